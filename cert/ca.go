@@ -3,9 +3,166 @@ package cert
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
 )
 
+// KeyType is the type of the key of generated leaf certificates.
+type KeyType int
+
+const (
+	// KeyTypeRSA2048 uses RSA keys, which every client supports.
+	KeyTypeRSA2048 KeyType = iota
+	// KeyTypeECDSAP256 uses ECDSA P-256 keys, which are faster to sign with and
+	// make smaller handshakes.
+	KeyTypeECDSAP256
+)
+
+func (kt KeyType) String() string {
+	switch kt {
+	case KeyTypeRSA2048:
+		return "RSA2048"
+	case KeyTypeECDSAP256:
+		return "ECDSA-P256"
+	}
+	return fmt.Sprintf("KeyType(%d)", int(kt))
+}
+
+// SANPolicy decides the subject alternative names of leaf certificates.
+type SANPolicy int
+
+const (
+	// SANExact names only the requested host or IP address.
+	SANExact SANPolicy = iota
+	// SANWildcard also covers the subdomains of a requested host name,
+	// e.g. *.example.com for example.com.
+	SANWildcard
+)
+
+// CertOptions are the parameters of generated leaf certificates.
+// Zero values keep the defaults.
+type CertOptions struct {
+	// Backdate is how far NotBefore lies in the past, to tolerate clients with slow
+	// clocks. Defaults to 48 hours.
+	Backdate time.Duration
+	// Validity is how long after generation a certificate expires. Defaults to one year.
+	// Certificates never outlive the CA that signs them.
+	Validity time.Duration
+	// RSAKeySize is the size of the RSA key shared by RSA leaf certificates, between 2048
+	// and 8192 bits. By default the leaves share the CA key.
+	RSAKeySize int
+	// SANPolicy decides the subject alternative names. Defaults to SANExact.
+	SANPolicy SANPolicy
+	// Organization is the organization in the subject. Defaults to "mitmproxy".
+	Organization string
+}
+
+const (
+	defaultBackdate     = 48 * time.Hour
+	defaultValidity     = 365 * 24 * time.Hour
+	defaultOrganization = "mitmproxy"
+)
+
+// Validate reports whether the options are usable.
+func (o CertOptions) Validate() error {
+	switch {
+	case o.Backdate < 0:
+		return fmt.Errorf("cert backdate %v is negative", o.Backdate)
+	case o.Validity < 0:
+		return fmt.Errorf("cert validity %v is negative", o.Validity)
+	case o.RSAKeySize != 0 && (o.RSAKeySize < 2048 || o.RSAKeySize > 8192):
+		return fmt.Errorf("rsa key size %d is not between 2048 and 8192", o.RSAKeySize)
+	case o.SANPolicy != SANExact && o.SANPolicy != SANWildcard:
+		return fmt.Errorf("unknown san policy %d", int(o.SANPolicy))
+	}
+	return nil
+}
+
+// template returns a leaf certificate template for commonName issued by issuer.
+func (o CertOptions) template(commonName string, issuer *x509.Certificate) *x509.Certificate {
+	backdate, validity, organization := o.Backdate, o.Validity, o.Organization
+	if backdate == 0 {
+		backdate = defaultBackdate
+	}
+	if validity == 0 {
+		validity = defaultValidity
+	}
+	if organization == "" {
+		organization = defaultOrganization
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano() / 100000),
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{organization},
+		},
+		NotBefore:   now.Add(-backdate),
+		NotAfter:    now.Add(validity),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if template.NotAfter.After(issuer.NotAfter) {
+		template.NotAfter = issuer.NotAfter
+	}
+
+	ip := net.ParseIP(commonName)
+	if ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{commonName}
+		if o.SANPolicy == SANWildcard {
+			template.DNSNames = append(template.DNSNames, "*."+commonName)
+		}
+	}
+	return template
+}
+
+// Config configures the generation and caching of leaf certificates.
+type Config struct {
+	KeyType KeyType // defaults to KeyTypeRSA2048
+
+	// CacheSize is the maximum number of cached leaf certificates; the least recently
+	// used ones are dropped first. Zero means 100 and a negative value means no limit.
+	CacheSize int
+	// CacheTTL is how long a cached leaf certificate is used. Zero means no expiry.
+	CacheTTL time.Duration
+
+	CertOptions CertOptions
+}
+
+// Validate reports whether the config is usable.
+func (c Config) Validate() error {
+	if c.KeyType != KeyTypeRSA2048 && c.KeyType != KeyTypeECDSAP256 {
+		return fmt.Errorf("unsupported leaf key type %v", c.KeyType)
+	}
+	return c.CertOptions.Validate()
+}
+
+func (c Config) cacheSize() int {
+	switch {
+	case c.CacheSize == 0:
+		return defaultCacheSize
+	case c.CacheSize < 0:
+		return 0
+	}
+	return c.CacheSize
+}
+
 type CA interface {
 	GetRootCA() *x509.Certificate
 	GetCert(commonName string) (*tls.Certificate, error)
 }
+
+// chainDER returns the DER certificates of a leaf followed by its chain.
+func chainDER(leaf []byte, chain []*x509.Certificate) [][]byte {
+	certs := make([][]byte, 0, len(chain)+1)
+	certs = append(certs, leaf)
+	for _, cert := range chain {
+		certs = append(certs, cert.Raw)
+	}
+	return certs
+}
@@ -0,0 +1,146 @@
+package cert
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/golang/groupcache/singleflight"
+)
+
+const defaultCacheSize = 100
+
+// CacheStats reports the usage of a leaf certificate cache.
+type CacheStats struct {
+	Entries   int    // certificates currently cached
+	Hits      uint64 // lookups answered from the cache
+	Misses    uint64 // lookups that generated a certificate
+	Evictions uint64 // certificates dropped to stay within the size or after the TTL
+}
+
+// leafCache caches generated leaf certificates by common name and makes sure
+// concurrent requests for the same name generate the certificate only once.
+type leafCache struct {
+	cache *lru.Cache
+	group *singleflight.Group
+	mu    sync.Mutex
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type leafCacheEntry struct {
+	cert    *tls.Certificate
+	created time.Time
+}
+
+func newLeafCache() *leafCache {
+	lc := &leafCache{group: new(singleflight.Group)}
+	lc.cache = lc.newLRU()
+	return lc
+}
+
+func (lc *leafCache) newLRU() *lru.Cache {
+	cache := lru.New(defaultCacheSize)
+	cache.OnEvicted = func(lru.Key, any) {
+		lc.evictions.Add(1)
+	}
+	return cache
+}
+
+// get returns the certificate for commonName from the cache, or the one create makes
+// for it. Concurrent lookups of a name that is not cached wait for a single create, and
+// a lookup that missed the cache right before the certificate was stored finds it in
+// the cache instead of creating another. Names differing only in case share a
+// certificate, as they are the same server name.
+func (lc *leafCache) get(config Config, commonName string, create func(commonName string) (*tls.Certificate, error)) (*tls.Certificate, error) {
+	key := strings.ToLower(commonName)
+	if cert, ok, err := lc.lookup(config, key); ok || err != nil {
+		if ok {
+			lc.hits.Add(1)
+			slog.Debug("ca GetCert", "commonName", commonName)
+		}
+		return cert, err
+	}
+
+	created := false
+	val, err := lc.group.Do(key, func() (any, error) {
+		// the certificate may have been stored since the lookup above
+		if cert, ok, err := lc.lookup(config, key); ok || err != nil {
+			return cert, err
+		}
+		created = true
+		cert, err := create(commonName)
+		if err == nil {
+			lc.mu.Lock()
+			lc.cache.MaxEntries = config.cacheSize()
+			lc.cache.Add(key, &leafCacheEntry{cert: cert, created: time.Now()})
+			for lc.cache.MaxEntries > 0 && lc.cache.Len() > lc.cache.MaxEntries {
+				lc.cache.RemoveOldest()
+			}
+			lc.mu.Unlock()
+		}
+		return cert, err
+	})
+	// the lookups waiting for the create of another one count as hits
+	if created {
+		lc.misses.Add(1)
+	} else {
+		lc.hits.Add(1)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cert, ok := val.(*tls.Certificate)
+	if !ok {
+		return nil, errors.New("generated value is not a tls.Certificate")
+	}
+	return cert, nil
+}
+
+// lookup returns the cached certificate for key, dropping it when it is older than the
+// TTL of config.
+func (lc *leafCache) lookup(config Config, key string) (*tls.Certificate, bool, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	val, ok := lc.cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	entry, ok := val.(*leafCacheEntry)
+	if !ok {
+		return nil, false, errors.New("cached value is not a leaf certificate")
+	}
+	if config.CacheTTL > 0 && time.Since(entry.created) >= config.CacheTTL {
+		lc.cache.Remove(key)
+		return nil, false, nil
+	}
+	return entry.cert, true, nil
+}
+
+// clear drops all cached certificates, e.g. after the signing CA changed.
+func (lc *leafCache) clear() {
+	lc.mu.Lock()
+	lc.cache = lc.newLRU()
+	lc.mu.Unlock()
+}
+
+func (lc *leafCache) stats() CacheStats {
+	lc.mu.Lock()
+	entries := lc.cache.Len()
+	lc.mu.Unlock()
+	return CacheStats{
+		Entries:   entries,
+		Hits:      lc.hits.Load(),
+		Misses:    lc.misses.Load(),
+		Evictions: lc.evictions.Load(),
+	}
+}
@@ -0,0 +1,114 @@
+// Justification for whitebox testing:
+// leafCache is unexported and the expiry, eviction and concurrency paths need a create
+// function that counts calls, which the public CA types do not allow to inject.
+
+package cert
+
+import (
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestLeafCache(t *testing.T) {
+	c := qt.New(t)
+
+	created := 0
+	create := func(string) (*tls.Certificate, error) {
+		created++
+		return &tls.Certificate{}, nil
+	}
+
+	lc := newLeafCache()
+	config := Config{CacheSize: 2}
+	first, err := lc.get(config, "a.example", create)
+	c.Assert(err, qt.IsNil)
+	again, err := lc.get(config, "a.example", create)
+	c.Assert(err, qt.IsNil)
+	c.Assert(again, qt.Equals, first)
+	c.Assert(created, qt.Equals, 1)
+
+	_, err = lc.get(config, "b.example", create)
+	c.Assert(err, qt.IsNil)
+	_, err = lc.get(config, "c.example", create)
+	c.Assert(err, qt.IsNil)
+	c.Assert(lc.stats(), qt.Equals, CacheStats{Entries: 2, Hits: 1, Misses: 3, Evictions: 1})
+
+	// a.example was evicted as the least recently used entry
+	_, err = lc.get(config, "a.example", create)
+	c.Assert(err, qt.IsNil)
+	c.Assert(created, qt.Equals, 4)
+
+	// shrinking the size drops the oldest entries on the next insert
+	_, err = lc.get(Config{CacheSize: 1}, "d.example", create)
+	c.Assert(err, qt.IsNil)
+	c.Assert(lc.stats().Entries, qt.Equals, 1)
+
+	lc.clear()
+	c.Assert(lc.stats().Entries, qt.Equals, 0)
+}
+
+func TestLeafCacheTTL(t *testing.T) {
+	c := qt.New(t)
+
+	created := 0
+	create := func(string) (*tls.Certificate, error) {
+		created++
+		return &tls.Certificate{}, nil
+	}
+
+	lc := newLeafCache()
+	config := Config{CacheTTL: 20 * time.Millisecond}
+	_, err := lc.get(config, "a.example", create)
+	c.Assert(err, qt.IsNil)
+	_, err = lc.get(config, "a.example", create)
+	c.Assert(err, qt.IsNil)
+	c.Assert(created, qt.Equals, 1)
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = lc.get(config, "a.example", create)
+	c.Assert(err, qt.IsNil)
+	c.Assert(created, qt.Equals, 2)
+	c.Assert(lc.stats(), qt.Equals, CacheStats{Entries: 1, Hits: 1, Misses: 2, Evictions: 1})
+}
+
+func TestLeafCacheCreatesOnce(t *testing.T) {
+	c := qt.New(t)
+
+	var created atomic.Int32
+	release := make(chan struct{})
+	create := func(string) (*tls.Certificate, error) {
+		created.Add(1)
+		<-release
+		return &tls.Certificate{}, nil
+	}
+
+	lc := newLeafCache()
+	names := []string{"a.example", "A.example", "a.EXAMPLE"}
+	certs := make(chan *tls.Certificate, 3*len(names))
+	var wg sync.WaitGroup
+	for i := range cap(certs) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cert, err := lc.get(Config{}, names[i%len(names)], create)
+			c.Check(err, qt.IsNil)
+			certs <- cert
+		}()
+	}
+	time.Sleep(20 * time.Millisecond) // let the lookups wait for the first create
+	close(release)
+	wg.Wait()
+	close(certs)
+
+	first := <-certs
+	for cert := range certs {
+		c.Assert(cert, qt.Equals, first)
+	}
+	c.Assert(created.Load(), qt.Equals, int32(1))
+	c.Assert(lc.stats(), qt.Equals, CacheStats{Entries: 1, Hits: 8, Misses: 1})
+}
@@ -0,0 +1,264 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ExternalCA signs leaf certificates with an existing CA, such as an enterprise
+// internal CA, instead of a generated one. The CA key may be RSA, ECDSA or Ed25519;
+// the leaf certificates share one RSA key generated when the CA is loaded.
+//
+// The signing CA may be an intermediate. Its certificates up to the root are then
+// served after every leaf, so clients that only trust the root accept the leaves.
+type ExternalCA struct {
+	// RootCert is the top certificate of the loaded chain.
+	RootCert x509.Certificate
+	// Chain holds the CA certificates served after every leaf, starting with the one
+	// that signs the leaves. A self-signed RootCert is not part of it, since clients
+	// already trust it.
+	Chain []*x509.Certificate
+
+	signer  crypto.Signer
+	leafKey *rsa.PrivateKey
+	leaves  *leafCache
+}
+
+// NewCAFromFiles loads a CA from a PEM certificate file and a PEM private key file.
+// Both may be the same file.
+func NewCAFromFiles(certPath, keyPath string) (CA, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewCAFromPEM(certPEM, keyPEM)
+}
+
+// NewCAFromPEM loads a CA from the CERTIFICATE blocks of certPEM and the first
+// private key block of keyPEM. PKCS#8, PKCS#1 and SEC 1 keys are accepted. Besides
+// the certificate of the key, certPEM may hold the intermediates up to the root.
+func NewCAFromPEM(certPEM, keyPEM []byte) (CA, error) {
+	var certs []*x509.Certificate
+	for data := certPEM; ; {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no CERTIFICATE found in ca certificate PEM")
+	}
+
+	keyDER := findPEMBlock(keyPEM, func(typ string) bool {
+		return typ == "PRIVATE KEY" || typ == "RSA PRIVATE KEY" || typ == "EC PRIVATE KEY"
+	})
+	if keyDER == nil {
+		return nil, errors.New("no PRIVATE KEY found in ca key PEM")
+	}
+	key, err := parsePrivateKey(keyDER)
+	if err != nil {
+		return nil, err
+	}
+
+	return newExternalCA(certs, key)
+}
+
+// NewCAFromPKCS12File loads a CA from a PKCS#12 file protected by password.
+func NewCAFromPKCS12File(path, password string) (CA, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewCAFromPKCS12(data, password)
+}
+
+// NewCAFromPKCS12 loads a CA from a PKCS#12 bundle holding the CA certificate, its
+// private key and optionally the intermediates up to the root.
+func NewCAFromPKCS12(data []byte, password string) (CA, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, err
+	}
+	return newExternalCA(append([]*x509.Certificate{cert}, caCerts...), key)
+}
+
+func newExternalCA(certs []*x509.Certificate, key any) (*ExternalCA, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ca private key type %T", key)
+	}
+	chain := buildChain(certs, signer)
+	if err := validateCA(chain[0], signer); err != nil {
+		return nil, err
+	}
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	root := chain[len(chain)-1]
+	if root.CheckSignatureFrom(root) == nil {
+		chain = chain[:len(chain)-1]
+	}
+
+	return &ExternalCA{
+		RootCert: *root,
+		Chain:    chain,
+		signer:   signer,
+		leafKey:  leafKey,
+		leaves:   newLeafCache(),
+	}, nil
+}
+
+// buildChain orders certs from the certificate of key up to the last issuer found
+// among them. Certificates outside the chain are dropped. Without a certificate of
+// key the chain starts with the first certificate.
+func buildChain(certs []*x509.Certificate, key crypto.Signer) []*x509.Certificate {
+	first := certs[0]
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); ok {
+		for _, cert := range certs {
+			if pub.Equal(cert.PublicKey) {
+				first = cert
+				break
+			}
+		}
+	}
+
+	chain := []*x509.Certificate{first}
+	for {
+		last := chain[len(chain)-1]
+		if last.CheckSignatureFrom(last) == nil {
+			return chain // reached a self-signed root
+		}
+		var issuer *x509.Certificate
+		for _, cert := range certs {
+			if !slices.Contains(chain, cert) && last.CheckSignatureFrom(cert) == nil {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			return chain
+		}
+		chain = append(chain, issuer)
+	}
+}
+
+// validateCA checks that cert is a CA certificate usable for signing with key.
+func validateCA(cert *x509.Certificate, key crypto.Signer) error {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return errors.New("ca private key does not match the certificate")
+	}
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return fmt.Errorf("certificate %q is not a CA certificate", cert.Subject.CommonName)
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("key usage of certificate %q does not allow signing certificates", cert.Subject.CommonName)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return fmt.Errorf("certificate %q expired at %v", cert.Subject.CommonName, cert.NotAfter)
+	}
+	return nil
+}
+
+func findPEMBlock(data []byte, match func(typ string) bool) *pem.Block {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		if match(block.Type) {
+			return block
+		}
+	}
+}
+
+func parsePrivateKey(block *pem.Block) (any, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported ca private key type %T", key)
+}
+
+func (ca *ExternalCA) GetRootCA() *x509.Certificate {
+	return &ca.RootCert
+}
+
+func (ca *ExternalCA) GetCert(commonName string) (*tls.Certificate, error) {
+	return ca.leaves.get(Config{}, commonName, ca.DummyCert)
+}
+
+// CacheStats reports the usage of the leaf certificate cache.
+func (ca *ExternalCA) CacheStats() CacheStats {
+	return ca.leaves.stats()
+}
+
+func (ca *ExternalCA) signingCert() *x509.Certificate {
+	if len(ca.Chain) > 0 {
+		return ca.Chain[0]
+	}
+	return &ca.RootCert
+}
+
+// DummyCert creates a leaf certificate for commonName signed by the CA.
+func (ca *ExternalCA) DummyCert(commonName string) (*tls.Certificate, error) {
+	slog.Debug("ca DummyCert", "commonName", commonName)
+	issuer := ca.signingCert()
+	template := CertOptions{}.template(commonName, issuer)
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, issuer, &ca.leafKey.PublicKey, ca.signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: chainDER(certBytes, ca.Chain),
+		PrivateKey:  ca.leafKey,
+	}, nil
+}
+
+// ExportPKCS12 returns the root certificate as a PKCS#12 bundle protected by password.
+func (ca *ExternalCA) ExportPKCS12(password string) ([]byte, error) {
+	return pkcs12.Legacy.EncodeTrustStore([]*x509.Certificate{&ca.RootCert}, password)
+}
@@ -0,0 +1,217 @@
+package cert_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"github.com/denisvmedia/go-mitmproxy/cert"
+)
+
+func newTestCA(c *qt.C, isCA bool, keyUsage x509.KeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, qt.IsNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Internal CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              keyUsage,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, qt.IsNil)
+	rootCert, err := x509.ParseCertificate(der)
+	c.Assert(err, qt.IsNil)
+	return rootCert, key
+}
+
+func encodePEM(c *qt.C, rootCert *x509.Certificate, key crypto.PrivateKey) ([]byte, []byte) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	c.Assert(err, qt.IsNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func assertLeafVerifies(c *qt.C, ca cert.CA, rootCert *x509.Certificate) {
+	c.Assert(ca.GetRootCA().Raw, qt.DeepEquals, rootCert.Raw)
+
+	leaf, err := ca.GetCert("example.com")
+	c.Assert(err, qt.IsNil)
+	leafCert, err := x509.ParseCertificate(leaf.Certificate[0])
+	c.Assert(err, qt.IsNil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+	_, err = leafCert.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots})
+	c.Assert(err, qt.IsNil)
+
+	cached, err := ca.GetCert("example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(cached, qt.Equals, leaf)
+}
+
+func TestNewCAFromFiles(t *testing.T) {
+	c := qt.New(t)
+	rootCert, key := newTestCA(c, true, x509.KeyUsageCertSign)
+	certPEM, keyPEM := encodePEM(c, rootCert, key)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+	c.Assert(os.WriteFile(certPath, certPEM, 0600), qt.IsNil)
+	c.Assert(os.WriteFile(keyPath, keyPEM, 0600), qt.IsNil)
+
+	ca, err := cert.NewCAFromFiles(certPath, keyPath)
+	c.Assert(err, qt.IsNil)
+	assertLeafVerifies(c, ca, rootCert)
+
+	// the key and the certificate may share one file
+	bundlePath := filepath.Join(dir, "bundle.pem")
+	c.Assert(os.WriteFile(bundlePath, append(keyPEM, certPEM...), 0600), qt.IsNil)
+	_, err = cert.NewCAFromFiles(bundlePath, bundlePath)
+	c.Assert(err, qt.IsNil)
+
+	_, err = cert.NewCAFromFiles(filepath.Join(dir, "missing.pem"), keyPath)
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestNewCAFromPEMValidation(t *testing.T) {
+	c := qt.New(t)
+
+	rootCert, key := newTestCA(c, true, x509.KeyUsageDigitalSignature)
+	certPEM, keyPEM := encodePEM(c, rootCert, key)
+	_, err := cert.NewCAFromPEM(certPEM, keyPEM)
+	c.Assert(err, qt.ErrorMatches, `key usage of certificate "Internal CA" does not allow signing certificates`)
+
+	rootCert, key = newTestCA(c, false, x509.KeyUsageCertSign)
+	certPEM, keyPEM = encodePEM(c, rootCert, key)
+	_, err = cert.NewCAFromPEM(certPEM, keyPEM)
+	c.Assert(err, qt.ErrorMatches, `certificate "Internal CA" is not a CA certificate`)
+
+	rootCert, _ = newTestCA(c, true, x509.KeyUsageCertSign)
+	_, otherKey := newTestCA(c, true, x509.KeyUsageCertSign)
+	certPEM, keyPEM = encodePEM(c, rootCert, otherKey)
+	_, err = cert.NewCAFromPEM(certPEM, keyPEM)
+	c.Assert(err, qt.ErrorMatches, "ca private key does not match the certificate")
+
+	_, err = cert.NewCAFromPEM(keyPEM, keyPEM)
+	c.Assert(err, qt.ErrorMatches, "no CERTIFICATE found in ca certificate PEM")
+}
+
+func TestNewCAFromPKCS12(t *testing.T) {
+	c := qt.New(t)
+	rootCert, key := newTestCA(c, true, x509.KeyUsageCertSign|x509.KeyUsageCRLSign)
+
+	data, err := pkcs12.Modern.Encode(key, rootCert, nil, "secret")
+	c.Assert(err, qt.IsNil)
+
+	ca, err := cert.NewCAFromPKCS12(data, "secret")
+	c.Assert(err, qt.IsNil)
+	assertLeafVerifies(c, ca, rootCert)
+
+	_, err = cert.NewCAFromPKCS12(data, "wrong")
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestExportPKCS12FromSelfSignCAKey(t *testing.T) {
+	c := qt.New(t)
+	caAPI, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	selfSignCA := caAPI.(*cert.SelfSignCA)
+
+	// a generated CA exported with its key can be loaded as an external CA
+	data, err := selfSignCA.ExportPKCS12WithKey("secret")
+	c.Assert(err, qt.IsNil)
+	ca, err := cert.NewCAFromPKCS12(data, "secret")
+	c.Assert(err, qt.IsNil)
+	assertLeafVerifies(c, ca, selfSignCA.GetRootCA())
+}
+
+func newTestIntermediate(c *qt.C, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, qt.IsNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Issuing CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 12),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	c.Assert(err, qt.IsNil)
+	intermediate, err := x509.ParseCertificate(der)
+	c.Assert(err, qt.IsNil)
+	return intermediate, key
+}
+
+// assertChainVerifies checks that a leaf validates for a client trusting only root,
+// using the intermediates served by GetCert.
+func assertChainVerifies(c *qt.C, ca cert.CA, root *x509.Certificate, chainLen int) {
+	leaf, err := ca.GetCert("example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(leaf.Certificate, qt.HasLen, chainLen)
+
+	leafCert, err := x509.ParseCertificate(leaf.Certificate[0])
+	c.Assert(err, qt.IsNil)
+	intermediates := x509.NewCertPool()
+	for _, der := range leaf.Certificate[1:] {
+		intermediate, err := x509.ParseCertificate(der)
+		c.Assert(err, qt.IsNil)
+		intermediates.AddCert(intermediate)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	_, err = leafCert.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots, Intermediates: intermediates})
+	c.Assert(err, qt.IsNil)
+}
+
+func TestNewCAFromPEMWithIntermediate(t *testing.T) {
+	c := qt.New(t)
+	rootCert, rootKey := newTestCA(c, true, x509.KeyUsageCertSign)
+	intermediate, key := newTestIntermediate(c, rootCert, rootKey)
+
+	// the root follows the intermediate, in any order
+	certPEM, keyPEM := encodePEM(c, intermediate, key)
+	rootPEM, _ := encodePEM(c, rootCert, rootKey)
+	ca, err := cert.NewCAFromPEM(append(rootPEM, certPEM...), keyPEM)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ca.GetRootCA().Raw, qt.DeepEquals, rootCert.Raw)
+	c.Assert(ca.(*cert.ExternalCA).Chain, qt.DeepEquals, []*x509.Certificate{intermediate})
+	assertChainVerifies(c, ca, rootCert, 2)
+
+	// without the root, the intermediate is still served
+	ca, err = cert.NewCAFromPEM(certPEM, keyPEM)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ca.GetRootCA().Raw, qt.DeepEquals, intermediate.Raw)
+	assertChainVerifies(c, ca, rootCert, 2)
+}
+
+func TestNewCAFromPKCS12WithIntermediate(t *testing.T) {
+	c := qt.New(t)
+	rootCert, rootKey := newTestCA(c, true, x509.KeyUsageCertSign)
+	intermediate, key := newTestIntermediate(c, rootCert, rootKey)
+
+	data, err := pkcs12.Modern.Encode(key, intermediate, []*x509.Certificate{rootCert}, "secret")
+	c.Assert(err, qt.IsNil)
+
+	ca, err := cert.NewCAFromPKCS12(data, "secret")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ca.GetRootCA().Raw, qt.DeepEquals, rootCert.Raw)
+	assertChainVerifies(c, ca, rootCert, 2)
+}
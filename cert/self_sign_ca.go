@@ -1,6 +1,9 @@
 package cert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -12,15 +15,13 @@ import (
 	"io"
 	"log/slog"
 	"math/big"
-	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang/groupcache/lru"
-	"github.com/golang/groupcache/singleflight"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 // reference
@@ -34,10 +35,23 @@ type SelfSignCA struct {
 	RootCert  x509.Certificate
 	StorePath string
 
-	cache *lru.Cache
-	group *singleflight.Group
+	// Chain holds the intermediate CA certificates served after every leaf, starting
+	// with the one that signs the leaves. It is empty when RootCert signs the leaves.
+	Chain []*x509.Certificate
+	// IntermediateKey is the private key of Chain[0].
+	IntermediateKey *rsa.PrivateKey
 
-	cacheMu sync.Mutex
+	// Config configures the leaf certificates. Change it before the CA is used.
+	Config Config
+
+	leaves *leafCache
+
+	ecdsaKeyOnce sync.Once
+	ecdsaKey     *ecdsa.PrivateKey
+	ecdsaKeyErr  error
+
+	rsaKeyMu sync.Mutex
+	rsaKey   *rsa.PrivateKey // leaf key of CertOptions.RSAKeySize
 }
 
 func createCert() (*rsa.PrivateKey, *x509.Certificate, error) {
@@ -92,11 +106,36 @@ func NewSelfSignCAMemory() (CA, error) {
 		PrivateKey: *key,
 		RootCert:   *cert,
 		StorePath:  "",
-		cache:      lru.New(100),
-		group:      new(singleflight.Group),
+		leaves:     newLeafCache(),
 	}, nil
 }
 
+// NewSelfSignCAMemoryWithConfig is like NewSelfSignCAMemory, with leaf certificates generated per config.
+func NewSelfSignCAMemoryWithConfig(config Config) (CA, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	ca, err := NewSelfSignCAMemory()
+	if err != nil {
+		return nil, err
+	}
+	ca.(*SelfSignCA).Config = config
+	return ca, nil
+}
+
+// NewSelfSignCAWithConfig is like NewSelfSignCA, with leaf certificates generated per config.
+func NewSelfSignCAWithConfig(path string, config Config) (CA, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	ca, err := NewSelfSignCA(path)
+	if err != nil {
+		return nil, err
+	}
+	ca.(*SelfSignCA).Config = config
+	return ca, nil
+}
+
 // NewSelfSignCA Load ca from store path or create new ca then store.
 func NewSelfSignCA(path string) (CA, error) {
 	storePath, err := getStorePath(path)
@@ -106,8 +145,7 @@ func NewSelfSignCA(path string) (CA, error) {
 
 	ca := &SelfSignCA{
 		StorePath: storePath,
-		cache:     lru.New(100),
-		group:     new(singleflight.Group),
+		leaves:    newLeafCache(),
 	}
 
 	err = ca.load()
@@ -176,32 +214,60 @@ func (ca *SelfSignCA) caCertCerFile() string {
 	return filepath.Join(ca.StorePath, "mitmproxy-ca-cert.cer")
 }
 
+// The intermediate certificate and its private key in PEM format.
+func (ca *SelfSignCA) intermediateFile() string {
+	return filepath.Join(ca.StorePath, "mitmproxy-ca-intermediate.pem")
+}
+
 func (ca *SelfSignCA) load() error {
-	caFile := ca.caFile()
-	stat, err := os.Stat(caFile)
+	key, cert, err := loadKeyAndCert(ca.caFile())
+	if err != nil {
+		return err
+	}
+	ca.PrivateKey = *key
+	ca.RootCert = *cert
+
+	key, cert, err = loadKeyAndCert(ca.intermediateFile())
+	if errors.Is(err, errCaNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignatureFrom(&ca.RootCert); err != nil {
+		return fmt.Errorf("intermediate ca is not signed by the root ca: %w", err)
+	}
+	ca.IntermediateKey = key
+	ca.Chain = []*x509.Certificate{cert}
+	return nil
+}
+
+// loadKeyAndCert reads a PEM file holding a private key followed by a certificate.
+func loadKeyAndCert(filename string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	stat, err := os.Stat(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return errCaNotFound
+			return nil, nil, errCaNotFound
 		}
-		return err
+		return nil, nil, err
 	}
 
 	if !stat.Mode().IsRegular() {
-		return fmt.Errorf("%v is not a file", caFile)
+		return nil, nil, fmt.Errorf("%v is not a file", filename)
 	}
 
-	data, err := os.ReadFile(caFile)
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	keyDERBlock, data := pem.Decode(data)
 	if keyDERBlock == nil {
-		return fmt.Errorf("PRIVATE KEY does not exist in %v", caFile)
+		return nil, nil, fmt.Errorf("PRIVATE KEY does not exist in %v", filename)
 	}
 	certDERBlock, _ := pem.Decode(data)
 	if certDERBlock == nil {
-		return fmt.Errorf("CERTIFICATE does not exist in %v", caFile)
+		return nil, nil, fmt.Errorf("CERTIFICATE does not exist in %v", filename)
 	}
 
 	var privateKey *rsa.PrivateKey
@@ -209,28 +275,26 @@ func (ca *SelfSignCA) load() error {
 	if err != nil {
 		// fix #14
 		if !strings.Contains(err.Error(), "use ParsePKCS1PrivateKey instead") {
-			return err
+			return nil, nil, err
 		}
 		privateKey, err = x509.ParsePKCS1PrivateKey(keyDERBlock.Bytes)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 	} else {
 		v, ok := key.(*rsa.PrivateKey)
 		if !ok {
-			return errors.New("found unknown rsa private key type in PKCS#8 wrapping")
+			return nil, nil, errors.New("found unknown rsa private key type in PKCS#8 wrapping")
 		}
 		privateKey = v
 	}
-	ca.PrivateKey = *privateKey
 
 	x509Cert, err := x509.ParseCertificate(certDERBlock.Bytes)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	ca.RootCert = *x509Cert
 
-	return nil
+	return privateKey, x509Cert, nil
 }
 
 func (ca *SelfSignCA) create() error {
@@ -249,7 +313,11 @@ func (ca *SelfSignCA) create() error {
 }
 
 func (ca *SelfSignCA) saveTo(out io.Writer) error {
-	keyBytes, err := x509.MarshalPKCS8PrivateKey(&ca.PrivateKey)
+	return saveKeyAndCertTo(out, &ca.PrivateKey, &ca.RootCert)
+}
+
+func saveKeyAndCertTo(out io.Writer, key *rsa.PrivateKey, cert *x509.Certificate) error {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
 	if err != nil {
 		return err
 	}
@@ -258,7 +326,7 @@ func (ca *SelfSignCA) saveTo(out io.Writer) error {
 		return err
 	}
 
-	return pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: ca.RootCert.Raw})
+	return pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
 }
 
 func (ca *SelfSignCA) saveCertTo(out io.Writer) error {
@@ -297,74 +365,141 @@ func (ca *SelfSignCA) saveCert() error {
 	return err
 }
 
+// CreateIntermediate creates an intermediate CA signed by the root CA and signs all
+// later leaf certificates with it, serving it in the chain of every leaf. Unless the
+// CA only lives in memory, the intermediate is stored next to the root CA and loaded
+// again by NewSelfSignCA.
+func (ca *SelfSignCA) CreateIntermediate() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano() / 100000),
+		Subject: pkix.Name{
+			CommonName:   "mitmproxy intermediate",
+			Organization: []string{"mitmproxy"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour * 48),
+		NotAfter:              ca.RootCert.NotAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SignatureAlgorithm:    x509.SHA256WithRSA,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, &ca.RootCert, &key.PublicKey, &ca.PrivateKey)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return err
+	}
+
+	if ca.StorePath != "" {
+		file, err := os.Create(ca.intermediateFile())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := saveKeyAndCertTo(file, key, cert); err != nil {
+			return err
+		}
+	}
+
+	ca.IntermediateKey = key
+	ca.Chain = []*x509.Certificate{cert}
+	ca.leaves.clear()
+	return nil
+}
+
+// ExportPKCS12 returns the root certificate as a PKCS#12 bundle protected by password.
+// The bundle uses legacy encryption, since iOS and older Android releases refuse the modern algorithms.
+func (ca *SelfSignCA) ExportPKCS12(password string) ([]byte, error) {
+	return pkcs12.Legacy.EncodeTrustStore([]*x509.Certificate{&ca.RootCert}, password)
+}
+
+// ExportPKCS12WithKey is like ExportPKCS12, but the bundle also carries the CA private key.
+func (ca *SelfSignCA) ExportPKCS12WithKey(password string) ([]byte, error) {
+	return pkcs12.Legacy.Encode(&ca.PrivateKey, &ca.RootCert, nil, password)
+}
+
 func (ca *SelfSignCA) GetRootCA() *x509.Certificate {
 	return &ca.RootCert
 }
 
 func (ca *SelfSignCA) GetCert(commonName string) (*tls.Certificate, error) {
-	ca.cacheMu.Lock()
-	if val, ok := ca.cache.Get(commonName); ok {
-		ca.cacheMu.Unlock()
-		slog.Debug("ca GetCert", "commonName", commonName)
-		cert, ok := val.(*tls.Certificate)
-		if !ok {
-			return nil, errors.New("cached value is not a tls.Certificate")
-		}
-		return cert, nil
-	}
-	ca.cacheMu.Unlock()
-
-	val, err := ca.group.Do(commonName, func() (any, error) {
-		cert, err := ca.DummyCert(commonName)
-		if err == nil {
-			ca.cacheMu.Lock()
-			ca.cache.Add(commonName, cert)
-			ca.cacheMu.Unlock()
-		}
-		return cert, err
-	})
+	return ca.leaves.get(ca.Config, commonName, ca.DummyCert)
+}
 
-	if err != nil {
-		return nil, err
-	}
+// CacheStats reports the usage of the leaf certificate cache.
+func (ca *SelfSignCA) CacheStats() CacheStats {
+	return ca.leaves.stats()
+}
 
-	cert, ok := val.(*tls.Certificate)
-	if !ok {
-		return nil, errors.New("generated value is not a tls.Certificate")
+// leafKey returns the key shared by all leaf certificates of the configured key type.
+// RSA leaves reuse the CA key unless CertOptions.RSAKeySize is set; other keys are
+// generated on first use.
+func (ca *SelfSignCA) leafKey() (crypto.Signer, crypto.PublicKey, error) {
+	switch ca.Config.KeyType {
+	case KeyTypeRSA2048:
+		size := ca.Config.CertOptions.RSAKeySize
+		if size == 0 {
+			return &ca.PrivateKey, &ca.PrivateKey.PublicKey, nil
+		}
+		ca.rsaKeyMu.Lock()
+		defer ca.rsaKeyMu.Unlock()
+		if ca.rsaKey == nil || ca.rsaKey.N.BitLen() != size {
+			key, err := rsa.GenerateKey(rand.Reader, size)
+			if err != nil {
+				return nil, nil, err
+			}
+			ca.rsaKey = key
+		}
+		return ca.rsaKey, &ca.rsaKey.PublicKey, nil
+	case KeyTypeECDSAP256:
+		ca.ecdsaKeyOnce.Do(func() {
+			ca.ecdsaKey, ca.ecdsaKeyErr = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		})
+		if ca.ecdsaKeyErr != nil {
+			return nil, nil, ca.ecdsaKeyErr
+		}
+		return ca.ecdsaKey, &ca.ecdsaKey.PublicKey, nil
 	}
-	return cert, nil
+	return nil, nil, fmt.Errorf("unsupported leaf key type %v", ca.Config.KeyType)
 }
 
 // TODO: Should we support multiple SubjectAltNames.
 func (ca *SelfSignCA) DummyCert(commonName string) (*tls.Certificate, error) {
 	slog.Debug("ca DummyCert", "commonName", commonName)
-	template := &x509.Certificate{
-		SerialNumber: big.NewInt(time.Now().UnixNano() / 100000),
-		Subject: pkix.Name{
-			CommonName:   commonName,
-			Organization: []string{"mitmproxy"},
-		},
-		NotBefore:          time.Now().Add(-time.Hour * 48),
-		NotAfter:           time.Now().Add(time.Hour * 24 * 365),
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	if err := ca.Config.Validate(); err != nil {
+		return nil, err
 	}
 
-	ip := net.ParseIP(commonName)
-	if ip != nil {
-		template.IPAddresses = []net.IP{ip}
-	} else {
-		template.DNSNames = []string{commonName}
+	issuer, signer := &ca.RootCert, &ca.PrivateKey
+	if len(ca.Chain) > 0 {
+		issuer, signer = ca.Chain[0], ca.IntermediateKey
+	}
+
+	template := ca.Config.CertOptions.template(commonName, issuer)
+	template.SignatureAlgorithm = x509.SHA256WithRSA
+
+	leafKey, leafPub, err := ca.leafKey()
+	if err != nil {
+		return nil, err
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, &ca.RootCert, &ca.PrivateKey.PublicKey, &ca.PrivateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, issuer, leafPub, signer)
 	if err != nil {
 		return nil, err
 	}
 
 	cert := &tls.Certificate{
-		Certificate: [][]byte{certBytes},
-		PrivateKey:  &ca.PrivateKey,
+		Certificate: chainDER(certBytes, ca.Chain),
+		PrivateKey:  leafKey,
 	}
 
 	return cert, nil
@@ -1,9 +1,16 @@
 package cert_test
 
 import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
+	"software.sslmate.com/src/go-pkcs12"
 
 	"github.com/denisvmedia/go-mitmproxy/cert"
 )
@@ -14,3 +21,193 @@ func TestNewCA(t *testing.T) {
 	c.Assert(err, qt.IsNil)
 	c.Assert(ca, qt.IsNotNil)
 }
+
+func TestExportPKCS12(t *testing.T) {
+	c := qt.New(t)
+	caAPI, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	ca := caAPI.(*cert.SelfSignCA)
+
+	data, err := ca.ExportPKCS12("secret")
+	c.Assert(err, qt.IsNil)
+
+	certs, err := pkcs12.DecodeTrustStore(data, "secret")
+	c.Assert(err, qt.IsNil)
+	c.Assert(certs, qt.HasLen, 1)
+	c.Assert(certs[0].Raw, qt.DeepEquals, ca.GetRootCA().Raw)
+
+	_, err = pkcs12.DecodeTrustStore(data, "wrong")
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestExportPKCS12WithKey(t *testing.T) {
+	c := qt.New(t)
+	caAPI, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	ca := caAPI.(*cert.SelfSignCA)
+
+	data, err := ca.ExportPKCS12WithKey("secret")
+	c.Assert(err, qt.IsNil)
+
+	key, rootCert, err := pkcs12.Decode(data, "secret")
+	c.Assert(err, qt.IsNil)
+	c.Assert(rootCert.Raw, qt.DeepEquals, ca.GetRootCA().Raw)
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(rsaKey.Equal(&ca.PrivateKey), qt.IsTrue)
+}
+
+func TestCreateIntermediate(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+	caAPI, err := cert.NewSelfSignCA(dir)
+	c.Assert(err, qt.IsNil)
+	ca := caAPI.(*cert.SelfSignCA)
+
+	before, err := ca.GetCert("example.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(before.Certificate, qt.HasLen, 1)
+
+	c.Assert(ca.CreateIntermediate(), qt.IsNil)
+	c.Assert(ca.Chain, qt.HasLen, 1)
+	assertChainVerifies(c, ca, ca.GetRootCA(), 2)
+
+	// the intermediate is loaded again from the store path
+	reloadedAPI, err := cert.NewSelfSignCA(dir)
+	c.Assert(err, qt.IsNil)
+	reloaded := reloadedAPI.(*cert.SelfSignCA)
+	c.Assert(reloaded.Chain, qt.HasLen, 1)
+	c.Assert(reloaded.Chain[0].Raw, qt.DeepEquals, ca.Chain[0].Raw)
+	assertChainVerifies(c, reloaded, ca.GetRootCA(), 2)
+}
+
+func TestLeafKeyType(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  cert.Config
+		wantAlg x509.PublicKeyAlgorithm
+	}{
+		{name: "default is RSA", config: cert.Config{}, wantAlg: x509.RSA},
+		{name: "ECDSA P-256", config: cert.Config{KeyType: cert.KeyTypeECDSAP256}, wantAlg: x509.ECDSA},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			ca, err := cert.NewSelfSignCAMemoryWithConfig(tc.config)
+			c.Assert(err, qt.IsNil)
+
+			leaf, err := ca.GetCert("example.com")
+			c.Assert(err, qt.IsNil)
+			leafCert, err := x509.ParseCertificate(leaf.Certificate[0])
+			c.Assert(err, qt.IsNil)
+			c.Assert(leafCert.PublicKeyAlgorithm, qt.Equals, tc.wantAlg)
+
+			// the private key belongs to the certificate
+			_, err = tls.X509KeyPair(
+				pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Certificate[0]}),
+				encodeKeyPEM(c, leaf.PrivateKey),
+			)
+			c.Assert(err, qt.IsNil)
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.GetRootCA())
+			_, err = leafCert.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots})
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func encodeKeyPEM(c *qt.C, key crypto.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	c.Assert(err, qt.IsNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestCacheStats(t *testing.T) {
+	c := qt.New(t)
+	caAPI, err := cert.NewSelfSignCAMemoryWithConfig(cert.Config{CacheSize: 1})
+	c.Assert(err, qt.IsNil)
+	ca := caAPI.(*cert.SelfSignCA)
+
+	for _, name := range []string{"a.example", "a.example", "b.example"} {
+		_, err := ca.GetCert(name)
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(ca.CacheStats(), qt.Equals, cert.CacheStats{Entries: 1, Hits: 1, Misses: 2, Evictions: 1})
+}
+
+func TestCertOptions(t *testing.T) {
+	c := qt.New(t)
+	caAPI, err := cert.NewSelfSignCAMemoryWithConfig(cert.Config{CertOptions: cert.CertOptions{
+		Backdate:     time.Hour,
+		Validity:     30 * 24 * time.Hour,
+		RSAKeySize:   3072,
+		SANPolicy:    cert.SANWildcard,
+		Organization: "Example Corp",
+	}})
+	c.Assert(err, qt.IsNil)
+	ca := caAPI.(*cert.SelfSignCA)
+
+	before := time.Now()
+	leaf, err := ca.GetCert("example.com")
+	c.Assert(err, qt.IsNil)
+	leafCert, err := x509.ParseCertificate(leaf.Certificate[0])
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(leafCert.Subject.Organization, qt.DeepEquals, []string{"Example Corp"})
+	c.Assert(leafCert.DNSNames, qt.DeepEquals, []string{"example.com", "*.example.com"})
+	c.Assert(leafCert.NotBefore.Before(before.Add(-time.Hour+time.Minute)), qt.IsTrue)
+	c.Assert(leafCert.NotBefore.After(before.Add(-time.Hour-time.Minute)), qt.IsTrue)
+	c.Assert(leafCert.NotAfter.Sub(leafCert.NotBefore).Round(time.Hour), qt.Equals, 30*24*time.Hour+time.Hour)
+	c.Assert(leafCert.PublicKey.(*rsa.PublicKey).N.BitLen(), qt.Equals, 3072)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.GetRootCA())
+	_, err = leafCert.Verify(x509.VerifyOptions{DNSName: "www.example.com", Roots: roots})
+	c.Assert(err, qt.IsNil)
+
+	// IP addresses get no wildcard
+	leaf, err = ca.GetCert("127.0.0.1")
+	c.Assert(err, qt.IsNil)
+	leafCert, err = x509.ParseCertificate(leaf.Certificate[0])
+	c.Assert(err, qt.IsNil)
+	c.Assert(leafCert.DNSNames, qt.HasLen, 0)
+	c.Assert(leafCert.IPAddresses, qt.HasLen, 1)
+}
+
+func TestCertOptionsValidityCappedByCA(t *testing.T) {
+	c := qt.New(t)
+	caAPI, err := cert.NewSelfSignCAMemoryWithConfig(cert.Config{CertOptions: cert.CertOptions{
+		Validity: 10 * 365 * 24 * time.Hour,
+	}})
+	c.Assert(err, qt.IsNil)
+
+	leaf, err := caAPI.GetCert("example.com")
+	c.Assert(err, qt.IsNil)
+	leafCert, err := x509.ParseCertificate(leaf.Certificate[0])
+	c.Assert(err, qt.IsNil)
+	c.Assert(leafCert.NotAfter.After(caAPI.GetRootCA().NotAfter), qt.IsFalse)
+}
+
+func TestCertOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options cert.CertOptions
+		wantErr string
+	}{
+		{name: "negative backdate", options: cert.CertOptions{Backdate: -time.Hour}, wantErr: `cert backdate -1h0m0s is negative`},
+		{name: "negative validity", options: cert.CertOptions{Validity: -time.Hour}, wantErr: `cert validity -1h0m0s is negative`},
+		{name: "small key", options: cert.CertOptions{RSAKeySize: 1024}, wantErr: `rsa key size 1024 is not between 2048 and 8192`},
+		{name: "large key", options: cert.CertOptions{RSAKeySize: 16384}, wantErr: `rsa key size 16384 is not between 2048 and 8192`},
+		{name: "unknown san policy", options: cert.CertOptions{SANPolicy: 7}, wantErr: `unknown san policy 7`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			_, err := cert.NewSelfSignCAMemoryWithConfig(cert.Config{CertOptions: tc.options})
+			c.Assert(err, qt.ErrorMatches, tc.wantErr)
+		})
+	}
+}
@@ -49,13 +49,15 @@ func main() {
 	}
 
 	fmt.Fprintf(os.Stdout, "%v-cert.pem\n", config.commonName)
-	err = pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: tlsCert.Certificate[0]})
-	if err != nil {
-		panic(err)
+	for _, der := range tlsCert.Certificate {
+		err = pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if err != nil {
+			panic(err)
+		}
 	}
 	fmt.Fprintf(os.Stdout, "\n%v-key.pem\n", config.commonName)
 
-	keyBytes, err := x509.MarshalPKCS8PrivateKey(&selfSignCA.PrivateKey)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(tlsCert.PrivateKey)
 	if err != nil {
 		panic(err)
 	}
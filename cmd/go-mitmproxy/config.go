@@ -3,9 +3,11 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 
 	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
 )
 
 func loadConfigFromFile(filename string) (*Config, error) {
@@ -21,20 +23,77 @@ func loadConfigFromCli() *Config {
 
 	flag.BoolVar(&config.version, "version", false, "show go-mitmproxy version")
 	flag.StringVar(&config.Addr, "addr", ":9080", "proxy listen addr")
-	flag.StringVar(&config.WebAddr, "web_addr", ":9081", "web interface listen addr")
+	flag.Var((*arrayValue)(&config.ExtraAddrs), "extra_addr", "another proxy listen addr, e.g. [::1]:9080, can be repeated")
+	flag.StringVar(&config.Network, "network", "", `network of the proxy listen addrs, "unix" to listen on socket files (default "tcp")`)
+	flag.BoolVar(&config.SystemdActivation, "systemd_activation", false, "serve the listening sockets passed by systemd socket activation instead of addr, if any")
+	flag.StringVar(&config.ProxyCert, "proxy_cert", "", "certificate PEM file the proxy listens with TLS with, as an HTTPS (secure web) proxy")
+	flag.StringVar(&config.ProxyKey, "proxy_key", "", "private key PEM file of proxy_cert, defaults to proxy_cert")
+	flag.Var((*arrayValue)(&config.AllowClients), "allow_clients", "a CIDR or IP of the only clients allowed to connect, can be repeated")
+	flag.Var((*arrayValue)(&config.DenyClients), "deny_clients", "a CIDR or IP of clients not allowed to connect, can be repeated")
+	flag.IntVar(&config.MaxConns, "max_conns", 0, "maximum number of client connections open at the same time, unlimited if 0")
+	flag.IntVar(&config.MaxConnsPerClient, "max_conns_per_client", 0, "maximum number of connections open at the same time by one client IP, unlimited if 0")
+	flag.IntVar(&config.IdleConnsPerHost, "max_idle_conns_per_host", 0, "idle upstream connections kept per host for rewritten hosts, 32 if 0")
+	flag.Int64Var(&config.SpoolBodies, "spool_bodies", 0, "spool bodies larger than 5 MB, up to this many bytes, to temporary files for addons to see them instead of streaming them")
+	flag.StringVar(&config.WebAddr, "web_addr", "127.0.0.1:9081", "web interface listen addr, use :9081 to accept remote connections")
+	flag.StringVar(&config.WebAuth, "web_auth", "", `require basic authentication for the web interface. Format: "username:pass"`)
+	flag.StringVar(&config.WebToken, "web_token", "", "require a bearer token for the web interface, also accepted as ?token=")
+	flag.BoolVar(&config.WebTLS, "web_tls", false, "serve the web interface over HTTPS with certificates signed by the CA")
+	flag.StringVar(&config.WebCert, "web_cert", "", "certificate PEM file serving the web interface over HTTPS, implies web_tls")
+	flag.StringVar(&config.WebKey, "web_key", "", "private key PEM file of web_cert, defaults to web_cert")
+	flag.StringVar(&config.WebStore, "web_store", "", "bbolt database filename keeping the flows of the web interface across restarts")
+	flag.IntVar(&config.WebMaxFlows, "web_max_flows", 0, "maximum number of flows kept by the web interface, 1000 or 100000 with web_store if 0")
+	flag.IntVar(&config.WebMaxMemoryMB, "web_max_memory_mb", 0, "maximum size in MB of the bodies kept in memory by the web interface, evicting the least recently used flows")
+	flag.IntVar(&config.WebMaxBodyKB, "web_max_body_kb", 0, "truncate the bodies larger than this many KB in the web interface")
 	flag.BoolVar(&config.InsecureSkipVerify, "ssl_insecure", false, "not verify upstream server SSL/TLS certificates.")
 	flag.Var((*arrayValue)(&config.IgnoreHosts), "ignore_hosts", "a list of ignore hosts")
 	flag.Var((*arrayValue)(&config.AllowHosts), "allow_hosts", "a list of allow hosts")
 	flag.StringVar(&config.CertPath, "cert_path", "", "path of generate cert files")
+	flag.StringVar(&config.CACert, "ca_cert", "", "existing CA certificate PEM file to sign with instead of the generated CA")
+	flag.StringVar(&config.CAKey, "ca_key", "", "private key PEM file of ca_cert, defaults to ca_cert")
+	flag.StringVar(&config.CAP12, "ca_p12", "", "existing CA as PKCS#12 file to sign with instead of the generated CA")
+	flag.StringVar(&config.CAP12Password, "ca_p12_password", "", "password of the ca_p12 file")
 	flag.IntVar(&config.Debug, "debug", 0, "debug mode: 1 - print debug log, 2 - show debug from")
 	flag.StringVar(&config.Dump, "dump", "", "dump filename")
 	flag.IntVar(&config.DumpLevel, "dump_level", 0, "dump level: 0 - header, 1 - header + body")
-	flag.StringVar(&config.Upstream, "upstream", "", "upstream proxy")
+	flag.StringVar(&config.JSONLDump, "jsonl_dump", "", "write one JSON object per flow to the filename, with bodies if dump_level is 1")
+	flag.StringVar(&config.SaveStreamFile, "save_stream_file", "", "save flows in the mitmproxy flow format (.flow) to the filename")
+	flag.BoolVar(&config.Metrics, "metrics", false, "serve Prometheus metrics at /metrics of the proxy addr")
+	flag.StringVar(&config.MetricsAddr, "metrics_addr", "", "also serve Prometheus metrics on the listen addr, implies metrics")
+	flag.StringVar(&config.PCAPFile, "pcap_file", "", "write decrypted flows to the filename as pcapng, for Wireshark")
+	flag.StringVar(&config.WireCapture, "wire_capture", "", "write the exact bytes read from and written to client and server connections to the filename")
+	flag.StringVar(&config.LoadTestScript, "load_test_script", "", "write the recorded requests as a load test script to the filename")
+	flag.StringVar(&config.LoadTestFormat, "load_test_format", "", "format of load_test_script: k6 (default) or vegeta")
+	flag.StringVar(&config.PostmanCollection, "postman_collection", "", "aggregate flows into a Postman v2.1 collection written to the filename")
+	flag.StringVar(&config.Upstream, "upstream", "", "upstream proxy URL, with the http, https, socks5 or socks5h scheme, or comma-separated URLs of proxies to chain")
+	flag.StringVar(&config.Resolver, "resolver", "", "comma-separated DNS servers, or the https URL of a DNS-over-HTTPS server, to look up hosts with")
+	flag.StringVar(&config.OutboundAddr, "outbound_addr", "", "local IP address to bind the connections to servers and upstream proxies to, to choose the egress interface")
 	flag.BoolVar(&config.UpstreamCert, "upstream_cert", true, "connect to upstream server to look up certificate details")
 	flag.StringVar(&config.MapRemote, "map_remote", "", "map remote config filename")
 	flag.StringVar(&config.MapLocal, "map_local", "", "map local config filename")
+	flag.StringVar(&config.HeaderRewrite, "header_rewrite", "", "header rewrite config filename")
+	flag.StringVar(&config.BodyRewrite, "body_rewrite", "", "body rewrite config filename")
+	flag.StringVar(&config.BlockList, "block_list", "", "block list config filename")
+	flag.StringVar(&config.CORS, "cors", "", "CORS config filename, to allow cross-origin requests to APIs")
+	flag.StringVar(&config.Script, "script", "", "Starlark script filename")
+	flag.StringVar(&config.JSScript, "js_script", "", "JavaScript script filename exporting addon hooks")
+	flag.StringVar(&config.ExecHook, "exec_hook", "", "exec hook config filename, to change flows with external programs")
+	flag.Var((*arrayValue)(&config.RemoteAddons), "remote_addon", "the gRPC address of a remote addon server, can be repeated")
+	flag.BoolVar(&config.WatchConfig, "watch_config", false, "reload the map_remote, map_local, header_rewrite, body_rewrite, block_list, throttle and script files and reopen the dump file when they change")
+	flag.Var((*arrayValue)(&config.WasmPlugins), "wasm_plugin", "a WebAssembly plugin filename, can be repeated")
+	flag.BoolVar(&config.AntiCache, "anticache", false, "strip caching headers so clients always fetch full responses")
+	flag.BoolVar(&config.Cache, "cache", false, "cache responses in memory, as a shared HTTP cache")
+	flag.StringVar(&config.ClientReplay, "client_replay", "", "replay the requests recorded in the flow, JSONL or HAR filename at startup")
+	flag.StringVar(&config.ServerReplay, "server_replay", "", "answer requests with the responses recorded in the flow, JSONL or HAR filename")
+	flag.Var((*arrayValue)(&config.ServerReplayParams), "server_replay_ignore_param", "a query parameter ignored by server_replay, can be repeated")
+	flag.Var((*arrayValue)(&config.ServerReplayHeader), "server_replay_use_header", "a request header that must match for server_replay, can be repeated")
+	flag.BoolVar(&config.ServerReplayKill, "server_replay_kill_extra", false, "answer requests server_replay has no response to with a 404")
+	flag.BoolVar(&config.StickyCookies, "sticky_cookies", false, "keep the cookies set by servers per client IP and send them on later requests")
+	flag.StringVar(&config.Throttle, "throttle", "", "throttle config filename, to simulate slow networks")
 	flag.StringVar(&config.LogFile, "log_file", "", "log file path")
 	flag.StringVar(&config.filename, "f", "", "read config from the filename")
+	flag.BoolVar(&config.validate, "validate", false, "validate the config file and the addon config files, then exit")
+	flag.StringVar(&config.exportP12, "export_p12", "", "export the CA certificate as PKCS#12 (.p12) to the filename and exit")
+	flag.StringVar(&config.p12Password, "p12_password", "", "password of the exported PKCS#12 file")
 
 	flag.StringVar(&config.ProxyAuth, "proxyauth", "", `enable proxy authentication. Format: "username:pass", "user1:pass1|user2:pass2","any" to accept any user/pass combination`)
 	flag.Parse() //revive:disable-line:deep-exit -- ok for cmd/*
@@ -48,9 +107,69 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.Addr != "" {
 		config.Addr = cliConfig.Addr
 	}
+	if len(cliConfig.ExtraAddrs) > 0 {
+		config.ExtraAddrs = cliConfig.ExtraAddrs
+	}
+	if cliConfig.Network != "" {
+		config.Network = cliConfig.Network
+	}
+	if cliConfig.SystemdActivation {
+		config.SystemdActivation = cliConfig.SystemdActivation
+	}
+	if cliConfig.ProxyCert != "" {
+		config.ProxyCert = cliConfig.ProxyCert
+	}
+	if cliConfig.ProxyKey != "" {
+		config.ProxyKey = cliConfig.ProxyKey
+	}
+	if len(cliConfig.AllowClients) > 0 {
+		config.AllowClients = cliConfig.AllowClients
+	}
+	if len(cliConfig.DenyClients) > 0 {
+		config.DenyClients = cliConfig.DenyClients
+	}
+	if cliConfig.MaxConns != 0 {
+		config.MaxConns = cliConfig.MaxConns
+	}
+	if cliConfig.MaxConnsPerClient != 0 {
+		config.MaxConnsPerClient = cliConfig.MaxConnsPerClient
+	}
+	if cliConfig.IdleConnsPerHost != 0 {
+		config.IdleConnsPerHost = cliConfig.IdleConnsPerHost
+	}
+	if cliConfig.SpoolBodies != 0 {
+		config.SpoolBodies = cliConfig.SpoolBodies
+	}
 	if cliConfig.WebAddr != "" {
 		config.WebAddr = cliConfig.WebAddr
 	}
+	if cliConfig.WebAuth != "" {
+		config.WebAuth = cliConfig.WebAuth
+	}
+	if cliConfig.WebToken != "" {
+		config.WebToken = cliConfig.WebToken
+	}
+	if cliConfig.WebTLS {
+		config.WebTLS = cliConfig.WebTLS
+	}
+	if cliConfig.WebCert != "" {
+		config.WebCert = cliConfig.WebCert
+	}
+	if cliConfig.WebKey != "" {
+		config.WebKey = cliConfig.WebKey
+	}
+	if cliConfig.WebStore != "" {
+		config.WebStore = cliConfig.WebStore
+	}
+	if cliConfig.WebMaxFlows != 0 {
+		config.WebMaxFlows = cliConfig.WebMaxFlows
+	}
+	if cliConfig.WebMaxMemoryMB != 0 {
+		config.WebMaxMemoryMB = cliConfig.WebMaxMemoryMB
+	}
+	if cliConfig.WebMaxBodyKB != 0 {
+		config.WebMaxBodyKB = cliConfig.WebMaxBodyKB
+	}
 	if cliConfig.InsecureSkipVerify {
 		config.InsecureSkipVerify = cliConfig.InsecureSkipVerify
 	}
@@ -63,6 +182,18 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.CertPath != "" {
 		config.CertPath = cliConfig.CertPath
 	}
+	if cliConfig.CACert != "" {
+		config.CACert = cliConfig.CACert
+	}
+	if cliConfig.CAKey != "" {
+		config.CAKey = cliConfig.CAKey
+	}
+	if cliConfig.CAP12 != "" {
+		config.CAP12 = cliConfig.CAP12
+	}
+	if cliConfig.CAP12Password != "" {
+		config.CAP12Password = cliConfig.CAP12Password
+	}
 	if cliConfig.Debug != 0 {
 		config.Debug = cliConfig.Debug
 	}
@@ -72,9 +203,42 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.DumpLevel != 0 {
 		config.DumpLevel = cliConfig.DumpLevel
 	}
+	if cliConfig.JSONLDump != "" {
+		config.JSONLDump = cliConfig.JSONLDump
+	}
+	if cliConfig.LoadTestScript != "" {
+		config.LoadTestScript = cliConfig.LoadTestScript
+	}
+	if cliConfig.LoadTestFormat != "" {
+		config.LoadTestFormat = cliConfig.LoadTestFormat
+	}
+	if cliConfig.Metrics {
+		config.Metrics = cliConfig.Metrics
+	}
+	if cliConfig.MetricsAddr != "" {
+		config.MetricsAddr = cliConfig.MetricsAddr
+	}
+	if cliConfig.PCAPFile != "" {
+		config.PCAPFile = cliConfig.PCAPFile
+	}
+	if cliConfig.WireCapture != "" {
+		config.WireCapture = cliConfig.WireCapture
+	}
+	if cliConfig.PostmanCollection != "" {
+		config.PostmanCollection = cliConfig.PostmanCollection
+	}
+	if cliConfig.SaveStreamFile != "" {
+		config.SaveStreamFile = cliConfig.SaveStreamFile
+	}
 	if cliConfig.Upstream != "" {
 		config.Upstream = cliConfig.Upstream
 	}
+	if cliConfig.Resolver != "" {
+		config.Resolver = cliConfig.Resolver
+	}
+	if cliConfig.OutboundAddr != "" {
+		config.OutboundAddr = cliConfig.OutboundAddr
+	}
 	if !cliConfig.UpstreamCert {
 		config.UpstreamCert = cliConfig.UpstreamCert
 	}
@@ -84,9 +248,70 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.MapLocal != "" {
 		config.MapLocal = cliConfig.MapLocal
 	}
+	if cliConfig.HeaderRewrite != "" {
+		config.HeaderRewrite = cliConfig.HeaderRewrite
+	}
+	if cliConfig.BodyRewrite != "" {
+		config.BodyRewrite = cliConfig.BodyRewrite
+	}
+	if cliConfig.BlockList != "" {
+		config.BlockList = cliConfig.BlockList
+	}
+	if cliConfig.CORS != "" {
+		config.CORS = cliConfig.CORS
+	}
+	if cliConfig.Script != "" {
+		config.Script = cliConfig.Script
+	}
+	if cliConfig.JSScript != "" {
+		config.JSScript = cliConfig.JSScript
+	}
+	if cliConfig.ExecHook != "" {
+		config.ExecHook = cliConfig.ExecHook
+	}
+	if len(cliConfig.RemoteAddons) > 0 {
+		config.RemoteAddons = cliConfig.RemoteAddons
+	}
+	if cliConfig.WatchConfig {
+		config.WatchConfig = cliConfig.WatchConfig
+	}
+	if len(cliConfig.WasmPlugins) > 0 {
+		config.WasmPlugins = cliConfig.WasmPlugins
+	}
+	if cliConfig.AntiCache {
+		config.AntiCache = cliConfig.AntiCache
+	}
+	if cliConfig.Cache {
+		config.Cache = cliConfig.Cache
+	}
+	if cliConfig.ClientReplay != "" {
+		config.ClientReplay = cliConfig.ClientReplay
+	}
+	if cliConfig.ServerReplay != "" {
+		config.ServerReplay = cliConfig.ServerReplay
+	}
+	if len(cliConfig.ServerReplayParams) > 0 {
+		config.ServerReplayParams = cliConfig.ServerReplayParams
+	}
+	if len(cliConfig.ServerReplayHeader) > 0 {
+		config.ServerReplayHeader = cliConfig.ServerReplayHeader
+	}
+	if cliConfig.ServerReplayKill {
+		config.ServerReplayKill = cliConfig.ServerReplayKill
+	}
+	if cliConfig.StickyCookies {
+		config.StickyCookies = cliConfig.StickyCookies
+	}
+	if cliConfig.Throttle != "" {
+		config.Throttle = cliConfig.Throttle
+	}
 	if cliConfig.LogFile != "" {
 		config.LogFile = cliConfig.LogFile
 	}
+	config.filename = cliConfig.filename
+	config.validate = cliConfig.validate
+	config.exportP12 = cliConfig.exportP12
+	config.p12Password = cliConfig.p12Password
 	return config
 }
 
@@ -107,6 +332,54 @@ func loadConfig() *Config {
 	return mergeConfigs(fileConfig, cliConfig)
 }
 
+// validateConfig loads every configured file and writes a report to out.
+// It returns false if any of them is invalid.
+func validateConfig(config *Config, out io.Writer) bool {
+	type check struct {
+		name     string
+		filename string
+		validate func(string) error
+	}
+	checks := []check{
+		{"config", config.filename, func(filename string) error {
+			_, err := loadConfigFromFile(filename)
+			return err
+		}},
+		{"map_remote", config.MapRemote, addons.ValidateMapRemoteFile},
+		{"map_local", config.MapLocal, addons.ValidateMapLocalFile},
+		{"header_rewrite", config.HeaderRewrite, addons.ValidateHeaderRewriteFile},
+		{"body_rewrite", config.BodyRewrite, addons.ValidateBodyRewriteFile},
+		{"block_list", config.BlockList, addons.ValidateBlockListFile},
+		{"cors", config.CORS, addons.ValidateCORSFile},
+		{"script", config.Script, addons.ValidateStarlarkScriptFile},
+		{"js_script", config.JSScript, addons.ValidateJSScriptFile},
+		{"exec_hook", config.ExecHook, addons.ValidateExecHookFile},
+		{"throttle", config.Throttle, addons.ValidateThrottleFile},
+	}
+	for _, filename := range config.WasmPlugins {
+		checks = append(checks, check{"wasm_plugin", filename, addons.ValidateWasmPluginFile})
+	}
+
+	valid := true
+	checked := 0
+	for _, check := range checks {
+		if check.filename == "" {
+			continue
+		}
+		checked++
+		if err := check.validate(check.filename); err != nil {
+			valid = false
+			fmt.Fprintf(out, "%s %s: invalid: %v\n", check.name, check.filename, err)
+			continue
+		}
+		fmt.Fprintf(out, "%s %s: ok\n", check.name, check.filename)
+	}
+	if checked == 0 {
+		fmt.Fprintln(out, "no config files to validate")
+	}
+	return valid
+}
+
 // arrayValue implements the flag.Value interface.
 type arrayValue []string
 
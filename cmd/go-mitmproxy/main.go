@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/denisvmedia/go-mitmproxy/cert"
 	"github.com/denisvmedia/go-mitmproxy/internal/helper"
 	"github.com/denisvmedia/go-mitmproxy/proxy"
 	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
@@ -19,21 +24,79 @@ type Config struct {
 	version bool // show go-mitmproxy version
 
 	Addr               string   // proxy listen addr
-	WebAddr            string   // web interface listen addr
+	ExtraAddrs         []string // more proxy listen addrs, e.g. [::1]:9080 next to 127.0.0.1:9080
+	Network            string   // network of the proxy listen addrs: tcp, or unix for socket files. Default: tcp
+	SystemdActivation  bool     // serve the listening sockets passed by systemd socket activation instead
+	ProxyCert          string   // certificate PEM file the proxy listens with TLS with, as an HTTPS proxy
+	ProxyKey           string   // private key PEM file of ProxyCert
+	AllowClients       []string // CIDRs or IPs of the only clients allowed to connect to the proxy
+	DenyClients        []string // CIDRs or IPs of the clients not allowed to connect to the proxy
+	MaxConns           int      // maximum number of client connections open at the same time
+	MaxConnsPerClient  int      // maximum number of connections open at the same time by one client IP
+	IdleConnsPerHost   int      // idle upstream connections the separate client keeps per host. Default: 32
+	SpoolBodies        int64    // size up to which bodies too large to buffer are spooled to temporary files for addons
+	WebAddr            string   // web interface listen addr. Default: 127.0.0.1:9081, use :9081 to accept remote connections
+	WebAuth            string   // require basic authentication for the web interface. Format: "username:pass"
+	WebToken           string   // require a bearer token for the web interface, also accepted as ?token=
+	WebTLS             bool     // serve the web interface over HTTPS with certificates signed by the CA
+	WebCert            string   // certificate PEM file serving the web interface over HTTPS instead of one signed by the CA
+	WebKey             string   // private key PEM file of WebCert
+	WebStore           string   // bbolt database filename keeping the flows of the web interface across restarts
+	WebMaxFlows        int      // maximum number of flows kept by the web interface. Default: 1000, 100000 with WebStore
+	WebMaxMemoryMB     int      // maximum size in MB of the bodies of the flows kept in memory by the web interface
+	WebMaxBodyKB       int      // bodies larger than this many KB are truncated in the web interface
 	InsecureSkipVerify bool     // not verify upstream server SSL/TLS certificates.
 	IgnoreHosts        []string // a list of ignore hosts
 	AllowHosts         []string // a list of allow hosts
 	CertPath           string   // path of generate cert files
+	CACert             string   // existing CA certificate PEM file, used instead of the generated CA
+	CAKey              string   // private key PEM file of CACert
+	CAP12              string   // existing CA as PKCS#12 file, used instead of the generated CA
+	CAP12Password      string   // password of the CAP12 file
 	Debug              int      // debug mode: 1 - print debug log, 2 - show debug from
 	Dump               string   // dump filename
 	DumpLevel          int      // dump level: 0 - header, 1 - header + body
+	JSONLDump          string   // write one JSON object per flow to the filename, bodies included with DumpLevel 1
+	SaveStreamFile     string   // save flows in the Python mitmproxy flow format to the filename
+	PCAPFile           string   // write decrypted flows as synthetic TCP streams to the pcapng filename
+	WireCapture        string   // write the exact bytes read from and written to connections to the filename
+	PostmanCollection  string   // aggregate flows into a Postman v2.1 collection written to the filename
+	LoadTestScript     string   // write the recorded requests as a load test script to the filename
+	LoadTestFormat     string   // format of LoadTestScript: k6 or vegeta. Default: k6
+	Metrics            bool     // serve Prometheus metrics at /metrics of the proxy addr
+	MetricsAddr        string   // also serve Prometheus metrics on a separate listen addr, implies Metrics
 	Upstream           string   // upstream proxy
+	Resolver           string   // comma-separated DNS servers, or the https URL of a DNS-over-HTTPS server, to look up hosts with
+	OutboundAddr       string   // local IP address the connections to servers and upstream proxies are bound to
 	UpstreamCert       bool     // Connect to upstream server to look up certificate details. Default: True
 	MapRemote          string   // map remote config filename
 	MapLocal           string   // map local config filename
+	HeaderRewrite      string   // header rewrite config filename
+	BodyRewrite        string   // body rewrite config filename
+	Throttle           string   // throttle config filename, to simulate slow networks
+	BlockList          string   // block list config filename
+	CORS               string   // CORS config filename, to allow cross-origin requests to APIs
+	Script             string   // Starlark script filename
+	JSScript           string   // JavaScript script filename exporting addon hooks
+	WasmPlugins        []string // WebAssembly plugin filenames
+	ExecHook           string   // exec hook config filename, to change flows with external programs
+	RemoteAddons       []string // gRPC addresses of remote addon servers
+	WatchConfig        bool     // reload the map_remote, map_local, header_rewrite, body_rewrite, block_list, throttle and script files and reopen the dump file when they change
+	Cache              bool     // cache responses in memory, as a shared HTTP cache
+	AntiCache          bool     // strip caching headers so clients always fetch full responses
+	StickyCookies      bool     // keep the cookies set by servers per client IP and send them on later requests
+	ServerReplay       string   // answer requests with the responses recorded in the flow, JSONL or HAR filename
+	ServerReplayParams []string // query parameters ignored when matching requests to recorded ones
+	ServerReplayHeader []string // request headers that must match the recorded ones too
+	ServerReplayKill   bool     // answer requests without a recorded response with a 404
+	ClientReplay       string   // replay the requests recorded in the flow, JSONL or HAR filename at startup
 	LogFile            string   // log file path
 
 	filename string // read config from the filename
+	validate bool   // validate the config files, then exit
+
+	exportP12   string // export the CA as PKCS#12 to the filename, then exit
+	p12Password string // password protecting the exported PKCS#12 bundle
 
 	ProxyAuth string // Require proxy authentication
 
@@ -42,6 +105,13 @@ type Config struct {
 func main() {
 	config := loadConfig()
 
+	if config.validate {
+		if !validateConfig(config, os.Stdout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Configure global slog logger.
 	level := slog.LevelInfo
 	addSource := false
@@ -55,17 +125,63 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 
-	ca, err := cert.NewSelfSignCA(config.CertPath)
+	ca, err := loadCA(config)
 	if err != nil {
-		slog.Error("failed to create CA", "error", err)
+		slog.Error("failed to load CA", "error", err)
 		os.Exit(1)
 	}
 
+	if config.exportP12 != "" {
+		if err := exportCAPKCS12(ca, config.exportP12, config.p12Password); err != nil {
+			slog.Error("failed to export CA as PKCS#12", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("CA exported as PKCS#12", "file", config.exportP12)
+		os.Exit(0)
+	}
+
 	proxyConfig := proxy.Config{
 		Addr:               config.Addr,
+		ExtraAddrs:         config.ExtraAddrs,
+		Network:            config.Network,
 		StreamLargeBodies:  1024 * 1024 * 5,
 		InsecureSkipVerify: config.InsecureSkipVerify,
 		Upstream:           config.Upstream,
+		OutboundLocalAddr:  config.OutboundAddr,
+		AllowClients:       config.AllowClients,
+		DenyClients:        config.DenyClients,
+
+		MaxClientConnections:    config.MaxConns,
+		MaxConnectionsPerClient: config.MaxConnsPerClient,
+		MaxIdleConnsPerHost:     config.IdleConnsPerHost,
+		SpoolLargeBodies:        config.SpoolBodies,
+		SystemdSocketActivation: config.SystemdActivation,
+	}
+	if strings.HasPrefix(config.Resolver, "https://") {
+		proxyConfig.Resolver = proxy.NewDoHResolver(config.Resolver, nil)
+	} else if config.Resolver != "" {
+		proxyConfig.Resolver = proxy.NewDNSResolver(strings.Split(config.Resolver, ",")...)
+	}
+	if config.WireCapture != "" {
+		wireFile, err := os.OpenFile(config.WireCapture, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			slog.Error("failed to open wire capture file", "error", err)
+			os.Exit(1)
+		}
+		defer wireFile.Close()
+		proxyConfig.WireCapture = wireFile
+	}
+	if config.ProxyCert != "" {
+		keyFile := config.ProxyKey
+		if keyFile == "" {
+			keyFile = config.ProxyCert
+		}
+		certificate, err := tls.LoadX509KeyPair(config.ProxyCert, keyFile)
+		if err != nil {
+			slog.Error("failed to load proxy certificate", "error", err)
+			os.Exit(1)
+		}
+		proxyConfig.TLSCertificate = &certificate
 	}
 
 	p, err := proxy.NewProxy(proxyConfig, ca)
@@ -111,7 +227,50 @@ func main() {
 		// Use default logger
 		p.AddAddon(&addons.LogAddon{})
 	}
-	p.AddAddon(web.NewWebAddon(config.WebAddr))
+	webOptions := web.Options{
+		Addr:        config.WebAddr,
+		Token:       config.WebToken,
+		MaxFlows:    config.WebMaxFlows,
+		MaxMemory:   int64(config.WebMaxMemoryMB) * 1024 * 1024,
+		MaxBodySize: config.WebMaxBodyKB * 1024,
+	}
+	if config.WebAuth != "" {
+		username, password, ok := strings.Cut(config.WebAuth, ":")
+		if !ok {
+			slog.Error("invalid web auth format", slog.String("value", config.WebAuth))
+			os.Exit(1)
+		}
+		webOptions.Username, webOptions.Password = username, password
+	}
+	if config.WebCert != "" {
+		keyFile := config.WebKey
+		if keyFile == "" {
+			keyFile = config.WebCert
+		}
+		certificate, err := tls.LoadX509KeyPair(config.WebCert, keyFile)
+		if err != nil {
+			slog.Error("failed to load web certificate", "error", err)
+			os.Exit(1)
+		}
+		webOptions.TLSCertificate = &certificate
+	} else if config.WebTLS {
+		webOptions.CA = ca
+	}
+	if config.WebStore != "" {
+		store, err := web.NewBoltFlowStore(config.WebStore, config.WebMaxFlows)
+		if err != nil {
+			slog.Error("failed to open web store", "error", err)
+			os.Exit(1)
+		}
+		webOptions.Store = store
+	}
+	webAddon := web.NewWebAddonWithOptions(webOptions)
+	if config.Network != "unix" {
+		webAddon.SetCurlProxy(localProxyURL(config.Addr, config.ProxyCert != ""))
+	}
+	p.AddAddon(webAddon)
+
+	watcher := addons.NewConfigWatcher(0)
 
 	if config.MapRemote != "" {
 		mapRemote, err := addons.NewMapRemoteFromFile(config.MapRemote)
@@ -119,6 +278,7 @@ func main() {
 			slog.Warn("load map remote error", "error", err)
 		} else {
 			p.AddAddon(mapRemote)
+			watcher.Add(mapRemote)
 		}
 	}
 
@@ -128,16 +288,245 @@ func main() {
 			slog.Warn("load map local error", "error", err)
 		} else {
 			p.AddAddon(mapLocal)
+			watcher.Add(mapLocal)
+		}
+	}
+
+	if config.HeaderRewrite != "" {
+		headerRewrite, err := addons.NewHeaderRewriteFromFile(config.HeaderRewrite)
+		if err != nil {
+			slog.Warn("load header rewrite error", "error", err)
+		} else {
+			p.AddAddon(headerRewrite)
+			watcher.Add(headerRewrite)
+		}
+	}
+
+	if config.BodyRewrite != "" {
+		bodyRewrite, err := addons.NewBodyRewriteFromFile(config.BodyRewrite)
+		if err != nil {
+			slog.Warn("load body rewrite error", "error", err)
+		} else {
+			p.AddAddon(bodyRewrite)
+			watcher.Add(bodyRewrite)
+		}
+	}
+
+	if config.CORS != "" {
+		cors, err := addons.NewCORSFromFile(config.CORS)
+		if err != nil {
+			slog.Warn("load cors error", "error", err)
+		} else {
+			p.AddAddon(cors)
+		}
+	}
+
+	if config.Script != "" {
+		script, err := addons.NewStarlarkScriptFromFile(config.Script)
+		if err != nil {
+			slog.Warn("load script error", "error", err)
+		} else {
+			p.AddAddon(script)
+			watcher.Add(script)
+		}
+	}
+
+	if config.JSScript != "" {
+		jsScript, err := addons.NewJSScriptFromFile(config.JSScript)
+		if err != nil {
+			slog.Warn("load js script error", "error", err)
+		} else {
+			p.AddAddon(jsScript)
+		}
+	}
+
+	if config.ExecHook != "" {
+		execHook, err := addons.NewExecHookFromFile(config.ExecHook)
+		if err != nil {
+			slog.Warn("load exec hook error", "error", err)
+		} else {
+			p.AddAddon(execHook)
+		}
+	}
+
+	for _, target := range config.RemoteAddons {
+		remote, err := addons.NewRemoteAddon(target, addons.RemoteAddonOptions{})
+		if err != nil {
+			slog.Warn("load remote addon error", "target", target, "error", err)
+		} else {
+			p.AddAddon(remote)
+		}
+	}
+
+	for _, filename := range config.WasmPlugins {
+		plugin, err := addons.NewWasmPluginFromFile(filename, addons.WasmPluginOptions{})
+		if err != nil {
+			slog.Warn("load wasm plugin error", "file", filename, "error", err)
+		} else {
+			p.AddAddon(plugin)
+		}
+	}
+
+	if config.BlockList != "" {
+		blockList, err := addons.NewBlockListFromFile(config.BlockList)
+		if err != nil {
+			slog.Warn("load block list error", "error", err)
+		} else {
+			p.AddAddon(blockList)
+			watcher.Add(blockList)
+		}
+	}
+
+	if config.ServerReplay != "" {
+		serverReplay, err := addons.NewServerReplayFromFile(config.ServerReplay, addons.ServerReplayOptions{
+			IgnoreParams: config.ServerReplayParams,
+			Headers:      config.ServerReplayHeader,
+			KillExtra:    config.ServerReplayKill,
+		})
+		if err != nil {
+			slog.Warn("load server replay error", "error", err)
+		} else {
+			p.AddAddon(serverReplay)
+		}
+	}
+
+	if config.AntiCache {
+		p.AddAddon(addons.NewAntiCache())
+	}
+
+	if config.StickyCookies {
+		p.AddAddon(addons.NewStickyCookies(addons.StickyCookiesOptions{}))
+	}
+
+	if config.Cache {
+		p.AddAddon(addons.NewResponseCache(addons.ResponseCacheOptions{}))
+	}
+
+	if config.Throttle != "" {
+		throttle, err := addons.NewThrottleFromFile(config.Throttle)
+		if err != nil {
+			slog.Warn("load throttle error", "error", err)
+		} else {
+			p.AddAddon(throttle)
+			watcher.Add(throttle)
 		}
 	}
 
 	if config.Dump != "" {
 		dumper := addons.NewDumperWithFilename(config.Dump, config.DumpLevel)
 		p.AddAddon(dumper)
+		watcher.Add(dumper)
 	}
 
-	if err := p.Start(); err != nil {
+	if config.WatchConfig {
+		watcher.Start()
+	}
+
+	if config.JSONLDump != "" {
+		jsonlDumper, err := addons.NewJSONLDumperFromFile(config.JSONLDump, addons.JSONLOptions{
+			IncludeBodies: config.DumpLevel == 1,
+			Base64Binary:  true,
+		})
+		if err != nil {
+			slog.Error("failed to open jsonl dump file", "error", err)
+			os.Exit(1)
+		}
+		p.AddAddon(jsonlDumper)
+	}
+
+	if config.SaveStreamFile != "" {
+		flowWriter, err := addons.NewFlowWriterFromFile(config.SaveStreamFile)
+		if err != nil {
+			slog.Error("failed to open flow file", "error", err)
+			os.Exit(1)
+		}
+		p.AddAddon(flowWriter)
+	}
+
+	if config.Metrics || config.MetricsAddr != "" {
+		metrics := addons.NewPrometheusAddon()
+		if statser, ok := ca.(addons.CertCacheStatser); ok {
+			metrics.ObserveCertCache(statser)
+		}
+		metrics.ObserveRejectedClients(p)
+		metrics.ObserveSeparateClient(p)
+		p.AddAddon(metrics)
+		if config.MetricsAddr != "" {
+			go func() {
+				mux := http.NewServeMux()
+				mux.Handle(addons.MetricsPath, metrics.Handler())
+				slog.Info("metrics listening", "addr", config.MetricsAddr)
+				if err := http.ListenAndServe(config.MetricsAddr, mux); err != nil {
+					slog.Error("metrics server failed", "error", err)
+				}
+			}()
+		}
+	}
+
+	if config.PCAPFile != "" {
+		pcapWriter, err := addons.NewPCAPWriterFromFile(config.PCAPFile)
+		if err != nil {
+			slog.Error("failed to open pcap file", "error", err)
+			os.Exit(1)
+		}
+		p.AddAddon(pcapWriter)
+	}
+
+	if config.PostmanCollection != "" {
+		postmanExporter, err := addons.NewPostmanExporterFromFile(config.PostmanCollection)
+		if err != nil {
+			slog.Error("failed to write postman collection", "error", err)
+			os.Exit(1)
+		}
+		p.AddAddon(postmanExporter)
+	}
+
+	if config.LoadTestScript != "" {
+		format := addons.LoadTestK6
+		if config.LoadTestFormat != "" {
+			format = addons.LoadTestFormat(config.LoadTestFormat)
+		}
+		loadTestExporter, err := addons.NewLoadTestExporterFromFile(config.LoadTestScript, format)
+		if err != nil {
+			slog.Error("failed to write load test script", "error", err)
+			os.Exit(1)
+		}
+		p.AddAddon(loadTestExporter)
+	}
+
+	if config.ClientReplay != "" {
+		clientReplay, err := addons.NewClientReplayFromFile(config.ClientReplay)
+		if err != nil {
+			slog.Warn("load client replay error", "error", err)
+		} else {
+			// replays are sent by the proxy itself, the listener is not needed
+			go func() {
+				if err := clientReplay.Run(context.Background(), p); err != nil {
+					slog.Warn("client replay error", "error", err)
+				}
+				slog.Info("client replay done", "file", config.ClientReplay)
+			}()
+		}
+	}
+
+	// shut down on interrupt, so that addons flush and close their files
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		slog.Info("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := p.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("shutdown error", "error", err)
+		}
+	}()
+
+	if err := p.Start(); !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("proxy exited", "error", err)
 		os.Exit(1)
 	}
+	<-stopped
 }
@@ -4,9 +4,12 @@ import (
 	"encoding/base64"
 	"errors"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/denisvmedia/go-mitmproxy/cert"
 )
 
 type DefaultBasicAuth struct {
@@ -63,3 +66,54 @@ func (usr *DefaultBasicAuth) parseRequestAuth(proxyAuth string) bool {
 	}
 	return true
 }
+
+// loadCA loads the configured external CA, or the generated CA from the cert path.
+func loadCA(config *Config) (cert.CA, error) {
+	switch {
+	case config.CACert != "" && config.CAP12 != "":
+		return nil, errors.New("ca_cert and ca_p12 are mutually exclusive")
+	case config.CACert != "":
+		keyPath := config.CAKey
+		if keyPath == "" {
+			keyPath = config.CACert
+		}
+		return cert.NewCAFromFiles(config.CACert, keyPath)
+	case config.CAKey != "":
+		return nil, errors.New("ca_key requires ca_cert")
+	case config.CAP12 != "":
+		return cert.NewCAFromPKCS12File(config.CAP12, config.CAP12Password)
+	}
+	return cert.NewSelfSignCA(config.CertPath)
+}
+
+// Export the root CA certificate as a PKCS#12 bundle, mainly for installing on mobile devices.
+func exportCAPKCS12(ca cert.CA, filename, password string) error {
+	exporter, ok := ca.(interface {
+		ExportPKCS12(password string) ([]byte, error)
+	})
+	if !ok {
+		return errors.New("CA does not support PKCS#12 export")
+	}
+	data, err := exporter.ExportPKCS12(password)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// localProxyURL returns the URL local clients reach the proxy listening on addr at, an
+// https URL when the proxy listens with TLS.
+func localProxyURL(addr string, secure bool) string {
+	scheme := "http://"
+	if secure {
+		scheme = "https://"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return scheme + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return scheme + net.JoinHostPort(host, port)
+}
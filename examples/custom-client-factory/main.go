@@ -36,6 +36,7 @@ func (*CustomClientFactory) CreateMainClient(upstreamManager proxy.UpstreamManag
 		Transport: &customTransport{
 			base: &http.Transport{
 				Proxy:              upstreamManager.RealUpstreamProxy(),
+				DialContext:        upstreamManager.DialContext,
 				ForceAttemptHTTP2:  true,
 				DisableCompression: true,
 				TLSClientConfig: &tls.Config{
@@ -94,6 +95,11 @@ func (f *LoggingClientFactory) CreateHTTPSClient(tlsConn *tls.Conn) *http.Client
 	return f.DefaultClientFactory.CreateHTTPSClient(tlsConn)
 }
 
+func (f *LoggingClientFactory) CreateHTTP3Client(insecureSkipVerify bool) *http.Client {
+	slog.Info("Creating HTTP/3 client")
+	return f.DefaultClientFactory.CreateHTTP3Client(insecureSkipVerify)
+}
+
 // Example 3: A client factory that uses HTTP/2 for all connections.
 type ForceHTTP2ClientFactory struct {
 	*proxy.DefaultClientFactory
@@ -8,28 +8,51 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
+// bufferPool holds the buffers ReaderToBuffer reads into, so that a body being read
+// does not grow a buffer of its own, allocation after allocation, under high load.
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
 // Try to read Reader into buffer
 // If the limit is not reached, successfully read into buffer
 // Otherwise buffer returns nil, and a new Reader is returned with state before reading.
+//
+// The reading goes through a pooled buffer, and the returned buffer is a copy of exactly
+// the length of the body, which the caller owns. Pooled buffers are at most limit large.
 func ReaderToBuffer(r io.Reader, limit int64) ([]byte, io.Reader, error) {
-	buf := bytes.NewBuffer(make([]byte, 0))
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
 	lr := io.LimitReader(r, limit)
 
 	_, err := io.Copy(buf, lr)
 	if err != nil {
+		putBuffer(buf, limit)
 		return nil, nil, err
 	}
 
 	// Reached the limit
 	if int64(buf.Len()) == limit {
-		// Return a new Reader
-		return nil, io.MultiReader(bytes.NewBuffer(buf.Bytes()), r), nil
+		// Return a new Reader, which keeps the buffer out of the pool
+		return nil, io.MultiReader(buf, r), nil
 	}
 
 	// Return buffer
-	return buf.Bytes(), nil, nil
+	body := append([]byte{}, buf.Bytes()...)
+	putBuffer(buf, limit)
+	return body, nil, nil
+}
+
+// putBuffer returns buf to the pool, unless it grew larger than limit.
+func putBuffer(buf *bytes.Buffer, limit int64) {
+	if int64(buf.Cap()) <= limit {
+		bufferPool.Put(buf)
+	}
 }
 
 func NewStructFromFile(filename string, v any) error {
@@ -43,10 +66,30 @@ func NewStructFromFile(filename string, v any) error {
 	return nil
 }
 
+// ReplaceFile replaces the content of the file at filename with data through a temporary
+// file, so readers see either the old or the new content.
+func ReplaceFile(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
 var portMap = map[string]string{
-	"http":   "80",
-	"https":  "443",
-	"socks5": "1080",
+	"http":    "80",
+	"https":   "443",
+	"socks5":  "1080",
+	"socks5h": "1080",
 }
 
 // CanonicalAddr returns url.Host but always with a ":port" suffix.
@@ -66,6 +109,54 @@ func IsTLS(buf []byte) bool {
 	return false
 }
 
+// MatchRequestLine checks b, the first bytes of a stream, against an HTTP/1.x request
+// line: a method token, a space, a request target, a space and the HTTP version. ok is
+// false as soon as b cannot start such a line, complete is true once b holds all of it.
+func MatchRequestLine(b []byte) (ok, complete bool) {
+	line := b
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		line = bytes.TrimSuffix(b[:i], []byte("\r"))
+		complete = true
+	}
+
+	method, rest, found := bytes.Cut(line, []byte(" "))
+	if len(method) == 0 && (found || complete) || bytes.IndexFunc(method, func(r rune) bool { return !isTokenChar(r) }) >= 0 {
+		return false, false
+	}
+	if !found {
+		return !complete, false
+	}
+	target, version, found := bytes.Cut(rest, []byte(" "))
+	if len(target) == 0 && (found || complete) || bytes.IndexFunc(target, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+		return false, false
+	}
+	if !found {
+		return !complete, false
+	}
+
+	const prefix = "HTTP/1."
+	if !bytes.HasPrefix([]byte(prefix), version[:min(len(version), len(prefix))]) {
+		return false, false
+	}
+	if !complete {
+		version = bytes.TrimSuffix(version, []byte("\r"))
+		return len(version) <= len(prefix) || len(version) == len(prefix)+1 && isDigit(version[len(prefix)]), false
+	}
+	if len(version) != len(prefix)+1 || !isDigit(version[len(prefix)]) {
+		return false, false
+	}
+	return true, true
+}
+
+// isTokenChar reports whether r may appear in an RFC 9110 token, such as a method.
+func isTokenChar(r rune) bool {
+	return r < 0x7f && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || isDigit(byte(r)) || strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
 type ResponseCheck struct {
 	http.ResponseWriter
 	Wrote bool
@@ -43,6 +43,25 @@ func TestReaderToBufferReturnsStreamingReaderWhenAtLimit(t *testing.T) {
 	c.Assert(all, qt.DeepEquals, data)
 }
 
+func TestReaderToBufferReturnsOwnedBuffers(t *testing.T) {
+	c := qt.New(t)
+
+	// the pooled buffers are reused, the returned ones are not
+	first, _, err := helper.ReaderToBuffer(bytes.NewReader([]byte("first payload")), 1024)
+	c.Assert(err, qt.IsNil)
+	second, _, err := helper.ReaderToBuffer(bytes.NewReader([]byte("second")), 1024)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(first), qt.Equals, "first payload")
+	c.Assert(string(second), qt.Equals, "second")
+
+	// an empty body is buffered, not too large
+	empty, nextReader, err := helper.ReaderToBuffer(bytes.NewReader(nil), 1024)
+	c.Assert(err, qt.IsNil)
+	c.Assert(empty, qt.IsNotNil)
+	c.Assert(empty, qt.HasLen, 0)
+	c.Assert(nextReader, qt.IsNil)
+}
+
 func TestCanonicalAddrAddsDefaultHTTPPort(t *testing.T) {
 	c := qt.New(t)
 
@@ -84,6 +103,32 @@ func TestIsTLSRejectsNonTLS(t *testing.T) {
 	c.Assert(helper.IsTLS(bufNonTLS), qt.IsFalse)
 }
 
+func TestMatchRequestLine(t *testing.T) {
+	tests := []struct {
+		in       string
+		ok       bool
+		complete bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n", true, true},
+		{"OPTIONS * HTTP/1.0\n", true, true},
+		{"GET /index.html HTTP/1.1\r", true, false},
+		{"GET /index.ht", true, false},
+		{"PO", true, false},
+		{"SSH-2.0-OpenSSH_9.6\r\n", false, false},
+		{"\x00\x00\x00\x08\x04\xd2\x16\x2f", false, false},
+		{"GET  / HTTP/1.1\r\n", false, false},
+		{"GET / HTTP/2.0\r\n", false, false},
+		{"GET / HTTP/1.1 extra\r\n", false, false},
+		{"GET / FTP", false, false},
+	}
+	for _, test := range tests {
+		c := qt.New(t)
+		ok, complete := helper.MatchRequestLine([]byte(test.in))
+		c.Assert(ok, qt.Equals, test.ok, qt.Commentf("%q", test.in))
+		c.Assert(complete, qt.Equals, test.complete, qt.Commentf("%q", test.in))
+	}
+}
+
 func TestNewStructFromFileLoadsJSON(t *testing.T) {
 	c := qt.New(t)
 
@@ -107,6 +152,24 @@ func TestNewStructFromFileLoadsJSON(t *testing.T) {
 	c.Assert(out.Age, qt.Equals, 30)
 }
 
+func TestReplaceFile(t *testing.T) {
+	c := qt.New(t)
+
+	dir := t.TempDir()
+	file := dir + "/out.json"
+	c.Assert(helper.ReplaceFile(file, []byte("first")), qt.IsNil)
+	c.Assert(helper.ReplaceFile(file, []byte("second")), qt.IsNil)
+
+	content, readErr := os.ReadFile(file)
+	c.Assert(readErr, qt.IsNil)
+	c.Assert(string(content), qt.Equals, "second")
+
+	// no temporary files are left behind
+	entries, readErr := os.ReadDir(dir)
+	c.Assert(readErr, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 1)
+}
+
 func TestResponseCheckMarksWrote(t *testing.T) {
 	c := qt.New(t)
 
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -15,11 +16,71 @@ import (
 	"golang.org/x/net/proxy"
 )
 
-// GetProxyConn connect proxy
+// GetProxyConn connect proxy, dial is used for the connection to the proxy itself
 // ref: http/transport.go dialConn func
-func GetProxyConn(ctx context.Context, proxyURL *url.URL, address string, sslInsecure bool) (net.Conn, error) {
-	var conn net.Conn
-	if proxyURL.Scheme == "socks5" {
+//
+// HTTP proxies are authenticated with auth, or with Basic and the user info of proxyURL
+// when it is nil.
+//
+// SOCKS5 proxies, with either the socks5 or the socks5h scheme, are sent the hostname of
+// address for them to resolve, as net/http does, so that names only the proxy can
+// resolve, like the .onion ones of Tor, can be reached.
+func GetProxyConn(ctx context.Context, dial DialFunc, proxyURL *url.URL, address string, sslInsecure bool, auth ProxyAuth) (net.Conn, error) {
+	return dialProxy(ctx, dial, proxyURL, address, sslInsecure, auth)
+}
+
+// GetProxyChainConn connects to address through the chain of proxies, the first of
+// which is dialed with dial and each of which is asked to connect to the next one.
+// Every proxy authenticates like with GetProxyConn, with auth or the user info of its own
+// URL.
+func GetProxyChainConn(ctx context.Context, dial DialFunc, chain []*url.URL, address string, sslInsecure bool, auth ProxyAuth) (net.Conn, error) {
+	for _, proxyURL := range chain {
+		through := dial
+		dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialProxy(ctx, through, proxyURL, addr, sslInsecure, auth)
+		}
+	}
+	return dial(ctx, "tcp", address)
+}
+
+// DialFunc dials a connection, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dial implements proxy.Dialer, so that SOCKS5 proxies can be reached through others.
+func (d DialFunc) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer.
+func (d DialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// maxProxyAuthRounds bounds the CONNECT requests of one ProxyAuth handshake.
+const maxProxyAuthRounds = 5
+
+// ProxyAuth authenticates the CONNECT requests to HTTP upstream proxies with schemes
+// beyond Basic, like NTLM or Negotiate (Kerberos) backed by SSPI or GSSAPI, whose
+// handshakes take several round trips.
+type ProxyAuth interface {
+	// Start begins the authentication of a connection to the proxy at proxyURL. A nil
+	// handshake authenticates with Basic and the user info of proxyURL instead.
+	Start(proxyURL *url.URL) (ProxyAuthHandshake, error)
+}
+
+// ProxyAuthHandshake is an authentication begun by ProxyAuth.Start. The requests of a
+// handshake go over the same connection as long as the proxy keeps it open.
+type ProxyAuthHandshake interface {
+	// Authorization returns the Proxy-Authorization header of the next CONNECT request,
+	// given the Proxy-Authenticate challenges of the 407 response to the previous one,
+	// none for the first request. An empty header sends the request without one.
+	Authorization(challenges []string) (string, error)
+}
+
+// dialProxy connects to address through the proxy at proxyURL, which is dialed with dial.
+// HTTP proxies are authenticated with auth, if not nil.
+func dialProxy(ctx context.Context, dial DialFunc, proxyURL *url.URL, address string, sslInsecure bool, auth ProxyAuth) (net.Conn, error) {
+	if proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h" {
 		// Check for socks5 authentication info
 		proxyAuth := &proxy.Auth{}
 		if proxyURL.User != nil {
@@ -28,24 +89,75 @@ func GetProxyConn(ctx context.Context, proxyURL *url.URL, address string, sslIns
 			proxyAuth.User = user
 			proxyAuth.Password = pass
 		}
-		dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, proxyAuth, proxy.Direct)
+		dialer, err := proxy.SOCKS5("tcp", CanonicalAddr(proxyURL), proxyAuth, dial)
 		if err != nil {
 			return nil, err
 		}
-		dc, ok := dialer.(interface {
-			DialContext(ctx context.Context, network, addr string) (net.Conn, error)
-		})
+		dc, ok := dialer.(proxy.ContextDialer)
 		if !ok {
 			return nil, errors.New("SOCKS5 dialer does not support DialContext")
 		}
-		conn, err = dc.DialContext(ctx, "tcp", address)
+		return dc.DialContext(ctx, "tcp", address)
+	}
+
+	var handshake ProxyAuthHandshake
+	if auth != nil {
+		var err error
+		if handshake, err = auth.Start(proxyURL); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := openProxyConn(ctx, dial, proxyURL, sslInsecure)
+	if err != nil {
+		return nil, err
+	}
+	var challenges []string
+	for round := 1; ; round++ {
+		var authorization string
+		switch {
+		case handshake != nil:
+			authorization, err = handshake.Authorization(challenges)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		case proxyURL.User != nil:
+			pass, _ := proxyURL.User.Password()
+			auth := proxyURL.User.Username() + ":" + pass
+			authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+		}
+		resp, err := connect(ctx, conn, address, authorization)
 		if err != nil {
 			conn.Close()
 			return nil, err
 		}
-		return conn, err
+		if resp.StatusCode == http.StatusOK {
+			return conn, nil
+		}
+		if resp.StatusCode == http.StatusProxyAuthRequired && handshake != nil && round < maxProxyAuthRounds {
+			challenges = resp.Header.Values("Proxy-Authenticate")
+			if resp.Close || strings.EqualFold(resp.Header.Get("Proxy-Connection"), "close") {
+				// the next round goes over a new connection
+				conn.Close()
+				if conn, err = openProxyConn(ctx, dial, proxyURL, sslInsecure); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		_, text, ok := strings.Cut(resp.Status, " ")
+		conn.Close()
+		if !ok {
+			return nil, errors.New("unknown status code")
+		}
+		return nil, errors.New(text)
 	}
-	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", proxyURL.Host)
+}
+
+// openProxyConn dials the proxy at proxyURL, and performs the TLS handshake with the
+// HTTPS ones.
+func openProxyConn(ctx context.Context, dial DialFunc, proxyURL *url.URL, sslInsecure bool) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", CanonicalAddr(proxyURL))
 	if err != nil {
 		return nil, err
 	}
@@ -65,19 +177,27 @@ func GetProxyConn(ctx context.Context, proxyURL *url.URL, address string, sslIns
 		}
 		conn = tlsConn // Replace the original connection with the TLS connection
 	}
+	return conn, nil
+}
+
+// connect sends a CONNECT request for address over conn and reads the response, whose
+// body is drained for the connection to take the next request. Conn is closed when the
+// request times out.
+func connect(ctx context.Context, conn net.Conn, address, authorization string) (*http.Response, error) {
 	connectReq := &http.Request{
 		Method: "CONNECT",
 		URL:    &url.URL{Opaque: address},
 		Host:   address,
 		Header: http.Header{},
 	}
-	if proxyURL.User != nil {
-		connectReq.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(proxyURL.User.String())))
+	if authorization != "" {
+		connectReq.Header.Set("Proxy-Authorization", authorization)
 	}
 	connectCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
 	defer cancel()
 	didReadResponse := make(chan struct{}) // closed after CONNECT write+read is done or fails
 	var resp *http.Response
+	var err error
 	// Write the CONNECT request & read the response.
 	go func() {
 		defer close(didReadResponse)
@@ -86,9 +206,15 @@ func GetProxyConn(ctx context.Context, proxyURL *url.URL, address string, sslIns
 			return
 		}
 		// Okay to use and discard buffered reader here, because
-		// TLS server will not speak until spoken to.
+		// TLS server will not speak until spoken to, and the proxy
+		// does not until the next request.
 		br := bufio.NewReader(conn)
 		resp, err = http.ReadResponse(br, connectReq)
+		if err != nil || resp.StatusCode == http.StatusOK {
+			return
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
 	}()
 	select {
 	case <-connectCtx.Done():
@@ -98,17 +224,5 @@ func GetProxyConn(ctx context.Context, proxyURL *url.URL, address string, sslIns
 	case <-didReadResponse:
 		// resp or err now set
 	}
-	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		_, text, ok := strings.Cut(resp.Status, " ")
-		conn.Close()
-		if !ok {
-			return nil, errors.New("unknown status code")
-		}
-		return nil, errors.New(text)
-	}
-	return conn, nil
+	return resp, err
 }
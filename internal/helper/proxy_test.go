@@ -0,0 +1,240 @@
+package helper_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+)
+
+// serveSOCKS5 accepts one SOCKS5 connection without authentication on ln, sends the
+// address requested by the client to requested and echoes what it sends afterwards.
+func serveSOCKS5(ln net.Listener, requested chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// greeting: version, number of methods, methods
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// request: version, command, reserved, address type, address, port
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	var host string
+	switch header[3] {
+	case 1:
+		ip := make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	requested <- net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0}); err != nil {
+		return
+	}
+	_, _ = io.Copy(conn, conn)
+}
+
+// serveConnect accepts one CONNECT request on ln, sends its Proxy-Authorization header
+// to auth and tunnels the connection to the requested address.
+func serveConnect(ln net.Listener, auth chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	auth <- req.Header.Get("Proxy-Authorization")
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(target, conn)
+	}()
+	_, _ = io.Copy(conn, target)
+}
+
+func TestGetProxyConnSOCKS5PassesHostname(t *testing.T) {
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		t.Run(scheme, func(t *testing.T) {
+			c := qt.New(t)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			c.Assert(err, qt.IsNil)
+			defer ln.Close()
+			requested := make(chan string, 1)
+			go serveSOCKS5(ln, requested)
+
+			proxyURL := &url.URL{Scheme: scheme, Host: ln.Addr().String()}
+			conn, err := helper.GetProxyConn(context.Background(), (&net.Dialer{}).DialContext, proxyURL, "service.onion:80", false, nil)
+			c.Assert(err, qt.IsNil)
+			defer conn.Close()
+			c.Assert(<-requested, qt.Equals, "service.onion:80")
+
+			_, err = conn.Write([]byte("ping"))
+			c.Assert(err, qt.IsNil)
+			reply := make([]byte, 4)
+			_, err = io.ReadFull(conn, reply)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(reply), qt.Equals, "ping")
+		})
+	}
+}
+
+func TestGetProxyConnSOCKS5DialError(t *testing.T) {
+	c := qt.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	proxyURL := &url.URL{Scheme: "socks5h", Host: addr}
+	_, err = helper.GetProxyConn(context.Background(), (&net.Dialer{}).DialContext, proxyURL, "example.com:80", false, nil)
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestGetProxyChainConn(t *testing.T) {
+	c := qt.New(t)
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer first.Close()
+	auth := make(chan string, 1)
+	go serveConnect(first, auth)
+	second, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer second.Close()
+	requested := make(chan string, 1)
+	go serveSOCKS5(second, requested)
+
+	chain := []*url.URL{
+		{Scheme: "http", User: url.UserPassword("alice", "p@ss"), Host: first.Addr().String()},
+		{Scheme: "socks5h", Host: second.Addr().String()},
+	}
+	conn, err := helper.GetProxyChainConn(context.Background(), (&net.Dialer{}).DialContext, chain, "service.onion:80", false, nil)
+	c.Assert(err, qt.IsNil)
+	defer conn.Close()
+	c.Assert(<-auth, qt.Equals, "Basic YWxpY2U6cEBzcw==")
+	c.Assert(<-requested, qt.Equals, "service.onion:80")
+
+	_, err = conn.Write([]byte("ping"))
+	c.Assert(err, qt.IsNil)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(reply), qt.Equals, "ping")
+}
+
+// challengeAuth answers a challenge of the proxy with "Token <challenge>-answer".
+type challengeAuth struct {
+	started []string
+}
+
+func (a *challengeAuth) Start(proxyURL *url.URL) (helper.ProxyAuthHandshake, error) {
+	a.started = append(a.started, proxyURL.Host)
+	return a, nil
+}
+
+func (a *challengeAuth) Authorization(challenges []string) (string, error) {
+	if len(challenges) == 0 {
+		return "Token hello", nil
+	}
+	_, challenge, _ := strings.Cut(challenges[0], " ")
+	return "Token " + challenge + "-answer", nil
+}
+
+func TestGetProxyConnAuthHandshake(t *testing.T) {
+	c := qt.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer ln.Close()
+	auths := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		// the handshake takes two requests over the same connection
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		auths <- req.Header.Get("Proxy-Authorization")
+		if _, err := io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Token nonce\r\nContent-Length: 6\r\n\r\ndenied"); err != nil {
+			return
+		}
+		req, err = http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		auths <- req.Header.Get("Proxy-Authorization")
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+			return
+		}
+		_, _ = io.Copy(conn, br)
+	}()
+
+	auth := &challengeAuth{}
+	proxyURL := &url.URL{Scheme: "http", Host: ln.Addr().String()}
+	conn, err := helper.GetProxyConn(context.Background(), (&net.Dialer{}).DialContext, proxyURL, "example.com:443", false, auth)
+	c.Assert(err, qt.IsNil)
+	defer conn.Close()
+	c.Assert(auth.started, qt.DeepEquals, []string{ln.Addr().String()})
+	c.Assert(<-auths, qt.Equals, "Token hello")
+	c.Assert(<-auths, qt.Equals, "Token nonce-answer")
+
+	_, err = conn.Write([]byte("ping"))
+	c.Assert(err, qt.IsNil)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(reply), qt.Equals, "ping")
+}
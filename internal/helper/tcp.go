@@ -0,0 +1,32 @@
+package helper
+
+import (
+	"net"
+	"time"
+)
+
+// SetTCPOptions applies keep-alive and nodelay settings to c when it is a TCP connection.
+// keepAlive follows net.Dialer.KeepAlive: zero keeps the current setting, negative disables keep-alive.
+// noDelay only ever turns TCP_NODELAY on; Go already enables it by default for TCP connections.
+func SetTCPOptions(c net.Conn, keepAlive time.Duration, noDelay bool) error {
+	tcpConn, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if keepAlive < 0 {
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if keepAlive > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tcpConn.SetKeepAlivePeriod(keepAlive); err != nil {
+			return err
+		}
+	}
+	if noDelay {
+		return tcpConn.SetNoDelay(true)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package helper_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+)
+
+func TestSetTCPOptionsOnTCPConn(t *testing.T) {
+	c := qt.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	c.Assert(err, qt.IsNil)
+	defer conn.Close()
+
+	c.Assert(helper.SetTCPOptions(conn, 30*time.Second, true), qt.IsNil)
+	c.Assert(helper.SetTCPOptions(conn, -1, false), qt.IsNil)
+}
+
+func TestSetTCPOptionsIgnoresNonTCPConn(t *testing.T) {
+	c := qt.New(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c.Assert(helper.SetTCPOptions(client, 30*time.Second, true), qt.IsNil)
+}
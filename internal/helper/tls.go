@@ -1,10 +1,15 @@
 package helper
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"os"
 	"sync"
+	"time"
 )
 
 // Wireshark HTTPS parsing configuration.
@@ -28,3 +33,38 @@ func GetTLSKeyLogWriter() io.Writer {
 	})
 	return tlsKeyLogWriter
 }
+
+var errClientHelloParsed = errors.New("client hello parsed")
+
+// ClientHelloServerName returns the SNI from a raw TLS ClientHello record.
+// The record is parsed by crypto/tls on an in-memory connection, nothing is sent anywhere.
+// An empty name with a nil error means the client sent no SNI.
+func ClientHelloServerName(record []byte) (string, error) {
+	var serverName string
+	var parsed bool
+	err := tls.Server(&readOnlyConn{r: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = chi.ServerName
+			parsed = true
+			return nil, errClientHelloParsed
+		},
+	}).Handshake()
+	if parsed {
+		return serverName, nil
+	}
+	return "", err
+}
+
+// readOnlyConn is a net.Conn that reads from r and discards writes.
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c *readOnlyConn) Read(p []byte) (int, error)     { return c.r.Read(p) }
+func (*readOnlyConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (*readOnlyConn) Close() error                     { return nil }
+func (*readOnlyConn) LocalAddr() net.Addr              { return nil }
+func (*readOnlyConn) RemoteAddr() net.Addr             { return nil }
+func (*readOnlyConn) SetDeadline(time.Time) error      { return nil }
+func (*readOnlyConn) SetReadDeadline(time.Time) error  { return nil }
+func (*readOnlyConn) SetWriteDeadline(time.Time) error { return nil }
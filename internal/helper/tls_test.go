@@ -1,6 +1,10 @@
 package helper_test
 
 import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -15,3 +19,36 @@ func TestGetTLSKeyLogWriterReturnsNilWhenNotConfigured(t *testing.T) {
 
 	c.Assert(writer, qt.IsNil)
 }
+
+func captureClientHello(c *qt.C, serverName string) []byte {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		_ = tls.Client(clientConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		clientConn.Close()
+	}()
+
+	header := make([]byte, 5)
+	_, err := io.ReadFull(serverConn, header)
+	c.Assert(err, qt.IsNil)
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(header[3:5])))
+	copy(record, header)
+	_, err = io.ReadFull(serverConn, record[5:])
+	c.Assert(err, qt.IsNil)
+	return record
+}
+
+func TestClientHelloServerName(t *testing.T) {
+	c := qt.New(t)
+
+	serverName, err := helper.ClientHelloServerName(captureClientHello(c, "example.com"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(serverName, qt.Equals, "example.com")
+
+	serverName, err = helper.ClientHelloServerName(captureClientHello(c, ""))
+	c.Assert(err, qt.IsNil)
+	c.Assert(serverName, qt.Equals, "")
+
+	_, err = helper.ClientHelloServerName([]byte("GET / HTTP/1.1\r\n\r\n"))
+	c.Assert(err, qt.IsNotNil)
+}
@@ -0,0 +1,96 @@
+// Package pcapng writes packet captures in the pcapng format read by Wireshark.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// LinkTypeRaw is the link type of packets starting with an IPv4 or IPv6 header.
+const LinkTypeRaw = 101
+
+const (
+	blockTypeSectionHeader    = 0x0A0D0D0A
+	blockTypeInterface        = 0x00000001
+	blockTypeEnhancedPacket   = 0x00000006
+	byteOrderMagic            = 0x1A2B3C4D
+	optionEndOfOpt            = 0
+	optionInterfaceTSResol    = 9
+	timestampResolutionMicros = 6
+)
+
+// Writer writes a section with a single interface of LinkTypeRaw. The section and
+// interface headers are written with the first packet.
+type Writer struct {
+	w       io.Writer
+	started bool
+}
+
+// NewWriter creates a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket writes an IP packet captured at ts.
+func (w *Writer) WritePacket(ts time.Time, packet []byte) error {
+	if !w.started {
+		if err := w.writeHeaders(); err != nil {
+			return err
+		}
+		w.started = true
+	}
+
+	micros := uint64(ts.UnixMicro())
+	body := make([]byte, 20, 20+len(packet)+3)
+	binary.LittleEndian.PutUint32(body[0:], 0) // interface ID
+	binary.LittleEndian.PutUint32(body[4:], uint32(micros>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(micros))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(packet)))
+	body = append(body, packet...)
+	return w.writeBlock(blockTypeEnhancedPacket, body)
+}
+
+func (w *Writer) writeHeaders() error {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // major version
+	binary.LittleEndian.PutUint16(shb[6:], 0) // minor version
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	if err := w.writeBlock(blockTypeSectionHeader, shb); err != nil {
+		return err
+	}
+
+	idb := make([]byte, 8, 20)
+	binary.LittleEndian.PutUint16(idb[0:], LinkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[4:], 0) // no snap length limit
+	idb = appendOption(idb, optionInterfaceTSResol, []byte{timestampResolutionMicros})
+	idb = appendOption(idb, optionEndOfOpt, nil)
+	return w.writeBlock(blockTypeInterface, idb)
+}
+
+// writeBlock writes a block with body padded to 32 bits.
+func (w *Writer) writeBlock(blockType uint32, body []byte) error {
+	padded := pad(len(body))
+	total := uint32(12 + padded)
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	block = append(block, body...)
+	block = append(block, make([]byte, padded-len(body))...)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	_, err := w.w.Write(block)
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad(len(value))-len(value))...)
+}
+
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
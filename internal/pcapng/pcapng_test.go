@@ -0,0 +1,124 @@
+package pcapng_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/pcapng"
+)
+
+type block struct {
+	blockType uint32
+	body      []byte
+}
+
+func parseBlocks(c *qt.C, data []byte) []block {
+	var blocks []block
+	for len(data) > 0 {
+		c.Assert(len(data) >= 12, qt.IsTrue)
+		blockType := binary.LittleEndian.Uint32(data)
+		total := binary.LittleEndian.Uint32(data[4:])
+		c.Assert(total%4, qt.Equals, uint32(0))
+		c.Assert(binary.LittleEndian.Uint32(data[total-4:]), qt.Equals, total)
+		blocks = append(blocks, block{blockType: blockType, body: data[8 : total-4]})
+		data = data[total:]
+	}
+	return blocks
+}
+
+// onesComplementSum is zero for data that includes a valid Internet checksum.
+func onesComplementSum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func TestWriter(t *testing.T) {
+	c := qt.New(t)
+	var buf bytes.Buffer
+	w := pcapng.NewWriter(&buf)
+
+	ts := time.UnixMicro(1700000000123456)
+	c.Assert(w.WritePacket(ts, []byte{1, 2, 3, 4, 5}), qt.IsNil)
+	c.Assert(w.WritePacket(ts, []byte{6}), qt.IsNil)
+
+	blocks := parseBlocks(c, buf.Bytes())
+	c.Assert(blocks, qt.HasLen, 4)
+	c.Assert(blocks[0].blockType, qt.Equals, uint32(0x0A0D0D0A))
+	c.Assert(binary.LittleEndian.Uint32(blocks[0].body), qt.Equals, uint32(0x1A2B3C4D))
+	c.Assert(blocks[1].blockType, qt.Equals, uint32(1))
+	c.Assert(binary.LittleEndian.Uint16(blocks[1].body), qt.Equals, uint16(pcapng.LinkTypeRaw))
+
+	epb := blocks[2]
+	c.Assert(epb.blockType, qt.Equals, uint32(6))
+	micros := uint64(binary.LittleEndian.Uint32(epb.body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(epb.body[8:]))
+	c.Assert(micros, qt.Equals, uint64(1700000000123456))
+	c.Assert(binary.LittleEndian.Uint32(epb.body[12:]), qt.Equals, uint32(5))
+	c.Assert(epb.body[20:25], qt.DeepEquals, []byte{1, 2, 3, 4, 5})
+	c.Assert(blocks[3].body[20:21], qt.DeepEquals, []byte{6})
+}
+
+func TestTCPStream(t *testing.T) {
+	testCases := []struct {
+		name           string
+		client, server string
+		ipHeaderLen    int
+	}{
+		{name: "IPv4", client: "192.0.2.1:10000", server: "198.51.100.7:80", ipHeaderLen: 20},
+		{name: "IPv4-mapped", client: "[::ffff:192.0.2.1]:10000", server: "198.51.100.7:80", ipHeaderLen: 20},
+		{name: "IPv6", client: "[2001:db8::1]:10000", server: "[2001:db8::2]:80", ipHeaderLen: 40},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			server := netip.MustParseAddrPort(tc.server)
+			stream := pcapng.NewTCPStream(netip.MustParseAddrPort(tc.client), server)
+
+			request := bytes.Repeat([]byte("a"), 2000)
+			var packets [][]byte
+			packets = append(packets, stream.Open()...)
+			packets = append(packets, stream.ClientData(request)...)
+			packets = append(packets, stream.ServerData([]byte("response"))...)
+			packets = append(packets, stream.Close()...)
+			// handshake, 2 request segments + ACK, 1 response segment + ACK, FINs
+			c.Assert(packets, qt.HasLen, 3+3+2+3)
+
+			var fromClient []byte
+			for _, packet := range packets {
+				ipHeader, tcp := packet[:tc.ipHeaderLen], packet[tc.ipHeaderLen:]
+				var pseudo []byte
+				if tc.ipHeaderLen == 20 {
+					c.Assert(onesComplementSum(ipHeader), qt.Equals, uint16(0))
+					pseudo = append(append([]byte{}, ipHeader[12:20]...), 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+				} else {
+					pseudo = append(append([]byte{}, ipHeader[8:40]...), 0, 0, byte(len(tcp)>>8), byte(len(tcp)), 0, 0, 0, 6)
+				}
+				c.Assert(onesComplementSum(append(pseudo, tcp...)), qt.Equals, uint16(0))
+
+				if binary.BigEndian.Uint16(tcp[2:]) == server.Port() {
+					fromClient = append(fromClient, tcp[20:]...)
+				}
+			}
+			c.Assert(fromClient, qt.DeepEquals, request)
+
+			// sequence numbers continue across segments
+			first := packets[3][tc.ipHeaderLen:]
+			second := packets[4][tc.ipHeaderLen:]
+			c.Assert(binary.BigEndian.Uint32(second[4:])-binary.BigEndian.Uint32(first[4:]), qt.Equals, uint32(1460))
+		})
+	}
+}
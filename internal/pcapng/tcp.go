@@ -0,0 +1,160 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// maxSegmentSize is the payload size of synthetic TCP segments.
+const maxSegmentSize = 1460
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// TCPStream synthesizes the IP packets of a TCP connection between a client and a
+// server, from the handshake to the closing FINs.
+type TCPStream struct {
+	client, server       netip.AddrPort
+	clientSeq, serverSeq uint32
+}
+
+// NewTCPStream creates a stream between client and server. Both must be of the same
+// IP version; IPv4-mapped IPv6 addresses are treated as IPv4.
+func NewTCPStream(client, server netip.AddrPort) *TCPStream {
+	return &TCPStream{
+		client:    netip.AddrPortFrom(client.Addr().Unmap(), client.Port()),
+		server:    netip.AddrPortFrom(server.Addr().Unmap(), server.Port()),
+		clientSeq: 1000,
+		serverSeq: 5000,
+	}
+}
+
+// Open returns the three-way handshake.
+func (s *TCPStream) Open() [][]byte {
+	syn := s.segment(true, tcpSYN, nil)
+	s.clientSeq++
+	synAck := s.segment(false, tcpSYN|tcpACK, nil)
+	s.serverSeq++
+	ack := s.segment(true, tcpACK, nil)
+	return [][]byte{syn, synAck, ack}
+}
+
+// ClientData returns the segments carrying data from the client, with the server's ACK.
+func (s *TCPStream) ClientData(data []byte) [][]byte {
+	return s.data(true, data)
+}
+
+// ServerData returns the segments carrying data from the server, with the client's ACK.
+func (s *TCPStream) ServerData(data []byte) [][]byte {
+	return s.data(false, data)
+}
+
+// Close returns the FIN exchange, initiated by the server.
+func (s *TCPStream) Close() [][]byte {
+	serverFin := s.segment(false, tcpFIN|tcpACK, nil)
+	s.serverSeq++
+	clientFin := s.segment(true, tcpFIN|tcpACK, nil)
+	s.clientSeq++
+	ack := s.segment(false, tcpACK, nil)
+	return [][]byte{serverFin, clientFin, ack}
+}
+
+func (s *TCPStream) data(fromClient bool, data []byte) [][]byte {
+	var packets [][]byte
+	for len(data) > 0 {
+		n := min(len(data), maxSegmentSize)
+		packets = append(packets, s.segment(fromClient, tcpPSH|tcpACK, data[:n]))
+		if fromClient {
+			s.clientSeq += uint32(n)
+		} else {
+			s.serverSeq += uint32(n)
+		}
+		data = data[n:]
+	}
+	if len(packets) > 0 {
+		packets = append(packets, s.segment(!fromClient, tcpACK, nil))
+	}
+	return packets
+}
+
+func (s *TCPStream) segment(fromClient bool, flags byte, payload []byte) []byte {
+	src, dst := s.client, s.server
+	seq, ack := s.clientSeq, s.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		seq, ack = ack, seq
+	}
+	if flags&tcpACK == 0 {
+		ack = 0
+	}
+
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // data offset: 5 words
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	tcp = append(tcp, payload...)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(pseudoHeader(src.Addr(), dst.Addr(), len(tcp)), tcp))
+
+	return append(ipHeader(src.Addr(), dst.Addr(), len(tcp)), tcp...)
+}
+
+const protocolTCP = 6
+
+func ipHeader(src, dst netip.Addr, payloadLen int) []byte {
+	if src.Is4() {
+		h := make([]byte, 20)
+		h[0] = 0x45 // version 4, header length 5 words
+		binary.BigEndian.PutUint16(h[2:], uint16(20+payloadLen))
+		h[6] = 0x40 // don't fragment
+		h[8] = 64   // TTL
+		h[9] = protocolTCP
+		copy(h[12:16], src.AsSlice())
+		copy(h[16:20], dst.AsSlice())
+		binary.BigEndian.PutUint16(h[10:], checksum(nil, h))
+		return h
+	}
+	h := make([]byte, 40)
+	h[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(h[4:], uint16(payloadLen))
+	h[6] = protocolTCP
+	h[7] = 64 // hop limit
+	copy(h[8:24], src.AsSlice())
+	copy(h[24:40], dst.AsSlice())
+	return h
+}
+
+func pseudoHeader(src, dst netip.Addr, tcpLen int) []byte {
+	h := append(src.AsSlice(), dst.AsSlice()...)
+	if src.Is4() {
+		return append(h, 0, protocolTCP, byte(tcpLen>>8), byte(tcpLen))
+	}
+	h = binary.BigEndian.AppendUint32(h, uint32(tcpLen))
+	return append(h, 0, 0, 0, protocolTCP)
+}
+
+// checksum is the Internet checksum of prefix followed by data.
+func checksum(prefix, data []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(prefix) // prefixes have an even length
+	add(data)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
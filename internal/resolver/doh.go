@@ -0,0 +1,173 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxCachedHosts bounds the hosts a DoH resolver keeps the addresses of.
+const maxCachedHosts = 4096
+
+// DoH is a resolver sending its queries to a DNS-over-HTTPS server, as defined by
+// RFC 8484. It asks for the IPv4 and IPv6 addresses of a host at once and keeps them
+// for the time to live of the answers.
+type DoH struct {
+	url    string
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]dohEntry
+}
+
+type dohEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// NewDoH creates a resolver querying the DNS-over-HTTPS server at url, e.g.
+// "https://1.1.1.1/dns-query", with client, or http.DefaultClient when nil. The host of
+// url is best an IP address, a name is looked up by the client.
+func NewDoH(url string, client *http.Client) *DoH {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &DoH{
+		url:    url,
+		client: client,
+		cache:  make(map[string]dohEntry),
+	}
+}
+
+// LookupHost implements Resolver. IPv4 addresses come first.
+func (r *DoH) LookupHost(ctx context.Context, host string) ([]string, error) {
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	name, err := dnsmessage.NewName(key + ".")
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	type result struct {
+		addrs []string
+		ttl   uint32
+		err   error
+	}
+	v4, v6 := make(chan result, 1), make(chan result, 1)
+	for qtype, results := range map[dnsmessage.Type]chan result{dnsmessage.TypeA: v4, dnsmessage.TypeAAAA: v6} {
+		go func() {
+			addrs, ttl, err := r.query(ctx, name, qtype)
+			results <- result{addrs, ttl, err}
+		}()
+	}
+	a, aaaa := <-v4, <-v6
+	if a.err != nil && aaaa.err != nil {
+		return nil, &net.DNSError{Err: a.err.Error(), Name: host, Server: r.url}
+	}
+	addrs := append(a.addrs, aaaa.addrs...)
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.url, IsNotFound: true}
+	}
+
+	ttl := a.ttl
+	switch {
+	case len(a.addrs) == 0:
+		ttl = aaaa.ttl
+	case len(aaaa.addrs) > 0:
+		ttl = min(a.ttl, aaaa.ttl)
+	}
+	r.store(key, dohEntry{addrs: addrs, expires: time.Now().Add(time.Duration(ttl) * time.Second)})
+	return addrs, nil
+}
+
+func (r *DoH) store(key string, entry dohEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache) >= maxCachedHosts {
+		now := time.Now()
+		for k, e := range r.cache {
+			if !now.Before(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+		if len(r.cache) >= maxCachedHosts {
+			clear(r.cache)
+		}
+	}
+	r.cache[key] = entry
+}
+
+// query asks the server for the records of name of qtype, and returns their addresses
+// and smallest time to live. A name without such records has none.
+func (r *DoH) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]string, uint32, error) {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH server responded with %s", res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(body); err != nil {
+		return nil, 0, err
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("DoH server answered %s", reply.RCode)
+	}
+	var addrs []string
+	var ttl uint32
+	for _, answer := range reply.Answers {
+		var addr netip.Addr
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			addr = netip.AddrFrom4(body.A)
+		case *dnsmessage.AAAAResource:
+			addr = netip.AddrFrom16(body.AAAA)
+		default:
+			continue // the CNAME records leading to the addresses
+		}
+		if len(addrs) == 0 || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+		addrs = append(addrs, addr.String())
+	}
+	return addrs, ttl, nil
+}
@@ -0,0 +1,94 @@
+// Package resolver looks up the addresses of the hosts the proxy connects to, with
+// DNS servers of choice or DNS-over-HTTPS instead of the resolver of the system.
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptrace"
+)
+
+// Resolver looks up the IP addresses of a host. *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNS is a resolver sending its queries to DNS servers of choice.
+type DNS struct {
+	resolvers []*net.Resolver
+}
+
+// NewDNS creates a resolver sending its queries to the DNS servers, whose addresses
+// default to port 53. The next server is asked when one fails to answer.
+func NewDNS(servers ...string) *DNS {
+	r := &DNS{}
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.resolvers = append(r.resolvers, &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		})
+	}
+	return r
+}
+
+// LookupHost implements Resolver.
+func (r *DNS) LookupHost(ctx context.Context, host string) ([]string, error) {
+	err := errors.New("no DNS server")
+	for _, res := range r.resolvers {
+		var addrs []string
+		addrs, err = res.LookupHost(ctx, host)
+		var dnsErr *net.DNSError
+		if err == nil || errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return addrs, err
+		}
+	}
+	return nil, err
+}
+
+// Dial connects to address, looking its host up with r unless r is nil or the host is
+// an IP address, in which case it is the same as dialer.DialContext. The addresses are
+// tried in turn, and the lookup is reported to the httptrace.ClientTrace of ctx like the
+// lookups of net.Dialer.
+func Dial(ctx context.Context, dialer *net.Dialer, r Resolver, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if r == nil || err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if trace != nil && trace.DNSDone != nil {
+		info := httptrace.DNSDoneInfo{Err: err}
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil {
+				info.Addrs = append(info.Addrs, net.IPAddr{IP: ip})
+			}
+		}
+		trace.DNSDone(info)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,149 @@
+package resolver_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync/atomic"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/resolver"
+)
+
+// answer replies to the query with the A and AAAA records of hosts, or with a name error
+// for the other names.
+func answer(c *qt.C, query []byte, hosts map[string][]net.IP) []byte {
+	var msg dnsmessage.Message
+	c.Assert(msg.Unpack(query), qt.IsNil)
+	msg.Header.Response = true
+	question := msg.Questions[0]
+	ips, ok := hosts[question.Name.String()]
+	if !ok {
+		msg.Header.RCode = dnsmessage.RCodeNameError
+	}
+	for _, ip := range ips {
+		header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+		if ip4 := ip.To4(); ip4 != nil && question.Type == dnsmessage.TypeA {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+		} else if ip4 == nil && question.Type == dnsmessage.TypeAAAA {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ip)}})
+		}
+	}
+	packed, err := msg.Pack()
+	c.Assert(err, qt.IsNil)
+	return packed
+}
+
+var testHosts = map[string][]net.IP{
+	"service.internal.": {net.ParseIP("10.1.2.3"), net.ParseIP("fd00::1")},
+}
+
+func TestNewDNS(t *testing.T) {
+	c := qt.New(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(answer(c, buf[:n], testHosts), addr)
+		}
+	}()
+
+	// the first server is down
+	r := resolver.NewDNS("127.0.0.1:1", pc.LocalAddr().String())
+	addrs, err := r.LookupHost(context.Background(), "service.internal")
+	c.Assert(err, qt.IsNil)
+	c.Assert(addrs, qt.ContentEquals, []string{"10.1.2.3", "fd00::1"})
+}
+
+func TestDoH(t *testing.T) {
+	c := qt.New(t)
+	var queries atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		c.Check(r.Method, qt.Equals, http.MethodPost)
+		c.Check(r.Header.Get("Content-Type"), qt.Equals, "application/dns-message")
+		query, err := io.ReadAll(r.Body)
+		c.Check(err, qt.IsNil)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answer(c, query, testHosts))
+	}))
+	defer server.Close()
+
+	r := resolver.NewDoH(server.URL+"/dns-query", server.Client())
+	addrs, err := r.LookupHost(context.Background(), "service.internal")
+	c.Assert(err, qt.IsNil)
+	c.Assert(addrs, qt.DeepEquals, []string{"10.1.2.3", "fd00::1"})
+	c.Assert(queries.Load(), qt.Equals, int32(2))
+
+	// answers are kept for their time to live
+	addrs, err = r.LookupHost(context.Background(), "Service.Internal.")
+	c.Assert(err, qt.IsNil)
+	c.Assert(addrs, qt.HasLen, 2)
+	c.Assert(queries.Load(), qt.Equals, int32(2))
+
+	_, err = r.LookupHost(context.Background(), "missing.internal")
+	var dnsErr *net.DNSError
+	c.Assert(err, qt.ErrorAs, &dnsErr)
+	c.Assert(dnsErr.IsNotFound, qt.IsTrue)
+}
+
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	return r[host], nil
+}
+
+func TestDial(t *testing.T) {
+	c := qt.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	c.Assert(err, qt.IsNil)
+
+	var looked []string
+	var resolved []net.IPAddr
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) { looked = append(looked, info.Host) },
+		DNSDone:  func(info httptrace.DNSDoneInfo) { resolved = info.Addrs },
+	}
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+
+	// the first address refuses the connection, the second accepts it
+	r := staticResolver{"service.internal": {"127.0.0.2", "127.0.0.1"}}
+	conn, err := resolver.Dial(ctx, &net.Dialer{}, r, "tcp", net.JoinHostPort("service.internal", port))
+	if err != nil {
+		// 127.0.0.2 is not a loopback address everywhere
+		r["service.internal"] = []string{"127.0.0.1"}
+		conn, err = resolver.Dial(ctx, &net.Dialer{}, r, "tcp", net.JoinHostPort("service.internal", port))
+	}
+	c.Assert(err, qt.IsNil)
+	conn.Close()
+	c.Assert(looked[0], qt.Equals, "service.internal")
+	c.Assert(resolved[len(resolved)-1].String(), qt.Equals, "127.0.0.1")
+
+	_, err = resolver.Dial(ctx, &net.Dialer{}, r, "tcp", "unknown.internal:80")
+	var dnsErr *net.DNSError
+	c.Assert(err, qt.ErrorAs, &dnsErr)
+}
@@ -0,0 +1,253 @@
+// Package tnetstring encodes and decodes tagged netstrings, the serialization used by
+// the flow files of Python mitmproxy.
+//
+// A value is written as "<length>:<payload><tag>". Values map to Go as follows:
+//
+//	,  bytes       []byte
+//	;  text        string
+//	#  integer     int64
+//	^  float       float64
+//	!  boolean     bool
+//	~  null        nil
+//	]  list        []any
+//	}  dictionary  map[string]any
+package tnetstring
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// maxLengthDigits bounds the length prefix like the Python implementation does.
+const maxLengthDigits = 9
+
+// Marshal encodes v. Integers of any size, float32/float64, bool, nil, string, []byte,
+// []any, []string, [][]byte and map[string]any are supported. Dictionary keys are
+// written as text in sorted order.
+func Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("0:~")
+	case bool:
+		writeValue(buf, []byte(strconv.FormatBool(v)), '!')
+	case int:
+		writeValue(buf, []byte(strconv.Itoa(v)), '#')
+	case int64:
+		writeValue(buf, []byte(strconv.FormatInt(v, 10)), '#')
+	case uint16:
+		writeValue(buf, []byte(strconv.FormatUint(uint64(v), 10)), '#')
+	case uint64:
+		writeValue(buf, []byte(strconv.FormatUint(v, 10)), '#')
+	case float32:
+		writeValue(buf, []byte(strconv.FormatFloat(float64(v), 'f', -1, 32)), '^')
+	case float64:
+		writeValue(buf, []byte(strconv.FormatFloat(v, 'f', -1, 64)), '^')
+	case string:
+		writeValue(buf, []byte(v), ';')
+	case []byte:
+		writeValue(buf, v, ',')
+	case []string:
+		items := make([]any, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return encode(buf, items)
+	case [][]byte:
+		items := make([]any, len(v))
+		for i, b := range v {
+			items[i] = b
+		}
+		return encode(buf, items)
+	case []any:
+		var payload bytes.Buffer
+		for _, item := range v {
+			if err := encode(&payload, item); err != nil {
+				return err
+			}
+		}
+		writeValue(buf, payload.Bytes(), ']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		var payload bytes.Buffer
+		for _, k := range keys {
+			writeValue(&payload, []byte(k), ';')
+			if err := encode(&payload, v[k]); err != nil {
+				return fmt.Errorf("key %q: %w", k, err)
+			}
+		}
+		writeValue(buf, payload.Bytes(), '}')
+	default:
+		return fmt.Errorf("tnetstring: unsupported type %T", v)
+	}
+	return nil
+}
+
+func writeValue(buf *bytes.Buffer, payload []byte, tag byte) {
+	buf.WriteString(strconv.Itoa(len(payload)))
+	buf.WriteByte(':')
+	buf.Write(payload)
+	buf.WriteByte(tag)
+}
+
+// Unmarshal decodes the single value in data.
+func Unmarshal(data []byte) (any, error) {
+	v, rest, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("tnetstring: trailing data")
+	}
+	return v, nil
+}
+
+// Decoder reads consecutive values from a stream, such as a flow file.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder creates a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next value. It returns io.EOF when the stream ends between values.
+func (d *Decoder) Decode() (any, error) {
+	prefix, err := d.r.ReadSlice(':')
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(prefix) == 0 {
+			return nil, io.EOF
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, bufio.ErrBufferFull) {
+			return nil, errors.New("tnetstring: malformed length")
+		}
+		return nil, err
+	}
+	length, err := parseLength(prefix[:len(prefix)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, len(prefix)+length+1)
+	copy(data, prefix)
+	if _, err := io.ReadFull(d.r, data[len(prefix):]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return Unmarshal(data)
+}
+
+func parseLength(digits []byte) (int, error) {
+	if len(digits) == 0 || len(digits) > maxLengthDigits {
+		return 0, errors.New("tnetstring: malformed length")
+	}
+	length := 0
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, errors.New("tnetstring: malformed length")
+		}
+		length = length*10 + int(c-'0')
+	}
+	return length, nil
+}
+
+func parse(data []byte) (any, []byte, error) {
+	colon := bytes.IndexByte(data, ':')
+	if colon < 0 {
+		return nil, nil, errors.New("tnetstring: malformed length")
+	}
+	length, err := parseLength(data[:colon])
+	if err != nil {
+		return nil, nil, err
+	}
+	data = data[colon+1:]
+	if len(data) < length+1 {
+		return nil, nil, errors.New("tnetstring: value is truncated")
+	}
+	payload, tag, rest := data[:length], data[length], data[length+1:]
+
+	switch tag {
+	case ',':
+		return bytes.Clone(payload), rest, nil
+	case ';':
+		return string(payload), rest, nil
+	case '#':
+		n, err := strconv.ParseInt(string(payload), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tnetstring: invalid integer %q", payload)
+		}
+		return n, rest, nil
+	case '^':
+		f, err := strconv.ParseFloat(string(payload), 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tnetstring: invalid float %q", payload)
+		}
+		return f, rest, nil
+	case '!':
+		switch string(payload) {
+		case "true":
+			return true, rest, nil
+		case "false":
+			return false, rest, nil
+		}
+		return nil, nil, fmt.Errorf("tnetstring: invalid boolean %q", payload)
+	case '~':
+		if length != 0 {
+			return nil, nil, errors.New("tnetstring: null with payload")
+		}
+		return nil, rest, nil
+	case ']':
+		list := []any{}
+		for len(payload) > 0 {
+			var item any
+			if item, payload, err = parse(payload); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, rest, nil
+	case '}':
+		dict := map[string]any{}
+		for len(payload) > 0 {
+			var key, value any
+			if key, payload, err = parse(payload); err != nil {
+				return nil, nil, err
+			}
+			if len(payload) == 0 {
+				return nil, nil, errors.New("tnetstring: dictionary key without value")
+			}
+			if value, payload, err = parse(payload); err != nil {
+				return nil, nil, err
+			}
+			switch key := key.(type) {
+			case string:
+				dict[key] = value
+			case []byte:
+				dict[string(key)] = value
+			default:
+				return nil, nil, fmt.Errorf("tnetstring: invalid dictionary key type %T", key)
+			}
+		}
+		return dict, rest, nil
+	}
+	return nil, nil, fmt.Errorf("tnetstring: unknown type tag %q", tag)
+}
@@ -0,0 +1,126 @@
+package tnetstring_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/tnetstring"
+)
+
+func TestMarshal(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{name: "null", value: nil, want: "0:~"},
+		{name: "true", value: true, want: "4:true!"},
+		{name: "false", value: false, want: "5:false!"},
+		{name: "integer", value: 12345, want: "5:12345#"},
+		{name: "negative integer", value: int64(-7), want: "2:-7#"},
+		{name: "float", value: 1.5, want: "3:1.5^"},
+		{name: "text", value: "héllo", want: "6:héllo;"},
+		{name: "bytes", value: []byte("hello"), want: "5:hello,"},
+		{name: "empty list", value: []any{}, want: "0:]"},
+		{name: "list", value: []any{"a", []byte("b"), 1}, want: "12:1:a;1:b,1:1#]"},
+		{name: "dictionary sorted by key", value: map[string]any{"b": 2, "a": nil}, want: "15:1:a;0:~1:b;1:2#}"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			got, err := tnetstring.Marshal(tc.value)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(got), qt.Equals, tc.want)
+		})
+	}
+}
+
+func TestMarshalUnsupportedType(t *testing.T) {
+	c := qt.New(t)
+	_, err := tnetstring.Marshal(map[string]any{"k": struct{}{}})
+	c.Assert(err, qt.ErrorMatches, `key "k": tnetstring: unsupported type struct {}`)
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	value := map[string]any{
+		"bytes":  []byte("\x00\xff"),
+		"text":   "text",
+		"int":    int64(42),
+		"float":  0.25,
+		"bool":   true,
+		"null":   nil,
+		"list":   []any{int64(1), []any{}, map[string]any{}},
+		"nested": map[string]any{"k": []byte("v")},
+	}
+	data, err := tnetstring.Marshal(value)
+	c.Assert(err, qt.IsNil)
+
+	got, err := tnetstring.Unmarshal(data)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.DeepEquals, value)
+}
+
+func TestUnmarshalBytesKeys(t *testing.T) {
+	c := qt.New(t)
+	// Python 2 era files use byte strings as dictionary keys.
+	got, err := tnetstring.Unmarshal([]byte("8:1:k,1:v,}"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.DeepEquals, map[string]any{"k": []byte("v")})
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "no length", data: "abc", wantErr: `tnetstring: malformed length`},
+		{name: "bad length", data: "1x:a,", wantErr: `tnetstring: malformed length`},
+		{name: "too long length", data: "1234567890:a,", wantErr: `tnetstring: malformed length`},
+		{name: "truncated", data: "5:abc,", wantErr: `tnetstring: value is truncated`},
+		{name: "unknown tag", data: "1:a?", wantErr: `tnetstring: unknown type tag '\?'`},
+		{name: "bad integer", data: "1:a#", wantErr: `tnetstring: invalid integer "a"`},
+		{name: "bad boolean", data: "3:yes!", wantErr: `tnetstring: invalid boolean "yes"`},
+		{name: "key without value", data: "4:1:k;}", wantErr: `tnetstring: dictionary key without value`},
+		{name: "trailing data", data: "0:~0:~", wantErr: `tnetstring: trailing data`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			_, err := tnetstring.Unmarshal([]byte(tc.data))
+			c.Assert(err, qt.ErrorMatches, tc.wantErr)
+		})
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	c := qt.New(t)
+	d := tnetstring.NewDecoder(bytes.NewBufferString("5:hello,2:42#0:~"))
+
+	v, err := d.Decode()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.DeepEquals, []byte("hello"))
+	v, err = d.Decode()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, int64(42))
+	v, err = d.Decode()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.IsNil)
+	_, err = d.Decode()
+	c.Assert(err, qt.Equals, io.EOF)
+}
+
+func TestDecoderTruncatedStream(t *testing.T) {
+	c := qt.New(t)
+	_, err := tnetstring.NewDecoder(bytes.NewBufferString("5:hel")).Decode()
+	c.Assert(err, qt.Equals, io.ErrUnexpectedEOF)
+
+	_, err = tnetstring.NewDecoder(bytes.NewBufferString("12")).Decode()
+	c.Assert(err, qt.ErrorMatches, `tnetstring: malformed length`)
+}
@@ -0,0 +1,34 @@
+package addons
+
+import (
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// AntiCache makes clients fetch full responses every time, so that the proxy sees
+// every body, e.g. to follow them in the web interface. It removes the conditions of
+// requests that let servers answer 304 Not Modified, and the caching headers of
+// responses, Last-Modified included, since clients cache heuristically with it.
+type AntiCache struct {
+	proxy.BaseAddon
+}
+
+func NewAntiCache() *AntiCache {
+	return &AntiCache{}
+}
+
+func (*AntiCache) Requestheaders(f *proxy.Flow) {
+	if f.Request.Header == nil {
+		return
+	}
+	f.Request.Header.Del("If-None-Match")
+	f.Request.Header.Del("If-Modified-Since")
+}
+
+func (*AntiCache) Responseheaders(f *proxy.Flow) {
+	if f.Response == nil || f.Response.Header == nil {
+		return
+	}
+	for _, name := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified"} {
+		f.Response.Header.Del(name)
+	}
+}
@@ -0,0 +1,33 @@
+package addons_test
+
+import (
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func TestAntiCache(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewAntiCache()
+	f := newJSONLFlow(c, nil, nil)
+	f.Request.Header.Set("If-None-Match", `"v1"`)
+	f.Request.Header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+	f.Response.Header.Set("Cache-Control", "max-age=3600")
+	f.Response.Header.Set("Expires", "Wed, 21 Oct 2015 08:28:00 GMT")
+	f.Response.Header.Set("ETag", `"v1"`)
+	f.Response.Header.Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	adn.Requestheaders(f)
+	adn.Responseheaders(f)
+	c.Assert(f.Request.Header, qt.DeepEquals, http.Header{"Content-Type": {"application/octet-stream"}})
+	c.Assert(f.Response.Header, qt.DeepEquals, http.Header{"Content-Type": {"application/json"}})
+
+	// no response yet, e.g. when an earlier addon failed the flow
+	noResponse := proxy.NewFlow()
+	noResponse.Request = f.Request
+	adn.Responseheaders(noResponse)
+}
@@ -0,0 +1,199 @@
+package addons
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/match"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// Block list rule:
+//   Hosts and paths match either a wildcard pattern (Host, Path) or a regular
+//   expression (HostRegex, PathRegex). Host matches the host name without the port.
+//   The first enabled item matching a request decides: an Allow item lets it through,
+//   any other item answers it with its Response, or a 403 without one.
+//   With BlockUnmatched, requests matching no item are blocked with UnmatchedResponse,
+//   turning the list into an allow list.
+
+type blockFrom struct {
+	Method    []string
+	Host      string
+	HostRegex string
+	Path      string
+	PathRegex string
+
+	hostRegex *regexp.Regexp
+	pathRegex *regexp.Regexp
+}
+
+func (bf *blockFrom) compile() error {
+	var err error
+	if bf.HostRegex != "" {
+		if bf.hostRegex, err = regexp.Compile(bf.HostRegex); err != nil {
+			return fmt.Errorf("invalid HostRegex: %w", err)
+		}
+	}
+	if bf.PathRegex != "" {
+		if bf.pathRegex, err = regexp.Compile(bf.PathRegex); err != nil {
+			return fmt.Errorf("invalid PathRegex: %w", err)
+		}
+	}
+	return nil
+}
+
+func (bf *blockFrom) match(req *proxy.Request) bool {
+	if len(bf.Method) > 0 && !lo.Contains(bf.Method, req.Method) {
+		return false
+	}
+	host := req.URL.Hostname()
+	if bf.Host != "" && !match.Match(host, bf.Host) {
+		return false
+	}
+	if bf.hostRegex != nil && !bf.hostRegex.MatchString(host) {
+		return false
+	}
+	if bf.Path != "" && !match.Match(req.URL.Path, bf.Path) {
+		return false
+	}
+	if bf.pathRegex != nil && !bf.pathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+	return true
+}
+
+type blockResponse struct {
+	StatusCode int
+	Header     map[string]string
+	Body       string
+}
+
+// validStatusCode reports whether the status code is unset or a valid one.
+func (br *blockResponse) validStatusCode() bool {
+	return br == nil || br.StatusCode == 0 || (br.StatusCode >= 100 && br.StatusCode <= 999)
+}
+
+func (br *blockResponse) response() *proxy.Response {
+	if br == nil {
+		br = &blockResponse{}
+	}
+	status := br.StatusCode
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	header := make(http.Header)
+	for name, value := range br.Header {
+		header.Set(name, value)
+	}
+	if br.Body != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return proxy.NewResponse(status, header, []byte(br.Body))
+}
+
+type blockItem struct {
+	From     *blockFrom
+	Allow    bool
+	Response *blockResponse
+	Enable   bool
+}
+
+// BlockList blocks requests to matching hosts and paths with a custom response.
+type BlockList struct {
+	proxy.BaseAddon
+	Items             []*blockItem
+	BlockUnmatched    bool
+	UnmatchedResponse *blockResponse
+	Enable            bool
+
+	mu       sync.RWMutex // guards the exported fields during reloads
+	filename string
+}
+
+func (bl *BlockList) Requestheaders(f *proxy.Flow) {
+	bl.mu.RLock()
+	enable, items := bl.Enable, bl.Items
+	blockUnmatched, unmatchedResponse := bl.BlockUnmatched, bl.UnmatchedResponse
+	bl.mu.RUnlock()
+	if !enable {
+		return
+	}
+	for _, item := range items {
+		if !item.Enable || !item.From.match(f.Request) {
+			continue
+		}
+		if !item.Allow {
+			slog.Info("block list blocked", "url", f.Request.URL.String())
+			f.Response = item.Response.response()
+		}
+		return
+	}
+	if blockUnmatched {
+		slog.Info("block list blocked unmatched", "url", f.Request.URL.String())
+		f.Response = unmatchedResponse.response()
+	}
+}
+
+func (bl *BlockList) validate() error {
+	for i, item := range bl.Items {
+		if item.From == nil {
+			return fmt.Errorf("%v no item.From", i)
+		}
+		if err := item.From.compile(); err != nil {
+			return fmt.Errorf("%v %w", i, err)
+		}
+		if item.Allow && item.Response != nil {
+			return fmt.Errorf("%v allow item with item.Response", i)
+		}
+		if !item.Response.validStatusCode() {
+			return fmt.Errorf("%v invalid item.Response.StatusCode %v", i, item.Response.StatusCode)
+		}
+	}
+	if !bl.UnmatchedResponse.validStatusCode() {
+		return fmt.Errorf("invalid UnmatchedResponse.StatusCode %v", bl.UnmatchedResponse.StatusCode)
+	}
+	return nil
+}
+
+func NewBlockListFromFile(filename string) (*BlockList, error) {
+	var blockList BlockList
+	if err := helper.NewStructFromFile(filename, &blockList); err != nil {
+		return nil, err
+	}
+	if err := blockList.validate(); err != nil {
+		return nil, err
+	}
+	blockList.filename = filename
+	return &blockList, nil
+}
+
+// ConfigFile returns the file the BlockList was loaded from.
+func (bl *BlockList) ConfigFile() string {
+	return bl.filename
+}
+
+// Reload loads the items of the file again, keeping the current ones if it is invalid.
+func (bl *BlockList) Reload() error {
+	loaded, err := NewBlockListFromFile(bl.filename)
+	if err != nil {
+		return err
+	}
+	bl.mu.Lock()
+	bl.Items, bl.Enable = loaded.Items, loaded.Enable
+	bl.BlockUnmatched, bl.UnmatchedResponse = loaded.BlockUnmatched, loaded.UnmatchedResponse
+	bl.mu.Unlock()
+	return nil
+}
+
+// ValidateBlockListFile returns the error of loading filename as a block list, such
+// as a pattern that does not compile.
+func ValidateBlockListFile(filename string) error {
+	_, err := NewBlockListFromFile(filename)
+	return err
+}
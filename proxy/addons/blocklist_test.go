@@ -0,0 +1,90 @@
+package addons_test
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newBlockList(c *qt.C, config string) *addons.BlockList {
+	filename := filepath.Join(c.TempDir(), "block.json")
+	c.Assert(os.WriteFile(filename, []byte(config), 0o644), qt.IsNil)
+	bl, err := addons.NewBlockListFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	return bl
+}
+
+// blockListResponse returns the response bl answers a GET for rawURL with, nil if it passes.
+func blockListResponse(c *qt.C, bl *addons.BlockList, rawURL string) *proxy.Response {
+	u, err := url.Parse(rawURL)
+	c.Assert(err, qt.IsNil)
+	f := proxy.NewFlow()
+	f.Request = &proxy.Request{Method: "GET", URL: u}
+	bl.Requestheaders(f)
+	return f.Response
+}
+
+func TestBlockList(t *testing.T) {
+	c := qt.New(t)
+	bl := newBlockList(c, `{"Enable":true,"Items":[
+		{"Enable":true,"Allow":true,"From":{"Host":"ads.example.com","Path":"/allowed/*"}},
+		{"Enable":true,"From":{"Host":"*.example.com"},"Response":{"StatusCode":451,"Header":{"X-Blocked":"yes"},"Body":"unavailable"}},
+		{"Enable":true,"From":{"HostRegex":"^track[0-9]+\\.net$","PathRegex":"^/pixel"}},
+		{"Enable":false,"From":{"Host":"disabled.org"}}
+	]}`)
+
+	res := blockListResponse(c, bl, "https://ads.example.com/banner")
+	c.Assert(res, qt.Not(qt.IsNil))
+	c.Assert(res.StatusCode, qt.Equals, 451)
+	c.Assert(res.Header.Get("X-Blocked"), qt.Equals, "yes")
+	c.Assert(res.Header.Get("Content-Type"), qt.Equals, "text/plain; charset=utf-8")
+	c.Assert(string(res.Body), qt.Equals, "unavailable")
+
+	// the first matching item decides
+	c.Assert(blockListResponse(c, bl, "https://ads.example.com/allowed/x"), qt.IsNil)
+
+	res = blockListResponse(c, bl, "http://track42.net:8080/pixel.gif")
+	c.Assert(res, qt.Not(qt.IsNil))
+	c.Assert(res.StatusCode, qt.Equals, 403)
+	c.Assert(blockListResponse(c, bl, "http://track42.net/page"), qt.IsNil)
+
+	c.Assert(blockListResponse(c, bl, "https://disabled.org/"), qt.IsNil)
+	c.Assert(blockListResponse(c, bl, "https://example.org/"), qt.IsNil)
+}
+
+func TestBlockListBlockUnmatched(t *testing.T) {
+	c := qt.New(t)
+	bl := newBlockList(c, `{"Enable":true,"BlockUnmatched":true,"UnmatchedResponse":{"StatusCode":404},"Items":[
+		{"Enable":true,"Allow":true,"From":{"Host":"api.example.com","Method":["GET"]}}
+	]}`)
+
+	c.Assert(blockListResponse(c, bl, "https://api.example.com/users"), qt.IsNil)
+	res := blockListResponse(c, bl, "https://cdn.example.com/")
+	c.Assert(res, qt.Not(qt.IsNil))
+	c.Assert(res.StatusCode, qt.Equals, 404)
+	c.Assert(res.Body, qt.HasLen, 0)
+}
+
+func TestValidateBlockListFile(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	for config, want := range map[string]string{
+		`{"Items":[{"Enable":true}]}`:                                                      "0 no item.From",
+		`{"Items":[{"Enable":true,"From":{"PathRegex":"("}}]}`:                             "0 invalid PathRegex: .*",
+		`{"Items":[{"Enable":true,"Allow":true,"From":{},"Response":{"StatusCode":403}}]}`: "0 allow item with item.Response",
+		`{"Items":[{"Enable":true,"From":{},"Response":{"StatusCode":42}}]}`:               "0 invalid item.Response.StatusCode 42",
+	} {
+		filename := filepath.Join(dir, "block.json")
+		c.Assert(os.WriteFile(filename, []byte(config), 0o644), qt.IsNil)
+		c.Assert(addons.ValidateBlockListFile(filename), qt.ErrorMatches, want, qt.Commentf(config))
+	}
+
+	c.Assert(addons.ValidateBlockListFile(filepath.Join(dir, "missing.json")), qt.IsNotNil)
+}
@@ -0,0 +1,344 @@
+package addons
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sync"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// Body rewrite rule:
+//   Every enabled item whose From matches the request applies its substitutions, in
+//   file order: Request substitutions to the request body, Response substitutions to
+//   the response body. Find is a literal string, or a regular expression with Regex,
+//   in which case Replace may refer to submatches as in regexp.Expand ("$1").
+//   Buffered bodies are decoded, rewritten and encoded again. Streamed bodies are
+//   rewritten on the fly, a chunk at a time: a match may span chunks, but must not be
+//   longer than MaxMatchLength bytes (1024 for regular expressions by default, the
+//   length of Find for literals). Streamed bodies with a Content-Encoding are passed
+//   through unchanged.
+
+// defaultMaxRegexMatchLength is the longest match of a regular expression found in
+// streamed bodies when the rule does not set MaxMatchLength.
+const defaultMaxRegexMatchLength = 1024
+
+// bodyRewriteChunkSize is the size of the reads from streamed bodies.
+const bodyRewriteChunkSize = 32 * 1024
+
+type bodySubstitution struct {
+	Find           string
+	Regex          bool
+	Replace        string
+	MaxMatchLength int
+
+	re *regexp.Regexp
+}
+
+func (sub *bodySubstitution) compile() error {
+	if sub.Find == "" {
+		return errors.New("without Find")
+	}
+	if sub.MaxMatchLength < 0 {
+		return fmt.Errorf("negative MaxMatchLength %v", sub.MaxMatchLength)
+	}
+	pattern := sub.Find
+	if !sub.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid Find: %w", err)
+	}
+	// an empty match would never let a stream make progress
+	if re.MatchString("") {
+		return fmt.Errorf("empty string matched by Find %q", sub.Find)
+	}
+	sub.re = re
+	return nil
+}
+
+// window is the number of bytes at the end of a streamed chunk that are held back,
+// because a match there might continue in the next chunk.
+func (sub *bodySubstitution) window() int {
+	if sub.MaxMatchLength > 0 {
+		return sub.MaxMatchLength
+	}
+	if sub.Regex {
+		return defaultMaxRegexMatchLength
+	}
+	return len(sub.Find)
+}
+
+func (sub *bodySubstitution) replaceAll(body []byte) []byte {
+	if sub.Regex {
+		return sub.re.ReplaceAll(body, []byte(sub.Replace))
+	}
+	return sub.re.ReplaceAllLiteral(body, []byte(sub.Replace))
+}
+
+// rewrite appends buf with its matches replaced to dst, up to the point where a match
+// could still continue past the end of buf, and returns the rest of buf to scan again
+// once more of the body has arrived. With final, buf is the end of the body and
+// everything is rewritten.
+func (sub *bodySubstitution) rewrite(dst, buf []byte, final bool) ([]byte, []byte) {
+	limit := len(buf)
+	if !final {
+		limit -= sub.window()
+		if limit <= 0 {
+			return dst, buf
+		}
+	}
+	cut, last := limit, 0
+	for _, m := range sub.re.FindAllSubmatchIndex(buf, -1) {
+		if m[0] >= limit {
+			break
+		}
+		if m[1] > limit && !final {
+			// the match might be longer with the next chunk
+			cut = m[0]
+			break
+		}
+		dst = append(dst, buf[last:m[0]]...)
+		if sub.Regex {
+			dst = sub.re.Expand(dst, []byte(sub.Replace), buf, m)
+		} else {
+			dst = append(dst, sub.Replace...)
+		}
+		last = m[1]
+	}
+	if final {
+		cut = len(buf)
+	}
+	dst = append(dst, buf[last:cut]...)
+	return dst, buf[cut:]
+}
+
+// rewriteReader applies a substitution to the body read from r.
+type rewriteReader struct {
+	r   io.Reader
+	sub *bodySubstitution
+
+	chunk []byte
+	buf   []byte // read, not scanned to the end yet
+	out   []byte // rewritten, not returned yet
+	err   error
+}
+
+func newRewriteReader(r io.Reader, sub *bodySubstitution) *rewriteReader {
+	return &rewriteReader{r: r, sub: sub, chunk: make([]byte, bodyRewriteChunkSize)}
+}
+
+func (rr *rewriteReader) Read(p []byte) (int, error) {
+	for len(rr.out) == 0 {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		n, err := rr.r.Read(rr.chunk)
+		rr.buf = append(rr.buf, rr.chunk[:n]...)
+		if err != nil {
+			rr.err = err
+		}
+		var rest []byte
+		rr.out, rest = rr.sub.rewrite(rr.out[:0], rr.buf, err != nil)
+		rr.buf = append(rr.buf[:0], rest...)
+	}
+	n := copy(p, rr.out)
+	rr.out = rr.out[n:]
+	return n, nil
+}
+
+type bodyRewriteItem struct {
+	From     *mapFrom
+	Request  []*bodySubstitution
+	Response []*bodySubstitution
+	Enable   bool
+}
+
+// BodyRewrite applies literal or regular expression substitutions to request and
+// response bodies, including streamed ones too large to be buffered.
+type BodyRewrite struct {
+	proxy.BaseAddon
+	Items  []*bodyRewriteItem
+	Enable bool
+
+	mu       sync.RWMutex // guards Items and Enable during reloads
+	filename string
+}
+
+// substitutions returns the request or response substitutions of the items matching req.
+func (br *BodyRewrite) substitutions(req *proxy.Request, response bool) []*bodySubstitution {
+	br.mu.RLock()
+	enable, items := br.Enable, br.Items
+	br.mu.RUnlock()
+	if !enable {
+		return nil
+	}
+	var subs []*bodySubstitution
+	for _, item := range items {
+		if !item.Enable || !item.From.match(req) {
+			continue
+		}
+		if response {
+			subs = append(subs, item.Response...)
+		} else {
+			subs = append(subs, item.Request...)
+		}
+	}
+	return subs
+}
+
+func (br *BodyRewrite) Request(f *proxy.Flow) {
+	subs := br.substitutions(f.Request, false)
+	if len(subs) == 0 || len(f.Request.Body) == 0 {
+		return
+	}
+	body, err := f.Request.DecodedBody()
+	if err != nil {
+		return
+	}
+	for _, sub := range subs {
+		body = sub.replaceAll(body)
+	}
+	f.Request.Body = body
+	if err := f.Request.ReencodeBody(f.Request.Header.Get("Content-Encoding")); err != nil {
+		slog.Error("body rewrite request encode error", "url", f.Request.URL.String(), "error", err)
+		return
+	}
+	slog.Debug("body rewrite request", "url", f.Request.URL.String())
+}
+
+func (br *BodyRewrite) Response(f *proxy.Flow) {
+	if f.Response == nil || f.Stream {
+		return
+	}
+	subs := br.substitutions(f.Request, true)
+	if len(subs) == 0 || len(f.Response.Body) == 0 {
+		return
+	}
+	body, err := f.Response.DecodedBody()
+	if err != nil {
+		return
+	}
+	for _, sub := range subs {
+		body = sub.replaceAll(body)
+	}
+	f.Response.Body = body
+	if err := f.Response.ReencodeBody(f.Response.Header.Get("Content-Encoding")); err != nil {
+		slog.Error("body rewrite response encode error", "url", f.Request.URL.String(), "error", err)
+		return
+	}
+	slog.Debug("body rewrite response", "url", f.Request.URL.String())
+}
+
+func (br *BodyRewrite) StreamRequestModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	// buffered request bodies are rewritten in Request
+	if !f.Stream || in == nil {
+		return in
+	}
+	subs := br.substitutions(f.Request, false)
+	if len(subs) == 0 {
+		return in
+	}
+	if !identityEncoded(f.Request.Header.Get("Content-Encoding")) {
+		slog.Debug("body rewrite skips encoded request stream", "url", f.Request.URL.String())
+		return in
+	}
+	f.Request.Header.Del("Content-Length")
+	slog.Debug("body rewrite request stream", "url", f.Request.URL.String())
+	return chainRewriteReaders(in, subs)
+}
+
+func (br *BodyRewrite) StreamResponseModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	// buffered response bodies are rewritten in Response
+	if !f.Stream || in == nil || f.Response == nil {
+		return in
+	}
+	subs := br.substitutions(f.Request, true)
+	if len(subs) == 0 {
+		return in
+	}
+	if !identityEncoded(f.Response.Header.Get("Content-Encoding")) {
+		slog.Debug("body rewrite skips encoded response stream", "url", f.Request.URL.String())
+		return in
+	}
+	f.Response.Header.Del("Content-Length")
+	slog.Debug("body rewrite response stream", "url", f.Request.URL.String())
+	return chainRewriteReaders(in, subs)
+}
+
+func chainRewriteReaders(r io.Reader, subs []*bodySubstitution) io.Reader {
+	for _, sub := range subs {
+		r = newRewriteReader(r, sub)
+	}
+	return r
+}
+
+func identityEncoded(encoding string) bool {
+	return encoding == "" || encoding == "identity"
+}
+
+func (br *BodyRewrite) validate() error {
+	for i, item := range br.Items {
+		if item.From == nil {
+			return fmt.Errorf("%v no item.From", i)
+		}
+		if item.From.Protocol != "" && item.From.Protocol != "http" && item.From.Protocol != "https" {
+			return fmt.Errorf("%v invalid item.From.Protocol %v", i, item.From.Protocol)
+		}
+		if len(item.Request) == 0 && len(item.Response) == 0 {
+			return fmt.Errorf("%v no item.Request or item.Response substitutions", i)
+		}
+		for _, subs := range [][]*bodySubstitution{item.Request, item.Response} {
+			for j, sub := range subs {
+				if sub == nil {
+					return fmt.Errorf("%v substitution %v empty", i, j)
+				}
+				if err := sub.compile(); err != nil {
+					return fmt.Errorf("%v substitution %v %w", i, j, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func NewBodyRewriteFromFile(filename string) (*BodyRewrite, error) {
+	var bodyRewrite BodyRewrite
+	if err := helper.NewStructFromFile(filename, &bodyRewrite); err != nil {
+		return nil, err
+	}
+	if err := bodyRewrite.validate(); err != nil {
+		return nil, err
+	}
+	bodyRewrite.filename = filename
+	return &bodyRewrite, nil
+}
+
+// ConfigFile returns the file the BodyRewrite was loaded from.
+func (br *BodyRewrite) ConfigFile() string {
+	return br.filename
+}
+
+// Reload loads the items of the file again, keeping the current ones if it is invalid.
+func (br *BodyRewrite) Reload() error {
+	loaded, err := NewBodyRewriteFromFile(br.filename)
+	if err != nil {
+		return err
+	}
+	br.mu.Lock()
+	br.Items, br.Enable = loaded.Items, loaded.Enable
+	br.mu.Unlock()
+	return nil
+}
+
+// ValidateBodyRewriteFile returns the error of loading filename as a body rewrite
+// config, such as a regular expression that does not compile.
+func ValidateBodyRewriteFile(filename string) error {
+	_, err := NewBodyRewriteFromFile(filename)
+	return err
+}
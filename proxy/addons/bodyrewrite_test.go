@@ -0,0 +1,135 @@
+package addons_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newBodyRewrite(c *qt.C, config string) *addons.BodyRewrite {
+	c.Helper()
+	filename := filepath.Join(c.TempDir(), "bodies.json")
+	c.Assert(os.WriteFile(filename, []byte(config), 0o644), qt.IsNil)
+	br, err := addons.NewBodyRewriteFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	return br
+}
+
+func TestBodyRewriteBuffered(t *testing.T) {
+	c := qt.New(t)
+	br := newBodyRewrite(c, `{"Enable":true,"Items":[
+		{"Enable":true,"From":{"Host":"example.com"},
+		 "Request":[{"Find":"secret","Replace":"***"}],
+		 "Response":[{"Find":"a.b","Replace":"$0"},{"Find":"id=(\\d+)","Regex":true,"Replace":"id=<$1>"}]},
+		{"Enable":true,"From":{"Host":"other.com"},"Response":[{"Find":"ok","Replace":"KO"}]}
+	]}`)
+
+	f := newJSONLFlow(c, []byte("user=secret"), []byte(`{"a.b":"axb","q":"id=42"}`))
+	br.Request(f)
+	br.Response(f)
+	c.Assert(string(f.Request.Body), qt.Equals, "user=***")
+	c.Assert(f.Request.Header.Get("Content-Length"), qt.Equals, "8")
+	// literals are not patterns and their replacements are not expanded
+	c.Assert(string(f.Response.Body), qt.Equals, `{"$0":"axb","q":"id=<42>"}`)
+	c.Assert(f.Response.Header.Get("Content-Length"), qt.Equals, "26")
+}
+
+func TestBodyRewriteBufferedEncoded(t *testing.T) {
+	c := qt.New(t)
+	br := newBodyRewrite(c, `{"Enable":true,"Items":[
+		{"Enable":true,"From":{},"Response":[{"Find":"hello","Replace":"bye"}]}
+	]}`)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, err := w.Write([]byte("hello world"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(w.Close(), qt.IsNil)
+	f := newJSONLFlow(c, nil, gz.Bytes())
+	f.Response.Header.Set("Content-Encoding", "gzip")
+
+	br.Response(f)
+	c.Assert(f.Response.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+	body, err := f.Response.DecodedBody()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "bye world")
+}
+
+func TestBodyRewriteStream(t *testing.T) {
+	c := qt.New(t)
+	br := newBodyRewrite(c, `{"Enable":true,"Items":[
+		{"Enable":true,"From":{},
+		 "Request":[{"Find":"token","Replace":"xx"}],
+		 "Response":[{"Find":"needle","Replace":"pin"},{"Find":"n(\\d+)x","Regex":true,"Replace":"[$1]","MaxMatchLength":16}]}
+	]}`)
+
+	// matches straddle every read boundary when the body arrives a byte at a time
+	body := strings.Repeat("hay needle n123x ", 5000)
+	want := strings.Repeat("hay pin [123] ", 5000)
+	for _, slow := range []bool{false, true} {
+		f := newJSONLFlow(c, nil, nil)
+		f.Stream = true
+		f.Response.Header.Set("Content-Length", "85000")
+		var in io.Reader = strings.NewReader(body)
+		if slow {
+			in = iotest.OneByteReader(in)
+		}
+		got, err := io.ReadAll(br.StreamResponseModifier(f, in))
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(got), qt.Equals, want, qt.Commentf("slow %v", slow))
+		c.Assert(f.Response.Header.Get("Content-Length"), qt.Equals, "")
+
+		got, err = io.ReadAll(br.StreamRequestModifier(f, strings.NewReader("a=token&b=tok")))
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(got), qt.Equals, "a=xx&b=tok")
+	}
+}
+
+func TestBodyRewriteStreamPassThrough(t *testing.T) {
+	c := qt.New(t)
+	br := newBodyRewrite(c, `{"Enable":true,"Items":[
+		{"Enable":true,"From":{},"Request":[{"Find":"a","Replace":"b"}],"Response":[{"Find":"a","Replace":"b"}]}
+	]}`)
+
+	// buffered bodies are left to the Request and Response events
+	f := newJSONLFlow(c, nil, nil)
+	in := strings.NewReader("a")
+	c.Assert(br.StreamRequestModifier(f, in), qt.Equals, io.Reader(in))
+	c.Assert(br.StreamResponseModifier(f, nil), qt.IsNil)
+
+	// encoded streams cannot be rewritten
+	f.Stream = true
+	f.Response.Header.Set("Content-Encoding", "gzip")
+	c.Assert(br.StreamResponseModifier(f, in), qt.Equals, io.Reader(in))
+
+	disabled := &addons.BodyRewrite{Items: br.Items}
+	f.Response.Header.Del("Content-Encoding")
+	c.Assert(disabled.StreamResponseModifier(f, in), qt.Equals, io.Reader(in))
+}
+
+func TestValidateBodyRewriteFile(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	for config, want := range map[string]string{
+		`{"Items":[{"Enable":true,"Response":[{"Find":"a"}]}]}`:                               "0 no item.From",
+		`{"Items":[{"Enable":true,"From":{}}]}`:                                               "0 no item.Request or item.Response substitutions",
+		`{"Items":[{"Enable":true,"From":{},"Request":[{"Replace":"a"}]}]}`:                   "0 substitution 0 without Find",
+		`{"Items":[{"Enable":true,"From":{},"Response":[{"Find":"(","Regex":true}]}]}`:        "0 substitution 0 invalid Find: .*",
+		`{"Items":[{"Enable":true,"From":{},"Response":[{"Find":"a*","Regex":true}]}]}`:       `0 substitution 0 empty string matched by Find "a\*"`,
+		`{"Items":[{"Enable":true,"From":{},"Response":[{"Find":"a","MaxMatchLength":-1}]}]}`: "0 substitution 0 negative MaxMatchLength -1",
+	} {
+		filename := filepath.Join(dir, "bodies.json")
+		c.Assert(os.WriteFile(filename, []byte(config), 0o644), qt.IsNil)
+		c.Assert(addons.ValidateBodyRewriteFile(filename), qt.ErrorMatches, want, qt.Commentf(config))
+	}
+}
@@ -0,0 +1,377 @@
+package addons
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// CacheStatusHeader is set on responses passing through ResponseCache to HIT, MISS or
+// REVALIDATED.
+const CacheStatusHeader = "X-Cache"
+
+// cacheableStatusCodes are the status codes cacheable by default (RFC 7231 section 6.1).
+var cacheableStatusCodes = []int{200, 203, 204, 300, 301, 404, 405, 410, 414, 501}
+
+// ResponseCacheOptions limit what ResponseCache stores.
+type ResponseCacheOptions struct {
+	// MaxEntries is the number of responses kept; the least recently used ones are
+	// dropped first. Zero means 1000.
+	MaxEntries int
+	// MaxBodySize is the largest body stored, in bytes. Zero means 10 MiB.
+	MaxBodySize int
+}
+
+// ResponseCache turns the proxy into a shared HTTP cache in the spirit of RFC 7234.
+// Cacheable responses to GET requests are stored in memory; fresh ones are answered
+// by the proxy itself and stale ones are revalidated upstream with their ETag or
+// Last-Modified validators.
+//
+// Responses of streamed flows, responses setting cookies and private responses are
+// not stored. Requests with unsafe methods invalidate the stored response of their URL.
+type ResponseCache struct {
+	proxy.BaseAddon
+	maxBodySize int
+
+	mu      sync.Mutex
+	entries *lru.Cache // cache key -> *cacheEntry
+
+	revalidating sync.Map // *proxy.Flow -> *cacheEntry
+}
+
+// cacheEntry is a stored response.
+type cacheEntry struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	vary       http.Header // the request headers named by Vary
+
+	responseTime time.Time
+	age          time.Duration // Age of the response when it was received
+	freshness    time.Duration
+}
+
+func NewResponseCache(options ResponseCacheOptions) *ResponseCache {
+	if options.MaxEntries == 0 {
+		options.MaxEntries = 1000
+	}
+	if options.MaxBodySize == 0 {
+		options.MaxBodySize = 10 << 20
+	}
+	return &ResponseCache{
+		maxBodySize: options.MaxBodySize,
+		entries:     lru.New(options.MaxEntries),
+	}
+}
+
+func (adn *ResponseCache) Requestheaders(f *proxy.Flow) {
+	req := f.Request
+	if req.Method != http.MethodGet {
+		return
+	}
+	entry := adn.lookup(req)
+	if entry == nil {
+		return
+	}
+
+	directives := parseCacheControl(req.Header.Get("Cache-Control"))
+	_, noCache := directives["no-cache"]
+	if !noCache && req.Header.Get("Pragma") == "no-cache" && req.Header.Get("Cache-Control") == "" {
+		noCache = true
+	}
+	if !noCache && entry.fresh(directives) {
+		f.Response = entry.response("HIT")
+		if entry.notModified(req) {
+			f.Response.StatusCode = http.StatusNotModified
+			f.Response.Body = nil
+		}
+		return
+	}
+
+	etag, lastModified := entry.header.Get("ETag"), entry.header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+	// the client's own conditions are answered by the origin
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	adn.revalidating.Store(f, entry)
+	go func() {
+		<-f.Done()
+		adn.revalidating.Delete(f)
+	}()
+}
+
+func (adn *ResponseCache) Response(f *proxy.Flow) {
+	req, res := f.Request, f.Response
+	if res == nil {
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return
+	default:
+		// unsafe methods invalidate what is stored for the URL
+		if res.StatusCode < 400 {
+			adn.mu.Lock()
+			adn.entries.Remove(cacheKey(req))
+			adn.mu.Unlock()
+		}
+		return
+	}
+
+	if v, ok := adn.revalidating.LoadAndDelete(f); ok && res.StatusCode == http.StatusNotModified {
+		entry := v.(*cacheEntry).updated(res.Header, time.Now())
+		adn.store(req, entry)
+		f.Response = entry.response("REVALIDATED")
+		return
+	}
+
+	if entry := adn.cacheable(f); entry != nil {
+		adn.store(req, entry)
+	}
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	res.Header.Set(CacheStatusHeader, "MISS")
+}
+
+func (adn *ResponseCache) lookup(req *proxy.Request) *cacheEntry {
+	adn.mu.Lock()
+	v, ok := adn.entries.Get(cacheKey(req))
+	adn.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	entry := v.(*cacheEntry)
+	for name, values := range entry.vary {
+		if !slices.Equal(req.Header.Values(name), values) {
+			return nil
+		}
+	}
+	return entry
+}
+
+func (adn *ResponseCache) store(req *proxy.Request, entry *cacheEntry) {
+	adn.mu.Lock()
+	adn.entries.Add(cacheKey(req), entry)
+	adn.mu.Unlock()
+}
+
+// cacheable returns the entry storing the response of f, nil if it may not be stored.
+func (adn *ResponseCache) cacheable(f *proxy.Flow) *cacheEntry {
+	req, res := f.Request, f.Response
+	if f.Stream || len(res.Body) > adn.maxBodySize || !slices.Contains(cacheableStatusCodes, res.StatusCode) {
+		return nil
+	}
+	reqDirectives := parseCacheControl(req.Header.Get("Cache-Control"))
+	resDirectives := parseCacheControl(res.Header.Get("Cache-Control"))
+	if _, ok := reqDirectives["no-store"]; ok {
+		return nil
+	}
+	for _, directive := range []string{"no-store", "private"} {
+		if _, ok := resDirectives[directive]; ok {
+			return nil
+		}
+	}
+	if req.Header.Get("Authorization") != "" {
+		_, public := resDirectives["public"]
+		_, sMaxAge := resDirectives["s-maxage"]
+		_, mustRevalidate := resDirectives["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return nil
+		}
+	}
+	if res.Header.Get("Set-Cookie") != "" {
+		return nil
+	}
+
+	now := time.Now()
+	entry := &cacheEntry{
+		statusCode:   res.StatusCode,
+		header:       res.Header.Clone(),
+		body:         bytes.Clone(res.Body),
+		vary:         make(http.Header),
+		responseTime: now,
+	}
+	entry.header.Del(CacheStatusHeader)
+	for _, value := range res.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				entry.vary[http.CanonicalHeaderKey(name)] = req.Header.Values(name)
+			}
+		}
+	}
+	entry.setFreshness(resDirectives, now)
+
+	if entry.freshness <= 0 && entry.header.Get("ETag") == "" && entry.header.Get("Last-Modified") == "" {
+		return nil
+	}
+	return entry
+}
+
+// setFreshness sets the age and the freshness lifetime of the entry from its headers.
+func (entry *cacheEntry) setFreshness(directives map[string]string, now time.Time) {
+	entry.age = 0
+	if age, err := strconv.Atoi(entry.header.Get("Age")); err == nil && age > 0 {
+		entry.age = time.Duration(age) * time.Second
+	}
+
+	date, err := http.ParseTime(entry.header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	_, noCache := directives["no-cache"]
+	switch {
+	case noCache:
+		entry.freshness = 0
+	case directives["s-maxage"] != "":
+		entry.freshness = parseSeconds(directives["s-maxage"])
+	case directives["max-age"] != "":
+		entry.freshness = parseSeconds(directives["max-age"])
+	case entry.header.Get("Expires") != "":
+		// invalid dates, such as "0", mean already expired
+		expires, err := http.ParseTime(entry.header.Get("Expires"))
+		if err == nil {
+			entry.freshness = expires.Sub(date)
+		} else {
+			entry.freshness = 0
+		}
+	default:
+		// a tenth of the time since the last modification (RFC 7234 section 4.2.2)
+		lastModified, err := http.ParseTime(entry.header.Get("Last-Modified"))
+		if err == nil && lastModified.Before(date) {
+			entry.freshness = date.Sub(lastModified) / 10
+		} else {
+			entry.freshness = 0
+		}
+	}
+}
+
+// currentAge is the age of the entry now.
+func (entry *cacheEntry) currentAge() time.Duration {
+	return entry.age + time.Since(entry.responseTime)
+}
+
+// fresh reports whether the entry may be served for a request with directives.
+func (entry *cacheEntry) fresh(directives map[string]string) bool {
+	age := entry.currentAge()
+	freshness := entry.freshness
+	if v, ok := directives["max-age"]; ok {
+		freshness = min(freshness, parseSeconds(v))
+	}
+	if v, ok := directives["min-fresh"]; ok {
+		age += parseSeconds(v)
+	}
+	if age < freshness {
+		return true
+	}
+	if v, ok := directives["max-stale"]; ok {
+		if _, mustRevalidate := parseCacheControl(entry.header.Get("Cache-Control"))["must-revalidate"]; mustRevalidate {
+			return false
+		}
+		// max-stale without a value accepts any staleness
+		return v == "" || age-freshness < parseSeconds(v)
+	}
+	return false
+}
+
+// notModified reports whether the conditions of req show the client has the entry.
+func (entry *cacheEntry) notModified(req *proxy.Request) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(entry.header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(entry.header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(since)
+}
+
+// updated returns a copy of the entry refreshed by the headers of a 304 response.
+func (entry *cacheEntry) updated(header http.Header, now time.Time) *cacheEntry {
+	updated := *entry
+	updated.header = entry.header.Clone()
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", CacheStatusHeader:
+			continue
+		}
+		updated.header[name] = values
+	}
+	updated.responseTime = now
+	updated.setFreshness(parseCacheControl(updated.header.Get("Cache-Control")), now)
+	return &updated
+}
+
+// response returns the stored response, with its Age and cache status.
+func (entry *cacheEntry) response(status string) *proxy.Response {
+	header := entry.header.Clone()
+	header.Set("Age", strconv.Itoa(int(entry.currentAge().Seconds())))
+	header.Set(CacheStatusHeader, status)
+	return &proxy.Response{
+		StatusCode: entry.statusCode,
+		Header:     header,
+		Body:       bytes.Clone(entry.body),
+	}
+}
+
+func cacheKey(req *proxy.Request) string {
+	u := *req.URL
+	u.Fragment = ""
+	return u.String()
+}
+
+// parseCacheControl returns the directives of a Cache-Control header by lowercase name.
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+	}
+	return directives
+}
+
+// parseSeconds parses a delta-seconds value; invalid ones are zero.
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
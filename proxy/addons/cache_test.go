@@ -0,0 +1,184 @@
+package addons_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newCacheFlow(c *qt.C, method, rawURL string) *proxy.Flow {
+	u, err := url.Parse(rawURL)
+	c.Assert(err, qt.IsNil)
+	f := proxy.NewFlow()
+	f.Request = &proxy.Request{Method: method, URL: u, Header: make(http.Header)}
+	return f
+}
+
+// cacheRoundTrip passes f through adn, answering it with upstream unless adn answers it.
+// It returns the response the client gets and whether the request went upstream.
+func cacheRoundTrip(adn *addons.ResponseCache, f *proxy.Flow, upstream func(req *proxy.Request) *proxy.Response) (*proxy.Response, bool) {
+	adn.Requestheaders(f)
+	if f.Response != nil {
+		return f.Response, false
+	}
+	f.Response = upstream(f.Request)
+	adn.Response(f)
+	return f.Response, true
+}
+
+func staticUpstream(header http.Header, body string) func(*proxy.Request) *proxy.Response {
+	return func(*proxy.Request) *proxy.Response {
+		return &proxy.Response{StatusCode: 200, Header: header.Clone(), Body: []byte(body)}
+	}
+}
+
+func TestResponseCacheServesFreshResponses(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewResponseCache(addons.ResponseCacheOptions{})
+	upstream := staticUpstream(http.Header{"Cache-Control": {"max-age=60"}, "Age": {"10"}}, "hello")
+
+	res, sent := cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/a"), upstream)
+	c.Assert(sent, qt.IsTrue)
+	c.Assert(res.Header.Get(addons.CacheStatusHeader), qt.Equals, "MISS")
+
+	res, sent = cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/a"), upstream)
+	c.Assert(sent, qt.IsFalse)
+	c.Assert(res.StatusCode, qt.Equals, 200)
+	c.Assert(string(res.Body), qt.Equals, "hello")
+	c.Assert(res.Header.Get(addons.CacheStatusHeader), qt.Equals, "HIT")
+	c.Assert(res.Header.Get("Age"), qt.Equals, "10")
+
+	// the client may refuse stored responses
+	f := newCacheFlow(c, "GET", "https://example.com/a")
+	f.Request.Header.Set("Cache-Control", "no-cache")
+	_, sent = cacheRoundTrip(adn, f, upstream)
+	c.Assert(sent, qt.IsTrue)
+	f = newCacheFlow(c, "GET", "https://example.com/a")
+	f.Request.Header.Set("Cache-Control", "max-age=5")
+	_, sent = cacheRoundTrip(adn, f, upstream)
+	c.Assert(sent, qt.IsTrue)
+
+	// the client's own validators are answered from the cache
+	f = newCacheFlow(c, "GET", "https://example.com/other")
+	_, _ = cacheRoundTrip(adn, f, staticUpstream(http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}}, "x"))
+	f = newCacheFlow(c, "GET", "https://example.com/other")
+	f.Request.Header.Set("If-None-Match", `W/"v1"`)
+	res, sent = cacheRoundTrip(adn, f, nil)
+	c.Assert(sent, qt.IsFalse)
+	c.Assert(res.StatusCode, qt.Equals, http.StatusNotModified)
+	c.Assert(res.Body, qt.HasLen, 0)
+}
+
+func TestResponseCacheRevalidates(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewResponseCache(addons.ResponseCacheOptions{})
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	_, _ = cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/r"), staticUpstream(http.Header{
+		"Cache-Control": {"no-cache"},
+		"Etag":          {`"v1"`},
+		"Last-Modified": {lastModified},
+		"Content-Type":  {"text/plain"},
+	}, "stored"))
+
+	var conditional http.Header
+	res, sent := cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/r"), func(req *proxy.Request) *proxy.Response {
+		conditional = req.Header.Clone()
+		return &proxy.Response{StatusCode: http.StatusNotModified, Header: http.Header{"Cache-Control": {"max-age=60"}}}
+	})
+	c.Assert(sent, qt.IsTrue)
+	c.Assert(conditional.Get("If-None-Match"), qt.Equals, `"v1"`)
+	c.Assert(conditional.Get("If-Modified-Since"), qt.Equals, lastModified)
+	c.Assert(res.StatusCode, qt.Equals, 200)
+	c.Assert(string(res.Body), qt.Equals, "stored")
+	c.Assert(res.Header.Get("Content-Type"), qt.Equals, "text/plain")
+	c.Assert(res.Header.Get(addons.CacheStatusHeader), qt.Equals, "REVALIDATED")
+
+	// the 304 made the stored response fresh
+	res, sent = cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/r"), nil)
+	c.Assert(sent, qt.IsFalse)
+	c.Assert(string(res.Body), qt.Equals, "stored")
+}
+
+func TestResponseCacheDoesNotStore(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		setup  func(f *proxy.Flow)
+	}{
+		{name: "no validators or freshness", header: http.Header{}},
+		{name: "no-store", header: http.Header{"Cache-Control": {"no-store, max-age=60"}}},
+		{name: "private", header: http.Header{"Cache-Control": {"private, max-age=60"}}},
+		{name: "cookies", header: http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=1"}}},
+		{name: "vary all", header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}},
+		{name: "authorized", header: http.Header{"Cache-Control": {"max-age=60"}}, setup: func(f *proxy.Flow) {
+			f.Request.Header.Set("Authorization", "Bearer x")
+		}},
+		{name: "streamed", header: http.Header{"Cache-Control": {"max-age=60"}}, setup: func(f *proxy.Flow) {
+			f.Stream = true
+		}},
+	}
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			adn := addons.NewResponseCache(addons.ResponseCacheOptions{})
+			f := newCacheFlow(c, "GET", "https://example.com/")
+			if test.setup != nil {
+				test.setup(f)
+			}
+			_, _ = cacheRoundTrip(adn, f, staticUpstream(test.header, "body"))
+			_, sent := cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/"), staticUpstream(test.header, "body"))
+			c.Assert(sent, qt.IsTrue)
+		})
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewResponseCache(addons.ResponseCacheOptions{})
+	upstream := staticUpstream(http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, "hallo")
+
+	f := newCacheFlow(c, "GET", "https://example.com/")
+	f.Request.Header.Set("Accept-Language", "de")
+	_, _ = cacheRoundTrip(adn, f, upstream)
+
+	f = newCacheFlow(c, "GET", "https://example.com/")
+	f.Request.Header.Set("Accept-Language", "de")
+	_, sent := cacheRoundTrip(adn, f, upstream)
+	c.Assert(sent, qt.IsFalse)
+
+	f = newCacheFlow(c, "GET", "https://example.com/")
+	f.Request.Header.Set("Accept-Language", "fr")
+	_, sent = cacheRoundTrip(adn, f, upstream)
+	c.Assert(sent, qt.IsTrue)
+}
+
+func TestResponseCacheInvalidatesOnUnsafeMethods(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewResponseCache(addons.ResponseCacheOptions{})
+	upstream := staticUpstream(http.Header{"Cache-Control": {"max-age=60"}}, "v1")
+
+	_, _ = cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/item"), upstream)
+	_, sent := cacheRoundTrip(adn, newCacheFlow(c, "PUT", "https://example.com/item"), staticUpstream(http.Header{}, ""))
+	c.Assert(sent, qt.IsTrue)
+	_, sent = cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/item"), upstream)
+	c.Assert(sent, qt.IsTrue)
+}
+
+func TestResponseCacheMaxEntries(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewResponseCache(addons.ResponseCacheOptions{MaxEntries: 1})
+	upstream := staticUpstream(http.Header{"Cache-Control": {"max-age=60"}}, "x")
+
+	_, _ = cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/1"), upstream)
+	_, _ = cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/2"), upstream)
+	_, sent := cacheRoundTrip(adn, newCacheFlow(c, "GET", "https://example.com/1"), upstream)
+	c.Assert(sent, qt.IsTrue)
+}
@@ -0,0 +1,55 @@
+package addons
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// FlowReplayer sends the request of a recorded flow again; *proxy.Proxy is one.
+type FlowReplayer interface {
+	ReplayFlow(ctx context.Context, f *proxy.Flow) (*proxy.Flow, error)
+}
+
+// ClientReplay replays recorded requests through the proxy, like the client replay
+// of mitmproxy. The replays are new flows passing through every addon.
+type ClientReplay struct {
+	flows []*proxy.Flow
+}
+
+func NewClientReplay(flows []*proxy.Flow) *ClientReplay {
+	return &ClientReplay{flows: flows}
+}
+
+// NewClientReplayFromFile creates a ClientReplay of the requests recorded in the file
+// at filename, a mitmproxy flow file, a JSONL dump or a HAR file.
+func NewClientReplayFromFile(filename string) (*ClientReplay, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	flows, err := readReplayFlows(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return NewClientReplay(flows), nil
+}
+
+// Run replays the requests one after another, in recorded order, until ctx is done,
+// and returns the errors of the failed ones.
+func (cr *ClientReplay) Run(ctx context.Context, replayer FlowReplayer) error {
+	var errs []error
+	for _, f := range cr.flows {
+		replayed, err := replayer.ReplayFlow(ctx, f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", f.Request.Method, f.Request.URL, err))
+			continue
+		}
+		slog.Debug("client replay", "url", f.Request.URL.String(), "status", replayed.Response.StatusCode)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package addons_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+type fakeReplayer struct {
+	replayed []string
+}
+
+func (r *fakeReplayer) ReplayFlow(_ context.Context, f *proxy.Flow) (*proxy.Flow, error) {
+	r.replayed = append(r.replayed, f.Request.Method+" "+f.Request.URL.String()+" "+string(f.Request.Body))
+	if f.Request.URL.Path == "/fail" {
+		return nil, errors.New("replay failed with status 502")
+	}
+	replayed := proxy.NewFlow()
+	replayed.Request = f.Request
+	replayed.Response = &proxy.Response{StatusCode: 200}
+	return replayed, nil
+}
+
+func TestClientReplayFromFile(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "flows.har")
+	c.Assert(os.WriteFile(filename, []byte(`{"log": {"entries": [
+  {"request": {"method": "POST", "url": "https://example.com/a", "headers": [], "postData": {"text": "x=1"}},
+   "response": {"status": 200, "headers": [], "content": {}}},
+  {"request": {"method": "GET", "url": "https://example.com/fail", "headers": []},
+   "response": {"status": 0, "headers": [], "content": {}}},
+  {"request": {"method": "GET", "url": "https://example.com/b", "headers": []},
+   "response": {"status": 0, "headers": [], "content": {}}}
+]}}`), 0o644), qt.IsNil)
+
+	clientReplay, err := addons.NewClientReplayFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	replayer := &fakeReplayer{}
+	err = clientReplay.Run(context.Background(), replayer)
+	c.Assert(err, qt.ErrorMatches, "GET https://example.com/fail: replay failed with status 502")
+	// requests without recorded response are replayed too, a failure does not stop the others
+	c.Assert(replayer.replayed, qt.DeepEquals, []string{
+		"POST https://example.com/a x=1",
+		"GET https://example.com/fail ",
+		"GET https://example.com/b ",
+	})
+}
+
+func TestClientReplayFromFileError(t *testing.T) {
+	c := qt.New(t)
+	_, err := addons.NewClientReplayFromFile(filepath.Join(c.TempDir(), "missing.flow"))
+	c.Assert(err, qt.ErrorMatches, ".*no such file or directory")
+}
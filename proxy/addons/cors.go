@@ -0,0 +1,170 @@
+package addons
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// CORSRule allows cross-origin requests to matching hosts.
+type CORSRule struct {
+	// Hosts are matched like ignore_hosts, e.g. "api.example.com" or "*.example.com:443".
+	// No hosts match every host.
+	Hosts []string
+	// AllowOrigins are the origins allowed, e.g. "http://localhost:3000", or "*" for any.
+	// No origins allow the origin of every request, which is then sent back as allowed.
+	AllowOrigins []string
+	// AllowMethods are the methods allowed by preflights. No methods allow the
+	// requested one.
+	AllowMethods []string
+	// AllowHeaders are the request headers allowed by preflights. No headers allow the
+	// requested ones.
+	AllowHeaders []string
+	// ExposeHeaders are the response headers scripts may read.
+	ExposeHeaders []string
+	// AllowCredentials lets requests carry cookies and authorization. The origin of the
+	// request is then sent back even if any is allowed, as browsers require.
+	AllowCredentials bool
+	// MaxAge is how many seconds browsers may cache preflights. Zero leaves it to them.
+	MaxAge int
+}
+
+// CORSConfig configures CORS.
+type CORSConfig struct {
+	// Rules are checked in order; the first one matching the host of a request applies.
+	Rules []*CORSRule
+}
+
+// CORS answers CORS preflight requests itself and adds the Access-Control-Allow-*
+// headers to the responses to cross-origin requests, so that a frontend served from
+// another origin, e.g. on localhost, can use an API that does not allow it.
+type CORS struct {
+	proxy.BaseAddon
+	rules []*CORSRule
+}
+
+func NewCORS(config CORSConfig) (*CORS, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &CORS{rules: config.Rules}, nil
+}
+
+func NewCORSFromFile(filename string) (*CORS, error) {
+	var config CORSConfig
+	if err := helper.NewStructFromFile(filename, &config); err != nil {
+		return nil, err
+	}
+	return NewCORS(config)
+}
+
+// ValidateCORSFile reads the CORS config in filename and reports its first invalid rule.
+func ValidateCORSFile(filename string) error {
+	_, err := NewCORSFromFile(filename)
+	return err
+}
+
+func (config *CORSConfig) validate() error {
+	for i, rule := range config.Rules {
+		if rule == nil {
+			return fmt.Errorf("%v empty rule", i)
+		}
+		if rule.MaxAge < 0 {
+			return fmt.Errorf("%v negative MaxAge", i)
+		}
+	}
+	return nil
+}
+
+func (adn *CORS) Requestheaders(f *proxy.Flow) {
+	req := f.Request
+	if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+		return
+	}
+	rule, origin := adn.match(f)
+	if rule == nil {
+		return
+	}
+
+	header := make(http.Header)
+	rule.setOriginHeaders(header, origin)
+	methods := rule.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{req.Header.Get("Access-Control-Request-Method")}
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(rule.AllowHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(rule.AllowHeaders, ", "))
+	} else if requested := req.Header.Values("Access-Control-Request-Headers"); len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if rule.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAge))
+	}
+	slog.Debug("cors preflight answered", "url", req.URL.String(), "origin", origin)
+	f.Response = &proxy.Response{
+		StatusCode: http.StatusNoContent,
+		Header:     header,
+	}
+}
+
+func (adn *CORS) Responseheaders(f *proxy.Flow) {
+	if f.Response == nil {
+		return
+	}
+	rule, origin := adn.match(f)
+	if rule == nil {
+		return
+	}
+	if f.Response.Header == nil {
+		f.Response.Header = make(http.Header)
+	}
+	rule.setOriginHeaders(f.Response.Header, origin)
+	if len(rule.ExposeHeaders) > 0 {
+		f.Response.Header.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+}
+
+// match returns the rule for the cross-origin request of f and its origin, a nil rule
+// if the request is not cross-origin, no rule matches its host or the origin is not
+// allowed.
+func (adn *CORS) match(f *proxy.Flow) (*CORSRule, string) {
+	origin := f.Request.Header.Get("Origin")
+	if origin == "" {
+		return nil, ""
+	}
+	address := helper.CanonicalAddr(f.Request.URL)
+	for _, rule := range adn.rules {
+		if len(rule.Hosts) > 0 && !helper.MatchHost(address, rule.Hosts) {
+			continue
+		}
+		if len(rule.AllowOrigins) > 0 && !slices.Contains(rule.AllowOrigins, "*") && !slices.Contains(rule.AllowOrigins, origin) {
+			return nil, ""
+		}
+		return rule, origin
+	}
+	return nil, ""
+}
+
+func (rule *CORSRule) setOriginHeaders(header http.Header, origin string) {
+	if slices.Contains(rule.AllowOrigins, "*") && !rule.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		// the response differs per origin
+		if !slices.Contains(header.Values("Vary"), "Origin") {
+			header.Add("Vary", "Origin")
+		}
+	}
+	if rule.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		header.Del("Access-Control-Allow-Credentials")
+	}
+}
@@ -0,0 +1,121 @@
+package addons_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newCORSFlow(c *qt.C, method, origin string) *proxy.Flow {
+	f := newJSONLFlow(c, nil, nil)
+	f.Request.Method = method
+	if origin != "" {
+		f.Request.Header.Set("Origin", origin)
+	}
+	return f
+}
+
+func TestCORSPreflight(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewCORS(addons.CORSConfig{Rules: []*addons.CORSRule{
+		{Hosts: []string{"other.com"}, AllowOrigins: []string{"http://other.local"}},
+		{Hosts: []string{"example.com"}, AllowCredentials: true, MaxAge: 600},
+	}})
+	c.Assert(err, qt.IsNil)
+
+	f := newCORSFlow(c, "OPTIONS", "http://localhost:3000")
+	f.Request.Header.Set("Access-Control-Request-Method", "PUT")
+	f.Request.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	f.Response = nil
+	adn.Requestheaders(f)
+	c.Assert(f.Response, qt.DeepEquals, &proxy.Response{
+		StatusCode: 204,
+		Header: http.Header{
+			"Access-Control-Allow-Origin":      {"http://localhost:3000"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Allow-Methods":     {"PUT"},
+			"Access-Control-Allow-Headers":     {"authorization, content-type"},
+			"Access-Control-Max-Age":           {"600"},
+			"Vary":                             {"Origin"},
+		},
+	})
+
+	// plain OPTIONS requests and requests to other hosts go upstream
+	for _, f := range []*proxy.Flow{newCORSFlow(c, "OPTIONS", "http://localhost:3000"), newCORSFlow(c, "OPTIONS", "")} {
+		f.Response = nil
+		adn.Requestheaders(f)
+		c.Assert(f.Response, qt.IsNil)
+	}
+	unmatched := newCORSFlow(c, "OPTIONS", "http://localhost:3000")
+	unmatched.Request.URL.Host = "unknown.com"
+	unmatched.Request.Header.Set("Access-Control-Request-Method", "GET")
+	unmatched.Response = nil
+	adn.Requestheaders(unmatched)
+	c.Assert(unmatched.Response, qt.IsNil)
+}
+
+func TestCORSResponse(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewCORS(addons.CORSConfig{Rules: []*addons.CORSRule{
+		{AllowOrigins: []string{"http://localhost:3000"}, AllowMethods: []string{"GET", "POST"}, ExposeHeaders: []string{"X-Total"}},
+	}})
+	c.Assert(err, qt.IsNil)
+
+	f := newCORSFlow(c, "POST", "http://localhost:3000")
+	f.Response.Header.Set("Access-Control-Allow-Origin", "https://example.com")
+	f.Response.Header.Set("Access-Control-Allow-Credentials", "true")
+	adn.Responseheaders(f)
+	c.Assert(f.Response.Header, qt.DeepEquals, http.Header{
+		"Content-Type":                  {"application/json"},
+		"Access-Control-Allow-Origin":   {"http://localhost:3000"},
+		"Access-Control-Expose-Headers": {"X-Total"},
+		"Vary":                          {"Origin"},
+	})
+
+	// origins that are not allowed and same-origin requests are left alone
+	for _, origin := range []string{"http://evil.local", ""} {
+		f := newCORSFlow(c, "GET", origin)
+		adn.Responseheaders(f)
+		c.Assert(f.Response.Header, qt.DeepEquals, http.Header{"Content-Type": {"application/json"}})
+	}
+
+	preflight := newCORSFlow(c, "OPTIONS", "http://localhost:3000")
+	preflight.Request.Header.Set("Access-Control-Request-Method", "DELETE")
+	adn.Requestheaders(preflight)
+	c.Assert(preflight.Response.Header.Get("Access-Control-Allow-Methods"), qt.Equals, "GET, POST")
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewCORS(addons.CORSConfig{Rules: []*addons.CORSRule{{AllowOrigins: []string{"*"}}}})
+	c.Assert(err, qt.IsNil)
+	f := newCORSFlow(c, "GET", "http://localhost:3000")
+	adn.Responseheaders(f)
+	c.Assert(f.Response.Header.Get("Access-Control-Allow-Origin"), qt.Equals, "*")
+	c.Assert(f.Response.Header.Get("Vary"), qt.Equals, "")
+}
+
+func TestValidateCORSFile(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	for config, want := range map[string]string{
+		`{"Rules":[null]}`:          "0 empty rule",
+		`{"Rules":[{"MaxAge":-1}]}`: "0 negative MaxAge",
+		`{"Rules":`:                 ".*unexpected end of JSON input",
+	} {
+		filename := filepath.Join(dir, "cors.json")
+		c.Assert(os.WriteFile(filename, []byte(config), 0o644), qt.IsNil)
+		c.Assert(addons.ValidateCORSFile(filename), qt.ErrorMatches, want, qt.Commentf(config))
+	}
+
+	filename := filepath.Join(dir, "cors.json")
+	c.Assert(os.WriteFile(filename, []byte(`{"Rules":[{"Hosts":["api.example.com"]}]}`), 0o644), qt.IsNil)
+	c.Assert(addons.ValidateCORSFile(filename), qt.IsNil)
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
 	"github.com/denisvmedia/go-mitmproxy/proxy"
@@ -15,8 +17,11 @@ import (
 
 type Dumper struct {
 	proxy.BaseAddon
-	out   io.Writer
 	level int // 0: header 1: header + body
+
+	mu       sync.RWMutex // guards out during reloads
+	out      io.Writer
+	filename string
 }
 
 func NewDumper(out io.Writer, level int) *Dumper {
@@ -27,11 +32,75 @@ func NewDumper(out io.Writer, level int) *Dumper {
 }
 
 func NewDumperWithFilename(filename string, level int) *Dumper {
-	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	out, err := openDumpFile(filename)
 	if err != nil {
 		panic(err)
 	}
-	return NewDumper(out, level)
+	d := NewDumper(out, level)
+	d.filename = filename
+	return d
+}
+
+func openDumpFile(filename string) (*os.File, error) {
+	return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+}
+
+// Running implements proxy.LifecycleAddon; the file is opened when the Dumper is created.
+func (d *Dumper) Running(*proxy.Proxy) {}
+
+// Done closes the file of a Dumper created with a filename. Flows finishing later are
+// not dumped.
+func (d *Dumper) Done() {
+	if d.filename == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.out.(*os.File); ok {
+		if err := f.Close(); err != nil {
+			slog.Error("failed to close dump file", "error", err)
+		}
+	}
+	d.out = io.Discard
+}
+
+// ConfigFile returns the file the Dumper writes to, empty if it was not created with a
+// filename.
+func (d *Dumper) ConfigFile() string {
+	return d.filename
+}
+
+// Reload opens the file again if it was moved or removed, e.g. by log rotation, so
+// that later flows are written to a new file at the path.
+func (d *Dumper) Reload() error {
+	if d.filename == "" {
+		return nil
+	}
+	d.mu.RLock()
+	done := d.out == io.Discard
+	current, _ := d.out.(*os.File)
+	d.mu.RUnlock()
+	if done {
+		return nil
+	}
+	if current != nil {
+		currentInfo, err1 := current.Stat()
+		pathInfo, err2 := os.Stat(d.filename)
+		if err1 == nil && err2 == nil && os.SameFile(currentInfo, pathInfo) {
+			return nil
+		}
+	}
+	out, err := openDumpFile(d.filename)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.out = out
+	d.mu.Unlock()
+	if current != nil {
+		return current.Close()
+	}
+	return nil
 }
 
 func (d *Dumper) Requestheaders(f *proxy.Flow) {
@@ -48,11 +117,14 @@ func (d *Dumper) dump(f *proxy.Flow) {
 	buf := bytes.NewBuffer(make([]byte, 0))
 	fmt.Fprintf(buf, "%s %s %s\r\n", f.Request.Method, f.Request.URL.RequestURI(), f.Request.Proto)
 	fmt.Fprintf(buf, "Host: %s\r\n", f.Request.URL.Host)
-	if len(f.Request.Raw().TransferEncoding) > 0 {
-		fmt.Fprintf(buf, "Transfer-Encoding: %s\r\n", strings.Join(f.Request.Raw().TransferEncoding, ","))
-	}
-	if f.Request.Raw().Close {
-		fmt.Fprintf(buf, "Connection: close\r\n")
+	// flows not read from a client, e.g. loaded from a file, have no raw request
+	if raw := f.Request.Raw(); raw != nil {
+		if len(raw.TransferEncoding) > 0 {
+			fmt.Fprintf(buf, "Transfer-Encoding: %s\r\n", strings.Join(raw.TransferEncoding, ","))
+		}
+		if raw.Close {
+			fmt.Fprintf(buf, "Connection: close\r\n")
+		}
 	}
 
 	err := f.Request.Header.WriteSubset(buf, nil)
@@ -83,14 +155,44 @@ func (d *Dumper) dump(f *proxy.Flow) {
 		}
 	}
 
+	if line := timingsLine(f.Timings()); line != "" {
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+
 	buf.WriteString("\r\n\r\n")
 
+	d.mu.RLock()
 	_, err = d.out.Write(buf.Bytes())
+	d.mu.RUnlock()
 	if err != nil {
 		slog.Error("failed to write dump output", "error", err)
 	}
 }
 
+// timingsLine summarizes the known durations of timings, e.g.
+// "# total 60ms, connect 3ms, tls 12ms, first byte 45ms".
+func timingsLine(timings proxy.Timings) string {
+	var parts []string
+	for _, d := range []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"total", timings.Duration()},
+		{"connect", timings.ConnectDuration()},
+		{"tls", timings.TLSDuration()},
+		{"first byte", timings.TimeToFirstByte()},
+	} {
+		if d.duration > 0 {
+			parts = append(parts, d.name+" "+d.duration.Round(100*time.Microsecond).String())
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "# " + strings.Join(parts, ", ")
+}
+
 func canPrint(content []byte) bool {
 	for _, c := range string(content) {
 		if !unicode.IsPrint(c) && !unicode.IsSpace(c) {
@@ -0,0 +1,64 @@
+package addons
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// ErrorOnlyLog logs a summary line for failed or slow flows only.
+// A flow is logged when it failed or got no response, when its status code is at
+// least StatusThreshold, or when it took longer than LatencyThreshold.
+type ErrorOnlyLog struct {
+	proxy.BaseAddon
+	StatusThreshold  int           // zero disables the status check
+	LatencyThreshold time.Duration // zero disables the latency check
+}
+
+func NewErrorOnlyLog(statusThreshold int, latencyThreshold time.Duration) *ErrorOnlyLog {
+	return &ErrorOnlyLog{
+		StatusThreshold:  statusThreshold,
+		LatencyThreshold: latencyThreshold,
+	}
+}
+
+func (adn *ErrorOnlyLog) Requestheaders(f *proxy.Flow) {
+	start := time.Now()
+	go func() {
+		<-f.Done()
+		duration := time.Since(start)
+
+		var statusCode int
+		if f.Response != nil {
+			statusCode = f.Response.StatusCode
+		}
+
+		var reason string
+		switch {
+		case f.Error != nil:
+			reason = "error"
+		case f.Response == nil:
+			reason = "no response"
+		case adn.StatusThreshold > 0 && statusCode >= adn.StatusThreshold:
+			reason = "status"
+		case adn.LatencyThreshold > 0 && duration > adn.LatencyThreshold:
+			reason = "latency"
+		default:
+			return
+		}
+
+		args := []any{
+			"reason", reason,
+			"clientAddr", f.ConnContext.ClientConn.Conn.RemoteAddr().String(),
+			"method", f.Request.Method,
+			"url", f.Request.URL.String(),
+			"status", statusCode,
+			"durationMs", duration.Milliseconds(),
+		}
+		if f.Error != nil {
+			args = append(args, "error", f.Error)
+		}
+		slog.Warn("request failed or slow", args...)
+	}()
+}
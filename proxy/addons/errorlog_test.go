@@ -0,0 +1,78 @@
+package addons_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func newErrorOnlyLogFlow(statusCode int) *proxy.Flow {
+	flow := types.NewFlow()
+	flow.Request = &proxy.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "https", Host: "api.example.com", Path: "/items"},
+		Header: make(map[string][]string),
+	}
+	if statusCode != 0 {
+		flow.Response = &proxy.Response{
+			StatusCode: statusCode,
+			Header:     make(map[string][]string),
+		}
+	}
+	flow.ConnContext = &proxy.ConnContext{
+		ClientConn: &proxy.ClientConn{
+			Conn: &mockConn{remoteAddr: mockAddr{"10.1.2.3:4567"}},
+		},
+	}
+	return flow
+}
+
+func TestErrorOnlyLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		delay      time.Duration
+		err        error
+		wantLog    string
+	}{
+		{name: "fast success is not logged", statusCode: 200},
+		{name: "server error is logged", statusCode: 500, wantLog: "reason=status"},
+		{name: "slow success is logged", statusCode: 200, delay: 60 * time.Millisecond, wantLog: "reason=latency"},
+		{name: "missing response is logged", wantLog: "reason=\"no response\""},
+		{name: "failed flow is logged with its error", err: errors.New("dial tcp: connection refused"), wantLog: "reason=error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			addon := addons.NewErrorOnlyLog(500, 50*time.Millisecond)
+			flow := newErrorOnlyLogFlow(tt.statusCode)
+			flow.Error = tt.err
+
+			output := captureLog(func() {
+				addon.Requestheaders(flow)
+				time.Sleep(tt.delay)
+				flow.Finish()
+				time.Sleep(20 * time.Millisecond)
+			})
+
+			if tt.wantLog == "" {
+				c.Assert(output, qt.Equals, "")
+				return
+			}
+			c.Assert(output, qt.Contains, "request failed or slow")
+			c.Assert(output, qt.Contains, tt.wantLog)
+			c.Assert(output, qt.Contains, "https://api.example.com/items")
+			if tt.err != nil {
+				c.Assert(output, qt.Contains, tt.err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package addons
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+var execHookEvents = []string{"requestheaders", "request", "responseheaders", "response"}
+
+// ExecHookCommand is an external program run in some events.
+type ExecHookCommand struct {
+	// Command is the program and its arguments, run without a shell.
+	Command []string
+	// Events are the events the program runs in: requestheaders, request,
+	// responseheaders and response. No events mean request and response.
+	Events []string
+	// Hosts are matched like ignore_hosts. No hosts match every host.
+	Hosts []string
+	// TimeoutMs is how long the program may run before it is killed. Zero means 5000.
+	TimeoutMs int
+	// MaxConcurrent limits how many copies of the program run at once; flows wait for
+	// their turn as long as the timeout allows. Zero means 4.
+	MaxConcurrent int
+
+	slots chan struct{}
+}
+
+// ExecHookConfig configures ExecHook.
+type ExecHookConfig struct {
+	// Commands run in order.
+	Commands []*ExecHookCommand
+}
+
+// ExecHook pipes flows to external programs and applies the flows they print back, a
+// quick way to change flows with tools not written in Go. A program gets the flow as
+// JSON on its stdin, with the event in "event" and base64 bodies, and may print the
+// flow, changed, to its stdout; nothing printed leaves the flow as it is, and so does
+// a program failing or timing out, which is logged. The fields printed replace the
+// ones of the flow, so a program may print only what it changes, e.g.
+// {"request": {"header": {"X-Hook": ["1"]}}}. Printing a response in requestheaders or
+// request answers the request without contacting the server. Programs only see the
+// bodies of buffered flows in the request and response events.
+type ExecHook struct {
+	proxy.BaseAddon
+	commands []*ExecHookCommand
+}
+
+func NewExecHook(config ExecHookConfig) (*ExecHook, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	for _, cmd := range config.Commands {
+		if len(cmd.Events) == 0 {
+			cmd.Events = []string{"request", "response"}
+		}
+		if cmd.TimeoutMs == 0 {
+			cmd.TimeoutMs = 5000
+		}
+		if cmd.MaxConcurrent == 0 {
+			cmd.MaxConcurrent = 4
+		}
+		cmd.slots = make(chan struct{}, cmd.MaxConcurrent)
+	}
+	return &ExecHook{commands: config.Commands}, nil
+}
+
+func NewExecHookFromFile(filename string) (*ExecHook, error) {
+	var config ExecHookConfig
+	if err := helper.NewStructFromFile(filename, &config); err != nil {
+		return nil, err
+	}
+	return NewExecHook(config)
+}
+
+// ValidateExecHookFile returns the error NewExecHookFromFile gives for filename.
+func ValidateExecHookFile(filename string) error {
+	_, err := NewExecHookFromFile(filename)
+	return err
+}
+
+func (config *ExecHookConfig) validate() error {
+	for i, cmd := range config.Commands {
+		if cmd == nil || len(cmd.Command) == 0 {
+			return fmt.Errorf("%v empty Command", i)
+		}
+		if _, err := exec.LookPath(cmd.Command[0]); err != nil {
+			return fmt.Errorf("%v %w", i, err)
+		}
+		for _, event := range cmd.Events {
+			if !slices.Contains(execHookEvents, event) {
+				return fmt.Errorf("%v unknown event %q, want one of %s", i, event, strings.Join(execHookEvents, ", "))
+			}
+		}
+		if cmd.TimeoutMs < 0 || cmd.MaxConcurrent < 0 {
+			return fmt.Errorf("%v negative TimeoutMs or MaxConcurrent", i)
+		}
+	}
+	return nil
+}
+
+func (adn *ExecHook) Requestheaders(f *proxy.Flow) {
+	adn.run("requestheaders", f)
+}
+
+func (adn *ExecHook) Request(f *proxy.Flow) {
+	adn.run("request", f)
+}
+
+func (adn *ExecHook) Responseheaders(f *proxy.Flow) {
+	adn.run("responseheaders", f)
+}
+
+func (adn *ExecHook) Response(f *proxy.Flow) {
+	adn.run("response", f)
+}
+
+func (adn *ExecHook) run(event string, f *proxy.Flow) {
+	for _, cmd := range adn.commands {
+		if !slices.Contains(cmd.Events, event) {
+			continue
+		}
+		if len(cmd.Hosts) > 0 && !helper.MatchHost(helper.CanonicalAddr(f.Request.URL), cmd.Hosts) {
+			continue
+		}
+		if err := cmd.run(event, f); err != nil {
+			slog.Error("exec hook error", "command", cmd.Command[0], "event", event, "url", f.Request.URL.String(), "error", err)
+		}
+	}
+}
+
+func (cmd *ExecHookCommand) run(event string, f *proxy.Flow) error {
+	input, err := json.Marshal(newHookFlow(f, event))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cmd.TimeoutMs)*time.Millisecond)
+	defer cancel()
+	select {
+	case cmd.slots <- struct{}{}:
+		defer func() { <-cmd.slots }()
+	case <-ctx.Done():
+		return fmt.Errorf("no free slot: %w", ctx.Err())
+	}
+
+	c := exec.CommandContext(ctx, cmd.Command[0], cmd.Command[1:]...)
+	c.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	// do not wait for children keeping the output open after the program was killed
+	c.WaitDelay = time.Second
+	if err := c.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		slog.Debug("exec hook stderr", "command", cmd.Command[0], "event", event, "msg", msg)
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	if len(output) == 0 {
+		return nil
+	}
+	var patch hookPatch
+	if err := json.Unmarshal(output, &patch); err != nil {
+		return fmt.Errorf("invalid output: %w", err)
+	}
+	return patch.apply(f)
+}
@@ -0,0 +1,100 @@
+package addons_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newExecHook(c *qt.C, commands ...*addons.ExecHookCommand) *addons.ExecHook {
+	adn, err := addons.NewExecHook(addons.ExecHookConfig{Commands: commands})
+	c.Assert(err, qt.IsNil)
+	return adn
+}
+
+func TestExecHook(t *testing.T) {
+	c := qt.New(t)
+	adn := newExecHook(c,
+		// printing the flow back changes nothing
+		&addons.ExecHookCommand{Command: []string{"cat"}},
+		&addons.ExecHookCommand{
+			Command: []string{"sh", "-c", `grep -q '"event":"request"' && printf '{"request": {"method": "PUT", "body": "aGVsbG8="}}'`},
+			Events:  []string{"request"},
+		},
+		&addons.ExecHookCommand{
+			Command: []string{"sh", "-c", `printf '{"response": {"statusCode": 202, "header": {"X-Hook": ["1"]}}}'`},
+			Events:  []string{"response"},
+			Hosts:   []string{"example.com"},
+		},
+		&addons.ExecHookCommand{
+			Command: []string{"sh", "-c", `printf '{"response": {"statusCode": 500}}'`},
+			Events:  []string{"response"},
+			Hosts:   []string{"other.com"},
+		},
+	)
+
+	f := newJSONLFlow(c, []byte("body"), []byte(`{"ok":true}`))
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "PUT")
+	c.Assert(string(f.Request.Body), qt.Equals, "hello")
+	c.Assert(f.Request.Header.Get("Content-Type"), qt.Equals, "application/octet-stream")
+	c.Assert(f.Request.Header.Get("Content-Length"), qt.Equals, "5")
+
+	adn.Response(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 202)
+	c.Assert(f.Response.Header.Get("X-Hook"), qt.Equals, "1")
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "")
+	c.Assert(string(f.Response.Body), qt.Equals, `{"ok":true}`)
+}
+
+func TestExecHookEarlyResponse(t *testing.T) {
+	c := qt.New(t)
+	adn := newExecHook(c, &addons.ExecHookCommand{
+		Command: []string{"sh", "-c", `printf '{"response": {"statusCode": 403, "body": "YmxvY2tlZA=="}}'`},
+		Events:  []string{"requestheaders"},
+	})
+
+	f := newJSONLFlow(c, nil, nil)
+	f.Response = nil
+	adn.Requestheaders(f)
+	c.Assert(f.Response, qt.IsNotNil)
+	c.Assert(f.Response.StatusCode, qt.Equals, 403)
+	c.Assert(string(f.Response.Body), qt.Equals, "blocked")
+}
+
+func TestExecHookFailures(t *testing.T) {
+	c := qt.New(t)
+	adn := newExecHook(c,
+		&addons.ExecHookCommand{Command: []string{"sh", "-c", `printf '{"request": {"method": "PUT"}}'; exit 1`}},
+		&addons.ExecHookCommand{Command: []string{"sh", "-c", `sleep 5; printf '{"request": {"method": "PUT"}}'`}, TimeoutMs: 100},
+		&addons.ExecHookCommand{Command: []string{"sh", "-c", `printf 'not json'`}},
+	)
+
+	// failed programs leave the flow as it is
+	f := newJSONLFlow(c, []byte("body"), nil)
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "POST")
+	c.Assert(string(f.Request.Body), qt.Equals, "body")
+}
+
+func TestExecHookConfigErrors(t *testing.T) {
+	c := qt.New(t)
+	tests := []struct {
+		name    string
+		command *addons.ExecHookCommand
+		err     string
+	}{
+		{"no command", &addons.ExecHookCommand{}, "0 empty Command"},
+		{"missing program", &addons.ExecHookCommand{Command: []string{"no-such-program-for-exec-hook"}}, `0 exec: "no-such-program-for-exec-hook": executable file not found in \$PATH`},
+		{"unknown event", &addons.ExecHookCommand{Command: []string{"cat"}, Events: []string{"connect"}}, `0 unknown event "connect", want one of requestheaders, request, responseheaders, response`},
+		{"negative timeout", &addons.ExecHookCommand{Command: []string{"cat"}, TimeoutMs: -1}, "0 negative TimeoutMs or MaxConcurrent"},
+	}
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			_, err := addons.NewExecHook(addons.ExecHookConfig{Commands: []*addons.ExecHookCommand{tt.command}})
+			c.Assert(err, qt.ErrorMatches, tt.err)
+		})
+	}
+}
@@ -0,0 +1,490 @@
+package addons
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/tnetstring"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// flowFormatVersion is the version of the Python mitmproxy flow format that is written.
+// mitmproxy upgrades older versions when it loads them.
+const flowFormatVersion = 19
+
+// FlowWriter saves finished flows in the flow file format of Python mitmproxy, so
+// captures can be opened in mitmweb or replayed with mitmdump.
+type FlowWriter struct {
+	proxy.BaseAddon
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer // the file opened by NewFlowWriterFromFile
+}
+
+func NewFlowWriter(out io.Writer) *FlowWriter {
+	return &FlowWriter{out: out}
+}
+
+// NewFlowWriterFromFile creates a FlowWriter appending to the file at filename.
+func NewFlowWriterFromFile(filename string) (*FlowWriter, error) {
+	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	adn := NewFlowWriter(out)
+	adn.closer = out
+	return adn, nil
+}
+
+// Running implements proxy.LifecycleAddon.
+func (adn *FlowWriter) Running(*proxy.Proxy) {}
+
+// Done closes the file of a FlowWriter created with NewFlowWriterFromFile. Flows
+// finishing later are not written.
+func (adn *FlowWriter) Done() {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	if adn.closer == nil {
+		return
+	}
+	if err := adn.closer.Close(); err != nil {
+		slog.Error("failed to close flow file", "error", err)
+	}
+	adn.closer = nil
+	adn.out = io.Discard
+}
+
+func (adn *FlowWriter) Requestheaders(f *proxy.Flow) {
+	start := time.Now()
+	go func() {
+		<-f.Done()
+		adn.mu.Lock()
+		defer adn.mu.Unlock()
+		if err := writeFlow(adn.out, f, start, time.Now()); err != nil {
+			slog.Error("failed to write flow", "error", err)
+		}
+	}()
+}
+
+// WriteFlow writes f in the mitmproxy flow format. The flow has no timings, so the
+// current time is recorded for all of them.
+func WriteFlow(out io.Writer, f *proxy.Flow) error {
+	now := time.Now()
+	return writeFlow(out, f, now, now)
+}
+
+func writeFlow(out io.Writer, f *proxy.Flow, start, end time.Time) error {
+	data, err := tnetstring.Marshal(flowState(f, start, end))
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+func flowState(f *proxy.Flow, start, end time.Time) map[string]any {
+	state := map[string]any{
+		"version":           flowFormatVersion,
+		"type":              "http",
+		"id":                f.ID.String(),
+		"error":             nil,
+		"client_conn":       clientConnState(f.ConnContext, start),
+		"server_conn":       serverConnState(f.ConnContext, start),
+		"intercepted":       false,
+		"is_replay":         nil,
+		"marked":            "",
+		"metadata":          map[string]any{},
+		"comment":           "",
+		"timestamp_created": timestamp(start),
+		"request":           requestState(f, start),
+		"response":          nil,
+		"websocket":         nil,
+	}
+	if f.Response != nil {
+		state["response"] = responseState(f, start, end)
+	}
+	return state
+}
+
+func requestState(f *proxy.Flow, start time.Time) map[string]any {
+	req := f.Request
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	portNumber, _ := strconv.Atoi(port)
+
+	// HTTP/1 requests carry the host in a header, HTTP/2 and HTTP/3 ones in the authority.
+	authority := ""
+	header := req.Header
+	if strings.HasPrefix(req.Proto, "HTTP/1") {
+		if header.Get("Host") == "" {
+			header = header.Clone()
+			header.Set("Host", req.URL.Host)
+		}
+	} else {
+		authority = req.URL.Host
+	}
+
+	return map[string]any{
+		"host":            req.URL.Hostname(),
+		"port":            portNumber,
+		"method":          []byte(req.Method),
+		"scheme":          []byte(req.URL.Scheme),
+		"authority":       []byte(authority),
+		"path":            []byte(req.URL.RequestURI()),
+		"http_version":    []byte(req.Proto),
+		"headers":         headersState(header),
+		"content":         contentState(req.Body, f.Stream),
+		"trailers":        trailersState(req.Trailer),
+		"timestamp_start": timestamp(start),
+		"timestamp_end":   timestamp(start),
+	}
+}
+
+func responseState(f *proxy.Flow, start, end time.Time) map[string]any {
+	res := f.Response
+	return map[string]any{
+		"http_version":    []byte(f.Request.Proto),
+		"status_code":     res.StatusCode,
+		"reason":          []byte(http.StatusText(res.StatusCode)),
+		"headers":         headersState(res.Header),
+		"content":         contentState(res.Body, f.Stream),
+		"trailers":        trailersState(res.Trailer),
+		"timestamp_start": timestamp(start),
+		"timestamp_end":   timestamp(end),
+	}
+}
+
+// headersState lists the header fields as (name, value) pairs, names sorted.
+func headersState(header http.Header) []any {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	fields := make([]any, 0, len(names))
+	for _, name := range names {
+		for _, value := range header[name] {
+			fields = append(fields, []any{[]byte(name), []byte(value)})
+		}
+	}
+	return fields
+}
+
+func trailersState(trailer http.Header) any {
+	if len(trailer) == 0 {
+		return nil
+	}
+	return headersState(trailer)
+}
+
+// contentState returns the body, or nil (content missing) for streamed flows.
+func contentState(body []byte, stream bool) any {
+	if stream && body == nil {
+		return nil
+	}
+	if body == nil {
+		return []byte{}
+	}
+	return body
+}
+
+func clientConnState(connCtx *proxy.ConnContext, start time.Time) map[string]any {
+	state := connState(start)
+	state["proxy_mode"] = "regular"
+	state["mitmcert"] = nil
+	state["peername"] = nil
+	state["sockname"] = nil
+	if connCtx == nil || connCtx.ClientConn == nil {
+		return state
+	}
+
+	client := connCtx.ClientConn
+	if client.Conn != nil {
+		state["peername"] = addressState(client.Conn.RemoteAddr().String())
+		state["sockname"] = addressState(client.Conn.LocalAddr().String())
+	}
+	state["id"] = client.ID.String()
+	state["tls"] = client.TLS
+	if client.NegotiatedProtocol != "" {
+		state["alpn"] = []byte(client.NegotiatedProtocol)
+	}
+	alpnOffers := make([]any, 0, len(client.OfferedALPN))
+	for _, proto := range client.OfferedALPN {
+		alpnOffers = append(alpnOffers, []byte(proto))
+	}
+	state["alpn_offers"] = alpnOffers
+	if client.ClientHello != nil && client.ClientHello.ServerName != "" {
+		state["sni"] = client.ClientHello.ServerName
+	}
+	return state
+}
+
+func serverConnState(connCtx *proxy.ConnContext, start time.Time) map[string]any {
+	state := connState(start)
+	state["address"] = nil
+	state["peername"] = nil
+	state["sockname"] = nil
+	state["timestamp_tcp_setup"] = nil
+	state["via"] = nil
+	if connCtx == nil || connCtx.ServerConn == nil {
+		return state
+	}
+
+	server := connCtx.ServerConn
+	state["id"] = server.ID.String()
+	state["address"] = addressState(server.Address)
+	if server.Conn != nil {
+		state["peername"] = addressState(server.Conn.RemoteAddr().String())
+		state["sockname"] = addressState(server.Conn.LocalAddr().String())
+		state["timestamp_tcp_setup"] = timestamp(start)
+	}
+	if tlsState := server.TLSState; tlsState != nil {
+		state["tls"] = true
+		state["tls_version"] = tlsVersionName(tlsState.Version)
+		state["cipher"] = tlsCipherName(tlsState.CipherSuite)
+		state["sni"] = tlsState.ServerName
+		if tlsState.NegotiatedProtocol != "" {
+			state["alpn"] = []byte(tlsState.NegotiatedProtocol)
+		}
+		state["timestamp_tls_setup"] = timestamp(start)
+	}
+	return state
+}
+
+// connState returns the fields client and server connections have in common.
+func connState(start time.Time) map[string]any {
+	return map[string]any{
+		"id":                  uuid.NewV4().String(),
+		"state":               0, // closed
+		"error":               nil,
+		"tls":                 false,
+		"certificate_list":    []any{},
+		"alpn":                nil,
+		"alpn_offers":         []any{},
+		"cipher":              nil,
+		"cipher_list":         []any{},
+		"tls_version":         nil,
+		"sni":                 nil,
+		"timestamp_start":     timestamp(start),
+		"timestamp_end":       nil,
+		"timestamp_tls_setup": nil,
+		"transport_protocol":  "tcp",
+	}
+}
+
+func addressState(address string) any {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+	return []any{host, portNumber}
+}
+
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1e6
+}
+
+// ReadFlows reads the HTTP flows of a mitmproxy flow file. Flows of other types, such
+// as TCP or DNS flows, are skipped. The read flows have a request and, if one was
+// recorded, a response; connection details are not restored.
+func ReadFlows(in io.Reader) ([]*proxy.Flow, error) {
+	var flows []*proxy.Flow
+	decoder := tnetstring.NewDecoder(in)
+	for {
+		value, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			return flows, nil
+		}
+		if err != nil {
+			return flows, err
+		}
+		state, ok := value.(map[string]any)
+		if !ok {
+			return flows, fmt.Errorf("flow %d: not a dictionary", len(flows)+1)
+		}
+		if flowType, _ := state["type"].(string); flowType != "http" {
+			continue
+		}
+		f, err := flowFromState(state)
+		if err != nil {
+			return flows, fmt.Errorf("flow %d: %w", len(flows)+1, err)
+		}
+		flows = append(flows, f)
+	}
+}
+
+func flowFromState(state map[string]any) (*proxy.Flow, error) {
+	f := proxy.NewFlow()
+	if id, err := uuid.FromString(stringField(state, "id")); err == nil {
+		f.ID = id
+	}
+
+	reqState, ok := state["request"].(map[string]any)
+	if !ok {
+		return nil, errors.New("missing request")
+	}
+	req, err := requestFromState(reqState)
+	if err != nil {
+		return nil, err
+	}
+	f.Request = req
+
+	if resState, ok := state["response"].(map[string]any); ok {
+		res, err := responseFromState(resState)
+		if err != nil {
+			return nil, err
+		}
+		f.Response = res
+	}
+	f.Finish()
+	return f, nil
+}
+
+func requestFromState(state map[string]any) (*proxy.Request, error) {
+	header, err := headersFromState(state["headers"])
+	if err != nil {
+		return nil, err
+	}
+	trailer, err := headersFromState(state["trailers"])
+	if err != nil {
+		return nil, err
+	}
+
+	host := stringField(state, "authority")
+	if host == "" {
+		host = header.Get("Host")
+	}
+	if host == "" {
+		host = stringField(state, "host")
+		port, _ := state["port"].(int64)
+		scheme := stringField(state, "scheme")
+		if (scheme == "http" && port != 80) || (scheme == "https" && port != 443) {
+			host = net.JoinHostPort(host, strconv.FormatInt(port, 10))
+		}
+	}
+	header.Del("Host")
+
+	u, err := url.Parse(stringField(state, "scheme") + "://" + host + stringField(state, "path"))
+	if err != nil {
+		return nil, err
+	}
+	body, _ := state["content"].([]byte)
+	if len(trailer) == 0 {
+		trailer = nil
+	}
+
+	return &proxy.Request{
+		Method:  stringField(state, "method"),
+		URL:     u,
+		Proto:   stringField(state, "http_version"),
+		Header:  header,
+		Body:    body,
+		Trailer: trailer,
+	}, nil
+}
+
+func responseFromState(state map[string]any) (*proxy.Response, error) {
+	header, err := headersFromState(state["headers"])
+	if err != nil {
+		return nil, err
+	}
+	trailer, err := headersFromState(state["trailers"])
+	if err != nil {
+		return nil, err
+	}
+	statusCode, ok := state["status_code"].(int64)
+	if !ok {
+		return nil, errors.New("missing response status code")
+	}
+	body, _ := state["content"].([]byte)
+	if len(trailer) == 0 {
+		trailer = nil
+	}
+
+	return &proxy.Response{
+		StatusCode: int(statusCode),
+		Header:     header,
+		Body:       body,
+		Trailer:    trailer,
+	}, nil
+}
+
+func headersFromState(value any) (http.Header, error) {
+	header := make(http.Header)
+	if value == nil {
+		return header, nil
+	}
+	fields, ok := value.([]any)
+	if !ok {
+		return nil, errors.New("malformed headers")
+	}
+	for _, field := range fields {
+		pair, ok := field.([]any)
+		if !ok || len(pair) != 2 {
+			return nil, errors.New("malformed header field")
+		}
+		name, nameOK := pair[0].([]byte)
+		value, valueOK := pair[1].([]byte)
+		if !nameOK || !valueOK {
+			return nil, errors.New("malformed header field")
+		}
+		header.Add(string(name), string(value))
+	}
+	return header, nil
+}
+
+// stringField returns a text or bytes field of state as a string.
+func stringField(state map[string]any, key string) string {
+	switch v := state[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// tlsVersionName names version like Python's ssl module does, e.g. "TLSv1.3".
+func tlsVersionName(version uint16) any {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return nil
+}
+
+func tlsCipherName(id uint16) any {
+	if id == 0 {
+		return nil
+	}
+	return tls.CipherSuiteName(id)
+}
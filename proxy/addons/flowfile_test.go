@@ -0,0 +1,197 @@
+package addons_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/tnetstring"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newFileFlow(c *qt.C, rawURL, proto string) *proxy.Flow {
+	u, err := url.Parse(rawURL)
+	c.Assert(err, qt.IsNil)
+	f := proxy.NewFlow()
+	f.Request = &proxy.Request{
+		Method: "POST",
+		URL:    u,
+		Proto:  proto,
+		Header: http.Header{"Content-Type": {"text/plain"}, "X-Multi": {"a", "b"}},
+		Body:   []byte("ping"),
+	}
+	f.Response = &proxy.Response{
+		StatusCode: 201,
+		Header:     http.Header{"Content-Encoding": {"gzip"}},
+		Body:       []byte("\x1f\x8b raw"),
+		Trailer:    http.Header{"Grpc-Status": {"0"}},
+	}
+	return f
+}
+
+func TestWriteFlowState(t *testing.T) {
+	c := qt.New(t)
+	f := newFileFlow(c, "https://example.com:8443/path?q=1", "HTTP/1.1")
+
+	var buf bytes.Buffer
+	c.Assert(addons.WriteFlow(&buf, f), qt.IsNil)
+
+	value, err := tnetstring.Unmarshal(buf.Bytes())
+	c.Assert(err, qt.IsNil)
+	state := value.(map[string]any)
+	c.Assert(state["version"], qt.Equals, int64(19))
+	c.Assert(state["type"], qt.Equals, "http")
+	c.Assert(state["id"], qt.Equals, f.ID.String())
+	c.Assert(state["client_conn"], qt.Not(qt.IsNil))
+	c.Assert(state["server_conn"], qt.Not(qt.IsNil))
+
+	req := state["request"].(map[string]any)
+	c.Assert(req["host"], qt.Equals, "example.com")
+	c.Assert(req["port"], qt.Equals, int64(8443))
+	c.Assert(req["method"], qt.DeepEquals, []byte("POST"))
+	c.Assert(req["scheme"], qt.DeepEquals, []byte("https"))
+	c.Assert(req["authority"], qt.DeepEquals, []byte(""))
+	c.Assert(req["path"], qt.DeepEquals, []byte("/path?q=1"))
+	c.Assert(req["http_version"], qt.DeepEquals, []byte("HTTP/1.1"))
+	c.Assert(req["content"], qt.DeepEquals, []byte("ping"))
+	c.Assert(req["headers"], qt.DeepEquals, []any{
+		[]any{[]byte("Content-Type"), []byte("text/plain")},
+		[]any{[]byte("Host"), []byte("example.com:8443")},
+		[]any{[]byte("X-Multi"), []byte("a")},
+		[]any{[]byte("X-Multi"), []byte("b")},
+	})
+
+	res := state["response"].(map[string]any)
+	c.Assert(res["status_code"], qt.Equals, int64(201))
+	c.Assert(res["reason"], qt.DeepEquals, []byte("Created"))
+	c.Assert(res["content"], qt.DeepEquals, []byte("\x1f\x8b raw"))
+	c.Assert(res["trailers"], qt.DeepEquals, []any{[]any{[]byte("Grpc-Status"), []byte("0")}})
+}
+
+func TestFlowFileRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name  string
+		url   string
+		proto string
+	}{
+		{name: "HTTP/1.1", url: "http://example.com/a?b=c", proto: "HTTP/1.1"},
+		{name: "HTTP/2 with port", url: "https://example.com:8443/", proto: "HTTP/2.0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			f := newFileFlow(c, tc.url, tc.proto)
+
+			var buf bytes.Buffer
+			c.Assert(addons.WriteFlow(&buf, f), qt.IsNil)
+			c.Assert(addons.WriteFlow(&buf, f), qt.IsNil)
+
+			flows, err := addons.ReadFlows(&buf)
+			c.Assert(err, qt.IsNil)
+			c.Assert(flows, qt.HasLen, 2)
+			got := flows[0]
+			c.Assert(got.ID, qt.Equals, f.ID)
+			c.Assert(got.Request.Method, qt.Equals, "POST")
+			c.Assert(got.Request.URL.String(), qt.Equals, tc.url)
+			c.Assert(got.Request.Proto, qt.Equals, tc.proto)
+			c.Assert(got.Request.Header, qt.DeepEquals, f.Request.Header)
+			c.Assert(got.Request.Body, qt.DeepEquals, []byte("ping"))
+			c.Assert(got.Response.StatusCode, qt.Equals, 201)
+			c.Assert(got.Response.Header, qt.DeepEquals, f.Response.Header)
+			c.Assert(got.Response.Body, qt.DeepEquals, f.Response.Body)
+			c.Assert(got.Response.Trailer, qt.DeepEquals, f.Response.Trailer)
+
+			select {
+			case <-got.Done():
+			default:
+				c.Fatal("read flow is not finished")
+			}
+		})
+	}
+}
+
+func TestReadFlowsFromMitmproxy(t *testing.T) {
+	c := qt.New(t)
+	headers := []any{[]any{[]byte("accept"), []byte("*/*")}}
+	httpFlow := map[string]any{
+		"version": int64(20),
+		"type":    "http",
+		"id":      "not-a-uuid",
+		"request": map[string]any{
+			"host":         "example.org",
+			"port":         int64(443),
+			"method":       []byte("GET"),
+			"scheme":       []byte("https"),
+			"authority":    []byte("example.org"),
+			"path":         []byte("/index.html"),
+			"http_version": []byte("HTTP/2.0"),
+			"headers":      headers,
+			"content":      []byte{},
+			"trailers":     nil,
+		},
+		"response": nil,
+	}
+	tcpFlow := map[string]any{"version": int64(20), "type": "tcp", "messages": []any{}}
+
+	var buf bytes.Buffer
+	for _, state := range []map[string]any{tcpFlow, httpFlow} {
+		data, err := tnetstring.Marshal(state)
+		c.Assert(err, qt.IsNil)
+		buf.Write(data)
+	}
+
+	flows, err := addons.ReadFlows(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(flows, qt.HasLen, 1)
+	c.Assert(flows[0].Request.URL.String(), qt.Equals, "https://example.org/index.html")
+	c.Assert(flows[0].Request.Header, qt.DeepEquals, http.Header{"Accept": {"*/*"}})
+	c.Assert(flows[0].Response, qt.IsNil)
+}
+
+func TestReadFlowsErrors(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := addons.ReadFlows(bytes.NewBufferString("5:hello,"))
+	c.Assert(err, qt.ErrorMatches, `flow 1: not a dictionary`)
+
+	data, err := tnetstring.Marshal(map[string]any{"type": "http"})
+	c.Assert(err, qt.IsNil)
+	_, err = addons.ReadFlows(bytes.NewReader(data))
+	c.Assert(err, qt.ErrorMatches, `flow 1: missing request`)
+}
+
+type syncBuffer struct {
+	bytes.Buffer
+	written chan struct{}
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	defer close(b.written)
+	return b.Buffer.Write(p)
+}
+
+func TestFlowWriter(t *testing.T) {
+	c := qt.New(t)
+	out := &syncBuffer{written: make(chan struct{})}
+	writer := addons.NewFlowWriter(out)
+
+	f := newFileFlow(c, "http://example.com/", "HTTP/1.1")
+	writer.Requestheaders(f)
+	f.Finish()
+
+	select {
+	case <-out.written:
+	case <-time.After(time.Second):
+		c.Fatal("flow was not written")
+	}
+	flows, err := addons.ReadFlows(&out.Buffer)
+	c.Assert(err, qt.IsNil)
+	c.Assert(flows, qt.HasLen, 1)
+	c.Assert(flows[0].ID, qt.Equals, f.ID)
+}
@@ -0,0 +1,364 @@
+package addons
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// GRPCMessage is one length-prefixed message of a gRPC body.
+type GRPCMessage struct {
+	Compressed bool
+	Data       []byte
+}
+
+// SplitGRPCMessages splits a gRPC body into its length-prefixed messages.
+func SplitGRPCMessages(body []byte) ([]GRPCMessage, error) {
+	var messages []GRPCMessage
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, errors.New("grpc: truncated message header")
+		}
+		size := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(size) {
+			return nil, errors.New("grpc: truncated message")
+		}
+		messages = append(messages, GRPCMessage{
+			Compressed: body[0]&1 != 0,
+			Data:       body[5 : 5+size],
+		})
+		body = body[5+size:]
+	}
+	return messages, nil
+}
+
+// JoinGRPCMessages builds a gRPC body from messages.
+func JoinGRPCMessages(messages []GRPCMessage) []byte {
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		var header [5]byte
+		if msg.Compressed {
+			header[0] = 1
+		}
+		binary.BigEndian.PutUint32(header[1:], uint32(len(msg.Data)))
+		buf.Write(header[:])
+		buf.Write(msg.Data)
+	}
+	return buf.Bytes()
+}
+
+// LoadGRPCDescriptorSet reads a binary FileDescriptorSet, as written by
+// protoc --include_imports --descriptor_set_out.
+func LoadGRPCDescriptorSet(filename string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("grpc: invalid descriptor set: %w", err)
+	}
+	return protodesc.NewFiles(&set)
+}
+
+// GRPCDecoder decodes the messages of gRPC requests and responses to a JSON array, so
+// that dumps, the web interface and later addons see readable bodies. The bodies are
+// replaced in the Request and Response events and encoded back to gRPC messages when
+// they are sent on, including any change made to the JSON.
+//
+// Messages of methods described by Files are decoded with protojson. Other messages are
+// decoded schema-less, like protoc --decode_raw, to a list of fields with their wire
+// types. Streamed bodies are left untouched.
+type GRPCDecoder struct {
+	proxy.BaseAddon
+	Files *protoregistry.Files // optional
+
+	decoded sync.Map // grpcBodyKey -> *grpcDecodedBody
+}
+
+type grpcBodyKey struct {
+	flow     *proxy.Flow
+	response bool
+}
+
+type grpcDecodedBody struct {
+	original []byte
+	desc     protoreflect.MessageDescriptor // nil for schema-less JSON
+}
+
+func NewGRPCDecoder(files *protoregistry.Files) *GRPCDecoder {
+	return &GRPCDecoder{Files: files}
+}
+
+func (adn *GRPCDecoder) Request(f *proxy.Flow) {
+	if !isGRPCContentType(f.Request.Header.Get("Content-Type")) {
+		return
+	}
+	body, ok := adn.decode(f, f.Request.Header, f.Request.Body, false)
+	if ok {
+		f.Request.Body = body
+	}
+}
+
+func (adn *GRPCDecoder) Response(f *proxy.Flow) {
+	if f.Response == nil || !isGRPCContentType(f.Response.Header.Get("Content-Type")) {
+		return
+	}
+	body, ok := adn.decode(f, f.Response.Header, f.Response.Body, true)
+	if ok {
+		f.Response.Body = body
+		f.Response.Header.Del("Content-Length")
+	}
+}
+
+func (adn *GRPCDecoder) StreamRequestModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	return adn.encode(f, in, false)
+}
+
+func (adn *GRPCDecoder) StreamResponseModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	return adn.encode(f, in, true)
+}
+
+func (adn *GRPCDecoder) decode(f *proxy.Flow, header http.Header, body []byte, response bool) ([]byte, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	messages, err := SplitGRPCMessages(body)
+	if err != nil {
+		slog.Debug("grpc decode skipped", "url", f.Request.URL.String(), "error", err)
+		return nil, false
+	}
+	encoding := header.Get("Grpc-Encoding")
+
+	desc := adn.messageDescriptor(f.Request.URL.Path, response)
+	items := make([]json.RawMessage, 0, len(messages))
+	for _, msg := range messages {
+		data := msg.Data
+		if msg.Compressed {
+			if data, err = gunzipGRPC(encoding, data); err != nil {
+				slog.Debug("grpc decode skipped", "url", f.Request.URL.String(), "error", err)
+				return nil, false
+			}
+		}
+		item, err := grpcMessageToJSON(desc, data)
+		if err != nil {
+			slog.Debug("grpc decode skipped", "url", f.Request.URL.String(), "error", err)
+			return nil, false
+		}
+		items = append(items, item)
+	}
+
+	out, err := json.Marshal(items)
+	if err != nil {
+		return nil, false
+	}
+	key := grpcBodyKey{flow: f, response: response}
+	adn.decoded.Store(key, &grpcDecodedBody{original: body, desc: desc})
+	go func() {
+		// the body is not sent on if a later addon answers the request itself
+		<-f.Done()
+		adn.decoded.Delete(key)
+	}()
+	return out, true
+}
+
+func (adn *GRPCDecoder) encode(f *proxy.Flow, in io.Reader, response bool) io.Reader {
+	v, ok := adn.decoded.LoadAndDelete(grpcBodyKey{flow: f, response: response})
+	if !ok {
+		return in
+	}
+	state := v.(*grpcDecodedBody)
+
+	body, err := io.ReadAll(in)
+	if err != nil {
+		slog.Warn("grpc encode failed, sending original body", "url", f.Request.URL.String(), "error", err)
+		return bytes.NewReader(state.original)
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		slog.Warn("grpc encode failed, sending original body", "url", f.Request.URL.String(), "error", err)
+		return bytes.NewReader(state.original)
+	}
+	messages := make([]GRPCMessage, 0, len(items))
+	for _, item := range items {
+		data, err := grpcMessageFromJSON(state.desc, item)
+		if err != nil {
+			slog.Warn("grpc encode failed, sending original body", "url", f.Request.URL.String(), "error", err)
+			return bytes.NewReader(state.original)
+		}
+		messages = append(messages, GRPCMessage{Data: data})
+	}
+	return bytes.NewReader(JoinGRPCMessages(messages))
+}
+
+// messageDescriptor returns the input or output type of the method at path /pkg.Service/Method.
+func (adn *GRPCDecoder) messageDescriptor(path string, response bool) protoreflect.MessageDescriptor {
+	if adn.Files == nil {
+		return nil
+	}
+	service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok {
+		return nil
+	}
+	d, err := adn.Files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil
+	}
+	if response {
+		return md.Output()
+	}
+	return md.Input()
+}
+
+func isGRPCContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/grpc") && !strings.HasPrefix(contentType, "application/grpc-web")
+}
+
+func gunzipGRPC(encoding string, data []byte) ([]byte, error) {
+	if encoding != "gzip" {
+		return nil, fmt.Errorf("grpc: unsupported message encoding %q", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func grpcMessageToJSON(desc protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	if desc == nil {
+		fields, err := decodeRawProto(data)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(fields)
+	}
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(msg)
+}
+
+func grpcMessageFromJSON(desc protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	if desc == nil {
+		var fields []rawProtoField
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		return encodeRawProto(fields)
+	}
+	msg := dynamicpb.NewMessage(desc)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+// rawProtoField is a schema-less protobuf field. Exactly one value is set; length-delimited
+// values are shown as String when they are valid UTF-8 and as Bytes otherwise.
+type rawProtoField struct {
+	Field   protowire.Number `json:"field"`
+	Varint  *uint64          `json:"varint,omitempty"`
+	Fixed32 *uint32          `json:"fixed32,omitempty"`
+	Fixed64 *uint64          `json:"fixed64,omitempty"`
+	String  *string          `json:"string,omitempty"`
+	Bytes   []byte           `json:"bytes,omitempty"`
+}
+
+func decodeRawProto(data []byte) ([]rawProtoField, error) {
+	fields := make([]rawProtoField, 0)
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := rawProtoField{Field: num}
+		switch typ {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(data)
+			field.Varint, n = &v, m
+		case protowire.Fixed32Type:
+			v, m := protowire.ConsumeFixed32(data)
+			field.Fixed32, n = &v, m
+		case protowire.Fixed64Type:
+			v, m := protowire.ConsumeFixed64(data)
+			field.Fixed64, n = &v, m
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(data)
+			if utf8.Valid(v) {
+				s := string(v)
+				field.String = &s
+			} else {
+				field.Bytes = v
+			}
+			n = m
+		default:
+			return nil, fmt.Errorf("grpc: unsupported wire type %d", typ)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func encodeRawProto(fields []rawProtoField) ([]byte, error) {
+	var b []byte
+	for _, field := range fields {
+		switch {
+		case field.Varint != nil:
+			b = protowire.AppendTag(b, field.Field, protowire.VarintType)
+			b = protowire.AppendVarint(b, *field.Varint)
+		case field.Fixed32 != nil:
+			b = protowire.AppendTag(b, field.Field, protowire.Fixed32Type)
+			b = protowire.AppendFixed32(b, *field.Fixed32)
+		case field.Fixed64 != nil:
+			b = protowire.AppendTag(b, field.Field, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, *field.Fixed64)
+		case field.String != nil:
+			b = protowire.AppendTag(b, field.Field, protowire.BytesType)
+			b = protowire.AppendString(b, *field.String)
+		case field.Bytes != nil:
+			b = protowire.AppendTag(b, field.Field, protowire.BytesType)
+			b = protowire.AppendBytes(b, field.Bytes)
+		default:
+			return nil, fmt.Errorf("grpc: field %d has no value", field.Field)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,190 @@
+package addons_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+// shopDescriptorSet describes service shop.Cart { rpc Add(Item) returns (Item); }
+// with message Item { string name = 1; int64 qty = 2; }.
+func shopDescriptorSet() *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("shop.proto"),
+			Package: proto.String("shop"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:     proto.String("name"),
+						JsonName: proto.String("name"),
+						Number:   proto.Int32(1),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					},
+					{
+						Name:     proto.String("qty"),
+						JsonName: proto.String("qty"),
+						Number:   proto.Int32(2),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+					},
+				},
+			}},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("Cart"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String("Add"),
+					InputType:  proto.String(".shop.Item"),
+					OutputType: proto.String(".shop.Item"),
+				}},
+			}},
+		}},
+	}
+}
+
+// item encodes shop.Item without generated code.
+func item(name string, qty uint64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, qty)
+	return b
+}
+
+func TestSplitAndJoinGRPCMessages(t *testing.T) {
+	c := qt.New(t)
+
+	messages := []addons.GRPCMessage{
+		{Data: []byte("first")},
+		{Compressed: true, Data: []byte("second")},
+		{Data: []byte{}},
+	}
+	body := addons.JoinGRPCMessages(messages)
+	c.Assert(body[:5], qt.DeepEquals, []byte{0, 0, 0, 0, 5})
+
+	split, err := addons.SplitGRPCMessages(body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(split, qt.DeepEquals, messages)
+
+	_, err = addons.SplitGRPCMessages(body[:len(body)-8])
+	c.Assert(err, qt.ErrorMatches, "grpc: truncated message")
+	_, err = addons.SplitGRPCMessages([]byte{0, 0})
+	c.Assert(err, qt.ErrorMatches, "grpc: truncated message header")
+}
+
+func TestGRPCDecoderWithDescriptors(t *testing.T) {
+	c := qt.New(t)
+
+	data, err := proto.Marshal(shopDescriptorSet())
+	c.Assert(err, qt.IsNil)
+	filename := filepath.Join(t.TempDir(), "shop.protoset")
+	c.Assert(os.WriteFile(filename, data, 0o600), qt.IsNil)
+	files, err := addons.LoadGRPCDescriptorSet(filename)
+	c.Assert(err, qt.IsNil)
+	decoder := addons.NewGRPCDecoder(files)
+
+	flow := newGRPCFlow("/shop.Cart/Add", "application/grpc")
+	flow.Request.Body = addons.JoinGRPCMessages([]addons.GRPCMessage{{Data: item("apple", 3)}})
+	decoder.Request(flow)
+	c.Assert(string(flow.Request.Body), qt.JSONEquals, []any{map[string]any{"name": "apple", "qty": "3"}})
+
+	// a later addon changes the decoded message
+	flow.Request.Body = []byte(`[{"name":"pear","qty":"5"}]`)
+	out, err := io.ReadAll(decoder.StreamRequestModifier(flow, bytes.NewReader(flow.Request.Body)))
+	c.Assert(err, qt.IsNil)
+	// field order on the wire is not fixed, so check the decoded message
+	check := newGRPCFlow("/shop.Cart/Add", "application/grpc")
+	check.Request.Body = out
+	decoder.Request(check)
+	c.Assert(string(check.Request.Body), qt.JSONEquals, []any{map[string]any{"name": "pear", "qty": "5"}})
+
+	// the response of the same flow is decoded separately
+	flow.Response.Header.Set("Grpc-Encoding", "gzip")
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	_, _ = zw.Write(item("plum", 1))
+	c.Assert(zw.Close(), qt.IsNil)
+	flow.Response.Body = addons.JoinGRPCMessages([]addons.GRPCMessage{{Compressed: true, Data: zbuf.Bytes()}})
+	decoder.Response(flow)
+	c.Assert(string(flow.Response.Body), qt.JSONEquals, []any{map[string]any{"name": "plum", "qty": "1"}})
+	out, err = io.ReadAll(decoder.StreamResponseModifier(flow, bytes.NewReader(flow.Response.Body)))
+	c.Assert(err, qt.IsNil)
+	messages, err := addons.SplitGRPCMessages(out)
+	c.Assert(err, qt.IsNil)
+	c.Assert(messages, qt.HasLen, 1)
+	c.Assert(messages[0].Compressed, qt.IsFalse)
+}
+
+func TestGRPCDecoderSchemaLess(t *testing.T) {
+	c := qt.New(t)
+	decoder := addons.NewGRPCDecoder(nil)
+
+	msg := item("apple", 3)
+	msg = protowire.AppendTag(msg, 3, protowire.Fixed32Type)
+	msg = protowire.AppendFixed32(msg, 7)
+	msg = protowire.AppendTag(msg, 4, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, []byte{0xff, 0x00})
+	flow := newGRPCFlow("/shop.Cart/Add", "application/grpc")
+	flow.Request.Body = addons.JoinGRPCMessages([]addons.GRPCMessage{{Data: msg}})
+
+	decoder.Request(flow)
+	c.Assert(string(flow.Request.Body), qt.JSONEquals, []any{[]any{
+		map[string]any{"field": 1, "string": "apple"},
+		map[string]any{"field": 2, "varint": 3},
+		map[string]any{"field": 3, "fixed32": 7},
+		map[string]any{"field": 4, "bytes": "/wA="},
+	}})
+
+	// unchanged JSON encodes back to the same message
+	out, err := io.ReadAll(decoder.StreamRequestModifier(flow, bytes.NewReader(flow.Request.Body)))
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.DeepEquals, addons.JoinGRPCMessages([]addons.GRPCMessage{{Data: msg}}))
+}
+
+func TestGRPCDecoderSkipsOtherBodies(t *testing.T) {
+	decoder := addons.NewGRPCDecoder(nil)
+
+	t.Run("non grpc content type", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Cart/Add", "application/grpc-web")
+		flow.Request.Body = addons.JoinGRPCMessages([]addons.GRPCMessage{{Data: item("apple", 3)}})
+		body := flow.Request.Body
+		decoder.Request(flow)
+		c.Assert(flow.Request.Body, qt.DeepEquals, body)
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Cart/Add", "application/grpc")
+		flow.Request.Body = []byte{0, 0, 0, 0, 9, 1}
+		decoder.Request(flow)
+		c.Assert(flow.Request.Body, qt.DeepEquals, []byte{0, 0, 0, 0, 9, 1})
+		in := bytes.NewReader(flow.Request.Body)
+		c.Assert(decoder.StreamRequestModifier(flow, in), qt.Equals, io.Reader(in))
+	})
+
+	t.Run("invalid JSON sends the original body", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Cart/Add", "application/grpc")
+		original := addons.JoinGRPCMessages([]addons.GRPCMessage{{Data: item("apple", 3)}})
+		flow.Request.Body = original
+		decoder.Request(flow)
+		out, err := io.ReadAll(decoder.StreamRequestModifier(flow, bytes.NewReader([]byte("not json"))))
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.DeepEquals, original)
+	})
+}
@@ -0,0 +1,71 @@
+package addons
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/match"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// GRPCStatusRule forces a gRPC status for calls whose method matches Method.
+type GRPCStatusRule struct {
+	Method  string // full method path, e.g. /pkg.Service/Method; supports * and ? wildcards
+	Status  int    // gRPC status code, e.g. 14 for UNAVAILABLE
+	Message string // optional grpc-message value
+}
+
+// GRPCStatusRewriter rewrites the grpc-status and grpc-message trailers of
+// matching gRPC responses. It is useful to test how gRPC clients handle
+// failures. Streamed responses do not pass through the Response event and are
+// left untouched.
+type GRPCStatusRewriter struct {
+	proxy.BaseAddon
+	Rules []GRPCStatusRule
+}
+
+func NewGRPCStatusRewriter(rules []GRPCStatusRule) *GRPCStatusRewriter {
+	return &GRPCStatusRewriter{Rules: rules}
+}
+
+func (adn *GRPCStatusRewriter) Response(f *proxy.Flow) {
+	if f.Response == nil || !isGRPCRequest(f.Request) {
+		return
+	}
+	rule := adn.match(f.Request.URL.Path)
+	if rule == nil {
+		return
+	}
+
+	// A trailers-only response carries the status in its headers.
+	target := f.Response.Trailer
+	if f.Response.Header.Get("Grpc-Status") != "" && target.Get("Grpc-Status") == "" {
+		target = f.Response.Header
+	}
+	if target == nil {
+		f.Response.Trailer = make(http.Header)
+		target = f.Response.Trailer
+	}
+
+	target.Set("Grpc-Status", strconv.Itoa(rule.Status))
+	if rule.Message != "" {
+		target.Set("Grpc-Message", rule.Message)
+	} else {
+		target.Del("Grpc-Message")
+	}
+}
+
+func (adn *GRPCStatusRewriter) match(method string) *GRPCStatusRule {
+	for i := range adn.Rules {
+		if match.Match(method, adn.Rules[i].Method) {
+			return &adn.Rules[i]
+		}
+	}
+	return nil
+}
+
+func isGRPCRequest(req *proxy.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
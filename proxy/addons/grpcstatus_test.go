@@ -0,0 +1,75 @@
+package addons_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func newGRPCFlow(method, contentType string) *proxy.Flow {
+	flow := types.NewFlow()
+	flow.Request = &proxy.Request{
+		Method: "POST",
+		URL:    &url.URL{Scheme: "https", Host: "grpc.example.com", Path: method},
+		Header: http.Header{"Content-Type": {contentType}},
+	}
+	flow.Response = &proxy.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Trailer:    http.Header{"Grpc-Status": {"0"}},
+	}
+	return flow
+}
+
+func TestGRPCStatusRewriter(t *testing.T) {
+	rewriter := addons.NewGRPCStatusRewriter([]addons.GRPCStatusRule{
+		{Method: "/shop.Cart/*", Status: 14, Message: "forced unavailable"},
+	})
+
+	t.Run("matched call gets overridden status", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Cart/Checkout", "application/grpc")
+		rewriter.Response(flow)
+		c.Assert(flow.Response.Trailer.Get("Grpc-Status"), qt.Equals, "14")
+		c.Assert(flow.Response.Trailer.Get("Grpc-Message"), qt.Equals, "forced unavailable")
+	})
+
+	t.Run("other method is untouched", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Catalog/List", "application/grpc")
+		rewriter.Response(flow)
+		c.Assert(flow.Response.Trailer.Get("Grpc-Status"), qt.Equals, "0")
+		c.Assert(flow.Response.Trailer.Get("Grpc-Message"), qt.Equals, "")
+	})
+
+	t.Run("non grpc request is untouched", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Cart/Checkout", "application/json")
+		rewriter.Response(flow)
+		c.Assert(flow.Response.Trailer.Get("Grpc-Status"), qt.Equals, "0")
+	})
+
+	t.Run("trailers-only response is rewritten in headers", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Cart/Checkout", "application/grpc+proto")
+		flow.Response.Trailer = nil
+		flow.Response.Header.Set("Grpc-Status", "5")
+		rewriter.Response(flow)
+		c.Assert(flow.Response.Header.Get("Grpc-Status"), qt.Equals, "14")
+		c.Assert(flow.Response.Trailer, qt.IsNil)
+	})
+
+	t.Run("missing trailers are created", func(t *testing.T) {
+		c := qt.New(t)
+		flow := newGRPCFlow("/shop.Cart/Checkout", "application/grpc")
+		flow.Response.Trailer = nil
+		rewriter.Response(flow)
+		c.Assert(flow.Response.Trailer.Get("Grpc-Status"), qt.Equals, "14")
+	})
+}
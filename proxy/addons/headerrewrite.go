@@ -0,0 +1,160 @@
+package addons
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// Header rewrite rule:
+//   Every enabled item whose From matches the request applies its operations, in
+//   file order: Request operations before the request is sent upstream, Response
+//   operations before the response headers reach other addons.
+//   Op "add" appends a value, "set" replaces all values and "remove" deletes the header.
+
+const (
+	headerOpAdd    = "add"
+	headerOpSet    = "set"
+	headerOpRemove = "remove"
+)
+
+type headerOperation struct {
+	Op    string
+	Name  string
+	Value string
+}
+
+func (op *headerOperation) apply(header http.Header) {
+	switch op.Op {
+	case headerOpAdd:
+		header.Add(op.Name, op.Value)
+	case headerOpSet:
+		header.Set(op.Name, op.Value)
+	case headerOpRemove:
+		header.Del(op.Name)
+	}
+}
+
+type headerRewriteItem struct {
+	From     *mapFrom
+	Request  []*headerOperation
+	Response []*headerOperation
+	Enable   bool
+}
+
+type HeaderRewrite struct {
+	proxy.BaseAddon
+	Items  []*headerRewriteItem
+	Enable bool
+
+	mu       sync.RWMutex // guards Items and Enable during reloads
+	filename string
+}
+
+// items returns the items to apply, none when the rewrite is disabled.
+func (hr *HeaderRewrite) items() []*headerRewriteItem {
+	hr.mu.RLock()
+	defer hr.mu.RUnlock()
+	if !hr.Enable {
+		return nil
+	}
+	return hr.Items
+}
+
+func (hr *HeaderRewrite) Requestheaders(f *proxy.Flow) {
+	for _, item := range hr.items() {
+		if !item.Enable || len(item.Request) == 0 || !item.From.match(f.Request) {
+			continue
+		}
+		if f.Request.Header == nil {
+			f.Request.Header = make(http.Header)
+		}
+		for _, op := range item.Request {
+			op.apply(f.Request.Header)
+		}
+		slog.Debug("header rewrite request", "url", f.Request.URL.String())
+	}
+}
+
+func (hr *HeaderRewrite) Responseheaders(f *proxy.Flow) {
+	if f.Response == nil {
+		return
+	}
+	for _, item := range hr.items() {
+		if !item.Enable || len(item.Response) == 0 || !item.From.match(f.Request) {
+			continue
+		}
+		if f.Response.Header == nil {
+			f.Response.Header = make(http.Header)
+		}
+		for _, op := range item.Response {
+			op.apply(f.Response.Header)
+		}
+		slog.Debug("header rewrite response", "url", f.Request.URL.String())
+	}
+}
+
+func (hr *HeaderRewrite) validate() error {
+	for i, item := range hr.Items {
+		if item.From == nil {
+			return fmt.Errorf("%v no item.From", i)
+		}
+		if item.From.Protocol != "" && item.From.Protocol != "http" && item.From.Protocol != "https" {
+			return fmt.Errorf("%v invalid item.From.Protocol %v", i, item.From.Protocol)
+		}
+		if len(item.Request) == 0 && len(item.Response) == 0 {
+			return fmt.Errorf("%v no item.Request or item.Response operations", i)
+		}
+		for _, ops := range [][]*headerOperation{item.Request, item.Response} {
+			for j, op := range ops {
+				if op.Name == "" {
+					return fmt.Errorf("%v operation %v without Name", i, j)
+				}
+				if op.Op != headerOpAdd && op.Op != headerOpSet && op.Op != headerOpRemove {
+					return fmt.Errorf("%v operation %v invalid Op %q", i, j, op.Op)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func NewHeaderRewriteFromFile(filename string) (*HeaderRewrite, error) {
+	var headerRewrite HeaderRewrite
+	if err := helper.NewStructFromFile(filename, &headerRewrite); err != nil {
+		return nil, err
+	}
+	if err := headerRewrite.validate(); err != nil {
+		return nil, err
+	}
+	headerRewrite.filename = filename
+	return &headerRewrite, nil
+}
+
+// ConfigFile returns the file the HeaderRewrite was loaded from.
+func (hr *HeaderRewrite) ConfigFile() string {
+	return hr.filename
+}
+
+// Reload loads the items of the file again, keeping the current ones if it is invalid.
+func (hr *HeaderRewrite) Reload() error {
+	loaded, err := NewHeaderRewriteFromFile(hr.filename)
+	if err != nil {
+		return err
+	}
+	hr.mu.Lock()
+	hr.Items, hr.Enable = loaded.Items, loaded.Enable
+	hr.mu.Unlock()
+	return nil
+}
+
+// ValidateHeaderRewriteFile checks that every rule of the header rewrite config in
+// filename has a source and valid operations.
+func ValidateHeaderRewriteFile(filename string) error {
+	_, err := NewHeaderRewriteFromFile(filename)
+	return err
+}
@@ -0,0 +1,84 @@
+package addons_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func TestHeaderRewrite(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "headers.json")
+	c.Assert(os.WriteFile(filename, []byte(`{"Enable":true,"Items":[
+		{"Enable":true,"From":{"Host":"example.com","Method":["POST"],"Path":"/up*"},
+		 "Request":[{"Op":"set","Name":"X-Env","Value":"staging"},{"Op":"remove","Name":"Cookie"}],
+		 "Response":[{"Op":"add","Name":"Access-Control-Allow-Origin","Value":"*"}]},
+		{"Enable":true,"From":{},"Request":[{"Op":"add","Name":"X-Env","Value":"all"}]},
+		{"Enable":false,"From":{},"Response":[{"Op":"remove","Name":"Content-Type"}]},
+		{"Enable":true,"From":{"Host":"other.com"},"Response":[{"Op":"remove","Name":"Content-Type"}]}
+	]}`), 0o644), qt.IsNil)
+	hr, err := addons.NewHeaderRewriteFromFile(filename)
+	c.Assert(err, qt.IsNil)
+
+	f := newJSONLFlow(c, nil, nil)
+	f.Request.Header.Set("Cookie", "a=1")
+	f.Request.Header.Set("X-Env", "prod")
+	hr.Requestheaders(f)
+	hr.Responseheaders(f)
+
+	// every matching item applies, in order
+	c.Assert(f.Request.Header.Values("X-Env"), qt.DeepEquals, []string{"staging", "all"})
+	c.Assert(f.Request.Header.Get("Cookie"), qt.Equals, "")
+	c.Assert(f.Response.Header, qt.DeepEquals, http.Header{
+		"Content-Type":                {"application/json"},
+		"Access-Control-Allow-Origin": {"*"},
+	})
+
+	get := newJSONLFlow(c, nil, nil)
+	get.Request.Method = "GET"
+	hr.Requestheaders(get)
+	hr.Responseheaders(get)
+	c.Assert(get.Request.Header.Values("X-Env"), qt.DeepEquals, []string{"all"})
+	c.Assert(get.Response.Header.Get("Access-Control-Allow-Origin"), qt.Equals, "")
+}
+
+func TestHeaderRewriteDisabled(t *testing.T) {
+	c := qt.New(t)
+	hr := &addons.HeaderRewrite{}
+	f := newJSONLFlow(c, nil, nil)
+	hr.Requestheaders(f)
+	hr.Responseheaders(f)
+	c.Assert(f.Request.Header, qt.DeepEquals, http.Header{"Content-Type": {"application/octet-stream"}})
+
+	// no response yet, e.g. when an earlier addon failed the flow
+	enabled := &addons.HeaderRewrite{Enable: true}
+	noResponse := proxy.NewFlow()
+	noResponse.Request = f.Request
+	enabled.Responseheaders(noResponse)
+}
+
+func TestValidateHeaderRewriteFile(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	for config, want := range map[string]string{
+		`{"Items":[{"Enable":true,"Request":[{"Op":"set","Name":"A"}]}]}`:            "0 no item.From",
+		`{"Items":[{"Enable":true,"From":{}}]}`:                                      "0 no item.Request or item.Response operations",
+		`{"Items":[{"Enable":true,"From":{},"Response":[{"Op":"set"}]}]}`:            "0 operation 0 without Name",
+		`{"Items":[{"Enable":true,"From":{},"Request":[{"Op":"drop","Name":"A"}]}]}`: `0 operation 0 invalid Op "drop"`,
+	} {
+		filename := filepath.Join(dir, "headers.json")
+		c.Assert(os.WriteFile(filename, []byte(config), 0o644), qt.IsNil)
+		c.Assert(addons.ValidateHeaderRewriteFile(filename), qt.ErrorMatches, want, qt.Commentf(config))
+	}
+
+	filename := filepath.Join(dir, "valid.json")
+	c.Assert(os.WriteFile(filename, []byte(`{"Enable":true,"Items":[{"Enable":true,"From":{"Protocol":"https"},"Response":[{"Op":"remove","Name":"Server"}]}]}`), 0o644), qt.IsNil)
+	c.Assert(addons.ValidateHeaderRewriteFile(filename), qt.IsNil)
+}
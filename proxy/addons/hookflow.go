@@ -0,0 +1,115 @@
+package addons
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// hookFlow is a flow as JSON for hooks run out of process, by WasmPlugin and ExecHook.
+// Bodies are decoded.
+type hookFlow struct {
+	Event    string        `json:"event"`
+	ID       string        `json:"id"`
+	Request  *hookRequest  `json:"request"`
+	Response *hookResponse `json:"response,omitempty"`
+}
+
+type hookRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Proto  string      `json:"proto"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body,omitempty"` // base64 in JSON
+}
+
+type hookResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body,omitempty"` // base64 in JSON
+}
+
+// hookPatch is the output of hooks, a hookFlow being one; the fields that are set replace the ones of the
+// flow, a header replaces all the headers.
+type hookPatch struct {
+	Request  *hookMessagePatch `json:"request"`
+	Response *hookMessagePatch `json:"response"`
+}
+
+type hookMessagePatch struct {
+	Method     *string     `json:"method"`
+	URL        *string     `json:"url"`
+	StatusCode *int        `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       *[]byte     `json:"body"`
+}
+
+func newHookFlow(f *proxy.Flow, event string) *hookFlow {
+	reqBody, err := f.Request.DecodedBody()
+	if err != nil {
+		reqBody = f.Request.Body
+	}
+	hf := &hookFlow{
+		Event: event,
+		ID:    f.ID.String(),
+		Request: &hookRequest{
+			Method: f.Request.Method,
+			URL:    f.Request.URL.String(),
+			Proto:  f.Request.Proto,
+			Header: f.Request.Header,
+			Body:   reqBody,
+		},
+	}
+	if f.Response != nil {
+		resBody, err := f.Response.DecodedBody()
+		if err != nil {
+			resBody = f.Response.Body
+		}
+		hf.Response = &hookResponse{
+			StatusCode: f.Response.StatusCode,
+			Header:     f.Response.Header,
+			Body:       resBody,
+		}
+	}
+	return hf
+}
+
+func (patch *hookPatch) apply(f *proxy.Flow) error {
+	if p := patch.Request; p != nil {
+		if p.URL != nil {
+			u, err := url.Parse(*p.URL)
+			if err != nil {
+				return fmt.Errorf("invalid url: %w", err)
+			}
+			f.Request.URL = u
+		}
+		if p.Method != nil {
+			f.Request.Method = *p.Method
+		}
+		if p.Header != nil {
+			f.Request.Header = p.Header
+		}
+		if p.Body != nil {
+			f.Request.Body = *p.Body
+			setDecodedBodyHeaders(&f.Request.Header, len(*p.Body))
+		}
+	}
+	if p := patch.Response; p != nil {
+		if f.Response == nil {
+			f.Response = &proxy.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+		}
+		if p.StatusCode != nil {
+			f.Response.StatusCode = *p.StatusCode
+		}
+		if p.Header != nil {
+			f.Response.Header = p.Header
+		}
+		if p.Body != nil {
+			f.Response.Body = *p.Body
+			setDecodedBodyHeaders(&f.Response.Header, len(*p.Body))
+		}
+	}
+	return nil
+}
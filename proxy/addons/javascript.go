@@ -0,0 +1,510 @@
+package addons
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// jsHooks are the hooks scripts can export, the Addon methods in lower camel case.
+var jsHooks = []string{
+	"clientConnected",
+	"clientDisconnected",
+	"serverConnected",
+	"serverDisconnected",
+	"tlsEstablishedServer",
+	"requestheaders",
+	"request",
+	"responseheaders",
+	"response",
+	"websocketStart",
+	"websocketMessage",
+	"websocketEnd",
+}
+
+// JSScript runs a JavaScript script, like the Python scripts of mitmproxy. The script
+// exports functions named after the Addon methods in lower camel case, e.g.
+// module.exports = {request(flow) {...}, response(flow) {...}}, and JSScript calls
+// them in the matching events.
+//
+// Every flow runs in a JavaScript runtime of its own, created by its first hook and
+// dropped when the flow is finished, so scripts can keep state of a flow in globals
+// without seeing the state of concurrent flows. The connection hooks run in a new
+// runtime each. Exceptions thrown and panics raised by hooks are logged and leave the
+// flow going; the runtime of a flow that panicked is dropped.
+//
+// Scripts see flow.id, flow.request and flow.response. The request has method, url,
+// path and body, which can be set, and scheme and host; the response, null until there
+// is one, has statusCode and body. Bodies are decoded strings; setting one drops the
+// Content-Encoding. Both have headers with the methods get, getAll, set, append, delete,
+// has and keys. Assigning {statusCode, headers, body} to flow.response in requestheaders
+// or request answers the request without contacting the server. websocketMessage gets
+// the message too, with type, fromClient, content, which can be set, and dropped.
+// Connection hooks get {id, address, tls}. console logs.
+type JSScript struct {
+	proxy.BaseAddon
+	filename string
+	program  *goja.Program
+	hooks    map[string]bool // exported hooks
+
+	mu       sync.Mutex
+	runtimes map[*proxy.Flow]*jsRuntime // runtimes of unfinished flows
+}
+
+// jsRuntime is a JavaScript runtime running the script.
+type jsRuntime struct {
+	mu    sync.Mutex // hooks of WebSocket messages of both directions may run concurrently
+	rt    *goja.Runtime
+	hooks map[string]goja.Callable
+	flow  goja.Value // kept so that properties scripts add to it last as long as the flow
+}
+
+func NewJSScriptFromFile(filename string) (*JSScript, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	program, err := goja.Compile(filename, string(src), false)
+	if err != nil {
+		return nil, err
+	}
+	adn := &JSScript{
+		filename: filename,
+		program:  program,
+		runtimes: make(map[*proxy.Flow]*jsRuntime),
+	}
+	// running the script once checks it and finds its hooks
+	js, err := adn.newRuntime()
+	if err != nil {
+		return nil, err
+	}
+	adn.hooks = make(map[string]bool)
+	for name := range js.hooks {
+		adn.hooks[name] = true
+	}
+	return adn, nil
+}
+
+// ValidateJSScriptFile runs the script in filename once and returns its error.
+func ValidateJSScriptFile(filename string) error {
+	_, err := NewJSScriptFromFile(filename)
+	return err
+}
+
+func (adn *JSScript) newRuntime() (js *jsRuntime, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	rt := goja.New()
+	module := rt.NewObject()
+	exports := rt.NewObject()
+	if err := module.Set("exports", exports); err != nil {
+		return nil, err
+	}
+	if err := rt.Set("module", module); err != nil {
+		return nil, err
+	}
+	if err := rt.Set("exports", exports); err != nil {
+		return nil, err
+	}
+	if err := rt.Set("console", adn.newConsole(rt)); err != nil {
+		return nil, err
+	}
+	if _, err := rt.RunProgram(adn.program); err != nil {
+		return nil, err
+	}
+
+	js = &jsRuntime{rt: rt, hooks: make(map[string]goja.Callable)}
+	exported := module.Get("exports")
+	if exported == nil || goja.IsUndefined(exported) || goja.IsNull(exported) {
+		return js, nil
+	}
+	obj := exported.ToObject(rt)
+	for _, name := range obj.Keys() {
+		if !slices.Contains(jsHooks, name) {
+			continue
+		}
+		fn, ok := goja.AssertFunction(obj.Get(name))
+		if !ok {
+			return nil, fmt.Errorf("exported %s is not a function", name)
+		}
+		js.hooks[name] = fn
+	}
+	return js, nil
+}
+
+func (adn *JSScript) newConsole(rt *goja.Runtime) *goja.Object {
+	console := rt.NewObject()
+	logFn := func(level slog.Level) func(goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			args := make([]string, len(call.Arguments))
+			for i, arg := range call.Arguments {
+				args[i] = arg.String()
+			}
+			slog.Log(context.Background(), level, "js script", "file", adn.filename, "msg", strings.Join(args, " "))
+			return goja.Undefined()
+		}
+	}
+	_ = console.Set("log", logFn(slog.LevelInfo))
+	_ = console.Set("info", logFn(slog.LevelInfo))
+	_ = console.Set("debug", logFn(slog.LevelDebug))
+	_ = console.Set("warn", logFn(slog.LevelWarn))
+	_ = console.Set("error", logFn(slog.LevelError))
+	return console
+}
+
+func (adn *JSScript) ClientConnected(client *proxy.ClientConn) {
+	adn.callConn("clientConnected", func(rt *goja.Runtime) goja.Value { return jsClientConn(rt, client) })
+}
+
+func (adn *JSScript) ClientDisconnected(client *proxy.ClientConn) {
+	adn.callConn("clientDisconnected", func(rt *goja.Runtime) goja.Value { return jsClientConn(rt, client) })
+}
+
+func (adn *JSScript) ServerConnected(connCtx *proxy.ConnContext) {
+	adn.callConn("serverConnected", func(rt *goja.Runtime) goja.Value { return jsServerConn(rt, connCtx) })
+}
+
+func (adn *JSScript) ServerDisconnected(connCtx *proxy.ConnContext) {
+	adn.callConn("serverDisconnected", func(rt *goja.Runtime) goja.Value { return jsServerConn(rt, connCtx) })
+}
+
+func (adn *JSScript) TLSEstablishedServer(connCtx *proxy.ConnContext) {
+	adn.callConn("tlsEstablishedServer", func(rt *goja.Runtime) goja.Value { return jsServerConn(rt, connCtx) })
+}
+
+func (adn *JSScript) Requestheaders(f *proxy.Flow) {
+	adn.callFlow("requestheaders", f)
+}
+
+func (adn *JSScript) Request(f *proxy.Flow) {
+	adn.callFlow("request", f)
+}
+
+func (adn *JSScript) Responseheaders(f *proxy.Flow) {
+	adn.callFlow("responseheaders", f)
+}
+
+func (adn *JSScript) Response(f *proxy.Flow) {
+	adn.callFlow("response", f)
+}
+
+func (adn *JSScript) WebsocketStart(f *proxy.Flow) {
+	adn.callFlow("websocketStart", f)
+}
+
+func (adn *JSScript) WebsocketMessage(f *proxy.Flow, msg *proxy.WebSocketMessage) {
+	adn.callFlow("websocketMessage", f, func(rt *goja.Runtime) goja.Value { return jsWebSocketMessage(rt, msg) })
+}
+
+func (adn *JSScript) WebsocketEnd(f *proxy.Flow) {
+	adn.callFlow("websocketEnd", f)
+}
+
+// callConn calls a connection hook in a new runtime.
+func (adn *JSScript) callConn(name string, arg func(*goja.Runtime) goja.Value) {
+	if !adn.hooks[name] {
+		return
+	}
+	js, err := adn.newRuntime()
+	if err != nil {
+		slog.Error("js script error", "file", adn.filename, "hook", name, "error", err)
+		return
+	}
+	if err := js.call(name, arg); err != nil {
+		slog.Error("js script error", "file", adn.filename, "hook", name, "error", err)
+	}
+}
+
+// callFlow calls a flow hook in the runtime of f, with the flow and the values of
+// extra as arguments.
+func (adn *JSScript) callFlow(name string, f *proxy.Flow, extra ...func(*goja.Runtime) goja.Value) {
+	if !adn.hooks[name] {
+		return
+	}
+	js, err := adn.flowRuntime(f)
+	if err != nil {
+		slog.Error("js script error", "file", adn.filename, "hook", name, "url", f.Request.URL.String(), "error", err)
+		return
+	}
+	args := append([]func(*goja.Runtime) goja.Value{func(*goja.Runtime) goja.Value { return js.flow }}, extra...)
+	err = js.call(name, args...)
+	var panicErr *jsPanicError
+	if errors.As(err, &panicErr) {
+		adn.dropRuntime(f, js)
+	}
+	if err != nil {
+		slog.Error("js script error", "file", adn.filename, "hook", name, "url", f.Request.URL.String(), "error", err)
+	}
+}
+
+// flowRuntime returns the runtime of f, created if f has none yet.
+func (adn *JSScript) flowRuntime(f *proxy.Flow) (*jsRuntime, error) {
+	adn.mu.Lock()
+	js := adn.runtimes[f]
+	adn.mu.Unlock()
+	if js != nil {
+		return js, nil
+	}
+
+	js, err := adn.newRuntime()
+	if err != nil {
+		return nil, err
+	}
+	js.flow = jsFlow(js.rt, f)
+	adn.mu.Lock()
+	if existing := adn.runtimes[f]; existing != nil {
+		adn.mu.Unlock()
+		return existing, nil
+	}
+	adn.runtimes[f] = js
+	adn.mu.Unlock()
+	go func() {
+		<-f.Done()
+		adn.dropRuntime(f, js)
+	}()
+	return js, nil
+}
+
+func (adn *JSScript) dropRuntime(f *proxy.Flow, js *jsRuntime) {
+	adn.mu.Lock()
+	if adn.runtimes[f] == js {
+		delete(adn.runtimes, f)
+	}
+	adn.mu.Unlock()
+}
+
+// jsPanicError is a panic raised by a hook.
+type jsPanicError struct {
+	value any
+}
+
+func (e *jsPanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+func (js *jsRuntime) call(name string, args ...func(*goja.Runtime) goja.Value) (err error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			err = &jsPanicError{value: r}
+		}
+	}()
+	values := make([]goja.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg(js.rt)
+	}
+	_, err = js.hooks[name](goja.Undefined(), values...)
+	return err
+}
+
+// jsThrow throws a TypeError in the script calling a Go function.
+func jsThrow(rt *goja.Runtime, format string, args ...any) {
+	panic(rt.NewTypeError(fmt.Sprintf(format, args...)))
+}
+
+func jsDefine(rt *goja.Runtime, obj *goja.Object, name string, get func() any, set func(goja.Value)) {
+	getter := rt.ToValue(func(goja.FunctionCall) goja.Value { return rt.ToValue(get()) })
+	var setter goja.Value
+	if set != nil {
+		setter = rt.ToValue(func(call goja.FunctionCall) goja.Value {
+			set(call.Argument(0))
+			return goja.Undefined()
+		})
+	}
+	if err := obj.DefineAccessorProperty(name, getter, setter, goja.FLAG_FALSE, goja.FLAG_TRUE); err != nil {
+		panic(err)
+	}
+}
+
+func jsFlow(rt *goja.Runtime, f *proxy.Flow) goja.Value {
+	obj := rt.NewObject()
+	id := f.ID.String()
+	jsDefine(rt, obj, "id", func() any { return id }, nil)
+	request := jsRequest(rt, f.Request)
+	jsDefine(rt, obj, "request", func() any { return request }, nil)
+	jsDefine(rt, obj, "response", func() any {
+		if f.Response == nil {
+			return nil
+		}
+		return jsResponse(rt, f.Response)
+	}, func(v goja.Value) {
+		if goja.IsUndefined(v) || goja.IsNull(v) {
+			f.Response = nil
+			return
+		}
+		f.Response = jsNewResponse(rt, v)
+	})
+	return obj
+}
+
+// jsNewResponse creates a response from a {statusCode, headers, body} object.
+func jsNewResponse(rt *goja.Runtime, v goja.Value) *proxy.Response {
+	obj := v.ToObject(rt)
+	res := &proxy.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+	}
+	if statusCode := obj.Get("statusCode"); statusCode != nil && !goja.IsUndefined(statusCode) {
+		res.StatusCode = int(statusCode.ToInteger())
+	}
+	if headers := obj.Get("headers"); headers != nil && !goja.IsUndefined(headers) && !goja.IsNull(headers) {
+		headersObj := headers.ToObject(rt)
+		for _, name := range headersObj.Keys() {
+			res.Header.Set(name, headersObj.Get(name).String())
+		}
+	}
+	if body := obj.Get("body"); body != nil && !goja.IsUndefined(body) && !goja.IsNull(body) {
+		res.Body = []byte(body.String())
+		setDecodedBodyHeaders(&res.Header, len(res.Body))
+	}
+	return res
+}
+
+func jsRequest(rt *goja.Runtime, req *proxy.Request) *goja.Object {
+	obj := rt.NewObject()
+	jsDefine(rt, obj, "method", func() any { return req.Method }, func(v goja.Value) { req.Method = v.String() })
+	jsDefine(rt, obj, "url", func() any { return req.URL.String() }, func(v goja.Value) {
+		u, err := url.Parse(v.String())
+		if err != nil {
+			jsThrow(rt, "invalid url: %v", err)
+		}
+		req.URL = u
+	})
+	jsDefine(rt, obj, "scheme", func() any { return req.URL.Scheme }, nil)
+	jsDefine(rt, obj, "host", func() any { return req.URL.Host }, nil)
+	jsDefine(rt, obj, "path", func() any { return req.URL.Path }, func(v goja.Value) {
+		u := *req.URL
+		u.Path, u.RawPath = v.String(), ""
+		req.URL = &u
+	})
+	headers := jsHeaders(rt, &req.Header)
+	jsDefine(rt, obj, "headers", func() any { return headers }, nil)
+	jsDefine(rt, obj, "body", func() any {
+		body, err := req.DecodedBody()
+		if err != nil {
+			body = req.Body
+		}
+		return string(body)
+	}, func(v goja.Value) {
+		req.Body = []byte(v.String())
+		setDecodedBodyHeaders(&req.Header, len(req.Body))
+	})
+	return obj
+}
+
+func jsResponse(rt *goja.Runtime, res *proxy.Response) *goja.Object {
+	obj := rt.NewObject()
+	jsDefine(rt, obj, "statusCode", func() any { return res.StatusCode }, func(v goja.Value) { res.StatusCode = int(v.ToInteger()) })
+	headers := jsHeaders(rt, &res.Header)
+	jsDefine(rt, obj, "headers", func() any { return headers }, nil)
+	jsDefine(rt, obj, "body", func() any {
+		body, err := res.DecodedBody()
+		if err != nil {
+			body = res.Body
+		}
+		return string(body)
+	}, func(v goja.Value) {
+		res.Body = []byte(v.String())
+		setDecodedBodyHeaders(&res.Header, len(res.Body))
+	})
+	return obj
+}
+
+// jsHeaders binds the header field of a request or response, so that headers can be
+// added to messages without any.
+func jsHeaders(rt *goja.Runtime, header *http.Header) *goja.Object {
+	if *header == nil {
+		*header = make(http.Header)
+	}
+	obj := rt.NewObject()
+	methods := map[string]func(goja.FunctionCall) goja.Value{
+		"get": func(call goja.FunctionCall) goja.Value {
+			values := header.Values(call.Argument(0).String())
+			if len(values) == 0 {
+				return goja.Null()
+			}
+			return rt.ToValue(values[0])
+		},
+		"getAll": func(call goja.FunctionCall) goja.Value {
+			return rt.ToValue(slices.Clone(header.Values(call.Argument(0).String())))
+		},
+		"set": func(call goja.FunctionCall) goja.Value {
+			header.Set(call.Argument(0).String(), call.Argument(1).String())
+			return goja.Undefined()
+		},
+		"append": func(call goja.FunctionCall) goja.Value {
+			header.Add(call.Argument(0).String(), call.Argument(1).String())
+			return goja.Undefined()
+		},
+		"delete": func(call goja.FunctionCall) goja.Value {
+			header.Del(call.Argument(0).String())
+			return goja.Undefined()
+		},
+		"has": func(call goja.FunctionCall) goja.Value {
+			return rt.ToValue(len(header.Values(call.Argument(0).String())) > 0)
+		},
+		"keys": func(goja.FunctionCall) goja.Value {
+			var names []string
+			for name := range *header {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return rt.ToValue(names)
+		},
+	}
+	for name, fn := range methods {
+		_ = obj.Set(name, fn)
+	}
+	return obj
+}
+
+func jsWebSocketMessage(rt *goja.Runtime, msg *proxy.WebSocketMessage) goja.Value {
+	obj := rt.NewObject()
+	msgType := "binary"
+	if msg.IsText() {
+		msgType = "text"
+	}
+	jsDefine(rt, obj, "type", func() any { return msgType }, nil)
+	jsDefine(rt, obj, "fromClient", func() any { return msg.FromClient }, nil)
+	jsDefine(rt, obj, "content", func() any { return string(msg.Content) }, func(v goja.Value) { msg.Content = []byte(v.String()) })
+	jsDefine(rt, obj, "dropped", func() any { return msg.Dropped }, func(v goja.Value) { msg.Dropped = v.ToBoolean() })
+	return obj
+}
+
+func jsClientConn(rt *goja.Runtime, client *proxy.ClientConn) goja.Value {
+	address := ""
+	if client.Conn != nil {
+		address = client.Conn.RemoteAddr().String()
+	}
+	return rt.ToValue(map[string]any{
+		"id":      client.ID.String(),
+		"address": address,
+		"tls":     client.TLS,
+	})
+}
+
+func jsServerConn(rt *goja.Runtime, connCtx *proxy.ConnContext) goja.Value {
+	if connCtx.ServerConn == nil {
+		return goja.Null()
+	}
+	return rt.ToValue(map[string]any{
+		"id":      connCtx.ServerConn.ID.String(),
+		"address": connCtx.ServerConn.Address,
+		"tls":     connCtx.ServerConn.TLSState != nil,
+	})
+}
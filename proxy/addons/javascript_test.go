@@ -0,0 +1,152 @@
+package addons_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newJSScript(c *qt.C, src string) *addons.JSScript {
+	filename := filepath.Join(c.TempDir(), "script.js")
+	c.Assert(os.WriteFile(filename, []byte(src), 0o600), qt.IsNil)
+	adn, err := addons.NewJSScriptFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	return adn
+}
+
+func TestJSScript(t *testing.T) {
+	c := qt.New(t)
+	adn := newJSScript(c, `
+module.exports = {
+  request(flow) {
+    const req = flow.request;
+    if (req.path === "/blocked") {
+      flow.response = {statusCode: 403, headers: {"Content-Type": "text/plain"}, body: "blocked " + req.method};
+      return;
+    }
+    req.headers.set("X-Script", req.host);
+    req.headers.append("X-Script", "twice");
+    req.headers.delete("Content-Type");
+    req.body = req.body.toUpperCase();
+  },
+  response(flow) {
+    const res = flow.response;
+    const data = JSON.parse(res.body);
+    data.seen = flow.request.headers.getAll("X-Script");
+    res.body = JSON.stringify(data);
+    res.statusCode = 201;
+    res.headers.set("X-Had-Type", String(res.headers.has("Content-Type")));
+  },
+};
+`)
+
+	f := newJSONLFlow(c, []byte("hello"), []byte(`{"ok":true}`))
+	adn.Request(f)
+	c.Assert(f.Request.Header.Values("X-Script"), qt.DeepEquals, []string{"example.com", "twice"})
+	c.Assert(f.Request.Header.Get("Content-Type"), qt.Equals, "")
+	c.Assert(string(f.Request.Body), qt.Equals, "HELLO")
+	c.Assert(f.Request.Header.Get("Content-Length"), qt.Equals, "5")
+
+	adn.Response(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 201)
+	c.Assert(string(f.Response.Body), qt.Equals, `{"ok":true,"seen":["example.com","twice"]}`)
+	c.Assert(f.Response.Header.Get("X-Had-Type"), qt.Equals, "true")
+
+	blocked := newJSONLFlow(c, nil, nil)
+	blocked.Request.URL.Path = "/blocked"
+	blocked.Response = nil
+	adn.Request(blocked)
+	c.Assert(blocked.Response, qt.IsNotNil)
+	c.Assert(blocked.Response.StatusCode, qt.Equals, 403)
+	c.Assert(blocked.Response.Header.Get("Content-Type"), qt.Equals, "text/plain")
+	c.Assert(string(blocked.Response.Body), qt.Equals, "blocked POST")
+}
+
+func TestJSScriptFlowIsolation(t *testing.T) {
+	c := qt.New(t)
+	adn := newJSScript(c, `
+let hooks = 0;
+exports.requestheaders = function (flow) {
+  hooks++;
+  flow.started = flow.request.path;
+};
+exports.response = function (flow) {
+  hooks++;
+  flow.response.headers.set("X-Hooks", String(hooks));
+  flow.response.headers.set("X-Started", flow.started);
+};
+exports.websocketMessage = function (flow, message) {
+  hooks++;
+  if (message.fromClient) {
+    message.content = message.type + ":" + message.content;
+  } else {
+    message.dropped = true;
+  }
+};
+`)
+
+	first := newJSONLFlow(c, nil, nil)
+	first.Request.URL.Path = "/first"
+	second := newJSONLFlow(c, nil, nil)
+	second.Request.URL.Path = "/second"
+	adn.Requestheaders(first)
+	adn.Requestheaders(second)
+	adn.Response(second)
+	adn.Response(first)
+	c.Assert(first.Response.Header.Get("X-Hooks"), qt.Equals, "2")
+	c.Assert(first.Response.Header.Get("X-Started"), qt.Equals, "/first")
+	c.Assert(second.Response.Header.Get("X-Hooks"), qt.Equals, "2")
+	c.Assert(second.Response.Header.Get("X-Started"), qt.Equals, "/second")
+
+	fromClient := &proxy.WebSocketMessage{Type: proxy.WebSocketText, FromClient: true, Content: []byte("hi")}
+	adn.WebsocketMessage(first, fromClient)
+	c.Assert(string(fromClient.Content), qt.Equals, "text:hi")
+	fromServer := &proxy.WebSocketMessage{Type: proxy.WebSocketBinary, Content: []byte{1}}
+	adn.WebsocketMessage(first, fromServer)
+	c.Assert(fromServer.Dropped, qt.IsTrue)
+
+	// a panic is logged and drops the runtime of the flow
+	adn.WebsocketMessage(first, nil)
+	adn.Response(first)
+	c.Assert(first.Response.Header.Get("X-Hooks"), qt.Equals, "1")
+	c.Assert(first.Response.Header.Get("X-Started"), qt.Equals, "undefined")
+}
+
+func TestJSScriptErrors(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+
+	_, err := addons.NewJSScriptFromFile(filepath.Join(dir, "missing.js"))
+	c.Assert(err, qt.IsNotNil)
+
+	filename := filepath.Join(dir, "script.js")
+	write := func(src string) {
+		c.Assert(os.WriteFile(filename, []byte(src), 0o600), qt.IsNil)
+	}
+	write("exports.request = 1;")
+	c.Assert(addons.ValidateJSScriptFile(filename), qt.ErrorMatches, "exported request is not a function")
+	write("exports.request = function (flow) {")
+	c.Assert(addons.ValidateJSScriptFile(filename), qt.ErrorMatches, `(?s).*Unexpected end of input.*`)
+	write(`throw new Error("broken");`)
+	c.Assert(addons.ValidateJSScriptFile(filename), qt.ErrorMatches, `(?s).*broken.*`)
+
+	// exceptions are logged, the changes made before them are kept
+	write(`
+exports.request = function (flow) {
+  flow.request.method = "PUT";
+  flow.request.url = "http://[invalid";
+  flow.request.method = "PATCH";
+};
+`)
+	adn, err := addons.NewJSScriptFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	f := newJSONLFlow(c, nil, nil)
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "PUT")
+	c.Assert(f.Request.URL.String(), qt.Equals, "https://example.com/upload")
+}
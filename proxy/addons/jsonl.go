@@ -0,0 +1,206 @@
+package addons
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// JSONLOptions control what JSONLDumper records.
+type JSONLOptions struct {
+	// IncludeBodies records request and response bodies. Response bodies are recorded
+	// decoded when their Content-Encoding is supported.
+	IncludeBodies bool
+	// Base64Binary records bodies that are not valid UTF-8 base64 encoded, marked with
+	// "bodyEncoding": "base64". Without it only their size is recorded.
+	Base64Binary bool
+}
+
+// JSONLDumper writes one JSON object per finished flow, for ingestion by log pipelines.
+type JSONLDumper struct {
+	proxy.BaseAddon
+	mu      sync.Mutex
+	out     io.Writer
+	closer  io.Closer // the file opened by NewJSONLDumperFromFile
+	options JSONLOptions
+
+	responseTimes sync.Map // *proxy.Flow -> time.Time
+}
+
+func NewJSONLDumper(out io.Writer, options JSONLOptions) *JSONLDumper {
+	return &JSONLDumper{out: out, options: options}
+}
+
+// NewJSONLDumperFromFile creates a JSONLDumper appending to the file at filename.
+func NewJSONLDumperFromFile(filename string, options JSONLOptions) (*JSONLDumper, error) {
+	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	adn := NewJSONLDumper(out, options)
+	adn.closer = out
+	return adn, nil
+}
+
+// Running implements proxy.LifecycleAddon.
+func (adn *JSONLDumper) Running(*proxy.Proxy) {}
+
+// Done closes the file of a JSONLDumper created with NewJSONLDumperFromFile. Flows
+// finishing later are not written.
+func (adn *JSONLDumper) Done() {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	if adn.closer == nil {
+		return
+	}
+	if err := adn.closer.Close(); err != nil {
+		slog.Error("failed to close jsonl dump file", "error", err)
+	}
+	adn.closer = nil
+	adn.out = io.Discard
+}
+
+// jsonlFlow is the record written for a flow.
+type jsonlFlow struct {
+	ID           string          `json:"id"`
+	ClientConnID string          `json:"clientConnId,omitempty"`
+	ServerConnID string          `json:"serverConnId,omitempty"`
+	ClientAddr   string          `json:"clientAddr,omitempty"`
+	ServerAddr   string          `json:"serverAddr,omitempty"`
+	TraceID      string          `json:"traceId,omitempty"`
+	Request      jsonlRequest    `json:"request"`
+	Response     *jsonlResponse  `json:"response,omitempty"`
+	Timings      jsonlTimings    `json:"timings"`
+	Metadata     *proxy.Metadata `json:"metadata,omitempty"`
+}
+
+type jsonlRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Proto  string      `json:"proto"`
+	Header http.Header `json:"header"`
+	jsonlBody
+}
+
+type jsonlResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	jsonlBody
+}
+
+// jsonlBody records a body; BodySize is its size as transferred.
+type jsonlBody struct {
+	BodySize     int    `json:"bodySize"`
+	Body         string `json:"body,omitempty"`
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+}
+
+type jsonlTimings struct {
+	Start           time.Time  `json:"start"`
+	ResponseHeaders *time.Time `json:"responseHeaders,omitempty"`
+	End             time.Time  `json:"end"`
+	DurationMs      float64    `json:"durationMs"`
+}
+
+func (adn *JSONLDumper) Requestheaders(f *proxy.Flow) {
+	start := time.Now()
+	go func() {
+		<-f.Done()
+		end := time.Now()
+		record := adn.record(f, start, end)
+		data, err := json.Marshal(record)
+		if err != nil {
+			slog.Error("failed to encode flow", "error", err)
+			return
+		}
+		data = append(data, '\n')
+
+		adn.mu.Lock()
+		defer adn.mu.Unlock()
+		if _, err := adn.out.Write(data); err != nil {
+			slog.Error("failed to write jsonl dump output", "error", err)
+		}
+	}()
+}
+
+func (adn *JSONLDumper) Responseheaders(f *proxy.Flow) {
+	adn.responseTimes.Store(f, time.Now())
+}
+
+func (adn *JSONLDumper) record(f *proxy.Flow, start, end time.Time) *jsonlFlow {
+	record := &jsonlFlow{
+		ID:      f.ID.String(),
+		TraceID: f.TraceID,
+		Request: jsonlRequest{
+			Method:    f.Request.Method,
+			URL:       f.Request.URL.String(),
+			Proto:     f.Request.Proto,
+			Header:    f.Request.Header,
+			jsonlBody: adn.body(f.Request.Body),
+		},
+		Timings: jsonlTimings{
+			Start:      start,
+			End:        end,
+			DurationMs: float64(end.Sub(start).Microseconds()) / 1000,
+		},
+	}
+	if f.Metadata.Len() > 0 {
+		record.Metadata = &f.Metadata
+	}
+	if responseTime, ok := adn.responseTimes.LoadAndDelete(f); ok {
+		t := responseTime.(time.Time)
+		record.Timings.ResponseHeaders = &t
+	}
+
+	if connCtx := f.ConnContext; connCtx != nil {
+		if client := connCtx.ClientConn; client != nil {
+			record.ClientConnID = client.ID.String()
+			if client.Conn != nil {
+				record.ClientAddr = client.Conn.RemoteAddr().String()
+			}
+		}
+		if server := connCtx.ServerConn; server != nil {
+			record.ServerConnID = server.ID.String()
+			record.ServerAddr = server.Address
+		}
+	}
+
+	if f.Response != nil {
+		body := f.Response.Body
+		if adn.options.IncludeBodies && len(body) > 0 {
+			if decoded, err := f.Response.DecodedBody(); err == nil {
+				body = decoded
+			}
+		}
+		record.Response = &jsonlResponse{
+			StatusCode: f.Response.StatusCode,
+			Header:     f.Response.Header,
+			jsonlBody:  adn.body(body),
+		}
+		record.Response.BodySize = len(f.Response.Body)
+	}
+	return record
+}
+
+func (adn *JSONLDumper) body(body []byte) jsonlBody {
+	b := jsonlBody{BodySize: len(body)}
+	if !adn.options.IncludeBodies || len(body) == 0 {
+		return b
+	}
+	switch {
+	case utf8.Valid(body):
+		b.Body = string(body)
+	case adn.options.Base64Binary:
+		b.Body = base64.StdEncoding.EncodeToString(body)
+		b.BodyEncoding = "base64"
+	}
+	return b
+}
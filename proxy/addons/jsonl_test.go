@@ -0,0 +1,193 @@
+package addons_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func dumpJSONL(c *qt.C, options addons.JSONLOptions, f *proxy.Flow) map[string]any {
+	out := &syncBuffer{written: make(chan struct{})}
+	dumper := addons.NewJSONLDumper(out, options)
+	dumper.Requestheaders(f)
+	if f.Response != nil {
+		dumper.Responseheaders(f)
+	}
+	f.Finish()
+
+	select {
+	case <-out.written:
+	case <-time.After(time.Second):
+		c.Fatal("flow was not written")
+	}
+	c.Assert(bytes.Count(out.Bytes(), []byte("\n")), qt.Equals, 1)
+	var record map[string]any
+	c.Assert(json.Unmarshal(out.Bytes(), &record), qt.IsNil)
+	return record
+}
+
+func newJSONLFlow(c *qt.C, requestBody, responseBody []byte) *proxy.Flow {
+	u, err := url.Parse("https://example.com/upload")
+	c.Assert(err, qt.IsNil)
+	f := proxy.NewFlow()
+	f.Request = &proxy.Request{
+		Method: "POST",
+		URL:    u,
+		Proto:  "HTTP/1.1",
+		Header: http.Header{"Content-Type": {"application/octet-stream"}},
+		Body:   requestBody,
+	}
+	f.Response = &proxy.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       responseBody,
+	}
+	return f
+}
+
+func TestJSONLDumperWithoutBodies(t *testing.T) {
+	c := qt.New(t)
+	f := newJSONLFlow(c, []byte("hello"), []byte(`{"ok":true}`))
+	f.TraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	record := dumpJSONL(c, addons.JSONLOptions{}, f)
+	c.Assert(record["id"], qt.Equals, f.ID.String())
+	c.Assert(record["traceId"], qt.Equals, f.TraceID)
+
+	req := record["request"].(map[string]any)
+	c.Assert(req["method"], qt.Equals, "POST")
+	c.Assert(req["url"], qt.Equals, "https://example.com/upload")
+	c.Assert(req["header"], qt.DeepEquals, map[string]any{"Content-Type": []any{"application/octet-stream"}})
+	c.Assert(req["bodySize"], qt.Equals, float64(5))
+	c.Assert(req["body"], qt.IsNil)
+
+	res := record["response"].(map[string]any)
+	c.Assert(res["statusCode"], qt.Equals, float64(200))
+	c.Assert(res["bodySize"], qt.Equals, float64(11))
+	c.Assert(res["body"], qt.IsNil)
+
+	timings := record["timings"].(map[string]any)
+	c.Assert(timings["start"], qt.Not(qt.IsNil))
+	c.Assert(timings["responseHeaders"], qt.Not(qt.IsNil))
+	c.Assert(timings["end"], qt.Not(qt.IsNil))
+}
+
+func TestJSONLDumperBodies(t *testing.T) {
+	binary := []byte{0xff, 0x00, 0xfe}
+
+	testCases := []struct {
+		name         string
+		options      addons.JSONLOptions
+		wantBody     any
+		wantEncoding any
+	}{
+		{
+			name:    "binary bodies omitted",
+			options: addons.JSONLOptions{IncludeBodies: true},
+		},
+		{
+			name:         "binary bodies base64 encoded",
+			options:      addons.JSONLOptions{IncludeBodies: true, Base64Binary: true},
+			wantBody:     "/wD+",
+			wantEncoding: "base64",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			record := dumpJSONL(c, tc.options, newJSONLFlow(c, binary, []byte(`{"ok":true}`)))
+
+			req := record["request"].(map[string]any)
+			c.Assert(req["bodySize"], qt.Equals, float64(3))
+			c.Assert(req["body"], qt.Equals, tc.wantBody)
+			c.Assert(req["bodyEncoding"], qt.Equals, tc.wantEncoding)
+
+			res := record["response"].(map[string]any)
+			c.Assert(res["body"], qt.Equals, `{"ok":true}`)
+			c.Assert(res["bodyEncoding"], qt.IsNil)
+		})
+	}
+}
+
+func TestJSONLDumperDecodesResponseBody(t *testing.T) {
+	c := qt.New(t)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write([]byte("plain text"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(gz.Close(), qt.IsNil)
+
+	f := newJSONLFlow(c, nil, compressed.Bytes())
+	f.Response.Header.Set("Content-Encoding", "gzip")
+
+	record := dumpJSONL(c, addons.JSONLOptions{IncludeBodies: true}, f)
+	res := record["response"].(map[string]any)
+	c.Assert(res["body"], qt.Equals, "plain text")
+	c.Assert(res["bodySize"], qt.Equals, float64(compressed.Len()))
+}
+
+func TestJSONLDumperWithoutResponse(t *testing.T) {
+	c := qt.New(t)
+	f := newJSONLFlow(c, nil, nil)
+	f.Response = nil
+
+	record := dumpJSONL(c, addons.JSONLOptions{}, f)
+	c.Assert(record["response"], qt.IsNil)
+	c.Assert(record["timings"].(map[string]any)["responseHeaders"], qt.IsNil)
+	c.Assert(record["metadata"], qt.IsNil)
+}
+
+func TestJSONLDumperMetadata(t *testing.T) {
+	c := qt.New(t)
+	f := newJSONLFlow(c, nil, nil)
+	f.Metadata.Set("auth.user", "alice")
+	f.Metadata.Set("auth.callback", func() {})
+
+	record := dumpJSONL(c, addons.JSONLOptions{}, f)
+	// values that cannot be marshaled are left out
+	c.Assert(record["metadata"], qt.DeepEquals, map[string]any{"auth.user": "alice"})
+}
+
+func TestJSONLDumperDoneClosesFile(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "flows.jsonl")
+	dumper, err := addons.NewJSONLDumperFromFile(filename, addons.JSONLOptions{})
+	c.Assert(err, qt.IsNil)
+
+	f := newJSONLFlow(c, nil, nil)
+	dumper.Requestheaders(f)
+	f.Finish()
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, err := os.ReadFile(filename)
+		c.Assert(err, qt.IsNil)
+		if len(data) > 0 || time.Now().After(deadline) {
+			c.Assert(bytes.Count(data, []byte("\n")), qt.Equals, 1)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dumper.Done()
+	dumper.Done()
+	// flows finishing after Done are dropped
+	f = newJSONLFlow(c, nil, nil)
+	dumper.Requestheaders(f)
+	f.Finish()
+	time.Sleep(50 * time.Millisecond)
+	data, err := os.ReadFile(filename)
+	c.Assert(err, qt.IsNil)
+	c.Assert(bytes.Count(data, []byte("\n")), qt.Equals, 1)
+}
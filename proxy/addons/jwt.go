@@ -0,0 +1,210 @@
+package addons
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// JWTMode selects how JWTRewriter signs rewritten tokens.
+type JWTMode int
+
+const (
+	// JWTResign signs the rewritten token with JWTRewriter.Alg and JWTRewriter.Key.
+	JWTResign JWTMode = iota
+	// JWTAlgNone sets the "none" algorithm and drops the signature.
+	JWTAlgNone
+	// JWTInvalidSignature keeps the original algorithm and sends a corrupted signature.
+	JWTInvalidSignature
+)
+
+// JWTRewriter rewrites the bearer JWT of the Authorization request header to test
+// how backends validate tokens. Claims are merged into the token payload, a nil
+// value removes the claim. The token is then signed according to Mode.
+type JWTRewriter struct {
+	proxy.BaseAddon
+	Mode   JWTMode
+	Alg    string         // HS256/384/512, RS256/384/512 or ES256/384/512, used by JWTResign
+	Key    any            // []byte for HS*, *rsa.PrivateKey for RS*, *ecdsa.PrivateKey for ES*
+	Claims map[string]any // claims to set on the token
+}
+
+func NewJWTRewriter(mode JWTMode, alg string, key any, claims map[string]any) *JWTRewriter {
+	return &JWTRewriter{
+		Mode:   mode,
+		Alg:    alg,
+		Key:    key,
+		Claims: claims,
+	}
+}
+
+func (adn *JWTRewriter) Requestheaders(f *proxy.Flow) {
+	auth := f.Request.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return
+	}
+
+	rewritten, err := adn.Rewrite(strings.TrimSpace(token))
+	if err != nil {
+		slog.Warn("jwt rewrite failed", "url", f.Request.URL.String(), "error", err)
+		return
+	}
+	f.Request.Header.Set("Authorization", "Bearer "+rewritten)
+}
+
+// Rewrite applies the claim mutations and the signing mode to token.
+func (adn *JWTRewriter) Rewrite(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("jwt: token must have three parts")
+	}
+
+	var header map[string]any
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", fmt.Errorf("jwt: invalid header: %w", err)
+	}
+	if header == nil {
+		return "", errors.New("jwt: header is not an object")
+	}
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("jwt: invalid claims: %w", err)
+	}
+	if claims == nil {
+		return "", errors.New("jwt: claims are not an object")
+	}
+	for name, value := range adn.Claims {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+
+	switch adn.Mode {
+	case JWTResign:
+		header["alg"] = adn.Alg
+	case JWTAlgNone:
+		header["alg"] = "none"
+	case JWTInvalidSignature:
+	default:
+		return "", fmt.Errorf("jwt: unknown mode %d", adn.Mode)
+	}
+
+	encodedHeader, err := encodeJWTPart(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := encodeJWTPart(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encodedHeader + "." + encodedClaims
+
+	var signature []byte
+	switch adn.Mode {
+	case JWTResign:
+		signature, err = signJWT(adn.Alg, adn.Key, []byte(signingInput))
+		if err != nil {
+			return "", err
+		}
+	case JWTAlgNone:
+	case JWTInvalidSignature:
+		signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil || len(signature) == 0 {
+			signature = []byte("invalid-signature")
+		}
+		signature[len(signature)-1] ^= 0xff
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return err
+	}
+	// keep numeric claims such as exp as they are
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func encodeJWTPart(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func jwtHash(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 {
+		return 0, fmt.Errorf("jwt: unsupported alg %q", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("jwt: unsupported alg %q", alg)
+}
+
+func signJWT(alg string, key any, signingInput []byte) ([]byte, error) {
+	hash, err := jwtHash(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("jwt: %s needs a []byte key", alg)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	case "RS":
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt: %s needs an *rsa.PrivateKey", alg)
+		}
+		h := hash.New()
+		h.Write(signingInput)
+		return rsa.SignPKCS1v15(rand.Reader, privateKey, hash, h.Sum(nil))
+	case "ES":
+		privateKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt: %s needs an *ecdsa.PrivateKey", alg)
+		}
+		h := hash.New()
+		h.Write(signingInput)
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, h.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed-size r||s encoding, not ASN.1
+		size := (privateKey.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature, nil
+	}
+	return nil, fmt.Errorf("jwt: unsupported alg %q", alg)
+}
@@ -0,0 +1,188 @@
+package addons_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+var jwtTestSecret = []byte("original-secret")
+
+func makeTestJWT(c *qt.C, claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT","kid":"k1"}`))
+	payload, err := json.Marshal(claims)
+	c.Assert(err, qt.IsNil)
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, jwtTestSecret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// splitTestJWT returns the decoded header and claims, the signing input and the signature.
+func splitTestJWT(c *qt.C, token string) (map[string]any, map[string]any, string, []byte) {
+	parts := strings.Split(token, ".")
+	c.Assert(parts, qt.HasLen, 3)
+	var header, claims map[string]any
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	c.Assert(err, qt.IsNil)
+	c.Assert(json.Unmarshal(data, &header), qt.IsNil)
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	c.Assert(err, qt.IsNil)
+	c.Assert(json.Unmarshal(data, &claims), qt.IsNil)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	c.Assert(err, qt.IsNil)
+	return header, claims, parts[0] + "." + parts[1], signature
+}
+
+func newJWTFlow(authorization string) *proxy.Flow {
+	flow := types.NewFlow()
+	flow.Request = &proxy.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "https", Host: "api.example.com", Path: "/me"},
+		Header: http.Header{"Authorization": {authorization}},
+	}
+	return flow
+}
+
+func TestJWTRewriterResignHMAC(t *testing.T) {
+	c := qt.New(t)
+
+	token := makeTestJWT(c, map[string]any{"sub": "alice", "role": "user", "exp": 1700000000, "tmp": true})
+	flow := newJWTFlow("Bearer " + token)
+
+	key := []byte("attacker-secret")
+	addons.NewJWTRewriter(addons.JWTResign, "HS256", key, map[string]any{
+		"role": "admin",
+		"tmp":  nil,
+	}).Requestheaders(flow)
+
+	rewritten := strings.TrimPrefix(flow.Request.Header.Get("Authorization"), "Bearer ")
+	header, claims, signingInput, signature := splitTestJWT(c, rewritten)
+	c.Assert(header["alg"], qt.Equals, "HS256")
+	c.Assert(header["kid"], qt.Equals, "k1")
+	c.Assert(claims["role"], qt.Equals, "admin")
+	c.Assert(claims["sub"], qt.Equals, "alice")
+	c.Assert(claims["exp"], qt.Equals, float64(1700000000))
+	_, ok := claims["tmp"]
+	c.Assert(ok, qt.IsFalse)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	c.Assert(hmac.Equal(signature, mac.Sum(nil)), qt.IsTrue)
+}
+
+func TestJWTRewriterResignRSA(t *testing.T) {
+	c := qt.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, qt.IsNil)
+
+	rewritten, err := addons.NewJWTRewriter(addons.JWTResign, "RS256", key, map[string]any{"role": "admin"}).
+		Rewrite(makeTestJWT(c, map[string]any{"sub": "alice"}))
+	c.Assert(err, qt.IsNil)
+
+	header, claims, signingInput, signature := splitTestJWT(c, rewritten)
+	c.Assert(header["alg"], qt.Equals, "RS256")
+	c.Assert(claims["role"], qt.Equals, "admin")
+	digest := sha256.Sum256([]byte(signingInput))
+	c.Assert(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature), qt.IsNil)
+}
+
+func TestJWTRewriterResignECDSA(t *testing.T) {
+	c := qt.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, qt.IsNil)
+
+	rewritten, err := addons.NewJWTRewriter(addons.JWTResign, "ES256", key, nil).
+		Rewrite(makeTestJWT(c, map[string]any{"sub": "alice"}))
+	c.Assert(err, qt.IsNil)
+
+	_, _, signingInput, signature := splitTestJWT(c, rewritten)
+	c.Assert(signature, qt.HasLen, 64)
+	digest := sha256.Sum256([]byte(signingInput))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	c.Assert(ecdsa.Verify(&key.PublicKey, digest[:], r, s), qt.IsTrue)
+}
+
+func TestJWTRewriterResignWrongKeyType(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := addons.NewJWTRewriter(addons.JWTResign, "RS256", []byte("secret"), nil).
+		Rewrite(makeTestJWT(c, map[string]any{"sub": "alice"}))
+	c.Assert(err, qt.ErrorMatches, `jwt: RS256 needs an \*rsa.PrivateKey`)
+}
+
+func TestJWTRewriterAlgNone(t *testing.T) {
+	c := qt.New(t)
+
+	rewritten, err := addons.NewJWTRewriter(addons.JWTAlgNone, "", nil, map[string]any{"role": "admin"}).
+		Rewrite(makeTestJWT(c, map[string]any{"sub": "alice"}))
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.HasSuffix(rewritten, "."), qt.IsTrue)
+
+	header, claims, _, signature := splitTestJWT(c, rewritten)
+	c.Assert(header["alg"], qt.Equals, "none")
+	c.Assert(claims["role"], qt.Equals, "admin")
+	c.Assert(signature, qt.HasLen, 0)
+}
+
+func TestJWTRewriterInvalidSignature(t *testing.T) {
+	c := qt.New(t)
+
+	token := makeTestJWT(c, map[string]any{"sub": "alice"})
+	rewritten, err := addons.NewJWTRewriter(addons.JWTInvalidSignature, "", nil, nil).Rewrite(token)
+	c.Assert(err, qt.IsNil)
+
+	header, _, signingInput, signature := splitTestJWT(c, rewritten)
+	c.Assert(header["alg"], qt.Equals, "HS256")
+	_, _, _, original := splitTestJWT(c, token)
+	c.Assert(signature, qt.HasLen, len(original))
+	c.Assert(signature, qt.Not(qt.DeepEquals), original)
+
+	mac := hmac.New(sha256.New, jwtTestSecret)
+	mac.Write([]byte(signingInput))
+	c.Assert(hmac.Equal(signature, mac.Sum(nil)), qt.IsFalse)
+}
+
+func TestJWTRewriterNullParts(t *testing.T) {
+	c := qt.New(t)
+
+	rewriter := addons.NewJWTRewriter(addons.JWTAlgNone, "", nil, map[string]any{"role": "admin"})
+	parts := strings.Split(makeTestJWT(c, map[string]any{"sub": "alice"}), ".")
+	null := base64.RawURLEncoding.EncodeToString([]byte("null"))
+
+	_, err := rewriter.Rewrite(null + "." + parts[1] + "." + parts[2])
+	c.Assert(err, qt.ErrorMatches, "jwt: header is not an object")
+	_, err = rewriter.Rewrite(parts[0] + "." + null + "." + parts[2])
+	c.Assert(err, qt.ErrorMatches, "jwt: claims are not an object")
+}
+
+func TestJWTRewriterIgnoresOtherAuthorization(t *testing.T) {
+	c := qt.New(t)
+
+	rewriter := addons.NewJWTRewriter(addons.JWTAlgNone, "", nil, nil)
+	for _, authorization := range []string{"Basic dXNlcjpwYXNz", "Bearer not-a-jwt"} {
+		flow := newJWTFlow(authorization)
+		rewriter.Requestheaders(flow)
+		c.Assert(flow.Request.Header.Get("Authorization"), qt.Equals, authorization)
+	}
+}
@@ -0,0 +1,236 @@
+package addons
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// LoadTestFormat is the script format written by LoadTestExporter.
+type LoadTestFormat string
+
+const (
+	// LoadTestK6 is a k6 script replaying the requests one after another, sleeping
+	// between them as long as the recorded client waited.
+	LoadTestK6 LoadTestFormat = "k6"
+	// LoadTestVegeta is a list of vegeta targets in its JSON format, for
+	// "vegeta attack -format=json". Vegeta sends at a fixed rate, so think times are lost.
+	LoadTestVegeta LoadTestFormat = "vegeta"
+)
+
+// loadTestMinThinkTime is the shortest pause between requests kept in k6 scripts.
+const loadTestMinThinkTime = 10 * time.Millisecond
+
+// loadTestSkipHeaders are request headers the load test tools set themselves.
+var loadTestSkipHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Host",
+	"Keep-Alive",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// LoadTestExporter records the requests of a session so it can be replayed under load
+// by k6 or vegeta. Requests are replayed in the order they were sent.
+type LoadTestExporter struct {
+	proxy.BaseAddon
+	format   LoadTestFormat
+	filename string
+	saveMu   sync.Mutex
+
+	mu       sync.Mutex
+	requests []*loadTestRequest
+}
+
+type loadTestRequest struct {
+	method string
+	url    string
+	header http.Header
+	body   []byte
+	start  time.Time
+	end    time.Time
+}
+
+func NewLoadTestExporter(format LoadTestFormat) (*LoadTestExporter, error) {
+	if format != LoadTestK6 && format != LoadTestVegeta {
+		return nil, fmt.Errorf("unknown load test format %q", format)
+	}
+	return &LoadTestExporter{format: format}, nil
+}
+
+// NewLoadTestExporterFromFile creates a LoadTestExporter rewriting the file at filename
+// after every flow.
+func NewLoadTestExporterFromFile(filename string, format LoadTestFormat) (*LoadTestExporter, error) {
+	adn, err := NewLoadTestExporter(format)
+	if err != nil {
+		return nil, err
+	}
+	adn.filename = filename
+	if err := adn.save(); err != nil {
+		return nil, err
+	}
+	return adn, nil
+}
+
+func (adn *LoadTestExporter) Requestheaders(f *proxy.Flow) {
+	start := time.Now()
+	go func() {
+		<-f.Done()
+		req := &loadTestRequest{
+			method: f.Request.Method,
+			url:    f.Request.URL.String(),
+			header: f.Request.Header.Clone(),
+			body:   f.Request.Body,
+			start:  start,
+			end:    time.Now(),
+		}
+		for _, name := range loadTestSkipHeaders {
+			req.header.Del(name)
+		}
+		if host := f.Request.Header.Get("Host"); host != "" && host != f.Request.URL.Host {
+			req.header.Set("Host", host)
+		}
+
+		adn.mu.Lock()
+		i, _ := slices.BinarySearchFunc(adn.requests, start, func(r *loadTestRequest, t time.Time) int {
+			return r.start.Compare(t)
+		})
+		adn.requests = slices.Insert(adn.requests, i, req)
+		adn.mu.Unlock()
+
+		if adn.filename == "" {
+			return
+		}
+		if err := adn.save(); err != nil {
+			slog.Error("failed to write load test script", "error", err)
+		}
+	}()
+}
+
+// WriteTo writes the script for the requests recorded so far.
+func (adn *LoadTestExporter) WriteTo(w io.Writer) (int64, error) {
+	adn.mu.Lock()
+	requests := slices.Clone(adn.requests)
+	adn.mu.Unlock()
+
+	var buf bytes.Buffer
+	var err error
+	if adn.format == LoadTestVegeta {
+		err = writeVegetaTargets(&buf, requests)
+	} else {
+		err = writeK6Script(&buf, requests)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(w)
+}
+
+func (adn *LoadTestExporter) save() error {
+	adn.saveMu.Lock()
+	defer adn.saveMu.Unlock()
+	var buf bytes.Buffer
+	if _, err := adn.WriteTo(&buf); err != nil {
+		return err
+	}
+	return helper.ReplaceFile(adn.filename, buf.Bytes())
+}
+
+func writeK6Script(buf *bytes.Buffer, requests []*loadTestRequest) error {
+	binary := slices.ContainsFunc(requests, func(r *loadTestRequest) bool {
+		return len(r.body) > 0 && !utf8.Valid(r.body)
+	})
+
+	buf.WriteString("import http from 'k6/http';\n")
+	buf.WriteString("import { sleep } from 'k6';\n")
+	if binary {
+		buf.WriteString("import encoding from 'k6/encoding';\n")
+	}
+	buf.WriteString("\nexport default function () {\n")
+	for i, r := range requests {
+		if i > 0 {
+			if think := r.start.Sub(requests[i-1].end); think >= loadTestMinThinkTime {
+				fmt.Fprintf(buf, "  sleep(%s);\n", strconv.FormatFloat(think.Round(time.Millisecond).Seconds(), 'f', -1, 64))
+			}
+		}
+
+		method, err := json.Marshal(r.method)
+		if err != nil {
+			return err
+		}
+		url, err := json.Marshal(r.url)
+		if err != nil {
+			return err
+		}
+		body := "null"
+		switch {
+		case len(r.body) == 0:
+		case utf8.Valid(r.body):
+			quoted, err := json.Marshal(string(r.body))
+			if err != nil {
+				return err
+			}
+			body = string(quoted)
+		default:
+			body = "encoding.b64decode('" + base64.StdEncoding.EncodeToString(r.body) + "')"
+		}
+		headers, err := json.Marshal(k6Headers(r.header))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "  http.request(%s, %s, %s, { headers: %s });\n", method, url, body, headers)
+	}
+	buf.WriteString("}\n")
+	return nil
+}
+
+// k6Headers joins the values of each header, as k6 takes one value per name.
+func k6Headers(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		sep := ", "
+		if name == "Cookie" {
+			sep = "; "
+		}
+		headers[name] = strings.Join(values, sep)
+	}
+	return headers
+}
+
+// vegetaTarget is a target in the JSON format of vegeta.
+type vegetaTarget struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Body   []byte      `json:"body,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+}
+
+func writeVegetaTargets(buf *bytes.Buffer, requests []*loadTestRequest) error {
+	enc := json.NewEncoder(buf)
+	for _, r := range requests {
+		target := vegetaTarget{Method: r.method, URL: r.url, Body: r.body}
+		if len(r.header) > 0 {
+			target.Header = r.header
+		}
+		if err := enc.Encode(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
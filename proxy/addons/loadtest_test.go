@@ -0,0 +1,104 @@
+package addons_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+// recordLoadTest passes the flows to adn one after another, pausing between them, and
+// returns the script once all of them are recorded.
+func recordLoadTest(c *qt.C, adn *addons.LoadTestExporter, pause time.Duration, flows ...*proxy.Flow) string {
+	for i, f := range flows {
+		if i > 0 {
+			time.Sleep(pause)
+		}
+		adn.Requestheaders(f)
+		f.Finish()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		var buf bytes.Buffer
+		_, err := adn.WriteTo(&buf)
+		c.Assert(err, qt.IsNil)
+		if strings.Count(buf.String(), "example.com") >= len(flows) || time.Now().After(deadline) {
+			return buf.String()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoadTestExporterK6(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewLoadTestExporter(addons.LoadTestK6)
+	c.Assert(err, qt.IsNil)
+
+	upload := newJSONLFlow(c, []byte(`{"name":"it's"}`), nil)
+	upload.Request.Header.Set("Content-Length", "15")
+	binary := newJSONLFlow(c, []byte{0xff, 0x00}, nil)
+	binary.Request.Header.Add("Cookie", "a=1")
+	binary.Request.Header.Add("Cookie", "b=2")
+
+	script := recordLoadTest(c, adn, 50*time.Millisecond, upload, binary)
+	c.Assert(script, qt.Contains, "import encoding from 'k6/encoding';")
+	c.Assert(script, qt.Contains,
+		`  http.request("POST", "https://example.com/upload", "{\"name\":\"it's\"}", { headers: {"Content-Type":"application/octet-stream"} });`)
+	c.Assert(script, qt.Contains,
+		`  http.request("POST", "https://example.com/upload", encoding.b64decode('/wA='), { headers: {"Content-Type":"application/octet-stream","Cookie":"a=1; b=2"} });`)
+
+	// the pause between the flows is kept
+	c.Assert(script, qt.Contains, "sleep(0.")
+	c.Assert(strings.Index(script, "sleep(") > strings.Index(script, "it's"), qt.IsTrue)
+}
+
+func TestLoadTestExporterK6SkipsShortPauses(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewLoadTestExporter(addons.LoadTestK6)
+	c.Assert(err, qt.IsNil)
+
+	script := recordLoadTest(c, adn, 0, newJSONLFlow(c, nil, nil), newJSONLFlow(c, nil, nil))
+	c.Assert(script, qt.Not(qt.Contains), "sleep(0")
+	c.Assert(script, qt.Not(qt.Contains), "k6/encoding")
+	c.Assert(strings.Count(script, `http.request("POST", "https://example.com/upload", null,`), qt.Equals, 2)
+}
+
+func TestLoadTestExporterVegeta(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "targets.json")
+	adn, err := addons.NewLoadTestExporterFromFile(filename, addons.LoadTestVegeta)
+	c.Assert(err, qt.IsNil)
+
+	recordLoadTest(c, adn, 0, newJSONLFlow(c, []byte("hello"), nil))
+
+	var data []byte
+	deadline := time.Now().Add(time.Second)
+	for len(data) == 0 && time.Now().Before(deadline) {
+		data, err = os.ReadFile(filename)
+		c.Assert(err, qt.IsNil)
+		time.Sleep(time.Millisecond)
+	}
+	var target map[string]any
+	c.Assert(json.Unmarshal(data, &target), qt.IsNil)
+	c.Assert(target, qt.DeepEquals, map[string]any{
+		"method": "POST",
+		"url":    "https://example.com/upload",
+		"body":   "aGVsbG8=",
+		"header": map[string]any{"Content-Type": []any{"application/octet-stream"}},
+	})
+}
+
+func TestLoadTestExporterUnknownFormat(t *testing.T) {
+	c := qt.New(t)
+	_, err := addons.NewLoadTestExporter("jmeter")
+	c.Assert(err, qt.ErrorMatches, `unknown load test format "jmeter"`)
+}
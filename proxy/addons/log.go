@@ -2,7 +2,6 @@ package addons
 
 import (
 	"log/slog"
-	"time"
 
 	"github.com/denisvmedia/go-mitmproxy/proxy"
 )
@@ -45,7 +44,6 @@ func (*LogAddon) Requestheaders(f *proxy.Flow) {
 		"method", f.Request.Method,
 		"url", f.Request.URL.String(),
 	)
-	start := time.Now()
 	go func() {
 		<-f.Done()
 		var statusCode int
@@ -56,13 +54,24 @@ func (*LogAddon) Requestheaders(f *proxy.Flow) {
 		if f.Response != nil && f.Response.Body != nil {
 			contentLen = len(f.Response.Body)
 		}
-		slog.Info("request completed",
+		timings := f.Timings()
+		attrs := []any{
 			"clientAddr", f.ConnContext.ClientConn.Conn.RemoteAddr().String(),
 			"method", f.Request.Method,
 			"url", f.Request.URL.String(),
 			"status", statusCode,
 			"contentLength", contentLen,
-			"durationMs", time.Since(start).Milliseconds(),
-		)
+			"durationMs", timings.Duration().Milliseconds(),
+		}
+		if d := timings.ConnectDuration(); d > 0 {
+			attrs = append(attrs, "connectMs", d.Milliseconds())
+		}
+		if d := timings.TLSDuration(); d > 0 {
+			attrs = append(attrs, "tlsMs", d.Milliseconds())
+		}
+		if d := timings.TimeToFirstByte(); d > 0 {
+			attrs = append(attrs, "ttfbMs", d.Milliseconds())
+		}
+		slog.Info("request completed", attrs...)
 	}()
 }
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/denisvmedia/go-mitmproxy/internal/helper"
 	"github.com/denisvmedia/go-mitmproxy/proxy"
@@ -94,13 +95,19 @@ type MapLocal struct {
 	proxy.BaseAddon
 	Items  []*mapLocalItem
 	Enable bool
+
+	mu       sync.RWMutex // guards Items and Enable during reloads
+	filename string
 }
 
 func (ml *MapLocal) Requestheaders(f *proxy.Flow) {
-	if !ml.Enable {
+	ml.mu.RLock()
+	enable, items := ml.Enable, ml.Items
+	ml.mu.RUnlock()
+	if !enable {
 		return
 	}
-	for _, item := range ml.Items {
+	for _, item := range items {
 		if item.match(f.Request) {
 			aurl := f.Request.URL.String()
 			localfile, resp := item.response(f.Request)
@@ -137,5 +144,30 @@ func NewMapLocalFromFile(filename string) (*MapLocal, error) {
 	if err := mapLocal.validate(); err != nil {
 		return nil, err
 	}
+	mapLocal.filename = filename
 	return &mapLocal, nil
 }
+
+// ConfigFile returns the file the MapLocal was loaded from.
+func (ml *MapLocal) ConfigFile() string {
+	return ml.filename
+}
+
+// Reload loads the rules of the file again, keeping the current ones if it is invalid.
+func (ml *MapLocal) Reload() error {
+	loaded, err := NewMapLocalFromFile(ml.filename)
+	if err != nil {
+		return err
+	}
+	ml.mu.Lock()
+	ml.Items, ml.Enable = loaded.Items, loaded.Enable
+	ml.mu.Unlock()
+	return nil
+}
+
+// ValidateMapLocalFile returns the error of loading filename as a map local config,
+// such as a rule without a From or a To path.
+func ValidateMapLocalFile(filename string) error {
+	_, err := NewMapLocalFromFile(filename)
+	return err
+}
@@ -0,0 +1,26 @@
+package addons_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func TestValidateMapLocalFile(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	err := os.WriteFile(valid, []byte(`{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"a.com"},"To":{"Path":"/tmp"}}]}`), 0o644)
+	c.Assert(err, qt.IsNil)
+	c.Assert(addons.ValidateMapLocalFile(valid), qt.IsNil)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	err = os.WriteFile(invalid, []byte(`{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"a.com"},"To":{}}]}`), 0o644)
+	c.Assert(err, qt.IsNil)
+	c.Assert(addons.ValidateMapLocalFile(invalid), qt.ErrorMatches, "0 empty item.To.Path")
+}
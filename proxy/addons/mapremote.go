@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/samber/lo"
 	"github.com/tidwall/match"
@@ -84,13 +85,19 @@ type MapRemote struct {
 	proxy.BaseAddon
 	Items  []*mapRemoteItem
 	Enable bool
+
+	mu       sync.RWMutex // guards Items and Enable during reloads
+	filename string
 }
 
 func (mr *MapRemote) Requestheaders(f *proxy.Flow) {
-	if !mr.Enable {
+	mr.mu.RLock()
+	enable, items := mr.Enable, mr.Items
+	mr.mu.RUnlock()
+	if !enable {
 		return
 	}
-	for _, item := range mr.Items {
+	for _, item := range items {
 		if item.match(f.Request) {
 			aurl := f.Request.URL.String()
 			f.Request = item.replace(f.Request)
@@ -131,5 +138,30 @@ func NewMapRemoteFromFile(filename string) (*MapRemote, error) {
 	if err := mapRemote.validate(); err != nil {
 		return nil, err
 	}
+	mapRemote.filename = filename
 	return &mapRemote, nil
 }
+
+// ConfigFile returns the file the MapRemote was loaded from.
+func (mr *MapRemote) ConfigFile() string {
+	return mr.filename
+}
+
+// Reload loads the rules of the file again, keeping the current ones if it is invalid.
+func (mr *MapRemote) Reload() error {
+	loaded, err := NewMapRemoteFromFile(mr.filename)
+	if err != nil {
+		return err
+	}
+	mr.mu.Lock()
+	mr.Items, mr.Enable = loaded.Items, loaded.Enable
+	mr.mu.Unlock()
+	return nil
+}
+
+// ValidateMapRemoteFile returns the error of loading filename as a map remote config,
+// such as a rule without a From or a To.
+func ValidateMapRemoteFile(filename string) error {
+	_, err := NewMapRemoteFromFile(filename)
+	return err
+}
@@ -1,6 +1,8 @@
 package addons_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -20,3 +22,20 @@ func TestMapRemotePublicAPI(t *testing.T) {
 	c.Assert(mr, qt.IsNotNil)
 	c.Assert(mr.Enable, qt.IsTrue)
 }
+
+func TestValidateMapRemoteFile(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	err := os.WriteFile(valid, []byte(`{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"a.com"},"To":{"Host":"b.com"}}]}`), 0o644)
+	c.Assert(err, qt.IsNil)
+	c.Assert(addons.ValidateMapRemoteFile(valid), qt.IsNil)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	err = os.WriteFile(invalid, []byte(`{"Enable":true,"Items":[{"Enable":true,"From":{"Protocol":"ftp"},"To":{"Host":"b.com"}}]}`), 0o644)
+	c.Assert(err, qt.IsNil)
+	c.Assert(addons.ValidateMapRemoteFile(invalid), qt.ErrorMatches, "0 invalid item.From.Protocol ftp")
+
+	c.Assert(addons.ValidateMapRemoteFile(filepath.Join(dir, "missing.json")), qt.IsNotNil)
+}
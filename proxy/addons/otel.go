@@ -0,0 +1,205 @@
+package addons
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+const otelInstrumentationName = "github.com/denisvmedia/go-mitmproxy/proxy/addons"
+
+// OTelTracing records an OpenTelemetry span per flow, from its request headers until
+// it is finished, so proxied traffic appears in distributed traces.
+//
+// A request carrying a W3C traceparent header continues that trace. The traceparent
+// sent upstream is replaced with the flow span, and Flow.TraceID is set to the trace ID.
+// Dialing the upstream server and its TLS handshake are recorded as child spans of the
+// flow that needed them; connections dialed before their first flow, e.g. for
+// CONNECT requests, are recorded under the first flow, timed from the client connecting.
+type OTelTracing struct {
+	proxy.BaseAddon
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	flows sync.Map // *proxy.Flow -> *otelFlow
+	conns sync.Map // client connection ID -> *otelConn
+}
+
+type otelFlow struct {
+	ctx      context.Context
+	span     trace.Span
+	dialFrom time.Time // when the flow may start dialing upstream
+}
+
+// otelConn tracks the upstream connection of a client connection.
+type otelConn struct {
+	mu          sync.Mutex
+	connectedAt time.Time
+
+	// dialing is the flow waiting for the upstream connection.
+	dialing  *otelFlow
+	tlsFlow  *otelFlow
+	tlsStart time.Time
+
+	// timings of an upstream connection dialed outside a flow
+	dialStart, dialEnd, tlsEnd time.Time
+}
+
+// NewOTelTracing creates an OTelTracing using tracerProvider, or the global one when nil.
+func NewOTelTracing(tracerProvider trace.TracerProvider) *OTelTracing {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	return &OTelTracing{
+		tracer:     tracerProvider.Tracer(otelInstrumentationName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+func (adn *OTelTracing) ClientConnected(client *proxy.ClientConn) {
+	adn.conns.Store(client.ID, &otelConn{connectedAt: time.Now()})
+}
+
+func (adn *OTelTracing) ClientDisconnected(client *proxy.ClientConn) {
+	adn.conns.Delete(client.ID)
+}
+
+func (adn *OTelTracing) Requestheaders(f *proxy.Flow) {
+	now := time.Now()
+	header := propagation.HeaderCarrier(f.Request.Header)
+	parent := adn.propagator.Extract(context.Background(), header)
+	ctx, span := adn.tracer.Start(parent, f.Request.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(now),
+		trace.WithAttributes(
+			attribute.String("http.request.method", f.Request.Method),
+			attribute.String("url.full", f.Request.URL.String()),
+			attribute.String("server.address", f.Request.URL.Hostname()),
+			attribute.String("network.protocol.version", strings.TrimPrefix(f.Request.Proto, "HTTP/")),
+		),
+	)
+	adn.propagator.Inject(ctx, header)
+	f.TraceID = span.SpanContext().TraceID().String()
+
+	of := &otelFlow{ctx: ctx, span: span, dialFrom: now}
+	adn.flows.Store(f, of)
+
+	if oc := adn.conn(f.ConnContext); oc != nil {
+		if client := f.ConnContext.ClientConn; client.Conn != nil {
+			span.SetAttributes(attribute.String("client.address", client.Conn.RemoteAddr().String()))
+		}
+		oc.mu.Lock()
+		if !oc.dialEnd.IsZero() {
+			adn.childSpan(ctx, "upstream dial", oc.dialStart, oc.dialEnd)
+			if !oc.tlsEnd.IsZero() {
+				adn.childSpan(ctx, "upstream tls handshake", oc.dialEnd, oc.tlsEnd)
+			}
+			oc.dialStart, oc.dialEnd, oc.tlsEnd = time.Time{}, time.Time{}, time.Time{}
+		}
+		if f.ConnContext.ServerConn == nil {
+			oc.dialing = of
+		}
+		oc.mu.Unlock()
+	}
+
+	go func() {
+		<-f.Done()
+		adn.flows.Delete(f)
+		if oc := adn.conn(f.ConnContext); oc != nil {
+			oc.mu.Lock()
+			if oc.dialing == of {
+				oc.dialing = nil
+			}
+			if oc.tlsFlow == of {
+				oc.tlsFlow = nil
+			}
+			oc.mu.Unlock()
+		}
+
+		if f.Response == nil {
+			span.SetStatus(codes.Error, "no response")
+		} else {
+			span.SetAttributes(attribute.Int("http.response.status_code", f.Response.StatusCode))
+			if f.Response.StatusCode >= 500 {
+				span.SetStatus(codes.Error, "")
+			}
+		}
+		span.End()
+	}()
+}
+
+// Request moves the start of a pending dial to after the request body has been read.
+func (adn *OTelTracing) Request(f *proxy.Flow) {
+	v, ok := adn.flows.Load(f)
+	if !ok {
+		return
+	}
+	if oc := adn.conn(f.ConnContext); oc != nil {
+		oc.mu.Lock()
+		v.(*otelFlow).dialFrom = time.Now()
+		oc.mu.Unlock()
+	}
+}
+
+func (adn *OTelTracing) ServerConnected(connCtx *proxy.ConnContext) {
+	oc := adn.conn(connCtx)
+	if oc == nil {
+		return
+	}
+	now := time.Now()
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if of := oc.dialing; of != nil {
+		oc.dialing = nil
+		adn.childSpan(of.ctx, "upstream dial", of.dialFrom, now)
+		oc.tlsFlow, oc.tlsStart = of, now
+		return
+	}
+	oc.dialStart, oc.dialEnd, oc.tlsEnd = oc.connectedAt, now, time.Time{}
+}
+
+func (adn *OTelTracing) TLSEstablishedServer(connCtx *proxy.ConnContext) {
+	oc := adn.conn(connCtx)
+	if oc == nil {
+		return
+	}
+	now := time.Now()
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if of := oc.tlsFlow; of != nil {
+		oc.tlsFlow = nil
+		adn.childSpan(of.ctx, "upstream tls handshake", oc.tlsStart, now)
+		return
+	}
+	if !oc.dialEnd.IsZero() {
+		oc.tlsEnd = now
+	}
+}
+
+func (adn *OTelTracing) conn(connCtx *proxy.ConnContext) *otelConn {
+	if connCtx == nil || connCtx.ClientConn == nil {
+		return nil
+	}
+	v, ok := adn.conns.Load(connCtx.ClientConn.ID)
+	if !ok {
+		return nil
+	}
+	return v.(*otelConn)
+}
+
+func (adn *OTelTracing) childSpan(ctx context.Context, name string, start, end time.Time) {
+	_, span := adn.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+	)
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,124 @@
+package addons_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	uuid "github.com/satori/go.uuid"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newOTelTracing() (*addons.OTelTracing, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return addons.NewOTelTracing(provider), recorder
+}
+
+// waitForSpans waits until n spans have ended.
+func waitForSpans(c *qt.C, recorder *tracetest.SpanRecorder, n int) []sdktrace.ReadOnlySpan {
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.Ended()) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	spans := recorder.Ended()
+	c.Assert(spans, qt.HasLen, n)
+	return spans
+}
+
+func spansByName(spans []sdktrace.ReadOnlySpan) map[string]sdktrace.ReadOnlySpan {
+	m := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		m[span.Name()] = span
+	}
+	return m
+}
+
+func TestOTelTracingDialDuringFlow(t *testing.T) {
+	c := qt.New(t)
+	tracing, recorder := newOTelTracing()
+
+	client := &proxy.ClientConn{ID: uuid.NewV4()}
+	connCtx := &proxy.ConnContext{ClientConn: client}
+	tracing.ClientConnected(client)
+
+	f := newJSONLFlow(c, nil, nil)
+	f.ConnContext = connCtx
+	tracing.Requestheaders(f)
+	tracing.Request(f)
+	connCtx.ServerConn = &proxy.ServerConn{ID: uuid.NewV4()}
+	tracing.ServerConnected(connCtx)
+	tracing.TLSEstablishedServer(connCtx)
+	f.Finish()
+
+	spans := spansByName(waitForSpans(c, recorder, 3))
+	flowSpan := spans["POST"]
+	c.Assert(flowSpan, qt.Not(qt.IsNil))
+	c.Assert(f.TraceID, qt.Equals, flowSpan.SpanContext().TraceID().String())
+	for _, name := range []string{"upstream dial", "upstream tls handshake"} {
+		span := spans[name]
+		c.Assert(span, qt.Not(qt.IsNil), qt.Commentf(name))
+		c.Assert(span.Parent().SpanID(), qt.Equals, flowSpan.SpanContext().SpanID())
+		c.Assert(span.StartTime().Before(flowSpan.StartTime()), qt.IsFalse)
+	}
+
+	// the request sent upstream carries the flow span
+	c.Assert(f.Request.Header.Get("traceparent"), qt.Equals,
+		"00-"+flowSpan.SpanContext().TraceID().String()+"-"+flowSpan.SpanContext().SpanID().String()+"-01")
+
+	attrs := make(map[string]any)
+	for _, attr := range flowSpan.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.AsInterface()
+	}
+	c.Assert(attrs["http.request.method"], qt.Equals, "POST")
+	c.Assert(attrs["url.full"], qt.Equals, "https://example.com/upload")
+	c.Assert(attrs["http.response.status_code"], qt.Equals, int64(200))
+}
+
+func TestOTelTracingDialBeforeFlow(t *testing.T) {
+	c := qt.New(t)
+	tracing, recorder := newOTelTracing()
+
+	client := &proxy.ClientConn{ID: uuid.NewV4()}
+	connCtx := &proxy.ConnContext{ClientConn: client}
+	tracing.ClientConnected(client)
+	connCtx.ServerConn = &proxy.ServerConn{ID: uuid.NewV4()}
+	tracing.ServerConnected(connCtx)
+	tracing.TLSEstablishedServer(connCtx)
+
+	for range 2 {
+		f := newJSONLFlow(c, nil, nil)
+		f.ConnContext = connCtx
+		tracing.Requestheaders(f)
+		f.Finish()
+	}
+
+	// the connection spans belong to the first flow only
+	spans := waitForSpans(c, recorder, 4)
+	counts := make(map[string]int)
+	for _, span := range spans {
+		counts[span.Name()]++
+	}
+	c.Assert(counts, qt.DeepEquals, map[string]int{"POST": 2, "upstream dial": 1, "upstream tls handshake": 1})
+}
+
+func TestOTelTracingContinuesIncomingTrace(t *testing.T) {
+	c := qt.New(t)
+	tracing, recorder := newOTelTracing()
+
+	f := newJSONLFlow(c, nil, nil)
+	f.Response = nil
+	f.Request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tracing.Requestheaders(f)
+	f.Finish()
+
+	span := waitForSpans(c, recorder, 1)[0]
+	c.Assert(span.SpanContext().TraceID().String(), qt.Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+	c.Assert(span.Parent().SpanID().String(), qt.Equals, "00f067aa0ba902b7")
+	c.Assert(f.TraceID, qt.Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+	c.Assert(span.Status().Description, qt.Equals, "no response")
+}
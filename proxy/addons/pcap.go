@@ -0,0 +1,165 @@
+package addons
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/pcapng"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+var (
+	pcapClientAddr = netip.MustParseAddr("10.0.0.1")
+	pcapServerAddr = netip.MustParseAddr("10.0.0.2")
+)
+
+// pcapServerPort is the server port of the synthetic streams, so Wireshark dissects them as HTTP.
+const pcapServerPort = 80
+
+// PCAPWriter writes the decrypted exchanges of finished flows to a pcapng file, so
+// intercepted HTTPS traffic can be analyzed in Wireshark without a key log.
+//
+// Every flow becomes its own synthetic TCP connection carrying the request and the
+// response in HTTP/1.1 form, whatever protocol was used. The connection goes from
+// the client's address to the server's address on port 80; addresses that are not
+// known are replaced with 10.0.0.1 and 10.0.0.2.
+type PCAPWriter struct {
+	proxy.BaseAddon
+	mu         sync.Mutex
+	out        *pcapng.Writer
+	nextPort   uint16
+	respStarts sync.Map // *proxy.Flow -> time.Time
+}
+
+func NewPCAPWriter(out io.Writer) *PCAPWriter {
+	return &PCAPWriter{out: pcapng.NewWriter(out), nextPort: 10000}
+}
+
+// NewPCAPWriterFromFile creates a PCAPWriter writing to the file at filename, which
+// is truncated.
+func NewPCAPWriterFromFile(filename string) (*PCAPWriter, error) {
+	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return NewPCAPWriter(out), nil
+}
+
+func (adn *PCAPWriter) Requestheaders(f *proxy.Flow) {
+	start := time.Now()
+	go func() {
+		<-f.Done()
+		end := time.Now()
+		respStart := end
+		if t, ok := adn.respStarts.LoadAndDelete(f); ok {
+			respStart = t.(time.Time)
+		}
+		if err := adn.writeFlow(f, start, respStart, end); err != nil {
+			slog.Error("failed to write pcap", "error", err)
+		}
+	}()
+}
+
+func (adn *PCAPWriter) Responseheaders(f *proxy.Flow) {
+	adn.respStarts.Store(f, time.Now())
+}
+
+func (adn *PCAPWriter) writeFlow(f *proxy.Flow, start, respStart, end time.Time) error {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+
+	clientIP, serverIP := pcapEndpoints(f.ConnContext)
+	client := netip.AddrPortFrom(clientIP, adn.nextPort)
+	adn.nextPort++
+	if adn.nextPort == 0 {
+		adn.nextPort = 10000
+	}
+	stream := pcapng.NewTCPStream(client, netip.AddrPortFrom(serverIP, pcapServerPort))
+
+	write := func(ts time.Time, packets [][]byte) error {
+		for _, packet := range packets {
+			if err := adn.out.WritePacket(ts, packet); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := write(start, stream.Open()); err != nil {
+		return err
+	}
+	if err := write(start, stream.ClientData(pcapRequest(f.Request))); err != nil {
+		return err
+	}
+	if f.Response != nil {
+		if err := write(respStart, stream.ServerData(pcapResponse(f.Response))); err != nil {
+			return err
+		}
+	}
+	return write(end, stream.Close())
+}
+
+// pcapEndpoints returns the client and server IP addresses of the flow's connection,
+// or the placeholders when they are unknown or of different IP versions.
+func pcapEndpoints(connCtx *proxy.ConnContext) (client, server netip.Addr) {
+	client, server = pcapClientAddr, pcapServerAddr
+	if connCtx == nil {
+		return client, server
+	}
+	var clientIP, serverIP netip.Addr
+	if connCtx.ClientConn != nil && connCtx.ClientConn.Conn != nil {
+		clientIP = addrIP(connCtx.ClientConn.Conn.RemoteAddr())
+	}
+	if connCtx.ServerConn != nil && connCtx.ServerConn.Conn != nil {
+		serverIP = addrIP(connCtx.ServerConn.Conn.RemoteAddr())
+	}
+	if clientIP.IsValid() && serverIP.IsValid() && clientIP.Is4() == serverIP.Is4() {
+		return clientIP, serverIP
+	}
+	return client, server
+}
+
+func addrIP(addr net.Addr) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
+// pcapRequest serializes req as an HTTP/1.1 request.
+func pcapRequest(req *proxy.Request) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	fmt.Fprintf(&buf, "Host: %s\r\n", req.URL.Host)
+	writePCAPHeader(&buf, req.Header, req.Body)
+	return buf.Bytes()
+}
+
+// pcapResponse serializes res as an HTTP/1.1 response.
+func pcapResponse(res *proxy.Response) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", res.StatusCode, http.StatusText(res.StatusCode))
+	writePCAPHeader(&buf, res.Header, res.Body)
+	return buf.Bytes()
+}
+
+// writePCAPHeader writes the header and body, framed by Content-Length since the
+// bodies are no longer chunked.
+func writePCAPHeader(buf *bytes.Buffer, header http.Header, body []byte) {
+	_ = header.WriteSubset(buf, map[string]bool{
+		"Host":              true,
+		"Content-Length":    true,
+		"Transfer-Encoding": true,
+	})
+	buf.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n")
+	buf.Write(body)
+}
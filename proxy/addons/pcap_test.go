@@ -0,0 +1,89 @@
+package addons_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// countPackets counts the enhanced packet blocks of a pcapng file.
+func countPackets(data []byte) int {
+	n := 0
+	for len(data) >= 8 {
+		if binary.LittleEndian.Uint32(data) == 6 {
+			n++
+		}
+		data = data[binary.LittleEndian.Uint32(data[4:]):]
+	}
+	return n
+}
+
+func TestPCAPWriter(t *testing.T) {
+	c := qt.New(t)
+	out := &lockedBuffer{}
+	writer := addons.NewPCAPWriter(out)
+
+	f := newJSONLFlow(c, []byte("hello"), []byte(`{"ok":true}`))
+	f.Request.Proto = "HTTP/2.0"
+	f.Response.Header.Set("Transfer-Encoding", "chunked")
+	writer.Requestheaders(f)
+	writer.Responseheaders(f)
+	f.Finish()
+
+	// handshake, request and ACK, response and ACK, FINs
+	const wantPackets = 3 + 2 + 2 + 3
+	deadline := time.Now().Add(time.Second)
+	for countPackets(out.Bytes()) < wantPackets && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	data := out.Bytes()
+	c.Assert(countPackets(data), qt.Equals, wantPackets)
+
+	c.Assert(bytes.HasPrefix(data, []byte{0x0A, 0x0D, 0x0D, 0x0A}), qt.IsTrue)
+	c.Assert(bytes.Contains(data, []byte("POST /upload HTTP/1.1\r\nHost: example.com\r\n")), qt.IsTrue)
+	c.Assert(bytes.Contains(data, []byte("Content-Length: 5\r\n\r\nhello")), qt.IsTrue)
+	c.Assert(bytes.Contains(data, []byte("HTTP/1.1 200 OK\r\n")), qt.IsTrue)
+	c.Assert(bytes.Contains(data, []byte("Content-Length: 11\r\n\r\n{\"ok\":true}")), qt.IsTrue)
+	c.Assert(bytes.Contains(data, []byte("chunked")), qt.IsFalse)
+}
+
+func TestPCAPWriterWithoutResponse(t *testing.T) {
+	c := qt.New(t)
+	out := &lockedBuffer{}
+	writer := addons.NewPCAPWriter(out)
+
+	f := newJSONLFlow(c, nil, nil)
+	f.Response = nil
+	writer.Requestheaders(f)
+	f.Finish()
+
+	const wantPackets = 3 + 2 + 3
+	deadline := time.Now().Add(time.Second)
+	for countPackets(out.Bytes()) < wantPackets && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(countPackets(out.Bytes()), qt.Equals, wantPackets)
+}
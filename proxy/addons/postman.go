@@ -0,0 +1,289 @@
+package addons
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// PostmanExporter aggregates the finished flows into a Postman v2.1 collection, with a
+// folder per host and a request per method and path. The first flow seen for a request
+// provides it; the first response of each status code is kept as an example.
+//
+// Bodies that are not valid UTF-8 are left out.
+type PostmanExporter struct {
+	proxy.BaseAddon
+	filename string
+	saveMu   sync.Mutex
+
+	mu         sync.Mutex
+	collection postmanCollection
+	folders    map[string]*postmanFolder // host -> folder
+	items      map[string]*postmanItem   // method host path -> item
+}
+
+// NewPostmanExporter creates a PostmanExporter for a collection called name. The collection
+// is available through MarshalJSON.
+func NewPostmanExporter(name string) *PostmanExporter {
+	return &PostmanExporter{
+		collection: postmanCollection{
+			Info: postmanInfo{
+				PostmanID: uuid.NewV4().String(),
+				Name:      name,
+				Schema:    postmanSchema,
+			},
+			Item: []*postmanFolder{},
+		},
+		folders: make(map[string]*postmanFolder),
+		items:   make(map[string]*postmanItem),
+	}
+}
+
+// NewPostmanExporterFromFile creates a PostmanExporter rewriting the file at filename
+// whenever the collection changes. The collection is named after the file, e.g. "api"
+// for api.postman_collection.json.
+func NewPostmanExporterFromFile(filename string) (*PostmanExporter, error) {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	name = strings.TrimSuffix(name, ".postman_collection")
+	adn := NewPostmanExporter(name)
+	adn.filename = filename
+	if err := adn.save(); err != nil {
+		return nil, err
+	}
+	return adn, nil
+}
+
+type postmanCollection struct {
+	Info postmanInfo      `json:"info"`
+	Item []*postmanFolder `json:"item"`
+}
+
+type postmanInfo struct {
+	PostmanID string `json:"_postman_id"`
+	Name      string `json:"name"`
+	Schema    string `json:"schema"`
+}
+
+type postmanFolder struct {
+	Name string         `json:"name"`
+	Item []*postmanItem `json:"item"`
+}
+
+type postmanItem struct {
+	Name     string             `json:"name"`
+	Request  *postmanRequest    `json:"request"`
+	Response []*postmanResponse `json:"response"`
+}
+
+type postmanRequest struct {
+	Method string        `json:"method"`
+	Header []postmanPair `json:"header"`
+	Body   *postmanBody  `json:"body,omitempty"`
+	URL    postmanURL    `json:"url"`
+}
+
+type postmanPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanBody struct {
+	Mode    string          `json:"mode"`
+	Raw     string          `json:"raw"`
+	Options *postmanOptions `json:"options,omitempty"`
+}
+
+type postmanOptions struct {
+	Raw struct {
+		Language string `json:"language"`
+	} `json:"raw"`
+}
+
+type postmanURL struct {
+	Raw      string        `json:"raw"`
+	Protocol string        `json:"protocol"`
+	Host     []string      `json:"host"`
+	Port     string        `json:"port,omitempty"`
+	Path     []string      `json:"path"`
+	Query    []postmanPair `json:"query,omitempty"`
+}
+
+type postmanResponse struct {
+	Name            string          `json:"name"`
+	OriginalRequest *postmanRequest `json:"originalRequest"`
+	Status          string          `json:"status"`
+	Code            int             `json:"code"`
+	Header          []postmanPair   `json:"header"`
+	Body            string          `json:"body"`
+}
+
+func (adn *PostmanExporter) Requestheaders(f *proxy.Flow) {
+	go func() {
+		<-f.Done()
+		if !adn.add(f) || adn.filename == "" {
+			return
+		}
+		if err := adn.save(); err != nil {
+			slog.Error("failed to write postman collection", "error", err)
+		}
+	}()
+}
+
+// MarshalJSON returns the collection.
+func (adn *PostmanExporter) MarshalJSON() ([]byte, error) {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	return json.MarshalIndent(adn.collection, "", "  ")
+}
+
+// add records f, reporting whether the collection changed.
+func (adn *PostmanExporter) add(f *proxy.Flow) bool {
+	host := f.Request.URL.Host
+	path := f.Request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key := f.Request.Method + " " + host + path
+
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+
+	item, ok := adn.items[key]
+	if !ok {
+		folder, found := adn.folders[host]
+		if !found {
+			folder = &postmanFolder{Name: host, Item: []*postmanItem{}}
+			adn.folders[host] = folder
+			adn.collection.Item = append(adn.collection.Item, folder)
+		}
+		item = &postmanItem{
+			Name:     f.Request.Method + " " + path,
+			Request:  newPostmanRequest(f.Request),
+			Response: []*postmanResponse{},
+		}
+		adn.items[key] = item
+		folder.Item = append(folder.Item, item)
+	}
+
+	if f.Response == nil {
+		return !ok
+	}
+	for _, example := range item.Response {
+		if example.Code == f.Response.StatusCode {
+			return !ok
+		}
+	}
+	item.Response = append(item.Response, newPostmanResponse(f))
+	return true
+}
+
+// save replaces the file with the collection, so readers never see a partial one.
+func (adn *PostmanExporter) save() error {
+	adn.saveMu.Lock()
+	defer adn.saveMu.Unlock()
+	data, err := adn.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return helper.ReplaceFile(adn.filename, data)
+}
+
+func newPostmanRequest(req *proxy.Request) *postmanRequest {
+	u := req.URL
+	pr := &postmanRequest{
+		Method: req.Method,
+		Header: postmanHeaders(req.Header, "Content-Length", "Host"),
+		URL: postmanURL{
+			Raw:      u.String(),
+			Protocol: u.Scheme,
+			Host:     strings.Split(u.Hostname(), "."),
+			Port:     u.Port(),
+			Path:     strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/"),
+		},
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		pr.URL.Query = append(pr.URL.Query, postmanPair{Key: key, Value: value})
+	}
+
+	if len(req.Body) > 0 && utf8.Valid(req.Body) {
+		pr.Body = &postmanBody{Mode: "raw", Raw: string(req.Body)}
+		if language := postmanLanguage(req.Header.Get("Content-Type")); language != "" {
+			pr.Body.Options = &postmanOptions{}
+			pr.Body.Options.Raw.Language = language
+		}
+	}
+	return pr
+}
+
+func newPostmanResponse(f *proxy.Flow) *postmanResponse {
+	status := http.StatusText(f.Response.StatusCode)
+	pr := &postmanResponse{
+		Name:            strconv.Itoa(f.Response.StatusCode) + " " + status,
+		OriginalRequest: newPostmanRequest(f.Request),
+		Status:          status,
+		Code:            f.Response.StatusCode,
+		// the body is stored decoded
+		Header: postmanHeaders(f.Response.Header, "Content-Length", "Content-Encoding", "Transfer-Encoding"),
+	}
+	if body, err := f.Response.DecodedBody(); err == nil && utf8.Valid(body) {
+		pr.Body = string(body)
+	}
+	return pr
+}
+
+// postmanHeaders returns header in name order, without the skipped names.
+func postmanHeaders(header http.Header, skip ...string) []postmanPair {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if !slices.Contains(skip, http.CanonicalHeaderKey(name)) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	headers := []postmanPair{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, postmanPair{Key: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// postmanLanguage returns the raw body language Postman highlights for contentType.
+func postmanLanguage(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return "xml"
+	case mediaType == "text/html":
+		return "html"
+	case mediaType == "application/javascript" || mediaType == "text/javascript":
+		return "javascript"
+	case strings.HasPrefix(mediaType, "text/"):
+		return "text"
+	}
+	return ""
+}
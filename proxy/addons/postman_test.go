@@ -0,0 +1,139 @@
+package addons_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+// postmanCollection decodes the collection of adn once it has n requests and examples,
+// or after a second.
+func postmanCollection(c *qt.C, adn *addons.PostmanExporter, n int) map[string]any {
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, err := adn.MarshalJSON()
+		c.Assert(err, qt.IsNil)
+		var collection map[string]any
+		c.Assert(json.Unmarshal(data, &collection), qt.IsNil)
+
+		count := 0
+		for _, folder := range collection["item"].([]any) {
+			for _, item := range folder.(map[string]any)["item"].([]any) {
+				count += 1 + len(item.(map[string]any)["response"].([]any))
+			}
+		}
+		if count >= n || time.Now().After(deadline) {
+			return collection
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPostmanExporterGroupsFlows(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewPostmanExporter("captured")
+
+	first := newJSONLFlow(c, []byte(`{"name":"a"}`), []byte(`{"ok":true}`))
+	first.Request.Header.Set("Content-Type", "application/json")
+	first.Request.URL.RawQuery = "dry=1"
+	again := newJSONLFlow(c, []byte(`{"name":"b"}`), []byte(`{"ok":true}`))
+	notFound := newJSONLFlow(c, nil, []byte("missing"))
+	notFound.Response.StatusCode = 404
+	other := newJSONLFlow(c, nil, nil)
+	other.Request.URL, _ = url.Parse("http://api.example.com:8080/v1/users")
+	other.Response = nil
+
+	adn.Requestheaders(first)
+	first.Finish()
+	postmanCollection(c, adn, 2)
+	for _, f := range []*proxy.Flow{again, notFound, other} {
+		adn.Requestheaders(f)
+		f.Finish()
+	}
+
+	collection := postmanCollection(c, adn, 4)
+	info := collection["info"].(map[string]any)
+	c.Assert(info["name"], qt.Equals, "captured")
+	c.Assert(info["schema"], qt.Equals, "https://schema.getpostman.com/json/collection/v2.1.0/collection.json")
+
+	folders := collection["item"].([]any)
+	c.Assert(folders, qt.HasLen, 2)
+	names := map[string]map[string]any{}
+	for _, folder := range folders {
+		folder := folder.(map[string]any)
+		names[folder["name"].(string)] = folder
+	}
+
+	items := names["example.com"]["item"].([]any)
+	c.Assert(items, qt.HasLen, 1)
+	item := items[0].(map[string]any)
+	c.Assert(item["name"], qt.Equals, "POST /upload")
+	c.Assert(item["response"], qt.HasLen, 2)
+
+	// the first flow provides the request
+	req := item["request"].(map[string]any)
+	c.Assert(req["body"], qt.DeepEquals, map[string]any{
+		"mode":    "raw",
+		"raw":     `{"name":"a"}`,
+		"options": map[string]any{"raw": map[string]any{"language": "json"}},
+	})
+	u := req["url"].(map[string]any)
+	c.Assert(u["raw"], qt.Equals, "https://example.com/upload?dry=1")
+	c.Assert(u["host"], qt.DeepEquals, []any{"example", "com"})
+	c.Assert(u["path"], qt.DeepEquals, []any{"upload"})
+	c.Assert(u["query"], qt.DeepEquals, []any{map[string]any{"key": "dry", "value": "1"}})
+
+	notFoundExample := item["response"].([]any)[1].(map[string]any)
+	c.Assert(notFoundExample["name"], qt.Equals, "404 Not Found")
+	c.Assert(notFoundExample["code"], qt.Equals, float64(404))
+	c.Assert(notFoundExample["body"], qt.Equals, "missing")
+
+	items = names["api.example.com:8080"]["item"].([]any)
+	c.Assert(items, qt.HasLen, 1)
+	item = items[0].(map[string]any)
+	c.Assert(item["name"], qt.Equals, "POST /v1/users")
+	c.Assert(item["response"], qt.HasLen, 0)
+	c.Assert(item["request"].(map[string]any)["url"].(map[string]any)["port"], qt.Equals, "8080")
+}
+
+func TestPostmanExporterFromFile(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "api.postman_collection.json")
+
+	adn, err := addons.NewPostmanExporterFromFile(filename)
+	c.Assert(err, qt.IsNil)
+
+	f := newJSONLFlow(c, nil, []byte(`{"ok":true}`))
+	adn.Requestheaders(f)
+	f.Finish()
+	postmanCollection(c, adn, 2)
+
+	// the file is rewritten after the collection changed
+	var collection map[string]any
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, err := os.ReadFile(filename)
+		c.Assert(err, qt.IsNil)
+		c.Assert(json.Unmarshal(data, &collection), qt.IsNil)
+		if len(collection["item"].([]any)) > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(collection["info"].(map[string]any)["name"], qt.Equals, "api")
+	c.Assert(collection["item"], qt.HasLen, 1)
+}
+
+func TestPostmanExporterFromFileError(t *testing.T) {
+	c := qt.New(t)
+	_, err := addons.NewPostmanExporterFromFile(filepath.Join(c.TempDir(), "missing", "collection.json"))
+	c.Assert(err, qt.IsNotNil)
+}
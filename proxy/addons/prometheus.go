@@ -0,0 +1,180 @@
+package addons
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/denisvmedia/go-mitmproxy/cert"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// MetricsPath is where PrometheusAddon answers direct requests to the proxy.
+const MetricsPath = "/metrics"
+
+// CertCacheStatser is implemented by CAs reporting leaf certificate cache statistics,
+// such as cert.SelfSignCA and cert.ExternalCA.
+type CertCacheStatser interface {
+	CacheStats() cert.CacheStats
+}
+
+// RejectedClientsCounter is implemented by proxies counting the client connections
+// they rejected, such as proxy.Proxy.
+type RejectedClientsCounter interface {
+	RejectedClients() int64
+}
+
+// SeparateClientStatser is implemented by proxies counting the connections of their
+// separate http client, such as proxy.Proxy.
+type SeparateClientStatser interface {
+	SeparateClientStats() proxy.ClientPoolStats
+}
+
+// PrometheusAddon collects Prometheus metrics about the proxied flows and connections.
+// The metrics are served by Handler, and by the proxy itself to direct requests for
+// MetricsPath, e.g. http://localhost:9080/metrics.
+//
+// Body sizes are those of buffered bodies; streamed bodies count as empty.
+type PrometheusAddon struct {
+	proxy.BaseAddon
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	flows           *prometheus.CounterVec
+	duration        prometheus.Histogram
+	requestBytes    prometheus.Counter
+	responseBytes   prometheus.Counter
+	openConnections prometheus.Gauge
+	separateClient  *prometheus.CounterVec
+}
+
+func NewPrometheusAddon() *PrometheusAddon {
+	registry := prometheus.NewRegistry()
+	adn := &PrometheusAddon{
+		registry: registry,
+		handler:  promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+		flows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mitmproxy_flows_total",
+			Help: "Finished flows by response status code and host; status is \"none\" for flows without a response.",
+		}, []string{"status", "host"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "mitmproxy_request_duration_seconds",
+			Help:    "Time from the request headers to the end of the flow.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		requestBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mitmproxy_request_bytes_total",
+			Help: "Request body bytes received from clients.",
+		}),
+		responseBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mitmproxy_response_bytes_total",
+			Help: "Response body bytes sent to clients.",
+		}),
+		openConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mitmproxy_client_connections_open",
+			Help: "Client connections currently open.",
+		}),
+		separateClient: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mitmproxy_separate_client_requests_total",
+			Help: "Requests sent with the separate client, by whether they opened a new connection or reused one.",
+		}, []string{"connection"}),
+	}
+	registry.MustRegister(adn.flows, adn.duration, adn.requestBytes, adn.responseBytes, adn.openConnections, adn.separateClient)
+	return adn
+}
+
+// Registry returns the registry of the metrics, e.g. to add custom ones.
+func (adn *PrometheusAddon) Registry() *prometheus.Registry {
+	return adn.registry
+}
+
+// Handler serves the metrics, e.g. on a separate listener.
+func (adn *PrometheusAddon) Handler() http.Handler {
+	return adn.handler
+}
+
+// ObserveCertCache exports the leaf certificate cache statistics of ca.
+func (adn *PrometheusAddon) ObserveCertCache(ca CertCacheStatser) {
+	adn.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "mitmproxy_cert_cache_entries",
+			Help: "Leaf certificates currently cached.",
+		}, func() float64 { return float64(ca.CacheStats().Entries) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "mitmproxy_cert_cache_hits_total",
+			Help: "Leaf certificate lookups answered from the cache.",
+		}, func() float64 { return float64(ca.CacheStats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "mitmproxy_cert_cache_misses_total",
+			Help: "Leaf certificate lookups that generated a certificate.",
+		}, func() float64 { return float64(ca.CacheStats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "mitmproxy_cert_cache_evictions_total",
+			Help: "Leaf certificates dropped from the cache.",
+		}, func() float64 { return float64(ca.CacheStats().Evictions) }),
+	)
+}
+
+// ObserveRejectedClients exports the number of client connections p rejected because of
+// its allowed and denied clients or its connection limits.
+func (adn *PrometheusAddon) ObserveRejectedClients(p RejectedClientsCounter) {
+	adn.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "mitmproxy_client_connections_rejected_total",
+		Help: "Client connections closed because the client is not allowed or over the connection limits.",
+	}, func() float64 { return float64(p.RejectedClients()) }))
+}
+
+// ObserveSeparateClient exports the connection statistics of the separate http client
+// of p, which sends the requests of flows with UseSeparateClient or a rewritten host.
+func (adn *PrometheusAddon) ObserveSeparateClient(p SeparateClientStatser) {
+	adn.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "mitmproxy_separate_client_connections_opened_total",
+			Help: "Upstream connections dialed by the separate client.",
+		}, func() float64 { return float64(p.SeparateClientStats().Opened) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "mitmproxy_separate_client_connections_open",
+			Help: "Upstream connections of the separate client currently open, in use or idle.",
+		}, func() float64 { return float64(p.SeparateClientStats().Open) }),
+	)
+}
+
+func (adn *PrometheusAddon) ClientConnected(*proxy.ClientConn) {
+	adn.openConnections.Inc()
+}
+
+func (adn *PrometheusAddon) ClientDisconnected(*proxy.ClientConn) {
+	adn.openConnections.Dec()
+}
+
+func (adn *PrometheusAddon) Requestheaders(f *proxy.Flow) {
+	start := time.Now()
+	go func() {
+		<-f.Done()
+		adn.duration.Observe(time.Since(start).Seconds())
+
+		status := "none"
+		if f.Response != nil {
+			status = strconv.Itoa(f.Response.StatusCode)
+			adn.responseBytes.Add(float64(len(f.Response.Body)))
+		}
+		adn.requestBytes.Add(float64(len(f.Request.Body)))
+		adn.flows.WithLabelValues(status, f.Request.URL.Hostname()).Inc()
+		if f.UsedSeparateClient {
+			connection := "reused"
+			if !f.Timings().DialStart.IsZero() {
+				connection = "new"
+			}
+			adn.separateClient.WithLabelValues(connection).Inc()
+		}
+	}()
+}
+
+func (adn *PrometheusAddon) AccessProxyServer(req *http.Request, res http.ResponseWriter) {
+	if req.URL.Path == MetricsPath {
+		adn.handler.ServeHTTP(res, req)
+	}
+}
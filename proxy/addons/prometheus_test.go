@@ -0,0 +1,153 @@
+package addons_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/cert"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func scrape(c *qt.C, handler http.Handler) string {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, addons.MetricsPath, nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	body, err := io.ReadAll(rec.Body)
+	c.Assert(err, qt.IsNil)
+	return string(body)
+}
+
+// waitForMetrics scrapes until all lines are present or a second passed.
+func waitForMetrics(c *qt.C, handler http.Handler, lines ...string) string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		metrics := scrape(c, handler)
+		missing := slices.ContainsFunc(lines, func(line string) bool {
+			return !strings.Contains(metrics, line)
+		})
+		if !missing || time.Now().After(deadline) {
+			return metrics
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPrometheusAddonFlows(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewPrometheusAddon()
+
+	f := newJSONLFlow(c, []byte("hello"), []byte(`{"ok":true}`))
+	adn.Requestheaders(f)
+	f.Finish()
+	noResponse := newJSONLFlow(c, nil, nil)
+	noResponse.Response = nil
+	adn.Requestheaders(noResponse)
+	noResponse.Finish()
+
+	metrics := waitForMetrics(c, adn.Handler(),
+		`mitmproxy_flows_total{host="example.com",status="200"} 1`,
+		`mitmproxy_flows_total{host="example.com",status="none"} 1`,
+	)
+	c.Assert(metrics, qt.Contains, `mitmproxy_flows_total{host="example.com",status="200"} 1`)
+	c.Assert(metrics, qt.Contains, `mitmproxy_flows_total{host="example.com",status="none"} 1`)
+	c.Assert(metrics, qt.Contains, `mitmproxy_request_duration_seconds_count 2`)
+	c.Assert(metrics, qt.Contains, `mitmproxy_request_bytes_total 5`)
+	c.Assert(metrics, qt.Contains, `mitmproxy_response_bytes_total 11`)
+}
+
+func TestPrometheusAddonConnections(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewPrometheusAddon()
+
+	first, second := &proxy.ClientConn{}, &proxy.ClientConn{}
+	adn.ClientConnected(first)
+	adn.ClientConnected(second)
+	adn.ClientDisconnected(first)
+
+	c.Assert(scrape(c, adn.Handler()), qt.Contains, "mitmproxy_client_connections_open 1")
+}
+
+func TestPrometheusAddonCertCache(t *testing.T) {
+	c := qt.New(t)
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	adn := addons.NewPrometheusAddon()
+	adn.ObserveCertCache(ca.(*cert.SelfSignCA))
+
+	for _, name := range []string{"a.example", "a.example", "b.example"} {
+		_, err := ca.GetCert(name)
+		c.Assert(err, qt.IsNil)
+	}
+
+	metrics := scrape(c, adn.Handler())
+	c.Assert(metrics, qt.Contains, "mitmproxy_cert_cache_entries 2")
+	c.Assert(metrics, qt.Contains, "mitmproxy_cert_cache_hits_total 1")
+	c.Assert(metrics, qt.Contains, "mitmproxy_cert_cache_misses_total 2")
+	c.Assert(metrics, qt.Contains, "mitmproxy_cert_cache_evictions_total 0")
+}
+
+type rejectedClientsCounter int64
+
+func (n rejectedClientsCounter) RejectedClients() int64 { return int64(n) }
+
+func TestPrometheusAddonRejectedClients(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewPrometheusAddon()
+	adn.ObserveRejectedClients(rejectedClientsCounter(3))
+
+	c.Assert(scrape(c, adn.Handler()), qt.Contains, "mitmproxy_client_connections_rejected_total 3")
+}
+
+type separateClientStatser proxy.ClientPoolStats
+
+func (s separateClientStatser) SeparateClientStats() proxy.ClientPoolStats {
+	return proxy.ClientPoolStats(s)
+}
+
+func TestPrometheusAddonSeparateClient(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewPrometheusAddon()
+	adn.ObserveSeparateClient(separateClientStatser{Opened: 4, Open: 2})
+
+	dialed := newJSONLFlow(c, nil, nil)
+	dialed.UsedSeparateClient = true
+	dialed.UpdateTimings(func(t *proxy.Timings) { t.DialStart = time.Now() })
+	reused := newJSONLFlow(c, nil, nil)
+	reused.UsedSeparateClient = true
+	for _, f := range []*proxy.Flow{dialed, reused, newJSONLFlow(c, nil, nil)} {
+		adn.Requestheaders(f)
+		f.Finish()
+	}
+
+	metrics := waitForMetrics(c, adn.Handler(),
+		`mitmproxy_separate_client_requests_total{connection="new"} 1`,
+		`mitmproxy_separate_client_requests_total{connection="reused"} 1`,
+	)
+	c.Assert(metrics, qt.Contains, `mitmproxy_separate_client_requests_total{connection="new"} 1`)
+	c.Assert(metrics, qt.Contains, `mitmproxy_separate_client_requests_total{connection="reused"} 1`)
+	c.Assert(metrics, qt.Contains, "mitmproxy_separate_client_connections_opened_total 4")
+	c.Assert(metrics, qt.Contains, "mitmproxy_separate_client_connections_open 2")
+}
+
+func TestPrometheusAddonAccessProxyServer(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewPrometheusAddon()
+
+	rec := httptest.NewRecorder()
+	adn.AccessProxyServer(httptest.NewRequest(http.MethodGet, "/metrics", nil), rec)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Contains, "mitmproxy_client_connections_open 0")
+
+	// other paths are left to the proxy
+	rec = httptest.NewRecorder()
+	adn.AccessProxyServer(httptest.NewRequest(http.MethodGet, "/other", nil), rec)
+	c.Assert(rec.Body.Len(), qt.Equals, 0)
+}
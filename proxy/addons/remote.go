@@ -0,0 +1,366 @@
+package addons
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons/remotepb"
+)
+
+// RemoteAddonOptions configure RemoteAddon.
+type RemoteAddonOptions struct {
+	// Timeout limits each call to the remote addon. Zero means 5 seconds.
+	Timeout time.Duration
+	// DialOptions configure the gRPC connection, e.g. its credentials. No options
+	// connect without TLS.
+	DialOptions []grpc.DialOption
+}
+
+// RemoteAddon forwards the events of the Addon interface to a remote addon, a gRPC
+// server implementing the Addon service of the remotepb package in any language, and
+// applies the changes it returns. The stream modifiers, ServerSentEvent,
+// AccessProxyServer and RewriteTarget are not forwarded.
+//
+// Remote addons run out of process: when the server is down, slow or failing, the
+// error is logged and flows go on unchanged, and the connection is made again when the
+// server is back, so servers can be restarted or swapped while the proxy runs. Methods
+// the server does not implement are not called again until the proxy reconnects.
+type RemoteAddon struct {
+	proxy.BaseAddon
+	target  string
+	timeout time.Duration
+	conn    *grpc.ClientConn
+	client  remotepb.AddonClient
+	cancel  context.CancelFunc
+
+	mu            sync.Mutex
+	unimplemented map[string]bool // methods
+}
+
+// NewRemoteAddon creates a RemoteAddon for the server at target, e.g.
+// "localhost:50051". It connects in the background, so the server may start later.
+func NewRemoteAddon(target string, options RemoteAddonOptions) (*RemoteAddon, error) {
+	if options.Timeout == 0 {
+		options.Timeout = 5 * time.Second
+	}
+	dialOptions := options.DialOptions
+	if len(dialOptions) == 0 {
+		dialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(target, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	adn := &RemoteAddon{
+		target:        target,
+		timeout:       options.Timeout,
+		conn:          conn,
+		client:        remotepb.NewAddonClient(conn),
+		cancel:        cancel,
+		unimplemented: make(map[string]bool),
+	}
+	conn.Connect()
+	go adn.watch(ctx)
+	return adn, nil
+}
+
+// Close closes the connection to the remote addon.
+func (adn *RemoteAddon) Close() error {
+	adn.cancel()
+	return adn.conn.Close()
+}
+
+// Running implements proxy.LifecycleAddon; the connection is made when the addon is
+// created.
+func (adn *RemoteAddon) Running(*proxy.Proxy) {}
+
+// Done closes the connection to the remote addon.
+func (adn *RemoteAddon) Done() {
+	if err := adn.Close(); err != nil {
+		slog.Warn("remote addon close error", "target", adn.target, "error", err)
+	}
+}
+
+// watch forgets the unimplemented methods whenever the connection is ready again, as
+// the server may have been replaced.
+func (adn *RemoteAddon) watch(ctx context.Context) {
+	for {
+		state := adn.conn.GetState()
+		if state == connectivity.Ready {
+			adn.mu.Lock()
+			clear(adn.unimplemented)
+			adn.mu.Unlock()
+			slog.Debug("remote addon connected", "target", adn.target)
+		}
+		if !adn.conn.WaitForStateChange(ctx, state) {
+			return
+		}
+	}
+}
+
+// call calls the remote method with fn unless the server does not implement it, and
+// logs errors. It reports whether the call succeeded.
+func (adn *RemoteAddon) call(method string, fn func(ctx context.Context) error) bool {
+	adn.mu.Lock()
+	skip := adn.unimplemented[method]
+	adn.mu.Unlock()
+	if skip {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), adn.timeout)
+	defer cancel()
+	err := fn(ctx)
+	if err == nil {
+		return true
+	}
+	if status.Code(err) == codes.Unimplemented {
+		adn.mu.Lock()
+		adn.unimplemented[method] = true
+		adn.mu.Unlock()
+		return false
+	}
+	slog.Warn("remote addon error", "target", adn.target, "method", method, "error", err)
+	return false
+}
+
+func (adn *RemoteAddon) ClientConnected(client *proxy.ClientConn) {
+	adn.call("ClientConnected", func(ctx context.Context) error {
+		_, err := adn.client.ClientConnected(ctx, toRemoteClientConn(client))
+		return err
+	})
+}
+
+func (adn *RemoteAddon) ClientDisconnected(client *proxy.ClientConn) {
+	adn.call("ClientDisconnected", func(ctx context.Context) error {
+		_, err := adn.client.ClientDisconnected(ctx, toRemoteClientConn(client))
+		return err
+	})
+}
+
+func (adn *RemoteAddon) ServerConnected(connCtx *proxy.ConnContext) {
+	adn.callServerConn("ServerConnected", adn.client.ServerConnected, connCtx)
+}
+
+func (adn *RemoteAddon) ServerDisconnected(connCtx *proxy.ConnContext) {
+	adn.callServerConn("ServerDisconnected", adn.client.ServerDisconnected, connCtx)
+}
+
+func (adn *RemoteAddon) TLSEstablishedServer(connCtx *proxy.ConnContext) {
+	adn.callServerConn("TlsEstablishedServer", adn.client.TlsEstablishedServer, connCtx)
+}
+
+type remoteServerConnMethod func(context.Context, *remotepb.ServerConn, ...grpc.CallOption) (*emptypb.Empty, error)
+
+func (adn *RemoteAddon) callServerConn(method string, fn remoteServerConnMethod, connCtx *proxy.ConnContext) {
+	if connCtx.ServerConn == nil {
+		return
+	}
+	adn.call(method, func(ctx context.Context) error {
+		_, err := fn(ctx, toRemoteServerConn(connCtx))
+		return err
+	})
+}
+
+func (adn *RemoteAddon) Requestheaders(f *proxy.Flow) {
+	adn.callFlow("Requestheaders", adn.client.Requestheaders, f, false)
+}
+
+func (adn *RemoteAddon) Request(f *proxy.Flow) {
+	adn.callFlow("Request", adn.client.Request, f, true)
+}
+
+func (adn *RemoteAddon) Responseheaders(f *proxy.Flow) {
+	adn.callFlow("Responseheaders", adn.client.Responseheaders, f, false)
+}
+
+func (adn *RemoteAddon) Response(f *proxy.Flow) {
+	adn.callFlow("Response", adn.client.Response, f, true)
+}
+
+type remoteFlowMethod func(context.Context, *remotepb.Flow, ...grpc.CallOption) (*remotepb.FlowUpdate, error)
+
+// callFlow calls a flow method and applies the update. Before the bodies are read,
+// withBodies is false and the bodies of the update are only used by new responses.
+func (adn *RemoteAddon) callFlow(method string, fn remoteFlowMethod, f *proxy.Flow, withBodies bool) {
+	remoteFlow := toRemoteFlow(f)
+	var update *remotepb.FlowUpdate
+	ok := adn.call(method, func(ctx context.Context) error {
+		var err error
+		update, err = fn(ctx, remoteFlow)
+		return err
+	})
+	if !ok {
+		return
+	}
+	if err := applyRemoteUpdate(f, remoteFlow, update, withBodies); err != nil {
+		slog.Warn("remote addon error", "target", adn.target, "method", method, "error", err)
+	}
+}
+
+func (adn *RemoteAddon) WebsocketStart(f *proxy.Flow) {
+	adn.call("WebsocketStart", func(ctx context.Context) error {
+		_, err := adn.client.WebsocketStart(ctx, toRemoteFlow(f))
+		return err
+	})
+}
+
+func (adn *RemoteAddon) WebsocketMessage(f *proxy.Flow, msg *proxy.WebSocketMessage) {
+	var update *remotepb.WebSocketMessageUpdate
+	ok := adn.call("WebsocketMessage", func(ctx context.Context) error {
+		var err error
+		update, err = adn.client.WebsocketMessage(ctx, &remotepb.WebSocketMessageEvent{
+			Flow: toRemoteFlow(f),
+			Message: &remotepb.WebSocketMessage{
+				FromClient: msg.FromClient,
+				Text:       msg.IsText(),
+				Content:    msg.Content,
+			},
+		})
+		return err
+	})
+	if !ok {
+		return
+	}
+	if update.Content != nil {
+		msg.Content = update.Content
+	}
+	if update.Drop {
+		msg.Dropped = true
+	}
+}
+
+func (adn *RemoteAddon) WebsocketEnd(f *proxy.Flow) {
+	adn.call("WebsocketEnd", func(ctx context.Context) error {
+		_, err := adn.client.WebsocketEnd(ctx, toRemoteFlow(f))
+		return err
+	})
+}
+
+func toRemoteClientConn(client *proxy.ClientConn) *remotepb.ClientConn {
+	rc := &remotepb.ClientConn{
+		Id:  client.ID.String(),
+		Tls: client.TLS,
+	}
+	if client.Conn != nil {
+		rc.Address = client.Conn.RemoteAddr().String()
+	}
+	return rc
+}
+
+func toRemoteServerConn(connCtx *proxy.ConnContext) *remotepb.ServerConn {
+	sc := &remotepb.ServerConn{
+		Id:      connCtx.ServerConn.ID.String(),
+		Address: connCtx.ServerConn.Address,
+		Tls:     connCtx.ServerConn.TLSState != nil,
+	}
+	if connCtx.ClientConn != nil {
+		sc.ClientId = connCtx.ClientConn.ID.String()
+		sc.Tls = sc.Tls || connCtx.ClientConn.TLS
+	}
+	return sc
+}
+
+func toRemoteHeaders(header http.Header) []*remotepb.Header {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var headers []*remotepb.Header
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, &remotepb.Header{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func fromRemoteHeaders(headers []*remotepb.Header) http.Header {
+	header := make(http.Header)
+	for _, h := range headers {
+		header.Add(h.Name, h.Value)
+	}
+	return header
+}
+
+func toRemoteFlow(f *proxy.Flow) *remotepb.Flow {
+	reqBody, err := f.Request.DecodedBody()
+	if err != nil {
+		reqBody = f.Request.Body
+	}
+	rf := &remotepb.Flow{
+		Id:       f.ID.String(),
+		IsReplay: f.IsReplay,
+		Request: &remotepb.Request{
+			Method:  f.Request.Method,
+			Url:     f.Request.URL.String(),
+			Proto:   f.Request.Proto,
+			Headers: toRemoteHeaders(f.Request.Header),
+			Body:    reqBody,
+		},
+	}
+	if f.Response != nil {
+		resBody, err := f.Response.DecodedBody()
+		if err != nil {
+			resBody = f.Response.Body
+		}
+		rf.Response = &remotepb.Response{
+			StatusCode: int32(f.Response.StatusCode),
+			Headers:    toRemoteHeaders(f.Response.Header),
+			Body:       resBody,
+		}
+	}
+	if f.ConnContext != nil && f.ConnContext.ClientConn != nil {
+		rf.ClientConn = toRemoteClientConn(f.ConnContext.ClientConn)
+	}
+	return rf
+}
+
+// applyRemoteUpdate applies update to f, sent as sent. Bodies are only replaced if
+// they changed, so that unchanged ones keep their encoding.
+func applyRemoteUpdate(f *proxy.Flow, sent *remotepb.Flow, update *remotepb.FlowUpdate, withBodies bool) error {
+	if req := update.Request; req != nil {
+		u, err := url.Parse(req.Url)
+		if err != nil {
+			return err
+		}
+		f.Request.Method = req.Method
+		f.Request.URL = u
+		f.Request.Header = fromRemoteHeaders(req.Headers)
+		if withBodies && !bytes.Equal(req.Body, sent.Request.Body) {
+			f.Request.Body = req.Body
+			setDecodedBodyHeaders(&f.Request.Header, len(req.Body))
+		}
+	}
+	if res := update.Response; res != nil {
+		if f.Response == nil {
+			f.Response = &proxy.Response{Body: res.Body}
+			f.Response.StatusCode = int(res.StatusCode)
+			f.Response.Header = fromRemoteHeaders(res.Headers)
+			setDecodedBodyHeaders(&f.Response.Header, len(res.Body))
+			return nil
+		}
+		f.Response.StatusCode = int(res.StatusCode)
+		f.Response.Header = fromRemoteHeaders(res.Headers)
+		if withBodies && (sent.Response == nil || !bytes.Equal(res.Body, sent.Response.Body)) {
+			f.Response.Body = res.Body
+			setDecodedBodyHeaders(&f.Response.Header, len(res.Body))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package addons_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons/remotepb"
+)
+
+// testRemoteAddon implements some methods of the Addon service.
+type testRemoteAddon struct {
+	remotepb.UnimplementedAddonServer
+	header string
+}
+
+func (s *testRemoteAddon) Requestheaders(_ context.Context, f *remotepb.Flow) (*remotepb.FlowUpdate, error) {
+	u := f.Request.Url
+	if u != "https://example.com/blocked" {
+		return &remotepb.FlowUpdate{}, nil
+	}
+	return &remotepb.FlowUpdate{Response: &remotepb.Response{
+		StatusCode: 403,
+		Headers:    []*remotepb.Header{{Name: "Content-Type", Value: "text/plain"}},
+		Body:       []byte("blocked"),
+	}}, nil
+}
+
+func (s *testRemoteAddon) Request(_ context.Context, f *remotepb.Flow) (*remotepb.FlowUpdate, error) {
+	req := f.Request
+	req.Method = "PUT"
+	req.Headers = append(req.Headers, &remotepb.Header{Name: "X-Remote", Value: s.header})
+	req.Body = append(req.Body, " changed"...)
+	return &remotepb.FlowUpdate{Request: req}, nil
+}
+
+func (s *testRemoteAddon) Response(_ context.Context, f *remotepb.Flow) (*remotepb.FlowUpdate, error) {
+	res := f.Response
+	res.StatusCode = 202
+	return &remotepb.FlowUpdate{Response: res}, nil
+}
+
+func (s *testRemoteAddon) WebsocketMessage(_ context.Context, event *remotepb.WebSocketMessageEvent) (*remotepb.WebSocketMessageUpdate, error) {
+	if !event.Message.FromClient {
+		return &remotepb.WebSocketMessageUpdate{Drop: true}, nil
+	}
+	return &remotepb.WebSocketMessageUpdate{Content: append([]byte("client: "), event.Message.Content...)}, nil
+}
+
+// testRemoteAddonServer serves testRemoteAddon and counts the calls per method.
+type testRemoteAddonServer struct {
+	server *grpc.Server
+	mu     sync.Mutex
+	calls  map[string]int
+}
+
+func startTestRemoteAddonServer(c *qt.C, addr, header string) (*testRemoteAddonServer, string) {
+	ln, err := net.Listen("tcp", addr)
+	c.Assert(err, qt.IsNil)
+	s := &testRemoteAddonServer{calls: make(map[string]int)}
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		s.mu.Lock()
+		s.calls[info.FullMethod]++
+		s.mu.Unlock()
+		return handler(ctx, req)
+	}))
+	remotepb.RegisterAddonServer(s.server, &testRemoteAddon{header: header})
+	go s.server.Serve(ln)
+	return s, ln.Addr().String()
+}
+
+func (s *testRemoteAddonServer) callCount(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls["/mitmproxy.addon.v1.Addon/"+method]
+}
+
+func newTestRemoteAddon(c *qt.C, target string) *addons.RemoteAddon {
+	adn, err := addons.NewRemoteAddon(target, addons.RemoteAddonOptions{
+		Timeout: time.Second,
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithConnectParams(grpc.ConnectParams{Backoff: backoff.Config{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}}),
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Cleanup(func() { adn.Close() })
+	return adn
+}
+
+func TestRemoteAddon(t *testing.T) {
+	c := qt.New(t)
+	server, addr := startTestRemoteAddonServer(c, "127.0.0.1:0", "1")
+	defer server.server.Stop()
+	adn := newTestRemoteAddon(c, addr)
+
+	f := newJSONLFlow(c, []byte("body"), []byte(`{"ok":true}`))
+	adn.Requestheaders(f)
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "PUT")
+	c.Assert(f.Request.Header.Get("X-Remote"), qt.Equals, "1")
+	c.Assert(f.Request.Header.Get("Content-Type"), qt.Equals, "application/octet-stream")
+	c.Assert(string(f.Request.Body), qt.Equals, "body changed")
+	c.Assert(f.Request.Header.Get("Content-Length"), qt.Equals, "12")
+
+	adn.Responseheaders(f)
+	adn.Response(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 202)
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(string(f.Response.Body), qt.Equals, `{"ok":true}`)
+
+	// unimplemented methods are not called again
+	adn.Responseheaders(f)
+	c.Assert(server.callCount("Responseheaders"), qt.Equals, 1)
+
+	blocked := newJSONLFlow(c, nil, nil)
+	blocked.Request.URL.Path = "/blocked"
+	blocked.Response = nil
+	adn.Requestheaders(blocked)
+	c.Assert(blocked.Response, qt.IsNotNil)
+	c.Assert(blocked.Response.StatusCode, qt.Equals, 403)
+	c.Assert(blocked.Response.Header.Get("Content-Type"), qt.Equals, "text/plain")
+	c.Assert(string(blocked.Response.Body), qt.Equals, "blocked")
+
+	fromClient := &proxy.WebSocketMessage{Type: proxy.WebSocketText, FromClient: true, Content: []byte("hi")}
+	adn.WebsocketMessage(f, fromClient)
+	c.Assert(string(fromClient.Content), qt.Equals, "client: hi")
+	fromServer := &proxy.WebSocketMessage{Type: proxy.WebSocketText, Content: []byte("hi")}
+	adn.WebsocketMessage(f, fromServer)
+	c.Assert(fromServer.Dropped, qt.IsTrue)
+	c.Assert(string(fromServer.Content), qt.Equals, "hi")
+}
+
+func TestRemoteAddonServerRestart(t *testing.T) {
+	c := qt.New(t)
+	server, addr := startTestRemoteAddonServer(c, "127.0.0.1:0", "1")
+	adn := newTestRemoteAddon(c, addr)
+
+	f := newJSONLFlow(c, []byte("body"), nil)
+	adn.Request(f)
+	c.Assert(f.Request.Header.Get("X-Remote"), qt.Equals, "1")
+
+	// flows go on unchanged while the server is down
+	server.server.Stop()
+	f = newJSONLFlow(c, []byte("body"), nil)
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "POST")
+	c.Assert(string(f.Request.Body), qt.Equals, "body")
+
+	// and are changed by the server replacing it
+	replacement, _ := startTestRemoteAddonServer(c, addr, "2")
+	defer replacement.server.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f = newJSONLFlow(c, []byte("body"), nil)
+		adn.Request(f)
+		if f.Request.Header.Get("X-Remote") != "" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.Assert(f.Request.Header.Get("X-Remote"), qt.Equals, "2")
+}
+
+func TestRemoteAddonUnavailable(t *testing.T) {
+	c := qt.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	addr := ln.Addr().String()
+	ln.Close()
+	adn := newTestRemoteAddon(c, addr)
+
+	f := newJSONLFlow(c, []byte("body"), nil)
+	f.Response = nil
+	adn.Requestheaders(f)
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "POST")
+	c.Assert(f.Response, qt.IsNil)
+}
@@ -0,0 +1,817 @@
+// The out-of-process addon API of go-mitmproxy.
+//
+// A remote addon is a gRPC server implementing the Addon service, in any language.
+// The proxy calls it through addons.RemoteAddon for every event of the Addon
+// interface it forwards; methods the server leaves unimplemented are not called
+// again for a while, so servers implement only the events they need.
+//
+// Regenerate the Go code with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative addon.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v3.21.12
+// source: addon.proto
+
+package remotepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ClientConn struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The address of the client.
+	Address       string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	Tls           bool   `protobuf:"varint,3,opt,name=tls,proto3" json:"tls,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientConn) Reset() {
+	*x = ClientConn{}
+	mi := &file_addon_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientConn) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientConn) ProtoMessage() {}
+
+func (x *ClientConn) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientConn.ProtoReflect.Descriptor instead.
+func (*ClientConn) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ClientConn) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ClientConn) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *ClientConn) GetTls() bool {
+	if x != nil {
+		return x.Tls
+	}
+	return false
+}
+
+type ServerConn struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The address the proxy connected to.
+	Address string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	Tls     bool   `protobuf:"varint,3,opt,name=tls,proto3" json:"tls,omitempty"`
+	// The ID of the client connection the server connection belongs to.
+	ClientId      string `protobuf:"bytes,4,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerConn) Reset() {
+	*x = ServerConn{}
+	mi := &file_addon_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerConn) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerConn) ProtoMessage() {}
+
+func (x *ServerConn) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerConn.ProtoReflect.Descriptor instead.
+func (*ServerConn) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ServerConn) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ServerConn) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *ServerConn) GetTls() bool {
+	if x != nil {
+		return x.Tls
+	}
+	return false
+}
+
+func (x *ServerConn) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+type Header struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Header) Reset() {
+	*x = Header{}
+	mi := &file_addon_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Header) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Header) ProtoMessage() {}
+
+func (x *Header) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Header.ProtoReflect.Descriptor instead.
+func (*Header) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Header) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Header) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type Request struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Method string                 `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	Url    string                 `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	Proto  string                 `protobuf:"bytes,3,opt,name=proto,proto3" json:"proto,omitempty"`
+	// Headers in order; a name may repeat.
+	Headers []*Header `protobuf:"bytes,4,rep,name=headers,proto3" json:"headers,omitempty"`
+	// The decoded body, empty before the request event and for streamed requests.
+	Body          []byte `protobuf:"bytes,5,opt,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Request) Reset() {
+	*x = Request{}
+	mi := &file_addon_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Request) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Request) ProtoMessage() {}
+
+func (x *Request) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Request.ProtoReflect.Descriptor instead.
+func (*Request) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Request) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *Request) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Request) GetProto() string {
+	if x != nil {
+		return x.Proto
+	}
+	return ""
+}
+
+func (x *Request) GetHeaders() []*Header {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *Request) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+type Response struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	StatusCode int32                  `protobuf:"varint,1,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Headers    []*Header              `protobuf:"bytes,2,rep,name=headers,proto3" json:"headers,omitempty"`
+	// The decoded body, empty before the response event and for streamed responses.
+	Body          []byte `protobuf:"bytes,3,opt,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Response) Reset() {
+	*x = Response{}
+	mi := &file_addon_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Response) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Response) ProtoMessage() {}
+
+func (x *Response) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Response.ProtoReflect.Descriptor instead.
+func (*Response) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Response) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *Response) GetHeaders() []*Header {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *Response) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+type Flow struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Request *Request               `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"`
+	// Unset until there is a response.
+	Response      *Response   `protobuf:"bytes,3,opt,name=response,proto3" json:"response,omitempty"`
+	ClientConn    *ClientConn `protobuf:"bytes,4,opt,name=client_conn,json=clientConn,proto3" json:"client_conn,omitempty"`
+	IsReplay      bool        `protobuf:"varint,5,opt,name=is_replay,json=isReplay,proto3" json:"is_replay,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Flow) Reset() {
+	*x = Flow{}
+	mi := &file_addon_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Flow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Flow) ProtoMessage() {}
+
+func (x *Flow) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Flow.ProtoReflect.Descriptor instead.
+func (*Flow) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Flow) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Flow) GetRequest() *Request {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *Flow) GetResponse() *Response {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *Flow) GetClientConn() *ClientConn {
+	if x != nil {
+		return x.ClientConn
+	}
+	return nil
+}
+
+func (x *Flow) GetIsReplay() bool {
+	if x != nil {
+		return x.IsReplay
+	}
+	return false
+}
+
+// FlowUpdate carries the changes an addon made to a flow.
+type FlowUpdate struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Replaces the request of the flow if set.
+	Request *Request `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	// Replaces the response of the flow if set. Setting it in Requestheaders or Request
+	// answers the request without contacting the server.
+	Response      *Response `protobuf:"bytes,2,opt,name=response,proto3" json:"response,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlowUpdate) Reset() {
+	*x = FlowUpdate{}
+	mi := &file_addon_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlowUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowUpdate) ProtoMessage() {}
+
+func (x *FlowUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowUpdate.ProtoReflect.Descriptor instead.
+func (*FlowUpdate) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FlowUpdate) GetRequest() *Request {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *FlowUpdate) GetResponse() *Response {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+type WebSocketMessage struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	FromClient bool                   `protobuf:"varint,1,opt,name=from_client,json=fromClient,proto3" json:"from_client,omitempty"`
+	// Whether the message is text, not binary.
+	Text          bool   `protobuf:"varint,2,opt,name=text,proto3" json:"text,omitempty"`
+	Content       []byte `protobuf:"bytes,3,opt,name=content,proto3" json:"content,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WebSocketMessage) Reset() {
+	*x = WebSocketMessage{}
+	mi := &file_addon_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WebSocketMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WebSocketMessage) ProtoMessage() {}
+
+func (x *WebSocketMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WebSocketMessage.ProtoReflect.Descriptor instead.
+func (*WebSocketMessage) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WebSocketMessage) GetFromClient() bool {
+	if x != nil {
+		return x.FromClient
+	}
+	return false
+}
+
+func (x *WebSocketMessage) GetText() bool {
+	if x != nil {
+		return x.Text
+	}
+	return false
+}
+
+func (x *WebSocketMessage) GetContent() []byte {
+	if x != nil {
+		return x.Content
+	}
+	return nil
+}
+
+type WebSocketMessageEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Flow          *Flow                  `protobuf:"bytes,1,opt,name=flow,proto3" json:"flow,omitempty"`
+	Message       *WebSocketMessage      `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WebSocketMessageEvent) Reset() {
+	*x = WebSocketMessageEvent{}
+	mi := &file_addon_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WebSocketMessageEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WebSocketMessageEvent) ProtoMessage() {}
+
+func (x *WebSocketMessageEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WebSocketMessageEvent.ProtoReflect.Descriptor instead.
+func (*WebSocketMessageEvent) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WebSocketMessageEvent) GetFlow() *Flow {
+	if x != nil {
+		return x.Flow
+	}
+	return nil
+}
+
+func (x *WebSocketMessageEvent) GetMessage() *WebSocketMessage {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+type WebSocketMessageUpdate struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Replaces the content of the message if set.
+	Content []byte `protobuf:"bytes,1,opt,name=content,proto3,oneof" json:"content,omitempty"`
+	// Drops the message instead of forwarding it.
+	Drop          bool `protobuf:"varint,2,opt,name=drop,proto3" json:"drop,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WebSocketMessageUpdate) Reset() {
+	*x = WebSocketMessageUpdate{}
+	mi := &file_addon_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WebSocketMessageUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WebSocketMessageUpdate) ProtoMessage() {}
+
+func (x *WebSocketMessageUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_addon_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WebSocketMessageUpdate.ProtoReflect.Descriptor instead.
+func (*WebSocketMessageUpdate) Descriptor() ([]byte, []int) {
+	return file_addon_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *WebSocketMessageUpdate) GetContent() []byte {
+	if x != nil {
+		return x.Content
+	}
+	return nil
+}
+
+func (x *WebSocketMessageUpdate) GetDrop() bool {
+	if x != nil {
+		return x.Drop
+	}
+	return false
+}
+
+var File_addon_proto protoreflect.FileDescriptor
+
+const file_addon_proto_rawDesc = "" +
+	"\n" +
+	"\vaddon.proto\x12\x12mitmproxy.addon.v1\x1a\x1bgoogle/protobuf/empty.proto\"H\n" +
+	"\n" +
+	"ClientConn\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\x12\x10\n" +
+	"\x03tls\x18\x03 \x01(\bR\x03tls\"e\n" +
+	"\n" +
+	"ServerConn\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\x12\x10\n" +
+	"\x03tls\x18\x03 \x01(\bR\x03tls\x12\x1b\n" +
+	"\tclient_id\x18\x04 \x01(\tR\bclientId\"2\n" +
+	"\x06Header\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\"\x93\x01\n" +
+	"\aRequest\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x10\n" +
+	"\x03url\x18\x02 \x01(\tR\x03url\x12\x14\n" +
+	"\x05proto\x18\x03 \x01(\tR\x05proto\x124\n" +
+	"\aheaders\x18\x04 \x03(\v2\x1a.mitmproxy.addon.v1.HeaderR\aheaders\x12\x12\n" +
+	"\x04body\x18\x05 \x01(\fR\x04body\"u\n" +
+	"\bResponse\x12\x1f\n" +
+	"\vstatus_code\x18\x01 \x01(\x05R\n" +
+	"statusCode\x124\n" +
+	"\aheaders\x18\x02 \x03(\v2\x1a.mitmproxy.addon.v1.HeaderR\aheaders\x12\x12\n" +
+	"\x04body\x18\x03 \x01(\fR\x04body\"\xe5\x01\n" +
+	"\x04Flow\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x125\n" +
+	"\arequest\x18\x02 \x01(\v2\x1b.mitmproxy.addon.v1.RequestR\arequest\x128\n" +
+	"\bresponse\x18\x03 \x01(\v2\x1c.mitmproxy.addon.v1.ResponseR\bresponse\x12?\n" +
+	"\vclient_conn\x18\x04 \x01(\v2\x1e.mitmproxy.addon.v1.ClientConnR\n" +
+	"clientConn\x12\x1b\n" +
+	"\tis_replay\x18\x05 \x01(\bR\bisReplay\"}\n" +
+	"\n" +
+	"FlowUpdate\x125\n" +
+	"\arequest\x18\x01 \x01(\v2\x1b.mitmproxy.addon.v1.RequestR\arequest\x128\n" +
+	"\bresponse\x18\x02 \x01(\v2\x1c.mitmproxy.addon.v1.ResponseR\bresponse\"a\n" +
+	"\x10WebSocketMessage\x12\x1f\n" +
+	"\vfrom_client\x18\x01 \x01(\bR\n" +
+	"fromClient\x12\x12\n" +
+	"\x04text\x18\x02 \x01(\bR\x04text\x12\x18\n" +
+	"\acontent\x18\x03 \x01(\fR\acontent\"\x85\x01\n" +
+	"\x15WebSocketMessageEvent\x12,\n" +
+	"\x04flow\x18\x01 \x01(\v2\x18.mitmproxy.addon.v1.FlowR\x04flow\x12>\n" +
+	"\amessage\x18\x02 \x01(\v2$.mitmproxy.addon.v1.WebSocketMessageR\amessage\"W\n" +
+	"\x16WebSocketMessageUpdate\x12\x1d\n" +
+	"\acontent\x18\x01 \x01(\fH\x00R\acontent\x88\x01\x01\x12\x12\n" +
+	"\x04drop\x18\x02 \x01(\bR\x04dropB\n" +
+	"\n" +
+	"\b_content2\x9e\a\n" +
+	"\x05Addon\x12I\n" +
+	"\x0fClientConnected\x12\x1e.mitmproxy.addon.v1.ClientConn\x1a\x16.google.protobuf.Empty\x12L\n" +
+	"\x12ClientDisconnected\x12\x1e.mitmproxy.addon.v1.ClientConn\x1a\x16.google.protobuf.Empty\x12I\n" +
+	"\x0fServerConnected\x12\x1e.mitmproxy.addon.v1.ServerConn\x1a\x16.google.protobuf.Empty\x12L\n" +
+	"\x12ServerDisconnected\x12\x1e.mitmproxy.addon.v1.ServerConn\x1a\x16.google.protobuf.Empty\x12N\n" +
+	"\x14TlsEstablishedServer\x12\x1e.mitmproxy.addon.v1.ServerConn\x1a\x16.google.protobuf.Empty\x12J\n" +
+	"\x0eRequestheaders\x12\x18.mitmproxy.addon.v1.Flow\x1a\x1e.mitmproxy.addon.v1.FlowUpdate\x12C\n" +
+	"\aRequest\x12\x18.mitmproxy.addon.v1.Flow\x1a\x1e.mitmproxy.addon.v1.FlowUpdate\x12K\n" +
+	"\x0fResponseheaders\x12\x18.mitmproxy.addon.v1.Flow\x1a\x1e.mitmproxy.addon.v1.FlowUpdate\x12D\n" +
+	"\bResponse\x12\x18.mitmproxy.addon.v1.Flow\x1a\x1e.mitmproxy.addon.v1.FlowUpdate\x12B\n" +
+	"\x0eWebsocketStart\x12\x18.mitmproxy.addon.v1.Flow\x1a\x16.google.protobuf.Empty\x12i\n" +
+	"\x10WebsocketMessage\x12).mitmproxy.addon.v1.WebSocketMessageEvent\x1a*.mitmproxy.addon.v1.WebSocketMessageUpdate\x12@\n" +
+	"\fWebsocketEnd\x12\x18.mitmproxy.addon.v1.Flow\x1a\x16.google.protobuf.EmptyB;Z9github.com/denisvmedia/go-mitmproxy/proxy/addons/remotepbb\x06proto3"
+
+var (
+	file_addon_proto_rawDescOnce sync.Once
+	file_addon_proto_rawDescData []byte
+)
+
+func file_addon_proto_rawDescGZIP() []byte {
+	file_addon_proto_rawDescOnce.Do(func() {
+		file_addon_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_addon_proto_rawDesc), len(file_addon_proto_rawDesc)))
+	})
+	return file_addon_proto_rawDescData
+}
+
+var file_addon_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_addon_proto_goTypes = []any{
+	(*ClientConn)(nil),             // 0: mitmproxy.addon.v1.ClientConn
+	(*ServerConn)(nil),             // 1: mitmproxy.addon.v1.ServerConn
+	(*Header)(nil),                 // 2: mitmproxy.addon.v1.Header
+	(*Request)(nil),                // 3: mitmproxy.addon.v1.Request
+	(*Response)(nil),               // 4: mitmproxy.addon.v1.Response
+	(*Flow)(nil),                   // 5: mitmproxy.addon.v1.Flow
+	(*FlowUpdate)(nil),             // 6: mitmproxy.addon.v1.FlowUpdate
+	(*WebSocketMessage)(nil),       // 7: mitmproxy.addon.v1.WebSocketMessage
+	(*WebSocketMessageEvent)(nil),  // 8: mitmproxy.addon.v1.WebSocketMessageEvent
+	(*WebSocketMessageUpdate)(nil), // 9: mitmproxy.addon.v1.WebSocketMessageUpdate
+	(*emptypb.Empty)(nil),          // 10: google.protobuf.Empty
+}
+var file_addon_proto_depIdxs = []int32{
+	2,  // 0: mitmproxy.addon.v1.Request.headers:type_name -> mitmproxy.addon.v1.Header
+	2,  // 1: mitmproxy.addon.v1.Response.headers:type_name -> mitmproxy.addon.v1.Header
+	3,  // 2: mitmproxy.addon.v1.Flow.request:type_name -> mitmproxy.addon.v1.Request
+	4,  // 3: mitmproxy.addon.v1.Flow.response:type_name -> mitmproxy.addon.v1.Response
+	0,  // 4: mitmproxy.addon.v1.Flow.client_conn:type_name -> mitmproxy.addon.v1.ClientConn
+	3,  // 5: mitmproxy.addon.v1.FlowUpdate.request:type_name -> mitmproxy.addon.v1.Request
+	4,  // 6: mitmproxy.addon.v1.FlowUpdate.response:type_name -> mitmproxy.addon.v1.Response
+	5,  // 7: mitmproxy.addon.v1.WebSocketMessageEvent.flow:type_name -> mitmproxy.addon.v1.Flow
+	7,  // 8: mitmproxy.addon.v1.WebSocketMessageEvent.message:type_name -> mitmproxy.addon.v1.WebSocketMessage
+	0,  // 9: mitmproxy.addon.v1.Addon.ClientConnected:input_type -> mitmproxy.addon.v1.ClientConn
+	0,  // 10: mitmproxy.addon.v1.Addon.ClientDisconnected:input_type -> mitmproxy.addon.v1.ClientConn
+	1,  // 11: mitmproxy.addon.v1.Addon.ServerConnected:input_type -> mitmproxy.addon.v1.ServerConn
+	1,  // 12: mitmproxy.addon.v1.Addon.ServerDisconnected:input_type -> mitmproxy.addon.v1.ServerConn
+	1,  // 13: mitmproxy.addon.v1.Addon.TlsEstablishedServer:input_type -> mitmproxy.addon.v1.ServerConn
+	5,  // 14: mitmproxy.addon.v1.Addon.Requestheaders:input_type -> mitmproxy.addon.v1.Flow
+	5,  // 15: mitmproxy.addon.v1.Addon.Request:input_type -> mitmproxy.addon.v1.Flow
+	5,  // 16: mitmproxy.addon.v1.Addon.Responseheaders:input_type -> mitmproxy.addon.v1.Flow
+	5,  // 17: mitmproxy.addon.v1.Addon.Response:input_type -> mitmproxy.addon.v1.Flow
+	5,  // 18: mitmproxy.addon.v1.Addon.WebsocketStart:input_type -> mitmproxy.addon.v1.Flow
+	8,  // 19: mitmproxy.addon.v1.Addon.WebsocketMessage:input_type -> mitmproxy.addon.v1.WebSocketMessageEvent
+	5,  // 20: mitmproxy.addon.v1.Addon.WebsocketEnd:input_type -> mitmproxy.addon.v1.Flow
+	10, // 21: mitmproxy.addon.v1.Addon.ClientConnected:output_type -> google.protobuf.Empty
+	10, // 22: mitmproxy.addon.v1.Addon.ClientDisconnected:output_type -> google.protobuf.Empty
+	10, // 23: mitmproxy.addon.v1.Addon.ServerConnected:output_type -> google.protobuf.Empty
+	10, // 24: mitmproxy.addon.v1.Addon.ServerDisconnected:output_type -> google.protobuf.Empty
+	10, // 25: mitmproxy.addon.v1.Addon.TlsEstablishedServer:output_type -> google.protobuf.Empty
+	6,  // 26: mitmproxy.addon.v1.Addon.Requestheaders:output_type -> mitmproxy.addon.v1.FlowUpdate
+	6,  // 27: mitmproxy.addon.v1.Addon.Request:output_type -> mitmproxy.addon.v1.FlowUpdate
+	6,  // 28: mitmproxy.addon.v1.Addon.Responseheaders:output_type -> mitmproxy.addon.v1.FlowUpdate
+	6,  // 29: mitmproxy.addon.v1.Addon.Response:output_type -> mitmproxy.addon.v1.FlowUpdate
+	10, // 30: mitmproxy.addon.v1.Addon.WebsocketStart:output_type -> google.protobuf.Empty
+	9,  // 31: mitmproxy.addon.v1.Addon.WebsocketMessage:output_type -> mitmproxy.addon.v1.WebSocketMessageUpdate
+	10, // 32: mitmproxy.addon.v1.Addon.WebsocketEnd:output_type -> google.protobuf.Empty
+	21, // [21:33] is the sub-list for method output_type
+	9,  // [9:21] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_addon_proto_init() }
+func file_addon_proto_init() {
+	if File_addon_proto != nil {
+		return
+	}
+	file_addon_proto_msgTypes[9].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_addon_proto_rawDesc), len(file_addon_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_addon_proto_goTypes,
+		DependencyIndexes: file_addon_proto_depIdxs,
+		MessageInfos:      file_addon_proto_msgTypes,
+	}.Build()
+	File_addon_proto = out.File
+	file_addon_proto_goTypes = nil
+	file_addon_proto_depIdxs = nil
+}
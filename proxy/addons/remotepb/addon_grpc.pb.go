@@ -0,0 +1,580 @@
+// The out-of-process addon API of go-mitmproxy.
+//
+// A remote addon is a gRPC server implementing the Addon service, in any language.
+// The proxy calls it through addons.RemoteAddon for every event of the Addon
+// interface it forwards; methods the server leaves unimplemented are not called
+// again for a while, so servers implement only the events they need.
+//
+// Regenerate the Go code with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative addon.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.1
+// - protoc             v3.21.12
+// source: addon.proto
+
+package remotepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Addon_ClientConnected_FullMethodName      = "/mitmproxy.addon.v1.Addon/ClientConnected"
+	Addon_ClientDisconnected_FullMethodName   = "/mitmproxy.addon.v1.Addon/ClientDisconnected"
+	Addon_ServerConnected_FullMethodName      = "/mitmproxy.addon.v1.Addon/ServerConnected"
+	Addon_ServerDisconnected_FullMethodName   = "/mitmproxy.addon.v1.Addon/ServerDisconnected"
+	Addon_TlsEstablishedServer_FullMethodName = "/mitmproxy.addon.v1.Addon/TlsEstablishedServer"
+	Addon_Requestheaders_FullMethodName       = "/mitmproxy.addon.v1.Addon/Requestheaders"
+	Addon_Request_FullMethodName              = "/mitmproxy.addon.v1.Addon/Request"
+	Addon_Responseheaders_FullMethodName      = "/mitmproxy.addon.v1.Addon/Responseheaders"
+	Addon_Response_FullMethodName             = "/mitmproxy.addon.v1.Addon/Response"
+	Addon_WebsocketStart_FullMethodName       = "/mitmproxy.addon.v1.Addon/WebsocketStart"
+	Addon_WebsocketMessage_FullMethodName     = "/mitmproxy.addon.v1.Addon/WebsocketMessage"
+	Addon_WebsocketEnd_FullMethodName         = "/mitmproxy.addon.v1.Addon/WebsocketEnd"
+)
+
+// AddonClient is the client API for Addon service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Addon mirrors the Addon interface of the proxy.
+type AddonClient interface {
+	// A client has connected to the proxy.
+	ClientConnected(ctx context.Context, in *ClientConn, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// A client has disconnected from the proxy.
+	ClientDisconnected(ctx context.Context, in *ClientConn, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// The proxy has connected to a server.
+	ServerConnected(ctx context.Context, in *ServerConn, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// The proxy has disconnected from a server.
+	ServerDisconnected(ctx context.Context, in *ServerConn, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// The TLS handshake with a server has completed.
+	TlsEstablishedServer(ctx context.Context, in *ServerConn, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// The headers of a request have been read; the body has not.
+	Requestheaders(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error)
+	// The full request has been read.
+	Request(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error)
+	// The headers of a response have been read; the body has not.
+	Responseheaders(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error)
+	// The full response has been read.
+	Response(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error)
+	// A WebSocket connection has been established.
+	WebsocketStart(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// A WebSocket message has been received.
+	WebsocketMessage(ctx context.Context, in *WebSocketMessageEvent, opts ...grpc.CallOption) (*WebSocketMessageUpdate, error)
+	// A WebSocket connection has been closed.
+	WebsocketEnd(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type addonClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAddonClient(cc grpc.ClientConnInterface) AddonClient {
+	return &addonClient{cc}
+}
+
+func (c *addonClient) ClientConnected(ctx context.Context, in *ClientConn, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Addon_ClientConnected_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) ClientDisconnected(ctx context.Context, in *ClientConn, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Addon_ClientDisconnected_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) ServerConnected(ctx context.Context, in *ServerConn, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Addon_ServerConnected_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) ServerDisconnected(ctx context.Context, in *ServerConn, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Addon_ServerDisconnected_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) TlsEstablishedServer(ctx context.Context, in *ServerConn, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Addon_TlsEstablishedServer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) Requestheaders(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FlowUpdate)
+	err := c.cc.Invoke(ctx, Addon_Requestheaders_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) Request(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FlowUpdate)
+	err := c.cc.Invoke(ctx, Addon_Request_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) Responseheaders(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FlowUpdate)
+	err := c.cc.Invoke(ctx, Addon_Responseheaders_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) Response(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*FlowUpdate, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FlowUpdate)
+	err := c.cc.Invoke(ctx, Addon_Response_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) WebsocketStart(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Addon_WebsocketStart_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) WebsocketMessage(ctx context.Context, in *WebSocketMessageEvent, opts ...grpc.CallOption) (*WebSocketMessageUpdate, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WebSocketMessageUpdate)
+	err := c.cc.Invoke(ctx, Addon_WebsocketMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *addonClient) WebsocketEnd(ctx context.Context, in *Flow, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Addon_WebsocketEnd_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddonServer is the server API for Addon service.
+// All implementations must embed UnimplementedAddonServer
+// for forward compatibility.
+//
+// Addon mirrors the Addon interface of the proxy.
+type AddonServer interface {
+	// A client has connected to the proxy.
+	ClientConnected(context.Context, *ClientConn) (*emptypb.Empty, error)
+	// A client has disconnected from the proxy.
+	ClientDisconnected(context.Context, *ClientConn) (*emptypb.Empty, error)
+	// The proxy has connected to a server.
+	ServerConnected(context.Context, *ServerConn) (*emptypb.Empty, error)
+	// The proxy has disconnected from a server.
+	ServerDisconnected(context.Context, *ServerConn) (*emptypb.Empty, error)
+	// The TLS handshake with a server has completed.
+	TlsEstablishedServer(context.Context, *ServerConn) (*emptypb.Empty, error)
+	// The headers of a request have been read; the body has not.
+	Requestheaders(context.Context, *Flow) (*FlowUpdate, error)
+	// The full request has been read.
+	Request(context.Context, *Flow) (*FlowUpdate, error)
+	// The headers of a response have been read; the body has not.
+	Responseheaders(context.Context, *Flow) (*FlowUpdate, error)
+	// The full response has been read.
+	Response(context.Context, *Flow) (*FlowUpdate, error)
+	// A WebSocket connection has been established.
+	WebsocketStart(context.Context, *Flow) (*emptypb.Empty, error)
+	// A WebSocket message has been received.
+	WebsocketMessage(context.Context, *WebSocketMessageEvent) (*WebSocketMessageUpdate, error)
+	// A WebSocket connection has been closed.
+	WebsocketEnd(context.Context, *Flow) (*emptypb.Empty, error)
+	mustEmbedUnimplementedAddonServer()
+}
+
+// UnimplementedAddonServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAddonServer struct{}
+
+func (UnimplementedAddonServer) ClientConnected(context.Context, *ClientConn) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method ClientConnected not implemented")
+}
+func (UnimplementedAddonServer) ClientDisconnected(context.Context, *ClientConn) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method ClientDisconnected not implemented")
+}
+func (UnimplementedAddonServer) ServerConnected(context.Context, *ServerConn) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method ServerConnected not implemented")
+}
+func (UnimplementedAddonServer) ServerDisconnected(context.Context, *ServerConn) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method ServerDisconnected not implemented")
+}
+func (UnimplementedAddonServer) TlsEstablishedServer(context.Context, *ServerConn) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method TlsEstablishedServer not implemented")
+}
+func (UnimplementedAddonServer) Requestheaders(context.Context, *Flow) (*FlowUpdate, error) {
+	return nil, status.Error(codes.Unimplemented, "method Requestheaders not implemented")
+}
+func (UnimplementedAddonServer) Request(context.Context, *Flow) (*FlowUpdate, error) {
+	return nil, status.Error(codes.Unimplemented, "method Request not implemented")
+}
+func (UnimplementedAddonServer) Responseheaders(context.Context, *Flow) (*FlowUpdate, error) {
+	return nil, status.Error(codes.Unimplemented, "method Responseheaders not implemented")
+}
+func (UnimplementedAddonServer) Response(context.Context, *Flow) (*FlowUpdate, error) {
+	return nil, status.Error(codes.Unimplemented, "method Response not implemented")
+}
+func (UnimplementedAddonServer) WebsocketStart(context.Context, *Flow) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method WebsocketStart not implemented")
+}
+func (UnimplementedAddonServer) WebsocketMessage(context.Context, *WebSocketMessageEvent) (*WebSocketMessageUpdate, error) {
+	return nil, status.Error(codes.Unimplemented, "method WebsocketMessage not implemented")
+}
+func (UnimplementedAddonServer) WebsocketEnd(context.Context, *Flow) (*emptypb.Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method WebsocketEnd not implemented")
+}
+func (UnimplementedAddonServer) mustEmbedUnimplementedAddonServer() {}
+func (UnimplementedAddonServer) testEmbeddedByValue()               {}
+
+// UnsafeAddonServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AddonServer will
+// result in compilation errors.
+type UnsafeAddonServer interface {
+	mustEmbedUnimplementedAddonServer()
+}
+
+func RegisterAddonServer(s grpc.ServiceRegistrar, srv AddonServer) {
+	// If the following call panics, it indicates UnimplementedAddonServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Addon_ServiceDesc, srv)
+}
+
+func _Addon_ClientConnected_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientConn)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).ClientConnected(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_ClientConnected_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).ClientConnected(ctx, req.(*ClientConn))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_ClientDisconnected_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientConn)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).ClientDisconnected(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_ClientDisconnected_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).ClientDisconnected(ctx, req.(*ClientConn))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_ServerConnected_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerConn)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).ServerConnected(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_ServerConnected_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).ServerConnected(ctx, req.(*ServerConn))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_ServerDisconnected_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerConn)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).ServerDisconnected(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_ServerDisconnected_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).ServerDisconnected(ctx, req.(*ServerConn))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_TlsEstablishedServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerConn)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).TlsEstablishedServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_TlsEstablishedServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).TlsEstablishedServer(ctx, req.(*ServerConn))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_Requestheaders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Flow)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).Requestheaders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_Requestheaders_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).Requestheaders(ctx, req.(*Flow))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_Request_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Flow)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).Request(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_Request_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).Request(ctx, req.(*Flow))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_Responseheaders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Flow)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).Responseheaders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_Responseheaders_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).Responseheaders(ctx, req.(*Flow))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_Response_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Flow)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).Response(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_Response_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).Response(ctx, req.(*Flow))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_WebsocketStart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Flow)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).WebsocketStart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_WebsocketStart_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).WebsocketStart(ctx, req.(*Flow))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_WebsocketMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebSocketMessageEvent)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).WebsocketMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_WebsocketMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).WebsocketMessage(ctx, req.(*WebSocketMessageEvent))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Addon_WebsocketEnd_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Flow)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AddonServer).WebsocketEnd(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Addon_WebsocketEnd_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AddonServer).WebsocketEnd(ctx, req.(*Flow))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Addon_ServiceDesc is the grpc.ServiceDesc for Addon service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Addon_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mitmproxy.addon.v1.Addon",
+	HandlerType: (*AddonServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ClientConnected",
+			Handler:    _Addon_ClientConnected_Handler,
+		},
+		{
+			MethodName: "ClientDisconnected",
+			Handler:    _Addon_ClientDisconnected_Handler,
+		},
+		{
+			MethodName: "ServerConnected",
+			Handler:    _Addon_ServerConnected_Handler,
+		},
+		{
+			MethodName: "ServerDisconnected",
+			Handler:    _Addon_ServerDisconnected_Handler,
+		},
+		{
+			MethodName: "TlsEstablishedServer",
+			Handler:    _Addon_TlsEstablishedServer_Handler,
+		},
+		{
+			MethodName: "Requestheaders",
+			Handler:    _Addon_Requestheaders_Handler,
+		},
+		{
+			MethodName: "Request",
+			Handler:    _Addon_Request_Handler,
+		},
+		{
+			MethodName: "Responseheaders",
+			Handler:    _Addon_Responseheaders_Handler,
+		},
+		{
+			MethodName: "Response",
+			Handler:    _Addon_Response_Handler,
+		},
+		{
+			MethodName: "WebsocketStart",
+			Handler:    _Addon_WebsocketStart_Handler,
+		},
+		{
+			MethodName: "WebsocketMessage",
+			Handler:    _Addon_WebsocketMessage_Handler,
+		},
+		{
+			MethodName: "WebsocketEnd",
+			Handler:    _Addon_WebsocketEnd_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "addon.proto",
+}
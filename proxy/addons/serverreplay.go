@@ -0,0 +1,306 @@
+package addons
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// ServerReplayOptions configure how ServerReplay matches requests to recorded ones.
+// The method, the scheme and host, the path and the query always match, unless
+// ignored here; request bodies are not compared.
+type ServerReplayOptions struct {
+	// IgnoreMethod matches requests with any method.
+	IgnoreMethod bool
+	// IgnoreHost matches requests to any scheme and host.
+	IgnoreHost bool
+	// IgnoreQuery matches requests with any query.
+	IgnoreQuery bool
+	// IgnoreParams are query parameters left out of the comparison, such as cache busters.
+	IgnoreParams []string
+	// Headers are request headers whose values must match too, e.g. Accept or Authorization.
+	Headers []string
+	// Reuse answers every matching request with the first recorded response. Without it
+	// each recorded response is returned once, in recorded order, and requests are
+	// passed upstream once the recorded responses to them are used up.
+	Reuse bool
+	// KillExtra answers requests without a recorded response with a 404 instead of
+	// passing them upstream.
+	KillExtra bool
+}
+
+// ServerReplay answers requests with recorded responses, without contacting the
+// server, like the server replay of mitmproxy. Flows are recorded as mitmproxy flow
+// files (see FlowWriter), JSONL dumps with bodies (see JSONLDumper) or HAR files.
+type ServerReplay struct {
+	proxy.BaseAddon
+	options ServerReplayOptions
+
+	mu        sync.Mutex
+	responses map[string][]*proxy.Response // match key -> recorded responses in order
+}
+
+func NewServerReplay(flows []*proxy.Flow, options ServerReplayOptions) *ServerReplay {
+	adn := &ServerReplay{
+		options:   options,
+		responses: make(map[string][]*proxy.Response),
+	}
+	for _, f := range flows {
+		if f.Request == nil || f.Request.URL == nil || f.Response == nil {
+			continue
+		}
+		key := adn.key(f.Request)
+		adn.responses[key] = append(adn.responses[key], f.Response)
+	}
+	return adn
+}
+
+// NewServerReplayFromFile creates a ServerReplay answering with the responses recorded
+// in the file at filename, a mitmproxy flow file, a JSONL dump or a HAR file.
+func NewServerReplayFromFile(filename string, options ServerReplayOptions) (*ServerReplay, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	flows, err := readReplayFlows(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return NewServerReplay(flows, options), nil
+}
+
+func (adn *ServerReplay) Requestheaders(f *proxy.Flow) {
+	key := adn.key(f.Request)
+
+	adn.mu.Lock()
+	var res *proxy.Response
+	if responses := adn.responses[key]; len(responses) > 0 {
+		res = responses[0]
+		if !adn.options.Reuse {
+			adn.responses[key] = responses[1:]
+		}
+	}
+	adn.mu.Unlock()
+
+	if res != nil {
+		slog.Debug("server replay", "url", f.Request.URL.String())
+		f.Response = &proxy.Response{
+			StatusCode: res.StatusCode,
+			Header:     res.Header.Clone(),
+			Body:       bytes.Clone(res.Body),
+			Trailer:    res.Trailer.Clone(),
+		}
+		return
+	}
+	if adn.options.KillExtra {
+		slog.Info("server replay killed request without recorded response", "url", f.Request.URL.String())
+		f.Response = &proxy.Response{
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			Body:       []byte("no recorded response\n"),
+		}
+	}
+}
+
+// key returns the string identifying the requests answered by the same recorded responses.
+func (adn *ServerReplay) key(req *proxy.Request) string {
+	var b strings.Builder
+	if !adn.options.IgnoreMethod {
+		b.WriteString(req.Method)
+	}
+	b.WriteByte(' ')
+	if !adn.options.IgnoreHost {
+		b.WriteString(req.URL.Scheme + "://" + helper.CanonicalAddr(req.URL))
+	}
+	b.WriteString(req.URL.EscapedPath())
+	if !adn.options.IgnoreQuery {
+		query := req.URL.Query()
+		for _, name := range adn.options.IgnoreParams {
+			query.Del(name)
+		}
+		// Encode sorts the parameters by name
+		b.WriteString("?" + query.Encode())
+	}
+	for _, name := range adn.options.Headers {
+		b.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(req.Header.Values(name), ", "))
+	}
+	return b.String()
+}
+
+// readReplayFlows reads the flows recorded in data, whatever the format. Flows whose
+// response was not recorded have none.
+func readReplayFlows(data []byte) ([]*proxy.Flow, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return ReadFlows(bytes.NewReader(data))
+	}
+	var har harFile
+	if err := json.Unmarshal(trimmed, &har); err == nil && har.Log != nil {
+		return har.flows()
+	}
+	return readJSONLFlows(trimmed)
+}
+
+func readJSONLFlows(data []byte) ([]*proxy.Flow, error) {
+	var flows []*proxy.Flow
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record jsonlFlow
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		u, err := url.Parse(record.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		reqBody, err := record.Request.decode()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		f := proxy.NewFlow()
+		f.Request = &proxy.Request{
+			Method: record.Request.Method,
+			URL:    u,
+			Proto:  record.Request.Proto,
+			Header: record.Request.Header,
+			Body:   reqBody,
+		}
+		if record.Response != nil {
+			body, err := record.Response.decode()
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if body != nil {
+				f.Response = decodedResponse(record.Response.StatusCode, record.Response.Header, body)
+			} else {
+				slog.Warn("response recorded without body", "line", line, "url", record.Request.URL)
+			}
+		}
+		f.Finish()
+		flows = append(flows, f)
+	}
+	return flows, scanner.Err()
+}
+
+// decode returns the recorded body, nil if it was not recorded.
+func (b *jsonlBody) decode() ([]byte, error) {
+	if b.Body == "" {
+		if b.BodySize > 0 {
+			return nil, nil
+		}
+		return []byte{}, nil
+	}
+	if b.BodyEncoding == "base64" {
+		return base64.StdEncoding.DecodeString(b.Body)
+	}
+	return []byte(b.Body), nil
+}
+
+// decodedResponse returns a response with a body recorded decoded, fixing the headers
+// describing the body as it was transferred.
+func decodedResponse(statusCode int, header http.Header, body []byte) *proxy.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &proxy.Response{StatusCode: statusCode, Header: header, Body: body}
+}
+
+// harFile is the part of a HAR file needed to replay its responses.
+type harFile struct {
+	Log *struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	Request struct {
+		Method      string         `json:"method"`
+		URL         string         `json:"url"`
+		HTTPVersion string         `json:"httpVersion"`
+		Headers     []harNameValue `json:"headers"`
+		PostData    *struct {
+			Text string `json:"text"`
+		} `json:"postData"`
+	} `json:"request"`
+	Response struct {
+		Status  int            `json:"status"`
+		Headers []harNameValue `json:"headers"`
+		Content struct {
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func harHeader(fields []harNameValue) http.Header {
+	header := make(http.Header)
+	for _, field := range fields {
+		// HTTP/2 pseudo-headers such as :authority are not headers of the message
+		if strings.HasPrefix(field.Name, ":") {
+			continue
+		}
+		header.Add(field.Name, field.Value)
+	}
+	return header
+}
+
+func (har *harFile) flows() ([]*proxy.Flow, error) {
+	var flows []*proxy.Flow
+	for i, entry := range har.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		f := proxy.NewFlow()
+		f.Request = &proxy.Request{
+			Method: entry.Request.Method,
+			URL:    u,
+			Proto:  entry.Request.HTTPVersion,
+			Header: harHeader(entry.Request.Headers),
+		}
+		if entry.Request.PostData != nil {
+			f.Request.Body = []byte(entry.Request.PostData.Text)
+		}
+		// requests that got no response are recorded with status 0
+		if entry.Response.Status != 0 {
+			body := []byte(entry.Response.Content.Text)
+			switch entry.Response.Content.Encoding {
+			case "":
+			case "base64":
+				if body, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+					return nil, fmt.Errorf("entry %d: %w", i, err)
+				}
+			default:
+				return nil, fmt.Errorf("entry %d: unsupported content encoding %q", i, entry.Response.Content.Encoding)
+			}
+			f.Response = decodedResponse(entry.Response.Status, harHeader(entry.Response.Headers), body)
+		}
+		f.Finish()
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
@@ -0,0 +1,192 @@
+package addons_test
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newReplayFlow(c *qt.C, method, rawURL string) *proxy.Flow {
+	f := newJSONLFlow(c, nil, nil)
+	u, err := url.Parse(rawURL)
+	c.Assert(err, qt.IsNil)
+	f.Request.Method = method
+	f.Request.URL = u
+	f.Response = nil
+	return f
+}
+
+// replay runs the request of a new flow through the addon and returns the response
+// body it was answered with, or "upstream" if it was passed on.
+func replay(c *qt.C, adn *addons.ServerReplay, method, rawURL string) string {
+	f := newReplayFlow(c, method, rawURL)
+	adn.Requestheaders(f)
+	if f.Response == nil {
+		return "upstream"
+	}
+	return string(f.Response.Body)
+}
+
+func recordedFlow(c *qt.C, method, rawURL, body string) *proxy.Flow {
+	f := newReplayFlow(c, method, rawURL)
+	f.Response = &proxy.Response{StatusCode: 200, Body: []byte(body)}
+	return f
+}
+
+func TestServerReplay(t *testing.T) {
+	c := qt.New(t)
+	flows := []*proxy.Flow{
+		recordedFlow(c, "GET", "https://example.com/a?x=1&y=2", "first"),
+		recordedFlow(c, "GET", "https://example.com/a?x=1&y=2", "second"),
+		recordedFlow(c, "POST", "https://example.com/a?x=1&y=2", "post"),
+		newReplayFlow(c, "GET", "https://example.com/no-response"),
+	}
+	adn := addons.NewServerReplay(flows, addons.ServerReplayOptions{})
+
+	// query parameters match in any order, recorded responses are used in order
+	c.Assert(replay(c, adn, "GET", "https://example.com:443/a?y=2&x=1"), qt.Equals, "first")
+	c.Assert(replay(c, adn, "GET", "https://example.com/a?x=1&y=2"), qt.Equals, "second")
+	c.Assert(replay(c, adn, "GET", "https://example.com/a?x=1&y=2"), qt.Equals, "upstream")
+	c.Assert(replay(c, adn, "POST", "https://example.com/a?x=1&y=2"), qt.Equals, "post")
+	c.Assert(replay(c, adn, "GET", "http://example.com/a?x=1&y=2"), qt.Equals, "upstream")
+	c.Assert(replay(c, adn, "GET", "https://example.com/no-response"), qt.Equals, "upstream")
+}
+
+func TestServerReplayOptions(t *testing.T) {
+	c := qt.New(t)
+	recorded := recordedFlow(c, "GET", "https://example.com/a?x=1&t=123", "recorded")
+	recorded.Request.Header.Set("Accept", "application/json")
+
+	adn := addons.NewServerReplay([]*proxy.Flow{recorded}, addons.ServerReplayOptions{
+		IgnoreParams: []string{"t"},
+		Headers:      []string{"accept"},
+		Reuse:        true,
+		KillExtra:    true,
+	})
+	f := newReplayFlow(c, "GET", "https://example.com/a?t=456&x=1")
+	f.Request.Header.Set("Accept", "application/json")
+	adn.Requestheaders(f)
+	c.Assert(string(f.Response.Body), qt.Equals, "recorded")
+	// the recorded response is not handed out itself
+	f.Response.Body[0] = 'R'
+
+	f = newReplayFlow(c, "GET", "https://example.com/a?t=789&x=1")
+	f.Request.Header.Set("Accept", "application/json")
+	adn.Requestheaders(f)
+	c.Assert(string(f.Response.Body), qt.Equals, "recorded")
+
+	f = newReplayFlow(c, "GET", "https://example.com/a?x=1")
+	f.Request.Header.Set("Accept", "text/html")
+	adn.Requestheaders(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 404)
+
+	ignoring := addons.NewServerReplay([]*proxy.Flow{recorded}, addons.ServerReplayOptions{
+		IgnoreMethod: true,
+		IgnoreHost:   true,
+		IgnoreQuery:  true,
+	})
+	c.Assert(replay(c, ignoring, "HEAD", "http://other.com/a?z=1"), qt.Equals, "recorded")
+}
+
+func TestServerReplayFromFlowFile(t *testing.T) {
+	c := qt.New(t)
+	recorded := recordedFlow(c, "GET", "https://example.com/a", "from flow file")
+	recorded.Response.Header = map[string][]string{"Content-Type": {"text/plain"}}
+	var buf bytes.Buffer
+	c.Assert(addons.WriteFlow(&buf, recorded), qt.IsNil)
+	filename := filepath.Join(c.TempDir(), "flows.flow")
+	c.Assert(os.WriteFile(filename, buf.Bytes(), 0o644), qt.IsNil)
+
+	adn, err := addons.NewServerReplayFromFile(filename, addons.ServerReplayOptions{})
+	c.Assert(err, qt.IsNil)
+	f := newReplayFlow(c, "GET", "https://example.com/a")
+	adn.Requestheaders(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 200)
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "text/plain")
+	c.Assert(string(f.Response.Body), qt.Equals, "from flow file")
+}
+
+func TestServerReplayFromJSONL(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "flows.jsonl")
+	c.Assert(os.WriteFile(filename, []byte(`{"request":{"method":"GET","url":"https://example.com/a","header":{}},"response":{"statusCode":201,"header":{"Content-Encoding":["gzip"],"Content-Length":["31"]},"bodySize":31,"body":"decoded"}}
+
+{"request":{"method":"GET","url":"https://example.com/bin","header":{}},"response":{"statusCode":200,"header":{},"bodySize":2,"body":"AP8=","bodyEncoding":"base64"}}
+{"request":{"method":"GET","url":"https://example.com/no-body","header":{}},"response":{"statusCode":200,"header":{},"bodySize":10}}
+{"request":{"method":"GET","url":"https://example.com/failed","header":{}}}
+`), 0o644), qt.IsNil)
+
+	adn, err := addons.NewServerReplayFromFile(filename, addons.ServerReplayOptions{})
+	c.Assert(err, qt.IsNil)
+	f := newReplayFlow(c, "GET", "https://example.com/a")
+	adn.Requestheaders(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 201)
+	c.Assert(string(f.Response.Body), qt.Equals, "decoded")
+	// JSONL dumps record bodies decoded
+	c.Assert(f.Response.Header.Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(f.Response.Header.Get("Content-Length"), qt.Equals, "7")
+
+	c.Assert(replay(c, adn, "GET", "https://example.com/bin"), qt.Equals, "\x00\xff")
+	c.Assert(replay(c, adn, "GET", "https://example.com/no-body"), qt.Equals, "upstream")
+	c.Assert(replay(c, adn, "GET", "https://example.com/failed"), qt.Equals, "upstream")
+}
+
+func TestServerReplayFromHAR(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "flows.har")
+	c.Assert(os.WriteFile(filename, []byte(`{
+  "log": {
+    "version": "1.2",
+    "entries": [
+      {
+        "request": {"method": "GET", "url": "https://example.com/a?x=1", "httpVersion": "HTTP/2.0",
+                    "headers": [{"name": ":authority", "value": "example.com"}]},
+        "response": {"status": 200,
+                     "headers": [{"name": ":status", "value": "200"}, {"name": "content-type", "value": "text/html"},
+                                 {"name": "content-encoding", "value": "br"}],
+                     "content": {"text": "PGI+aGk8L2I+", "encoding": "base64"}}
+      },
+      {
+        "request": {"method": "GET", "url": "https://example.com/blocked", "headers": []},
+        "response": {"status": 0, "headers": [], "content": {}}
+      }
+    ]
+  }
+}`), 0o644), qt.IsNil)
+
+	adn, err := addons.NewServerReplayFromFile(filename, addons.ServerReplayOptions{})
+	c.Assert(err, qt.IsNil)
+	f := newReplayFlow(c, "GET", "https://example.com/a?x=1")
+	adn.Requestheaders(f)
+	c.Assert(string(f.Response.Body), qt.Equals, "<b>hi</b>")
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "text/html")
+	c.Assert(f.Response.Header.Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(f.Response.Header.Get(":status"), qt.Equals, "")
+	c.Assert(f.Response.Header.Get("Content-Length"), qt.Equals, "9")
+	c.Assert(replay(c, adn, "GET", "https://example.com/blocked"), qt.Equals, "upstream")
+}
+
+func TestServerReplayFromFileErrors(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+
+	_, err := addons.NewServerReplayFromFile(filepath.Join(dir, "missing.har"), addons.ServerReplayOptions{})
+	c.Assert(err, qt.ErrorMatches, ".*no such file or directory")
+
+	for content, want := range map[string]string{
+		`{"request":{"url":"https://example.com/"},"response":{"statusCode":200}}` + "\n{":                                                                ".*line 2: .*",
+		`{"log":{"entries":[{"request":{"url":"https://example.com/"},"response":{"status":200,"content":{"text":"x","encoding":"quoted-printable"}}}]}}`: `.*entry 0: unsupported content encoding "quoted-printable"`,
+	} {
+		filename := filepath.Join(dir, "flows")
+		c.Assert(os.WriteFile(filename, []byte(content), 0o644), qt.IsNil)
+		_, err := addons.NewServerReplayFromFile(filename, addons.ServerReplayOptions{})
+		c.Assert(err, qt.ErrorMatches, want)
+	}
+}
@@ -0,0 +1,453 @@
+package addons
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/syntax"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+var starlarkFileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// StarlarkScript runs the request(flow) and response(flow) functions of a Starlark
+// script (https://github.com/google/starlark-go) in the Request and Response events,
+// so the behavior of the proxy can change without recompiling it. Scripts are Reloadable:
+// added to a ConfigWatcher, they are loaded again when their file changes, and a script
+// that fails to load leaves the previous one running.
+// Streamed flows are not passed to scripts.
+//
+// In scripts, flow.request has the settable fields method, url, path and body and the
+// read-only fields scheme and host; flow.response has status_code and body. Bodies are
+// decoded, setting one drops the Content-Encoding. Both have headers, indexed by name
+// and with the methods get(name, default=None), values(name), add(name, value) and
+// remove(name). Assigning Response(status_code=200, headers={}, body="") to
+// flow.response in request answers the request without contacting the server. The
+// json module and print, which logs, are predeclared.
+type StarlarkScript struct {
+	proxy.BaseAddon
+	filename string
+
+	mu      sync.RWMutex // guards globals during reloads
+	globals starlark.StringDict
+}
+
+func NewStarlarkScriptFromFile(filename string) (*StarlarkScript, error) {
+	globals, err := loadStarlarkScript(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &StarlarkScript{filename: filename, globals: globals}, nil
+}
+
+// ConfigFile returns the script file.
+func (adn *StarlarkScript) ConfigFile() string {
+	return adn.filename
+}
+
+// Reload loads the script again, keeping the current one if it fails to load.
+func (adn *StarlarkScript) Reload() error {
+	globals, err := loadStarlarkScript(adn.filename)
+	if err != nil {
+		return err
+	}
+	adn.mu.Lock()
+	adn.globals = globals
+	adn.mu.Unlock()
+	return nil
+}
+
+// ValidateStarlarkScriptFile loads a Starlark script and checks it runs without
+// creating the addon.
+func ValidateStarlarkScriptFile(filename string) error {
+	_, err := loadStarlarkScript(filename)
+	return err
+}
+
+func loadStarlarkScript(filename string) (starlark.StringDict, error) {
+	thread := newStarlarkThread(filename)
+	globals, err := starlark.ExecFileOptions(starlarkFileOptions, thread, filename, nil, starlarkPredeclared)
+	if err != nil {
+		return nil, starlarkError(err)
+	}
+	for _, name := range []string{"request", "response"} {
+		if fn, ok := globals[name]; ok {
+			if _, ok := fn.(starlark.Callable); !ok {
+				return nil, fmt.Errorf("%s is a %s, not a function", name, fn.Type())
+			}
+		}
+	}
+	globals.Freeze()
+	return globals, nil
+}
+
+func (adn *StarlarkScript) Request(f *proxy.Flow) {
+	adn.call("request", f)
+}
+
+func (adn *StarlarkScript) Response(f *proxy.Flow) {
+	adn.call("response", f)
+}
+
+func (adn *StarlarkScript) call(name string, f *proxy.Flow) {
+	adn.mu.RLock()
+	fn := adn.globals[name]
+	adn.mu.RUnlock()
+	if fn == nil {
+		return
+	}
+	thread := newStarlarkThread(adn.filename)
+	if _, err := starlark.Call(thread, fn, starlark.Tuple{&starlarkFlow{f: f}}, nil); err != nil {
+		slog.Error("starlark script error", "file", adn.filename, "function", name, "url", f.Request.URL.String(), "error", starlarkError(err))
+	}
+}
+
+func newStarlarkThread(filename string) *starlark.Thread {
+	return &starlark.Thread{
+		Name: filename,
+		Print: func(_ *starlark.Thread, msg string) {
+			slog.Info("starlark script", "file", filename, "msg", msg)
+		},
+	}
+}
+
+// starlarkError adds the Starlark backtrace to evaluation errors.
+func starlarkError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+var starlarkPredeclared = starlark.StringDict{
+	"json":     starlarkjson.Module,
+	"Response": starlark.NewBuiltin("Response", starlarkNewResponse),
+}
+
+func starlarkNewResponse(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	statusCode := http.StatusOK
+	var headers *starlark.Dict
+	var body string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "status_code?", &statusCode, "headers?", &headers, "body?", &body); err != nil {
+		return nil, err
+	}
+	res := &proxy.Response{
+		StatusCode: statusCode,
+		Header:     make(http.Header),
+		Body:       []byte(body),
+	}
+	if headers != nil {
+		for _, item := range headers.Items() {
+			name, ok1 := starlark.AsString(item[0])
+			value, ok2 := starlark.AsString(item[1])
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("%s: headers must map strings to strings", fn.Name())
+			}
+			res.Header.Set(name, value)
+		}
+	}
+	return &starlarkResponse{res: res}, nil
+}
+
+// starlarkFlow is the flow argument of script functions.
+type starlarkFlow struct {
+	f *proxy.Flow
+}
+
+func (sf *starlarkFlow) String() string     { return "<flow " + sf.f.ID.String() + ">" }
+func (*starlarkFlow) Type() string          { return "flow" }
+func (*starlarkFlow) Freeze()               {}
+func (*starlarkFlow) Truth() starlark.Bool  { return starlark.True }
+func (*starlarkFlow) Hash() (uint32, error) { return 0, errors.New("unhashable type: flow") }
+func (*starlarkFlow) AttrNames() []string   { return []string{"id", "request", "response"} }
+
+func (sf *starlarkFlow) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "id":
+		return starlark.String(sf.f.ID.String()), nil
+	case "request":
+		return &starlarkRequest{req: sf.f.Request}, nil
+	case "response":
+		if sf.f.Response == nil {
+			return starlark.None, nil
+		}
+		return &starlarkResponse{res: sf.f.Response}, nil
+	}
+	return nil, nil
+}
+
+func (sf *starlarkFlow) SetField(name string, val starlark.Value) error {
+	if name != "response" {
+		return starlark.NoSuchAttrError(fmt.Sprintf("flow has no settable field .%s", name))
+	}
+	switch v := val.(type) {
+	case starlark.NoneType:
+		sf.f.Response = nil
+	case *starlarkResponse:
+		sf.f.Response = v.res
+	default:
+		return fmt.Errorf("flow.response must be a Response or None, not %s", val.Type())
+	}
+	return nil
+}
+
+// starlarkRequest is flow.request.
+type starlarkRequest struct {
+	req *proxy.Request
+}
+
+func (sr *starlarkRequest) String() string {
+	return "<request " + sr.req.Method + " " + sr.req.URL.String() + ">"
+}
+func (*starlarkRequest) Type() string          { return "request" }
+func (*starlarkRequest) Freeze()               {}
+func (*starlarkRequest) Truth() starlark.Bool  { return starlark.True }
+func (*starlarkRequest) Hash() (uint32, error) { return 0, errors.New("unhashable type: request") }
+func (*starlarkRequest) AttrNames() []string {
+	return []string{"body", "headers", "host", "method", "path", "scheme", "url"}
+}
+
+func (sr *starlarkRequest) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "method":
+		return starlark.String(sr.req.Method), nil
+	case "url":
+		return starlark.String(sr.req.URL.String()), nil
+	case "scheme":
+		return starlark.String(sr.req.URL.Scheme), nil
+	case "host":
+		return starlark.String(sr.req.URL.Host), nil
+	case "path":
+		return starlark.String(sr.req.URL.Path), nil
+	case "headers":
+		return newStarlarkHeaders(&sr.req.Header), nil
+	case "body":
+		body, err := sr.req.DecodedBody()
+		if err != nil {
+			body = sr.req.Body
+		}
+		return starlark.String(body), nil
+	}
+	return nil, nil
+}
+
+func (sr *starlarkRequest) SetField(name string, val starlark.Value) error {
+	s, ok := starlark.AsString(val)
+	if !ok {
+		return fmt.Errorf("request.%s must be a string, not %s", name, val.Type())
+	}
+	switch name {
+	case "method":
+		sr.req.Method = s
+	case "url":
+		u, err := url.Parse(s)
+		if err != nil {
+			return err
+		}
+		sr.req.URL = u
+	case "path":
+		u := *sr.req.URL
+		u.Path, u.RawPath = s, ""
+		sr.req.URL = &u
+	case "body":
+		sr.req.Body = []byte(s)
+		setDecodedBodyHeaders(&sr.req.Header, len(s))
+	default:
+		return starlark.NoSuchAttrError(fmt.Sprintf("request has no settable field .%s", name))
+	}
+	return nil
+}
+
+// starlarkResponse is flow.response.
+type starlarkResponse struct {
+	res *proxy.Response
+}
+
+func (sr *starlarkResponse) String() string {
+	return "<response " + strconv.Itoa(sr.res.StatusCode) + ">"
+}
+func (*starlarkResponse) Type() string          { return "response" }
+func (*starlarkResponse) Freeze()               {}
+func (*starlarkResponse) Truth() starlark.Bool  { return starlark.True }
+func (*starlarkResponse) Hash() (uint32, error) { return 0, errors.New("unhashable type: response") }
+func (*starlarkResponse) AttrNames() []string   { return []string{"body", "headers", "status_code"} }
+
+func (sr *starlarkResponse) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "status_code":
+		return starlark.MakeInt(sr.res.StatusCode), nil
+	case "headers":
+		return newStarlarkHeaders(&sr.res.Header), nil
+	case "body":
+		body, err := sr.res.DecodedBody()
+		if err != nil {
+			body = sr.res.Body
+		}
+		return starlark.String(body), nil
+	}
+	return nil, nil
+}
+
+func (sr *starlarkResponse) SetField(name string, val starlark.Value) error {
+	switch name {
+	case "status_code":
+		var statusCode int
+		if err := starlark.AsInt(val, &statusCode); err != nil {
+			return fmt.Errorf("response.status_code: %w", err)
+		}
+		sr.res.StatusCode = statusCode
+	case "body":
+		s, ok := starlark.AsString(val)
+		if !ok {
+			return fmt.Errorf("response.body must be a string, not %s", val.Type())
+		}
+		sr.res.Body = []byte(s)
+		setDecodedBodyHeaders(&sr.res.Header, len(s))
+	default:
+		return starlark.NoSuchAttrError(fmt.Sprintf("response has no settable field .%s", name))
+	}
+	return nil
+}
+
+// setDecodedBodyHeaders fixes the headers describing a body replaced with a decoded one.
+func setDecodedBodyHeaders(header *http.Header, length int) {
+	if *header == nil {
+		*header = make(http.Header)
+	}
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(length))
+}
+
+// starlarkHeaders is the headers field of requests and responses. It points to the
+// header field so that headers can be added to messages without any.
+type starlarkHeaders struct {
+	header *http.Header
+}
+
+func newStarlarkHeaders(header *http.Header) *starlarkHeaders {
+	if *header == nil {
+		*header = make(http.Header)
+	}
+	return &starlarkHeaders{header: header}
+}
+
+func (*starlarkHeaders) String() string        { return "<headers>" }
+func (*starlarkHeaders) Type() string          { return "headers" }
+func (*starlarkHeaders) Freeze()               {}
+func (*starlarkHeaders) Truth() starlark.Bool  { return starlark.True }
+func (*starlarkHeaders) Hash() (uint32, error) { return 0, errors.New("unhashable type: headers") }
+func (*starlarkHeaders) AttrNames() []string {
+	return []string{"add", "get", "keys", "remove", "values"}
+}
+
+func (sh *starlarkHeaders) Get(k starlark.Value) (starlark.Value, bool, error) {
+	name, ok := starlark.AsString(k)
+	if !ok {
+		return nil, false, fmt.Errorf("header names are strings, not %s", k.Type())
+	}
+	values := sh.header.Values(name)
+	if len(values) == 0 {
+		return nil, false, nil
+	}
+	return starlark.String(values[0]), true, nil
+}
+
+func (sh *starlarkHeaders) SetKey(k, v starlark.Value) error {
+	name, ok1 := starlark.AsString(k)
+	value, ok2 := starlark.AsString(v)
+	if !ok1 || !ok2 {
+		return errors.New("headers map strings to strings")
+	}
+	sh.header.Set(name, value)
+	return nil
+}
+
+func (sh *starlarkHeaders) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "get":
+		return starlark.NewBuiltin("get", sh.get).BindReceiver(sh), nil
+	case "values":
+		return starlark.NewBuiltin("values", sh.values).BindReceiver(sh), nil
+	case "keys":
+		return starlark.NewBuiltin("keys", sh.keys).BindReceiver(sh), nil
+	case "add":
+		return starlark.NewBuiltin("add", sh.add).BindReceiver(sh), nil
+	case "remove":
+		return starlark.NewBuiltin("remove", sh.remove).BindReceiver(sh), nil
+	}
+	return nil, nil
+}
+
+func (sh *starlarkHeaders) get(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var def starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "default?", &def); err != nil {
+		return nil, err
+	}
+	if values := sh.header.Values(name); len(values) > 0 {
+		return starlark.String(values[0]), nil
+	}
+	return def, nil
+}
+
+func (sh *starlarkHeaders) values(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	var values []starlark.Value
+	for _, value := range sh.header.Values(name) {
+		values = append(values, starlark.String(value))
+	}
+	return starlark.NewList(values), nil
+}
+
+func (sh *starlarkHeaders) keys(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range *sh.header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	keys := make([]starlark.Value, len(names))
+	for i, name := range names {
+		keys[i] = starlark.String(name)
+	}
+	return starlark.NewList(keys), nil
+}
+
+func (sh *starlarkHeaders) add(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, value string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "value", &value); err != nil {
+		return nil, err
+	}
+	sh.header.Add(name, value)
+	return starlark.None, nil
+}
+
+func (sh *starlarkHeaders) remove(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	sh.header.Del(name)
+	return starlark.None, nil
+}
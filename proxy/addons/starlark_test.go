@@ -0,0 +1,128 @@
+package addons_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func writeStarlarkScript(c *qt.C, filename, src string) {
+	c.Assert(os.WriteFile(filename, []byte(src), 0o600), qt.IsNil)
+}
+
+func TestStarlarkScript(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "script.star")
+	writeStarlarkScript(c, filename, `
+def request(flow):
+    req = flow.request
+    if req.path == "/blocked":
+        flow.response = Response(status_code = 403, headers = {"Content-Type": "text/plain"}, body = "blocked " + req.method)
+        return
+    req.headers["X-Script"] = req.host
+    req.headers.add("X-Script", "twice")
+    req.headers.remove("Content-Type")
+    req.body = req.body.upper()
+
+def response(flow):
+    res = flow.response
+    data = json.decode(res.body)
+    data["seen"] = flow.request.headers.values("X-Script")
+    res.body = json.encode(data)
+    res.status_code = 201
+    res.headers["X-Had-Type"] = str("Content-Type" in res.headers)
+`)
+	adn, err := addons.NewStarlarkScriptFromFile(filename)
+	c.Assert(err, qt.IsNil)
+
+	f := newJSONLFlow(c, []byte("hello"), []byte(`{"ok":true}`))
+	adn.Request(f)
+	c.Assert(f.Request.Header.Values("X-Script"), qt.DeepEquals, []string{"example.com", "twice"})
+	c.Assert(f.Request.Header.Get("Content-Type"), qt.Equals, "")
+	c.Assert(string(f.Request.Body), qt.Equals, "HELLO")
+	c.Assert(f.Request.Header.Get("Content-Length"), qt.Equals, "5")
+
+	adn.Response(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 201)
+	c.Assert(string(f.Response.Body), qt.Equals, `{"ok":true,"seen":["example.com","twice"]}`)
+	c.Assert(f.Response.Header.Get("X-Had-Type"), qt.Equals, "True")
+
+	blocked := newJSONLFlow(c, nil, nil)
+	blocked.Request.URL.Path = "/blocked"
+	blocked.Response = nil
+	adn.Request(blocked)
+	c.Assert(blocked.Response, qt.IsNotNil)
+	c.Assert(blocked.Response.StatusCode, qt.Equals, 403)
+	c.Assert(blocked.Response.Header.Get("Content-Type"), qt.Equals, "text/plain")
+	c.Assert(string(blocked.Response.Body), qt.Equals, "blocked POST")
+}
+
+func TestStarlarkScriptReload(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "script.star")
+	writeStarlarkScript(c, filename, `
+def request(flow):
+    flow.request.headers["X-Version"] = "1"
+`)
+	adn, err := addons.NewStarlarkScriptFromFile(filename)
+	c.Assert(err, qt.IsNil)
+
+	f := newJSONLFlow(c, nil, nil)
+	adn.Request(f)
+	c.Assert(f.Request.Header.Get("X-Version"), qt.Equals, "1")
+
+	w := addons.NewConfigWatcher(time.Hour)
+	w.Add(adn)
+	writeStarlarkScript(c, filename, `
+def request(flow):
+    flow.request.headers["X-Version"] = "2"
+`)
+	modTime := time.Now().Add(time.Minute)
+	c.Assert(os.Chtimes(filename, modTime, modTime), qt.IsNil)
+	w.Check()
+	f = newJSONLFlow(c, nil, nil)
+	adn.Request(f)
+	c.Assert(f.Request.Header.Get("X-Version"), qt.Equals, "2")
+
+	// a broken script leaves the previous one running
+	writeStarlarkScript(c, filename, "def request(flow):\n    undefined()\n")
+	modTime = modTime.Add(time.Minute)
+	c.Assert(os.Chtimes(filename, modTime, modTime), qt.IsNil)
+	w.Check()
+	f = newJSONLFlow(c, nil, nil)
+	adn.Request(f)
+	c.Assert(f.Request.Header.Get("X-Version"), qt.Equals, "2")
+}
+
+func TestStarlarkScriptErrors(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+
+	_, err := addons.NewStarlarkScriptFromFile(filepath.Join(dir, "missing.star"))
+	c.Assert(err, qt.IsNotNil)
+
+	filename := filepath.Join(dir, "script.star")
+	writeStarlarkScript(c, filename, "request = 1\n")
+	c.Assert(addons.ValidateStarlarkScriptFile(filename), qt.ErrorMatches, "request is a int, not a function")
+
+	writeStarlarkScript(c, filename, "fail(\"broken\")\n")
+	c.Assert(addons.ValidateStarlarkScriptFile(filename), qt.ErrorMatches, `(?s).*broken.*`)
+
+	// runtime errors are logged, the changes made before them are kept
+	writeStarlarkScript(c, filename, `
+def request(flow):
+    flow.request.method = "PUT"
+    flow.request.headers["X"] = 1
+`)
+	adn, err := addons.NewStarlarkScriptFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	f := newJSONLFlow(c, nil, nil)
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "PUT")
+	c.Assert(f.Request.Header.Get("X"), qt.Equals, "")
+}
@@ -0,0 +1,119 @@
+package addons
+
+import (
+	"log/slog"
+	"net"
+	"net/http/cookiejar"
+	"sync"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// StickyCookiesOptions configure StickyCookies.
+type StickyCookiesOptions struct {
+	// PerConnection keeps a cookie jar per client connection, dropped when the client
+	// disconnects, instead of one per client IP address.
+	PerConnection bool
+	// Hosts limits the jars to the cookies of matching hosts, matched like ignore_hosts.
+	// No hosts match every host.
+	Hosts []string
+}
+
+// StickyCookies keeps the cookies servers set in a cookie jar per client and adds them
+// to the later requests of the client that do not send them, so that sessions survive
+// replays, rewritten hosts and clients that drop cookies. Cookies sent by the client
+// take precedence over the ones of the jar.
+type StickyCookies struct {
+	proxy.BaseAddon
+	options StickyCookiesOptions
+
+	mu   sync.Mutex
+	jars map[string]*cookiejar.Jar // client key -> jar
+}
+
+func NewStickyCookies(options StickyCookiesOptions) *StickyCookies {
+	return &StickyCookies{
+		options: options,
+		jars:    make(map[string]*cookiejar.Jar),
+	}
+}
+
+func (adn *StickyCookies) Requestheaders(f *proxy.Flow) {
+	jar := adn.jar(f, false)
+	if jar == nil {
+		return
+	}
+	cookies := jar.Cookies(f.Request.URL)
+	if len(cookies) == 0 {
+		return
+	}
+	sent := make(map[string]bool)
+	for _, cookie := range f.Request.Cookies() {
+		sent[cookie.Name] = true
+	}
+	added := 0
+	for _, cookie := range cookies {
+		if !sent[cookie.Name] {
+			f.Request.SetCookie(cookie)
+			added++
+		}
+	}
+	if added == 0 {
+		return
+	}
+	slog.Debug("sticky cookies added", "url", f.Request.URL.String(), "count", added)
+}
+
+func (adn *StickyCookies) Responseheaders(f *proxy.Flow) {
+	if f.Response == nil || len(f.Response.Header.Values("Set-Cookie")) == 0 {
+		return
+	}
+	jar := adn.jar(f, true)
+	if jar == nil {
+		return
+	}
+	jar.SetCookies(f.Request.URL, f.Response.Cookies())
+}
+
+func (adn *StickyCookies) ClientDisconnected(client *proxy.ClientConn) {
+	if !adn.options.PerConnection {
+		return
+	}
+	adn.mu.Lock()
+	delete(adn.jars, client.ID.String())
+	adn.mu.Unlock()
+}
+
+// jar returns the cookie jar of the client of f, nil if its host is not handled or,
+// without create, if the client has none yet.
+func (adn *StickyCookies) jar(f *proxy.Flow, create bool) *cookiejar.Jar {
+	if len(adn.options.Hosts) > 0 && !helper.MatchHost(helper.CanonicalAddr(f.Request.URL), adn.options.Hosts) {
+		return nil
+	}
+	key := adn.clientKey(f)
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	jar := adn.jars[key]
+	if jar == nil && create {
+		// without a public suffix list, domain cookies are accepted for any parent domain
+		jar, _ = cookiejar.New(nil)
+		adn.jars[key] = jar
+	}
+	return jar
+}
+
+func (adn *StickyCookies) clientKey(f *proxy.Flow) string {
+	if f.ConnContext == nil || f.ConnContext.ClientConn == nil {
+		return ""
+	}
+	client := f.ConnContext.ClientConn
+	if adn.options.PerConnection || client.Conn == nil {
+		return client.ID.String()
+	}
+	addr := client.Conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
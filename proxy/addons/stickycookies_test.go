@@ -0,0 +1,73 @@
+package addons_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func newStickyClient(remoteAddr string) *proxy.ClientConn {
+	return &proxy.ClientConn{
+		ID:   uuid.NewV4(),
+		Conn: &stubConn{localAddr: stubAddr("127.0.0.1:9080"), remoteAddr: stubAddr(remoteAddr)},
+	}
+}
+
+// stickyRequest sends a request of client through the addon, answered with the
+// Set-Cookie headers, and returns the Cookie header sent upstream.
+func stickyRequest(c *qt.C, adn *addons.StickyCookies, client *proxy.ClientConn, rawURL, cookie string, setCookies ...string) string {
+	u, err := url.Parse(rawURL)
+	c.Assert(err, qt.IsNil)
+	f := proxy.NewFlow()
+	f.ConnContext = &proxy.ConnContext{ClientConn: client}
+	f.Request = &proxy.Request{Method: "GET", URL: u, Header: make(http.Header)}
+	if cookie != "" {
+		f.Request.Header.Set("Cookie", cookie)
+	}
+	adn.Requestheaders(f)
+	f.Response = &proxy.Response{StatusCode: 200, Header: http.Header{"Set-Cookie": setCookies}}
+	adn.Responseheaders(f)
+	return f.Request.Header.Get("Cookie")
+}
+
+func TestStickyCookies(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewStickyCookies(addons.StickyCookiesOptions{})
+	client := newStickyClient("192.168.0.10:6000")
+
+	c.Assert(stickyRequest(c, adn, client, "https://example.com/login", "", "session=s1; Path=/", "theme=dark; Path=/"), qt.Equals, "")
+	// the same client IP on another connection gets the cookies
+	c.Assert(stickyRequest(c, adn, newStickyClient("192.168.0.10:6001"), "https://example.com/account", ""), qt.Equals, "session=s1; theme=dark")
+	// cookies sent by the client win
+	c.Assert(stickyRequest(c, adn, client, "https://example.com/", "theme=light"), qt.Equals, "theme=light; session=s1")
+	// cookies are kept per host and per client
+	c.Assert(stickyRequest(c, adn, client, "https://other.com/", ""), qt.Equals, "")
+	c.Assert(stickyRequest(c, adn, newStickyClient("192.168.0.11:6000"), "https://example.com/", ""), qt.Equals, "")
+	// servers can still remove cookies
+	stickyRequest(c, adn, client, "https://example.com/logout", "", "session=; Path=/; Max-Age=0")
+	c.Assert(stickyRequest(c, adn, client, "https://example.com/", ""), qt.Equals, "theme=dark")
+}
+
+func TestStickyCookiesPerConnection(t *testing.T) {
+	c := qt.New(t)
+	adn := addons.NewStickyCookies(addons.StickyCookiesOptions{
+		PerConnection: true,
+		Hosts:         []string{"*.example.com"},
+	})
+	client := newStickyClient("192.168.0.10:6000")
+
+	stickyRequest(c, adn, client, "https://api.example.com/", "", "session=s1")
+	stickyRequest(c, adn, client, "https://other.com/", "", "other=1")
+	c.Assert(stickyRequest(c, adn, client, "https://api.example.com/", ""), qt.Equals, "session=s1")
+	c.Assert(stickyRequest(c, adn, client, "https://other.com/", ""), qt.Equals, "")
+	c.Assert(stickyRequest(c, adn, newStickyClient("192.168.0.10:6001"), "https://api.example.com/", ""), qt.Equals, "")
+
+	adn.ClientDisconnected(client)
+	c.Assert(stickyRequest(c, adn, client, "https://api.example.com/", ""), qt.Equals, "")
+}
@@ -0,0 +1,197 @@
+package addons
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// ThrottleRule shapes the flows to matching hosts, simulating a slow network.
+type ThrottleRule struct {
+	// Hosts are matched like ignore_hosts, e.g. "*.example.com" or "api.example.com:443".
+	// No hosts match every host.
+	Hosts []string
+	// LatencyMs is added before the request is sent upstream and again before the
+	// response is returned, so a flow takes at least twice as long.
+	LatencyMs int
+	// JitterMs adds up to as many random milliseconds to each latency.
+	JitterMs int
+	// UploadBytesPerSecond caps the rate request bodies are sent upstream at, per flow.
+	// Zero is unlimited.
+	UploadBytesPerSecond int64
+	// DownloadBytesPerSecond caps the rate response bodies are returned at, per flow.
+	// Zero is unlimited.
+	DownloadBytesPerSecond int64
+}
+
+// ThrottleConfig configures Throttle.
+type ThrottleConfig struct {
+	// Rules are checked in order; the first one matching the host of a flow applies.
+	Rules []*ThrottleRule
+}
+
+// Throttle injects latency and jitter into flows and caps their bandwidth with
+// token-bucket pacing of the request and response bodies.
+type Throttle struct {
+	proxy.BaseAddon
+
+	mu       sync.RWMutex // guards rules during reloads
+	rules    []*ThrottleRule
+	filename string
+}
+
+func NewThrottle(config ThrottleConfig) (*Throttle, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Throttle{rules: config.Rules}, nil
+}
+
+func NewThrottleFromFile(filename string) (*Throttle, error) {
+	var config ThrottleConfig
+	if err := helper.NewStructFromFile(filename, &config); err != nil {
+		return nil, err
+	}
+	throttle, err := NewThrottle(config)
+	if err != nil {
+		return nil, err
+	}
+	throttle.filename = filename
+	return throttle, nil
+}
+
+// ConfigFile returns the file the Throttle was loaded from, empty if it was created
+// from a ThrottleConfig.
+func (adn *Throttle) ConfigFile() string {
+	return adn.filename
+}
+
+// Reload loads the rules of the file again, keeping the current ones if it is invalid.
+func (adn *Throttle) Reload() error {
+	loaded, err := NewThrottleFromFile(adn.filename)
+	if err != nil {
+		return err
+	}
+	adn.mu.Lock()
+	adn.rules = loaded.rules
+	adn.mu.Unlock()
+	return nil
+}
+
+// ValidateThrottleFile checks the throttle config in filename, e.g. for negative latencies.
+func ValidateThrottleFile(filename string) error {
+	_, err := NewThrottleFromFile(filename)
+	return err
+}
+
+func (config *ThrottleConfig) validate() error {
+	for i, rule := range config.Rules {
+		if rule == nil {
+			return fmt.Errorf("%v empty rule", i)
+		}
+		if rule.LatencyMs < 0 || rule.JitterMs < 0 {
+			return fmt.Errorf("%v negative latency", i)
+		}
+		if rule.UploadBytesPerSecond < 0 || rule.DownloadBytesPerSecond < 0 {
+			return fmt.Errorf("%v negative bandwidth", i)
+		}
+	}
+	return nil
+}
+
+func (adn *Throttle) StreamRequestModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	rule := adn.match(f)
+	if rule == nil {
+		return in
+	}
+	rule.delay(f)
+	return newThrottledReader(in, rule.UploadBytesPerSecond)
+}
+
+func (adn *Throttle) StreamResponseModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	rule := adn.match(f)
+	if rule == nil {
+		return in
+	}
+	rule.delay(f)
+	return newThrottledReader(in, rule.DownloadBytesPerSecond)
+}
+
+func (adn *Throttle) match(f *proxy.Flow) *ThrottleRule {
+	address := helper.CanonicalAddr(f.Request.URL)
+	adn.mu.RLock()
+	rules := adn.rules
+	adn.mu.RUnlock()
+	for _, rule := range rules {
+		if len(rule.Hosts) == 0 || helper.MatchHost(address, rule.Hosts) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (rule *ThrottleRule) delay(f *proxy.Flow) {
+	latency := time.Duration(rule.LatencyMs) * time.Millisecond
+	if rule.JitterMs > 0 {
+		latency += time.Duration(rand.Int64N(int64(rule.JitterMs)*int64(time.Millisecond) + 1))
+	}
+	if latency > 0 {
+		slog.Debug("throttle delay", "url", f.Request.URL.String(), "latency", latency)
+		time.Sleep(latency)
+	}
+}
+
+// tokenBucket refills at rate tokens per second, holding up to burst tokens.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	// a tenth of a second worth of tokens keeps the pacing smooth
+	burst := max(float64(rate)/10, 1)
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take removes n tokens, sleeping until they are available.
+func (b *tokenBucket) take(n int) {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens < 0 {
+		time.Sleep(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	}
+}
+
+// throttledReader paces the reads from r through a token bucket.
+type throttledReader struct {
+	r      io.Reader
+	bucket *tokenBucket
+}
+
+func newThrottledReader(r io.Reader, bytesPerSecond int64) io.Reader {
+	if r == nil || bytesPerSecond == 0 {
+		return r
+	}
+	return &throttledReader{r: r, bucket: newTokenBucket(bytesPerSecond)}
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > int(tr.bucket.burst) {
+		p = p[:int(tr.bucket.burst)]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		tr.bucket.take(n)
+	}
+	return n, err
+}
@@ -0,0 +1,77 @@
+package addons_test
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+func TestThrottleLatency(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewThrottle(addons.ThrottleConfig{Rules: []*addons.ThrottleRule{
+		{Hosts: []string{"*.example.com"}, LatencyMs: 30, JitterMs: 10},
+	}})
+	c.Assert(err, qt.IsNil)
+
+	f := newJSONLFlow(c, nil, nil)
+	f.Request.URL, _ = url.Parse("https://api.example.com/")
+	start := time.Now()
+	adn.StreamRequestModifier(f, bytes.NewReader(nil))
+	adn.StreamResponseModifier(f, bytes.NewReader(nil))
+	elapsed := time.Since(start)
+	c.Assert(elapsed >= 60*time.Millisecond, qt.IsTrue, qt.Commentf("elapsed %v", elapsed))
+	c.Assert(elapsed < time.Second, qt.IsTrue, qt.Commentf("elapsed %v", elapsed))
+}
+
+func TestThrottleSkipsOtherHosts(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewThrottle(addons.ThrottleConfig{Rules: []*addons.ThrottleRule{
+		{Hosts: []string{"slow.example.org"}, LatencyMs: 1000, DownloadBytesPerSecond: 1},
+	}})
+	c.Assert(err, qt.IsNil)
+
+	in := bytes.NewReader([]byte("body"))
+	start := time.Now()
+	out := adn.StreamResponseModifier(newJSONLFlow(c, nil, nil), in)
+	c.Assert(out, qt.Equals, io.Reader(in))
+	c.Assert(time.Since(start) < 500*time.Millisecond, qt.IsTrue)
+}
+
+func TestThrottleBandwidth(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewThrottle(addons.ThrottleConfig{Rules: []*addons.ThrottleRule{
+		{DownloadBytesPerSecond: 10000},
+	}})
+	c.Assert(err, qt.IsNil)
+
+	body := bytes.Repeat([]byte("x"), 3000)
+	start := time.Now()
+	got, err := io.ReadAll(adn.StreamResponseModifier(newJSONLFlow(c, nil, nil), bytes.NewReader(body)))
+	elapsed := time.Since(start)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.DeepEquals, body)
+	// the first tenth of a second is a burst, the rest is paced at 10 kB/s
+	c.Assert(elapsed >= 150*time.Millisecond, qt.IsTrue, qt.Commentf("elapsed %v", elapsed))
+	c.Assert(elapsed < time.Second, qt.IsTrue, qt.Commentf("elapsed %v", elapsed))
+}
+
+func TestNewThrottleFromFile(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "throttle.json")
+	c.Assert(os.WriteFile(filename, []byte(`{"Rules":[{"Hosts":["example.com"],"LatencyMs":-1}]}`), 0o644), qt.IsNil)
+
+	_, err := addons.NewThrottleFromFile(filename)
+	c.Assert(err, qt.ErrorMatches, "0 negative latency")
+	c.Assert(addons.ValidateThrottleFile(filename), qt.ErrorMatches, "0 negative latency")
+
+	c.Assert(os.WriteFile(filename, []byte(`{"Rules":[{"Hosts":["example.com"],"UploadBytesPerSecond":1024}]}`), 0o644), qt.IsNil)
+	c.Assert(addons.ValidateThrottleFile(filename), qt.IsNil)
+}
@@ -0,0 +1,76 @@
+package addons
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// TraceContext propagates W3C trace-context headers (https://www.w3.org/TR/trace-context/).
+// A request that carries a valid traceparent header is forwarded unchanged together with
+// its tracestate, so the trace continues across the proxy. A request without one, or with
+// an invalid one, gets a freshly generated sampled traceparent. Either way the trace ID is
+// stored in Flow.TraceID.
+type TraceContext struct {
+	proxy.BaseAddon
+}
+
+func NewTraceContext() *TraceContext {
+	return &TraceContext{}
+}
+
+func (*TraceContext) Requestheaders(f *proxy.Flow) {
+	if traceID, ok := parseTraceparent(f.Request.Header.Get("traceparent")); ok {
+		f.TraceID = traceID
+		return
+	}
+
+	traceID := randomHex(16)
+	f.Request.Header.Set("traceparent", "00-"+traceID+"-"+randomHex(8)+"-01")
+	// tracestate is meaningless without the traceparent it belonged to
+	f.Request.Header.Del("tracestate")
+	f.TraceID = traceID
+}
+
+// parseTraceparent validates a version 00 traceparent header and returns its trace ID.
+// Headers of higher versions are accepted as long as they start with the version 00 fields.
+func parseTraceparent(header string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return "", false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", false
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+	if !isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return "", false
+	}
+	if !isLowerHex(flags, 2) {
+		return "", false
+	}
+	return traceID, true
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
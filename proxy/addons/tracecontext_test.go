@@ -0,0 +1,89 @@
+package addons_test
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+var traceparentRe = regexp.MustCompile(`^00-([0-9a-f]{32})-[0-9a-f]{16}-01$`)
+
+func newTraceFlow(header http.Header) *proxy.Flow {
+	flow := types.NewFlow()
+	flow.Request = &proxy.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "http", Host: "example.com", Path: "/"},
+		Header: header,
+	}
+	return flow
+}
+
+func TestTraceContextGeneratesMissingTraceparent(t *testing.T) {
+	c := qt.New(t)
+
+	flow := newTraceFlow(http.Header{})
+	addons.NewTraceContext().Requestheaders(flow)
+
+	m := traceparentRe.FindStringSubmatch(flow.Request.Header.Get("traceparent"))
+	c.Assert(m, qt.HasLen, 2)
+	c.Assert(flow.TraceID, qt.Equals, m[1])
+}
+
+func TestTraceContextPropagatesValidTraceparent(t *testing.T) {
+	c := qt.New(t)
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	flow := newTraceFlow(http.Header{
+		"Traceparent": {traceparent},
+		"Tracestate":  {"vendor=value"},
+	})
+	addons.NewTraceContext().Requestheaders(flow)
+
+	c.Assert(flow.Request.Header.Get("traceparent"), qt.Equals, traceparent)
+	c.Assert(flow.Request.Header.Get("tracestate"), qt.Equals, "vendor=value")
+	c.Assert(flow.TraceID, qt.Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+}
+
+func TestTraceContextReplacesInvalidTraceparent(t *testing.T) {
+	invalid := []string{
+		"garbage",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, header := range invalid {
+		t.Run(header, func(t *testing.T) {
+			c := qt.New(t)
+
+			flow := newTraceFlow(http.Header{
+				"Traceparent": {header},
+				"Tracestate":  {"vendor=value"},
+			})
+			addons.NewTraceContext().Requestheaders(flow)
+
+			c.Assert(flow.Request.Header.Get("traceparent"), qt.Not(qt.Equals), header)
+			c.Assert(traceparentRe.MatchString(flow.Request.Header.Get("traceparent")), qt.IsTrue)
+			c.Assert(flow.Request.Header.Get("tracestate"), qt.Equals, "")
+		})
+	}
+}
+
+func TestTraceContextAcceptsFutureVersion(t *testing.T) {
+	c := qt.New(t)
+
+	traceparent := "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"
+	flow := newTraceFlow(http.Header{"Traceparent": {traceparent}})
+	addons.NewTraceContext().Requestheaders(flow)
+
+	c.Assert(flow.Request.Header.Get("traceparent"), qt.Equals, traceparent)
+	c.Assert(flow.TraceID, qt.Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+}
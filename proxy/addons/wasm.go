@@ -0,0 +1,345 @@
+package addons
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// The WebAssembly plugin ABI.
+//
+// Plugins are WebAssembly modules exporting their memory as "memory" and a function
+// alloc(size i32) i32 that returns the address of size bytes the host writes the input
+// of a hook to. They may export free(ptr i32, size i32), which the host calls with the
+// input and the output of hooks once it is done with them.
+//
+// The hooks are exported as requestheaders, request and response, all of them
+// optional, with the signature (ptr i32, size i32) i64. The input is a hookFlow as
+// JSON; the result packs the address of a hookPatch as JSON in its high 32 bits and its
+// size in the low ones, 0 leaves the flow as it is. Assigning a response in
+// requestheaders or request answers the request without contacting the server.
+//
+// The host module "mitmproxy" has log(level i32, ptr i32, size i32), which logs a
+// message with a slog level, e.g. 0 for info. WASI is available, without file system
+// access or environment variables; the output of the plugin goes to stderr. Reactor
+// modules are initialized with _initialize.
+const wasmHostModule = "mitmproxy"
+
+var wasmHooks = []string{"requestheaders", "request", "response"}
+
+// WasmPluginOptions configure WasmPlugin.
+type WasmPluginOptions struct {
+	// Timeout limits how long a hook may run before it is aborted. Zero means 5 seconds.
+	Timeout time.Duration
+	// MemoryLimitPages limits the memory of each instance, in 64 KiB pages. Zero means
+	// 1024 pages, 64 MiB.
+	MemoryLimitPages uint32
+}
+
+// WasmPlugin runs the hooks of a WebAssembly plugin, so that addons can be written in
+// any language compiling to WebAssembly and run sandboxed: plugins only see the flows
+// handed to them and cannot reach the file system or the network. Concurrent flows
+// run in separate instances of the module. Add it with Proxy.AddAddon; to unload it,
+// remove it with Proxy.RemoveAddon, which also closes it while the proxy runs.
+type WasmPlugin struct {
+	proxy.BaseAddon
+	name     string
+	options  WasmPluginOptions
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	hooks    map[string]bool // exported hooks
+
+	mu        sync.Mutex
+	instances []api.Module // idle instances
+	closed    bool
+	running   sync.WaitGroup
+}
+
+// NewWasmPlugin compiles the WebAssembly module wasm and checks it implements the ABI.
+// name identifies the plugin in logs.
+func NewWasmPlugin(ctx context.Context, name string, wasm []byte, options WasmPluginOptions) (*WasmPlugin, error) {
+	if options.Timeout == 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.MemoryLimitPages == 0 {
+		options.MemoryLimitPages = 1024
+	}
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(options.MemoryLimitPages).
+		WithCloseOnContextDone(true)
+	adn := &WasmPlugin{
+		name:    name,
+		options: options,
+		runtime: wazero.NewRuntimeWithConfig(ctx, config),
+		hooks:   make(map[string]bool),
+	}
+	if err := adn.init(ctx, wasm); err != nil {
+		adn.runtime.Close(ctx)
+		return nil, err
+	}
+	return adn, nil
+}
+
+func NewWasmPluginFromFile(filename string, options WasmPluginOptions) (*WasmPlugin, error) {
+	wasm, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewWasmPlugin(context.Background(), filename, wasm, options)
+}
+
+// ValidateWasmPluginFile instantiates the plugin in filename to check that it implements
+// the ABI, then closes it.
+func ValidateWasmPluginFile(filename string) error {
+	adn, err := NewWasmPluginFromFile(filename, WasmPluginOptions{})
+	if err != nil {
+		return err
+	}
+	return adn.Close()
+}
+
+func (adn *WasmPlugin) init(ctx context.Context, wasm []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, adn.runtime); err != nil {
+		return err
+	}
+	_, err := adn.runtime.NewHostModuleBuilder(wasmHostModule).
+		NewFunctionBuilder().WithFunc(adn.log).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	adn.compiled, err = adn.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return err
+	}
+
+	exported := adn.compiled.ExportedFunctions()
+	if err := checkWasmSignature(exported, "alloc", []api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}); err != nil {
+		return err
+	}
+	if _, ok := exported["free"]; ok {
+		if err := checkWasmSignature(exported, "free", []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, nil); err != nil {
+			return err
+		}
+	}
+	for _, name := range wasmHooks {
+		if _, ok := exported[name]; !ok {
+			continue
+		}
+		if err := checkWasmSignature(exported, name, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}); err != nil {
+			return err
+		}
+		adn.hooks[name] = true
+	}
+	if _, ok := adn.compiled.ExportedMemories()["memory"]; !ok {
+		return errors.New("memory not exported")
+	}
+
+	// instantiating the module once checks it initializes
+	mod, err := adn.instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	adn.instances = append(adn.instances, mod)
+	return nil
+}
+
+func checkWasmSignature(exported map[string]api.FunctionDefinition, name string, params, results []api.ValueType) error {
+	def, ok := exported[name]
+	if !ok {
+		return fmt.Errorf("%s not exported", name)
+	}
+	if string(def.ParamTypes()) != string(params) || string(def.ResultTypes()) != string(results) {
+		return fmt.Errorf("%s has signature %v -> %v, want %v -> %v", name,
+			wasmTypeNames(def.ParamTypes()), wasmTypeNames(def.ResultTypes()), wasmTypeNames(params), wasmTypeNames(results))
+	}
+	return nil
+}
+
+func wasmTypeNames(types []api.ValueType) []string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = api.ValueTypeName(t)
+	}
+	return names
+}
+
+func (adn *WasmPlugin) instantiate(ctx context.Context) (api.Module, error) {
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStderr(os.Stderr).
+		WithStdout(os.Stderr)
+	return adn.runtime.InstantiateModule(ctx, adn.compiled, config)
+}
+
+// log is the log function of the host module.
+func (adn *WasmPlugin) log(ctx context.Context, mod api.Module, level, ptr, size uint32) {
+	msg, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		slog.Warn("wasm plugin log out of memory bounds", "plugin", adn.name)
+		return
+	}
+	slog.Log(ctx, slog.Level(int32(level)), "wasm plugin", "plugin", adn.name, "msg", string(msg))
+}
+
+func (adn *WasmPlugin) Requestheaders(f *proxy.Flow) {
+	adn.call("requestheaders", f)
+}
+
+func (adn *WasmPlugin) Request(f *proxy.Flow) {
+	adn.call("request", f)
+}
+
+func (adn *WasmPlugin) Response(f *proxy.Flow) {
+	adn.call("response", f)
+}
+
+// Close waits for running hooks and frees the plugin. Hooks called later do nothing.
+func (adn *WasmPlugin) Close() error {
+	adn.mu.Lock()
+	if adn.closed {
+		adn.mu.Unlock()
+		return nil
+	}
+	adn.closed = true
+	adn.instances = nil
+	adn.mu.Unlock()
+	adn.running.Wait()
+	return adn.runtime.Close(context.Background())
+}
+
+// Running implements proxy.LifecycleAddon; the plugin is loaded when it is created.
+func (adn *WasmPlugin) Running(*proxy.Proxy) {}
+
+// Done closes the plugin.
+func (adn *WasmPlugin) Done() {
+	if err := adn.Close(); err != nil {
+		slog.Warn("wasm plugin close error", "plugin", adn.name, "error", err)
+	}
+}
+
+// acquire returns an idle instance, or a new one, and false once the plugin is closed.
+func (adn *WasmPlugin) acquire(ctx context.Context) (api.Module, bool, error) {
+	adn.mu.Lock()
+	if adn.closed {
+		adn.mu.Unlock()
+		return nil, false, nil
+	}
+	adn.running.Add(1)
+	if n := len(adn.instances); n > 0 {
+		mod := adn.instances[n-1]
+		adn.instances = adn.instances[:n-1]
+		adn.mu.Unlock()
+		return mod, true, nil
+	}
+	adn.mu.Unlock()
+	mod, err := adn.instantiate(ctx)
+	if err != nil {
+		adn.running.Done()
+		return nil, true, err
+	}
+	return mod, true, nil
+}
+
+// release returns an instance after a hook; instances aborted by a trap or a timeout
+// are dropped, as their state is undefined.
+func (adn *WasmPlugin) release(mod api.Module, failed bool) {
+	defer adn.running.Done()
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	if failed || adn.closed || mod.IsClosed() {
+		mod.Close(context.Background())
+		return
+	}
+	adn.instances = append(adn.instances, mod)
+}
+
+func (adn *WasmPlugin) call(name string, f *proxy.Flow) {
+	if !adn.hooks[name] {
+		return
+	}
+	input, err := json.Marshal(newHookFlow(f, name))
+	if err != nil {
+		slog.Error("wasm plugin error", "plugin", adn.name, "hook", name, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), adn.options.Timeout)
+	defer cancel()
+	mod, ok, err := adn.acquire(ctx)
+	if !ok {
+		return
+	}
+	if err != nil {
+		slog.Error("wasm plugin error", "plugin", adn.name, "hook", name, "error", err)
+		return
+	}
+	output, err := adn.run(ctx, mod, name, input)
+	adn.release(mod, err != nil)
+	if err != nil {
+		slog.Error("wasm plugin error", "plugin", adn.name, "hook", name, "url", f.Request.URL.String(), "error", err)
+		return
+	}
+	if output == nil {
+		return
+	}
+	var patch hookPatch
+	if err := json.Unmarshal(output, &patch); err != nil {
+		slog.Error("wasm plugin error", "plugin", adn.name, "hook", name, "url", f.Request.URL.String(), "error", fmt.Errorf("invalid output: %w", err))
+		return
+	}
+	if err := patch.apply(f); err != nil {
+		slog.Error("wasm plugin error", "plugin", adn.name, "hook", name, "url", f.Request.URL.String(), "error", err)
+	}
+}
+
+// run calls a hook of mod with input and returns a copy of its output, nil if it
+// returned 0.
+func (adn *WasmPlugin) run(ctx context.Context, mod api.Module, name string, input []byte) ([]byte, error) {
+	free := mod.ExportedFunction("free")
+	results, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	inputPtr := uint32(results[0])
+	if !mod.Memory().Write(inputPtr, input) {
+		return nil, fmt.Errorf("alloc returned %d, out of memory bounds", inputPtr)
+	}
+	results, err = mod.ExportedFunction(name).Call(ctx, uint64(inputPtr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	if free != nil {
+		if _, err := free.Call(ctx, uint64(inputPtr), uint64(len(input))); err != nil {
+			return nil, err
+		}
+	}
+	if results[0] == 0 {
+		return nil, nil
+	}
+	outputPtr, outputSize := uint32(results[0]>>32), uint32(results[0])
+	output, ok := mod.Memory().Read(outputPtr, outputSize)
+	if !ok {
+		return nil, fmt.Errorf("output at %d of %d bytes out of memory bounds", outputPtr, outputSize)
+	}
+	// the memory is reused by later calls
+	output = append([]byte(nil), output...)
+	if free != nil {
+		if _, err := free.Call(ctx, uint64(outputPtr), uint64(outputSize)); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
+}
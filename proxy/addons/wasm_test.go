@@ -0,0 +1,120 @@
+package addons_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+// testWasmPlugin is this module, assembled by hand:
+//
+//	(module
+//	  (import "mitmproxy" "log" (func $log (param i32 i32 i32)))
+//	  (memory (export "memory") 1)
+//	  (data (i32.const 16) "{\"request\":{\"method\":\"PUT\",\"header\":{\"X-Wasm\":[\"1\"]},\"body\":\"aGVsbG8=\"},\"response\":{\"statusCode\":202,\"body\":\"ZWFybHk=\"}}")
+//	  (data (i32.const 512) "plugin request")
+//	  (func (export "alloc") (param i32) (result i32)
+//	    i32.const 1024)
+//	  ;; never returns
+//	  (func (export "requestheaders") (param i32 i32) (result i64)
+//	    (loop br 0)
+//	    i64.const 0)
+//	  ;; logs and returns the patch of the first data segment
+//	  (func (export "request") (param i32 i32) (result i64)
+//	    (call $log (i32.const 0) (i32.const 512) (i32.const 14))
+//	    i64.const 0x1000000078)
+//	  ;; returns its input, changing nothing
+//	  (func (export "response") (param i32 i32) (result i64)
+//	    (i64.or (i64.shl (i64.extend_i32_u (local.get 0)) (i64.const 32)) (i64.extend_i32_u (local.get 1)))))
+var testWasmPlugin = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x12, 0x03, 0x60, 0x03, 0x7f, 0x7f, 0x7f,
+	0x00, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e, 0x02, 0x11, 0x01, 0x09,
+	0x6d, 0x69, 0x74, 0x6d, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x03, 0x6c, 0x6f, 0x67, 0x00, 0x00, 0x03,
+	0x05, 0x04, 0x01, 0x02, 0x02, 0x02, 0x05, 0x03, 0x01, 0x00, 0x01, 0x07, 0x38, 0x05, 0x06, 0x6d,
+	0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x00, 0x01, 0x0e,
+	0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x00, 0x02,
+	0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x00, 0x03, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x00, 0x04, 0x0a, 0x31, 0x04, 0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, 0x09, 0x00,
+	0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00, 0x0b, 0x12, 0x00, 0x41, 0x00, 0x41, 0x80, 0x04, 0x41,
+	0x0e, 0x10, 0x00, 0x42, 0xf8, 0x80, 0x80, 0x80, 0x80, 0x02, 0x0b, 0x0c, 0x00, 0x20, 0x00, 0xad,
+	0x42, 0x20, 0x86, 0x20, 0x01, 0xad, 0x84, 0x0b, 0x0b, 0x92, 0x01, 0x02, 0x00, 0x41, 0x10, 0x0b,
+	0x78, 0x7b, 0x22, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x3a, 0x7b, 0x22, 0x6d, 0x65,
+	0x74, 0x68, 0x6f, 0x64, 0x22, 0x3a, 0x22, 0x50, 0x55, 0x54, 0x22, 0x2c, 0x22, 0x68, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x22, 0x3a, 0x7b, 0x22, 0x58, 0x2d, 0x57, 0x61, 0x73, 0x6d, 0x22, 0x3a, 0x5b,
+	0x22, 0x31, 0x22, 0x5d, 0x7d, 0x2c, 0x22, 0x62, 0x6f, 0x64, 0x79, 0x22, 0x3a, 0x22, 0x61, 0x47,
+	0x56, 0x73, 0x62, 0x47, 0x38, 0x3d, 0x22, 0x7d, 0x2c, 0x22, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x22, 0x3a, 0x7b, 0x22, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x43, 0x6f, 0x64, 0x65,
+	0x22, 0x3a, 0x32, 0x30, 0x32, 0x2c, 0x22, 0x62, 0x6f, 0x64, 0x79, 0x22, 0x3a, 0x22, 0x5a, 0x57,
+	0x46, 0x79, 0x62, 0x48, 0x6b, 0x3d, 0x22, 0x7d, 0x7d, 0x00, 0x41, 0x80, 0x04, 0x0b, 0x0e, 0x70,
+	0x6c, 0x75, 0x67, 0x69, 0x6e, 0x20, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+}
+
+func TestWasmPlugin(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewWasmPlugin(context.Background(), "test", testWasmPlugin, addons.WasmPluginOptions{})
+	c.Assert(err, qt.IsNil)
+	defer adn.Close()
+
+	f := newJSONLFlow(c, []byte("body"), []byte(`{"ok":true}`))
+	adn.Response(f)
+	c.Assert(f.Response.StatusCode, qt.Equals, 200)
+	c.Assert(string(f.Response.Body), qt.Equals, `{"ok":true}`)
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "application/json")
+
+	f.Response = nil
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "PUT")
+	c.Assert(f.Request.Header.Get("X-Wasm"), qt.Equals, "1")
+	c.Assert(f.Request.Header.Get("Content-Type"), qt.Equals, "")
+	c.Assert(string(f.Request.Body), qt.Equals, "hello")
+	c.Assert(f.Request.Header.Get("Content-Length"), qt.Equals, "5")
+	c.Assert(f.Response, qt.IsNotNil)
+	c.Assert(f.Response.StatusCode, qt.Equals, 202)
+	c.Assert(string(f.Response.Body), qt.Equals, "early")
+
+	// closed plugins leave flows alone
+	c.Assert(adn.Close(), qt.IsNil)
+	f = newJSONLFlow(c, nil, nil)
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "POST")
+}
+
+func TestWasmPluginTimeout(t *testing.T) {
+	c := qt.New(t)
+	adn, err := addons.NewWasmPlugin(context.Background(), "test", testWasmPlugin, addons.WasmPluginOptions{Timeout: 50 * time.Millisecond})
+	c.Assert(err, qt.IsNil)
+	defer adn.Close()
+
+	f := newJSONLFlow(c, nil, nil)
+	adn.Requestheaders(f)
+	c.Assert(f.Request.Method, qt.Equals, "POST")
+
+	// the aborted instance is replaced
+	adn.Request(f)
+	c.Assert(f.Request.Method, qt.Equals, "PUT")
+}
+
+func TestWasmPluginErrors(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+
+	_, err := addons.NewWasmPluginFromFile(filepath.Join(dir, "missing.wasm"), addons.WasmPluginOptions{})
+	c.Assert(err, qt.IsNotNil)
+
+	filename := filepath.Join(dir, "plugin.wasm")
+	c.Assert(os.WriteFile(filename, []byte("not wasm"), 0o600), qt.IsNil)
+	c.Assert(addons.ValidateWasmPluginFile(filename), qt.ErrorMatches, ".*magic number.*")
+
+	// a module without exports
+	c.Assert(os.WriteFile(filename, []byte("\x00asm\x01\x00\x00\x00"), 0o600), qt.IsNil)
+	c.Assert(addons.ValidateWasmPluginFile(filename), qt.ErrorMatches, "alloc not exported")
+
+	c.Assert(os.WriteFile(filename, testWasmPlugin, 0o600), qt.IsNil)
+	c.Assert(addons.ValidateWasmPluginFile(filename), qt.IsNil)
+}
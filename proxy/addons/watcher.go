@@ -0,0 +1,131 @@
+package addons
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloadable is implemented by addons that load their configuration from a file and
+// can load it again while the proxy runs.
+type Reloadable interface {
+	// ConfigFile returns the file the addon loads, empty if it has none.
+	ConfigFile() string
+	// Reload loads the file again. On error, the addon keeps its configuration.
+	Reload() error
+}
+
+// ConfigWatcher checks the files of Reloadable addons and reloads an addon when its
+// file changes, so that edits take effect without restarting the proxy. Files are
+// polled, which works on every platform and with editors replacing files on save.
+type ConfigWatcher struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries []*watchEntry
+	checkMu sync.Mutex // serializes checks
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	started   bool
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+type watchEntry struct {
+	addon Reloadable
+	info  os.FileInfo // nil while the file is missing
+}
+
+// NewConfigWatcher creates a ConfigWatcher checking files every interval once started.
+// Zero means every second.
+func NewConfigWatcher(interval time.Duration) *ConfigWatcher {
+	if interval == 0 {
+		interval = time.Second
+	}
+	return &ConfigWatcher{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Add watches the file of addon, if it has one. It may be called once the watcher runs.
+func (w *ConfigWatcher) Add(addon Reloadable) {
+	filename := addon.ConfigFile()
+	if filename == "" {
+		return
+	}
+	info, _ := os.Stat(filename)
+	w.mu.Lock()
+	w.entries = append(w.entries, &watchEntry{addon: addon, info: info})
+	w.mu.Unlock()
+}
+
+// Start checks the files in the background until Close.
+func (w *ConfigWatcher) Start() {
+	w.startOnce.Do(w.start)
+}
+
+func (w *ConfigWatcher) start() {
+	w.mu.Lock()
+	w.started = true
+	w.mu.Unlock()
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.Check()
+			}
+		}
+	}()
+}
+
+// Close stops a started watcher.
+func (w *ConfigWatcher) Close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		w.mu.Lock()
+		started := w.started
+		w.mu.Unlock()
+		if started {
+			<-w.done
+		}
+	})
+}
+
+// Check reloads the addons whose files changed since the last check.
+func (w *ConfigWatcher) Check() {
+	w.checkMu.Lock()
+	defer w.checkMu.Unlock()
+	w.mu.Lock()
+	entries := append([]*watchEntry(nil), w.entries...)
+	w.mu.Unlock()
+	for _, entry := range entries {
+		filename := entry.addon.ConfigFile()
+		info, _ := os.Stat(filename)
+		if !fileChanged(entry.info, info) {
+			continue
+		}
+		// a broken file is not reloaded again until it changes
+		entry.info = info
+		if err := entry.addon.Reload(); err != nil {
+			slog.Error("config reload error", "file", filename, "error", err)
+			continue
+		}
+		slog.Info("config reloaded", "file", filename)
+	}
+}
+
+func fileChanged(old, cur os.FileInfo) bool {
+	if old == nil || cur == nil {
+		return (old == nil) != (cur == nil)
+	}
+	return !os.SameFile(old, cur) || !old.ModTime().Equal(cur.ModTime()) || old.Size() != cur.Size()
+}
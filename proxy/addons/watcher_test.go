@@ -0,0 +1,214 @@
+package addons_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/proxy/addons"
+)
+
+// writeWatchedFile writes a file with a modification time that differs from the
+// previous one even on file systems with coarse timestamps.
+func writeWatchedFile(c *qt.C, filename, content string, age time.Duration) {
+	c.Assert(os.WriteFile(filename, []byte(content), 0o644), qt.IsNil)
+	modTime := time.Now().Add(-age)
+	c.Assert(os.Chtimes(filename, modTime, modTime), qt.IsNil)
+}
+
+func mapRemoteConfig(toHost string) string {
+	return `{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"example.com"},"To":{"Host":"` + toHost + `"}}]}`
+}
+
+func TestConfigWatcherReloadsMapRemote(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "map_remote.json")
+	writeWatchedFile(c, filename, mapRemoteConfig("first.com"), time.Hour)
+	mr, err := addons.NewMapRemoteFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	c.Assert(mr.ConfigFile(), qt.Equals, filename)
+
+	w := addons.NewConfigWatcher(time.Hour)
+	w.Add(mr)
+	host := func() string {
+		f := newJSONLFlow(c, nil, nil)
+		mr.Requestheaders(f)
+		return f.Request.URL.Host
+	}
+	c.Assert(host(), qt.Equals, "first.com")
+
+	// unchanged files are not reloaded
+	w.Check()
+	c.Assert(host(), qt.Equals, "first.com")
+
+	writeWatchedFile(c, filename, mapRemoteConfig("second.com"), time.Minute)
+	w.Check()
+	c.Assert(host(), qt.Equals, "second.com")
+
+	// invalid files leave the rules as they are
+	writeWatchedFile(c, filename, `{"Enable":true,"Items":[{"Enable":true}]}`, 0)
+	w.Check()
+	c.Assert(host(), qt.Equals, "second.com")
+}
+
+func TestConfigWatcherReloadsMapLocal(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+	local := filepath.Join(dir, "local.txt")
+	c.Assert(os.WriteFile(local, []byte("local"), 0o644), qt.IsNil)
+	filename := filepath.Join(dir, "map_local.json")
+	writeWatchedFile(c, filename, `{"Enable":false,"Items":[{"Enable":true,"From":{"Host":"example.com"},"To":{"Path":"`+local+`"}}]}`, time.Hour)
+	ml, err := addons.NewMapLocalFromFile(filename)
+	c.Assert(err, qt.IsNil)
+
+	w := addons.NewConfigWatcher(10 * time.Millisecond)
+	w.Add(ml)
+	w.Start()
+	defer w.Close()
+
+	writeWatchedFile(c, filename, `{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"example.com"},"To":{"Path":"`+local+`"}}]}`, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f := newJSONLFlow(c, nil, nil)
+		f.Response = nil
+		ml.Requestheaders(f)
+		if f.Response != nil || time.Now().After(deadline) {
+			c.Assert(f.Response, qt.IsNotNil)
+			c.Assert(f.Response.StatusCode, qt.Equals, 200)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfigWatcherReloadsRewritesAndBlockList(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+	headerFile := filepath.Join(dir, "header_rewrite.json")
+	headerConfig := func(value string) string {
+		return `{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"example.com"},"Request":[{"Op":"set","Name":"X-Rule","Value":"` + value + `"}]}]}`
+	}
+	writeWatchedFile(c, headerFile, headerConfig("first"), time.Hour)
+	hr, err := addons.NewHeaderRewriteFromFile(headerFile)
+	c.Assert(err, qt.IsNil)
+
+	bodyFile := filepath.Join(dir, "body_rewrite.json")
+	bodyConfig := func(replace string) string {
+		return `{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"example.com"},"Request":[{"Find":"hello","Replace":"` + replace + `"}]}]}`
+	}
+	writeWatchedFile(c, bodyFile, bodyConfig("first"), time.Hour)
+	br, err := addons.NewBodyRewriteFromFile(bodyFile)
+	c.Assert(err, qt.IsNil)
+
+	blockFile := filepath.Join(dir, "block_list.json")
+	writeWatchedFile(c, blockFile, `{"Enable":false,"Items":[{"Enable":true,"From":{"Host":"example.com"}}]}`, time.Hour)
+	bl, err := addons.NewBlockListFromFile(blockFile)
+	c.Assert(err, qt.IsNil)
+
+	w := addons.NewConfigWatcher(time.Hour)
+	w.Add(hr)
+	w.Add(br)
+	w.Add(bl)
+	apply := func() *proxy.Flow {
+		f := newJSONLFlow(c, []byte("hello"), nil)
+		f.Response = nil
+		hr.Requestheaders(f)
+		br.Request(f)
+		bl.Requestheaders(f)
+		return f
+	}
+	f := apply()
+	c.Assert(f.Request.Header.Get("X-Rule"), qt.Equals, "first")
+	c.Assert(string(f.Request.Body), qt.Equals, "first")
+	c.Assert(f.Response, qt.IsNil)
+
+	writeWatchedFile(c, headerFile, headerConfig("second"), time.Minute)
+	writeWatchedFile(c, bodyFile, bodyConfig("second"), time.Minute)
+	writeWatchedFile(c, blockFile, `{"Enable":true,"Items":[{"Enable":true,"From":{"Host":"example.com"}}]}`, time.Minute)
+	w.Check()
+	f = apply()
+	c.Assert(f.Request.Header.Get("X-Rule"), qt.Equals, "second")
+	c.Assert(string(f.Request.Body), qt.Equals, "second")
+	c.Assert(f.Response, qt.IsNotNil)
+	c.Assert(f.Response.StatusCode, qt.Equals, 403)
+
+	// invalid files leave the rules as they are
+	writeWatchedFile(c, headerFile, `{"Enable":true,"Items":[{"Enable":true}]}`, 0)
+	writeWatchedFile(c, bodyFile, `{"Enable":true,"Items":[{"Enable":true}]}`, 0)
+	writeWatchedFile(c, blockFile, `{"Enable":true,"Items":[{"Enable":true}]}`, 0)
+	w.Check()
+	f = apply()
+	c.Assert(f.Request.Header.Get("X-Rule"), qt.Equals, "second")
+	c.Assert(string(f.Request.Body), qt.Equals, "second")
+	c.Assert(f.Response, qt.IsNotNil)
+}
+
+func TestConfigWatcherReloadsThrottle(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "throttle.json")
+	writeWatchedFile(c, filename, `{"Rules":[{"Hosts":["other.com"],"UploadBytesPerSecond":1000}]}`, time.Hour)
+	adn, err := addons.NewThrottleFromFile(filename)
+	c.Assert(err, qt.IsNil)
+	c.Assert(adn.ConfigFile(), qt.Equals, filename)
+
+	w := addons.NewConfigWatcher(time.Hour)
+	w.Add(adn)
+	throttled := func() bool {
+		in := strings.NewReader("hello")
+		return adn.StreamRequestModifier(newJSONLFlow(c, nil, nil), in) != io.Reader(in)
+	}
+	c.Assert(throttled(), qt.IsFalse)
+
+	writeWatchedFile(c, filename, `{"Rules":[{"Hosts":["example.com"],"UploadBytesPerSecond":1000}]}`, time.Minute)
+	w.Check()
+	c.Assert(throttled(), qt.IsTrue)
+}
+
+func TestConfigWatcherReopensRotatedDump(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+	filename := filepath.Join(dir, "dump.txt")
+	d := addons.NewDumperWithFilename(filename, 0)
+	w := addons.NewConfigWatcher(time.Hour)
+	w.Add(d)
+
+	dump := func() {
+		f := newJSONLFlow(c, nil, nil)
+		d.Requestheaders(f)
+		f.Finish()
+	}
+	waitForDump := func(filename string) string {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			data, _ := os.ReadFile(filename)
+			if len(data) > 0 || time.Now().After(deadline) {
+				return string(data)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	dump()
+	c.Assert(waitForDump(filename), qt.Contains, "POST /upload")
+	// writing to the dump does not reopen it
+	w.Check()
+
+	rotated := filepath.Join(dir, "dump.txt.1")
+	c.Assert(os.Rename(filename, rotated), qt.IsNil)
+	w.Check()
+	dump()
+	c.Assert(waitForDump(filename), qt.Contains, "POST /upload")
+	c.Assert(strings.Count(waitForDump(rotated), "POST /upload"), qt.Equals, 1)
+}
+
+func TestConfigWatcherCloseWithoutStart(t *testing.T) {
+	w := addons.NewConfigWatcher(0)
+	w.Close()
+	w.Start()
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// clientFilter decides which client addresses may connect to the proxy, from
+// Config.AllowClients and Config.DenyClients.
+type clientFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func newClientFilter(allow, deny []string) (*clientFilter, error) {
+	f := &clientFilter{}
+	var err error
+	if f.allow, err = parsePrefixes(allow); err != nil {
+		return nil, fmt.Errorf("allow clients: %w", err)
+	}
+	if f.deny, err = parsePrefixes(deny); err != nil {
+		return nil, fmt.Errorf("deny clients: %w", err)
+	}
+	return f, nil
+}
+
+// parsePrefixes parses CIDRs, and single IP addresses as the prefix of that address only.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// clientIP returns the IP address of a client connecting from addr, over TCP or over
+// QUIC to the HTTP/3 listener, false for clients without one, on UNIX sockets.
+func clientIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return netip.Addr{}, false
+	}
+	parsed, ok := netip.AddrFromSlice(ip)
+	return parsed.Unmap(), ok
+}
+
+// allowed reports whether a client connecting from addr may use the proxy. Clients
+// without an IP address are always allowed.
+func (f *clientFilter) allowed(addr net.Addr) bool {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return true
+	}
+	ip, ok := clientIP(addr)
+	if !ok {
+		return true
+	}
+	for _, prefix := range f.deny {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// connLimiter counts the open client connections, in total and per client IP address,
+// against Config.MaxClientConnections and Config.MaxConnectionsPerClient.
+type connLimiter struct {
+	max       int
+	perClient int
+
+	mu     sync.Mutex
+	open   int
+	byAddr map[netip.Addr]int
+}
+
+func newConnLimiter(max, perClient int) *connLimiter {
+	return &connLimiter{
+		max:       max,
+		perClient: perClient,
+		byAddr:    make(map[netip.Addr]int),
+	}
+}
+
+// acquire counts a connection of the client at addr, unless it is over the limits.
+// The per client limit does not apply to clients without an IP address.
+func (l *connLimiter) acquire(addr net.Addr) bool {
+	if l.max <= 0 && l.perClient <= 0 {
+		return true
+	}
+	ip, hasIP := clientIP(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.open >= l.max {
+		return false
+	}
+	if hasIP && l.perClient > 0 && l.byAddr[ip] >= l.perClient {
+		return false
+	}
+	l.open++
+	if hasIP {
+		l.byAddr[ip]++
+	}
+	return true
+}
+
+// release uncounts a connection acquired for the client at addr.
+func (l *connLimiter) release(addr net.Addr) {
+	if l.max <= 0 && l.perClient <= 0 {
+		return
+	}
+	ip, hasIP := clientIP(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.open--
+	if hasIP {
+		if l.byAddr[ip] <= 1 {
+			delete(l.byAddr, ip)
+		} else {
+			l.byAddr[ip]--
+		}
+	}
+}
+
+// admitClient reports whether a new connection of the client at addr is allowed by
+// Config.AllowClients and Config.DenyClients and within the connection limits, in which
+// case it is counted against the limits until released. Refused connections are counted
+// by RejectedClients.
+func (p *Proxy) admitClient(addr net.Addr) bool {
+	if !p.clients.allowed(addr) {
+		slog.Debug("client rejected", "remoteAddr", addr.String())
+	} else if !p.connLimit.acquire(addr) {
+		slog.Debug("client over the connection limits", "remoteAddr", addr.String())
+	} else {
+		return true
+	}
+	p.rejectedClients.Add(1)
+	return false
+}
+
+// admitHTTP3Client admits the QUIC connections of the HTTP/3 listener like admitClient.
+func (p *Proxy) admitHTTP3Client(addr net.Addr) (func(), bool) {
+	if !p.admitClient(addr) {
+		return nil, false
+	}
+	return func() { p.connLimit.release(addr) }, true
+}
@@ -1,10 +1,157 @@
 package proxy
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"time"
+)
+
 // Config holds the proxy configuration settings.
 type Config struct {
-	Addr               string
+	Addr string
+	// ExtraAddrs are listened on too, besides Addr, e.g. [::1]:9080 next to
+	// 127.0.0.1:9080 or one address per interface. All of them share the addons.
+	ExtraAddrs []string
+	// Network is the network of Addr and ExtraAddrs, "tcp" if empty, or "unix" to
+	// listen on socket files, e.g. for a sidecar whose clients connect over a local
+	// socket while the proxy does the outbound TLS work. A socket file left by a proxy
+	// that did not stop cleanly is replaced.
+	Network string
+	// SystemdSocketActivation serves the listening sockets passed by systemd (LISTEN_FDS)
+	// instead of listening on Addr and ExtraAddrs, so the proxy can be socket-activated
+	// and restarted without closing the listening sockets. Without sockets from systemd
+	// the proxy listens on Addr and ExtraAddrs.
+	SystemdSocketActivation bool
+	// TLSCertificate makes the listeners accept TLS connections only, as an HTTPS
+	// ("secure web") proxy like the ones Chrome and Firefox support, so the
+	// Proxy-Authorization credentials and the CONNECT targets are not visible on the
+	// network. The requests and the tunnels run inside the TLS connection.
+	TLSCertificate *tls.Certificate
+	// AllowClients and DenyClients restrict the clients that may connect, as CIDRs or
+	// single IP addresses, e.g. 10.0.0.0/8 or 192.168.1.20, so an exposed proxy port
+	// cannot be used by arbitrary hosts. A client matching DenyClients is rejected;
+	// with AllowClients, only the clients matching it are accepted. Rejected connections
+	// are closed before any addon sees them, and counted by Proxy.RejectedClients. They
+	// apply to the QUIC connections of the HTTP3Addr listener too.
+	AllowClients []string
+	DenyClients  []string
+	// MaxClientConnections limits the client connections open at the same time, and
+	// MaxConnectionsPerClient the ones of a single client IP address, to protect the
+	// proxy from connection floods; zero means unlimited. Connections beyond the limits
+	// are closed as soon as they are accepted, and counted by Proxy.RejectedClients. A
+	// QUIC connection to the HTTP3Addr listener counts as one client connection.
+	MaxClientConnections    int
+	MaxConnectionsPerClient int
+
 	StreamLargeBodies  int64
 	InsecureSkipVerify bool
-	Upstream           string
+	Upstream           string // upstream proxy URL, or comma-separated URLs of proxies to go through in turn
 	ClientFactory      ClientFactory
+
+	// TCPNoDelay enables TCP_NODELAY on client and upstream connections.
+	// Go already enables it by default, so false leaves the default in place.
+	TCPNoDelay bool
+
+	// TCPKeepAlive is the keep-alive period for client and upstream connections.
+	// Zero keeps the default, negative disables keep-alive.
+	TCPKeepAlive time.Duration
+
+	// MatchUpstreamProtocol makes the proxy offer upstream only the HTTP version the client
+	// negotiated, so an HTTP/1.1 client is not upgraded to HTTP/2 towards the server and an
+	// HTTP/2 client keeps HTTP/2 when the server supports it.
+	MatchUpstreamProtocol bool
+
+	// ServerHeader is sent as the Server header on responses generated by the proxy itself,
+	// such as errors and responses provided by addons. Proxied responses keep the upstream
+	// Server header. Empty sends no Server header.
+	ServerHeader string
+
+	// MaxConcurrentFlows limits the number of flows handled at the same time; zero means unlimited.
+	// Flows beyond the limit wait for a free slot, up to MaxQueuedFlows of them; the rest are
+	// rejected with 503 Service Unavailable.
+	MaxConcurrentFlows int
+	MaxQueuedFlows     int
+
+	// ResponseBodyLimits caps response body sizes per content type. Keys are media types
+	// such as "application/json", "video/*" or "*/*"; the most specific match applies.
+	// Values are limits in bytes, zero or negative means unlimited. A body over its limit
+	// makes the proxy answer 502, or, with TruncateLimitedBodies, is cut at the limit.
+	ResponseBodyLimits    map[string]int64
+	TruncateLimitedBodies bool
+
+	// MaxIdleConnsPerHost and IdleConnTimeout tune the connection pool of the separate
+	// client, which sends the requests of flows with UseSeparateClient or a rewritten
+	// host; zero keeps the defaults of DefaultClientFactory. They do not apply to a
+	// custom ClientFactory.
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	// HTTP3Addr, if set, is the UDP address of a transparent HTTP/3 (QUIC) listener.
+	// Clients reaching it, e.g. through a DNS override, negotiate h3 with a certificate
+	// generated for their SNI, and their requests are intercepted like HTTP/1.1 and h2
+	// ones and sent upstream over HTTP/3. CONNECT-UDP tunnels are not supported.
+	HTTP3Addr string
+
+	// MimicClientHello makes the upstream TLS handshake replay the fingerprint of the
+	// client's ClientHello (cipher suites, extensions and their order, curves, ALPN),
+	// using uTLS, so servers that fingerprint TLS clients see the client instead of Go.
+	// ClientHellos uTLS cannot reproduce fall back to Go's own handshake.
+	MimicClientHello bool
+
+	// ClientCertificates are presented to upstream servers that ask for a client
+	// certificate, so services requiring mutual TLS can be intercepted. The first entry
+	// whose Hosts match the server is used. With a custom ClientFactory, the certificates
+	// are only applied to the connections of intercepted clients and WebSockets.
+	ClientCertificates []ClientCertificate
+
+	// ClientAuth makes intercepted TLS clients present a certificate to the proxy. With
+	// tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert the certificate must
+	// chain to ClientCAs. The certificates are available to addons as
+	// ClientConn.PeerCertificates.
+	ClientAuth tls.ClientAuthType
+	ClientCAs  *x509.CertPool
+
+	// WireCapture, if set, receives the exact bytes the proxy reads from and writes to
+	// client and server connections, decrypted but before any HTTP parsing, to debug
+	// differences introduced by the proxy's own HTTP serialization. Each read or write is
+	// a record of a header line with the time, the ID of the client connection and the
+	// direction, followed by the bytes. Tunnels that are not intercepted, WebSockets,
+	// HTTP/3, the connections of the separate client and upstream HTTP/2 connections
+	// of HTTP/1.1 clients are not captured.
+	WireCapture io.Writer
+
+	// Resolver, if set, looks up the servers and upstream proxies the proxy connects to
+	// instead of the system resolver, e.g. NewDNSResolver or NewDoHResolver. The lookups
+	// are available to addons as Flow.Resolution. WebSockets and HTTP/3 upstream
+	// connections keep the system resolver.
+	Resolver Resolver
+
+	// UpstreamAuth, if set, authenticates the CONNECT requests to HTTP upstream proxies,
+	// for schemes beyond the Basic one of the user info of their URLs, like NTLM or
+	// Negotiate (Kerberos). Its handshakes may take several requests, so the separate
+	// client then tunnels plain HTTP requests through the proxy with CONNECT too.
+	UpstreamAuth ProxyAuth
+
+	// OutboundLocalAddr, if set, is the local IP address the connections to servers and
+	// upstream proxies are bound to, to choose the egress interface of multi-homed hosts.
+	// Proxy.SetOutboundLocalAddr chooses it per request instead. WebSockets and HTTP/3
+	// upstream connections are not bound.
+	OutboundLocalAddr string
+
+	// SpoolLargeBodies, if set, is the size up to which bodies larger than
+	// StreamLargeBodies are spooled to temporary files in SpoolDir, or in the default
+	// directory for temporary files when empty, instead of being streamed. The Request
+	// and Response addon events then see them as Request.SpooledBody and
+	// Response.SpooledBody, without holding them in memory. Larger bodies are streamed.
+	SpoolLargeBodies int64
+	SpoolDir         string
+
+	// TransformRequest, if set, is called with every request after the Request addon event,
+	// right before it is forwarded upstream. Changes to the request are sent on the wire.
+	TransformRequest func(*Request)
+
+	// TransformResponse, if set, is called with every response after all addons have run,
+	// right before it is written back to the client.
+	TransformResponse func(*Response)
 }
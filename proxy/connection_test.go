@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 
@@ -161,3 +162,54 @@ func TestConnectionOffUpstreamCert(t *testing.T) {
 		})
 	})
 }
+
+// addon recording the client's offered ALPN protocols when the server TLS handshake completes.
+type testOfferedALPNAddon struct {
+	proxy.BaseAddon
+	mu          sync.Mutex
+	offeredALPN []string
+	extensions  int
+}
+
+func (adn *testOfferedALPNAddon) TLSEstablishedServer(connCtx *proxy.ConnContext) {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	adn.offeredALPN = connCtx.ClientConn.OfferedALPN
+	adn.extensions = len(connCtx.ClientConn.OfferedExtensions)
+}
+
+func TestConnectionOfferedALPN(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29092",
+	}
+	helper.init(c)
+	helper.server.TLSConfig.NextProtos = []string{"h2"}
+	httpsEndpoint := helper.httpsEndpoint
+	testProxy := helper.testProxy
+	offeredALPNAddon := &testOfferedALPNAddon{}
+	testProxy.AddAddon(offeredALPNAddon)
+	defer helper.tlsPlainLn.Close()
+	go func() { _ = helper.server.ServeTLS(helper.tlsPlainLn, "", "") }()
+	go func() { _ = testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1" + helper.proxyAddr)
+			},
+		},
+	}
+	testGetResponse(c, httpsEndpoint, client)
+
+	offeredALPNAddon.mu.Lock()
+	defer offeredALPNAddon.mu.Unlock()
+	c.Assert(offeredALPNAddon.offeredALPN, qt.DeepEquals, []string{"h2", "http/1.1"})
+	c.Assert(offeredALPNAddon.extensions > 0, qt.IsTrue)
+}
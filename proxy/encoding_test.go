@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"strconv"
 	"testing"
 
 	"github.com/andybalholm/brotli"
@@ -185,3 +186,85 @@ func TestResponseReplaceToDecodedBodyOnError(t *testing.T) {
 	c.Assert(resp.Body, qt.DeepEquals, broken)
 	c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "gzip")
 }
+
+func TestRequestReencodeBodyRoundTrip(t *testing.T) {
+	for _, enc := range []string{"gzip", "br", "deflate", "zstd"} {
+		t.Run(enc, func(t *testing.T) {
+			c := qt.New(t)
+
+			req := &proxy.Request{Header: make(map[string][]string)}
+			req.Body = []byte("edited payload")
+			req.Header.Set("Transfer-Encoding", "chunked")
+
+			err := req.ReencodeBody(enc)
+			c.Assert(err, qt.IsNil)
+			c.Assert(req.Header.Get("Content-Encoding"), qt.Equals, enc)
+			c.Assert(req.Header.Get("Content-Length"), qt.Equals, strconv.Itoa(len(req.Body)))
+			c.Assert(req.Header.Get("Transfer-Encoding"), qt.Equals, "")
+
+			decoded, err := req.DecodedBody()
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(decoded), qt.Equals, "edited payload")
+		})
+	}
+}
+
+func TestRequestReencodeBodyIdentity(t *testing.T) {
+	c := qt.New(t)
+
+	req := &proxy.Request{Header: make(map[string][]string)}
+	req.Body = []byte("plain")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	err := req.ReencodeBody("identity")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(req.Body), qt.Equals, "plain")
+	c.Assert(req.Header.Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(req.Header.Get("Content-Length"), qt.Equals, "5")
+}
+
+func TestRequestReencodeBodyUnsupportedEncoding(t *testing.T) {
+	c := qt.New(t)
+
+	req := &proxy.Request{Header: make(map[string][]string)}
+	req.Body = []byte("plain")
+
+	err := req.ReencodeBody("compress")
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(string(req.Body), qt.Equals, "plain")
+	c.Assert(req.Header.Get("Content-Encoding"), qt.Equals, "")
+}
+
+func TestRequestReplaceToDecodedBody(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write([]byte("payload"))
+	_ = w.Close()
+
+	req := &proxy.Request{Header: make(map[string][]string)}
+	req.Body = buf.Bytes()
+	req.Header.Set("Content-Encoding", "gzip")
+
+	req.ReplaceToDecodedBody()
+
+	c.Assert(string(req.Body), qt.Equals, "payload")
+	c.Assert(req.Header.Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(req.Header.Get("Content-Length"), qt.Equals, "7")
+}
+
+func TestResponseReencodeBody(t *testing.T) {
+	c := qt.New(t)
+
+	resp := &proxy.Response{Header: make(map[string][]string)}
+	resp.Body = []byte("payload")
+
+	err := resp.ReencodeBody("gzip")
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+
+	decoded, err := resp.DecodedBody()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(decoded), qt.Equals, "payload")
+}
@@ -72,10 +72,13 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"sync/atomic"
 
 	"github.com/denisvmedia/go-mitmproxy/internal/helper"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/conn"
@@ -88,6 +91,9 @@ import (
 // the ClientConnected addon event before returning the connection to the HTTP server.
 //
 // This wrapper is essential for:
+//   - Rejecting the clients Config.AllowClients and Config.DenyClients do not allow, and
+//     the connections over the connection limits
+//   - Applying the configured TCP keep-alive and nodelay options
 //   - Creating and attaching connection context (ConnContext) to each client connection
 //   - Wrapping raw connections in WrapClientConn for buffering and peeking capabilities
 //   - Notifying addons when a new client connects to the proxy
@@ -97,12 +103,19 @@ type wrapListener struct {
 }
 
 func (l *wrapListener) Accept() (net.Conn, error) {
-	c, err := l.Listener.Accept()
+	c, err := l.acceptAllowed()
 	if err != nil {
 		return nil, err
 	}
 
 	proxy := l.proxy
+	tcpConn := c
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		tcpConn = tlsConn.NetConn()
+	}
+	if err := helper.SetTCPOptions(tcpConn, proxy.config.TCPKeepAlive, proxy.config.TCPNoDelay); err != nil {
+		slog.Debug("set tcp options failed", "error", err)
+	}
 	wc := conn.NewWrapClientConn(c, proxy)
 
 	// Create conn context - this is now the single source of truth
@@ -110,14 +123,33 @@ func (l *wrapListener) Accept() (net.Conn, error) {
 	clientConn.CloseChan = wc.CloseChan // Share the close channel
 	connCtx := conn.NewContext(clientConn)
 	wc.ConnCtx = connCtx
+	if proxy.wire != nil {
+		wc.CaptureWire(proxy.wire)
+	}
 
-	for _, addon := range proxy.addonRegistry.Get() {
+	for _, addon := range proxy.addonRegistry.View() {
 		addon.ClientConnected(connCtx.ClientConn)
 	}
 
 	return wc, nil
 }
 
+// acceptAllowed accepts the next connection of a client allowed by Config.AllowClients
+// and Config.DenyClients, within the connection limits, and closes the other
+// connections. The connection limit is released when the client disconnects.
+func (l *wrapListener) acceptAllowed() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.proxy.admitClient(c.RemoteAddr()) {
+			return c, nil
+		}
+		c.Close()
+	}
+}
+
 // entry is the HTTP server entry point for the MITM proxy.
 //
 // The entry struct serves as the bridge between the standard Go HTTP server
@@ -133,8 +165,8 @@ func (l *wrapListener) Accept() (net.Conn, error) {
 //   - Direct requests to the proxy server itself
 //
 // Lifecycle:
-//   - Created by newEntry() during Proxy initialization
-//   - Started by start() which begins listening for connections
+//   - Created by newEntry() during Proxy initialization, one per listen address
+//   - Started by listen() and serve(), which accept the connections
 //   - Stopped by close() for immediate shutdown or shutdown() for graceful shutdown
 //
 // Request Flow:
@@ -147,6 +179,11 @@ func (l *wrapListener) Accept() (net.Conn, error) {
 type entry struct {
 	proxy  *Proxy
 	server *http.Server
+	ln     net.Listener // inherited from systemd or given to Serve, nil to listen on the address of server
+
+	// tunnels counts the CONNECT requests being handled: the tunnels, and the
+	// intercepted connections until the attacker serves them.
+	tunnels atomic.Int64
 }
 
 // newEntry creates a new entry point for the proxy server.
@@ -159,10 +196,10 @@ type entry struct {
 // WrapClientConn (created by wrapListener) and stores it in the request's
 // context.Context, making connection-level state available throughout the
 // request lifecycle.
-func newEntry(proxy *Proxy) *entry {
+func newEntry(proxy *Proxy, addr string) *entry {
 	e := &entry{proxy: proxy}
 	e.server = &http.Server{
-		Addr:    proxy.config.Addr,
+		Addr:    addr,
 		Handler: e,
 		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
 			if wc, ok := c.(*conn.WrapClientConn); ok {
@@ -175,30 +212,70 @@ func newEntry(proxy *Proxy) *entry {
 	return e
 }
 
-// start begins listening for incoming proxy connections.
-//
-// This method:
-//  1. Creates a TCP listener on the configured address (defaults to ":http" if not specified)
-//  2. Wraps the listener in wrapListener to intercept and prepare connections
-//  3. Starts the HTTP server with the wrapped listener
-//
-// This is a blocking call that runs until the server is shut down or encounters an error.
-func (e *entry) start() error {
-	addr := e.server.Addr
-	if addr == "" {
-		addr = ":http"
-	}
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
+// listen creates the listener on the address of the entry (defaults to ":http" if not
+// specified), wrapped in wrapListener to intercept and prepare connections. The network
+// is the one of Config.Network, TCP by default. A listener inherited from systemd or
+// given to Serve is used as is.
+// With Config.TLSCertificate the connections are TLS connections.
+func (e *entry) listen() (net.Listener, error) {
+	ln := e.ln
+	if ln != nil {
+		slog.Info("proxy serving listener", "network", ln.Addr().Network(), "addr", e.server.Addr)
+	} else {
+		network := e.proxy.config.Network
+		if network == "" {
+			network = "tcp"
+		}
+		addr := e.server.Addr
+		if addr == "" && network == "tcp" {
+			addr = ":http"
+		}
+		if network == "unix" {
+			removeStaleSocket(addr)
+		}
+		var err error
+		ln, err = net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("proxy listening", "network", network, "addr", e.server.Addr)
 	}
 
-	slog.Info("proxy listening", "addr", e.server.Addr)
-	pln := &wrapListener{
+	if cert := e.proxy.config.TLSCertificate; cert != nil {
+		// beneath wrapListener, which peeks the requests and the tunnels in the clear
+		ln = tls.NewListener(ln, &tls.Config{
+			Certificates: []tls.Certificate{*cert},
+			NextProtos:   []string{"http/1.1"},
+		})
+	}
+	return &wrapListener{
 		Listener: ln,
 		proxy:    e.proxy,
+	}, nil
+}
+
+// removeStaleSocket removes the socket file at path if no process listens on it, so a
+// proxy that did not stop cleanly does not keep the next one from listening.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		// in use, listening fails
+		c.Close()
+		return
 	}
-	return e.server.Serve(pln)
+	if err := os.Remove(path); err != nil {
+		slog.Warn("remove stale socket failed", "path", path, "error", err)
+	}
+}
+
+// serve serves the proxy connections accepted by ln.
+//
+// This is a blocking call that runs until the server is shut down or encounters an error.
+func (e *entry) serve(ln net.Listener) error {
+	return e.server.Serve(ln)
 }
 
 // close immediately stops the proxy server.
@@ -229,12 +306,16 @@ func (e *entry) shutdown(ctx context.Context) error {
 //   - Routes to handleConnect() for establishing encrypted tunnels
 //   - Used for intercepting HTTPS traffic
 //
-// 3. Direct Requests (non-proxy requests):
+// 3. Target Rewriting:
+//   - Repairs malformed absolute-form URLs (e.g. a missing scheme)
+//   - Triggers RewriteTarget addon event so addons can fix the target
+//
+// 4. Direct Requests (non-proxy requests):
 //   - Requests without absolute URLs or missing Host header
 //   - Triggers AccessProxyServer addon event
 //   - Returns 400 Bad Request if addons don't handle it
 //
-// 4. HTTP Proxy Requests:
+// 5. HTTP Proxy Requests:
 //   - Regular HTTP requests with absolute URLs
 //   - Routes to attacker.Attack() for interception and forwarding
 //
@@ -248,6 +329,11 @@ func (e *entry) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		"in", "Proxy.entry.ServeHTTP",
 		"host", req.Host,
 	)
+	// Responses generated by the proxy carry the configured Server header,
+	// proxied responses replace it with the upstream one.
+	if proxy.config.ServerHeader != "" {
+		res.Header().Set("Server", proxy.config.ServerHeader)
+	}
 	// Add entry proxy authentication
 	if e.proxy.authProxy != nil {
 		b, err := e.proxy.authProxy(res, req)
@@ -263,9 +349,14 @@ func (e *entry) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	normalizeRequestURL(req)
+	for _, addon := range proxy.addonRegistry.View() {
+		addon.RewriteTarget(req)
+	}
+
 	if !req.URL.IsAbs() || req.URL.Host == "" {
 		res = helper.NewResponseCheck(res)
-		for _, addon := range proxy.addonRegistry.Get() {
+		for _, addon := range proxy.addonRegistry.View() {
 			addon.AccessProxyServer(req, res)
 		}
 		if res, ok := res.(*helper.ResponseCheck); ok {
@@ -315,6 +406,9 @@ func (e *entry) handleConnect(res http.ResponseWriter, req *http.Request) {
 		"host", req.Host,
 	)
 
+	e.tunnels.Add(1)
+	defer e.tunnels.Add(-1)
+
 	shouldIntercept := proxy.shouldIntercept == nil || proxy.shouldIntercept(req)
 	f := types.NewFlow()
 	f.Request = types.NewRequest(req)
@@ -327,7 +421,7 @@ func (e *entry) handleConnect(res http.ResponseWriter, req *http.Request) {
 	defer f.Finish()
 
 	// trigger addon event Requestheaders
-	for _, addon := range proxy.addonRegistry.Get() {
+	for _, addon := range proxy.addonRegistry.View() {
 		addon.Requestheaders(f)
 	}
 
@@ -371,6 +465,10 @@ func (e *entry) establishConnection(res http.ResponseWriter, f *Flow) (net.Conn,
 		cconn.Close()
 		return nil, err
 	}
+	// the tunnel is captured by the attacker once decrypted, if it is intercepted
+	if wcc, ok := cconn.(*conn.WrapClientConn); ok {
+		wcc.StopWireCapture()
+	}
 
 	f.Response = &Response{
 		StatusCode: 200,
@@ -378,7 +476,7 @@ func (e *entry) establishConnection(res http.ResponseWriter, f *Flow) (net.Conn,
 	}
 
 	// trigger addon event Responseheaders
-	for _, addon := range e.proxy.addonRegistry.Get() {
+	for _, addon := range e.proxy.addonRegistry.View() {
 		addon.Responseheaders(f)
 	}
 
@@ -424,7 +522,7 @@ func (e *entry) directTransfer(res http.ResponseWriter, req *http.Request, f *Fl
 	}
 	defer cconn.Close()
 
-	transfer(logger, upstreamConn, cconn)
+	transfer(logger, upstreamConn, cconn, &proxy.tunnelSent, &proxy.tunnelReceived)
 }
 
 // httpsDialFirstAttack performs MITM interception by connecting to upstream first.
@@ -438,7 +536,8 @@ func (e *entry) directTransfer(res http.ResponseWriter, req *http.Request, f *Fl
 //  2. Establish tunnel with client (establishConnection)
 //  3. Peek at client's first bytes to detect TLS
 //  4. Route based on protocol:
-//     - Non-TLS: Direct transfer (TODO: handle HTTP, WebSocket)
+//     - Plain HTTP request line: Intercept plain HTTP and WebSocket (HTTPAttack)
+//     - Other non-TLS protocols: Tunnel to the dialed connection (transfer)
 //     - TLS: Perform TLS interception (HTTPSTLSDial)
 //
 // Advantages:
@@ -484,10 +583,14 @@ func (e *entry) httpsDialFirstAttack(res http.ResponseWriter, req *http.Request,
 		return
 	}
 	if !helper.IsTLS(peek) {
-		// todo: http, ws
-		transfer(logger, serverConn, cconn)
-		cconn.Close()
+		if peekHTTPRequest(wcc) {
+			// plain http or ws, reuses the dialed server connection
+			proxy.attacker.HTTPAttack(req.Context(), cconn, req)
+			return
+		}
+		transfer(logger, serverConn, cconn, &proxy.tunnelSent, &proxy.tunnelReceived)
 		serverConn.Close()
+		cconn.Close()
 		return
 	}
 
@@ -506,8 +609,10 @@ func (e *entry) httpsDialFirstAttack(res http.ResponseWriter, req *http.Request,
 //  1. Establish tunnel with client (establishConnection)
 //  2. Peek at client's first bytes to detect protocol
 //  3. Route based on protocol:
-//     - Non-TLS: Connect to upstream and direct transfer (TODO: handle HTTP, WebSocket)
-//     - TLS: Perform lazy TLS interception (HTTPSLazyAttack)
+//     - Plain HTTP request line: Intercept plain HTTP and WebSocket (HTTPAttack)
+//     - Other non-TLS protocols: Tunnel to the target (transferToUpstream)
+//     - TLS: Perform lazy TLS interception (HTTPSLazyAttack), or tunnel it
+//     when the SNI rule declines interception
 //
 // Advantages:
 //   - More efficient for most cases (no upstream connection if not needed)
@@ -545,20 +650,58 @@ func (e *entry) httpsDialLazyAttack(res http.ResponseWriter, req *http.Request,
 	}
 
 	if !helper.IsTLS(peek) {
-		// todo: http, ws
-		serverConn, err := proxy.attacker.HTTPSDial(req.Context(), req)
-		if err != nil {
-			cconn.Close()
-			logger.Error("httpsDial failed", "error", err)
+		if peekHTTPRequest(wcc) {
+			// plain http or ws
+			proxy.attacker.HTTPAttack(req.Context(), cconn, req)
 			return
 		}
-		transfer(logger, serverConn, cconn)
-		serverConn.Close()
-		cconn.Close()
+		e.transferToUpstream(cconn, req, logger)
 		return
 	}
 
 	// is tls
 	f.ConnContext.ClientConn.TLS = true
-	proxy.attacker.HTTPSLazyAttack(req.Context(), cconn, req)
+	if !proxy.attacker.HTTPSLazyAttack(req.Context(), cconn, req) {
+		// interception declined by the SNI rule
+		e.transferToUpstream(cconn, req, logger)
+	}
+}
+
+// maxRequestLinePeek bounds the bytes read ahead to find the request line of plain HTTP
+// in a CONNECT tunnel. Longer request lines are tunneled without interception.
+const maxRequestLinePeek = 8 << 10
+
+// peekHTTPRequest reports whether the client starts its tunnel with an HTTP/1.x request
+// line, without consuming it. It stops reading ahead as soon as the bytes cannot start
+// one, so that protocols such as SSH are not held up waiting for a line end.
+func peekHTTPRequest(wcc *conn.WrapClientConn) bool {
+	for n := 1; n <= maxRequestLinePeek; n = wcc.Buffered() + 1 {
+		// waits for n bytes, returns more when they are already read ahead
+		if _, err := wcc.Peek(n); err != nil {
+			return false
+		}
+		peek, _ := wcc.Peek(min(wcc.Buffered(), maxRequestLinePeek))
+		ok, complete := helper.MatchRequestLine(peek)
+		if !ok {
+			return false
+		}
+		if complete {
+			return true
+		}
+	}
+	return false
+}
+
+// transferToUpstream connects to the CONNECT target and tunnels the already
+// established client connection to it without interception.
+func (e *entry) transferToUpstream(cconn net.Conn, req *http.Request, logger *slog.Logger) {
+	serverConn, err := e.proxy.attacker.HTTPSDial(req.Context(), req)
+	if err != nil {
+		cconn.Close()
+		logger.Error("httpsDial failed", "error", err)
+		return
+	}
+	transfer(logger, serverConn, cconn, &e.proxy.tunnelSent, &e.proxy.tunnelReceived)
+	serverConn.Close()
+	cconn.Close()
 }
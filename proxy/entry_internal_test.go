@@ -64,7 +64,7 @@ func TestEntryServeHTTPDirectRequestTriggersAccessProxyServer(t *testing.T) {
 	ctx := proxycontext.WithConnContext(req.Context(), connCtx)
 	req = req.WithContext(ctx)
 
-	p.entry.ServeHTTP(rec, req)
+	p.entries[0].ServeHTTP(rec, req)
 
 	c.Assert(addon.accessProxyServerCalled, qt.IsTrue)
 	c.Assert(rec.Code, qt.Equals, 200)
@@ -89,7 +89,7 @@ func TestEntryServeHTTPDirectRequestWithoutAddonReturns400(t *testing.T) {
 	ctx := proxycontext.WithConnContext(req.Context(), connCtx)
 	req = req.WithContext(ctx)
 
-	p.entry.ServeHTTP(rec, req)
+	p.entries[0].ServeHTTP(rec, req)
 
 	c.Assert(rec.Code, qt.Equals, 400)
 	c.Assert(rec.Body.String(), qt.Contains, "This is a proxy server")
@@ -118,7 +118,7 @@ func TestEntryServeHTTPWithProxyAuthRequiresAuthentication(t *testing.T) {
 	ctx := proxycontext.WithConnContext(req.Context(), connCtx)
 	req = req.WithContext(ctx)
 
-	p.entry.ServeHTTP(rec, req)
+	p.entries[0].ServeHTTP(rec, req)
 
 	c.Assert(rec.Code, qt.Equals, http.StatusProxyAuthRequired)
 }
@@ -150,7 +150,7 @@ func TestEntryServeHTTPWithValidProxyAuthProceeds(t *testing.T) {
 	ctx := proxycontext.WithConnContext(req.Context(), connCtx)
 	req = req.WithContext(ctx)
 
-	p.entry.ServeHTTP(rec, req)
+	p.entries[0].ServeHTTP(rec, req)
 
 	c.Assert(addon.requestheadersCalled, qt.IsTrue)
 }
@@ -195,7 +195,7 @@ func TestEntryServeHTTPCONNECTRequestTriggersRequestheaders(t *testing.T) {
 	ctx := proxycontext.WithConnContext(req.Context(), connCtx)
 	req = req.WithContext(ctx)
 
-	p.entry.ServeHTTP(rec, req)
+	p.entries[0].ServeHTTP(rec, req)
 
 	c.Assert(addon.requestheadersCalled, qt.IsTrue)
 }
@@ -6,7 +6,9 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/conn"
 )
@@ -36,14 +38,45 @@ func logErr(logger *slog.Logger, err error) {
 	logger.Error("unexpected error", "error", err)
 }
 
-// Transfer traffic.
-func transfer(logger *slog.Logger, server, client io.ReadWriteCloser) {
+// tunnelChunk is how many bytes a tunnel copies at most before counting them, so that the
+// counters follow long-lived tunnels. A splice of a chunk only returns once it is
+// complete, so smaller chunks would cost more system calls.
+const tunnelChunk = 1 << 20
+
+// Transfer traffic. The bytes sent to the server and received from it are added to sent
+// and received every tunnelChunk bytes and at the end. Between two TCP connections, io.Copy moves them
+// within the kernel with splice(2) on Linux, without copying them through userspace.
+func transfer(logger *slog.Logger, server, client io.ReadWriteCloser, sent, received *atomic.Int64) {
 	done := make(chan struct{})
 	defer close(done)
 
+	var serverReader, clientReader io.Reader = server, client
+	var serverWriter, clientWriter io.Writer = server, client
+	var readAhead []byte
+	if wcc, ok := client.(*conn.WrapClientConn); ok {
+		if tcpConn, ahead, ok := wcc.TunnelConn(); ok {
+			clientReader, clientWriter, readAhead = tcpConn, tcpConn, ahead
+		}
+	}
+	switch sc := server.(type) {
+	case *conn.WrapServerConn:
+		if tcpConn, ok := sc.TunnelConn(); ok {
+			serverReader, serverWriter = tcpConn, tcpConn
+		}
+	case *net.TCPConn:
+		serverReader, serverWriter = sc, sc
+	}
+
 	errChan := make(chan error)
 	go func() {
-		_, err := io.Copy(server, client)
+		var err error
+		if len(readAhead) > 0 {
+			_, err = serverWriter.Write(readAhead)
+			sent.Add(int64(len(readAhead)))
+		}
+		if err == nil {
+			_, err = copyCounted(serverWriter, clientReader, sent)
+		}
 		logger.Debug("client copy end", "error", err)
 		client.Close()
 		select {
@@ -54,14 +87,14 @@ func transfer(logger *slog.Logger, server, client io.ReadWriteCloser) {
 		}
 	}()
 	go func() {
-		_, err := io.Copy(client, server)
+		_, err := copyCounted(clientWriter, serverReader, received)
 		logger.Debug("server copy end", "error", err)
 		server.Close()
 
 		if clientConn, ok := client.(*conn.WrapClientConn); ok {
-			if tcpConn, ok := clientConn.Conn.(*net.TCPConn); ok {
+			if tcpConn, ok := clientConn.TCPConn(); ok {
 				err := tcpConn.CloseRead()
-				logger.Debug("clientConn.Conn.(*net.TCPConn).CloseRead()", "error", err)
+				logger.Debug("clientConn.TCPConn().CloseRead()", "error", err)
 			}
 		}
 
@@ -81,9 +114,46 @@ func transfer(logger *slog.Logger, server, client io.ReadWriteCloser) {
 	}
 }
 
+// copyCounted copies src to dst until EOF like io.Copy, adding the bytes to counter
+// every tunnelChunk bytes. The limited readers of the chunks are still spliced.
+func copyCounted(dst io.Writer, src io.Reader, counter *atomic.Int64) (int64, error) {
+	var written int64
+	for {
+		n, err := io.Copy(dst, io.LimitReader(src, tunnelChunk))
+		written += n
+		counter.Add(n)
+		if err != nil || n < tunnelChunk {
+			return written, err
+		}
+	}
+}
+
 func httpError(w http.ResponseWriter, errMsg string, code int) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`) // Indicates that the proxy server requires client credentials
 	w.WriteHeader(code)
 	fmt.Fprintln(w, errMsg)
 }
+
+// normalizeRequestURL repairs absolute-form URLs that some plain HTTP proxy clients get wrong:
+//   - "//host/path" without a scheme
+//   - "host:port/path" without a scheme, which is parsed as scheme "host"
+//
+// Both are rewritten to "http://host/path". Origin-form requests ("/path") are left alone.
+func normalizeRequestURL(req *http.Request) {
+	u := req.URL
+	var raw string
+	switch {
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "//"):
+		raw = "http:" + req.RequestURI
+	case u.Scheme != "" && u.Opaque != "" && u.Scheme != "http" && u.Scheme != "https":
+		raw = "http://" + req.RequestURI
+	default:
+		return
+	}
+	nu, err := url.Parse(raw)
+	if err != nil || nu.Host == "" {
+		return
+	}
+	req.URL = nu
+}
@@ -1,39 +1,88 @@
 package addonregistry
 
 import (
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
 )
 
 // Registry manages a collection of addons and provides thread-safe access to them.
+// Addons are ordered by ascending priority; addons of the same priority keep the
+// order they were added in.
+//
+// The list is copy-on-write: changes build a new list, so reading it never waits for
+// them and addons can be added and removed while flows are in flight.
 type Registry struct {
-	addons []types.Addon
-	mu     sync.RWMutex
+	mu      sync.Mutex // serializes changes
+	current atomic.Pointer[list]
+}
+
+type list struct {
+	addons     []types.Addon
+	priorities []int // priorities[i] is the priority of addons[i]
 }
 
 // New creates a new Registry instance.
 func New() *Registry {
-	return &Registry{
-		addons: make([]types.Addon, 0),
-	}
+	r := &Registry{}
+	r.current.Store(&list{addons: make([]types.Addon, 0)})
+	return r
 }
 
-// Add adds a new addon to the registry.
+// Add adds a new addon to the registry with priority 0.
 // This method is thread-safe.
 func (r *Registry) Add(addon types.Addon) {
+	r.AddWithPriority(addon, 0)
+}
+
+// AddWithPriority adds a new addon to the registry after the addons with a priority
+// lower than or equal to priority and before the others.
+// This method is thread-safe.
+func (r *Registry) AddWithPriority(addon types.Addon, priority int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := r.current.Load()
+	i := len(cur.priorities)
+	for i > 0 && cur.priorities[i-1] > priority {
+		i--
+	}
+	r.current.Store(&list{
+		addons:     slices.Insert(slices.Clip(cur.addons), i, addon),
+		priorities: slices.Insert(slices.Clip(cur.priorities), i, priority),
+	})
+}
+
+// Remove removes an addon from the registry and reports whether it was there.
+// Events already being dispatched when it is removed may still reach the addon.
+// This method is thread-safe.
+func (r *Registry) Remove(addon types.Addon) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.addons = append(r.addons, addon)
+	cur := r.current.Load()
+	i := slices.Index(cur.addons, addon)
+	if i < 0 {
+		return false
+	}
+	r.current.Store(&list{
+		addons:     slices.Delete(slices.Clone(cur.addons), i, i+1),
+		priorities: slices.Delete(slices.Clone(cur.priorities), i, i+1),
+	})
+	return true
 }
 
 // Get returns a copy of the current addon list.
 // This method is thread-safe.
 func (r *Registry) Get() []types.Addon {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
 	// Return a copy to prevent external modification
-	result := make([]types.Addon, len(r.addons))
-	copy(result, r.addons)
-	return result
+	return slices.Clone(r.View())
+}
+
+// View returns the current addon list without copying it, for dispatching events.
+// The list is shared and must not be modified; later changes to the registry do not
+// affect it.
+// This method is thread-safe.
+func (r *Registry) View() []types.Addon {
+	return r.current.Load().addons
 }
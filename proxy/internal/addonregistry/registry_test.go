@@ -1,6 +1,7 @@
 package addonregistry_test
 
 import (
+	"sync"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -47,3 +48,108 @@ func TestRegistryGetReturnsCopy(t *testing.T) {
 	c.Assert(len(secondSnapshot), qt.Equals, 1)
 	c.Assert(secondSnapshot[0].(*testAddon).name, qt.Equals, "only")
 }
+
+func TestRegistryRemove(t *testing.T) {
+	c := qt.New(t)
+
+	reg := addonregistry.New()
+	first := &testAddon{name: "first"}
+	second := &testAddon{name: "second"}
+	reg.Add(first)
+	reg.Add(second)
+	snapshot := reg.Get()
+
+	c.Assert(reg.Remove(first), qt.IsTrue)
+	c.Assert(reg.Remove(first), qt.IsFalse)
+
+	addons := reg.Get()
+	c.Assert(len(addons), qt.Equals, 1)
+	c.Assert(addons[0].(*testAddon).name, qt.Equals, "second")
+	// earlier copies are left alone
+	c.Assert(snapshot[0].(*testAddon).name, qt.Equals, "first")
+}
+
+func TestRegistryAddWithPriority(t *testing.T) {
+	c := qt.New(t)
+
+	reg := addonregistry.New()
+	reg.Add(&testAddon{name: "default"})
+	reg.AddWithPriority(&testAddon{name: "late"}, 10)
+	reg.AddWithPriority(&testAddon{name: "early"}, -10)
+	reg.Add(&testAddon{name: "default2"})
+	reg.AddWithPriority(&testAddon{name: "early2"}, -10)
+	reg.AddWithPriority(&testAddon{name: "earliest"}, -20)
+
+	var names []string
+	for _, addon := range reg.Get() {
+		names = append(names, addon.(*testAddon).name)
+	}
+	c.Assert(names, qt.DeepEquals, []string{"earliest", "early", "early2", "default", "default2", "late"})
+
+	c.Assert(reg.Remove(reg.Get()[1]), qt.IsTrue)
+	reg.AddWithPriority(&testAddon{name: "early3"}, -10)
+	names = nil
+	for _, addon := range reg.Get() {
+		names = append(names, addon.(*testAddon).name)
+	}
+	c.Assert(names, qt.DeepEquals, []string{"earliest", "early2", "early3", "default", "default2", "late"})
+}
+
+func TestRegistryViewIsNotAffectedByChanges(t *testing.T) {
+	c := qt.New(t)
+
+	reg := addonregistry.New()
+	first := &testAddon{name: "first"}
+	reg.Add(first)
+	view := reg.View()
+
+	reg.AddWithPriority(&testAddon{name: "earlier"}, -1)
+	reg.Remove(first)
+
+	c.Assert(len(view), qt.Equals, 1)
+	c.Assert(view[0].(*testAddon).name, qt.Equals, "first")
+	c.Assert(len(reg.View()), qt.Equals, 1)
+	c.Assert(reg.View()[0].(*testAddon).name, qt.Equals, "earlier")
+}
+
+func TestRegistryConcurrentChanges(t *testing.T) {
+	c := qt.New(t)
+
+	reg := addonregistry.New()
+	stable := &testAddon{name: "stable"}
+	reg.Add(stable)
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				addon := &testAddon{name: "toggled"}
+				reg.AddWithPriority(addon, i-2)
+				reg.Remove(addon)
+			}
+		}()
+	}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				found := false
+				for _, addon := range reg.View() {
+					found = found || addon == stable
+				}
+				if !found {
+					t.Error("stable addon missing")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	addons := reg.Get()
+	c.Assert(len(addons), qt.Equals, 1)
+	c.Assert(addons[0] == types.Addon(stable), qt.IsTrue)
+}
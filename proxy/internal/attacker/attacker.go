@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/http2"
 
 	"github.com/denisvmedia/go-mitmproxy/cert"
@@ -25,22 +32,47 @@ import (
 // through a channel. It is used internally by the Attacker to handle intercepted
 // HTTP/1.1 connections.
 type listener struct {
-	connChan chan net.Conn
+	connChan  chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newListener creates a listener ready to accept connections.
+func newListener() *listener {
+	return &listener{
+		connChan: make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
 }
 
 // accept sends a connection to the listener's channel for processing.
+// If the listener has been closed, the connection is closed instead.
 func (l *listener) accept(c net.Conn) {
-	l.connChan <- c
+	select {
+	case l.connChan <- c:
+	case <-l.done:
+		c.Close()
+	}
 }
 
 // Accept waits for and returns the next connection to the listener.
+// It returns net.ErrClosed once the listener has been closed.
 func (l *listener) Accept() (net.Conn, error) {
-	c := <-l.connChan
-	return c, nil
+	select {
+	case c := <-l.connChan:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
 }
 
-// Close closes the listener. This is a no-op for listener.
-func (*listener) Close() error { return nil }
+// Close closes the listener, unblocking any pending Accept.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
 
 // Addr returns the listener's network address. This returns nil for listener.
 func (*listener) Addr() net.Addr { return nil }
@@ -64,9 +96,28 @@ type Attacker struct {
 	wsHandler          *websocket.Handler
 	server             *http.Server
 	h2Server           *http2.Server
+	h3Server           *http3.Server
+	h3Client           *http.Client // upstream client for flows intercepted over HTTP/3
 	client             *http.Client
+	http1Client        *http.Client // separate client restricted to HTTP/1.1, nil if not needed
 	listener           *listener
 	clientFactory      types.ClientFactory
+	transformRequest   func(*types.Request)
+	transformResponse  func(*types.Response)
+
+	matchUpstreamProtocol bool
+	shouldInterceptSNI    func(serverName string) bool
+	serverHeader          string
+	flowLimiter           *flowLimiter
+	responseBodyLimits    map[string]int64
+	truncateLimitedBodies bool
+	mimicClientHello      bool
+	clientCertificates    types.ClientCertificates
+	clientAuth            tls.ClientAuthType
+	clientCAs             *x509.CertPool
+	wire                  *conn.WireCapture
+	spoolLargeBodies      int64
+	spoolDir              string
 }
 
 // Args contains all dependencies required by the Attacker.
@@ -88,6 +139,61 @@ type Args struct {
 	// ClientFactory is used to create HTTP clients for different scenarios.
 	// If nil, DefaultClientFactory will be used.
 	ClientFactory types.ClientFactory
+
+	// TransformRequest is called before the request is forwarded upstream, after the Request addon event.
+	TransformRequest func(*types.Request)
+
+	// TransformResponse is called before the response is written to the client, after all addons.
+	TransformResponse func(*types.Response)
+
+	// MatchUpstreamProtocol restricts the upstream ALPN offer and the separate client to the
+	// HTTP version used by the client.
+	MatchUpstreamProtocol bool
+
+	// ServerHeader is the Server header of responses generated by the proxy itself.
+	// Empty sends no Server header.
+	ServerHeader string
+
+	// MaxConcurrentFlows limits the number of flows handled at the same time; zero means unlimited.
+	// Up to MaxQueuedFlows flows beyond the limit wait for a free slot, others get 503.
+	MaxConcurrentFlows int
+	MaxQueuedFlows     int
+
+	// ResponseBodyLimits maps media types ("application/json", "video/*" or "*/*") to
+	// response body size limits in bytes. Bodies over their limit are rejected with 502,
+	// or cut at the limit when TruncateLimitedBodies is set.
+	ResponseBodyLimits    map[string]int64
+	TruncateLimitedBodies bool
+
+	// MimicClientHello replays the fingerprint of the client's ClientHello in the upstream
+	// TLS handshake.
+	MimicClientHello bool
+
+	// ClientCertificates are presented to upstream servers asking for a client certificate.
+	// They are also given to the default client factory.
+	ClientCertificates types.ClientCertificates
+
+	// ClientAuth and ClientCAs set the client certificate policy of intercepted TLS
+	// handshakes with clients.
+	ClientAuth tls.ClientAuthType
+	ClientCAs  *x509.CertPool
+
+	// WireCapture records the bytes of the intercepted client connections and of their
+	// upstream connections. Nil captures nothing.
+	WireCapture *conn.WireCapture
+
+	// MaxIdleConnsPerHost and IdleConnTimeout are given to the default client factory.
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	// HTTP3 prepares the attacker to serve the HTTP/3 listener with ServeHTTP3.
+	HTTP3 bool
+
+	// SpoolLargeBodies is the size up to which bodies larger than StreamLargeBodies are
+	// spooled to temporary files in SpoolDir, for the Request and Response addon events
+	// to see them, instead of being streamed. Zero spools none.
+	SpoolLargeBodies int64
+	SpoolDir         string
 }
 
 // New creates a new Attacker instance with the given dependencies.
@@ -97,7 +203,11 @@ func New(args Args) (*Attacker, error) {
 	// Use default client factory if none provided
 	clientFactory := args.ClientFactory
 	if clientFactory == nil {
-		clientFactory = NewDefaultClientFactory()
+		clientFactory = &types.DefaultClientFactory{
+			ClientCertificates:  args.ClientCertificates,
+			MaxIdleConnsPerHost: args.MaxIdleConnsPerHost,
+			IdleConnTimeout:     args.IdleConnTimeout,
+		}
 	}
 
 	atk := &Attacker{
@@ -108,9 +218,22 @@ func New(args Args) (*Attacker, error) {
 		insecureSkipVerify: args.InsecureSkipVerify,
 		wsHandler:          args.WSHandler,
 		clientFactory:      clientFactory,
-		listener: &listener{
-			connChan: make(chan net.Conn),
-		},
+		transformRequest:   args.TransformRequest,
+		transformResponse:  args.TransformResponse,
+		listener:           newListener(),
+
+		matchUpstreamProtocol: args.MatchUpstreamProtocol,
+		serverHeader:          args.ServerHeader,
+		flowLimiter:           newFlowLimiter(args.MaxConcurrentFlows, args.MaxQueuedFlows),
+		responseBodyLimits:    normalizeBodyLimits(args.ResponseBodyLimits),
+		truncateLimitedBodies: args.TruncateLimitedBodies,
+		mimicClientHello:      args.MimicClientHello,
+		clientCertificates:    args.ClientCertificates,
+		clientAuth:            args.ClientAuth,
+		clientCAs:             args.ClientCAs,
+		wire:                  args.WireCapture,
+		spoolLargeBodies:      args.SpoolLargeBodies,
+		spoolDir:              args.SpoolDir,
 	}
 
 	// Client #1: Main fallback/separate client
@@ -118,6 +241,9 @@ func New(args Args) (*Attacker, error) {
 	// UseSeparateClient is set. This client goes through the upstream proxy and supports
 	// HTTP/2. It creates new connections rather than reusing existing ones.
 	atk.client = atk.clientFactory.CreateMainClient(atk.upstreamManager, args.InsecureSkipVerify)
+	if atk.matchUpstreamProtocol {
+		atk.http1Client = newHTTP1Client(atk.client)
+	}
 
 	atk.server = &http.Server{
 		Handler: atk,
@@ -131,6 +257,10 @@ func New(args Args) (*Attacker, error) {
 		NewWriteScheduler:    func() http2.WriteScheduler { return http2.NewPriorityWriteScheduler(nil) },
 	}
 
+	if args.HTTP3 {
+		atk.initHTTP3(args)
+	}
+
 	return atk, nil
 }
 
@@ -140,20 +270,58 @@ func (a *Attacker) Start() error {
 	return a.server.Serve(a.listener)
 }
 
+// Close immediately stops the attacker's server and closes its listener,
+// which makes Start return http.ErrServerClosed.
+func (a *Attacker) Close() error {
+	err := a.server.Close()
+	if a.h3Server != nil {
+		err = errors.Join(err, a.h3Server.Close())
+	}
+	a.listener.Close()
+	return err
+}
+
+// Shutdown gracefully stops the attacker's server, waiting for active
+// connections to become idle until ctx is done.
+func (a *Attacker) Shutdown(ctx context.Context) error {
+	err := a.server.Shutdown(ctx)
+	if a.h3Server != nil {
+		err = errors.Join(err, a.h3Server.Shutdown(ctx))
+	}
+	a.listener.Close()
+	return err
+}
+
+// SetKeepAlivesEnabled controls whether the intercepted HTTP/1.1 connections are kept
+// alive, disabling them makes the responses carry Connection: close.
+func (a *Attacker) SetKeepAlivesEnabled(v bool) {
+	a.server.SetKeepAlivesEnabled(v)
+}
+
 // NotifyClientDisconnected implements conn.AddonNotifier.
 func (a *Attacker) NotifyClientDisconnected(client *conn.ClientConn) {
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		addon.ClientDisconnected(client)
 	}
 }
 
 // NotifyServerDisconnected implements conn.AddonNotifier.
 func (a *Attacker) NotifyServerDisconnected(connCtx *conn.Context) {
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		addon.ServerDisconnected(connCtx)
 	}
 }
 
+// SeparateClientStats returns the statistics of the connections of the separate client,
+// false when they are unknown because the client factory does not count them.
+func (a *Attacker) SeparateClientStats() (types.ClientPoolStats, bool) {
+	counter, ok := a.clientFactory.(interface{ MainClientPoolStats() types.ClientPoolStats })
+	if !ok {
+		return types.ClientPoolStats{}, false
+	}
+	return counter.MainClientPoolStats(), true
+}
+
 // Addon interface methods that forward to the actual addon implementations.
 func (a *Attacker) ClientDisconnected(client *conn.ClientConn) {
 	// This is called by the wrapper, we forward to addons
@@ -170,14 +338,21 @@ func (a *Attacker) ServerDisconnected(connCtx *conn.Context) {
 // to the appropriate handler. For HTTP/2, it sets up an HTTP/2 server connection.
 // For HTTP/1.1, it passes the connection to the HTTP/1.1 listener.
 func (a *Attacker) serveConn(clientTLSConn *tls.Conn, connCtx *conn.Context) {
-	connCtx.ClientConn.NegotiatedProtocol = clientTLSConn.ConnectionState().NegotiatedProtocol
+	clientTLSState := clientTLSConn.ConnectionState()
+	connCtx.ClientConn.NegotiatedProtocol = clientTLSState.NegotiatedProtocol
+	connCtx.ClientConn.PeerCertificates = clientTLSState.PeerCertificates
+	connCtx.ClientConn.TLSState = &clientTLSState
 
 	if connCtx.ClientConn.NegotiatedProtocol == "h2" && connCtx.ServerConn != nil {
 		// Client #2: HTTP/2 server connection client
 		// Purpose: Created specifically for HTTP/2 connections when the negotiated protocol
 		// is "h2". Uses http2.Transport and reuses the existing TLS connection
 		// (connCtx.ServerConn.TLSConn) rather than creating new connections.
-		connCtx.ServerConn.Client = a.clientFactory.CreateHTTP2Client(connCtx.ServerConn.TLSConn)
+		var serverTLSConn net.Conn = connCtx.ServerConn.UTLSConn
+		if connCtx.ServerConn.TLSConn != nil {
+			serverTLSConn = connCtx.ServerConn.TLSConn
+		}
+		connCtx.ServerConn.Client = a.createHTTP2Client(a.wire.ServerConn(serverTLSConn, connCtx))
 
 		ctx := proxycontext.WithConnContext(context.Background(), connCtx)
 		ctx, cancel := context.WithCancel(ctx)
@@ -186,7 +361,7 @@ func (a *Attacker) serveConn(clientTLSConn *tls.Conn, connCtx *conn.Context) {
 			cancel()
 		}()
 		go func() {
-			a.h2Server.ServeConn(clientTLSConn, &http2.ServeConnOpts{
+			a.h2Server.ServeConn(a.wire.ClientConn(clientTLSConn, connCtx), &http2.ServeConnOpts{
 				Context:    ctx,
 				Handler:    a,
 				BaseConfig: a.server,
@@ -196,7 +371,7 @@ func (a *Attacker) serveConn(clientTLSConn *tls.Conn, connCtx *conn.Context) {
 	}
 
 	a.listener.accept(&attackerConn{
-		Conn:    clientTLSConn,
+		Conn:    a.wire.ClientConn(clientTLSConn, connCtx),
 		connCtx: connCtx,
 	})
 }
@@ -205,14 +380,36 @@ func (a *Attacker) serveConn(clientTLSConn *tls.Conn, connCtx *conn.Context) {
 // It handles incoming HTTP requests, including WebSocket upgrades and regular HTTP/HTTPS requests.
 // This method ensures the request URL is properly formatted before passing it to the Attack method.
 func (a *Attacker) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// Responses generated by the proxy carry the configured Server header,
+	// proxied responses replace it with the upstream one.
+	if a.serverHeader != "" {
+		res.Header().Set("Server", a.serverHeader)
+	}
+
+	// Connections handed over by HTTPAttack are not TLS.
+	plain := false
+	connCtx, ok := proxycontext.GetConnContext(req.Context())
+	if ok {
+		plain = !connCtx.ClientConn.TLS
+	}
+
 	if strings.EqualFold(req.Header.Get("Connection"), "Upgrade") && strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
-		// wss
-		a.wsHandler.HandleWSS(res, req)
+		if plain && connCtx.ServerConn != nil && connCtx.FlowCount.Load() == 0 {
+			// the connection dialed first by HTTPAttack, no flow has used it yet
+			a.wsHandler.HandleWSConn(res, req, connCtx.ServerConn.Conn)
+		} else if plain {
+			a.wsHandler.HandleWS(res, req)
+		} else {
+			a.wsHandler.HandleWSS(res, req)
+		}
 		return
 	}
 
 	if req.URL.Scheme == "" {
 		req.URL.Scheme = "https"
+		if plain {
+			req.URL.Scheme = "http"
+		}
 	}
 	if req.URL.Host == "" {
 		req.URL.Host = req.Host
@@ -243,10 +440,10 @@ func (a *Attacker) InitHTTPDialFn(req *http.Request) {
 		// Purpose: Created for plain HTTP (non-TLS) connections. Explicitly disables HTTP/2
 		// and reuses the existing plain connection (cw) via custom DialContext function.
 		// This avoids creating new connections for each request on the same HTTP connection.
-		serverConn.Client = a.clientFactory.CreatePlainHTTPClient(cw)
+		serverConn.Client = a.clientFactory.CreatePlainHTTPClient(a.wire.ServerConn(cw, connCtx))
 
 		connCtx.ServerConn = serverConn
-		for _, addon := range a.addonRegistry.Get() {
+		for _, addon := range a.addonRegistry.View() {
 			addon.ServerConnected(connCtx)
 		}
 
@@ -269,6 +466,13 @@ func (a *Attacker) serverTLSHandshake(ctx context.Context, connCtx *conn.Context
 		// CurvePreferences:   clientHello.SupportedCurves, // todo: will cause errors if enabled
 		CipherSuites: clientHello.CipherSuites,
 	}
+	a.clientCertificates.Apply(serverTLSConfig, types.UpstreamAddress(serverConn.Address, "https"))
+	// In lazy mode the client handshake is already done, and uTLS connections are not
+	// recognized by http.Transport as TLS, so it cannot switch to HTTP/2 by itself;
+	// offer upstream only what the client got.
+	if (a.matchUpstreamProtocol || a.mimicClientHello) && connCtx.ClientConn.NegotiatedProtocol != "" {
+		serverTLSConfig.NextProtos = []string{connCtx.ClientConn.NegotiatedProtocol}
+	}
 	if len(clientHello.SupportedVersions) > 0 {
 		minVersion := clientHello.SupportedVersions[0]
 		maxVersion := clientHello.SupportedVersions[0]
@@ -283,14 +487,39 @@ func (a *Attacker) serverTLSHandshake(ctx context.Context, connCtx *conn.Context
 		serverTLSConfig.MinVersion = minVersion
 		serverTLSConfig.MaxVersion = maxVersion
 	}
-	serverTLSConn := tls.Client(serverConn.Conn, serverTLSConfig)
-	serverConn.TLSConn = serverTLSConn
-	if err := serverTLSConn.HandshakeContext(ctx); err != nil {
-		return err
+
+	var serverTLSConn net.Conn
+	var serverTLSState *tls.ConnectionState
+	if raw := connCtx.ClientConn.RawClientHello; a.mimicClientHello && raw != nil {
+		spec, err := clientHelloSpec(raw, serverTLSConfig)
+		if err == nil {
+			uconn, err := uTLSHandshake(ctx, serverConn.Conn, spec, serverTLSConfig)
+			if err != nil {
+				return err
+			}
+			serverConn.UTLSConn = uconn
+			serverTLSConn, serverTLSState = uconn, uTLSConnectionState(uconn.ConnectionState())
+		} else {
+			slog.Debug("cannot mimic client hello, using go tls", "sni", clientHello.ServerName, "error", err)
+		}
+	}
+	if serverTLSConn == nil {
+		tlsConn := tls.Client(serverConn.Conn, serverTLSConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		state := tlsConn.ConnectionState()
+		serverConn.TLSConn = tlsConn
+		serverTLSConn, serverTLSState = tlsConn, &state
+	}
+	// the handshake is not done by http.Transport, which would report it to the trace
+	if trace := httptrace.ContextClientTrace(ctx); trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(*serverTLSState, nil)
 	}
-	serverTLSState := serverTLSConn.ConnectionState()
-	serverConn.TLSState = &serverTLSState
-	for _, addon := range a.addonRegistry.Get() {
+	serverConn.RecordTLSEstablished(time.Now())
+	serverConn.TLSState = serverTLSState
+	serverConn.PeerCertificates = serverTLSState.PeerCertificates
+	for _, addon := range a.addonRegistry.View() {
 		addon.TLSEstablishedServer(connCtx)
 	}
 
@@ -298,7 +527,13 @@ func (a *Attacker) serverTLSHandshake(ctx context.Context, connCtx *conn.Context
 	// Purpose: Created for HTTPS connections after TLS handshake. Reuses the established
 	// TLS connection (serverTLSConn) via custom DialTLSContext function and allows HTTP/2
 	// negotiation. This maintains persistent connections to upstream servers.
-	serverConn.Client = a.clientFactory.CreateHTTPSClient(serverTLSConn)
+	// http.Transport only switches to HTTP/2 on a *tls.Conn, so those connections are
+	// not captured
+	clientConn := serverTLSConn
+	if serverTLSState.NegotiatedProtocol != "h2" {
+		clientConn = a.wire.ServerConn(serverTLSConn, connCtx)
+	}
+	serverConn.Client = a.createHTTPSClient(clientConn)
 
 	return nil
 }
@@ -333,16 +568,18 @@ func (a *Attacker) HTTPSDial(ctx context.Context, req *http.Request) (net.Conn,
 		panic("failed to get ConnContext from request context")
 	}
 
+	start := time.Now()
 	plainConn, err := a.upstreamManager.GetUpstreamConn(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
 	serverConn := conn.NewServerConn()
+	serverConn.RecordDial(start, time.Now())
 	serverConn.Address = req.Host
 	serverConn.Conn = conn.NewWrapServerConn(plainConn, connCtx, a)
 	connCtx.ServerConn = serverConn
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		addon.ServerConnected(connCtx)
 	}
 
@@ -368,6 +605,10 @@ func (a *Attacker) HTTPSTLSDial(ctx context.Context, cconn, sconn net.Conn) {
 		"host", connCtx.ClientConn.Conn.RemoteAddr().String(),
 	)
 
+	if a.mimicClientHello {
+		connCtx.ClientConn.RawClientHello = peekClientHello(cconn)
+	}
+
 	var clientHello *tls.ClientHelloInfo
 	clientHelloChan := make(chan *tls.ClientHelloInfo)
 	serverTLSStateChan := make(chan *tls.ConnectionState)
@@ -399,6 +640,8 @@ func (a *Attacker) HTTPSTLSDial(ctx context.Context, cconn, sconn net.Conn) {
 				SessionTicketsDisabled: true,
 				Certificates:           []tls.Certificate{*c},
 				NextProtos:             nextProtos,
+				ClientAuth:             a.clientAuth,
+				ClientCAs:              a.clientCAs,
 			}, nil
 		},
 	})
@@ -419,7 +662,7 @@ func (a *Attacker) HTTPSTLSDial(ctx context.Context, cconn, sconn net.Conn) {
 		return
 	case clientHello = <-clientHelloChan:
 	}
-	connCtx.ClientConn.ClientHello = clientHello
+	connCtx.ClientConn.SetClientHello(clientHello)
 
 	if err := a.serverTLSHandshake(ctx, connCtx); err != nil {
 		cconn.Close()
@@ -444,11 +687,61 @@ func (a *Attacker) HTTPSTLSDial(ctx context.Context, cconn, sconn net.Conn) {
 	a.serveConn(clientTLSConn, connCtx)
 }
 
+// ActiveFlows returns the number of flows currently being handled.
+func (a *Attacker) ActiveFlows() int64 {
+	return a.flowLimiter.active.Load()
+}
+
+// QueuedFlows returns the number of flows waiting for a slot when MaxConcurrentFlows is set.
+func (a *Attacker) QueuedFlows() int64 {
+	return a.flowLimiter.queued.Load()
+}
+
+// SetShouldInterceptSNIRule sets a rule that is checked in HTTPSLazyAttack with the SNI
+// from the client's ClientHello. Connections whose SNI is rejected are not intercepted.
+// Connections without SNI are always intercepted.
+func (a *Attacker) SetShouldInterceptSNIRule(rule func(serverName string) bool) {
+	a.shouldInterceptSNI = rule
+}
+
+// peekClientHello returns the client's first TLS record, which carries its ClientHello,
+// without consuming it. It returns nil if the record cannot be read.
+func peekClientHello(cconn net.Conn) []byte {
+	wcc, ok := cconn.(*conn.WrapClientConn)
+	if !ok {
+		return nil
+	}
+	header, err := wcc.Peek(5)
+	if err != nil {
+		return nil
+	}
+	record, err := wcc.Peek(5 + int(binary.BigEndian.Uint16(header[3:5])))
+	if err != nil {
+		return nil
+	}
+	return append([]byte(nil), record...)
+}
+
+// peekServerName reads the SNI from the client's first TLS record without consuming it.
+func peekServerName(cconn net.Conn) (string, bool) {
+	record := peekClientHello(cconn)
+	if record == nil {
+		return "", false
+	}
+	serverName, err := helper.ClientHelloServerName(record)
+	if err != nil || serverName == "" {
+		return "", false
+	}
+	return serverName, true
+}
+
 // HTTPSLazyAttack performs a lazy MITM TLS handshake for HTTPS connections.
 // Unlike HttpsTLSDial, this method only performs the client TLS handshake without
 // immediately connecting to the upstream server. The server connection is established
 // lazily when the first request is made. This approach only supports HTTP/1.1.
-func (a *Attacker) HTTPSLazyAttack(ctx context.Context, cconn net.Conn, req *http.Request) {
+// It returns false, leaving cconn untouched, when the SNI rule declines interception;
+// the caller is then responsible for tunneling the connection.
+func (a *Attacker) HTTPSLazyAttack(ctx context.Context, cconn net.Conn, req *http.Request) bool {
 	connCtx, ok := proxycontext.GetConnContext(ctx)
 	if !ok {
 		panic("failed to get ConnContext from request context")
@@ -458,10 +751,22 @@ func (a *Attacker) HTTPSLazyAttack(ctx context.Context, cconn net.Conn, req *htt
 		"host", connCtx.ClientConn.Conn.RemoteAddr().String(),
 	)
 
+	if a.shouldInterceptSNI != nil {
+		if serverName, ok := peekServerName(cconn); ok && !a.shouldInterceptSNI(serverName) {
+			logger.Debug("skip intercept by sni", "sni", serverName)
+			connCtx.Intercept = false
+			return false
+		}
+	}
+
+	if a.mimicClientHello {
+		connCtx.ClientConn.RawClientHello = peekClientHello(cconn)
+	}
+
 	clientTLSConn := tls.Server(cconn, &tls.Config{
 		SessionTicketsDisabled: true, // Set this to true to ensure GetConfigForClient is called every time
 		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
-			connCtx.ClientConn.ClientHello = chi
+			connCtx.ClientConn.SetClientHello(chi)
 			c, err := a.ca.GetCert(chi.ServerName)
 			if err != nil {
 				return nil, err
@@ -470,37 +775,92 @@ func (a *Attacker) HTTPSLazyAttack(ctx context.Context, cconn net.Conn, req *htt
 				SessionTicketsDisabled: true,
 				Certificates:           []tls.Certificate{*c},
 				NextProtos:             []string{"http/1.1"}, // only support http/1.1
+				ClientAuth:             a.clientAuth,
+				ClientCAs:              a.clientCAs,
 			}, nil
 		},
 	})
 	if err := clientTLSConn.HandshakeContext(ctx); err != nil {
 		cconn.Close()
 		logger.Error("client handshake failed", "error", err)
-		return
+		return true
 	}
 
 	// will go to Attacker.ServeHTTP
 	a.InitHTTPSDialFn(req)
 	a.serveConn(clientTLSConn, connCtx)
+	return true
+}
+
+// HTTPAttack intercepts plain HTTP and ws:// traffic sent through a CONNECT tunnel.
+// The requests read from cconn go to Attacker.ServeHTTP like decrypted HTTPS requests.
+// If the upstream connection was already dialed (HTTPSDial) it is reused, otherwise
+// it is dialed when the first request is made.
+func (a *Attacker) HTTPAttack(ctx context.Context, cconn net.Conn, req *http.Request) {
+	connCtx, ok := proxycontext.GetConnContext(ctx)
+	if !ok {
+		panic("failed to get ConnContext from request context")
+	}
+
+	if connCtx.ServerConn != nil {
+		connCtx.ServerConn.Client = a.clientFactory.CreatePlainHTTPClient(a.wire.ServerConn(connCtx.ServerConn.Conn, connCtx))
+	} else {
+		a.InitHTTPDialFn(req)
+	}
+
+	// will go to Attacker.ServeHTTP
+	a.listener.accept(&attackerConn{
+		Conn:    a.wire.ClientConn(cconn, connCtx),
+		connCtx: connCtx,
+	})
 }
 
 // executeProxyRequest creates and executes the proxy request to the upstream server.
 // It handles both separate client mode (for modified requests) and connection reuse mode.
 // The method returns the upstream server's response or an error if the request fails.
-func (a *Attacker) executeProxyRequest(f *types.Flow, req *http.Request, reqBody io.Reader, rawReqURLHost, rawReqURLScheme string, res http.ResponseWriter, logger *slog.Logger) (*http.Response, error) {
-	proxyReqCtx := proxycontext.WithProxyRequest(req.Context(), req)
+// ctx is canceled when the flow is killed.
+func (a *Attacker) executeProxyRequest(ctx context.Context, f *types.Flow, req *http.Request, reqBody io.Reader, rawReqURLHost, rawReqURLScheme string, logger *slog.Logger) (*http.Response, error) {
+	// Streamed bodies only get their trailer values once they have been read to the end,
+	// the keys are announced up front.
+	var trailer http.Header
+	if f.Stream {
+		if len(req.Trailer) > 0 {
+			trailer = make(http.Header, len(req.Trailer))
+			for key := range req.Trailer {
+				trailer[key] = nil
+			}
+			reqBody = &requestTrailerReader{r: reqBody, req: f.Request, src: req, dst: trailer}
+		}
+	} else if len(f.Request.Trailer) > 0 {
+		trailer = f.Request.Trailer.Clone()
+	}
+
+	proxyReqCtx := proxycontext.WithProxyRequest(ctx, req)
+	proxyReqCtx = types.WithUpstreamAddress(proxyReqCtx, types.UpstreamAddress(f.Request.URL.Host, f.Request.URL.Scheme))
+	proxyReqCtx = httptrace.WithClientTrace(proxyReqCtx, flowTrace(f))
 	proxyReq, err := http.NewRequestWithContext(proxyReqCtx, f.Request.Method, f.Request.URL.String(), reqBody)
 	if err != nil {
 		logger.Error("failed to create proxy request", "error", err)
-		res.WriteHeader(502)
 		return nil, err
 	}
+	// only a spooled body no stream request modifier replaced has a known length
+	if spooledBody, ok := reqBody.(*io.SectionReader); ok {
+		proxyReq.ContentLength = spooledBody.Size()
+	}
+	if trailer != nil {
+		proxyReq.Trailer = trailer
+		proxyReq.ContentLength = -1 // trailers need a chunked body
+	}
 
 	for key, value := range f.Request.Header {
 		for _, v := range value {
 			proxyReq.Header.Add(key, v)
 		}
 	}
+	if !f.Stream {
+		// the body is already here, there is nothing to wait for
+		proxyReq.Header.Del("Expect")
+	}
 
 	useSeparateClient := f.UseSeparateClient
 	if !useSeparateClient {
@@ -511,33 +871,31 @@ func (a *Attacker) executeProxyRequest(f *types.Flow, req *http.Request, reqBody
 
 	var proxyRes *http.Response
 	if useSeparateClient {
-		proxyRes, err = a.client.Do(proxyReq)
+		f.UsedSeparateClient = true
+		client := a.client
+		if a.http1Client != nil && req.ProtoMajor < 2 {
+			client = a.http1Client
+		}
+		proxyRes, err = client.Do(proxyReq)
 		if err != nil {
 			logErr(logger, err)
-			res.WriteHeader(502)
 			return nil, err
 		}
 		return proxyRes, nil
 	}
 
-	// Establish connection if needed
+	// Establish connection if needed, the trace of the proxy request records its timings
 	if f.ConnContext.ServerConn == nil && f.ConnContext.DialFn != nil {
-		if err := f.ConnContext.DialFn(req.Context()); err != nil {
-			// Check for authentication failure
+		if err := f.ConnContext.DialFn(proxyReq.Context()); err != nil {
 			logger.Error("dial upstream failed", "error", err)
-			if strings.Contains(err.Error(), "Proxy Authentication Required") {
-				httpError(res, "", http.StatusProxyAuthRequired)
-				return nil, err
-			}
-			res.WriteHeader(502)
 			return nil, err
 		}
 	}
+	takeDialTimings(f)
 
 	proxyRes, err = f.ConnContext.ServerConn.Client.Do(proxyReq)
 	if err != nil {
 		logErr(logger, err)
-		res.WriteHeader(502)
 		return nil, err
 	}
 
@@ -545,13 +903,58 @@ func (a *Attacker) executeProxyRequest(f *types.Flow, req *http.Request, reqBody
 	return proxyRes, nil
 }
 
+// fail answers a flow whose upstream request failed with err. It stores err in the flow
+// and triggers the OnError addon event; if an addon set a new f.Response, the client
+// gets it, otherwise a 407 when the upstream proxy wants authentication and a 502 else.
+// Killed flows are answered by killed instead.
+func (a *Attacker) fail(res http.ResponseWriter, f *types.Flow, err error, logger *slog.Logger) {
+	if a.killed(res, f) {
+		return
+	}
+	f.Error = err
+	response := f.Response
+	for _, addon := range a.addonRegistry.View() {
+		addon.OnError(f, err)
+	}
+	if f.Response != nil && f.Response != response {
+		a.replyToClient(res, f.Response, nil, logger)
+		return
+	}
+	if strings.Contains(err.Error(), "Proxy Authentication Required") {
+		httpError(res, "", http.StatusProxyAuthRequired)
+		return
+	}
+	res.WriteHeader(502)
+}
+
+// killed answers a flow killed with Flow.Kill with a 502 closing the client connection,
+// after the OnError addon event. It returns whether f was killed.
+func (a *Attacker) killed(res http.ResponseWriter, f *types.Flow) bool {
+	err := f.KillError()
+	if err == nil {
+		return false
+	}
+	a.notifyKilled(f, err)
+	res.Header().Set("Connection", "close")
+	res.WriteHeader(http.StatusBadGateway)
+	return true
+}
+
+// notifyKilled stores the error of the killed flow f and triggers the OnError addon event.
+func (a *Attacker) notifyKilled(f *types.Flow, err error) {
+	f.Error = err
+	for _, addon := range a.addonRegistry.View() {
+		addon.OnError(f, err)
+	}
+}
+
 // handleResponseHeadersAddons triggers the Responseheaders addon event for all registered addons.
 // It returns true if any addon provides an early response (by setting f.Response.Body),
-// indicating that the normal response flow should be bypassed.
+// or kills the flow, indicating that the normal response flow should be bypassed.
 func (a *Attacker) handleResponseHeadersAddons(f *types.Flow) bool {
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		addon.Responseheaders(f)
-		if f.Response.Body != nil {
+		if f.Response.Body != nil || f.KillError() != nil {
 			return true // early response
 		}
 	}
@@ -561,44 +964,142 @@ func (a *Attacker) handleResponseHeadersAddons(f *types.Flow) bool {
 // readResponseBody reads and buffers the response body from the upstream server.
 // If the response body is too large (exceeds StreamLargeBodies threshold), it switches
 // to streaming mode. In non-streaming mode, it triggers the Response addon event.
-// Returns the response body reader and a boolean indicating success.
-func (a *Attacker) readResponseBody(f *types.Flow, proxyRes *http.Response, logger *slog.Logger) (io.Reader, bool) {
+// Bodies over the limit configured for their content type fail, or get truncated.
+// Server-Sent Events responses are always streamed, event by event. Large bodies are
+// spooled to spooled instead of streamed, when spooling is enabled and they fit.
+// Returns the response body reader, or the error reading it failed with.
+func (a *Attacker) readResponseBody(f *types.Flow, proxyRes *http.Response, spooled *spooledBodies, logger *slog.Logger) (io.Reader, error) {
 	var resBody io.Reader = proxyRes.Body
+	if limit, ok := a.responseBodyLimit(proxyRes.Header.Get("Content-Type")); ok {
+		if proxyRes.ContentLength > limit && !a.truncateLimitedBodies {
+			logger.Warn("response body exceeds content-type limit", "contentLength", proxyRes.ContentLength, "limit", limit)
+			return nil, errBodyTooLarge
+		}
+		if proxyRes.ContentLength < 0 || proxyRes.ContentLength > limit {
+			f.Response.Header.Del("Content-Length")
+		}
+		resBody = &limitedBody{r: proxyRes.Body, left: limit, truncate: a.truncateLimitedBodies}
+	}
+	if isEventStream(proxyRes.Header) {
+		// events are passed to the ServerSentEvent addon event as they arrive, unless
+		// they are compressed; those are only flushed
+		f.Stream = true
+		f.Response.Header.Del("Content-Length")
+		if !isEncoded(proxyRes.Header) {
+			resBody = newSSEReader(resBody, f, a.addonRegistry.View())
+		}
+	}
 	if f.Stream {
-		return resBody, true
+		return &trailerReader{r: resBody, res: f.Response, src: proxyRes}, nil
 	}
 
 	streamThreshold := a.streamLargeBodies
-	resBuf, r, err := helper.ReaderToBuffer(proxyRes.Body, streamThreshold)
+	resBuf, r, err := helper.ReaderToBuffer(resBody, streamThreshold)
 	resBody = r
+	if errors.Is(err, errBodyTooLarge) {
+		logger.Warn("response body exceeds content-type limit")
+		return nil, err
+	}
 	if err != nil {
 		logger.Error("failed to buffer response body", "error", err)
-		return nil, false
+		return nil, err
 	}
 
 	if resBuf == nil {
-		logger.Warn("response body too large, switching to stream", "threshold", streamThreshold)
-		f.Stream = true
-		return resBody, true
+		spooledBody, r, err := a.spool(resBody, spooled)
+		if errors.Is(err, errBodyTooLarge) {
+			logger.Warn("response body exceeds content-type limit")
+			return nil, err
+		}
+		if err != nil {
+			logger.Error("failed to spool response body", "error", err)
+			return nil, err
+		}
+		if spooledBody == nil {
+			logger.Warn("response body too large, switching to stream", "threshold", streamThreshold)
+			f.Stream = true
+			return &trailerReader{r: r, res: f.Response, src: proxyRes}, nil
+		}
+		f.Response.SpooledBody = spooledBody
+		logger.Debug("spooled response body", "size", spooledBody.Size())
+	} else {
+		f.Response.Body = resBuf
+		logger.Debug("buffered response body", "size", len(resBuf))
 	}
-
-	f.Response.Body = resBuf
-	logger.Debug("buffered response body", "size", len(resBuf))
+	f.Response.Trailer = proxyRes.Trailer
 
 	// trigger addon event Response
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		addon.Response(f)
+		if f.KillError() != nil {
+			break
+		}
 	}
 
 	logger.Debug("after Response addon", "bodySize", len(f.Response.Body))
-	return resBody, true
+	if f.Response.Body == nil && f.Response.SpooledBody != nil {
+		return f.Response.SpooledBody.Reader(), nil
+	}
+	return resBody, nil
+}
+
+// trailerReader copies the upstream trailers into the flow response once the
+// streamed body has been read to the end. The http client only fills in
+// trailers after the body reaches EOF.
+type trailerReader struct {
+	r   io.Reader
+	res *types.Response
+	src *http.Response
+}
+
+func (tr *trailerReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		tr.res.Trailer = tr.src.Trailer
+	}
+	return n, err
+}
+
+func expectsContinue(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+// requestTrailerReader is trailerReader for streamed request bodies. It also fills in
+// the trailer values of the upstream request, whose keys were declared before sending.
+type requestTrailerReader struct {
+	r   io.Reader
+	req *types.Request
+	src *http.Request
+	dst http.Header
+}
+
+func (tr *requestTrailerReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		for key, values := range tr.src.Trailer {
+			tr.dst[key] = values
+		}
+		tr.req.Trailer = tr.src.Trailer
+	}
+	return n, err
 }
 
 // replyToClient sends the HTTP response back to the client.
-// It writes the response headers, status code, and body (from multiple possible sources).
+// It applies the configured response transformation first, then writes the response
+// headers, status code, and body (from multiple possible sources).
 // The body can come from a reader, a BodyReader field, or a Body byte slice.
-func (*Attacker) replyToClient(res http.ResponseWriter, response *types.Response, body io.Reader, logger *slog.Logger) {
+// Response trailers are written after the body.
+func (a *Attacker) replyToClient(res http.ResponseWriter, response *types.Response, body io.Reader, logger *slog.Logger) {
+	if a.transformResponse != nil {
+		if response.Header == nil {
+			response.Header = make(http.Header)
+		}
+		a.transformResponse(response)
+	}
 	logger.Debug("replyToClient", "bodyReader", body != nil, "responseBodyReader", response.BodyReader != nil, "responseBodyLen", len(response.Body))
+	if response.Header.Get("Server") != "" {
+		res.Header().Del("Server")
+	}
 	if response.Header != nil {
 		for key, value := range response.Header {
 			for _, v := range value {
@@ -609,10 +1110,21 @@ func (*Attacker) replyToClient(res http.ResponseWriter, response *types.Response
 	if response.Close {
 		res.Header().Add("Connection", "close")
 	}
+	if len(response.Trailer) > 0 {
+		// HTTP/1.1 trailers are only sent with a chunked body
+		res.Header().Del("Content-Length")
+	}
 	res.WriteHeader(response.StatusCode)
 
+	var w io.Writer = res
+	if flusher, ok := res.(http.Flusher); ok && isEventStream(response.Header) {
+		// send headers and every event right away
+		flusher.Flush()
+		w = &flushWriter{w: res, f: flusher}
+	}
+
 	if body != nil {
-		n, err := io.Copy(res, body)
+		n, err := io.Copy(w, body)
 		logger.Debug("wrote from body reader", "bytes", n)
 		if err != nil {
 			logErr(logger, err)
@@ -633,6 +1145,12 @@ func (*Attacker) replyToClient(res http.ResponseWriter, response *types.Response
 		}
 	}
 
+	for key, value := range response.Trailer {
+		for _, v := range value {
+			res.Header().Add(http.TrailerPrefix+key, v)
+		}
+	}
+
 	// Flush the response
 	if flusher, ok := res.(http.Flusher); ok {
 		flusher.Flush()
@@ -642,11 +1160,12 @@ func (*Attacker) replyToClient(res http.ResponseWriter, response *types.Response
 
 // handleRequestAddons triggers the Requestheaders addon event for all registered addons.
 // It returns true if any addon provides an early response (by setting f.Response),
-// indicating that the request should not be forwarded to the upstream server.
+// or kills the flow, indicating that the request should not be forwarded to the
+// upstream server.
 func (a *Attacker) handleRequestAddons(f *types.Flow) bool {
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		addon.Requestheaders(f)
-		if f.Response != nil {
+		if f.Response != nil || f.KillError() != nil {
 			return true // early response
 		}
 	}
@@ -655,10 +1174,17 @@ func (a *Attacker) handleRequestAddons(f *types.Flow) bool {
 
 // readRequestBody reads and buffers the request body from the client.
 // If the request body is too large (exceeds StreamLargeBodies threshold), it switches
-// to streaming mode. In non-streaming mode, it triggers the Request addon event.
+// to streaming mode, unless spooling is enabled and it fits, in which case it is spooled
+// to spooled. In non-streaming mode, it triggers the Request addon event.
 // Returns the request body reader and a boolean indicating success.
-func (a *Attacker) readRequestBody(f *types.Flow, req *http.Request, logger *slog.Logger) (io.Reader, bool) {
+func (a *Attacker) readRequestBody(f *types.Flow, req *http.Request, spooled *spooledBodies, logger *slog.Logger) (io.Reader, bool) {
 	var reqBody io.Reader = req.Body
+	// The client gets the interim 100 response when the body is first read. Large uploads are
+	// streamed, so that only happens once the upstream server has asked for the body too.
+	if expectsContinue(req) && req.ContentLength > a.streamLargeBodies {
+		logger.Debug("streaming request body announced with Expect: 100-continue", "contentLength", req.ContentLength)
+		f.Stream = true
+	}
 	if f.Stream {
 		return reqBody, true
 	}
@@ -672,21 +1198,39 @@ func (a *Attacker) readRequestBody(f *types.Flow, req *http.Request, logger *slo
 	}
 
 	if reqBuf == nil {
-		logger.Warn("request body too large, switching to stream", "threshold", streamThreshold)
-		f.Stream = true
-		return reqBody, true
+		spooledBody, r, err := a.spool(reqBody, spooled)
+		if err != nil {
+			logger.Error("failed to spool request body", "error", err)
+			return nil, false
+		}
+		if spooledBody == nil {
+			logger.Warn("request body too large, switching to stream", "threshold", streamThreshold)
+			f.Stream = true
+			return r, true
+		}
+		f.Request.SpooledBody = spooledBody
+		logger.Debug("spooled request body", "size", spooledBody.Size())
+	} else {
+		f.Request.Body = reqBuf
 	}
-
-	f.Request.Body = reqBuf
+	f.Request.Trailer = req.Trailer
 
 	// trigger addon event Request
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		addon.Request(f)
-		if f.Response != nil {
+		if f.Response != nil || f.KillError() != nil {
 			return nil, true // early response
 		}
 	}
-	return bytes.NewReader(f.Request.Body), true
+	return requestBody(f.Request), true
+}
+
+// requestBody returns a reader of the buffered or spooled body of req.
+func requestBody(req *types.Request) io.Reader {
+	if req.Body == nil && req.SpooledBody != nil {
+		return req.SpooledBody.Reader()
+	}
+	return bytes.NewReader(req.Body)
 }
 
 // Attack is the main request handling method that processes HTTP/HTTPS requests.
@@ -695,13 +1239,21 @@ func (a *Attacker) readRequestBody(f *types.Flow, req *http.Request, logger *slo
 // 2. Triggers Requestheaders addon event
 // 3. Reads and buffers the request body (or streams if too large)
 // 4. Triggers Request addon event
-// 5. Applies stream request modifiers
+// 5. Applies the configured request transformation and stream request modifiers
 // 6. Executes the proxy request to the upstream server
 // 7. Triggers Responseheaders addon event
 // 8. Reads and buffers the response body (or streams if too large)
 // 9. Triggers Response addon event
 // 10. Applies stream response modifiers
-// 11. Sends the response back to the client
+// 11. Applies the configured response transformation and sends the response back to the client
+//
+// When steps 6 or 8 fail, the OnError addon event is triggered and the client gets a 502
+// unless an addon answers instead.
+//
+// A flow killed with Flow.Kill stops at the next step, or right away when it waits for
+// the upstream server or streams a body. The OnError addon event is triggered, and the
+// client gets a 502 closing its connection, or its connection reset once the response
+// has started.
 //
 // The method includes panic recovery to handle addon errors gracefully.
 func (a *Attacker) Attack(res http.ResponseWriter, req *http.Request) {
@@ -711,9 +1263,19 @@ func (a *Attacker) Attack(res http.ResponseWriter, req *http.Request) {
 		"method", req.Method,
 	)
 
+	if !a.flowLimiter.acquire(req.Context()) {
+		logger.Warn("too many concurrent flows, rejecting")
+		res.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer a.flowLimiter.release()
+
 	// when addons panic
 	defer func() {
 		if err := recover(); err != nil {
+			if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+				panic(err) // a killed flow resets the client connection
+			}
 			logger.Warn("Recovered from panic in Attacker.attack", "error", err)
 		}
 	}()
@@ -725,38 +1287,68 @@ func (a *Attacker) Attack(res http.ResponseWriter, req *http.Request) {
 
 	// Create flow directly
 	f := types.NewFlow()
+	start := time.Now()
+	f.UpdateTimings(func(t *types.Timings) { t.RequestStart = start })
 	f.Request = types.NewRequest(req)
 	f.ConnContext = connCtx
+	markReplay(req, f)
+	// the spooled bodies are removed once the flow has finished
+	var spooled spooledBodies
+	defer spooled.close()
 	defer f.Finish()
 
 	connCtx.FlowCount.Add(1)
 
+	// killing the flow cancels the upstream round trip and the streamed bodies
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-f.Killed():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	rawReqURLHost := f.Request.URL.Host
 	rawReqURLScheme := f.Request.URL.Scheme
 
 	// trigger addon event Requestheaders
 	if a.handleRequestAddons(f) {
-		a.replyToClient(res, f.Response, nil, logger)
+		if !a.killed(res, f) {
+			a.replyToClient(res, f.Response, nil, logger)
+		}
 		return
 	}
 
 	// Read request body
-	reqBody, ok := a.readRequestBody(f, req, logger)
+	reqBody, ok := a.readRequestBody(f, req, &spooled, logger)
 	if !ok {
 		res.WriteHeader(502)
 		return
 	}
+	if a.killed(res, f) {
+		return
+	}
 	if f.Response != nil {
 		a.replyToClient(res, f.Response, nil, logger)
 		return
 	}
 
-	for _, addon := range a.addonRegistry.Get() {
+	if a.transformRequest != nil {
+		a.transformRequest(f.Request)
+		if !f.Stream {
+			reqBody = requestBody(f.Request)
+		}
+	}
+
+	for _, addon := range a.addonRegistry.View() {
 		reqBody = addon.StreamRequestModifier(f, reqBody)
 	}
 
-	proxyRes, err := a.executeProxyRequest(f, req, reqBody, rawReqURLHost, rawReqURLScheme, res, logger)
+	proxyRes, err := a.executeProxyRequest(ctx, f, req, reqBody, rawReqURLHost, rawReqURLScheme, logger)
 	if err != nil {
+		a.fail(res, f, err, logger)
 		return
 	}
 
@@ -769,25 +1361,39 @@ func (a *Attacker) Attack(res http.ResponseWriter, req *http.Request) {
 	f.Response = &types.Response{
 		StatusCode: proxyRes.StatusCode,
 		Header:     proxyRes.Header,
+		Trailer:    proxyRes.Trailer,
 		Close:      proxyRes.Close,
 	}
 
 	// trigger addon event Responseheaders
 	if a.handleResponseHeadersAddons(f) {
-		a.replyToClient(res, f.Response, nil, logger)
+		if !a.killed(res, f) {
+			a.replyToClient(res, f.Response, nil, logger)
+		}
 		return
 	}
 
 	// Read response body
-	resBody, ok := a.readResponseBody(f, proxyRes, logger)
-	if !ok {
-		res.WriteHeader(502)
+	resBody, err := a.readResponseBody(f, proxyRes, &spooled, logger)
+	if err != nil {
+		a.fail(res, f, err, logger)
 		return
 	}
 
-	for _, addon := range a.addonRegistry.Get() {
+	for _, addon := range a.addonRegistry.View() {
 		resBody = addon.StreamResponseModifier(f, resBody)
 	}
+	if a.killed(res, f) {
+		return
+	}
 
+	// proxied responses never carry the proxy's own Server header
+	res.Header().Del("Server")
 	a.replyToClient(res, f.Response, resBody, logger)
+
+	if err := f.KillError(); err != nil {
+		// killed while the response was streamed, the client must not take it as complete
+		a.notifyKilled(f, err)
+		panic(http.ErrAbortHandler)
+	}
 }
@@ -7,6 +7,7 @@ package attacker
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
@@ -14,7 +15,9 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 
@@ -26,7 +29,8 @@ import (
 )
 
 type stubClientFactory struct {
-	mainCalled bool
+	mainCalled  bool
+	httpsCalled bool
 }
 
 func (f *stubClientFactory) CreateMainClient(types.UpstreamManager, bool) *http.Client {
@@ -42,7 +46,8 @@ func (*stubClientFactory) CreatePlainHTTPClient(net.Conn) *http.Client {
 	return &http.Client{}
 }
 
-func (*stubClientFactory) CreateHTTPSClient(*tls.Conn) *http.Client {
+func (f *stubClientFactory) CreateHTTPSClient(*tls.Conn) *http.Client {
+	f.httpsCalled = true
 	return &http.Client{}
 }
 
@@ -58,7 +63,7 @@ func TestNewUsesCustomClientFactory(t *testing.T) {
 		UpstreamManager:   upstream.NewManager("", false),
 		AddonRegistry:     addonregistry.New(),
 		StreamLargeBodies: 1024,
-		WSHandler:         websocket.New(),
+		WSHandler:         websocket.New(nil, false),
 		ClientFactory:     factory,
 	})
 
@@ -78,12 +83,57 @@ func TestNewDefaultsToDefaultClientFactory(t *testing.T) {
 		UpstreamManager:   upstream.NewManager("", false),
 		AddonRegistry:     addonregistry.New(),
 		StreamLargeBodies: 1024,
-		WSHandler:         websocket.New(),
+		WSHandler:         websocket.New(nil, false),
 	})
 
 	c.Assert(err, qt.IsNil)
 	_, ok := atk.clientFactory.(*types.DefaultClientFactory)
 	c.Assert(ok, qt.IsTrue)
+	c.Assert(atk.h3Server, qt.IsNil)
+	c.Assert(atk.ServeHTTP3(nil, nil), qt.Equals, errHTTP3Disabled)
+}
+
+func TestNewHTTP3WithFactoryWithoutHTTP3Client(t *testing.T) {
+	c := qt.New(t)
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+
+	// stubClientFactory does not implement types.HTTP3ClientFactory
+	atk, err := New(Args{
+		CA:                ca,
+		UpstreamManager:   upstream.NewManager("", false),
+		AddonRegistry:     addonregistry.New(),
+		StreamLargeBodies: 1024,
+		WSHandler:         websocket.New(nil, false),
+		ClientFactory:     &stubClientFactory{},
+		HTTP3:             true,
+	})
+
+	c.Assert(err, qt.IsNil)
+	c.Assert(atk.h3Server, qt.IsNotNil)
+	c.Assert(atk.h3Client, qt.IsNotNil)
+}
+
+func TestCreateHTTPSClientFallsBackForOtherConns(t *testing.T) {
+	c := qt.New(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// stubClientFactory does not implement types.ConnClientFactory
+	factory := &stubClientFactory{}
+	atk := &Attacker{clientFactory: factory}
+
+	c.Assert(atk.createHTTPSClient(tls.Client(clientConn, &tls.Config{})), qt.IsNotNil)
+	c.Assert(factory.httpsCalled, qt.IsTrue)
+
+	factory.httpsCalled = false
+	client := atk.createHTTPSClient(clientConn)
+	c.Assert(factory.httpsCalled, qt.IsFalse)
+	_, ok := client.Transport.(*http.Transport)
+	c.Assert(ok, qt.IsTrue)
 }
 
 func TestListenerAcceptReturnsConnection(t *testing.T) {
@@ -130,3 +180,230 @@ func TestHTTPErrorWritesExpectedHeaders(t *testing.T) {
 	c.Assert(res.Header.Get("Proxy-Authenticate"), qt.Equals, `Basic realm="proxy"`)
 	c.Assert(string(body), qt.Contains, "boom")
 }
+
+func TestListenerCloseUnblocksAccept(t *testing.T) {
+	c := qt.New(t)
+
+	l := newListener()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		errCh <- err
+	}()
+
+	c.Assert(l.Close(), qt.IsNil)
+	c.Assert(l.Close(), qt.IsNil) // closing twice is safe
+
+	select {
+	case err := <-errCh:
+		c.Assert(errors.Is(err, net.ErrClosed), qt.IsTrue)
+	case <-time.After(time.Second):
+		c.Fatal("Accept did not return after Close")
+	}
+
+	// connections handed over after close are closed instead of blocking
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	l.accept(clientConn)
+	_, err := clientConn.Write([]byte("x"))
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestAttackerCloseStopsStart(t *testing.T) {
+	c := qt.New(t)
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+
+	atk, err := New(Args{
+		CA:                ca,
+		UpstreamManager:   upstream.NewManager("", false),
+		AddonRegistry:     addonregistry.New(),
+		StreamLargeBodies: 1024,
+		WSHandler:         websocket.New(nil, false),
+	})
+	c.Assert(err, qt.IsNil)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- atk.Start()
+	}()
+	time.Sleep(10 * time.Millisecond) // wait for Start to begin serving
+
+	c.Assert(atk.Close(), qt.IsNil)
+
+	select {
+	case err := <-errCh:
+		c.Assert(errors.Is(err, http.ErrServerClosed), qt.IsTrue)
+	case <-time.After(time.Second):
+		c.Fatal("Start did not return after Close")
+	}
+}
+
+func TestFlowLimiterQueuesAndRejects(t *testing.T) {
+	c := qt.New(t)
+
+	l := newFlowLimiter(1, 1)
+	c.Assert(l.acquire(context.Background()), qt.IsTrue)
+
+	acquired := make(chan bool, 1)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+	for l.queued.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the queue is full
+	c.Assert(l.acquire(context.Background()), qt.IsFalse)
+
+	l.release()
+	c.Assert(<-acquired, qt.IsTrue)
+	c.Assert(l.active.Load(), qt.Equals, int64(1))
+	c.Assert(l.queued.Load(), qt.Equals, int64(0))
+
+	// a queued flow gives up when its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Assert(l.acquire(ctx), qt.IsFalse)
+	c.Assert(l.queued.Load(), qt.Equals, int64(0))
+}
+
+func TestFlowLimiterUnlimited(t *testing.T) {
+	c := qt.New(t)
+
+	l := newFlowLimiter(0, 0)
+	for i := 0; i < 3; i++ {
+		c.Assert(l.acquire(context.Background()), qt.IsTrue)
+	}
+	c.Assert(l.active.Load(), qt.Equals, int64(3))
+	l.release()
+	c.Assert(l.active.Load(), qt.Equals, int64(2))
+}
+
+func TestResponseBodyLimitMatching(t *testing.T) {
+	c := qt.New(t)
+
+	a := &Attacker{responseBodyLimits: normalizeBodyLimits(map[string]int64{
+		"Application/JSON": 10,
+		"video/*":          0,
+		"text/*":           20,
+		"*/*":              30,
+	})}
+
+	tests := []struct {
+		contentType string
+		limit       int64
+		limited     bool
+	}{
+		{"application/json; charset=utf-8", 10, true},
+		{"text/html", 20, true},
+		{"video/mp4", 30, true}, // zero means no limit for video/*, so the default applies
+		{"image/png", 30, true},
+		{"", 30, true},
+	}
+	for _, tt := range tests {
+		limit, limited := a.responseBodyLimit(tt.contentType)
+		c.Assert(limited, qt.Equals, tt.limited, qt.Commentf(tt.contentType))
+		c.Assert(limit, qt.Equals, tt.limit, qt.Commentf(tt.contentType))
+	}
+
+	_, limited := (&Attacker{}).responseBodyLimit("application/json")
+	c.Assert(limited, qt.IsFalse)
+}
+
+func TestLimitedBody(t *testing.T) {
+	c := qt.New(t)
+
+	body, err := io.ReadAll(&limitedBody{r: strings.NewReader("0123456789"), left: 4, truncate: true})
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "0123")
+
+	_, err = io.ReadAll(&limitedBody{r: strings.NewReader("0123456789"), left: 4})
+	c.Assert(errors.Is(err, errBodyTooLarge), qt.IsTrue)
+
+	body, err = io.ReadAll(&limitedBody{r: strings.NewReader("0123"), left: 4})
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "0123")
+}
+
+type sseTestAddon struct {
+	types.BaseAddon
+	events []string
+}
+
+func (a *sseTestAddon) ServerSentEvent(_ *types.Flow, event []byte) []byte {
+	a.events = append(a.events, string(event))
+	switch {
+	case bytes.HasPrefix(event, []byte("event: drop")):
+		return nil
+	case bytes.Equal(event, []byte("data: secret\n\n")):
+		return []byte("data: redacted\n\n")
+	}
+	return event
+}
+
+func TestSSEReader(t *testing.T) {
+	longLine := "data: " + strings.Repeat("x", 10000) + "\n\n"
+	hugeEvent := "data: " + strings.Repeat("x", maxEventSize) + "\ndata: more\n\n"
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantOut    string
+		wantEvents []string
+	}{
+		{
+			name:       "events are split on blank lines",
+			body:       "id: 1\ndata: a\n\ndata: b\r\n\r\n",
+			wantOut:    "id: 1\ndata: a\n\ndata: b\r\n\r\n",
+			wantEvents: []string{"id: 1\ndata: a\n\n", "data: b\r\n\r\n"},
+		},
+		{
+			name:       "events can be rewritten and dropped",
+			body:       "data: secret\n\nevent: drop\ndata: x\n\ndata: keep\n\n",
+			wantOut:    "data: redacted\n\ndata: keep\n\n",
+			wantEvents: []string{"data: secret\n\n", "event: drop\ndata: x\n\n", "data: keep\n\n"},
+		},
+		{
+			name:       "stray blank lines and incomplete events are forwarded",
+			body:       "\ndata: a\n\ndata: partial",
+			wantOut:    "\ndata: a\n\ndata: partial",
+			wantEvents: []string{"data: a\n\n"},
+		},
+		{
+			name:       "lines longer than the read buffer",
+			body:       longLine,
+			wantOut:    longLine,
+			wantEvents: []string{longLine},
+		},
+		{
+			name:       "events over the size limit are forwarded without the addons",
+			body:       hugeEvent + "data: secret\n\n",
+			wantOut:    hugeEvent + "data: redacted\n\n",
+			wantEvents: []string{"data: secret\n\n"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			addon := &sseTestAddon{}
+			r := newSSEReader(strings.NewReader(tc.body), types.NewFlow(), []types.Addon{addon})
+
+			out, err := io.ReadAll(r)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(out), qt.Equals, tc.wantOut)
+			c.Assert(addon.events, qt.DeepEquals, tc.wantEvents)
+		})
+	}
+}
+
+func TestIsEventStream(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(isEventStream(http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}), qt.IsTrue)
+	c.Assert(isEventStream(http.Header{"Content-Type": {"Text/Event-Stream"}}), qt.IsTrue)
+	c.Assert(isEventStream(http.Header{"Content-Type": {"text/plain"}}), qt.IsFalse)
+	c.Assert(isEventStream(http.Header{}), qt.IsFalse)
+}
@@ -0,0 +1,76 @@
+package attacker
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"strings"
+)
+
+var errBodyTooLarge = errors.New("response body exceeds the content-type limit")
+
+// normalizeBodyLimits lowercases the media types of limits.
+func normalizeBodyLimits(limits map[string]int64) map[string]int64 {
+	if len(limits) == 0 {
+		return nil
+	}
+	normalized := make(map[string]int64, len(limits))
+	for mediaType, limit := range limits {
+		normalized[strings.ToLower(mediaType)] = limit
+	}
+	return normalized
+}
+
+// responseBodyLimit returns the size limit configured for contentType.
+// An exact media type match wins over a "type/*" entry, which wins over "*/*".
+func (a *Attacker) responseBodyLimit(contentType string) (int64, bool) {
+	if len(a.responseBodyLimits) == 0 {
+		return 0, false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	keys := []string{mediaType}
+	if i := strings.IndexByte(mediaType, '/'); i > 0 {
+		keys = append(keys, mediaType[:i]+"/*")
+	}
+	keys = append(keys, "*/*")
+	for _, key := range keys {
+		if limit, ok := a.responseBodyLimits[key]; ok && limit > 0 {
+			return limit, true
+		}
+	}
+	return 0, false
+}
+
+// limitedBody reads at most limit bytes from r. Once the limit is reached it reports
+// io.EOF when truncating, or errBodyTooLarge if more data is left otherwise.
+type limitedBody struct {
+	r        io.Reader
+	left     int64
+	truncate bool
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.left <= 0 {
+		if lb.truncate {
+			return 0, io.EOF
+		}
+		// probe for data beyond the limit
+		var probe [1]byte
+		n, err := lb.r.Read(probe[:])
+		if n > 0 {
+			return 0, errBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > lb.left {
+		p = p[:lb.left]
+	}
+	n, err := lb.r.Read(p)
+	lb.left -= int64(n)
+	return n, err
+}
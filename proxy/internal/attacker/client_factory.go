@@ -1,6 +1,10 @@
 package attacker
 
 import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
 )
 
@@ -9,3 +13,48 @@ import (
 func NewDefaultClientFactory() types.ClientFactory {
 	return types.NewDefaultClientFactory()
 }
+
+// createHTTP2Client creates the HTTP/2 client of the upstream TLS connection c.
+func (a *Attacker) createHTTP2Client(c net.Conn) *http.Client {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		return a.clientFactory.CreateHTTP2Client(tlsConn)
+	}
+	return a.connClientFactory().CreateHTTP2ConnClient(c)
+}
+
+// createHTTPSClient creates the HTTPS client of the upstream TLS connection c.
+func (a *Attacker) createHTTPSClient(c net.Conn) *http.Client {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		return a.clientFactory.CreateHTTPSClient(tlsConn)
+	}
+	return a.connClientFactory().CreateHTTPSConnClient(c)
+}
+
+// connClientFactory returns the factory creating the clients of upstream TLS
+// connections that are not a *tls.Conn.
+func (a *Attacker) connClientFactory() types.ConnClientFactory {
+	if factory, ok := a.clientFactory.(types.ConnClientFactory); ok {
+		return factory
+	}
+	return &types.DefaultClientFactory{}
+}
+
+// newHTTP1Client returns a copy of client whose transport never negotiates HTTP/2.
+// Clients with a transport other than *http.Transport are returned unchanged.
+func newHTTP1Client(client *http.Client) *http.Client {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return client
+	}
+	transport = transport.Clone()
+	transport.ForceAttemptHTTP2 = false
+	// A non-nil empty map disables HTTP/2 support.
+	transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	http1Client := *client
+	http1Client.Transport = transport
+	return &http1Client
+}
@@ -2,13 +2,17 @@ package attacker_test
 
 import (
 	"crypto/tls"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/attacker"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/proxycontext"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/upstream"
 )
@@ -58,6 +62,14 @@ func TestDefaultClientFactory(t *testing.T) {
 		c.Assert(client, qt.IsNotNil, qt.Commentf("expected client to be created"))
 		c.Assert(client.Transport, qt.IsNotNil, qt.Commentf("expected transport to be set"))
 	})
+
+	t.Run("CreateHTTP3Client", func(t *testing.T) {
+		c := qt.New(t)
+		client := factory.(types.HTTP3ClientFactory).CreateHTTP3Client(true)
+
+		c.Assert(client, qt.IsNotNil, qt.Commentf("expected client to be created"))
+		c.Assert(client.Transport, qt.IsNotNil, qt.Commentf("expected transport to be set"))
+	})
 }
 
 // customClientFactory is a test implementation of types.ClientFactory.
@@ -66,6 +78,7 @@ type customClientFactory struct {
 	http2ClientCalled     bool
 	plainHTTPClientCalled bool
 	httpsClientCalled     bool
+	http3ClientCalled     bool
 }
 
 func (f *customClientFactory) CreateMainClient(upstreamManager types.UpstreamManager, insecureSkipVerify bool) *http.Client {
@@ -88,6 +101,11 @@ func (f *customClientFactory) CreateHTTPSClient(tlsConn *tls.Conn) *http.Client
 	return &http.Client{}
 }
 
+func (f *customClientFactory) CreateHTTP3Client(insecureSkipVerify bool) *http.Client {
+	f.http3ClientCalled = true
+	return &http.Client{}
+}
+
 func TestCustomClientFactory(t *testing.T) {
 	factory := &customClientFactory{}
 
@@ -122,4 +140,41 @@ func TestCustomClientFactory(t *testing.T) {
 
 		c.Assert(factory.httpsClientCalled, qt.IsTrue, qt.Commentf("expected CreateHTTPSClient to be called"))
 	})
+
+	t.Run("CreateHTTP3Client is called", func(t *testing.T) {
+		c := qt.New(t)
+		factory.CreateHTTP3Client(false)
+
+		c.Assert(factory.http3ClientCalled, qt.IsTrue, qt.Commentf("expected CreateHTTP3Client to be called"))
+	})
+}
+
+func TestDefaultClientFactoryMainClientPool(t *testing.T) {
+	c := qt.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	factory := &types.DefaultClientFactory{MaxIdleConnsPerHost: 8, IdleConnTimeout: -1}
+	client := factory.CreateMainClient(upstream.NewManager("", false), false)
+	transport := client.Transport.(*http.Transport)
+	c.Assert(transport.MaxIdleConns, qt.Equals, 100)
+	c.Assert(transport.MaxIdleConnsPerHost, qt.Equals, 8)
+	c.Assert(transport.IdleConnTimeout, qt.Equals, time.Duration(0))
+
+	// the requests share one connection
+	for range 3 {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		c.Assert(err, qt.IsNil)
+		req = req.WithContext(proxycontext.WithProxyRequest(req.Context(), req))
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	c.Assert(factory.MainClientPoolStats(), qt.Equals, types.ClientPoolStats{Opened: 1, Open: 1})
+
+	transport.CloseIdleConnections()
+	c.Assert(factory.MainClientPoolStats(), qt.Equals, types.ClientPoolStats{Opened: 1, Open: 0})
 }
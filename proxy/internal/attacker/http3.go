@@ -0,0 +1,143 @@
+package attacker
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/conn"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/proxycontext"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+var (
+	errQUICConnNotReadable = errors.New("quic connection does not support stream io")
+	errHTTP3Disabled       = errors.New("attacker was created without HTTP3")
+)
+
+// quicClientConn adapts a QUIC connection to net.Conn so it can be used as
+// ClientConn.Conn. Only addresses and Close are meaningful; HTTP/3 requests
+// are read from QUIC streams by the http3 server.
+type quicClientConn struct {
+	qconn *quic.Conn
+}
+
+func (*quicClientConn) Read([]byte) (int, error)         { return 0, errQUICConnNotReadable }
+func (*quicClientConn) Write([]byte) (int, error)        { return 0, errQUICConnNotReadable }
+func (c *quicClientConn) Close() error                   { return c.qconn.CloseWithError(0, "") }
+func (c *quicClientConn) LocalAddr() net.Addr            { return c.qconn.LocalAddr() }
+func (c *quicClientConn) RemoteAddr() net.Addr           { return c.qconn.RemoteAddr() }
+func (*quicClientConn) SetDeadline(time.Time) error      { return nil }
+func (*quicClientConn) SetReadDeadline(time.Time) error  { return nil }
+func (*quicClientConn) SetWriteDeadline(time.Time) error { return nil }
+
+// initHTTP3 creates the HTTP/3 server and the client sending its flows upstream.
+func (a *Attacker) initHTTP3(args Args) {
+	// Client #5: HTTP/3 client
+	// Purpose: Sends the requests of flows intercepted on the HTTP/3 listener upstream
+	// over QUIC. It dials its own connections.
+	factory, ok := a.clientFactory.(types.HTTP3ClientFactory)
+	if !ok {
+		factory = &types.DefaultClientFactory{ClientCertificates: args.ClientCertificates}
+	}
+	a.h3Client = factory.CreateHTTP3Client(args.InsecureSkipVerify)
+	a.h3Server = &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return a.ca.GetCert(chi.ServerName)
+			},
+			ClientAuth: a.clientAuth,
+			ClientCAs:  a.clientCAs,
+		}),
+		Handler:     a,
+		ConnContext: a.http3ConnContext,
+	}
+}
+
+// ServeHTTP3 serves intercepted HTTP/3 connections on pconn until the attacker is closed.
+// Clients negotiate h3 with a certificate generated for their SNI; the requests then go
+// through Attack and are sent upstream with the HTTP/3 client from the ClientFactory.
+// The listener is transparent: clients must be directed to it, e.g. by DNS, since
+// tunneling QUIC through the proxy with CONNECT-UDP is not supported.
+// If admit is not nil, it is called with the address of every new client connection:
+// the connections it refuses are closed before any addon sees them, and release, if
+// not nil, is called once an admitted connection is closed.
+func (a *Attacker) ServeHTTP3(pconn net.PacketConn, admit func(addr net.Addr) (release func(), ok bool)) error {
+	if a.h3Server == nil {
+		return errHTTP3Disabled
+	}
+	ln, err := quic.ListenEarly(pconn, a.h3Server.TLSConfig, &quic.Config{Allow0RTT: true})
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	err = a.h3Server.ServeListener(&admitListener{EarlyListener: ln, admit: admit})
+	if errors.Is(err, quic.ErrServerClosed) || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// admitListener accepts the QUIC connections admitted by admit and closes the others.
+type admitListener struct {
+	*quic.EarlyListener
+	admit func(addr net.Addr) (release func(), ok bool)
+}
+
+func (l *admitListener) Accept(ctx context.Context) (*quic.Conn, error) {
+	for {
+		qconn, err := l.EarlyListener.Accept(ctx)
+		if err != nil || l.admit == nil {
+			return qconn, err
+		}
+		release, ok := l.admit(qconn.RemoteAddr())
+		if ok {
+			if release != nil {
+				context.AfterFunc(qconn.Context(), release)
+			}
+			return qconn, nil
+		}
+		_ = qconn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeRequestRejected), "")
+	}
+}
+
+// http3ConnContext creates the connection context of a new HTTP/3 client connection.
+func (a *Attacker) http3ConnContext(ctx context.Context, qconn *quic.Conn) context.Context {
+	tlsState := qconn.ConnectionState().TLS
+	clientConn := conn.NewClientConn(&quicClientConn{qconn: qconn})
+	clientConn.TLS = true
+	clientConn.NegotiatedProtocol = tlsState.NegotiatedProtocol
+	clientConn.PeerCertificates = tlsState.PeerCertificates
+	clientConn.TLSState = &tlsState
+	clientConn.CloseChan = make(chan struct{})
+	connCtx := conn.NewContext(clientConn)
+	connCtx.Intercept = true
+
+	for _, addon := range a.addonRegistry.View() {
+		addon.ClientConnected(clientConn)
+	}
+	go func() {
+		<-qconn.Context().Done()
+		close(clientConn.CloseChan)
+		a.ClientDisconnected(clientConn)
+	}()
+
+	connCtx.DialFn = func(context.Context) error {
+		serverConn := conn.NewServerConn()
+		serverConn.Address = tlsState.ServerName
+		serverConn.Client = a.h3Client
+		connCtx.ServerConn = serverConn
+		for _, addon := range a.addonRegistry.View() {
+			addon.ServerConnected(connCtx)
+		}
+		return nil
+	}
+
+	return proxycontext.WithConnContext(ctx, connCtx)
+}
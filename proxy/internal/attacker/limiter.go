@@ -0,0 +1,62 @@
+package attacker
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// flowLimiter bounds the number of flows handled concurrently by Attack.
+// Flows beyond the limit wait in a bounded queue; when the queue is full they
+// are rejected. It also tracks the current number of active and queued flows.
+type flowLimiter struct {
+	slots    chan struct{} // nil means unlimited
+	maxQueue int64
+	active   atomic.Int64
+	queued   atomic.Int64
+}
+
+func newFlowLimiter(maxConcurrent, maxQueued int) *flowLimiter {
+	l := &flowLimiter{maxQueue: int64(maxQueued)}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// acquire reserves a slot for a flow, waiting in the queue if needed.
+// It returns false if the queue is full or ctx is done before a slot frees up.
+func (l *flowLimiter) acquire(ctx context.Context) bool {
+	if l.slots == nil {
+		l.active.Add(1)
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		l.active.Add(1)
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		l.active.Add(1)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees the slot reserved by a successful acquire.
+func (l *flowLimiter) release() {
+	l.active.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,143 @@
+package attacker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/conn"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/proxycontext"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+var errReplayConnNotReadable = errors.New("replay connection does not support io")
+
+// replayAddr is the address of the client of replayed requests.
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
+
+// replayClientConn stands in for the client connection of replayed requests, which
+// are not read from a connection.
+type replayClientConn struct{}
+
+func (replayClientConn) Read([]byte) (int, error)         { return 0, errReplayConnNotReadable }
+func (replayClientConn) Write([]byte) (int, error)        { return 0, errReplayConnNotReadable }
+func (replayClientConn) Close() error                     { return nil }
+func (replayClientConn) LocalAddr() net.Addr              { return replayAddr{} }
+func (replayClientConn) RemoteAddr() net.Addr             { return replayAddr{} }
+func (replayClientConn) SetDeadline(time.Time) error      { return nil }
+func (replayClientConn) SetReadDeadline(time.Time) error  { return nil }
+func (replayClientConn) SetWriteDeadline(time.Time) error { return nil }
+
+// replayResponseWriter records the status code Attack answers a replayed request with.
+// The body is dropped: buffered bodies are in the flow, streamed ones are not kept.
+type replayResponseWriter struct {
+	header     http.Header
+	statusCode int
+}
+
+func (w *replayResponseWriter) Header() http.Header { return w.header }
+
+func (w *replayResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (w *replayResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (*replayResponseWriter) Flush() {}
+
+type replayContextKey struct{}
+
+// replayState is shared with Attack through the request context to mark the flow it
+// creates as a replay and to hand it back.
+type replayState struct {
+	flow *types.Flow
+}
+
+// markReplay marks f as a replay if req is a replayed request.
+func markReplay(req *http.Request, f *types.Flow) {
+	state, ok := req.Context().Value(replayContextKey{}).(*replayState)
+	if !ok {
+		return
+	}
+	f.IsReplay = true
+	// there is no client connection with an upstream connection to reuse
+	f.UseSeparateClient = true
+	state.flow = f
+}
+
+// Replay sends the request of the recorded flow f again through Attack, as if a client
+// had sent it, so addons see a new flow. The new flow is returned once it is finished;
+// its response body is missing if the response was streamed. An error is returned if
+// the replay got no response from the server or the addons, or if the request body was
+// spooled, as the spool is removed once f is finished.
+func (a *Attacker) Replay(ctx context.Context, f *types.Flow) (*types.Flow, error) {
+	if f.Request == nil || f.Request.URL == nil {
+		return nil, errors.New("flow without request")
+	}
+	if f.Request.Body == nil && f.Request.SpooledBody != nil {
+		return nil, errors.New("flow with spooled request body")
+	}
+	req, err := http.NewRequestWithContext(ctx, f.Request.Method, f.Request.URL.String(), bytes.NewReader(f.Request.Body))
+	if err != nil {
+		return nil, err
+	}
+	if f.Request.Header != nil {
+		req.Header = f.Request.Header.Clone()
+	}
+	req.Header.Del("Host")
+	req.Trailer = f.Request.Trailer.Clone()
+
+	clientConn := conn.NewClientConn(replayClientConn{})
+	clientConn.TLS = req.URL.Scheme == "https"
+	clientConn.CloseChan = make(chan struct{})
+	defer close(clientConn.CloseChan)
+	connCtx := conn.NewContext(clientConn)
+	connCtx.Intercept = true
+
+	state := &replayState{}
+	replayCtx := context.WithValue(req.Context(), replayContextKey{}, state)
+	req = req.WithContext(proxycontext.WithConnContext(replayCtx, connCtx))
+
+	res := &replayResponseWriter{header: make(http.Header)}
+	a.replayAttack(res, req)
+	if state.flow == nil {
+		return nil, fmt.Errorf("replay rejected with status %d", res.statusCode)
+	}
+	if err := state.flow.KillError(); err != nil {
+		return state.flow, fmt.Errorf("replay failed with status %d: %w", res.statusCode, err)
+	}
+	if state.flow.Response == nil {
+		if state.flow.Error != nil {
+			return state.flow, fmt.Errorf("replay failed with status %d: %w", res.statusCode, state.flow.Error)
+		}
+		return state.flow, fmt.Errorf("replay failed with status %d", res.statusCode)
+	}
+	return state.flow, nil
+}
+
+// replayAttack runs Attack for a replayed request, which has no server to abort the
+// response of a flow killed while it was streamed.
+func (a *Attacker) replayAttack(res http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			if e, ok := err.(error); !ok || !errors.Is(e, http.ErrAbortHandler) {
+				panic(err)
+			}
+		}
+	}()
+	a.Attack(res, req)
+}
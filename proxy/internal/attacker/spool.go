@@ -0,0 +1,35 @@
+package attacker
+
+import (
+	"io"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+// spooledBodies are the bodies of a flow spooled to temporary files, which are removed
+// when the flow ends.
+type spooledBodies []*types.SpooledBody
+
+func (s *spooledBodies) close() {
+	for _, body := range *s {
+		_ = body.Close()
+	}
+}
+
+// spool writes body, too large to be buffered, to a temporary file added to spooled,
+// when spooling is enabled. It returns the spooled body when all of it fits within the
+// spooling limit, and a reader streaming the body otherwise.
+func (a *Attacker) spool(body io.Reader, spooled *spooledBodies) (*types.SpooledBody, io.Reader, error) {
+	if a.spoolLargeBodies <= 0 {
+		return nil, body, nil
+	}
+	spooledBody, err := types.SpoolBody(body, a.spoolDir, a.spoolLargeBodies)
+	if err != nil {
+		return nil, nil, err
+	}
+	*spooled = append(*spooled, spooledBody)
+	if spooledBody.Size() > a.spoolLargeBodies {
+		return nil, io.MultiReader(spooledBody.Reader(), body), nil
+	}
+	return spooledBody, nil, nil
+}
@@ -0,0 +1,139 @@
+package attacker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+// isEventStream reports whether header declares a text/event-stream body.
+func isEventStream(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && strings.EqualFold(mediaType, "text/event-stream")
+}
+
+// isEncoded reports whether header declares a Content-Encoding other than identity.
+func isEncoded(header http.Header) bool {
+	encoding := strings.TrimSpace(header.Get("Content-Encoding"))
+	return encoding != "" && !strings.EqualFold(encoding, "identity")
+}
+
+// maxEventSize bounds the memory used by an event. The rest of a larger event is
+// forwarded as it arrives, without going through the addons.
+const maxEventSize = 1 << 20
+
+// sseReader splits a text/event-stream body into events and passes each one through
+// the ServerSentEvent addon event. Every Read returns data of at most one event, so
+// a flushing writer sends the events to the client as they arrive.
+type sseReader struct {
+	r      *bufio.Reader
+	flow   *types.Flow
+	addons []types.Addon
+
+	pending []byte // processed event data not yet returned
+	err     error  // error to report once pending is drained
+
+	skipping  bool // forwarding the rest of an event over maxEventSize
+	lineStart bool // whether the forwarded data ended with a complete line
+}
+
+func newSSEReader(r io.Reader, f *types.Flow, addons []types.Addon) *sseReader {
+	return &sseReader{
+		r:      bufio.NewReader(r),
+		flow:   f,
+		addons: addons,
+	}
+}
+
+func (sr *sseReader) Read(p []byte) (int, error) {
+	for len(sr.pending) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.skipping {
+			sr.pending, sr.err = sr.skip()
+		} else {
+			sr.pending, sr.err = sr.nextEvent()
+		}
+	}
+	n := copy(p, sr.pending)
+	sr.pending = sr.pending[n:]
+	return n, nil
+}
+
+// nextEvent reads one event including its terminating blank line and returns
+// it as modified by the addons. Data after the last complete event is returned
+// unchanged together with the read error, as is the start of an event over
+// maxEventSize, whose rest skip forwards.
+func (sr *sseReader) nextEvent() ([]byte, error) {
+	var event []byte
+	lineStart := 0
+	for {
+		line, err := sr.r.ReadSlice('\n')
+		event = append(event, line...)
+		if len(event) > maxEventSize {
+			sr.skipping = true
+			sr.lineStart = event[len(event)-1] == '\n'
+			if errors.Is(err, bufio.ErrBufferFull) {
+				err = nil
+			}
+			return event, err
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return event, err
+		}
+		if len(bytes.TrimRight(event[lineStart:], "\r\n")) == 0 {
+			if lineStart == 0 {
+				// stray blank line between events, forward as is
+				return event, nil
+			}
+			break
+		}
+		lineStart = len(event)
+	}
+
+	for _, addon := range sr.addons {
+		event = addon.ServerSentEvent(sr.flow, event)
+		if len(event) == 0 {
+			break
+		}
+	}
+	return event, nil
+}
+
+// skip returns the next line, or part of a line, of an event over maxEventSize, up to
+// the blank line ending the event.
+func (sr *sseReader) skip() ([]byte, error) {
+	line, err := sr.r.ReadSlice('\n')
+	data := append([]byte(nil), line...)
+	complete := len(line) > 0 && line[len(line)-1] == '\n'
+	if sr.lineStart && complete && len(bytes.TrimRight(line, "\r\n")) == 0 {
+		sr.skipping = false
+	}
+	sr.lineStart = complete
+	if errors.Is(err, bufio.ErrBufferFull) {
+		err = nil
+	}
+	return data, err
+}
+
+// flushWriter flushes w after every write.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
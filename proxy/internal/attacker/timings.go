@@ -0,0 +1,96 @@
+package attacker
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+// takeDialTimings attributes the dial and TLS handshake of the upstream connection of f
+// to f when it is the first flow on the connection. Connections dialed before their
+// first flow, as intercepted HTTPS connections are by default, are not seen by its trace.
+func takeDialTimings(f *types.Flow) {
+	serverConn := f.ConnContext.ServerConn
+	if serverConn == nil {
+		return
+	}
+	dial, ok := serverConn.TakeDialTimes()
+	if !ok {
+		return
+	}
+	f.UpdateTimings(func(t *types.Timings) {
+		if t.DialStart.IsZero() && t.Connected.IsZero() {
+			t.DialStart, t.Connected = dial.Start, dial.Connected
+		}
+		if t.TLSEstablished.IsZero() {
+			t.TLSEstablished = dial.TLSEstablished
+		}
+	})
+}
+
+// flowTrace records the upstream timings and the DNS lookup of f from the events of its
+// upstream request. The lookup, dial and TLS events only fire when the request opens a
+// new upstream connection.
+func flowTrace(f *types.Flow) *httptrace.ClientTrace {
+	var resolutionMu sync.Mutex
+	var resolution *types.Resolution
+	mark := func(at func(t *types.Timings) *time.Time) {
+		now := time.Now()
+		f.UpdateTimings(func(t *types.Timings) {
+			if ts := at(t); ts.IsZero() {
+				*ts = now
+			}
+		})
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			resolutionMu.Lock()
+			defer resolutionMu.Unlock()
+			if resolution == nil {
+				resolution = &types.Resolution{Host: info.Host, Start: time.Now()}
+			}
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			resolutionMu.Lock()
+			defer resolutionMu.Unlock()
+			if resolution == nil || !resolution.Done.IsZero() {
+				return
+			}
+			resolution.Done = time.Now()
+			for _, addr := range info.Addrs {
+				resolution.Addrs = append(resolution.Addrs, addr.String())
+			}
+			if info.Err != nil {
+				resolution.Error = info.Err.Error()
+			}
+			f.SetResolution(resolution)
+		},
+		ConnectStart: func(string, string) {
+			mark(func(t *types.Timings) *time.Time { return &t.DialStart })
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				mark(func(t *types.Timings) *time.Time { return &t.Connected })
+			}
+		},
+		// http.Transport also reports the handshakes of the connections it was handed
+		// ready, only those of the connections the flow dialed count
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			f.UpdateTimings(func(t *types.Timings) {
+				if !t.Connected.IsZero() && t.TLSEstablished.IsZero() {
+					t.TLSEstablished = now
+				}
+			})
+		},
+		GotFirstResponseByte: func() {
+			mark(func(t *types.Timings) *time.Time { return &t.FirstResponseByte })
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package attacker
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// clientHelloSpec builds a uTLS ClientHelloSpec replaying the client's raw ClientHello
+// record, with the SNI and ALPN protocols taken from config.
+func clientHelloSpec(raw []byte, config *tls.Config) (*utls.ClientHelloSpec, error) {
+	fingerprinter := &utls.Fingerprinter{AllowBluntMimicry: true}
+	spec, err := fingerprinter.FingerprintClientHello(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions := spec.Extensions[:0]
+	for _, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *utls.SNIExtension:
+			e.ServerName = config.ServerName
+		case *utls.ALPNExtension:
+			if len(config.NextProtos) == 0 {
+				continue
+			}
+			e.AlpnProtocols = config.NextProtos
+		}
+		extensions = append(extensions, ext)
+	}
+	spec.Extensions = extensions
+	return spec, nil
+}
+
+// uTLSHandshake performs the upstream TLS handshake over c sending the ClientHello of spec.
+func uTLSHandshake(ctx context.Context, c net.Conn, spec *utls.ClientHelloSpec, config *tls.Config) (*utls.UConn, error) {
+	uconfig := &utls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		KeyLogWriter:       config.KeyLogWriter,
+		NextProtos:         config.NextProtos,
+	}
+	for _, c := range config.Certificates {
+		uconfig.Certificates = append(uconfig.Certificates, utls.Certificate{
+			Certificate: c.Certificate,
+			PrivateKey:  c.PrivateKey,
+			Leaf:        c.Leaf,
+		})
+	}
+	uconn := utls.UClient(c, uconfig, utls.HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		return nil, err
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return uconn, nil
+}
+
+// uTLSConnectionState converts the state of a uTLS connection for addons and flows.
+func uTLSConnectionState(state utls.ConnectionState) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		Version:                     state.Version,
+		HandshakeComplete:           state.HandshakeComplete,
+		DidResume:                   state.DidResume,
+		CipherSuite:                 state.CipherSuite,
+		NegotiatedProtocol:          state.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  true,
+		ServerName:                  state.ServerName,
+		PeerCertificates:            state.PeerCertificates,
+		VerifiedChains:              state.VerifiedChains,
+		SignedCertificateTimestamps: state.SignedCertificateTimestamps,
+		OCSPResponse:                state.OCSPResponse,
+		TLSUnique:                   state.TLSUnique,
+		ECHAccepted:                 state.ECHAccepted,
+	}
+}
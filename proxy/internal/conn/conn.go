@@ -3,9 +3,12 @@ package conn
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/atomic"
@@ -19,7 +22,12 @@ type ClientConn struct {
 	NegotiatedProtocol string
 	UpstreamCert       bool // Connect to upstream server to look up certificate details. Default: True
 	ClientHello        *tls.ClientHelloInfo
-	CloseChan          chan struct{} // Channel that is closed when the connection is closed
+	OfferedALPN        []string             // ALPN protocols offered by the client in its ClientHello
+	OfferedExtensions  []uint16             // TLS extension IDs offered by the client, in ClientHello order
+	RawClientHello     []byte               // the client's TLS record carrying its ClientHello, recorded when mimicking it upstream
+	PeerCertificates   []*x509.Certificate  // certificates presented by the client, verified as configured by ClientAuth
+	TLSState           *tls.ConnectionState // state of the TLS connection with the client, nil until its handshake completes
+	CloseChan          chan struct{}        // Channel that is closed when the connection is closed
 }
 
 // NewClientConn creates a new ClientConn instance.
@@ -32,11 +40,27 @@ func NewClientConn(c net.Conn) *ClientConn {
 	}
 }
 
+// SetClientHello records the client's ClientHello along with the offered ALPN protocols and extensions.
+func (c *ClientConn) SetClientHello(chi *tls.ClientHelloInfo) {
+	c.ClientHello = chi
+	c.OfferedALPN = append([]string(nil), chi.SupportedProtos...)
+	c.OfferedExtensions = append([]uint16(nil), chi.Extensions...)
+}
+
 func (c *ClientConn) MarshalJSON() ([]byte, error) {
 	m := make(map[string]any)
 	m["id"] = c.ID
 	m["tls"] = c.TLS
 	m["address"] = c.Conn.RemoteAddr().String()
+	if len(c.PeerCertificates) > 0 {
+		m["clientCertificate"] = c.PeerCertificates[0].Subject.String()
+	}
+	if c.TLSState != nil {
+		m["tlsInfo"] = NewTLSInfo(c.TLSState)
+	}
+	if len(c.OfferedALPN) > 0 {
+		m["offeredAlpn"] = c.OfferedALPN
+	}
 	return json.Marshal(m)
 }
 
@@ -48,6 +72,52 @@ type ServerConn struct {
 	Client   *http.Client
 	TLSConn  *tls.Conn
 	TLSState *tls.ConnectionState
+
+	// UTLSConn is the *utls.UConn to the server when the client's ClientHello is
+	// mimicked, in which case TLSConn is nil.
+	UTLSConn net.Conn
+
+	// PeerCertificates is the certificate chain presented by the upstream server, leaf first.
+	// It is recorded by the handshake of intercepted TLS connections; HTTP/3 upstreams have none.
+	PeerCertificates []*x509.Certificate
+
+	dialMu    sync.Mutex
+	dial      DialTimes
+	dialTaken bool
+}
+
+// DialTimes are the points in time an upstream connection went through while it was
+// established.
+type DialTimes struct {
+	Start          time.Time
+	Connected      time.Time
+	TLSEstablished time.Time
+}
+
+// RecordDial records when the connection was dialed and established.
+func (c *ServerConn) RecordDial(start, connected time.Time) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	c.dial.Start, c.dial.Connected = start, connected
+}
+
+// RecordTLSEstablished records when the TLS handshake with the server completed.
+func (c *ServerConn) RecordTLSEstablished(t time.Time) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	c.dial.TLSEstablished = t
+}
+
+// TakeDialTimes returns the recorded dial times to the first caller only, so that they
+// are attributed to the first flow on the connection.
+func (c *ServerConn) TakeDialTimes() (DialTimes, bool) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	if c.dialTaken {
+		return DialTimes{}, false
+	}
+	c.dialTaken = true
+	return c.dial, true
 }
 
 // NewServerConn creates a new ServerConn instance.
@@ -66,9 +136,24 @@ func (c *ServerConn) MarshalJSON() ([]byte, error) {
 		peername = c.Conn.RemoteAddr().String()
 	}
 	m["peername"] = peername
+	if len(c.PeerCertificates) > 0 {
+		m["certificateChain"] = c.CertificateChain()
+	}
+	if c.TLSState != nil {
+		m["tlsInfo"] = NewTLSInfo(c.TLSState)
+	}
 	return json.Marshal(m)
 }
 
+// CertificateChain summarizes the certificate chain presented by the upstream server.
+func (c *ServerConn) CertificateChain() []CertificateInfo {
+	chain := make([]CertificateInfo, 0, len(c.PeerCertificates))
+	for _, cert := range c.PeerCertificates {
+		chain = append(chain, NewCertificateInfo(cert))
+	}
+	return chain
+}
+
 // GetTLSState returns the TLS connection state.
 func (c *ServerConn) GetTLSState() *tls.ConnectionState {
 	return c.TLSState
@@ -1,11 +1,20 @@
 package conn_test
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
 	uuid "github.com/satori/go.uuid"
 
+	"github.com/denisvmedia/go-mitmproxy/cert"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/conn"
 )
 
@@ -59,3 +68,136 @@ func TestContextFlowCountCanIncrement(t *testing.T) {
 
 	c.Assert(connCtx.FlowCount.Load(), qt.Equals, uint32(5))
 }
+
+func TestServerConnCertificateChain(t *testing.T) {
+	c := qt.New(t)
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	leaf, err := ca.GetCert("127.0.0.1")
+	c.Assert(err, qt.IsNil)
+	leafCert, err := x509.ParseCertificate(leaf.Certificate[0])
+	c.Assert(err, qt.IsNil)
+
+	server := conn.NewServerConn()
+	server.PeerCertificates = []*x509.Certificate{leafCert, ca.GetRootCA()}
+
+	chain := server.CertificateChain()
+	c.Assert(chain, qt.HasLen, 2)
+	c.Assert(chain[0].Subject, qt.Equals, "CN=127.0.0.1,O=mitmproxy")
+	c.Assert(chain[0].Issuer, qt.Equals, chain[1].Subject)
+	c.Assert(chain[0].IPAddresses, qt.DeepEquals, []string{"127.0.0.1"})
+	c.Assert(chain[0].NotAfter, qt.Equals, leafCert.NotAfter)
+	sum := sha256.Sum256(leafCert.Raw)
+	c.Assert(chain[0].Fingerprint, qt.Equals, hex.EncodeToString(sum[:]))
+
+	data, err := json.Marshal(server)
+	c.Assert(err, qt.IsNil)
+	var decoded struct {
+		CertificateChain []conn.CertificateInfo `json:"certificateChain"`
+	}
+	c.Assert(json.Unmarshal(data, &decoded), qt.IsNil)
+	c.Assert(decoded.CertificateChain, qt.HasLen, 2)
+	c.Assert(decoded.CertificateChain[1].Fingerprint, qt.Equals, chain[1].Fingerprint)
+}
+
+func TestConnTLSInfo(t *testing.T) {
+	c := qt.New(t)
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	client := conn.NewClientConn(pipe)
+	client.SetClientHello(&tls.ClientHelloInfo{SupportedProtos: []string{"h2", "http/1.1"}})
+	client.TLSState = &tls.ConnectionState{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		NegotiatedProtocol: "h2",
+		ServerName:         "example.com",
+	}
+	server := conn.NewServerConn()
+	server.TLSState = &tls.ConnectionState{
+		Version:     tls.VersionTLS12,
+		CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		ServerName:  "example.com",
+	}
+
+	var decoded struct {
+		TLSInfo     *conn.TLSInfo `json:"tlsInfo"`
+		OfferedALPN []string      `json:"offeredAlpn"`
+	}
+	data, err := json.Marshal(client)
+	c.Assert(err, qt.IsNil)
+	c.Assert(json.Unmarshal(data, &decoded), qt.IsNil)
+	c.Assert(decoded.TLSInfo, qt.DeepEquals, &conn.TLSInfo{
+		Version:     "TLS 1.3",
+		CipherSuite: "TLS_AES_128_GCM_SHA256",
+		ALPN:        "h2",
+		SNI:         "example.com",
+	})
+	c.Assert(decoded.OfferedALPN, qt.DeepEquals, []string{"h2", "http/1.1"})
+
+	// the server did not negotiate a protocol, so the flows on it are HTTP/1.1
+	decoded.TLSInfo = nil
+	data, err = json.Marshal(server)
+	c.Assert(err, qt.IsNil)
+	c.Assert(json.Unmarshal(data, &decoded), qt.IsNil)
+	c.Assert(decoded.TLSInfo, qt.DeepEquals, &conn.TLSInfo{
+		Version:     "TLS 1.2",
+		CipherSuite: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		SNI:         "example.com",
+	})
+
+	// plain connections have none
+	data, err = json.Marshal(conn.NewServerConn())
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Not(qt.Contains), "tlsInfo")
+}
+
+func TestWrapClientConnPeeksWholeTLSRecord(t *testing.T) {
+	c := qt.New(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	record := bytes.Repeat([]byte{0x16}, 5+1<<14)
+	go func() {
+		_, _ = client.Write(record)
+	}()
+
+	wcc := conn.NewWrapClientConn(server, nil)
+	peek, err := wcc.Peek(len(record))
+	c.Assert(err, qt.IsNil)
+	c.Assert(peek, qt.HasLen, len(record))
+}
+
+func TestWrapClientConnTunnelConn(t *testing.T) {
+	c := qt.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("peeked and more"))
+	}()
+
+	tcpConn, err := net.Dial("tcp", ln.Addr().String())
+	c.Assert(err, qt.IsNil)
+	defer tcpConn.Close()
+	wcc := conn.NewWrapClientConn(tcpConn, nil)
+	_, err = wcc.Peek(6)
+	c.Assert(err, qt.IsNil)
+
+	// the bytes read ahead come first, the rest is read from the TCP connection
+	raw, readAhead, ok := wcc.TunnelConn()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(raw, qt.Equals, tcpConn)
+	rest, err := io.ReadAll(raw)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(readAhead)+string(rest), qt.Equals, "peeked and more")
+
+	// the bytes of a captured connection go through the capture
+	wcc.CaptureWire(conn.NewWireCapture(io.Discard))
+	_, _, ok = wcc.TunnelConn()
+	c.Assert(ok, qt.IsFalse)
+}
@@ -0,0 +1,22 @@
+package conn
+
+import "crypto/tls"
+
+// TLSInfo summarizes what a TLS handshake negotiated, e.g. to tell why a connection
+// ended up as HTTP/1.1 rather than HTTP/2.
+type TLSInfo struct {
+	Version     string `json:"version"`        // e.g. "TLS 1.3"
+	CipherSuite string `json:"cipherSuite"`    // e.g. "TLS_AES_128_GCM_SHA256"
+	ALPN        string `json:"alpn,omitempty"` // the negotiated protocol, empty when ALPN was not used
+	SNI         string `json:"sni,omitempty"`  // the server name sent by the client
+}
+
+// NewTLSInfo summarizes state.
+func NewTLSInfo(state *tls.ConnectionState) TLSInfo {
+	return TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+		SNI:         state.ServerName,
+	}
+}
@@ -0,0 +1,108 @@
+package conn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Directions of the bytes recorded by WireCapture.
+const (
+	WireClientToProxy = "client>proxy"
+	WireProxyToClient = "proxy>client"
+	WireProxyToServer = "proxy>server"
+	WireServerToProxy = "server>proxy"
+)
+
+// WireCapture records the exact bytes the proxy reads from and writes to connections,
+// decrypted but before any HTTP parsing, to a writer. Each read or write is a record of
+// a header line followed by the bytes and a newline:
+//
+//	# 2024-05-01T12:00:00.123456Z 0b6c0a4e-8e44-4a5c-9a4e-2f1e1a6b8c3d client>proxy 78
+//	GET http://example.com/ HTTP/1.1
+//	...
+//
+// The ID is the one of the client connection, which the flows of the connection share
+// in their ConnContext. The methods of a nil WireCapture return the connections as they are.
+type WireCapture struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWireCapture creates a WireCapture writing to w.
+func NewWireCapture(w io.Writer) *WireCapture {
+	return &WireCapture{w: w}
+}
+
+func (wc *WireCapture) record(connCtx *Context, direction string, p []byte) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	_, err := fmt.Fprintf(wc.w, "# %s %s %s %d\n", time.Now().UTC().Format(time.RFC3339Nano), connCtx.ID(), direction, len(p))
+	if err == nil {
+		_, err = wc.w.Write(p)
+	}
+	if err == nil {
+		_, err = io.WriteString(wc.w, "\n")
+	}
+	if err != nil {
+		slog.Debug("failed to write wire capture", "error", err)
+	}
+}
+
+// ClientConn returns c recording the bytes exchanged with the client of connCtx.
+func (wc *WireCapture) ClientConn(c net.Conn, connCtx *Context) net.Conn {
+	return wc.wrap(c, connCtx, WireClientToProxy, WireProxyToClient)
+}
+
+// ServerConn returns c recording the bytes exchanged with the server of connCtx.
+func (wc *WireCapture) ServerConn(c net.Conn, connCtx *Context) net.Conn {
+	return wc.wrap(c, connCtx, WireServerToProxy, WireProxyToServer)
+}
+
+func (wc *WireCapture) wrap(c net.Conn, connCtx *Context, read, written string) net.Conn {
+	if wc == nil {
+		return c
+	}
+	captured := &wireConn{Conn: c, capture: wc, connCtx: connCtx, read: read, written: written}
+	// HTTP/2 servers and clients read the TLS state of their connection
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		return &wireTLSConn{wireConn: captured, tlsConn: tlsConn}
+	}
+	return captured
+}
+
+type wireConn struct {
+	net.Conn
+	capture       *WireCapture
+	connCtx       *Context
+	read, written string
+}
+
+func (c *wireConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.capture.record(c.connCtx, c.read, p[:n])
+	}
+	return n, err
+}
+
+func (c *wireConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.capture.record(c.connCtx, c.written, p[:n])
+	}
+	return n, err
+}
+
+type wireTLSConn struct {
+	*wireConn
+	tlsConn *tls.Conn
+}
+
+func (c *wireTLSConn) ConnectionState() tls.ConnectionState {
+	return c.tlsConn.ConnectionState()
+}
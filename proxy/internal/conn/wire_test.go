@@ -0,0 +1,51 @@
+package conn_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/conn"
+)
+
+func TestWireCapture(t *testing.T) {
+	c := qt.New(t)
+	var out bytes.Buffer
+	wire := conn.NewWireCapture(&out)
+	connCtx := conn.NewContext(conn.NewClientConn(nil))
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	captured := wire.ServerConn(local, connCtx)
+	defer captured.Close()
+
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := remote.Read(buf)
+		_, _ = remote.Write(bytes.ToUpper(buf[:n]))
+	}()
+	_, err := captured.Write([]byte("ping"))
+	c.Assert(err, qt.IsNil)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(captured, buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(buf), qt.Equals, "PING")
+
+	id := regexp.QuoteMeta(connCtx.ID().String())
+	c.Assert(out.String(), qt.Matches, `# \S+Z `+id+` proxy>server 4\nping\n`+
+		`# \S+Z `+id+` server>proxy 4\nPING\n`)
+}
+
+func TestNilWireCapture(t *testing.T) {
+	c := qt.New(t)
+	var wire *conn.WireCapture
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	c.Assert(wire.ClientConn(local, nil), qt.Equals, local)
+}
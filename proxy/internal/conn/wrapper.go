@@ -2,9 +2,12 @@ package conn
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/tls"
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
 // AddonNotifier defines callbacks for addon notifications.
@@ -13,6 +16,10 @@ type AddonNotifier interface {
 	NotifyServerDisconnected(*Context)
 }
 
+// peekBufferSize lets Peek return a whole TLS record, a header and up to 16 KiB of
+// payload; ClientHellos with post-quantum key shares do not fit in the default 4 KiB.
+const peekBufferSize = 5 + 1<<14
+
 // WrapClientConn wraps a net.Conn for remote client connections.
 type WrapClientConn struct {
 	net.Conn
@@ -24,13 +31,15 @@ type WrapClientConn struct {
 	closed    bool
 	closeErr  error
 	CloseChan chan struct{}
+
+	wire atomic.Pointer[WireCapture]
 }
 
 // NewWrapClientConn creates a new wrapped client connection.
 func NewWrapClientConn(c net.Conn, addonNotifier AddonNotifier) *WrapClientConn {
 	return &WrapClientConn{
 		Conn:          c,
-		r:             bufio.NewReader(c),
+		r:             bufio.NewReaderSize(c, peekBufferSize),
 		addonNotifier: addonNotifier,
 		CloseChan:     make(chan struct{}),
 	}
@@ -41,9 +50,65 @@ func (c *WrapClientConn) Peek(n int) ([]byte, error) {
 	return c.r.Peek(n)
 }
 
+// Buffered returns the number of bytes read ahead, which Peek returns without reading.
+func (c *WrapClientConn) Buffered() int {
+	return c.r.Buffered()
+}
+
 // Read reads data from the connection.
 func (c *WrapClientConn) Read(data []byte) (int, error) {
-	return c.r.Read(data)
+	n, err := c.r.Read(data)
+	if wire := c.wire.Load(); wire != nil && n > 0 {
+		wire.record(c.ConnCtx, WireClientToProxy, data[:n])
+	}
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c *WrapClientConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	if wire := c.wire.Load(); wire != nil && n > 0 {
+		wire.record(c.ConnCtx, WireProxyToClient, data[:n])
+	}
+	return n, err
+}
+
+// CaptureWire records the bytes read from and written to the connection with wire, until
+// StopWireCapture. Peeked bytes are recorded once they are read.
+func (c *WrapClientConn) CaptureWire(wire *WireCapture) {
+	c.wire.Store(wire)
+}
+
+// StopWireCapture stops recording the bytes of the connection, e.g. once it became a
+// tunnel whose bytes are not HTTP, or are captured decrypted elsewhere.
+func (c *WrapClientConn) StopWireCapture() {
+	c.wire.Store(nil)
+}
+
+// TCPConn returns the TCP connection of the client, beneath the TLS connection when the
+// proxy listens with TLS.
+func (c *WrapClientConn) TCPConn() (*net.TCPConn, bool) {
+	nc := c.Conn
+	if tlsConn, ok := nc.(*tls.Conn); ok {
+		nc = tlsConn.NetConn()
+	}
+	tcpConn, ok := nc.(*net.TCPConn)
+	return tcpConn, ok
+}
+
+// TunnelConn returns the TCP connection of the client, for a tunnel to copy its bytes
+// directly, along with the bytes already read ahead from it, which come first. It fails
+// when the proxy listens with TLS or the bytes are recorded by a wire capture. The
+// connection must not be read through c afterwards.
+func (c *WrapClientConn) TunnelConn() (*net.TCPConn, []byte, bool) {
+	tcpConn, ok := c.Conn.(*net.TCPConn)
+	if !ok || c.wire.Load() != nil {
+		return nil, nil, false
+	}
+	readAhead, _ := c.r.Peek(c.r.Buffered())
+	readAhead = bytes.Clone(readAhead)
+	_, _ = c.r.Discard(len(readAhead))
+	return tcpConn, readAhead, true
 }
 
 // Close closes the connection and notifies addons.
@@ -91,6 +156,14 @@ func NewWrapServerConn(c net.Conn, connCtx *Context, addonNotifier AddonNotifier
 	}
 }
 
+// TunnelConn returns the TCP connection to the server, for a tunnel to copy its bytes
+// directly. It fails when the connection goes through a SOCKS5 or HTTPS upstream
+// proxy.
+func (c *WrapServerConn) TunnelConn() (*net.TCPConn, bool) {
+	tcpConn, ok := c.Conn.(*net.TCPConn)
+	return tcpConn, ok
+}
+
 // Close closes the connection and notifies addons.
 func (c *WrapServerConn) Close() error {
 	c.closeMu.Lock()
@@ -111,7 +184,7 @@ func (c *WrapServerConn) Close() error {
 	if !c.ConnCtx.ClientConn.TLS {
 		// Try to close read on the client connection
 		if wcc, ok := c.ConnCtx.ClientConn.Conn.(*WrapClientConn); ok {
-			if tcpConn, ok := wcc.Conn.(*net.TCPConn); ok {
+			if tcpConn, ok := wcc.TCPConn(); ok {
 				_ = tcpConn.CloseRead()
 			}
 		}
@@ -42,13 +42,41 @@ type Addon interface {
 	// Stream response body modifier
 	StreamResponseModifier(*Flow, io.Reader) io.Reader
 
+	// An event of a text/event-stream response has been read, including its terminating blank line.
+	// The returned event is sent to the client in its place; nil drops it. Compressed
+	// streams and events over 1 MiB are forwarded without this event.
+	ServerSentEvent(f *Flow, event []byte) []byte
+
 	// onAccessProxyServer
 	AccessProxyServer(req *http.Request, res http.ResponseWriter)
+
+	// A plain HTTP proxy request has been received. Addons may fix or rewrite req.URL
+	// before it is routed and the upstream address is computed.
+	RewriteTarget(req *http.Request)
+
+	// A WebSocket connection has been established. The flow holds the upgrade request and the 101 response.
+	WebsocketStart(*Flow)
+
+	// A WebSocket message has been received from the client or the server.
+	// The message content can be modified, or the message dropped by setting msg.Dropped.
+	WebsocketMessage(*Flow, *WebSocketMessage)
+
+	// A WebSocket connection has been closed.
+	WebsocketEnd(*Flow)
+
+	// Dialing the server, the TLS handshake with it or the round trip failed, or the flow
+	// was killed with Flow.Kill; the error is also in f.Error. By default the client gets a
+	// 502; an addon may answer it instead by setting f.Response to a new response, unless
+	// the flow was killed.
+	OnError(f *Flow, err error)
 }
 
 // AddonRegistry manages a collection of addons.
 type AddonRegistry interface {
+	// Get returns a copy of the addons.
 	Get() []Addon
+	// View returns the addons without copying them; the list must not be modified.
+	View() []Addon
 }
 
 // BaseAddon provides default no-op implementations of all Addon methods.
@@ -65,7 +93,13 @@ func (*BaseAddon) Responseheaders(*Flow)                                    {}
 func (*BaseAddon) Response(*Flow)                                           {}
 func (*BaseAddon) StreamRequestModifier(_ *Flow, in io.Reader) io.Reader    { return in }
 func (*BaseAddon) StreamResponseModifier(_ *Flow, in io.Reader) io.Reader   { return in }
+func (*BaseAddon) ServerSentEvent(_ *Flow, event []byte) []byte             { return event }
 func (*BaseAddon) AccessProxyServer(_ *http.Request, _ http.ResponseWriter) {}
+func (*BaseAddon) RewriteTarget(*http.Request)                              {}
+func (*BaseAddon) WebsocketStart(*Flow)                                     {}
+func (*BaseAddon) WebsocketMessage(*Flow, *WebSocketMessage)                {}
+func (*BaseAddon) WebsocketEnd(*Flow)                                       {}
+func (*BaseAddon) OnError(*Flow, error)                                     {}
 
 // AddonNotifier defines the interface for notifying addons about connection events.
 // This is used by the internal conn package to notify about disconnections.
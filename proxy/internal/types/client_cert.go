@@ -0,0 +1,86 @@
+package types
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+)
+
+// ClientCertificate is a client certificate the proxy presents to upstream servers
+// that ask for one, so services requiring mutual TLS can be intercepted.
+type ClientCertificate struct {
+	// Hosts are the upstream hosts the certificate is presented to: "example.com",
+	// "*.example.com" or "*", optionally with a port such as "example.com:8443".
+	Hosts       []string
+	Certificate tls.Certificate
+}
+
+// LoadClientCertificate loads a client certificate for hosts from a PEM certificate
+// file and a PEM key file.
+func LoadClientCertificate(hosts []string, certFile, keyFile string) (ClientCertificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return ClientCertificate{}, err
+	}
+	return ClientCertificate{Hosts: hosts, Certificate: certificate}, nil
+}
+
+// ClientCertificates selects the client certificate for an upstream server.
+// The first entry matching the server wins.
+type ClientCertificates []ClientCertificate
+
+// Lookup returns the client certificate for the upstream server at address (host:port),
+// or nil if there is none.
+func (cc ClientCertificates) Lookup(address string) *tls.Certificate {
+	for i := range cc {
+		if helper.MatchHost(address, cc[i].Hosts) {
+			return &cc[i].Certificate
+		}
+	}
+	return nil
+}
+
+// Apply sets the client certificate for the upstream server at address on config.
+func (cc ClientCertificates) Apply(config *tls.Config, address string) {
+	if c := cc.Lookup(address); c != nil {
+		config.Certificates = []tls.Certificate{*c}
+	}
+}
+
+// getClientCertificate is a tls.Config.GetClientCertificate callback for configs shared
+// by connections to many servers. The server address comes from the handshake context,
+// see WithUpstreamAddress.
+func (cc ClientCertificates) getClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	address, ok := cri.Context().Value(upstreamAddressKey{}).(string)
+	if !ok {
+		return &tls.Certificate{}, nil
+	}
+	c := cc.Lookup(address)
+	if c == nil {
+		return &tls.Certificate{}, nil // no certificate is sent
+	}
+	return c, nil
+}
+
+type upstreamAddressKey struct{}
+
+// WithUpstreamAddress returns a copy of ctx carrying the address (host:port) of the upstream
+// server of a request, which selects the client certificate in clients created by
+// DefaultClientFactory.
+func WithUpstreamAddress(ctx context.Context, address string) context.Context {
+	return context.WithValue(ctx, upstreamAddressKey{}, address)
+}
+
+// UpstreamAddress returns host with the default port of scheme if it has none.
+func UpstreamAddress(host, scheme string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if scheme == "https" || scheme == "wss" {
+		port = "443"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,41 @@
+package types_test
+
+import (
+	"crypto/tls"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestClientCertificatesLookup(t *testing.T) {
+	c := qt.New(t)
+
+	api := tls.Certificate{Certificate: [][]byte{[]byte("api")}}
+	wildcard := tls.Certificate{Certificate: [][]byte{[]byte("wildcard")}}
+	certs := types.ClientCertificates{
+		{Hosts: []string{"api.example.com:8443"}, Certificate: api},
+		{Hosts: []string{"*.example.com"}, Certificate: wildcard},
+	}
+
+	c.Assert(certs.Lookup("api.example.com:8443"), qt.DeepEquals, &api)
+	c.Assert(certs.Lookup("api.example.com:443"), qt.DeepEquals, &wildcard)
+	c.Assert(certs.Lookup("example.com:443"), qt.DeepEquals, &wildcard)
+	c.Assert(certs.Lookup("example.org:443"), qt.IsNil)
+
+	config := &tls.Config{}
+	certs.Apply(config, "example.org:443")
+	c.Assert(config.Certificates, qt.HasLen, 0)
+	certs.Apply(config, "www.example.com:443")
+	c.Assert(config.Certificates, qt.DeepEquals, []tls.Certificate{wildcard})
+}
+
+func TestUpstreamAddress(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(types.UpstreamAddress("example.com", "https"), qt.Equals, "example.com:443")
+	c.Assert(types.UpstreamAddress("example.com", "http"), qt.Equals, "example.com:80")
+	c.Assert(types.UpstreamAddress("example.com:8443", "https"), qt.Equals, "example.com:8443")
+	c.Assert(types.UpstreamAddress("::1", "https"), qt.Equals, "[::1]:443")
+}
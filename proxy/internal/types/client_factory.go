@@ -1,12 +1,18 @@
 package types
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/http2"
 
 	"github.com/denisvmedia/go-mitmproxy/internal/helper"
@@ -21,6 +27,11 @@ type UpstreamManager interface {
 	// The returned function extracts the original request from the context and uses it
 	// to determine the appropriate proxy.
 	RealUpstreamProxy() func(*http.Request) (*url.URL, error)
+
+	// DialContext is the dial function of HTTP client transports that use
+	// RealUpstreamProxy. It connects through the chain of upstream proxies when there
+	// is more than one, which RealUpstreamProxy leaves to it, and directly otherwise.
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
 // ClientFactory is responsible for creating HTTP clients for different scenarios.
@@ -51,9 +62,106 @@ type ClientFactory interface {
 	CreateHTTPSClient(tlsConn *tls.Conn) *http.Client
 }
 
+// ConnClientFactory is implemented by a ClientFactory that also creates the clients of
+// upstream TLS connections that are not a *tls.Conn: a *utls.UConn when the client's
+// ClientHello is mimicked, or a connection recorded by wire capture. Factories that do
+// not implement it get the clients of DefaultClientFactory for those connections.
+type ConnClientFactory interface {
+	// CreateHTTP2ConnClient is CreateHTTP2Client for any TLS connection.
+	CreateHTTP2ConnClient(conn net.Conn) *http.Client
+
+	// CreateHTTPSConnClient is CreateHTTPSClient for any TLS connection.
+	CreateHTTPSConnClient(conn net.Conn) *http.Client
+}
+
+// HTTP3ClientFactory is implemented by a ClientFactory that also creates the HTTP/3
+// (QUIC) client, used for upstream requests of flows intercepted on the HTTP/3
+// listener. Factories that do not implement it get the client of DefaultClientFactory.
+type HTTP3ClientFactory interface {
+	// CreateHTTP3Client creates an HTTP/3 client dialing its own QUIC connections to
+	// the upstream servers.
+	CreateHTTP3Client(insecureSkipVerify bool) *http.Client
+}
+
+// expectContinueTimeout is how long requests with "Expect: 100-continue" wait for the
+// upstream server to ask for the body before the body is sent anyway.
+const expectContinueTimeout = time.Second
+
+// Defaults of the connection pool of the main client.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
 // DefaultClientFactory is the default implementation of ClientFactory.
 // It creates clients with the standard configuration used by the proxy.
-type DefaultClientFactory struct{}
+type DefaultClientFactory struct {
+	// ClientCertificates are presented to upstream servers asking for a client
+	// certificate by the main and the HTTP/3 clients.
+	ClientCertificates ClientCertificates
+
+	// MaxIdleConns, MaxIdleConnsPerHost and IdleConnTimeout tune the connection pool of
+	// the main client, as the fields of http.Transport do. Zero uses 100 idle
+	// connections, 32 of them per host, closed after 90 seconds idle; a negative
+	// MaxIdleConns or IdleConnTimeout removes the limit. The net/http default of 2 idle
+	// connections per host would have busy rewritten hosts dial, and close, a
+	// connection for most requests, running out of ports.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	opened atomic.Int64
+	closed atomic.Int64
+}
+
+// ClientPoolStats are statistics of the connections of a client.
+type ClientPoolStats struct {
+	Opened int64 // connections dialed so far
+	Open   int64 // connections currently open, in use or idle
+}
+
+// MainClientPoolStats returns the statistics of the connections of the main clients
+// created by the factory. Requests minus opened connections are the reused ones.
+func (f *DefaultClientFactory) MainClientPoolStats() ClientPoolStats {
+	opened := f.opened.Load()
+	return ClientPoolStats{Opened: opened, Open: opened - f.closed.Load()}
+}
+
+// countConns returns dial counting the connections it opens and their closing.
+func (f *DefaultClientFactory) countConns(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		f.opened.Add(1)
+		return &countedConn{Conn: c, closed: &f.closed}, nil
+	}
+}
+
+// countedConn counts its closing in closed.
+type countedConn struct {
+	net.Conn
+	closed *atomic.Int64
+	once   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.closed.Add(1) })
+	return c.Conn.Close()
+}
+
+// poolSetting returns value, or def when it is zero and no limit when it is negative.
+func poolSetting[T int | time.Duration](value, def T) T {
+	switch {
+	case value == 0:
+		return def
+	case value < 0:
+		return 0
+	}
+	return value
+}
 
 // NewDefaultClientFactory creates a new DefaultClientFactory.
 func NewDefaultClientFactory() *DefaultClientFactory {
@@ -61,16 +169,26 @@ func NewDefaultClientFactory() *DefaultClientFactory {
 }
 
 // CreateMainClient implements ClientFactory.
-func (*DefaultClientFactory) CreateMainClient(upstreamManager UpstreamManager, insecureSkipVerify bool) *http.Client {
+func (f *DefaultClientFactory) CreateMainClient(upstreamManager UpstreamManager, insecureSkipVerify bool) *http.Client {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+		KeyLogWriter:       helper.GetTLSKeyLogWriter(),
+	}
+	if len(f.ClientCertificates) > 0 {
+		// the config is shared by all servers, the request context tells them apart
+		tlsConfig.GetClientCertificate = f.ClientCertificates.getClientCertificate
+	}
 	return &http.Client{
 		Transport: &http.Transport{
-			Proxy:              upstreamManager.RealUpstreamProxy(),
-			ForceAttemptHTTP2:  true,
-			DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: insecureSkipVerify,
-				KeyLogWriter:       helper.GetTLSKeyLogWriter(),
-			},
+			Proxy:                 upstreamManager.RealUpstreamProxy(),
+			DialContext:           f.countConns(upstreamManager.DialContext),
+			ForceAttemptHTTP2:     true,
+			DisableCompression:    true, // To get the original response from the server, set Transport.DisableCompression to true.
+			ExpectContinueTimeout: expectContinueTimeout,
+			TLSClientConfig:       tlsConfig,
+			MaxIdleConns:          poolSetting(f.MaxIdleConns, defaultMaxIdleConns),
+			MaxIdleConnsPerHost:   cmp.Or(max(f.MaxIdleConnsPerHost, 0), defaultMaxIdleConnsPerHost),
+			IdleConnTimeout:       poolSetting(f.IdleConnTimeout, defaultIdleConnTimeout),
 		},
 		CheckRedirect: func(*http.Request, []*http.Request) error {
 			// Disable automatic redirects
@@ -80,7 +198,12 @@ func (*DefaultClientFactory) CreateMainClient(upstreamManager UpstreamManager, i
 }
 
 // CreateHTTP2Client implements ClientFactory.
-func (*DefaultClientFactory) CreateHTTP2Client(tlsConn *tls.Conn) *http.Client {
+func (f *DefaultClientFactory) CreateHTTP2Client(tlsConn *tls.Conn) *http.Client {
+	return f.CreateHTTP2ConnClient(tlsConn)
+}
+
+// CreateHTTP2ConnClient implements ConnClientFactory.
+func (*DefaultClientFactory) CreateHTTP2ConnClient(tlsConn net.Conn) *http.Client {
 	return &http.Client{
 		Transport: &http2.Transport{
 			DialTLSContext: func(_ context.Context, _, _ string, _ *tls.Config) (net.Conn, error) {
@@ -102,8 +225,9 @@ func (*DefaultClientFactory) CreatePlainHTTPClient(conn net.Conn) *http.Client {
 			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
 				return conn, nil
 			},
-			ForceAttemptHTTP2:  false, // disable http2
-			DisableCompression: true,  // To get the original response from the server, set Transport.DisableCompression to true.
+			ForceAttemptHTTP2:     false, // disable http2
+			DisableCompression:    true,  // To get the original response from the server, set Transport.DisableCompression to true.
+			ExpectContinueTimeout: expectContinueTimeout,
 		},
 		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
 			// Disable automatic redirects
@@ -113,14 +237,20 @@ func (*DefaultClientFactory) CreatePlainHTTPClient(conn net.Conn) *http.Client {
 }
 
 // CreateHTTPSClient implements ClientFactory.
-func (*DefaultClientFactory) CreateHTTPSClient(tlsConn *tls.Conn) *http.Client {
+func (f *DefaultClientFactory) CreateHTTPSClient(tlsConn *tls.Conn) *http.Client {
+	return f.CreateHTTPSConnClient(tlsConn)
+}
+
+// CreateHTTPSConnClient implements ConnClientFactory.
+func (*DefaultClientFactory) CreateHTTPSConnClient(tlsConn net.Conn) *http.Client {
 	return &http.Client{
 		Transport: &http.Transport{
 			DialTLSContext: func(_ context.Context, _, _ string) (net.Conn, error) {
 				return tlsConn, nil
 			},
-			ForceAttemptHTTP2:  true,
-			DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
+			ForceAttemptHTTP2:     true,
+			DisableCompression:    true, // To get the original response from the server, set Transport.DisableCompression to true.
+			ExpectContinueTimeout: expectContinueTimeout,
 		},
 		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
 			// Disable automatic redirects
@@ -128,3 +258,28 @@ func (*DefaultClientFactory) CreateHTTPSClient(tlsConn *tls.Conn) *http.Client {
 		},
 	}
 }
+
+// CreateHTTP3Client implements HTTP3ClientFactory.
+func (f *DefaultClientFactory) CreateHTTP3Client(insecureSkipVerify bool) *http.Client {
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: insecureSkipVerify,
+			KeyLogWriter:       helper.GetTLSKeyLogWriter(),
+		},
+		DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
+	}
+	if len(f.ClientCertificates) > 0 {
+		transport.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			tlsCfg = tlsCfg.Clone()
+			f.ClientCertificates.Apply(tlsCfg, addr)
+			return quic.DialAddrEarly(ctx, addr, tlsCfg, cfg)
+		}
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			// Disable automatic redirects
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Cookies parses and returns the cookies sent with the request, from its Cookie headers.
+func (r *Request) Cookies() []*http.Cookie {
+	return (&http.Request{Header: r.Header}).Cookies()
+}
+
+// Cookie returns the named cookie sent with the request, or http.ErrNoCookie.
+func (r *Request) Cookie(name string) (*http.Cookie, error) {
+	return (&http.Request{Header: r.Header}).Cookie(name)
+}
+
+// SetCookie sets the cookie c in the request, replacing any cookie of the same name.
+// Only the name and value of c are sent, requests carry no cookie attributes. The
+// cookies end up in a single Cookie header, and pairs the request already carried are
+// kept as they were. Cookies with an invalid name are ignored.
+func (r *Request) SetCookie(c *http.Cookie) {
+	pair := (&http.Cookie{Name: c.Name, Value: c.Value, Quoted: c.Quoted}).String()
+	if pair == "" {
+		return
+	}
+	r.setCookiePairs(c.Name, pair)
+}
+
+// DeleteCookie removes the named cookie from the request.
+func (r *Request) DeleteCookie(name string) {
+	r.setCookiePairs(name, "")
+}
+
+// setCookiePairs rewrites the Cookie headers as one, without the pairs of the named
+// cookie, and with pair appended unless empty.
+func (r *Request) setCookiePairs(name, pair string) {
+	var pairs []string
+	for _, line := range r.Header.Values("Cookie") {
+		for part := range strings.SplitSeq(line, ";") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			partName, _, _ := strings.Cut(part, "=")
+			if strings.TrimSpace(partName) == name {
+				continue
+			}
+			pairs = append(pairs, part)
+		}
+	}
+	if pair != "" {
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) == 0 {
+		r.Header.Del("Cookie")
+		return
+	}
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Set("Cookie", strings.Join(pairs, "; "))
+}
+
+// Cookies parses and returns the cookies set by the response, from its Set-Cookie headers.
+func (r *Response) Cookies() []*http.Cookie {
+	return (&http.Response{Header: r.Header}).Cookies()
+}
+
+// SetCookie adds a Set-Cookie header for c to the response, replacing any Set-Cookie
+// header of a cookie of the same name. Cookies with an invalid name are ignored.
+func (r *Response) SetCookie(c *http.Cookie) {
+	line := c.String()
+	if line == "" {
+		return
+	}
+	r.deleteSetCookie(c.Name)
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Add("Set-Cookie", line)
+}
+
+// DeleteCookie removes the Set-Cookie headers of the named cookie from the response.
+// To have the client drop a cookie it holds, set one with MaxAge -1 instead.
+func (r *Response) DeleteCookie(name string) {
+	r.deleteSetCookie(name)
+}
+
+func (r *Response) deleteSetCookie(name string) {
+	lines := r.Header.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+	var kept []string
+	for _, line := range lines {
+		if c, err := http.ParseSetCookie(line); err == nil && c.Name == name {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(kept) == 0 {
+		r.Header.Del("Set-Cookie")
+		return
+	}
+	r.Header["Set-Cookie"] = kept
+}
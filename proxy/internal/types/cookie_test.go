@@ -0,0 +1,71 @@
+package types_test
+
+import (
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestRequestCookies(t *testing.T) {
+	c := qt.New(t)
+	req := &types.Request{Header: http.Header{"Cookie": {"a=1; b=2", "c=3"}}}
+
+	c.Assert(req.Cookies(), qt.HasLen, 3)
+	cookie, err := req.Cookie("b")
+	c.Assert(err, qt.IsNil)
+	c.Assert(cookie.Value, qt.Equals, "2")
+	_, err = req.Cookie("d")
+	c.Assert(err, qt.ErrorIs, http.ErrNoCookie)
+
+	req.SetCookie(&http.Cookie{Name: "b", Value: "20", Path: "/ignored"})
+	req.SetCookie(&http.Cookie{Name: "d", Value: "4"})
+	c.Assert(req.Header.Values("Cookie"), qt.DeepEquals, []string{"a=1; c=3; b=20; d=4"})
+
+	req.SetCookie(&http.Cookie{Name: "bad name", Value: "x"})
+	c.Assert(req.Header.Get("Cookie"), qt.Equals, "a=1; c=3; b=20; d=4")
+
+	req.DeleteCookie("a")
+	req.DeleteCookie("c")
+	c.Assert(req.Header.Get("Cookie"), qt.Equals, "b=20; d=4")
+	req.DeleteCookie("b")
+	req.DeleteCookie("d")
+	c.Assert(req.Header.Values("Cookie"), qt.HasLen, 0)
+
+	// a request without headers gets some
+	empty := &types.Request{}
+	empty.SetCookie(&http.Cookie{Name: "a", Value: "1"})
+	c.Assert(empty.Header.Get("Cookie"), qt.Equals, "a=1")
+}
+
+func TestResponseCookies(t *testing.T) {
+	c := qt.New(t)
+	res := &types.Response{Header: http.Header{"Set-Cookie": {
+		"a=1; Path=/",
+		"b=2; HttpOnly",
+	}}}
+
+	cookies := res.Cookies()
+	c.Assert(cookies, qt.HasLen, 2)
+	c.Assert(cookies[1].HttpOnly, qt.IsTrue)
+
+	res.SetCookie(&http.Cookie{Name: "a", Value: "10", Secure: true})
+	res.SetCookie(&http.Cookie{Name: "c", Value: "3", MaxAge: -1})
+	c.Assert(res.Header.Values("Set-Cookie"), qt.DeepEquals, []string{
+		"b=2; HttpOnly",
+		"a=10; Secure",
+		"c=3; Max-Age=0",
+	})
+
+	res.DeleteCookie("b")
+	res.DeleteCookie("a")
+	res.DeleteCookie("c")
+	c.Assert(res.Header.Values("Set-Cookie"), qt.HasLen, 0)
+
+	// a response without headers gets some
+	empty := &types.Response{StatusCode: 200}
+	empty.SetCookie(&http.Cookie{Name: "a", Value: "1"})
+	c.Assert(empty.Header.Get("Set-Cookie"), qt.Equals, "a=1")
+}
@@ -0,0 +1,99 @@
+package types
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ToCurl renders the request as a runnable curl command. If proxyURL is not empty the
+// command sends the request through that proxy, accepting the certificates it generates.
+// Text bodies are passed with --data-raw; other bodies, which a command line argument
+// cannot hold, are piped to curl by printf.
+func (r *Request) ToCurl(proxyURL string) string {
+	args := []string{"curl"}
+
+	switch r.Method {
+	case "", http.MethodGet:
+	case http.MethodHead:
+		args = append(args, "--head")
+	default:
+		args = append(args, "-X", shellQuote(r.Method))
+	}
+
+	switch r.Proto {
+	case "HTTP/1.0":
+		args = append(args, "--http1.0")
+	case "HTTP/2.0":
+		args = append(args, "--http2")
+	case "HTTP/3.0":
+		args = append(args, "--http3")
+	}
+
+	if proxyURL != "" {
+		args = append(args, "--proxy", shellQuote(proxyURL))
+		if r.URL.Scheme == "https" {
+			args = append(args, "--insecure")
+		}
+	}
+
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		// curl computes the length and the host from what it sends
+		if name == "Content-Length" || (name == "Host" && r.Header.Get("Host") == r.URL.Host) {
+			continue
+		}
+		for _, value := range r.Header[name] {
+			args = append(args, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	var input string
+	if len(r.Body) > 0 {
+		if isText(r.Body) {
+			// unlike --data-binary, --data-raw does not read a file for a leading @
+			args = append(args, "--data-raw", shellQuote(string(r.Body)))
+		} else {
+			input = "printf " + printfFormat(r.Body) + " | "
+			args = append(args, "--data-binary", "@-")
+		}
+	}
+
+	args = append(args, shellQuote(r.URL.String()))
+	return input + strings.Join(args, " ")
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// isText reports whether b is printable text.
+func isText(b []byte) bool {
+	return utf8.Valid(b) && !strings.ContainsFunc(string(b), func(r rune) bool {
+		return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+	})
+}
+
+// printfFormat returns a quoted printf format printing b, with octal escapes for the
+// bytes that are not printable ASCII and for the quote, the backslash and the percent sign.
+func printfFormat(b []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for _, c := range b {
+		if c >= 0x20 && c < 0x7f && c != '\'' && c != '\\' && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, `\%03o`, c)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
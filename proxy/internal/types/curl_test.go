@@ -0,0 +1,75 @@
+package types_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func newCurlRequest(c *qt.C, method, rawURL string, body []byte) *types.Request {
+	u, err := url.Parse(rawURL)
+	c.Assert(err, qt.IsNil)
+	return &types.Request{
+		Method: method,
+		URL:    u,
+		Proto:  "HTTP/1.1",
+		Header: make(http.Header),
+		Body:   body,
+	}
+}
+
+func TestRequestToCurl(t *testing.T) {
+	c := qt.New(t)
+
+	req := newCurlRequest(c, http.MethodPost, "https://example.com/upload?a=1", []byte(`{"name":"it's"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", "15")
+	req.Header.Set("Host", "example.com")
+	req.Header["Accept"] = []string{"text/html", "application/json"}
+
+	c.Assert(req.ToCurl(""), qt.Equals, `curl -X 'POST'`+
+		` -H 'Accept: text/html' -H 'Accept: application/json' -H 'Content-Type: application/json'`+
+		` --data-raw '{"name":"it'\''s"}' 'https://example.com/upload?a=1'`)
+}
+
+func TestRequestToCurlThroughProxy(t *testing.T) {
+	c := qt.New(t)
+
+	req := newCurlRequest(c, http.MethodGet, "https://example.com/", nil)
+	req.Proto = "HTTP/2.0"
+	c.Assert(req.ToCurl("http://127.0.0.1:9080"), qt.Equals,
+		`curl --http2 --proxy 'http://127.0.0.1:9080' --insecure 'https://example.com/'`)
+
+	// plain HTTP needs no certificate check override
+	req = newCurlRequest(c, http.MethodHead, "http://example.com/", nil)
+	c.Assert(req.ToCurl("http://127.0.0.1:9080"), qt.Equals,
+		`curl --head --proxy 'http://127.0.0.1:9080' 'http://example.com/'`)
+}
+
+func TestRequestToCurlBinaryBody(t *testing.T) {
+	c := qt.New(t)
+
+	req := newCurlRequest(c, http.MethodPut, "http://example.com/blob", []byte{0x00, 'a', '\'', '%', '\\', 0xff})
+	c.Assert(req.ToCurl(""), qt.Equals,
+		`printf '\000a\047\045\134\377' | curl -X 'PUT' --data-binary @- 'http://example.com/blob'`)
+}
+
+func TestRequestToCurlBodyStartingWithAt(t *testing.T) {
+	c := qt.New(t)
+
+	// curl reads the file named by a --data or --data-binary argument starting with @
+	req := newCurlRequest(c, http.MethodPost, "http://example.com/", []byte("@/etc/passwd"))
+	c.Assert(req.ToCurl(""), qt.Equals, `curl -X 'POST' --data-raw '@/etc/passwd' 'http://example.com/'`)
+}
+
+func TestRequestToCurlKeepsOverriddenHost(t *testing.T) {
+	c := qt.New(t)
+
+	req := newCurlRequest(c, http.MethodGet, "http://127.0.0.1:8080/", nil)
+	req.Header.Set("Host", "example.com")
+	c.Assert(req.ToCurl(""), qt.Equals, `curl -H 'Host: example.com' 'http://127.0.0.1:8080/'`)
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -89,6 +90,111 @@ func (r *Response) ReplaceToDecodedBody() {
 	r.Header.Del("Transfer-Encoding")
 }
 
+// ReplaceToDecodedBody replaces the request body with the decoded version.
+func (req *Request) ReplaceToDecodedBody() {
+	body, err := req.DecodedBody()
+	if err != nil {
+		return
+	}
+
+	req.Body = body
+	req.Header.Del("Content-Encoding")
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Del("Transfer-Encoding")
+}
+
+// ReencodeBody encodes the request body, which must not be encoded yet, with the given
+// content encoding and fixes the Content-Encoding and Content-Length headers.
+// An empty encoding or "identity" leaves the body as is and removes Content-Encoding.
+// Use it after editing the result of DecodedBody to send the edited body upstream
+// in the client's original encoding.
+func (req *Request) ReencodeBody(encoding string) error {
+	body, err := encode(encoding, req.Body)
+	if err != nil {
+		return err
+	}
+
+	req.Body = body
+	setEncodingHeaders(req.Header, encoding, len(body))
+	return nil
+}
+
+// ReencodeBody encodes the response body, which must not be encoded yet, with the given
+// content encoding and fixes the Content-Encoding and Content-Length headers.
+// An empty encoding or "identity" leaves the body as is and removes Content-Encoding.
+func (r *Response) ReencodeBody(encoding string) error {
+	body, err := encode(encoding, r.Body)
+	if err != nil {
+		return err
+	}
+
+	r.Body = body
+	setEncodingHeaders(r.Header, encoding, len(body))
+	return nil
+}
+
+func setEncodingHeaders(header http.Header, encoding string, length int) {
+	if encoding == "" || encoding == "identity" {
+		header.Del("Content-Encoding")
+	} else {
+		header.Set("Content-Encoding", encoding)
+	}
+	header.Set("Content-Length", strconv.Itoa(length))
+	header.Del("Transfer-Encoding")
+}
+
+func encode(enc string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch enc {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "br":
+		w := brotli.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "deflate":
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, errEncodingNotSupport
+}
+
 func decode(enc string, body []byte) ([]byte, error) {
 	switch enc {
 	case "gzip":
@@ -1,11 +1,15 @@
 package types
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 
@@ -20,6 +24,15 @@ type Request struct {
 	Header http.Header
 	Body   []byte
 
+	// Trailer holds the request trailers. It is filled in once the body has been read,
+	// so it is complete in the Request addon event. Trailers set here are sent upstream.
+	Trailer http.Header
+
+	// SpooledBody holds the body, instead of Body, when it is larger than the bodies
+	// buffered in memory and was spooled to a temporary file, which is removed when the
+	// flow ends. An addon setting Body sends that body upstream instead.
+	SpooledBody *SpooledBody
+
 	raw *http.Request
 }
 
@@ -39,12 +52,35 @@ func (r *Request) Raw() *http.Request {
 	return r.raw
 }
 
+// Clone returns a copy of r with its own URL, header, body and trailer, e.g. for an
+// addon to keep a snapshot of the request while later addons change it. The clone
+// shares the underlying http.Request returned by Raw, and the SpooledBody, which can
+// no longer be read once the flow is finished.
+func (r *Request) Clone() *Request {
+	clone := *r
+	if r.URL != nil {
+		u := *r.URL
+		if r.URL.User != nil {
+			user := *r.URL.User
+			u.User = &user
+		}
+		clone.URL = &u
+	}
+	clone.Header = r.Header.Clone()
+	clone.Body = bytes.Clone(r.Body)
+	clone.Trailer = r.Trailer.Clone()
+	return &clone
+}
+
 func (r *Request) MarshalJSON() ([]byte, error) {
 	m := make(map[string]any)
 	m["method"] = r.Method
 	m["url"] = r.URL.String()
 	m["proto"] = r.Proto
 	m["header"] = r.Header
+	if len(r.Trailer) > 0 {
+		m["trailer"] = r.Trailer
+	}
 	return json.Marshal(m)
 }
 
@@ -68,32 +104,47 @@ func (r *Request) UnmarshalJSON(data []byte) error {
 	if !ok {
 		return errors.New("rawheader parse error")
 	}
+	header, err := parseJSONHeader(rawheader)
+	if err != nil {
+		return err
+	}
 
-	header := make(map[string][]string)
-	for k, v := range rawheader {
+	var trailer http.Header
+	if rawtrailer, ok := m["trailer"].(map[string]any); ok {
+		if trailer, err = parseJSONHeader(rawtrailer); err != nil {
+			return err
+		}
+	}
+
+	*r = Request{
+		Method:  m["method"].(string),
+		URL:     u,
+		Proto:   m["proto"].(string),
+		Header:  header,
+		Trailer: trailer,
+	}
+	return nil
+}
+
+func parseJSONHeader(raw map[string]any) (http.Header, error) {
+	header := make(http.Header)
+	for k, v := range raw {
 		vals, ok := v.([]any)
 		if !ok {
-			return errors.New("header parse error")
+			return nil, errors.New("header parse error")
 		}
 
 		svals := make([]string, 0)
 		for _, val := range vals {
 			sval, ok := val.(string)
 			if !ok {
-				return errors.New("header parse error")
+				return nil, errors.New("header parse error")
 			}
 			svals = append(svals, sval)
 		}
 		header[k] = svals
 	}
-
-	*r = Request{
-		Method: m["method"].(string),
-		URL:    u,
-		Proto:  m["proto"].(string),
-		Header: header,
-	}
-	return nil
+	return header, nil
 }
 
 // Response represents an HTTP response in the proxy flow.
@@ -103,10 +154,34 @@ type Response struct {
 	Body       []byte      `json:"-"`
 	BodyReader io.Reader
 
+	// Trailer holds the response trailers. For upstream responses it is filled in once
+	// the body has been read, so it is complete in the Response addon event.
+	// Trailers set here are sent to the client after the body.
+	Trailer http.Header `json:"trailer,omitempty"`
+
+	// SpooledBody holds the body, instead of Body, when it is larger than the bodies
+	// buffered in memory and was spooled to a temporary file, which is removed when the
+	// flow ends. An addon setting Body sends that body to the client instead.
+	SpooledBody *SpooledBody `json:"-"`
+
 	Close bool // connection close
 }
 
-// Flow represents a complete HTTP request/response flow.
+// Clone returns a copy of r with its own header, body and trailer, e.g. for an addon
+// to keep a snapshot of the response while later addons change it. A BodyReader can
+// only be read once, so the clone has none. The SpooledBody is shared, and can no
+// longer be read once the flow is finished.
+func (r *Response) Clone() *Response {
+	clone := *r
+	clone.Header = r.Header.Clone()
+	clone.Body = bytes.Clone(r.Body)
+	clone.BodyReader = nil
+	clone.Trailer = r.Trailer.Clone()
+	return &clone
+}
+
+// Flow represents a complete HTTP request/response flow. The spooled bodies of its
+// request and response, and of their clones, can no longer be read once it is finished.
 type Flow struct {
 	ID          uuid.UUID
 	ConnContext *conn.Context
@@ -115,11 +190,49 @@ type Flow struct {
 
 	// https://docs.mitmproxy.org/stable/overview-features/#streaming
 	// If true, Request.Body and Response.Body are not buffered, and will not enter subsequent Addon.Request and Addon.Response
-	Stream            bool
-	UseSeparateClient bool // use separate http client to send http request
-	done              chan struct{}
+	Stream bool
+
+	// UseSeparateClient forces the request to be sent with the shared separate http client
+	// instead of reusing the client connection's upstream connection. Addons may set it in
+	// Requestheaders or Request to get a fresh upstream connection.
+	UseSeparateClient bool
+
+	// UsedSeparateClient reports whether the request was actually sent with the separate
+	// http client, either because UseSeparateClient was set or because the host/scheme changed.
+	UsedSeparateClient bool
+
+	// IsReplay reports whether the flow replays the request of a recorded flow, see
+	// Proxy.ReplayFlow.
+	IsReplay bool
+
+	// TraceID is the W3C trace-context trace ID of the request, as a 32 character hex string.
+	// It is set by the TraceContext addon and empty otherwise.
+	TraceID string
+
+	// Error is the error the flow failed with, when dialing the server, the TLS
+	// handshake with it or the round trip failed. It is nil otherwise.
+	Error error
+
+	// Metadata holds values addons attach to the flow to cooperate. The values that
+	// can be marshaled are part of the JSON of the flow.
+	Metadata Metadata
+
+	done chan struct{}
+
+	killMu  sync.Mutex
+	killed  chan struct{}
+	killErr error
+
+	timingsMu sync.Mutex
+	timings   Timings
+
+	resolutionMu sync.Mutex
+	resolution   *Resolution
 }
 
+// ErrFlowKilled is the error of the flows killed with Flow.Kill.
+var ErrFlowKilled = errors.New("flow killed")
+
 // NewFlow creates a new Flow instance.
 func NewFlow() *Flow {
 	return &Flow{
@@ -133,15 +246,77 @@ func (f *Flow) Done() <-chan struct{} {
 	return f.done
 }
 
-// Finish marks the flow as complete.
+// Finish marks the flow as complete, recording the end of its timings.
 func (f *Flow) Finish() {
+	f.UpdateTimings(func(t *Timings) {
+		if t.End.IsZero() {
+			t.End = time.Now()
+		}
+	})
 	close(f.done)
 }
 
+// Kill aborts the flow, from any addon event or another goroutine: the request is not
+// sent upstream if it was not yet, an upstream round trip or a streamed body in
+// progress is cut, and the client gets a 502 with its connection closed, or, once the
+// response has started, its connection or HTTP/2 stream reset. The OnError addon event
+// sees an error wrapping ErrFlowKilled with reason; it cannot answer a killed flow.
+// Only the first call counts.
+func (f *Flow) Kill(reason string) {
+	f.killMu.Lock()
+	defer f.killMu.Unlock()
+	if f.killErr != nil {
+		return
+	}
+	f.killErr = ErrFlowKilled
+	if reason != "" {
+		f.killErr = fmt.Errorf("%w: %s", ErrFlowKilled, reason)
+	}
+	if f.killed == nil {
+		f.killed = make(chan struct{})
+	}
+	close(f.killed)
+}
+
+// Killed returns a channel that is closed when the flow is killed.
+func (f *Flow) Killed() <-chan struct{} {
+	f.killMu.Lock()
+	defer f.killMu.Unlock()
+	if f.killed == nil {
+		f.killed = make(chan struct{})
+	}
+	return f.killed
+}
+
+// KillError returns the error the flow was killed with, nil if it was not killed.
+func (f *Flow) KillError() error {
+	f.killMu.Lock()
+	defer f.killMu.Unlock()
+	return f.killErr
+}
+
 func (f *Flow) MarshalJSON() ([]byte, error) {
 	j := make(map[string]any)
 	j["id"] = f.ID
 	j["request"] = f.Request
 	j["response"] = f.Response
+	if f.TraceID != "" {
+		j["traceId"] = f.TraceID
+	}
+	if f.IsReplay {
+		j["isReplay"] = true
+	}
+	if f.Error != nil {
+		j["error"] = f.Error.Error()
+	}
+	if f.Metadata.Len() > 0 {
+		j["metadata"] = &f.Metadata
+	}
+	if timings := f.Timings(); timings != (Timings{}) {
+		j["timings"] = timings
+	}
+	if resolution := f.Resolution(); resolution != nil {
+		j["resolution"] = resolution
+	}
 	return json.Marshal(j)
 }
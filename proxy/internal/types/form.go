@@ -0,0 +1,54 @@
+package types
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// Query parses and returns the query parameters of the request URL. Changing the
+// returned values does not change the request, pass them to SetQuery for that.
+func (r *Request) Query() url.Values {
+	if r.URL == nil {
+		return url.Values{}
+	}
+	return r.URL.Query()
+}
+
+// SetQuery replaces the query of the request URL with the encoded query, sorted by key.
+// Empty query removes it.
+func (r *Request) SetQuery(query url.Values) {
+	if r.URL == nil {
+		r.URL = &url.URL{}
+	}
+	r.URL.RawQuery = query.Encode()
+	r.URL.ForceQuery = false
+}
+
+// ParseForm parses and returns the URL encoded form in the request body, decoding
+// its content encoding first. Like http.Request.ParseForm, bodies of other content
+// types hold no form, and give empty values.
+func (r *Request) ParseForm() (url.Values, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != formContentType {
+		return url.Values{}, nil
+	}
+	body, err := r.DecodedBody()
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(body))
+}
+
+// SetForm replaces the request body with the URL encoded form, sorted by key, and
+// fixes the Content-Type, Content-Encoding and Content-Length headers.
+func (r *Request) SetForm(form url.Values) {
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Body = []byte(form.Encode())
+	r.Header.Set("Content-Type", formContentType)
+	setEncodingHeaders(r.Header, "", len(r.Body))
+}
@@ -0,0 +1,59 @@
+package types_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestRequestQuery(t *testing.T) {
+	c := qt.New(t)
+	u, err := url.Parse("https://example.com/search?q=go&page=1")
+	c.Assert(err, qt.IsNil)
+	req := &types.Request{URL: u}
+
+	query := req.Query()
+	c.Assert(query.Get("q"), qt.Equals, "go")
+	query.Set("q", "go mitmproxy")
+	query.Del("page")
+	c.Assert(req.URL.RawQuery, qt.Equals, "q=go&page=1")
+
+	req.SetQuery(query)
+	c.Assert(req.URL.String(), qt.Equals, "https://example.com/search?q=go+mitmproxy")
+
+	req.SetQuery(nil)
+	c.Assert(req.URL.String(), qt.Equals, "https://example.com/search")
+}
+
+func TestRequestForm(t *testing.T) {
+	c := qt.New(t)
+	req := &types.Request{
+		Header: http.Header{
+			"Content-Type":     {"application/x-www-form-urlencoded; charset=utf-8"},
+			"Content-Encoding": {"gzip"},
+			"Content-Length":   {"100"},
+		},
+		Body: gzipped(c, "user=alice&role=admin"),
+	}
+
+	form, err := req.ParseForm()
+	c.Assert(err, qt.IsNil)
+	c.Assert(form.Get("user"), qt.Equals, "alice")
+
+	form.Set("role", "guest & friends")
+	req.SetForm(form)
+	c.Assert(string(req.Body), qt.Equals, "role=guest+%26+friends&user=alice")
+	c.Assert(req.Header.Get("Content-Type"), qt.Equals, "application/x-www-form-urlencoded")
+	c.Assert(req.Header.Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(req.Header.Get("Content-Length"), qt.Equals, "33")
+
+	// other content types hold no form
+	req.Header.Set("Content-Type", "application/json")
+	form, err = req.ParseForm()
+	c.Assert(err, qt.IsNil)
+	c.Assert(form, qt.HasLen, 0)
+}
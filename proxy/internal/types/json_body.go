@@ -0,0 +1,104 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	errNoRequest  = errors.New("flow has no request")
+	errNoResponse = errors.New("flow has no response")
+)
+
+// GetRequestJSON decodes the JSON request body into v, decoding its content encoding
+// first.
+func (f *Flow) GetRequestJSON(v any) error {
+	if f.Request == nil {
+		return errNoRequest
+	}
+	body, err := f.Request.DecodedBody()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// SetRequestJSON replaces the request body with v marshaled as JSON, see SetResponseJSON.
+func (f *Flow) SetRequestJSON(v any) error {
+	if f.Request == nil {
+		return errNoRequest
+	}
+	if f.Request.Header == nil {
+		f.Request.Header = make(http.Header)
+	}
+	body, err := jsonBody(f.Request.Header, v)
+	if err != nil {
+		return err
+	}
+	f.Request.Body = body
+	return nil
+}
+
+// GetResponseJSON decodes the JSON response body into v, decoding its content encoding
+// first.
+func (f *Flow) GetResponseJSON(v any) error {
+	if f.Response == nil {
+		return errNoResponse
+	}
+	body, err := f.Response.DecodedBody()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// SetResponseJSON replaces the response body with v marshaled as JSON. The body keeps
+// the content encoding of the response, when supported, and the Content-Type, unless
+// it is not a JSON one, and the Content-Length header is fixed. Set in the Request
+// event, before there is a response, it replies to the client with a 200 response
+// without contacting the server.
+func (f *Flow) SetResponseJSON(v any) error {
+	if f.Response == nil {
+		f.Response = &Response{StatusCode: http.StatusOK}
+	}
+	if f.Response.Header == nil {
+		f.Response.Header = make(http.Header)
+	}
+	body, err := jsonBody(f.Response.Header, v)
+	if err != nil {
+		return err
+	}
+	f.Response.Body = body
+	f.Response.BodyReader = nil
+	return nil
+}
+
+// jsonBody marshals v as the body of a message with header, encoded with the content
+// encoding of header, and fixes its headers.
+func jsonBody(header http.Header, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if !isJSONContentType(header.Get("Content-Type")) {
+		header.Set("Content-Type", "application/json")
+	}
+	enc := header.Get("Content-Encoding")
+	body, err := encode(enc, data)
+	if err != nil {
+		enc, body = "", data
+	}
+	setEncodingHeaders(header, enc, len(body))
+	return body, nil
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,92 @@
+package types_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func gzipped(c *qt.C, data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	c.Assert(err, qt.IsNil)
+	c.Assert(w.Close(), qt.IsNil)
+	return buf.Bytes()
+}
+
+func TestFlowResponseJSON(t *testing.T) {
+	c := qt.New(t)
+	f := types.NewFlow()
+	f.Response = &types.Response{
+		StatusCode: 200,
+		Header: http.Header{
+			"Content-Type":     {"application/vnd.api+json"},
+			"Content-Encoding": {"gzip"},
+		},
+		Body: gzipped(c, `{"name":"alice","admin":false}`),
+	}
+
+	var v map[string]any
+	c.Assert(f.GetResponseJSON(&v), qt.IsNil)
+	c.Assert(v["name"], qt.Equals, "alice")
+
+	v["admin"] = true
+	c.Assert(f.SetResponseJSON(v), qt.IsNil)
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "application/vnd.api+json")
+	c.Assert(f.Response.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+	c.Assert(f.Response.Header.Get("Content-Length"), qt.Equals, strconv.Itoa(len(f.Response.Body)))
+	r, err := gzip.NewReader(bytes.NewReader(f.Response.Body))
+	c.Assert(err, qt.IsNil)
+	data, err := io.ReadAll(r)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, `{"admin":true,"name":"alice"}`)
+
+	// unsupported encodings and non JSON types are replaced
+	f.Response.Header.Set("Content-Encoding", "compress")
+	f.Response.Header.Set("Content-Type", "text/plain")
+	c.Assert(f.SetResponseJSON([]int{1}), qt.IsNil)
+	c.Assert(string(f.Response.Body), qt.Equals, "[1]")
+	c.Assert(f.Response.Header.Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(f.Response.Header.Get("Content-Length"), qt.Equals, "3")
+
+	c.Assert(f.SetResponseJSON(make(chan int)), qt.IsNotNil)
+}
+
+func TestFlowSetResponseJSONWithoutResponse(t *testing.T) {
+	c := qt.New(t)
+	f := types.NewFlow()
+
+	c.Assert(f.GetResponseJSON(new(any)), qt.ErrorMatches, "flow has no response")
+	c.Assert(f.SetResponseJSON(map[string]string{"ok": "yes"}), qt.IsNil)
+	c.Assert(f.Response.StatusCode, qt.Equals, 200)
+	c.Assert(string(f.Response.Body), qt.Equals, `{"ok":"yes"}`)
+	c.Assert(f.Response.Header.Get("Content-Type"), qt.Equals, "application/json")
+}
+
+func TestFlowRequestJSON(t *testing.T) {
+	c := qt.New(t)
+	f := types.NewFlow()
+	c.Assert(f.GetRequestJSON(new(any)), qt.ErrorMatches, "flow has no request")
+
+	f.Request = &types.Request{Method: "POST", Body: []byte(`{"page":1}`)}
+	var v struct {
+		Page int `json:"page"`
+	}
+	c.Assert(f.GetRequestJSON(&v), qt.IsNil)
+	c.Assert(v.Page, qt.Equals, 1)
+
+	v.Page = 2
+	c.Assert(f.SetRequestJSON(v), qt.IsNil)
+	c.Assert(string(f.Request.Body), qt.Equals, `{"page":2}`)
+	c.Assert(f.Request.Header.Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(f.Request.Header.Get("Content-Length"), qt.Equals, "10")
+}
@@ -0,0 +1,70 @@
+package types
+
+import (
+	"encoding/json"
+	"maps"
+	"sync"
+)
+
+// Metadata holds values addons attach to a flow to cooperate, like the metadata of
+// mitmproxy flows, e.g. an authentication addon setting the user a logging addon
+// reads. It is safe for concurrent use, and the zero value is empty and ready to use.
+// Keys are best prefixed with the name of the addon, to avoid clashes.
+type Metadata struct {
+	mu     sync.RWMutex
+	values map[string]any
+}
+
+// Get returns the value of key, and whether it is set.
+func (m *Metadata) Get(key string) (any, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Set sets the value of key.
+func (m *Metadata) Set(key string, value any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = make(map[string]any)
+	}
+	m.values[key] = value
+}
+
+// Delete removes key.
+func (m *Metadata) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+}
+
+// Len returns the number of keys set.
+func (m *Metadata) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.values)
+}
+
+// All returns a copy of the values.
+func (m *Metadata) All() map[string]any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.values)
+}
+
+// MarshalJSON marshals the values as a JSON object. Values that cannot be marshaled,
+// such as functions or channels, are left out, so they never break the serialization
+// of the flow.
+func (m *Metadata) MarshalJSON() ([]byte, error) {
+	j := make(map[string]json.RawMessage)
+	for k, v := range m.All() {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		j[k] = raw
+	}
+	return json.Marshal(j)
+}
@@ -0,0 +1,69 @@
+package types_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestMetadata(t *testing.T) {
+	c := qt.New(t)
+	var f types.Flow // the zero value is ready to use
+
+	_, ok := f.Metadata.Get("user")
+	c.Assert(ok, qt.IsFalse)
+	f.Metadata.Set("user", "alice")
+	v, ok := f.Metadata.Get("user")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(v, qt.Equals, "alice")
+
+	all := f.Metadata.All()
+	all["other"] = 1 // a copy
+	c.Assert(f.Metadata.Len(), qt.Equals, 1)
+
+	f.Metadata.Delete("user")
+	c.Assert(f.Metadata.Len(), qt.Equals, 0)
+}
+
+func TestMetadataConcurrentUse(t *testing.T) {
+	c := qt.New(t)
+	f := types.NewFlow()
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := strconv.Itoa(i)
+			f.Metadata.Set(key, i)
+			_, _ = f.Metadata.Get(key)
+			_, _ = json.Marshal(f)
+		}()
+	}
+	wg.Wait()
+	c.Assert(f.Metadata.Len(), qt.Equals, 10)
+}
+
+func TestFlowJSONMetadata(t *testing.T) {
+	c := qt.New(t)
+	f := types.NewFlow()
+
+	data, err := json.Marshal(f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Not(qt.Contains), "metadata")
+
+	f.Metadata.Set("auth.user", "alice")
+	f.Metadata.Set("auth.done", make(chan struct{}))
+	data, err = json.Marshal(f)
+	c.Assert(err, qt.IsNil)
+	var j struct {
+		Metadata map[string]any `json:"metadata"`
+	}
+	c.Assert(json.Unmarshal(data, &j), qt.IsNil)
+	c.Assert(j.Metadata, qt.DeepEquals, map[string]any{"auth.user": "alice"})
+}
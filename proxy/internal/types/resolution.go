@@ -0,0 +1,35 @@
+package types
+
+import "time"
+
+// Resolution is the DNS lookup of the host the proxy connected to for a flow: the server,
+// or the first upstream proxy when there is one.
+type Resolution struct {
+	Host  string    `json:"host"`
+	Addrs []string  `json:"addrs,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Start time.Time `json:"start"`
+	Done  time.Time `json:"done"`
+}
+
+// Duration returns how long the lookup took.
+func (r *Resolution) Duration() time.Duration {
+	return r.Done.Sub(r.Start)
+}
+
+// Resolution returns the DNS lookup of the flow, nil when the flow did not look a host
+// up: it reused an upstream connection, connected to an IP address or was answered by
+// an addon.
+func (f *Flow) Resolution() *Resolution {
+	f.resolutionMu.Lock()
+	defer f.resolutionMu.Unlock()
+	return f.resolution
+}
+
+// SetResolution records the DNS lookup of the flow, which the proxy does when it
+// connects upstream for it. It is safe for concurrent use.
+func (f *Flow) SetResolution(r *Resolution) {
+	f.resolutionMu.Lock()
+	defer f.resolutionMu.Unlock()
+	f.resolution = r
+}
@@ -0,0 +1,39 @@
+package types_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestFlowResolution(t *testing.T) {
+	c := qt.New(t)
+	f := types.NewFlow()
+	c.Assert(f.Resolution(), qt.IsNil)
+
+	data, err := json.Marshal(f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Not(qt.Contains), "resolution")
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.SetResolution(&types.Resolution{
+		Host:  "example.com",
+		Addrs: []string{"93.184.216.34"},
+		Start: start,
+		Done:  start.Add(3 * time.Millisecond),
+	})
+	c.Assert(f.Resolution().Duration(), qt.Equals, 3*time.Millisecond)
+
+	data, err = json.Marshal(f)
+	c.Assert(err, qt.IsNil)
+	var decoded struct {
+		Resolution types.Resolution `json:"resolution"`
+	}
+	c.Assert(json.Unmarshal(data, &decoded), qt.IsNil)
+	c.Assert(decoded.Resolution.Host, qt.Equals, "example.com")
+	c.Assert(decoded.Resolution.Addrs, qt.DeepEquals, []string{"93.184.216.34"})
+}
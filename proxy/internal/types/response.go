@@ -0,0 +1,55 @@
+package types
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// NewResponse creates a response with the given status code, header and body, e.g. for
+// an addon to reply to the client without contacting the server. header may be nil.
+// The Content-Length header is set from the body, and the Content-Type header, unless
+// present, is sniffed from it with http.DetectContentType. Responses with a status
+// that has no body, like 204 and 304, get neither.
+func NewResponse(status int, header http.Header, body []byte) *Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	res := &Response{StatusCode: status, Header: header, Body: body}
+	if !bodyAllowedForStatus(status) {
+		return res
+	}
+	if len(body) > 0 && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return res
+}
+
+// NewJSONResponse creates a 200 response with v marshaled as JSON, see NewResponse.
+func NewJSONResponse(v any) (*Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	return NewResponse(http.StatusOK, header, body), nil
+}
+
+// NewTextResponse creates a 200 response with the plain text s, see NewResponse.
+func NewTextResponse(s string) *Response {
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	return NewResponse(http.StatusOK, header, []byte(s))
+}
+
+// bodyAllowedForStatus reports whether a response with status may have a body, as
+// defined by RFC 9110.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package types_test
+
+import (
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestNewResponse(t *testing.T) {
+	c := qt.New(t)
+
+	res := types.NewResponse(http.StatusForbidden, nil, []byte("<html><body>blocked</body></html>"))
+	c.Assert(res.StatusCode, qt.Equals, http.StatusForbidden)
+	c.Assert(res.Header.Get("Content-Type"), qt.Equals, "text/html; charset=utf-8")
+	c.Assert(res.Header.Get("Content-Length"), qt.Equals, "33")
+
+	header := http.Header{"Content-Type": {"image/svg+xml"}}
+	res = types.NewResponse(http.StatusOK, header, []byte("<svg/>"))
+	c.Assert(res.Header.Get("Content-Type"), qt.Equals, "image/svg+xml")
+
+	res = types.NewResponse(http.StatusOK, nil, nil)
+	c.Assert(res.Header.Get("Content-Type"), qt.Equals, "")
+	c.Assert(res.Header.Get("Content-Length"), qt.Equals, "0")
+
+	res = types.NewResponse(http.StatusNoContent, nil, nil)
+	c.Assert(res.Header, qt.HasLen, 0)
+}
+
+func TestNewJSONResponse(t *testing.T) {
+	c := qt.New(t)
+
+	res, err := types.NewJSONResponse(map[string]bool{"ok": true})
+	c.Assert(err, qt.IsNil)
+	c.Assert(res.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(string(res.Body), qt.Equals, `{"ok":true}`)
+	c.Assert(res.Header.Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(res.Header.Get("Content-Length"), qt.Equals, "11")
+
+	_, err = types.NewJSONResponse(make(chan int))
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestNewTextResponse(t *testing.T) {
+	c := qt.New(t)
+
+	res := types.NewTextResponse("hello")
+	c.Assert(res.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(string(res.Body), qt.Equals, "hello")
+	c.Assert(res.Header.Get("Content-Type"), qt.Equals, "text/plain; charset=utf-8")
+	c.Assert(res.Header.Get("Content-Length"), qt.Equals, "5")
+}
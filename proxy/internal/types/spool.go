@@ -0,0 +1,58 @@
+package types
+
+import (
+	"io"
+	"os"
+)
+
+// SpooledBody is a body larger than the bodies buffered in memory, kept in a temporary
+// file instead, so that the Request and Response addon events can inspect it. It is
+// read at any offset with ReadAt, and removed when the flow ends.
+type SpooledBody struct {
+	file *os.File
+	size int64
+}
+
+// SpoolBody reads r into a new temporary file in dir, or in the default directory for
+// temporary files when dir is empty. It stops after limit+1 bytes, so a body larger than
+// limit is spooled only in part, which Size tells apart. The caller closes the body.
+func SpoolBody(r io.Reader, dir string, limit int64) (*SpooledBody, error) {
+	file, err := os.CreateTemp(dir, "go-mitmproxy-body-*")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(file, io.LimitReader(r, limit+1))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &SpooledBody{file: file, size: size}, nil
+}
+
+// Size returns the length of the body.
+func (b *SpooledBody) Size() int64 {
+	return b.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *SpooledBody) ReadAt(p []byte, off int64) (int, error) {
+	return io.NewSectionReader(b.file, 0, b.size).ReadAt(p, off)
+}
+
+// Reader returns a reader of the whole body, independent of the other readers.
+func (b *SpooledBody) Reader() *io.SectionReader {
+	return io.NewSectionReader(b.file, 0, b.size)
+}
+
+// Close removes the temporary file. It does nothing on a nil body.
+func (b *SpooledBody) Close() error {
+	if b == nil {
+		return nil
+	}
+	err := b.file.Close()
+	if removeErr := os.Remove(b.file.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
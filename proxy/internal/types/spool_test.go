@@ -0,0 +1,55 @@
+package types_test
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestSpoolBody(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	body, err := types.SpoolBody(strings.NewReader("hello, spooled world"), dir, 64)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body.Size(), qt.Equals, int64(20))
+
+	p := make([]byte, 7)
+	n, err := body.ReadAt(p, 7)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(p[:n]), qt.Equals, "spooled")
+
+	// every reader starts from the beginning
+	for range 2 {
+		all, err := io.ReadAll(body.Reader())
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(all), qt.Equals, "hello, spooled world")
+	}
+
+	c.Assert(body.Close(), qt.IsNil)
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 0)
+
+	var none *types.SpooledBody
+	c.Assert(none.Close(), qt.IsNil)
+}
+
+func TestSpoolBodyStopsAfterLimit(t *testing.T) {
+	c := qt.New(t)
+	r := strings.NewReader("0123456789")
+
+	body, err := types.SpoolBody(r, t.TempDir(), 4)
+	c.Assert(err, qt.IsNil)
+	defer body.Close()
+	// one byte more than the limit tells the body is larger
+	c.Assert(body.Size(), qt.Equals, int64(5))
+	rest, err := io.ReadAll(r)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(rest), qt.Equals, "56789")
+}
@@ -0,0 +1,67 @@
+package types
+
+import "time"
+
+// Timings are the points in time a flow went through, zero for those it did not go
+// through: flows sent on an upstream connection that was open before them have no dial
+// and TLS times, and flows answered by an addon have no upstream times at all.
+type Timings struct {
+	// RequestStart is when the proxy got the request headers from the client.
+	RequestStart time.Time `json:"requestStart,omitzero"`
+	// DialStart is when the proxy started to dial the upstream server for the flow.
+	DialStart time.Time `json:"dialStart,omitzero"`
+	// Connected is when the upstream connection was established.
+	Connected time.Time `json:"connected,omitzero"`
+	// TLSEstablished is when the TLS handshake with the upstream server completed.
+	TLSEstablished time.Time `json:"tlsEstablished,omitzero"`
+	// FirstResponseByte is when the first byte of the upstream response arrived.
+	FirstResponseByte time.Time `json:"firstResponseByte,omitzero"`
+	// End is when the flow finished.
+	End time.Time `json:"end,omitzero"`
+}
+
+// Duration returns the time from the request start to the end of the flow, zero
+// while either is unknown.
+func (t Timings) Duration() time.Duration {
+	return between(t.RequestStart, t.End)
+}
+
+// TimeToFirstByte returns the time from the request start to the first byte of the
+// upstream response, zero while either is unknown.
+func (t Timings) TimeToFirstByte() time.Duration {
+	return between(t.RequestStart, t.FirstResponseByte)
+}
+
+// ConnectDuration returns the time it took to connect to the upstream server, zero
+// when the flow did not dial it.
+func (t Timings) ConnectDuration() time.Duration {
+	return between(t.DialStart, t.Connected)
+}
+
+// TLSDuration returns the time the TLS handshake with the upstream server took, zero
+// when the flow did not do it.
+func (t Timings) TLSDuration() time.Duration {
+	return between(t.Connected, t.TLSEstablished)
+}
+
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// Timings returns the points in time the flow went through so far.
+func (f *Flow) Timings() Timings {
+	f.timingsMu.Lock()
+	defer f.timingsMu.Unlock()
+	return f.timings
+}
+
+// UpdateTimings changes the timings of the flow with update, which the proxy uses to
+// record them as the flow goes on. It is safe for concurrent use.
+func (f *Flow) UpdateTimings(update func(t *Timings)) {
+	f.timingsMu.Lock()
+	defer f.timingsMu.Unlock()
+	update(&f.timings)
+}
@@ -0,0 +1,55 @@
+package types_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+func TestTimings(t *testing.T) {
+	c := qt.New(t)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	timings := types.Timings{
+		RequestStart:      start,
+		DialStart:         start.Add(time.Millisecond),
+		Connected:         start.Add(5 * time.Millisecond),
+		TLSEstablished:    start.Add(12 * time.Millisecond),
+		FirstResponseByte: start.Add(40 * time.Millisecond),
+	}
+	c.Assert(timings.ConnectDuration(), qt.Equals, 4*time.Millisecond)
+	c.Assert(timings.TLSDuration(), qt.Equals, 7*time.Millisecond)
+	c.Assert(timings.TimeToFirstByte(), qt.Equals, 40*time.Millisecond)
+	c.Assert(timings.Duration(), qt.Equals, time.Duration(0)) // not finished
+
+	timings.End = start.Add(50 * time.Millisecond)
+	c.Assert(timings.Duration(), qt.Equals, 50*time.Millisecond)
+	c.Assert(types.Timings{}.ConnectDuration(), qt.Equals, time.Duration(0))
+}
+
+func TestFlowTimings(t *testing.T) {
+	c := qt.New(t)
+	f := types.NewFlow()
+
+	data, err := json.Marshal(f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Not(qt.Contains), "timings")
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.UpdateTimings(func(t *types.Timings) { t.RequestStart = start })
+	f.Finish()
+	c.Assert(f.Timings().End.IsZero(), qt.IsFalse)
+
+	data, err = json.Marshal(f)
+	c.Assert(err, qt.IsNil)
+	var j struct {
+		Timings map[string]string `json:"timings"`
+	}
+	c.Assert(json.Unmarshal(data, &j), qt.IsNil)
+	c.Assert(j.Timings["requestStart"], qt.Equals, "2024-05-01T12:00:00Z")
+	c.Assert(j.Timings["end"], qt.Not(qt.Equals), "")
+	c.Assert(j.Timings, qt.HasLen, 2)
+}
@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+// WebSocket message types, equal to the RFC 6455 data frame opcodes.
+const (
+	WebSocketText   = 1
+	WebSocketBinary = 2
+)
+
+// WebSocketMessage is a complete (reassembled) WebSocket data message.
+type WebSocketMessage struct {
+	Type       int  // WebSocketText or WebSocketBinary
+	FromClient bool // true if the message was sent by the client
+	Content    []byte
+	Timestamp  time.Time
+
+	// Dropped can be set by the WebsocketMessage addon event to not forward the message.
+	Dropped bool
+}
+
+// IsText reports whether the message is a text message.
+func (m *WebSocketMessage) IsText() bool {
+	return m.Type == WebSocketText
+}
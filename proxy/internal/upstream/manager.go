@@ -5,8 +5,11 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/internal/resolver"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/proxycontext"
 )
 
@@ -14,7 +17,8 @@ import (
 // It manages the logic for connecting to upstream servers and determining
 // which proxy to use for each request.
 type Manager struct {
-	// upstream is the upstream proxy address (e.g., "http://proxy:8080").
+	// upstream is the upstream proxy address (e.g., "http://proxy:8080"), or a
+	// comma-separated chain of them, the first of which is connected to first.
 	// If empty, the manager will use environment variables (HTTP_PROXY, HTTPS_PROXY).
 	upstream string
 
@@ -23,6 +27,23 @@ type Manager struct {
 	sslInsecure bool
 
 	upstreamProxy func(*http.Request) (*url.URL, error)
+
+	// dialer is used for every outgoing TCP connection, to servers and to upstream proxies.
+	dialer *net.Dialer
+
+	// tcpNoDelay enables TCP_NODELAY on outgoing connections.
+	tcpNoDelay bool
+
+	// resolver looks up the hosts of outgoing connections, the system resolver when nil.
+	resolver resolver.Resolver
+
+	// proxyAuth authenticates the CONNECT requests to HTTP upstream proxies, with Basic
+	// and the user info of their URLs when nil.
+	proxyAuth helper.ProxyAuth
+
+	// localAddr returns the local IP address the outgoing connections for a request are
+	// bound to, none when nil or when it returns nil.
+	localAddr func(*http.Request) net.IP
 }
 
 // NewManager creates a new Manager with the given configuration.
@@ -32,9 +53,43 @@ func NewManager(upstream string, sslInsecure bool) *Manager {
 	return &Manager{
 		upstream:    upstream,
 		sslInsecure: sslInsecure,
+		dialer:      &net.Dialer{},
 	}
 }
 
+// SetTCPOptions configures keep-alive and nodelay for outgoing connections.
+// KeepAlive has the same meaning as net.Dialer.KeepAlive: zero uses the default period,
+// negative disables keep-alive.
+func (m *Manager) SetTCPOptions(keepAlive time.Duration, noDelay bool) {
+	m.dialer = &net.Dialer{KeepAlive: keepAlive}
+	m.tcpNoDelay = noDelay
+}
+
+// SetResolver makes outgoing connections look up the servers and upstream proxies they
+// connect to with r instead of the system resolver.
+func (m *Manager) SetResolver(r resolver.Resolver) {
+	m.resolver = r
+}
+
+// SetProxyAuth makes the CONNECT requests to HTTP upstream proxies authenticate with
+// auth, for schemes beyond Basic like NTLM or Negotiate. The HTTP client transports
+// then connect through the proxies with DialContext rather than on their own.
+func (m *Manager) SetProxyAuth(auth helper.ProxyAuth) {
+	m.proxyAuth = auth
+}
+
+// SetLocalAddr binds the outgoing connections for a request, to servers and upstream
+// proxies, to the local IP address fn returns for it, so that multi-homed hosts can
+// choose the egress interface. A nil address lets the system choose.
+func (m *Manager) SetLocalAddr(fn func(*http.Request) net.IP) {
+	m.localAddr = fn
+}
+
+// Dialer returns the dialer used for outgoing connections.
+func (m *Manager) Dialer() *net.Dialer {
+	return m.dialer
+}
+
 // SetUpstreamProxy sets a custom upstream proxy function.
 // This function will be called to determine the proxy URL for each request.
 // If not set, the manager will use the config.Upstream or environment variables.
@@ -43,49 +98,145 @@ func (m *Manager) SetUpstreamProxy(fn func(*http.Request) (*url.URL, error)) {
 }
 
 // GetUpstreamConn establishes a connection to the upstream server.
-// It determines the appropriate proxy (if any) and creates a connection
-// to the target server, either directly or through the proxy.
+// It determines the appropriate proxies (if any) and creates a connection
+// to the target server, either directly or through the proxies.
 func (m *Manager) GetUpstreamConn(ctx context.Context, req *http.Request) (net.Conn, error) {
-	proxyURL, err := m.GetUpstreamProxyURL(req)
+	chain, err := m.GetUpstreamProxyChain(req)
 	if err != nil {
 		return nil, err
 	}
+	return m.dial(ctx, req, chain, helper.CanonicalAddr(req.URL))
+}
+
+// dial connects to address for req, which may be nil, through chain, directly when it
+// is empty.
+func (m *Manager) dial(ctx context.Context, req *http.Request, chain []*url.URL, address string) (net.Conn, error) {
+	dialer := m.dialerFor(req)
+	dialDirect := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return resolver.Dial(ctx, dialer, m.resolver, network, address)
+	}
 	var conn net.Conn
-	address := helper.CanonicalAddr(req.URL)
-	if proxyURL != nil {
-		conn, err = helper.GetProxyConn(ctx, proxyURL, address, m.sslInsecure)
+	var err error
+	if len(chain) > 0 {
+		conn, err = helper.GetProxyChainConn(ctx, dialDirect, chain, address, m.sslInsecure, m.proxyAuth)
 	} else {
-		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		conn, err = dialDirect(ctx, "tcp", address)
 	}
-	return conn, err
+	if err != nil {
+		return nil, err
+	}
+	if m.tcpNoDelay {
+		if err := helper.SetTCPOptions(conn, 0, true); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// dialerFor returns the dialer of the outgoing connections for req, bound to the local
+// address chosen for it, if any.
+func (m *Manager) dialerFor(req *http.Request) *net.Dialer {
+	if m.localAddr == nil || req == nil {
+		return m.dialer
+	}
+	ip := m.localAddr(req)
+	if ip == nil {
+		return m.dialer
+	}
+	dialer := *m.dialer
+	dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	return &dialer
 }
 
-// GetUpstreamProxyURL returns the upstream proxy URL for a given request.
+// GetUpstreamProxyURL returns the upstream proxy URL for a given request, the first
+// one of a chain.
 // It checks in order:
 // 1. Custom upstream proxy function (if set via SetUpstreamProxy)
 // 2. Upstream field (if configured)
 // 3. Environment variables (HTTP_PROXY, HTTPS_PROXY, etc.)
 func (m *Manager) GetUpstreamProxyURL(req *http.Request) (*url.URL, error) {
+	chain, err := m.GetUpstreamProxyChain(req)
+	if err != nil || len(chain) == 0 {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// GetUpstreamProxyChain returns the upstream proxies to go through for a given request,
+// in the order they are connected to, empty to connect to the server directly. It looks
+// them up like GetUpstreamProxyURL; only the Upstream field can hold more than one.
+func (m *Manager) GetUpstreamProxyChain(req *http.Request) ([]*url.URL, error) {
 	if m.upstreamProxy != nil {
-		return m.upstreamProxy(req)
+		proxyURL, err := m.upstreamProxy(req)
+		if err != nil || proxyURL == nil {
+			return nil, err
+		}
+		return []*url.URL{proxyURL}, nil
 	}
 	if len(m.upstream) > 0 {
-		return url.Parse(m.upstream)
+		var chain []*url.URL
+		for _, hop := range strings.Split(m.upstream, ",") {
+			proxyURL, err := url.Parse(strings.TrimSpace(hop))
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, proxyURL)
+		}
+		return chain, nil
 	}
 	cReq := &http.Request{URL: &url.URL{Scheme: "https", Host: req.Host}}
-	return http.ProxyFromEnvironment(cReq)
+	proxyURL, err := http.ProxyFromEnvironment(cReq)
+	if err != nil || proxyURL == nil {
+		return nil, err
+	}
+	return []*url.URL{proxyURL}, nil
 }
 
 // RealUpstreamProxy returns a function that resolves upstream proxy for HTTP client transport.
 // This is used by the HTTP client to determine the proxy for each request.
 // The returned function extracts the original request from the context and uses it
 // to determine the appropriate proxy.
+// A chain of proxies is more than the transport can go through, and so are the handshakes
+// of SetProxyAuth, so the function returns no proxy for them and the transport has to
+// connect through the proxies with DialContext.
 func (m *Manager) RealUpstreamProxy() func(*http.Request) (*url.URL, error) {
 	return func(cReq *http.Request) (*url.URL, error) {
 		req, ok := proxycontext.GetProxyRequest(cReq.Context())
 		if !ok {
 			panic("failed to get original request from context")
 		}
-		return m.GetUpstreamProxyURL(req)
+		chain, err := m.GetUpstreamProxyChain(req)
+		if err != nil || len(chain) == 0 || m.tunnels(chain) {
+			return nil, err
+		}
+		return chain[0], nil
+	}
+}
+
+// DialContext is the dial function of HTTP client transports that use RealUpstreamProxy.
+// It connects to addr through the proxies of the original request in ctx when the
+// transport cannot go through them, and directly otherwise: to the server, or to the
+// proxy the transport goes through.
+func (m *Manager) DialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	var chain []*url.URL
+	req, ok := proxycontext.GetProxyRequest(ctx)
+	if ok {
+		var err error
+		chain, err = m.GetUpstreamProxyChain(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !m.tunnels(chain) {
+		chain = nil
 	}
+	return m.dial(ctx, req, chain, addr)
+}
+
+// tunnels reports whether HTTP client transports connect through chain with DialContext,
+// and not through its proxy on their own: when there is more than one, or when they
+// authenticate with SetProxyAuth.
+func (m *Manager) tunnels(chain []*url.URL) bool {
+	return len(chain) > 1 || len(chain) == 1 && m.proxyAuth != nil
 }
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// RFC 6455 opcodes of continuation and close frames.
+const (
+	opContinuation = 0x0
+	opClose        = 0x8
+)
+
+// closeMessageTooBig is the RFC 6455 status code closing a connection whose message is
+// too big to process.
+const closeMessageTooBig = 1009
+
+// maxFramePayload bounds the memory used by a single frame.
+const maxFramePayload = 64 << 20
+
+// maxMessageSize bounds the memory used by a message reassembled from its fragments.
+var maxMessageSize = 64 << 20
+
+var (
+	errFrameTooLarge   = errors.New("websocket frame too large")
+	errMessageTooLarge = errors.New("websocket message too large")
+)
+
+// frame is a single WebSocket frame with an unmasked payload.
+type frame struct {
+	fin     bool
+	rsv     byte // RSV1-3 bits in their wire position
+	opcode  byte
+	payload []byte
+}
+
+func (f *frame) isControl() bool {
+	return f.opcode&0x8 != 0
+}
+
+// readFrame reads one frame from r and unmasks its payload.
+func readFrame(r io.Reader) (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		rsv:    head[0] & 0x70,
+		opcode: head[0] & 0x0f,
+	}
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxFramePayload {
+		return nil, errFrameTooLarge
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame writes f to w. Frames sent by clients must be masked.
+func writeFrame(w io.Writer, f *frame, mask bool) error {
+	buf := make([]byte, 0, 14+len(f.payload))
+
+	b0 := f.rsv | f.opcode
+	if f.fin {
+		b0 |= 0x80
+	}
+	buf = append(buf, b0)
+
+	var b1 byte
+	if mask {
+		b1 = 0x80
+	}
+	length := len(f.payload)
+	switch {
+	case length < 126:
+		buf = append(buf, b1|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if !mask {
+		buf = append(buf, f.payload...)
+		_, err := w.Write(buf)
+		return err
+	}
+
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	buf = append(buf, key[:]...)
+	start := len(buf)
+	buf = append(buf, f.payload...)
+	maskBytes(key, buf[start:])
+	_, err := w.Write(buf)
+	return err
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
@@ -0,0 +1,43 @@
+// Justification for whitebox testing:
+// The frame codec is unexported and only reachable through a live WebSocket connection.
+// These tests check masking and the extended payload length encodings directly.
+
+package websocket
+
+import (
+	"bytes"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 0xffff, 0x10000} {
+		for _, mask := range []bool{false, true} {
+			c := qt.New(t)
+
+			payload := bytes.Repeat([]byte{'x'}, size)
+			in := &frame{fin: true, opcode: 0x2, payload: payload}
+
+			var buf bytes.Buffer
+			c.Assert(writeFrame(&buf, in, mask), qt.IsNil)
+			c.Assert(buf.Bytes()[1]&0x80 != 0, qt.Equals, mask)
+
+			out, err := readFrame(&buf)
+			c.Assert(err, qt.IsNil)
+			c.Assert(out.fin, qt.IsTrue)
+			c.Assert(out.opcode, qt.Equals, byte(0x2))
+			c.Assert(out.payload, qt.DeepEquals, payload)
+			// masking must not modify the caller's payload
+			c.Assert(in.payload, qt.DeepEquals, payload)
+		}
+	}
+}
+
+func TestReadFrameRejectsLargePayload(t *testing.T) {
+	c := qt.New(t)
+
+	header := []byte{0x82, 127, 0xff, 0, 0, 0, 0, 0, 0, 0}
+	_, err := readFrame(bytes.NewReader(header))
+	c.Assert(err, qt.Equals, errFrameTooLarge)
+}
@@ -1,29 +1,100 @@
 package websocket
 
 import (
+	"bufio"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"reflect"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/proxycontext"
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
 )
 
 // Handler implements WebSocket handling for the proxy.
-type Handler struct{}
+type Handler struct {
+	addonRegistry      types.AddonRegistry
+	insecureSkipVerify bool
+	clientCertificates types.ClientCertificates
+}
+
+// New creates a new WebSocket handler. Messages are reported to the addons of addonRegistry,
+// which may be nil.
+func New(addonRegistry types.AddonRegistry, insecureSkipVerify bool) *Handler {
+	return &Handler{
+		addonRegistry:      addonRegistry,
+		insecureSkipVerify: insecureSkipVerify,
+	}
+}
 
-// New creates a new WebSocket handler.
-func New() *Handler {
-	return &Handler{}
+// SetClientCertificates sets the client certificates presented to wss:// servers asking for one.
+func (h *Handler) SetClientCertificates(clientCertificates types.ClientCertificates) {
+	h.clientCertificates = clientCertificates
 }
 
 // HandleWSS handles WebSocket Secure (WSS) connections.
-// It upgrades the connection and forwards traffic between client and server.
-func (*Handler) HandleWSS(res http.ResponseWriter, req *http.Request) {
+// It forwards the upgrade to the server and then relays the frames between client and server,
+// passing every data message through the WebsocketMessage addon event.
+func (h *Handler) HandleWSS(res http.ResponseWriter, req *http.Request) {
 	logger := slog.Default().With(
 		"in", "websocket.HandleWSS",
 		"host", req.Host,
 	)
+	h.handle(res, req, logger, func(host string) (net.Conn, error) {
+		address := withDefaultPort(host, "443")
+		config := &tls.Config{InsecureSkipVerify: h.insecureSkipVerify}
+		h.clientCertificates.Apply(config, address)
+		return tls.Dial("tcp", address, config)
+	})
+}
+
+// HandleWS handles plain (ws://) WebSocket connections, like HandleWSS does for wss://.
+func (h *Handler) HandleWS(res http.ResponseWriter, req *http.Request) {
+	logger := slog.Default().With(
+		"in", "websocket.HandleWS",
+		"host", req.Host,
+	)
+	h.handle(res, req, logger, func(host string) (net.Conn, error) {
+		return net.Dial("tcp", withDefaultPort(host, "80"))
+	})
+}
+
+// HandleWSConn handles a plain WebSocket connection like HandleWS, over server, a
+// connection to the server already dialed, which it closes when done.
+func (h *Handler) HandleWSConn(res http.ResponseWriter, req *http.Request, server net.Conn) {
+	logger := slog.Default().With(
+		"in", "websocket.HandleWSConn",
+		"host", req.Host,
+	)
+	h.handle(res, req, logger, func(string) (net.Conn, error) {
+		return server, nil
+	})
+}
+
+func withDefaultPort(host, port string) string {
+	if !strings.Contains(host, ":") {
+		return host + ":" + port
+	}
+	return host
+}
+
+func (h *Handler) handle(res http.ResponseWriter, req *http.Request, logger *slog.Logger, dial func(host string) (net.Conn, error)) {
+	addons := h.addons()
+	inspect := slices.ContainsFunc(addons, handlesMessages)
+	if inspect {
+		// Messages can only be inspected if they are not compressed.
+		req.Header.Del("Sec-WebSocket-Extensions")
+	}
 
 	upgradeBuf, err := httputil.DumpRequest(req, false)
 	if err != nil {
@@ -32,21 +103,17 @@ func (*Handler) HandleWSS(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	cconn, _, err := res.(http.Hijacker).Hijack()
+	cconn, cbuf, err := res.(http.Hijacker).Hijack()
 	if err != nil {
-		slog.Error("Hijack failed", "error", err)
+		logger.Error("Hijack failed", "error", err)
 		res.WriteHeader(502)
 		return
 	}
 	defer cconn.Close()
 
-	host := req.Host
-	if !strings.Contains(host, ":") {
-		host += ":443"
-	}
-	conn, err := tls.Dial("tcp", host, nil)
+	conn, err := dial(req.Host)
 	if err != nil {
-		slog.Error("tls.Dial failed", "error", err)
+		logger.Error("dial failed", "error", err)
 		return
 	}
 	defer conn.Close()
@@ -56,43 +123,211 @@ func (*Handler) HandleWSS(res http.ResponseWriter, req *http.Request) {
 		logger.Error("wss upgrade failed", "error", err)
 		return
 	}
-	transfer(logger, conn, cconn)
+
+	sbuf := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(sbuf, req)
+	if err != nil {
+		logger.Error("read upgrade response failed", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if err := resp.Write(cconn); err != nil {
+		logger.Error("write upgrade response failed", "error", err)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return
+	}
+
+	f := types.NewFlow()
+	f.Request = types.NewRequest(req)
+	f.Response = &types.Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
+	if connCtx, ok := proxycontext.GetConnContext(req.Context()); ok {
+		f.ConnContext = connCtx
+	}
+
+	s := &session{
+		flow:    f,
+		addons:  addons,
+		inspect: inspect,
+		logger:  logger,
+	}
+	s.run(conn, sbuf, cconn, cbuf.Reader)
 }
 
-// transfer bidirectionally transfers data between two connections.
-func transfer(logger *slog.Logger, server, client io.ReadWriteCloser) {
-	done := make(chan struct{})
-	defer close(done)
+func (h *Handler) addons() []types.Addon {
+	if h.addonRegistry == nil {
+		return nil
+	}
+	return h.addonRegistry.View()
+}
 
-	errChan := make(chan error)
-	go func() {
-		_, err := io.Copy(server, client)
-		logger.Debug("client copy end", "error", err)
-		client.Close()
-		select {
-		case <-done:
-			return
-		case errChan <- err:
-			return
+var (
+	baseAddonType    = reflect.TypeFor[types.BaseAddon]()
+	baseAddonPtrType = reflect.TypeFor[*types.BaseAddon]()
+)
+
+// handlesMessages reports whether addon handles the WebsocketMessage event, that is
+// whether its WebsocketMessage method is not the no-op of an embedded BaseAddon.
+// Methods promoted from embedded fields are followed to the field declaring them.
+func handlesMessages(addon types.Addon) bool {
+	t := reflect.TypeOf(addon)
+	for {
+		if t == baseAddonType || t == baseAddonPtrType {
+			return false
+		}
+		method, ok := t.MethodByName("WebsocketMessage")
+		if !ok {
+			return false
+		}
+		pc := method.Func.Pointer()
+		if file, _ := runtime.FuncForPC(pc).FileLine(pc); file != "<autogenerated>" {
+			return true
+		}
+		// a wrapper of a method promoted from an embedded field
+		embedded := promotingField(t, "WebsocketMessage")
+		if embedded == nil {
+			return true
+		}
+		t = embedded
+	}
+}
+
+// promotingField returns the type of the embedded field of the struct t, or of the
+// struct t points to, whose method name t has, or nil if there is none.
+func promotingField(t reflect.Type, name string) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		if _, ok := field.Type.MethodByName(name); ok {
+			return field.Type
 		}
+		if ptr := reflect.PointerTo(field.Type); field.Type.Kind() != reflect.Pointer {
+			if _, ok := ptr.MethodByName(name); ok {
+				return ptr
+			}
+		}
+	}
+	return nil
+}
+
+// session relays the frames of one WebSocket connection.
+type session struct {
+	flow    *types.Flow
+	addons  []types.Addon
+	inspect bool // whether messages are reassembled and passed to the addons
+	logger  *slog.Logger
+
+	mu sync.Mutex // serializes addon events of both directions
+}
+
+func (s *session) run(server net.Conn, serverReader io.Reader, client net.Conn, clientReader io.Reader) {
+	for _, addon := range s.addons {
+		addon.WebsocketStart(s.flow)
+	}
+	defer func() {
+		for _, addon := range s.addons {
+			addon.WebsocketEnd(s.flow)
+		}
+		s.flow.Finish()
 	}()
+
+	done := make(chan struct{}, 2)
 	go func() {
-		_, err := io.Copy(client, server)
-		logger.Debug("server copy end", "error", err)
-		server.Close()
-
-		select {
-		case <-done:
-			return
-		case errChan <- err:
-			return
-		}
+		err := s.relay(clientReader, server, true)
+		s.logger.Debug("client relay end", "error", err)
+		s.closeIfTooLarge(err, server, client)
+		done <- struct{}{}
+	}()
+	go func() {
+		err := s.relay(serverReader, client, false)
+		s.logger.Debug("server relay end", "error", err)
+		s.closeIfTooLarge(err, server, client)
+		done <- struct{}{}
 	}()
 
-	for i := 0; i < 2; i++ {
-		if err := <-errChan; err != nil {
-			logger.Debug("transfer error", "error", err)
-			return // If there's an error, return immediately
+	// Once one side is gone, the other one is closed too.
+	<-done
+	client.Close()
+	server.Close()
+	<-done
+}
+
+// closeIfTooLarge closes both sides with status 1009 when err is errMessageTooLarge.
+// Frames are written whole, so this does not interleave with the other relay.
+func (s *session) closeIfTooLarge(err error, server, client net.Conn) {
+	if !errors.Is(err, errMessageTooLarge) {
+		return
+	}
+	payload := binary.BigEndian.AppendUint16(nil, closeMessageTooBig)
+	closeFrame := &frame{fin: true, opcode: opClose, payload: payload}
+	_ = writeFrame(server, closeFrame, true)
+	_ = writeFrame(client, closeFrame, false)
+}
+
+// relay copies frames from src to dst until src fails. When the session inspects
+// messages, fragmented data messages are reassembled, passed to the addons and sent as
+// a single frame; messages over maxMessageSize fail with errMessageTooLarge.
+func (s *session) relay(src io.Reader, dst io.Writer, fromClient bool) error {
+	var msg *types.WebSocketMessage
+	for {
+		f, err := readFrame(src)
+		if err != nil {
+			return err
+		}
+
+		if f.isControl() || !s.inspect {
+			if err := writeFrame(dst, f, fromClient); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if f.opcode != opContinuation || msg == nil {
+			msg = &types.WebSocketMessage{
+				Type:       int(f.opcode),
+				FromClient: fromClient,
+				Timestamp:  time.Now(),
+			}
+		}
+		if len(msg.Content)+len(f.payload) > maxMessageSize {
+			return errMessageTooLarge
+		}
+		msg.Content = append(msg.Content, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		s.message(msg)
+		if !msg.Dropped {
+			out := &frame{
+				fin:     true,
+				opcode:  byte(msg.Type),
+				payload: msg.Content,
+			}
+			if err := writeFrame(dst, out, fromClient); err != nil {
+				return err
+			}
 		}
+		msg = nil
+	}
+}
+
+func (s *session) message(msg *types.WebSocketMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, addon := range s.addons {
+		addon.WebsocketMessage(s.flow, msg)
 	}
 }
@@ -0,0 +1,67 @@
+// Justification for whitebox testing:
+// Whether an addon handles messages and the limit of reassembled messages are decided
+// by unexported helpers; reaching the limit through a live connection would take 64 MB.
+
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
+)
+
+type noMessagesAddon struct {
+	types.BaseAddon
+}
+
+type messagesAddon struct {
+	types.BaseAddon
+}
+
+func (*messagesAddon) WebsocketMessage(*types.Flow, *types.WebSocketMessage) {}
+
+type wrappedAddon struct {
+	*messagesAddon
+}
+
+func TestHandlesMessages(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(handlesMessages(&types.BaseAddon{}), qt.IsFalse)
+	c.Assert(handlesMessages(&noMessagesAddon{}), qt.IsFalse)
+	c.Assert(handlesMessages(&messagesAddon{}), qt.IsTrue)
+	c.Assert(handlesMessages(&wrappedAddon{&messagesAddon{}}), qt.IsTrue)
+}
+
+func TestRelayClosesTooLargeMessages(t *testing.T) {
+	c := qt.New(t)
+	defer func(size int) { maxMessageSize = size }(maxMessageSize)
+	maxMessageSize = 10
+
+	var src bytes.Buffer
+	c.Assert(writeFrame(&src, &frame{opcode: 1, payload: []byte("123456")}, false), qt.IsNil)
+	c.Assert(writeFrame(&src, &frame{opcode: opContinuation, payload: []byte("789012")}, false), qt.IsNil)
+
+	s := &session{inspect: true}
+	var dst bytes.Buffer
+	err := s.relay(&src, &dst, false)
+	c.Assert(err, qt.Equals, errMessageTooLarge)
+	c.Assert(dst.Len(), qt.Equals, 0)
+
+	server, serverPeer := net.Pipe()
+	client, clientPeer := net.Pipe()
+	defer serverPeer.Close()
+	defer clientPeer.Close()
+	go s.closeIfTooLarge(err, server, client)
+	for _, peer := range []net.Conn{serverPeer, clientPeer} {
+		f, err := readFrame(peer)
+		c.Assert(err, qt.IsNil)
+		c.Assert(f.opcode, qt.Equals, byte(opClose))
+		c.Assert(binary.BigEndian.Uint16(f.payload), qt.Equals, uint16(closeMessageTooBig))
+	}
+}
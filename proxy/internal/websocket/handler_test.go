@@ -1,17 +1,166 @@
 package websocket_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
+	gorilla "github.com/gorilla/websocket"
 
+	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/websocket"
 )
 
 func TestNewCreatesHandler(t *testing.T) {
 	c := qt.New(t)
 
-	handler := websocket.New()
+	handler := websocket.New(nil, false)
 
 	c.Assert(handler, qt.IsNotNil)
 }
+
+type addonList []types.Addon
+
+func (l addonList) Get() []types.Addon  { return l }
+func (l addonList) View() []types.Addon { return l }
+
+type messageAddon struct {
+	types.BaseAddon
+	mu       sync.Mutex
+	started  bool
+	ended    chan struct{}
+	messages []string
+}
+
+func (a *messageAddon) WebsocketStart(f *types.Flow) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.started = f.Response.StatusCode == http.StatusSwitchingProtocols
+}
+
+func (a *messageAddon) WebsocketMessage(_ *types.Flow, msg *types.WebSocketMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	dir := "server"
+	if msg.FromClient {
+		dir = "client"
+	}
+	a.messages = append(a.messages, dir+":"+string(msg.Content))
+
+	switch string(msg.Content) {
+	case "drop":
+		msg.Dropped = true
+	case "hello":
+		msg.Content = []byte("HELLO")
+	}
+}
+
+func (a *messageAddon) WebsocketEnd(*types.Flow) {
+	close(a.ended)
+}
+
+func TestHandleWSSPassesMessagesToAddons(t *testing.T) {
+	c := qt.New(t)
+
+	upgrader := gorilla.Upgrader{}
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			mt, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(mt, append([]byte("echo "), data...)); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	addon := &messageAddon{ended: make(chan struct{})}
+	handler := websocket.New(addonList{addon}, true)
+	front := httptest.NewServer(http.HandlerFunc(handler.HandleWSS))
+	defer front.Close()
+
+	header := http.Header{"Host": {strings.TrimPrefix(upstream.URL, "https://")}}
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(front.URL, "http"), header)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(ws.WriteMessage(gorilla.TextMessage, []byte("drop")), qt.IsNil)
+	c.Assert(ws.WriteMessage(gorilla.TextMessage, []byte("hello")), qt.IsNil)
+	mt, data, err := ws.ReadMessage()
+	c.Assert(err, qt.IsNil)
+	c.Assert(mt, qt.Equals, gorilla.TextMessage)
+	c.Assert(string(data), qt.Equals, "echo HELLO")
+
+	c.Assert(ws.WriteMessage(gorilla.BinaryMessage, []byte{1, 2, 3}), qt.IsNil)
+	mt, data, err = ws.ReadMessage()
+	c.Assert(err, qt.IsNil)
+	c.Assert(mt, qt.Equals, gorilla.BinaryMessage)
+	c.Assert(data, qt.DeepEquals, []byte("echo \x01\x02\x03"))
+
+	ws.Close()
+	<-addon.ended
+
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	c.Assert(addon.started, qt.IsTrue)
+	c.Assert(addon.messages, qt.DeepEquals, []string{
+		"client:drop",
+		"client:hello",
+		"server:echo HELLO",
+		"client:\x01\x02\x03",
+		"server:echo \x01\x02\x03",
+	})
+}
+
+func TestHandleWSSKeepsCompressionWithoutMessageAddons(t *testing.T) {
+	c := qt.New(t)
+
+	upgrader := gorilla.Upgrader{EnableCompression: true}
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		mt, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = ws.WriteMessage(mt, append([]byte("echo "), data...))
+	}))
+	defer upstream.Close()
+
+	dial := func(addon types.Addon) (*gorilla.Conn, *http.Response) {
+		handler := websocket.New(addonList{addon}, true)
+		front := httptest.NewServer(http.HandlerFunc(handler.HandleWSS))
+		c.Cleanup(front.Close)
+		dialer := gorilla.Dialer{EnableCompression: true}
+		header := http.Header{"Host": {strings.TrimPrefix(upstream.URL, "https://")}}
+		ws, resp, err := dialer.Dial("ws"+strings.TrimPrefix(front.URL, "http"), header)
+		c.Assert(err, qt.IsNil)
+		return ws, resp
+	}
+
+	// no addon looks at the messages, compressed ones are relayed as they are
+	ws, resp := dial(&types.BaseAddon{})
+	defer ws.Close()
+	c.Assert(resp.Header.Get("Sec-WebSocket-Extensions"), qt.Contains, "permessage-deflate")
+	c.Assert(ws.WriteMessage(gorilla.TextMessage, []byte(strings.Repeat("hello ", 100))), qt.IsNil)
+	_, data, err := ws.ReadMessage()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "echo "+strings.Repeat("hello ", 100))
+
+	// an addon looking at them turns compression off
+	ws, resp = dial(&messageAddon{ended: make(chan struct{})})
+	defer ws.Close()
+	c.Assert(resp.Header.Get("Sec-WebSocket-Extensions"), qt.Equals, "")
+}
@@ -4,9 +4,16 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/denisvmedia/go-mitmproxy/cert"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/addonregistry"
@@ -23,11 +30,34 @@ type Proxy struct {
 	addonRegistry   *addonregistry.Registry
 	upstreamManager *upstream.Manager
 
-	entry           *entry
+	entries         []*entry // one per listen address, Addr first, replaced by Serve
+	entriesMu       sync.Mutex
 	attacker        *attacker.Attacker
 	ca              cert.CA
 	shouldIntercept func(req *http.Request) bool // req is received by proxy.server
 	authProxy       func(res http.ResponseWriter, req *http.Request) (bool, error)
+	clients         *clientFilter
+	connLimit       *connLimiter
+	rejectedClients atomic.Int64
+	tunnelSent      atomic.Int64 // bytes sent to servers through the tunnels not intercepted
+	tunnelReceived  atomic.Int64 // bytes received from servers through them
+	wire            *conn.WireCapture
+
+	lifecycleMu sync.Mutex // serializes the lifecycle with adding and removing addons
+	running     bool       // Running was called and Done was not yet
+}
+
+// LifecycleAddon is implemented by addons owning files, connections or background
+// goroutines, to learn when the proxy runs.
+type LifecycleAddon interface {
+	Addon
+	// Running is called when the proxy starts, or when the addon is added to a running
+	// proxy.
+	Running(p *Proxy)
+	// Done is called when the proxy was closed or shut down, or when the addon is
+	// removed from a running proxy, so it can flush and release what it owns. When the
+	// proxy stops, the addons are done in the reverse of the order they are called in.
+	Done()
 }
 
 // NewProxy creates a new Proxy with the given configuration and CA.
@@ -40,7 +70,27 @@ func NewProxy(config Config, ca cert.CA) (*Proxy, error) {
 
 	addonRegistry := addonregistry.New()
 	upstreamManager := upstream.NewManager(config.Upstream, config.InsecureSkipVerify)
-	wsHandler := websocket.New()
+	upstreamManager.SetTCPOptions(config.TCPKeepAlive, config.TCPNoDelay)
+	if config.Resolver != nil {
+		upstreamManager.SetResolver(config.Resolver)
+	}
+	if config.UpstreamAuth != nil {
+		upstreamManager.SetProxyAuth(config.UpstreamAuth)
+	}
+	if config.OutboundLocalAddr != "" {
+		localAddr := net.ParseIP(config.OutboundLocalAddr)
+		if localAddr == nil {
+			return nil, fmt.Errorf("invalid outbound local address %q", config.OutboundLocalAddr)
+		}
+		upstreamManager.SetLocalAddr(func(*http.Request) net.IP { return localAddr })
+	}
+	wsHandler := websocket.New(addonRegistry, config.InsecureSkipVerify)
+	wsHandler.SetClientCertificates(config.ClientCertificates)
+
+	var wire *conn.WireCapture
+	if config.WireCapture != nil {
+		wire = conn.NewWireCapture(config.WireCapture)
+	}
 
 	atk, err := attacker.New(attacker.Args{
 		CA:                 ca,
@@ -50,11 +100,35 @@ func NewProxy(config Config, ca cert.CA) (*Proxy, error) {
 		InsecureSkipVerify: config.InsecureSkipVerify,
 		WSHandler:          wsHandler,
 		ClientFactory:      config.ClientFactory,
+		TransformRequest:   config.TransformRequest,
+		TransformResponse:  config.TransformResponse,
+
+		MatchUpstreamProtocol: config.MatchUpstreamProtocol,
+		ServerHeader:          config.ServerHeader,
+		MaxConcurrentFlows:    config.MaxConcurrentFlows,
+		MaxQueuedFlows:        config.MaxQueuedFlows,
+		ResponseBodyLimits:    config.ResponseBodyLimits,
+		TruncateLimitedBodies: config.TruncateLimitedBodies,
+		MimicClientHello:      config.MimicClientHello,
+		ClientCertificates:    config.ClientCertificates,
+		ClientAuth:            config.ClientAuth,
+		ClientCAs:             config.ClientCAs,
+		WireCapture:           wire,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		SpoolLargeBodies:      config.SpoolLargeBodies,
+		SpoolDir:              config.SpoolDir,
+		HTTP3:                 config.HTTP3Addr != "",
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	clients, err := newClientFilter(config.AllowClients, config.DenyClients)
+	if err != nil {
+		return nil, err
+	}
+
 	proxy := &Proxy{
 		Version:         version.Version,
 		config:          config,
@@ -62,32 +136,299 @@ func NewProxy(config Config, ca cert.CA) (*Proxy, error) {
 		upstreamManager: upstreamManager,
 		attacker:        atk,
 		ca:              ca,
+		clients:         clients,
+		connLimit:       newConnLimiter(config.MaxClientConnections, config.MaxConnectionsPerClient),
+		wire:            wire,
 	}
 
-	proxy.entry = newEntry(proxy)
+	var listeners []net.Listener
+	if config.SystemdSocketActivation {
+		listeners, err = systemdListeners(systemdListenFdsStart)
+		if err != nil {
+			return nil, err
+		}
+		if len(listeners) > 0 {
+			slog.Info("systemd socket activation", "listeners", len(listeners))
+		}
+	}
+	for _, ln := range listeners {
+		e := newEntry(proxy, ln.Addr().String())
+		e.ln = ln
+		proxy.entries = append(proxy.entries, e)
+	}
+	if len(listeners) == 0 {
+		for _, addr := range append([]string{config.Addr}, config.ExtraAddrs...) {
+			proxy.entries = append(proxy.entries, newEntry(proxy, addr))
+		}
+	}
 
 	return proxy, nil
 }
 
+// AddAddon adds an addon with priority 0, after the addons added before it.
 func (p *Proxy) AddAddon(addon Addon) {
-	p.addonRegistry.Add(addon)
+	p.AddAddonWithPriority(addon, 0)
+}
+
+// AddAddonWithPriority adds an addon with a priority. The addons are called in
+// ascending priority, and in the order they were added for the same priority, e.g. an
+// addon rewriting requests added with -10 runs before a dumper added with AddAddon
+// whatever the order they were added in.
+func (p *Proxy) AddAddonWithPriority(addon Addon, priority int) {
+	p.lifecycleMu.Lock()
+	p.addonRegistry.AddWithPriority(addon, priority)
+	running := p.running
+	p.lifecycleMu.Unlock()
+	if lifecycle, ok := addon.(LifecycleAddon); ok && running {
+		lifecycle.Running(p)
+	}
+}
+
+// RemoveAddon removes an addon added with AddAddon, e.g. to unload a plugin or to stop
+// dumping while the proxy runs, and reports whether it was added. Addons can be added
+// and removed while flows are in flight: flows stop calling a removed addon from their
+// next event on, and events already being dispatched may still reach it.
+func (p *Proxy) RemoveAddon(addon Addon) bool {
+	p.lifecycleMu.Lock()
+	removed := p.addonRegistry.Remove(addon)
+	running := p.running
+	p.lifecycleMu.Unlock()
+	if lifecycle, ok := addon.(LifecycleAddon); ok && removed && running {
+		lifecycle.Done()
+	}
+	return removed
 }
 
+// startAddons calls Running of the lifecycle addons, unless the proxy already runs.
+func (p *Proxy) startAddons() {
+	p.lifecycleMu.Lock()
+	if p.running {
+		p.lifecycleMu.Unlock()
+		return
+	}
+	p.running = true
+	addons := p.addonRegistry.View()
+	p.lifecycleMu.Unlock()
+	for _, addon := range addons {
+		if lifecycle, ok := addon.(LifecycleAddon); ok {
+			lifecycle.Running(p)
+		}
+	}
+}
+
+// stopAddons calls Done of the lifecycle addons, in reverse order, if the proxy runs.
+func (p *Proxy) stopAddons() {
+	p.lifecycleMu.Lock()
+	if !p.running {
+		p.lifecycleMu.Unlock()
+		return
+	}
+	p.running = false
+	addons := p.addonRegistry.View()
+	p.lifecycleMu.Unlock()
+	for i := len(addons) - 1; i >= 0; i-- {
+		if lifecycle, ok := addons[i].(LifecycleAddon); ok {
+			lifecycle.Done()
+		}
+	}
+}
+
+// Start listens on Addr and ExtraAddrs, or serves the sockets passed by systemd, and
+// serves the proxy until it is closed or shut down.
 func (p *Proxy) Start() error {
+	// listen first, a failure must not leave the attacker running
+	var pconn net.PacketConn
+	if p.config.HTTP3Addr != "" {
+		var err error
+		pconn, err = net.ListenPacket("udp", p.config.HTTP3Addr)
+		if err != nil {
+			return err
+		}
+	}
+	lns, err := p.listenEntries()
+	if err != nil {
+		if pconn != nil {
+			pconn.Close()
+		}
+		return err
+	}
 	go func() {
-		if err := p.attacker.Start(); err != nil {
+		if err := p.attacker.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error("attacker start failed", "error", err)
 		}
 	}()
-	return p.entry.start()
+	if pconn != nil {
+		slog.Info("proxy listening for http3", "addr", p.config.HTTP3Addr)
+		go func() {
+			defer pconn.Close()
+			if err := p.attacker.ServeHTTP3(pconn, p.admitHTTP3Client); err != nil {
+				slog.Error("http3 serve failed", "error", err)
+			}
+		}()
+	}
+	p.startAddons()
+	err = p.serveEntries(lns)
+	if !errors.Is(err, http.ErrServerClosed) {
+		// the proxy failed to serve, Close and Shutdown may never be called
+		p.stopAddons()
+	}
+	return err
+}
+
+// Serve serves the proxy on ln instead of Addr and ExtraAddrs, like Start otherwise,
+// e.g. for a listener with SO_REUSEPORT, an in-memory listener in tests, or a listener
+// wrapped by the embedding program. ln is closed when the proxy is closed or shut down.
+// Config.TLSCertificate and the client restrictions apply to ln too.
+func (p *Proxy) Serve(ln net.Listener) error {
+	e := newEntry(p, ln.Addr().String())
+	e.ln = ln
+	p.entriesMu.Lock()
+	p.entries = []*entry{e}
+	p.entriesMu.Unlock()
+	return p.Start()
 }
 
+func (p *Proxy) listEntries() []*entry {
+	p.entriesMu.Lock()
+	defer p.entriesMu.Unlock()
+	return p.entries
+}
+
+// listenEntries listens on all the addresses, or none if one fails.
+func (p *Proxy) listenEntries() ([]net.Listener, error) {
+	entries := p.listEntries()
+	lns := make([]net.Listener, 0, len(entries))
+	for _, e := range entries {
+		ln, err := e.listen()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// serveEntries serves the listeners opened by listenEntries until they are all closed.
+func (p *Proxy) serveEntries(lns []net.Listener) error {
+	entries := p.listEntries()
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = e.serve(lns[i])
+			if !errors.Is(errs[i], http.ErrServerClosed) {
+				slog.Error("proxy serve failed", "addr", e.server.Addr, "error", errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := slices.DeleteFunc(errs, func(err error) bool { return errors.Is(err, http.ErrServerClosed) })
+	if len(failed) > 0 {
+		return errors.Join(failed...)
+	}
+	return http.ErrServerClosed
+}
+
+// Close stops the proxy immediately, and then the lifecycle addons.
 func (p *Proxy) Close() error {
-	return p.entry.close()
+	err := p.attacker.Close()
+	for _, e := range p.listEntries() {
+		err = errors.Join(err, e.close())
+	}
+	p.stopAddons()
+	return err
 }
 
+// Shutdown stops accepting connections and waits, until ctx is done, for the flows in
+// flight and the CONNECT tunnels to finish, and then stops the lifecycle addons. The
+// HTTP/1.1 responses sent meanwhile carry Connection: close, so clients open their next
+// connection elsewhere, e.g. to the new instance of a rolling deployment.
 func (p *Proxy) Shutdown(ctx context.Context) error {
-	return p.entry.shutdown(ctx)
+	p.attacker.SetKeepAlivesEnabled(false)
+	var err error
+	for _, e := range p.listEntries() {
+		err = errors.Join(err, e.shutdown(ctx))
+	}
+	err = errors.Join(err, p.attacker.Shutdown(ctx), p.drain(ctx))
+	p.stopAddons()
+	return err
+}
+
+// drain waits for the flows in flight and the CONNECT tunnels to finish, or ctx to be
+// done.
+func (p *Proxy) drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for p.ActiveFlows()+p.QueuedFlows()+p.ActiveTunnels() > 0 {
+		select {
+		case <-ctx.Done():
+			slog.Warn("shutdown before the flows finished",
+				"flows", p.ActiveFlows()+p.QueuedFlows(), "tunnels", p.ActiveTunnels())
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// ActiveFlows returns the number of HTTP flows currently being handled, e.g. for health checks.
+func (p *Proxy) ActiveFlows() int64 {
+	return p.attacker.ActiveFlows()
+}
+
+// QueuedFlows returns the number of flows waiting for a slot because of MaxConcurrentFlows.
+func (p *Proxy) QueuedFlows() int64 {
+	return p.attacker.QueuedFlows()
+}
+
+// ActiveTunnels returns the number of CONNECT requests being handled, most of them
+// tunnels to servers that are not intercepted.
+func (p *Proxy) ActiveTunnels() int64 {
+	var tunnels int64
+	for _, e := range p.listEntries() {
+		tunnels += e.tunnels.Load()
+	}
+	return tunnels
+}
+
+// RejectedClients returns the number of client connections closed because of
+// Config.AllowClients and Config.DenyClients, or because they were over
+// Config.MaxClientConnections or Config.MaxConnectionsPerClient.
+func (p *Proxy) RejectedClients() int64 {
+	return p.rejectedClients.Load()
+}
+
+// TunnelBytes returns the bytes sent to servers and received from them through the
+// CONNECT tunnels that are not intercepted. A tunnel counts them every megabyte copied
+// and when it ends.
+func (p *Proxy) TunnelBytes() (sent, received int64) {
+	return p.tunnelSent.Load(), p.tunnelReceived.Load()
+}
+
+// SeparateClientStats returns the statistics of the connections of the separate http
+// client, which sends the requests of flows with UseSeparateClient or a rewritten host.
+// They are zero with a custom Config.ClientFactory that does not count them, like
+// DefaultClientFactory does.
+func (p *Proxy) SeparateClientStats() ClientPoolStats {
+	stats, _ := p.attacker.SeparateClientStats()
+	return stats
+}
+
+// ReplayFlow sends the request of the recorded flow f again, with the separate http
+// client, until ctx is done. The replay goes through the addons like a request of a
+// client, with Flow.IsReplay set, so they can record or show it. The new flow is
+// returned once it is finished; its response body is missing if the response was
+// streamed. An error is returned if the replay got no response from the server or the
+// addons, or if the request body of f was spooled, as the spool is removed once f is
+// finished.
+func (p *Proxy) ReplayFlow(ctx context.Context, f *Flow) (*Flow, error) {
+	return p.attacker.Replay(ctx, f)
 }
 
 func (p *Proxy) GetCertificate() x509.Certificate {
@@ -102,24 +443,49 @@ func (p *Proxy) SetShouldInterceptRule(rule func(req *http.Request) bool) {
 	p.shouldIntercept = rule
 }
 
+// SetShouldInterceptSNIRule sets a rule deciding, from the SNI of the client's ClientHello,
+// whether an intercepted CONNECT tunnel is really intercepted. It complements
+// SetShouldInterceptRule for CONNECT targets that are generic or plain IPs, and is
+// checked only in lazy mode (UpstreamCert = false). Declined connections are tunneled
+// to the CONNECT target untouched.
+func (p *Proxy) SetShouldInterceptSNIRule(rule func(serverName string) bool) {
+	p.attacker.SetShouldInterceptSNIRule(rule)
+}
+
 func (p *Proxy) SetUpstreamProxy(fn func(req *http.Request) (*url.URL, error)) {
 	p.upstreamManager.SetUpstreamProxy(fn)
 }
 
+// SetOutboundLocalAddr sets a function choosing the local IP address the connections
+// to servers and upstream proxies for a request are bound to, e.g. per host to choose
+// the egress interface of multi-homed hosts. A nil address falls back to
+// Config.OutboundLocalAddr. Connections of the separate client are reused across the
+// requests to the same host, so a function had better choose by host.
+func (p *Proxy) SetOutboundLocalAddr(fn func(req *http.Request) net.IP) {
+	fallback := net.ParseIP(p.config.OutboundLocalAddr)
+	p.upstreamManager.SetLocalAddr(func(req *http.Request) net.IP {
+		if ip := fn(req); ip != nil {
+			return ip
+		}
+		return fallback
+	})
+}
+
 func (p *Proxy) SetAuthProxy(fn func(res http.ResponseWriter, req *http.Request) (bool, error)) {
 	p.authProxy = fn
 }
 
 // NotifyClientDisconnected implements conn.AddonNotifier interface.
 func (p *Proxy) NotifyClientDisconnected(clientConn *conn.ClientConn) {
-	for _, addon := range p.addonRegistry.Get() {
+	p.connLimit.release(clientConn.Conn.RemoteAddr())
+	for _, addon := range p.addonRegistry.View() {
 		addon.ClientDisconnected(clientConn)
 	}
 }
 
 // NotifyServerDisconnected implements conn.AddonNotifier interface.
 func (p *Proxy) NotifyServerDisconnected(connCtx *conn.Context) {
-	for _, addon := range p.addonRegistry.Get() {
+	for _, addon := range p.addonRegistry.View() {
 		addon.ServerDisconnected(connCtx)
 	}
 }
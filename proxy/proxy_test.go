@@ -1,20 +1,33 @@
 package proxy_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	qt "github.com/frankban/quicktest"
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	utls "github.com/refraction-networking/utls"
 
 	"github.com/denisvmedia/go-mitmproxy/cert"
 	"github.com/denisvmedia/go-mitmproxy/proxy"
@@ -48,6 +61,7 @@ type testProxyHelper struct {
 	testOrderAddonInstance *testOrderAddon
 	testProxy              *proxy.Proxy
 	getProxyClient         func() *http.Client
+	configure              func(*proxy.Config) // optional proxy config customization
 }
 
 func (hlp *testProxyHelper) init(c *qt.C) {
@@ -57,6 +71,16 @@ func (hlp *testProxyHelper) init(c *qt.C) {
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("ok"))
 	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.Header.Get("X-Echo") + " " + string(body)))
+	})
+	mux.HandleFunc("/grpc/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write([]byte("ok"))
+		w.Header().Set("Grpc-Status", "0")
+	})
 	hlp.server.Handler = mux
 
 	// start http server
@@ -92,6 +116,9 @@ func (hlp *testProxyHelper) init(c *qt.C) {
 		Addr:               hlp.proxyAddr, // some random port
 		InsecureSkipVerify: true,
 	}
+	if hlp.configure != nil {
+		hlp.configure(&config)
+	}
 
 	var testProxy *proxy.Proxy
 	testProxy, err = proxy.NewProxy(config, proxyCA)
@@ -745,3 +772,2691 @@ func TestOffUpstreamCert(t *testing.T) {
 		testOrderAddonInstance.contains(c, "TLSEstablishedServer")
 	})
 }
+
+// addon forcing the separate client for a single path.
+type separateClientAddon struct {
+	proxy.BaseAddon
+	mu   sync.Mutex
+	used []bool
+}
+
+func (*separateClientAddon) Requestheaders(f *proxy.Flow) {
+	if f.Request.URL.Path == "/separate-client" {
+		f.UseSeparateClient = true
+	}
+}
+
+func (adn *separateClientAddon) Response(f *proxy.Flow) {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	adn.used = append(adn.used, f.UsedSeparateClient)
+}
+
+func (adn *separateClientAddon) takeUsed() []bool {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	used := adn.used
+	adn.used = nil
+	return used
+}
+
+func TestUseSeparateClient(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29089",
+	}
+	helper.init(c)
+	httpEndpoint := helper.httpEndpoint
+	httpsEndpoint := helper.httpsEndpoint
+	testProxy := helper.testProxy
+	separateClientAddonInstance := &separateClientAddon{}
+	testProxy.AddAddon(separateClientAddonInstance)
+	getProxyClient := helper.getProxyClient
+	defer helper.ln.Close()
+	go func() { _ = helper.server.Serve(helper.ln) }()
+	defer helper.tlsPlainLn.Close()
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+	go func() { _ = testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := getProxyClient()
+
+	for _, endpoint := range []string{httpEndpoint, httpsEndpoint} {
+		t.Run(endpoint, func(t *testing.T) {
+			c := qt.New(t)
+			testSendRequest(c, endpoint, proxyClient, "ok")
+			c.Assert(separateClientAddonInstance.takeUsed(), qt.DeepEquals, []bool{false})
+
+			testSendRequest(c, endpoint+"separate-client", proxyClient, "ok")
+			c.Assert(separateClientAddonInstance.takeUsed(), qt.DeepEquals, []bool{true})
+		})
+	}
+}
+
+// addon redirecting requests for a fake host to the real test server.
+type rewriteTargetAddon struct {
+	proxy.BaseAddon
+	host string
+}
+
+func (adn *rewriteTargetAddon) RewriteTarget(req *http.Request) {
+	if req.URL.Hostname() == "rewrite-me.test" {
+		req.URL.Host = adn.host
+	}
+}
+
+func testSendRawRequest(c *qt.C, proxyAddr, requestURI, host string) *http.Response {
+	c.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	c.Assert(err, qt.IsNil)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET "+requestURI+" HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n")
+	c.Assert(err, qt.IsNil)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	c.Assert(err, qt.IsNil)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp
+}
+
+func TestMalformedAbsoluteFormURL(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29090",
+	}
+	helper.init(c)
+	testProxy := helper.testProxy
+	testProxy.AddAddon(&rewriteTargetAddon{host: helper.ln.Addr().String()})
+	defer helper.ln.Close()
+	go func() { _ = helper.server.Serve(helper.ln) }()
+	go func() { _ = testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyAddr := "127.0.0.1" + helper.proxyAddr
+	port := strconv.Itoa(helper.ln.Addr().(*net.TCPAddr).Port)
+	host := "localhost:" + port
+
+	tests := []struct {
+		name       string
+		requestURI string
+		host       string
+	}{
+		{name: "missing scheme", requestURI: host + "/", host: host},
+		{name: "scheme-relative", requestURI: "//" + host + "/", host: host},
+		{name: "rewritten by addon", requestURI: "http://rewrite-me.test/", host: "rewrite-me.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			resp := testSendRawRequest(c, proxyAddr, tt.requestURI, tt.host)
+			c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+			body, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(body), qt.Equals, "ok")
+		})
+	}
+
+	t.Run("direct request is not rewritten", func(t *testing.T) {
+		c := qt.New(t)
+		resp := testSendRawRequest(c, proxyAddr, "/", proxyAddr)
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+	})
+}
+
+func TestConfigTransform(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29091",
+		configure: func(config *proxy.Config) {
+			config.TransformRequest = func(req *proxy.Request) {
+				if req.URL.Path == "/echo" {
+					req.Header.Set("X-Echo", "transformed")
+					req.Body = []byte("request")
+				}
+			}
+			config.TransformResponse = func(res *proxy.Response) {
+				res.Header.Set("X-Transformed", "1")
+				res.Header.Del("Content-Length")
+				res.Body = append(res.Body, '!')
+			}
+		},
+	}
+	helper.init(c)
+	httpEndpoint := helper.httpEndpoint
+	httpsEndpoint := helper.httpsEndpoint
+	testProxy := helper.testProxy
+	getProxyClient := helper.getProxyClient
+	defer helper.ln.Close()
+	go func() { _ = helper.server.Serve(helper.ln) }()
+	defer helper.tlsPlainLn.Close()
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+	go func() { _ = testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := getProxyClient()
+
+	for _, endpoint := range []string{httpEndpoint, httpsEndpoint} {
+		t.Run(endpoint, func(t *testing.T) {
+			c := qt.New(t)
+			resp := testGetResponse(c, endpoint+"echo", proxyClient)
+			c.Assert(resp.Header.Get("X-Transformed"), qt.Equals, "1")
+			testSendRequest(c, endpoint+"echo", proxyClient, "transformed request!")
+			testSendRequest(c, endpoint+"intercept-request", proxyClient, "intercept-request!")
+		})
+	}
+}
+
+func TestGRPCStatusTrailer(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29093",
+	}
+	helper.init(c)
+	httpEndpoint := helper.httpEndpoint
+	httpsEndpoint := helper.httpsEndpoint
+	testProxy := helper.testProxy
+	getProxyClient := helper.getProxyClient
+	testProxy.AddAddon(addons.NewGRPCStatusRewriter([]addons.GRPCStatusRule{
+		{Method: "/grpc/test.Service/Fail", Status: 14, Message: "unavailable"},
+	}))
+	defer helper.ln.Close()
+	go func() { _ = helper.server.Serve(helper.ln) }()
+	defer helper.tlsPlainLn.Close()
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+	go func() { _ = testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := getProxyClient()
+
+	call := func(c *qt.C, endpoint string) http.Header {
+		req, err := http.NewRequest("POST", endpoint, strings.NewReader("call"))
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := proxyClient.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(body), qt.Equals, "ok")
+		return resp.Trailer
+	}
+
+	for _, endpoint := range []string{httpEndpoint, httpsEndpoint} {
+		t.Run(endpoint, func(t *testing.T) {
+			c := qt.New(t)
+			trailer := call(c, endpoint+"grpc/test.Service/Fail")
+			c.Assert(trailer.Get("Grpc-Status"), qt.Equals, "14")
+			c.Assert(trailer.Get("Grpc-Message"), qt.Equals, "unavailable")
+
+			trailer = call(c, endpoint+"grpc/test.Service/Ok")
+			c.Assert(trailer.Get("Grpc-Status"), qt.Equals, "0")
+			c.Assert(trailer.Get("Grpc-Message"), qt.Equals, "")
+		})
+	}
+}
+
+func TestMatchUpstreamProtocol(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29094",
+		configure: func(config *proxy.Config) {
+			config.MatchUpstreamProtocol = true
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	testProxy.AddAddon(&separateClientAddon{})
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	newClient := func(h2 bool) *http.Client {
+		transport := &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			Proxy: func(*http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1" + helper.proxyAddr)
+			},
+			ForceAttemptHTTP2: h2,
+		}
+		if !h2 {
+			transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		}
+		return &http.Client{Transport: transport}
+	}
+
+	tests := []struct {
+		name string
+		h2   bool
+		path string
+		want string
+	}{
+		{name: "h2 client", h2: true, path: "/", want: "HTTP/2.0"},
+		{name: "h2 client with separate client", h2: true, path: "/separate-client", want: "HTTP/2.0"},
+		{name: "h1 client", h2: false, path: "/", want: "HTTP/1.1"},
+		{name: "h1 client with separate client", h2: false, path: "/separate-client", want: "HTTP/1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			client := newClient(tt.h2)
+			resp, err := client.Get(upstream.URL + tt.path)
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			wantClientProto := "HTTP/1.1"
+			if tt.h2 {
+				wantClientProto = "HTTP/2.0"
+			}
+			c.Assert(resp.Proto, qt.Equals, wantClientProto)
+			c.Assert(string(body), qt.Equals, tt.want)
+		})
+	}
+}
+
+func TestShouldInterceptSNIRule(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29095",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	testProxy.AddAddon(addons.NewUpstreamCertAddon(false))
+	testProxy.SetShouldInterceptSNIRule(func(serverName string) bool {
+		return serverName != "blocked.example.com"
+	})
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	// dialTLS opens a CONNECT tunnel to the upstream IP and returns the certificate
+	// presented for serverName.
+	dialTLS := func(c *qt.C, serverName string) *x509.Certificate {
+		conn, err := net.Dial("tcp", "127.0.0.1"+helper.proxyAddr)
+		c.Assert(err, qt.IsNil)
+		defer conn.Close()
+
+		target := upstream.Listener.Addr().String()
+		_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+		c.Assert(err, qt.IsNil)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		c.Assert(tlsConn.Handshake(), qt.IsNil)
+		return tlsConn.ConnectionState().PeerCertificates[0]
+	}
+
+	t.Run("denied sni is passed through", func(t *testing.T) {
+		c := qt.New(t)
+		peerCert := dialTLS(c, "blocked.example.com")
+		c.Assert(peerCert.Equal(upstream.Certificate()), qt.IsTrue)
+	})
+
+	t.Run("allowed sni is intercepted", func(t *testing.T) {
+		c := qt.New(t)
+		peerCert := dialTLS(c, "allowed.example.com")
+		c.Assert(peerCert.Equal(upstream.Certificate()), qt.IsFalse)
+		c.Assert(peerCert.Subject.CommonName, qt.Equals, "allowed.example.com")
+	})
+}
+
+func TestServerHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/named" {
+			w.Header().Set("Server", "upstream/1.0")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29096",
+		configure: func(config *proxy.Config) {
+			config.ServerHeader = "test-proxy"
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := helper.getProxyClient()
+
+	t.Run("direct request error", func(t *testing.T) {
+		c := qt.New(t)
+		resp := testSendRawRequest(c, helper.proxyAddr, "/", "127.0.0.1"+helper.proxyAddr)
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+		c.Assert(resp.Header.Get("Server"), qt.Equals, "test-proxy")
+	})
+
+	t.Run("upstream unreachable", func(t *testing.T) {
+		c := qt.New(t)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		c.Assert(err, qt.IsNil)
+		closedAddr := ln.Addr().String()
+		ln.Close()
+		resp, err := proxyClient.Get("http://" + closedAddr + "/")
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusBadGateway)
+		c.Assert(resp.Header.Get("Server"), qt.Equals, "test-proxy")
+	})
+
+	t.Run("addon response", func(t *testing.T) {
+		c := qt.New(t)
+		resp, err := proxyClient.Get(upstream.URL + "/intercept-request")
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.Header.Get("Server"), qt.Equals, "test-proxy")
+	})
+
+	t.Run("proxied response keeps upstream header", func(t *testing.T) {
+		c := qt.New(t)
+		resp, err := proxyClient.Get(upstream.URL + "/named")
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		c.Assert(resp.Header.Values("Server"), qt.DeepEquals, []string{"upstream/1.0"})
+
+		resp, err = proxyClient.Get(upstream.URL + "/")
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.Header.Get("Server"), qt.Equals, "")
+	})
+}
+
+// gzipBodyEditAddon rewrites gzip-encoded request bodies and re-encodes them.
+type gzipBodyEditAddon struct {
+	proxy.BaseAddon
+}
+
+func (*gzipBodyEditAddon) Request(f *proxy.Flow) {
+	body, err := f.Request.DecodedBody()
+	if err != nil {
+		return
+	}
+	f.Request.Body = bytes.ToUpper(body)
+	_ = f.Request.ReencodeBody(f.Request.Header.Get("Content-Encoding"))
+}
+
+func TestRequestReencodeBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(zr)
+		_, _ = fmt.Fprintf(w, "%s|%d|%d|%s", r.Header.Get("Content-Encoding"), r.ContentLength, len(raw), body)
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29097",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	testProxy.AddAddon(&gzipBodyEditAddon{})
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("upload body"))
+	_ = zw.Close()
+
+	req, err := http.NewRequest("POST", upstream.URL, bytes.NewReader(buf.Bytes()))
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := helper.getProxyClient().Do(req)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+	// upstream reports: content-encoding|content-length|received bytes|decoded body
+	parts := strings.Split(string(body), "|")
+	c.Assert(parts, qt.HasLen, 4)
+	c.Assert(parts[0], qt.Equals, "gzip")
+	c.Assert(parts[1], qt.Equals, parts[2])
+	c.Assert(parts[3], qt.Equals, "UPLOAD BODY")
+}
+
+func TestMaxConcurrentFlows(t *testing.T) {
+	var inflight, maxInflight atomic.Int64
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			m := maxInflight.Load()
+			if n <= m || maxInflight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29098",
+		configure: func(config *proxy.Config) {
+			config.MaxConcurrentFlows = 2
+			config.MaxQueuedFlows = 1
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := helper.getProxyClient()
+	const total = 5
+	statuses := make(chan int, total)
+	for i := 0; i < total; i++ {
+		go func() {
+			resp, err := proxyClient.Get(upstream.URL)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+
+	// 2 flows are active, 1 is queued and the other 2 are rejected right away
+	got := map[int]int{}
+	for i := 0; i < 2; i++ {
+		got[<-statuses]++
+	}
+	c.Assert(got, qt.DeepEquals, map[int]int{http.StatusServiceUnavailable: 2})
+	for inflight.Load() != 2 || testProxy.ActiveFlows() != 2 || testProxy.QueuedFlows() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	for i := 0; i < total-2; i++ {
+		got[<-statuses]++
+	}
+	c.Assert(got, qt.DeepEquals, map[int]int{http.StatusServiceUnavailable: 2, http.StatusOK: 3})
+	c.Assert(maxInflight.Load(), qt.Equals, int64(2))
+	c.Assert(testProxy.ActiveFlows(), qt.Equals, int64(0))
+}
+
+func TestProxyShutdownDrainsFlows(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29118",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	go func() { _ = testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := helper.getProxyClient().Get(upstream.URL)
+		if err != nil {
+			responses <- nil
+			return
+		}
+		responses <- resp
+	}()
+	<-started
+	c.Assert(testProxy.ActiveFlows(), qt.Equals, int64(1))
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- testProxy.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		c.Fatalf("shutdown did not wait for the flow: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	resp := <-responses
+	c.Assert(resp, qt.IsNotNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "ok")
+	// the client does not reuse the connection
+	c.Assert(resp.Close, qt.IsTrue)
+	c.Assert(<-shutdown, qt.IsNil)
+	c.Assert(testProxy.ActiveFlows(), qt.Equals, int64(0))
+}
+
+func TestProxyShutdownWaitsForTunnels(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29119",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+	testProxy := helper.testProxy
+	testProxy.SetShouldInterceptRule(func(*http.Request) bool { return false })
+	go func() { _ = testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	target := helper.tlsPlainLn.Addr().String()
+	conn, err := net.Dial("tcp", "127.0.0.1:29119")
+	c.Assert(err, qt.IsNil)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	c.Assert(err, qt.IsNil)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(testProxy.ActiveTunnels(), qt.Equals, int64(1))
+
+	// the tunnel outlives the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Assert(testProxy.Shutdown(ctx), qt.ErrorIs, context.DeadlineExceeded)
+
+	// the tunnel ends once both sides are closed
+	conn.Close()
+	c.Assert(helper.server.Close(), qt.IsNil)
+	for testProxy.ActiveTunnels() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProxyExtraAddrs(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29120",
+		configure: func(config *proxy.Config) {
+			config.ExtraAddrs = []string{"127.0.0.1:29121"}
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	go func() { _ = helper.server.Serve(helper.ln) }()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	errCh := make(chan error, 1)
+	go func() { errCh <- testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	for _, addr := range []string{"127.0.0.1:29120", "127.0.0.1:29121"} {
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://" + addr) },
+			},
+		}
+		testSendRequest(c, helper.httpEndpoint, client, "ok")
+	}
+
+	c.Assert(testProxy.Close(), qt.IsNil)
+	c.Assert(<-errCh, qt.ErrorIs, http.ErrServerClosed)
+}
+
+func TestProxyExtraAddrsListenFailure(t *testing.T) {
+	c := qt.New(t)
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer taken.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:29122", ExtraAddrs: []string{taken.Addr().String()}}, ca)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testProxy.Start(), qt.ErrorMatches, ".*address already in use")
+
+	// the other address is released
+	ln, err := net.Listen("tcp", "127.0.0.1:29122")
+	c.Assert(err, qt.IsNil)
+	ln.Close()
+}
+
+func TestProxyUnixSocket(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	socket := filepath.Join(t.TempDir(), "proxy.sock")
+	// a socket file left by a proxy that did not stop cleanly
+	stale, err := net.Listen("unix", socket)
+	c.Assert(err, qt.IsNil)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	c.Assert(stale.Close(), qt.IsNil)
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: socket, Network: "unix"}, ca)
+	c.Assert(err, qt.IsNil)
+	errCh := make(chan error, 1)
+	go func() { errCh <- testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://localhost") },
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	testSendRequest(c, upstream.URL, client, "ok")
+
+	c.Assert(testProxy.Close(), qt.IsNil)
+	c.Assert(<-errCh, qt.ErrorIs, http.ErrServerClosed)
+}
+
+func TestProxyTLSCertificate(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	proxyCert, err := ca.GetCert("localhost")
+	c.Assert(err, qt.IsNil)
+	testProxy, err := proxy.NewProxy(proxy.Config{
+		Addr:               "127.0.0.1:29123",
+		InsecureSkipVerify: true,
+		TLSCertificate:     proxyCert,
+	}, ca)
+	c.Assert(err, qt.IsNil)
+	errCh := make(chan error, 1)
+	go func() { errCh <- testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	// the same TLS config is used for the proxy and, through CONNECT, the upstream
+	newClient := func(proxyURL string) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				Proxy: func(*http.Request) (*url.URL, error) { return url.Parse(proxyURL) },
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+	client := newClient("https://127.0.0.1:29123")
+	c.Run("http", func(c *qt.C) {
+		testSendRequest(c, upstream.URL, client, "ok")
+	})
+	c.Run("https", func(c *qt.C) {
+		testSendRequest(c, tlsUpstream.URL, client, "ok")
+	})
+	c.Run("plaintext client", func(c *qt.C) {
+		resp, err := newClient("http://127.0.0.1:29123").Get(upstream.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		c.Assert(err, qt.IsNotNil)
+	})
+
+	c.Assert(testProxy.Close(), qt.IsNil)
+	c.Assert(<-errCh, qt.ErrorIs, http.ErrServerClosed)
+}
+
+type testClientConnectedAddon struct {
+	proxy.BaseAddon
+	connected atomic.Int64
+}
+
+func (adn *testClientConnectedAddon) ClientConnected(*proxy.ClientConn) {
+	adn.connected.Add(1)
+}
+
+func TestProxyAllowDenyClients(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	ca, err := cert.NewSelfSignCAMemory()
+	qt.Assert(t, err, qt.IsNil)
+
+	tests := []struct {
+		name    string
+		addr    string
+		allow   []string
+		deny    []string
+		allowed bool
+	}{
+		{name: "allowed", addr: "127.0.0.1:29124", allow: []string{"10.0.0.0/8", "127.0.0.1"}, allowed: true},
+		{name: "not allowed", addr: "127.0.0.1:29125", allow: []string{"10.0.0.0/8"}, allowed: false},
+		{name: "denied", addr: "127.0.0.1:29126", allow: []string{"127.0.0.0/8"}, deny: []string{"127.0.0.1/32"}, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			testProxy, err := proxy.NewProxy(proxy.Config{Addr: tt.addr, AllowClients: tt.allow, DenyClients: tt.deny}, ca)
+			c.Assert(err, qt.IsNil)
+			adn := &testClientConnectedAddon{}
+			testProxy.AddAddon(adn)
+			errCh := make(chan error, 1)
+			go func() { errCh <- testProxy.Start() }()
+			time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+			client := &http.Client{
+				Transport: &http.Transport{
+					Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://" + tt.addr) },
+				},
+			}
+			if tt.allowed {
+				testSendRequest(c, upstream.URL, client, "ok")
+				c.Assert(testProxy.RejectedClients(), qt.Equals, int64(0))
+				c.Assert(adn.connected.Load(), qt.Equals, int64(1))
+			} else {
+				resp, err := client.Get(upstream.URL)
+				if err == nil {
+					resp.Body.Close()
+				}
+				c.Assert(err, qt.IsNotNil)
+				c.Assert(testProxy.RejectedClients(), qt.Equals, int64(1))
+				// no addon sees the rejected clients
+				c.Assert(adn.connected.Load(), qt.Equals, int64(0))
+			}
+
+			c.Assert(testProxy.Close(), qt.IsNil)
+			c.Assert(<-errCh, qt.ErrorIs, http.ErrServerClosed)
+		})
+	}
+
+	_, err = proxy.NewProxy(proxy.Config{Addr: ":0", DenyClients: []string{"10.0.0.0/33"}}, ca)
+	qt.Assert(t, err, qt.ErrorMatches, `deny clients: .*`)
+}
+
+func TestProxyConnectionLimits(t *testing.T) {
+	ca, err := cert.NewSelfSignCAMemory()
+	qt.Assert(t, err, qt.IsNil)
+
+	tests := []struct {
+		name   string
+		config proxy.Config
+	}{
+		{name: "total", config: proxy.Config{Addr: "127.0.0.1:29127", MaxClientConnections: 1}},
+		{name: "per client", config: proxy.Config{Addr: "127.0.0.1:29128", MaxConnectionsPerClient: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			testProxy, err := proxy.NewProxy(tt.config, ca)
+			c.Assert(err, qt.IsNil)
+			errCh := make(chan error, 1)
+			go func() { errCh <- testProxy.Start() }()
+			time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+			// answered by the proxy itself when the connection is accepted
+			request := func(conn net.Conn) error {
+				c.Assert(conn.SetDeadline(time.Now().Add(5*time.Second)), qt.IsNil)
+				if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + tt.config.Addr + "\r\n\r\n")); err != nil {
+					return err
+				}
+				resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				return nil
+			}
+			first, err := net.Dial("tcp", tt.config.Addr)
+			c.Assert(err, qt.IsNil)
+			c.Assert(request(first), qt.IsNil)
+
+			second, err := net.Dial("tcp", tt.config.Addr)
+			c.Assert(err, qt.IsNil)
+			c.Assert(request(second), qt.IsNotNil)
+			second.Close()
+			c.Assert(testProxy.RejectedClients(), qt.Equals, int64(1))
+
+			// the closed connection frees its slot
+			first.Close()
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				third, err := net.Dial("tcp", tt.config.Addr)
+				c.Assert(err, qt.IsNil)
+				err = request(third)
+				third.Close()
+				if err == nil {
+					break
+				}
+				c.Assert(time.Now().Before(deadline), qt.IsTrue, qt.Commentf("the slot is still taken"))
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			c.Assert(testProxy.Close(), qt.IsNil)
+			c.Assert(<-errCh, qt.ErrorIs, http.ErrServerClosed)
+		})
+	}
+}
+
+func TestProxyServe(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	// Addr is not listened on
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:29129"}, ca)
+	c.Assert(err, qt.IsNil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	errCh := make(chan error, 1)
+	go func() { errCh <- testProxy.Serve(ln) }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://" + ln.Addr().String()) },
+		},
+	}
+	testSendRequest(c, upstream.URL, client, "ok")
+	_, err = net.Dial("tcp", "127.0.0.1:29129")
+	c.Assert(err, qt.IsNotNil)
+
+	c.Assert(testProxy.Close(), qt.IsNil)
+	c.Assert(<-errCh, qt.ErrorIs, http.ErrServerClosed)
+	// the listener is closed with the proxy
+	_, err = net.Dial("tcp", ln.Addr().String())
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestTraceContext(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("traceparent")))
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29099",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	testProxy.AddAddon(addons.NewTraceContext())
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := helper.getProxyClient()
+	send := func(c *qt.C, traceparent string) string {
+		req, err := http.NewRequest("GET", upstream.URL, nil)
+		c.Assert(err, qt.IsNil)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		resp, err := proxyClient.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		return string(body)
+	}
+
+	t.Run("missing traceparent is injected", func(t *testing.T) {
+		c := qt.New(t)
+		c.Assert(send(c, ""), qt.Matches, `00-[0-9a-f]{32}-[0-9a-f]{16}-01`)
+	})
+
+	t.Run("existing traceparent is preserved", func(t *testing.T) {
+		c := qt.New(t)
+		traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		c.Assert(send(c, traceparent), qt.Equals, traceparent)
+	})
+}
+
+func TestResponseBodyLimits(t *testing.T) {
+	payload := strings.Repeat("x", 2048)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+		case "/json-chunked":
+			// no Content-Length, the limit is only noticed while reading
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(payload[:1024]))
+			w.(http.Flusher).Flush()
+		case "/video":
+			w.Header().Set("Content-Type", "video/mp4")
+		}
+		_, _ = w.Write([]byte(payload))
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29100",
+		configure: func(config *proxy.Config) {
+			config.ResponseBodyLimits = map[string]int64{"application/json": 1024}
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := helper.getProxyClient()
+	get := func(c *qt.C, path string) (int, int) {
+		resp, err := proxyClient.Get(upstream.URL + path)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		return resp.StatusCode, len(body)
+	}
+
+	t.Run("json over its cap is rejected", func(t *testing.T) {
+		c := qt.New(t)
+		status, _ := get(c, "/json")
+		c.Assert(status, qt.Equals, http.StatusBadGateway)
+		status, _ = get(c, "/json-chunked")
+		c.Assert(status, qt.Equals, http.StatusBadGateway)
+	})
+
+	t.Run("video of the same size passes", func(t *testing.T) {
+		c := qt.New(t)
+		status, n := get(c, "/video")
+		c.Assert(status, qt.Equals, http.StatusOK)
+		c.Assert(n, qt.Equals, len(payload))
+	})
+}
+
+func TestHTTP3Interception(t *testing.T) {
+	c := qt.New(t)
+
+	upstreamCA, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	upstreamCert, err := upstreamCA.GetCert("localhost")
+	c.Assert(err, qt.IsNil)
+	upstreamConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	upstream := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{*upstreamCert}}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Proto + " " + r.Header.Get("X-Intercepted")))
+		}),
+	}
+	go func() { _ = upstream.Serve(upstreamConn) }()
+	defer upstream.Close()
+
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29101",
+		configure: func(config *proxy.Config) {
+			config.HTTP3Addr = "127.0.0.1:29101"
+			config.TransformRequest = func(req *proxy.Request) {
+				req.Header.Set("X-Intercepted", "yes")
+			}
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	// The client trusts only the proxy CA and sends its QUIC packets to the proxy,
+	// like a client whose DNS points the upstream host at the proxy.
+	proxyRoot := testProxy.GetCertificate()
+	roots := x509.NewCertPool()
+	roots.AddCert(&proxyRoot)
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		Dial: func(ctx context.Context, _ string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			return quic.DialAddrEarly(ctx, "127.0.0.1:29101", tlsCfg, cfg)
+		},
+	}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	port := upstreamConn.LocalAddr().(*net.UDPAddr).Port
+	resp, err := client.Get("https://localhost:" + strconv.Itoa(port) + "/")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(resp.Proto, qt.Equals, "HTTP/3.0")
+	c.Assert(resp.TLS.PeerCertificates[0].Issuer.CommonName, qt.Equals, proxyRoot.Subject.CommonName)
+	c.Assert(string(body), qt.Equals, "HTTP/3.0 yes")
+}
+
+func TestHTTP3ClientRestrictions(t *testing.T) {
+	ca, err := cert.NewSelfSignCAMemory()
+	qt.Assert(t, err, qt.IsNil)
+
+	tests := []struct {
+		name   string
+		config proxy.Config
+	}{
+		{name: "denied", config: proxy.Config{Addr: "127.0.0.1:29137", HTTP3Addr: "127.0.0.1:29137", DenyClients: []string{"127.0.0.1"}}},
+		{name: "per client", config: proxy.Config{Addr: "127.0.0.1:29138", HTTP3Addr: "127.0.0.1:29138", MaxConnectionsPerClient: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			testProxy, err := proxy.NewProxy(tt.config, ca)
+			c.Assert(err, qt.IsNil)
+			adn := &testClientConnectedAddon{}
+			testProxy.AddAddon(adn)
+			go func() { _ = testProxy.Start() }()
+			defer testProxy.Close()
+			time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+			// dial returns the connection if the proxy keeps it open
+			dial := func() (*quic.Conn, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				conn, err := quic.DialAddr(ctx, tt.config.HTTP3Addr, &tls.Config{
+					ServerName:         "localhost",
+					NextProtos:         []string{http3.NextProtoH3},
+					InsecureSkipVerify: true,
+				}, nil)
+				if err != nil {
+					return nil, err
+				}
+				select {
+				case <-conn.Context().Done():
+					return nil, context.Cause(conn.Context())
+				case <-time.After(200 * time.Millisecond):
+					return conn, nil
+				}
+			}
+
+			if tt.config.MaxConnectionsPerClient == 0 {
+				_, err := dial()
+				c.Assert(err, qt.IsNotNil)
+				c.Assert(testProxy.RejectedClients(), qt.Equals, int64(1))
+				c.Assert(adn.connected.Load(), qt.Equals, int64(0))
+				return
+			}
+
+			first, err := dial()
+			c.Assert(err, qt.IsNil)
+			_, err = dial()
+			c.Assert(err, qt.IsNotNil)
+			c.Assert(testProxy.RejectedClients(), qt.Equals, int64(1))
+
+			// the closed connection frees its slot
+			c.Assert(first.CloseWithError(0, ""), qt.IsNil)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				third, err := dial()
+				if err == nil {
+					_ = third.CloseWithError(0, "")
+					break
+				}
+				c.Assert(time.Now().Before(deadline), qt.IsTrue, qt.Commentf("the slot is still taken"))
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestHTTP3ListenFailure(t *testing.T) {
+	c := qt.New(t)
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer taken.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: taken.Addr().String(), HTTP3Addr: "127.0.0.1:29139"}, ca)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testProxy.Start(), qt.ErrorMatches, ".*address already in use")
+
+	// the HTTP/3 socket is released
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:29139")
+	c.Assert(err, qt.IsNil)
+	pconn.Close()
+}
+
+type tunneledPlainAddon struct {
+	proxy.BaseAddon
+	mu       sync.Mutex
+	urls     []string
+	messages []string
+}
+
+func (a *tunneledPlainAddon) Requestheaders(f *proxy.Flow) {
+	if f.Request.Method == http.MethodConnect {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.urls = append(a.urls, f.Request.URL.String())
+}
+
+func (a *tunneledPlainAddon) WebsocketMessage(_ *proxy.Flow, msg *proxy.WebSocketMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.messages = append(a.messages, string(msg.Content))
+}
+
+func TestPlainTrafficInConnectTunnel(t *testing.T) {
+	var wsConns atomic.Int32
+	wsUpstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&gorillaws.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		mt, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = ws.WriteMessage(mt, append([]byte("echo "), data...))
+	}))
+	wsUpstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			wsConns.Add(1)
+		}
+	}
+	wsUpstream.Start()
+	defer wsUpstream.Close()
+
+	// a protocol that is neither TLS nor HTTP
+	echoLn, err := net.Listen("tcp", "127.0.0.1:0")
+	qt.Assert(t, err, qt.IsNil)
+	defer echoLn.Close()
+	go func() {
+		for {
+			conn, err := echoLn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	testCases := []struct {
+		name         string
+		proxyAddr    string
+		upstreamCert bool
+	}{
+		{name: "dial first", proxyAddr: ":29102", upstreamCert: true},
+		{name: "lazy", proxyAddr: ":29103", upstreamCert: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			helper := &testProxyHelper{
+				server:    &http.Server{},
+				proxyAddr: tc.proxyAddr,
+			}
+			helper.init(c)
+			defer helper.ln.Close()
+			defer helper.tlsPlainLn.Close()
+			go func() { _ = helper.server.Serve(helper.ln) }()
+			testProxy := helper.testProxy
+			testProxy.AddAddon(addons.NewUpstreamCertAddon(tc.upstreamCert))
+			addon := &tunneledPlainAddon{}
+			testProxy.AddAddon(addon)
+			go func() { _ = testProxy.Start() }()
+			defer testProxy.Close()
+			time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+			proxyAddr := "127.0.0.1" + tc.proxyAddr
+
+			// plain http request inside a CONNECT tunnel
+			upstreamAddr := helper.ln.Addr().String()
+			conn, err := net.Dial("tcp", proxyAddr)
+			c.Assert(err, qt.IsNil)
+			defer conn.Close()
+			_, err = io.WriteString(conn, "CONNECT "+upstreamAddr+" HTTP/1.1\r\nHost: "+upstreamAddr+"\r\n\r\n")
+			c.Assert(err, qt.IsNil)
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			c.Assert(err, qt.IsNil)
+			c.Assert(resp.StatusCode, qt.Equals, 200)
+			_, err = io.WriteString(conn, "GET /echo HTTP/1.1\r\nHost: "+upstreamAddr+"\r\nX-Echo: tunneled\r\nConnection: close\r\n\r\n")
+			c.Assert(err, qt.IsNil)
+			resp, err = http.ReadResponse(br, nil)
+			c.Assert(err, qt.IsNil)
+			body, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			resp.Body.Close()
+			c.Assert(string(body), qt.Equals, "tunneled ")
+
+			// other protocols are tunneled as they are
+			raw, err := net.Dial("tcp", proxyAddr)
+			c.Assert(err, qt.IsNil)
+			defer raw.Close()
+			echoAddr := echoLn.Addr().String()
+			_, err = io.WriteString(raw, "CONNECT "+echoAddr+" HTTP/1.1\r\nHost: "+echoAddr+"\r\n\r\n")
+			c.Assert(err, qt.IsNil)
+			rawBr := bufio.NewReader(raw)
+			resp, err = http.ReadResponse(rawBr, nil)
+			c.Assert(err, qt.IsNil)
+			c.Assert(resp.StatusCode, qt.Equals, 200)
+			payload := []byte("\x00\x00\x00\x08\x04\xd2\x16\x2f binary\r\n")
+			_, err = raw.Write(payload)
+			c.Assert(err, qt.IsNil)
+			echoed := make([]byte, len(payload))
+			_, err = io.ReadFull(rawBr, echoed)
+			c.Assert(err, qt.IsNil)
+			c.Assert(echoed, qt.DeepEquals, payload)
+
+			// ws:// is always tunneled through CONNECT by gorilla
+			wsConns.Store(0)
+			proxyURL, err := url.Parse("http://" + proxyAddr)
+			c.Assert(err, qt.IsNil)
+			dialer := &gorillaws.Dialer{Proxy: http.ProxyURL(proxyURL)}
+			ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(wsUpstream.URL, "http"), nil)
+			c.Assert(err, qt.IsNil)
+			defer ws.Close()
+			c.Assert(ws.WriteMessage(gorillaws.TextMessage, []byte("hello")), qt.IsNil)
+			_, data, err := ws.ReadMessage()
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(data), qt.Equals, "echo hello")
+			// the connection dialed first is the one upgraded
+			c.Assert(wsConns.Load(), qt.Equals, int32(1))
+
+			addon.mu.Lock()
+			defer addon.mu.Unlock()
+			c.Assert(addon.urls, qt.DeepEquals, []string{"http://" + upstreamAddr + "/echo"})
+			c.Assert(addon.messages, qt.DeepEquals, []string{"hello", "echo hello"})
+		})
+	}
+}
+
+type sseRewriteAddon struct {
+	proxy.BaseAddon
+}
+
+func (*sseRewriteAddon) ServerSentEvent(_ *proxy.Flow, event []byte) []byte {
+	return bytes.ReplaceAll(event, []byte("secret"), []byte("redacted"))
+}
+
+func TestServerSentEvents(t *testing.T) {
+	c := qt.New(t)
+
+	// the second event is only sent once the client got the first one
+	firstReceived := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if r.URL.Path == "/gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			_, _ = io.WriteString(zw, "data: secret 1\n\n")
+			_ = zw.Close()
+			return
+		}
+		_, _ = io.WriteString(w, "data: secret 1\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-firstReceived:
+		case <-time.After(5 * time.Second):
+			return
+		}
+		_, _ = io.WriteString(w, "data: secret 2\n\n")
+	}))
+	defer upstream.Close()
+
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29104",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	testProxy.AddAddon(&sseRewriteAddon{})
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	resp, err := helper.getProxyClient().Get(upstream.URL)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "text/event-stream")
+
+	br := bufio.NewReader(resp.Body)
+	line, err := br.ReadString('\n')
+	c.Assert(err, qt.IsNil)
+	c.Assert(line, qt.Equals, "data: redacted 1\n")
+	close(firstReceived)
+
+	rest, err := io.ReadAll(br)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(rest), qt.Equals, "\ndata: redacted 2\n\n")
+
+	// compressed events cannot be split, they are passed through
+	resp, err = helper.getProxyClient().Get(upstream.URL + "/gzip")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		body, err = gzip.NewReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+	}
+	events, err := io.ReadAll(body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(events), qt.Equals, "data: secret 1\n\n")
+}
+
+type requestTrailerAddon struct {
+	proxy.BaseAddon
+	mu      sync.Mutex
+	trailer string
+}
+
+func (a *requestTrailerAddon) Request(f *proxy.Flow) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.trailer = f.Request.Trailer.Get("X-Checksum")
+	f.Request.Trailer.Set("X-Checksum", a.trailer+"-checked")
+}
+
+func TestRequestAndResponseTrailers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Trailer", "X-Resp")
+		_, _ = w.Write([]byte(string(body) + "|" + r.Trailer.Get("X-Checksum")))
+		w.Header().Set("X-Resp", "done")
+	}))
+	defer upstream.Close()
+
+	testCases := []struct {
+		name        string
+		proxyAddr   string
+		streamLimit int64
+		wantBody    string
+		wantSeen    string
+	}{
+		{name: "buffered", proxyAddr: ":29105", wantBody: "payload|abc-checked", wantSeen: "abc"},
+		{name: "streamed", proxyAddr: ":29106", streamLimit: 1, wantBody: "payload|abc", wantSeen: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			helper := &testProxyHelper{
+				server:    &http.Server{},
+				proxyAddr: tc.proxyAddr,
+				configure: func(config *proxy.Config) {
+					config.StreamLargeBodies = tc.streamLimit
+				},
+			}
+			helper.init(c)
+			defer helper.ln.Close()
+			defer helper.tlsPlainLn.Close()
+			testProxy := helper.testProxy
+			addon := &requestTrailerAddon{}
+			testProxy.AddAddon(addon)
+			go func() { _ = testProxy.Start() }()
+			defer testProxy.Close()
+			time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+			req, err := http.NewRequest("POST", upstream.URL, io.MultiReader(strings.NewReader("payload")))
+			c.Assert(err, qt.IsNil)
+			req.ContentLength = -1
+			req.Trailer = http.Header{"X-Checksum": {"abc"}}
+			resp, err := helper.getProxyClient().Do(req)
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+
+			c.Assert(string(body), qt.Equals, tc.wantBody)
+			c.Assert(resp.Trailer.Get("X-Resp"), qt.Equals, "done")
+			addon.mu.Lock()
+			defer addon.mu.Unlock()
+			c.Assert(addon.trailer, qt.Equals, tc.wantSeen)
+		})
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(strconv.Itoa(len(body))))
+	}))
+	defer upstream.Close()
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29107",
+		configure: func(config *proxy.Config) {
+			config.StreamLargeBodies = 100
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	sendHeaders := func(c *qt.C, path string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", "127.0.0.1"+helper.proxyAddr)
+		c.Assert(err, qt.IsNil)
+		_, err = io.WriteString(conn, "POST "+upstream.URL+path+" HTTP/1.1\r\nHost: "+strings.TrimPrefix(upstream.URL, "http://")+
+			"\r\nContent-Length: 1000\r\nExpect: 100-continue\r\n\r\n")
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.SetReadDeadline(time.Now().Add(5*time.Second)), qt.IsNil)
+		return conn, bufio.NewReader(conn)
+	}
+
+	t.Run("upstream accepts the body", func(t *testing.T) {
+		c := qt.New(t)
+		conn, br := sendHeaders(c, "/accept")
+		defer conn.Close()
+
+		status, err := br.ReadString('\n')
+		c.Assert(err, qt.IsNil)
+		c.Assert(status, qt.Equals, "HTTP/1.1 100 Continue\r\n")
+		blank, err := br.ReadString('\n')
+		c.Assert(err, qt.IsNil)
+		c.Assert(blank, qt.Equals, "\r\n")
+
+		_, err = conn.Write(bytes.Repeat([]byte("x"), 1000))
+		c.Assert(err, qt.IsNil)
+		resp, err := http.ReadResponse(br, nil)
+		c.Assert(err, qt.IsNil)
+		body, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(string(body), qt.Equals, "1000")
+	})
+
+	t.Run("upstream rejects the body", func(t *testing.T) {
+		c := qt.New(t)
+		conn, br := sendHeaders(c, "/reject")
+		defer conn.Close()
+
+		// the final response comes without an interim 100 and without sending the body
+		resp, err := http.ReadResponse(br, nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusRequestEntityTooLarge)
+	})
+}
+
+func TestMimicClientHello(t *testing.T) {
+	extensionsSeen := make(chan []uint16, 1)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	upstream.TLS = &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			extensionsSeen <- chi.Extensions
+			return nil, nil
+		},
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+	upstreamAddr := "localhost:" + strconv.Itoa(upstream.Listener.Addr().(*net.TCPAddr).Port)
+
+	// the fingerprint of a Firefox client connecting directly
+	direct, err := net.Dial("tcp", upstreamAddr)
+	qt.Assert(t, err, qt.IsNil)
+	directTLS := utls.UClient(direct, &utls.Config{ServerName: "localhost", InsecureSkipVerify: true}, utls.HelloFirefox_Auto)
+	qt.Assert(t, directTLS.Handshake(), qt.IsNil)
+	directTLS.Close()
+	firefoxExtensions := <-extensionsSeen
+
+	testCases := []struct {
+		name      string
+		proxyAddr string
+		mimic     bool
+	}{
+		{name: "upstream sees the client's fingerprint", proxyAddr: ":29108", mimic: true},
+		{name: "upstream sees go's fingerprint by default", proxyAddr: ":29109", mimic: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			helper := &testProxyHelper{
+				server:    &http.Server{},
+				proxyAddr: tc.proxyAddr,
+				configure: func(config *proxy.Config) {
+					config.InsecureSkipVerify = true
+					config.MimicClientHello = tc.mimic
+				},
+			}
+			helper.init(c)
+			defer helper.ln.Close()
+			defer helper.tlsPlainLn.Close()
+			testProxy := helper.testProxy
+			go func() { _ = testProxy.Start() }()
+			defer testProxy.Close()
+			time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+			conn, err := net.Dial("tcp", "127.0.0.1"+tc.proxyAddr)
+			c.Assert(err, qt.IsNil)
+			defer conn.Close()
+			c.Assert(conn.SetDeadline(time.Now().Add(5*time.Second)), qt.IsNil)
+			_, err = io.WriteString(conn, "CONNECT "+upstreamAddr+" HTTP/1.1\r\nHost: "+upstreamAddr+"\r\n\r\n")
+			c.Assert(err, qt.IsNil)
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			c.Assert(err, qt.IsNil)
+			c.Assert(resp.StatusCode, qt.Equals, 200)
+
+			tlsConn := utls.UClient(conn, &utls.Config{ServerName: "localhost", InsecureSkipVerify: true}, utls.HelloFirefox_Auto)
+			c.Assert(tlsConn.Handshake(), qt.IsNil)
+			if tc.mimic {
+				c.Assert(<-extensionsSeen, qt.DeepEquals, firefoxExtensions)
+			} else {
+				c.Assert(<-extensionsSeen, qt.Not(qt.DeepEquals), firefoxExtensions)
+			}
+
+			_, err = io.WriteString(tlsConn, "GET / HTTP/1.1\r\nHost: "+upstreamAddr+"\r\n\r\n")
+			c.Assert(err, qt.IsNil)
+			resp, err = http.ReadResponse(bufio.NewReader(tlsConn), nil)
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(body), qt.Equals, "ok")
+		})
+	}
+}
+
+func TestUpstreamClientCertificates(t *testing.T) {
+	clientCA, err := cert.NewSelfSignCAMemory()
+	qt.Assert(t, err, qt.IsNil)
+	clientCert, err := clientCA.GetCert("client")
+	qt.Assert(t, err, qt.IsNil)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.GetRootCA())
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+	upstreamURL := "https://localhost:" + strconv.Itoa(upstream.Listener.Addr().(*net.TCPAddr).Port)
+
+	testCases := []struct {
+		name      string
+		proxyAddr string
+		hosts     []string
+		wantOK    bool
+	}{
+		{name: "matching host", proxyAddr: ":29110", hosts: []string{"*.example.com", "localhost"}, wantOK: true},
+		{name: "no matching host", proxyAddr: ":29111", hosts: []string{"example.com"}, wantOK: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			helper := &testProxyHelper{
+				server:    &http.Server{},
+				proxyAddr: tc.proxyAddr,
+				configure: func(config *proxy.Config) {
+					config.ClientCertificates = []proxy.ClientCertificate{
+						{Hosts: tc.hosts, Certificate: *clientCert},
+					}
+				},
+			}
+			helper.init(c)
+			defer helper.ln.Close()
+			defer helper.tlsPlainLn.Close()
+			testProxy := helper.testProxy
+			separateClient := &separateClientAddon{}
+			testProxy.AddAddon(separateClient)
+			go func() { _ = testProxy.Start() }()
+			defer testProxy.Close()
+			time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+			// the intercepted connection and the separate client both present the certificate
+			for _, path := range []string{"/", "/separate-client"} {
+				resp, err := helper.getProxyClient().Get(upstreamURL + path)
+				if !tc.wantOK {
+					// the server rejects the handshake, which fails the request one way or another
+					if err == nil {
+						resp.Body.Close()
+						c.Assert(resp.StatusCode, qt.Equals, http.StatusBadGateway, qt.Commentf(path))
+					}
+					continue
+				}
+				c.Assert(err, qt.IsNil)
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				c.Assert(err, qt.IsNil)
+				c.Assert(resp.StatusCode, qt.Equals, 200, qt.Commentf(path))
+				c.Assert(string(body), qt.Equals, "hello client")
+			}
+		})
+	}
+}
+
+// addon recording the client certificate of every request.
+type clientCertificateAddon struct {
+	proxy.BaseAddon
+	mu    sync.Mutex
+	names []string
+}
+
+func (adn *clientCertificateAddon) Request(f *proxy.Flow) {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	name := ""
+	if certs := f.ConnContext.ClientConn.PeerCertificates; len(certs) > 0 {
+		name = certs[0].Subject.CommonName
+	}
+	adn.names = append(adn.names, name)
+}
+
+func TestRequireClientCertificates(t *testing.T) {
+	clientCA, err := cert.NewSelfSignCAMemory()
+	qt.Assert(t, err, qt.IsNil)
+	clientCert, err := clientCA.GetCert("alice")
+	qt.Assert(t, err, qt.IsNil)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.GetRootCA())
+
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29112",
+		configure: func(config *proxy.Config) {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+			config.ClientCAs = clientCAs
+		},
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	addon := &clientCertificateAddon{}
+	testProxy.AddAddon(addon)
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := helper.getProxyClient()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+	testSendRequest(c, helper.httpsEndpoint, client, "ok")
+	c.Assert(addon.names, qt.DeepEquals, []string{"alice"})
+
+	// clients without a certificate are refused
+	_, err = helper.getProxyClient().Get(helper.httpsEndpoint)
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(addon.names, qt.HasLen, 1)
+}
+
+type serverCertificateAddon struct {
+	proxy.BaseAddon
+	mu     sync.Mutex
+	chains [][]proxy.CertificateInfo
+}
+
+func (adn *serverCertificateAddon) TLSEstablishedServer(connCtx *proxy.ConnContext) {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	adn.chains = append(adn.chains, connCtx.ServerConn.CertificateChain())
+}
+
+func TestUpstreamCertificateChain(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29113",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	addon := &serverCertificateAddon{}
+	testProxy.AddAddon(addon)
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	testSendRequest(c, helper.httpsEndpoint, helper.getProxyClient(), "ok")
+
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	c.Assert(addon.chains, qt.HasLen, 1)
+	chain := addon.chains[0]
+	c.Assert(chain, qt.HasLen, 1)
+	c.Assert(chain[0].Subject, qt.Equals, "CN=localhost,O=mitmproxy")
+	c.Assert(chain[0].Issuer, qt.Equals, "CN=mitmproxy,O=mitmproxy")
+	c.Assert(chain[0].DNSNames, qt.DeepEquals, []string{"localhost"})
+	c.Assert(chain[0].Fingerprint, qt.HasLen, 64)
+	c.Assert(chain[0].NotAfter.After(time.Now()), qt.IsTrue)
+}
+
+func TestResponseCacheRevalidation(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29114",
+	}
+	helper.init(c)
+	var requests, notModified atomic.Int32
+	helper.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	testProxy.AddAddon(addons.NewResponseCache(addons.ResponseCacheOptions{}))
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	proxyClient := helper.getProxyClient()
+	testSendRequest(c, helper.httpsEndpoint, proxyClient, "ok")
+	testSendRequest(c, helper.httpsEndpoint, proxyClient, "ok")
+
+	c.Assert(requests.Load(), qt.Equals, int32(2))
+	c.Assert(notModified.Load(), qt.Equals, int32(1))
+}
+
+type replayRecorderAddon struct {
+	proxy.BaseAddon
+	mu      sync.Mutex
+	replays []bool
+}
+
+func (adn *replayRecorderAddon) Response(f *proxy.Flow) {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	adn.replays = append(adn.replays, f.IsReplay)
+}
+
+func TestReplayFlow(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29115",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	recorder := &replayRecorderAddon{}
+	testProxy.AddAddon(recorder)
+	go func() { _ = helper.server.Serve(helper.tlsLn) }()
+
+	u, err := url.Parse(helper.httpsEndpoint + "echo")
+	c.Assert(err, qt.IsNil)
+	recorded := proxy.NewFlow()
+	recorded.Request = &proxy.Request{
+		Method: "POST",
+		URL:    u,
+		Proto:  "HTTP/1.1",
+		Header: http.Header{"X-Echo": {"replayed"}},
+		Body:   []byte("body"),
+	}
+
+	replayed, err := testProxy.ReplayFlow(context.Background(), recorded)
+	c.Assert(err, qt.IsNil)
+	c.Assert(replayed, qt.Not(qt.Equals), recorded)
+	c.Assert(replayed.IsReplay, qt.IsTrue)
+	c.Assert(replayed.UsedSeparateClient, qt.IsTrue)
+	c.Assert(replayed.Response.StatusCode, qt.Equals, 200)
+	c.Assert(string(replayed.Response.Body), qt.Equals, "replayed body")
+	c.Assert(replayed.ConnContext.ClientConn.Conn.RemoteAddr().String(), qt.Equals, "replay")
+	select {
+	case <-replayed.Done():
+	default:
+		c.Fatal("replayed flow not finished")
+	}
+	c.Assert(recorder.replays, qt.DeepEquals, []bool{true})
+
+	// the addons may answer replays too
+	intercepted := *recorded.Request
+	intercepted.URL = &url.URL{Scheme: "https", Host: u.Host, Path: "/intercept-request"}
+	recorded.Request = &intercepted
+	replayed, err = testProxy.ReplayFlow(context.Background(), recorded)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(replayed.Response.Body), qt.Equals, "intercept-request")
+
+	// a server that cannot be reached leaves the replay without response
+	unreachable := *recorded.Request
+	unreachable.URL = &url.URL{Scheme: "http", Host: "127.0.0.1:1", Path: "/"}
+	recorded.Request = &unreachable
+	replayed, err = testProxy.ReplayFlow(context.Background(), recorded)
+	c.Assert(err, qt.ErrorMatches, "replay failed with status 502: .*connect: connection refused")
+	c.Assert(replayed.Response, qt.IsNil)
+	c.Assert(errors.Is(err, replayed.Error), qt.IsTrue)
+
+	_, err = testProxy.ReplayFlow(context.Background(), proxy.NewFlow())
+	c.Assert(err, qt.ErrorMatches, "flow without request")
+}
+
+type errorAddon struct {
+	proxy.BaseAddon
+	mu     sync.Mutex
+	errs   []error
+	answer bool
+}
+
+func (adn *errorAddon) OnError(f *proxy.Flow, err error) {
+	adn.mu.Lock()
+	adn.errs = append(adn.errs, f.Error)
+	answer := adn.answer
+	adn.mu.Unlock()
+	if answer {
+		f.Response = &proxy.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       []byte("upstream down: " + err.Error()),
+		}
+	}
+}
+
+func (adn *errorAddon) takeErrs() []error {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	errs := adn.errs
+	adn.errs = nil
+	return errs
+}
+
+func TestOnError(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29116",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+	errs := &errorAddon{}
+	testProxy.AddAddon(errs)
+	go func() { _ = helper.server.Serve(helper.ln) }()
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	// nothing listens on the port of a closed listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	unreachable := "http://" + ln.Addr().String() + "/"
+	c.Assert(ln.Close(), qt.IsNil)
+	proxyClient := helper.getProxyClient()
+
+	t.Run("the client gets a 502 by default", func(t *testing.T) {
+		c := qt.New(t)
+		resp, err := proxyClient.Get(unreachable)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusBadGateway)
+		got := errs.takeErrs()
+		c.Assert(got, qt.HasLen, 1)
+		c.Assert(got[0], qt.ErrorMatches, ".*connection refused")
+	})
+
+	t.Run("addons may answer instead", func(t *testing.T) {
+		c := qt.New(t)
+		errs.mu.Lock()
+		errs.answer = true
+		errs.mu.Unlock()
+		resp, err := proxyClient.Get(unreachable)
+		c.Assert(err, qt.IsNil)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusServiceUnavailable)
+		c.Assert(string(body), qt.Matches, "upstream down: .*connection refused")
+		c.Assert(errs.takeErrs(), qt.HasLen, 1)
+	})
+
+	t.Run("successful flows do not call it", func(t *testing.T) {
+		c := qt.New(t)
+		testSendRequest(c, helper.httpEndpoint, proxyClient, "ok")
+		c.Assert(errs.takeErrs(), qt.HasLen, 0)
+	})
+}
+
+type killAddon struct {
+	errorAddon
+	streamed chan *proxy.Flow
+}
+
+func (adn *killAddon) Requestheaders(f *proxy.Flow) {
+	if f.Request.URL.Path == "/kill-requestheaders" {
+		f.Kill("blocked")
+	}
+}
+
+func (adn *killAddon) Responseheaders(f *proxy.Flow) {
+	if f.Request.URL.Path == "/stream" {
+		f.Stream = true
+	}
+}
+
+// StreamResponseModifier hands the flow over once the first bytes were streamed.
+func (adn *killAddon) StreamResponseModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	if f.Request.URL.Path != "/stream" {
+		return in
+	}
+	return &notifyReader{r: in, notify: func() { adn.streamed <- f }}
+}
+
+type notifyReader struct {
+	r      io.Reader
+	notify func()
+	once   sync.Once
+}
+
+func (r *notifyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.once.Do(r.notify)
+	}
+	return n, err
+}
+
+func (adn *killAddon) Response(f *proxy.Flow) {
+	if f.Request.URL.Path == "/kill-response" {
+		f.Kill("")
+	}
+}
+
+func TestFlowKill(t *testing.T) {
+	c := qt.New(t)
+	var hits atomic.Int64
+	release := make(chan struct{})
+	defer close(release)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/stream" {
+			_, _ = w.Write([]byte("first"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:29130"}, ca)
+	c.Assert(err, qt.IsNil)
+	adn := &killAddon{streamed: make(chan *proxy.Flow, 1)}
+	testProxy.AddAddon(adn)
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://127.0.0.1:29130") },
+		},
+	}
+
+	c.Run("before the request is sent", func(c *qt.C) {
+		resp, err := client.Get(upstream.URL + "/kill-requestheaders")
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusBadGateway)
+		c.Assert(resp.Close, qt.IsTrue)
+		c.Assert(hits.Load(), qt.Equals, int64(0))
+		errs := adn.takeErrs()
+		c.Assert(errs, qt.HasLen, 1)
+		c.Assert(errs[0], qt.ErrorIs, proxy.ErrFlowKilled)
+		c.Assert(errs[0], qt.ErrorMatches, "flow killed: blocked")
+	})
+
+	c.Run("in the response event", func(c *qt.C) {
+		resp, err := client.Get(upstream.URL + "/kill-response")
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusBadGateway)
+		c.Assert(hits.Load(), qt.Equals, int64(1))
+		errs := adn.takeErrs()
+		c.Assert(errs, qt.HasLen, 1)
+		c.Assert(errs[0], qt.Equals, proxy.ErrFlowKilled)
+	})
+
+	c.Run("while the response is streamed", func(c *qt.C) {
+		got := make(chan error, 1)
+		go func() {
+			resp, err := client.Get(upstream.URL + "/stream")
+			if err != nil {
+				got <- err
+				return
+			}
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			got <- err
+		}()
+
+		f := <-adn.streamed
+		f.Kill("too slow")
+		// the client does not take the cut response as complete
+		select {
+		case err := <-got:
+			c.Assert(err, qt.IsNotNil)
+		case <-time.After(5 * time.Second):
+			c.Fatal("the killed response is still streamed")
+		}
+		select {
+		case <-f.Done():
+		case <-time.After(5 * time.Second):
+			c.Fatal("the killed flow did not finish")
+		}
+		errs := adn.takeErrs()
+		c.Assert(errs, qt.HasLen, 1)
+		c.Assert(errs[0], qt.ErrorMatches, "flow killed: too slow")
+	})
+}
+
+type lifecycleAddon struct {
+	proxy.BaseAddon
+	name   string
+	events *[]string
+	mu     *sync.Mutex
+	proxy  *proxy.Proxy
+}
+
+func (adn *lifecycleAddon) Running(p *proxy.Proxy) {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	adn.proxy = p
+	*adn.events = append(*adn.events, "running "+adn.name)
+}
+
+func (adn *lifecycleAddon) Done() {
+	adn.mu.Lock()
+	defer adn.mu.Unlock()
+	*adn.events = append(*adn.events, "done "+adn.name)
+}
+
+type finishedFlowsAddon struct {
+	proxy.BaseAddon
+	finished chan *proxy.Flow
+}
+
+func (adn *finishedFlowsAddon) Requestheaders(f *proxy.Flow) {
+	if f.Request.Method == http.MethodConnect {
+		return
+	}
+	go func() {
+		<-f.Done()
+		adn.finished <- f
+	}()
+}
+
+func TestFlowTimings(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:29131", InsecureSkipVerify: true}, ca)
+	c.Assert(err, qt.IsNil)
+	adn := &finishedFlowsAddon{finished: make(chan *proxy.Flow, 2)}
+	testProxy.AddAddon(adn)
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           func(*http.Request) (*url.URL, error) { return url.Parse("http://127.0.0.1:29131") },
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	get := func(url string) proxy.Timings {
+		resp, err := client.Get(url)
+		c.Assert(err, qt.IsNil)
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		select {
+		case f := <-adn.finished:
+			return f.Timings()
+		case <-time.After(5 * time.Second):
+			c.Fatal("flow did not finish")
+			return proxy.Timings{}
+		}
+	}
+
+	// the first flow dials the server
+	timings := get(upstream.URL)
+	c.Assert(timings.RequestStart.IsZero(), qt.IsFalse)
+	c.Assert(timings.DialStart.Before(timings.RequestStart), qt.IsFalse)
+	c.Assert(timings.ConnectDuration() > 0, qt.IsTrue)
+	c.Assert(timings.TLSEstablished.IsZero(), qt.IsTrue)
+	c.Assert(timings.TimeToFirstByte() >= 10*time.Millisecond, qt.IsTrue)
+	c.Assert(timings.End.Before(timings.FirstResponseByte), qt.IsFalse)
+	c.Assert(timings.Duration() >= timings.TimeToFirstByte(), qt.IsTrue)
+
+	// the second one reuses its connection
+	timings = get(upstream.URL)
+	c.Assert(timings.DialStart.IsZero(), qt.IsTrue)
+	c.Assert(timings.Connected.IsZero(), qt.IsTrue)
+	c.Assert(timings.TimeToFirstByte() >= 10*time.Millisecond, qt.IsTrue)
+	c.Assert(timings.End.IsZero(), qt.IsFalse)
+
+	// intercepted HTTPS connections are dialed before their first flow, which gets
+	// the times of the dial and the handshake
+	timings = get(tlsUpstream.URL)
+	c.Assert(timings.ConnectDuration() > 0, qt.IsTrue)
+	c.Assert(timings.TLSDuration() > 0, qt.IsTrue)
+	c.Assert(timings.TimeToFirstByte() >= 10*time.Millisecond, qt.IsTrue)
+
+	// the next flow on that connection does not
+	timings = get(tlsUpstream.URL)
+	c.Assert(timings.DialStart.IsZero(), qt.IsTrue)
+	c.Assert(timings.TLSEstablished.IsZero(), qt.IsTrue)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWireCapture(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "yes")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(upstream.Config.Handler)
+	defer tlsUpstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	var wire syncBuffer
+	testProxy, err := proxy.NewProxy(proxy.Config{
+		Addr:               "127.0.0.1:29132",
+		InsecureSkipVerify: true,
+		WireCapture:        &wire,
+	}, ca)
+	c.Assert(err, qt.IsNil)
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             func(*http.Request) (*url.URL, error) { return url.Parse("http://127.0.0.1:29132") },
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	for _, u := range []string{upstream.URL + "/plain", tlsUpstream.URL + "/tls"} {
+		resp, err := client.Get(u)
+		c.Assert(err, qt.IsNil)
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	captured := wire.String()
+	// the plain request as the client sent it, and as the proxy sent it upstream
+	c.Assert(captured, qt.Matches, `(?s).* client>proxy \d+\nGET http://127\.0\.0\.1:\d+/plain HTTP/1\.1\r\n.*`)
+	c.Assert(captured, qt.Matches, `(?s).* proxy>server \d+\nGET /plain HTTP/1\.1\r\n.*`)
+	c.Assert(captured, qt.Matches, `(?s).* server>proxy \d+\nHTTP/1\.1 200 OK\r\n.*X-Upstream: yes.*`)
+	// the intercepted HTTPS request decrypted, after the CONNECT
+	c.Assert(captured, qt.Matches, `(?s).* client>proxy \d+\nCONNECT 127\.0\.0\.1:\d+ HTTP/1\.1\r\n.*`)
+	c.Assert(captured, qt.Matches, `(?s).* client>proxy \d+\nGET /tls HTTP/1\.1\r\n.*`)
+	c.Assert(captured, qt.Matches, `(?s).* proxy>server \d+\nGET /tls HTTP/1\.1\r\n.*`)
+	// no TLS records are captured
+	c.Assert(captured, qt.Not(qt.Contains), "\x16\x03")
+}
+
+// staticResolver resolves the hosts it knows to their addresses.
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	return r[host], nil
+}
+
+func TestFlowResolution(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	_, port, err := net.SplitHostPort(upstream.Listener.Addr().String())
+	c.Assert(err, qt.IsNil)
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	config := proxy.Config{
+		Addr:     "127.0.0.1:29133",
+		Resolver: staticResolver{"service.internal": {"127.0.0.1"}},
+	}
+	testProxy, err := proxy.NewProxy(config, ca)
+	c.Assert(err, qt.IsNil)
+	adn := &finishedFlowsAddon{finished: make(chan *proxy.Flow, 1)}
+	testProxy.AddAddon(adn)
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://127.0.0.1:29133") },
+		},
+	}
+	resp, err := client.Get("http://service.internal:" + port + "/")
+	c.Assert(err, qt.IsNil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "ok")
+
+	var f *proxy.Flow
+	select {
+	case f = <-adn.finished:
+	case <-time.After(5 * time.Second):
+		c.Fatal("flow did not finish")
+	}
+	resolution := f.Resolution()
+	c.Assert(resolution, qt.IsNotNil)
+	c.Assert(resolution.Host, qt.Equals, "service.internal")
+	c.Assert(resolution.Addrs, qt.DeepEquals, []string{"127.0.0.1"})
+	c.Assert(resolution.Error, qt.Equals, "")
+	c.Assert(resolution.Duration() >= 0, qt.IsTrue)
+}
+
+func TestOutboundLocalAddr(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	}))
+	defer upstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	_, err = proxy.NewProxy(proxy.Config{Addr: ":0", OutboundLocalAddr: "eth0"}, ca)
+	c.Assert(err, qt.ErrorMatches, `invalid outbound local address "eth0"`)
+
+	// 192.0.2.1 is reserved for documentation, so it is not a local address to bind to
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:29134", OutboundLocalAddr: "192.0.2.1"}, ca)
+	c.Assert(err, qt.IsNil)
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://127.0.0.1:29134") },
+		},
+	}
+	resp, err := client.Get(upstream.URL)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadGateway)
+
+	testProxy.SetOutboundLocalAddr(func(req *http.Request) net.IP {
+		if req.URL.Hostname() == "127.0.0.1" {
+			return net.IPv4(127, 0, 0, 1)
+		}
+		return nil
+	})
+	resp, err = client.Get(upstream.URL)
+	c.Assert(err, qt.IsNil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(string(body), qt.Matches, `127\.0\.0\.1:\d+`)
+}
+
+// spooledBodiesAddon sends the spooled bodies seen by the Request and Response addon
+// events to bodies.
+type spooledBodiesAddon struct {
+	proxy.BaseAddon
+	bodies chan string
+}
+
+func (adn *spooledBodiesAddon) Request(f *proxy.Flow) {
+	adn.send(f.Request.SpooledBody)
+}
+
+func (adn *spooledBodiesAddon) Response(f *proxy.Flow) {
+	adn.send(f.Response.SpooledBody)
+}
+
+func (adn *spooledBodiesAddon) send(body *proxy.SpooledBody) {
+	if body == nil {
+		adn.bodies <- ""
+		return
+	}
+	data, err := io.ReadAll(body.Reader())
+	if err != nil {
+		adn.bodies <- err.Error()
+		return
+	}
+	adn.bodies <- string(data)
+}
+
+// wrapRequestBodyAddon wraps the bodies of requests with an X-Wrap-Body header in a
+// stream request modifier, as modifiers changing the body do.
+type wrapRequestBodyAddon struct {
+	proxy.BaseAddon
+}
+
+func (*wrapRequestBodyAddon) StreamRequestModifier(f *proxy.Flow, in io.Reader) io.Reader {
+	if f.Request.Header.Get("X-Wrap-Body") == "" {
+		return in
+	}
+	return io.MultiReader(in)
+}
+
+func TestSpoolLargeBodies(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		_, _ = w.Write(append([]byte("echo: "), body...))
+	}))
+	defer upstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	dir := t.TempDir()
+	config := proxy.Config{
+		Addr:              "127.0.0.1:29135",
+		StreamLargeBodies: 16,
+		SpoolLargeBodies:  64,
+		SpoolDir:          dir,
+	}
+	testProxy, err := proxy.NewProxy(config, ca)
+	c.Assert(err, qt.IsNil)
+	spooled := &spooledBodiesAddon{bodies: make(chan string, 2)}
+	testProxy.AddAddon(spooled)
+	finished := &finishedFlowsAddon{finished: make(chan *proxy.Flow, 1)}
+	testProxy.AddAddon(finished)
+	testProxy.AddAddon(&wrapRequestBodyAddon{})
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) { return url.Parse("http://127.0.0.1:29135") },
+		},
+	}
+	post := func(body string, header ...string) (*http.Response, string, *proxy.Flow) {
+		req, err := http.NewRequest(http.MethodPost, upstream.URL, strings.NewReader(body))
+		c.Assert(err, qt.IsNil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, qt.IsNil)
+		select {
+		case f := <-finished.finished:
+			return resp, string(data), f
+		case <-time.After(5 * time.Second):
+			c.Fatal("flow did not finish")
+		}
+		return nil, "", nil
+	}
+
+	// too large to buffer, small enough to spool
+	body := strings.Repeat("spooled ", 5)
+	resp, data, f := post(body)
+	c.Assert(data, qt.Equals, "echo: "+body)
+	c.Assert(resp.Header.Get("X-Content-Length"), qt.Equals, "40")
+	c.Assert(f.Stream, qt.IsFalse)
+	c.Assert(<-spooled.bodies, qt.Equals, body)
+	c.Assert(<-spooled.bodies, qt.Equals, "echo: "+body)
+	// the spooled bodies are removed right after the flow finished
+	spoolRemoved := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			entries, err := os.ReadDir(dir)
+			c.Assert(err, qt.IsNil)
+			if len(entries) == 0 {
+				return
+			}
+			c.Assert(time.Now().Before(deadline), qt.IsTrue, qt.Commentf("spooled bodies left: %v", entries))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	spoolRemoved()
+	// the spooled request body is gone with the spool
+	_, err = testProxy.ReplayFlow(context.Background(), f)
+	c.Assert(err, qt.ErrorMatches, "flow with spooled request body")
+
+	// a stream request modifier changing the body sends it chunked
+	resp, data, _ = post(body, "X-Wrap-Body", "1")
+	c.Assert(data, qt.Equals, "echo: "+body)
+	c.Assert(resp.Header.Get("X-Content-Length"), qt.Equals, "-1")
+	<-spooled.bodies
+	<-spooled.bodies
+	spoolRemoved()
+
+	// too large to spool
+	body = strings.Repeat("streamed ", 10)
+	_, data, f = post(body)
+	c.Assert(data, qt.Equals, "echo: "+body)
+	c.Assert(f.Stream, qt.IsTrue)
+	c.Assert(spooled.bodies, qt.HasLen, 0)
+	spoolRemoved()
+}
+
+func TestTunnelBytes(t *testing.T) {
+	c := qt.New(t)
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer echo.Close()
+	echoed := make(chan net.Conn, 1)
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		echoed <- conn
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	testProxy, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:29136"}, ca)
+	c.Assert(err, qt.IsNil)
+	testProxy.SetShouldInterceptRule(func(*http.Request) bool { return false })
+	go func() { _ = testProxy.Start() }()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	target := echo.Addr().String()
+	conn, err := net.Dial("tcp", "127.0.0.1:29136")
+	c.Assert(err, qt.IsNil)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	c.Assert(err, qt.IsNil)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+	// more than a chunk of the counters, both ways
+	payload := bytes.Repeat([]byte("tunnel"), 300_000)
+	go func() { _, _ = conn.Write(payload) }()
+	reply := make([]byte, len(payload))
+	_, err = io.ReadFull(br, reply)
+	c.Assert(err, qt.IsNil)
+	c.Assert(bytes.Equal(reply, payload), qt.IsTrue)
+
+	// the last bytes are counted once the tunnel ends
+	conn.Close()
+	(<-echoed).Close()
+	for testProxy.ActiveTunnels() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	sent, received := testProxy.TunnelBytes()
+	c.Assert(sent, qt.Equals, int64(len(payload)))
+	c.Assert(received, qt.Equals, int64(len(payload)))
+}
+
+func TestLifecycleAddons(t *testing.T) {
+	c := qt.New(t)
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29117",
+	}
+	helper.init(c)
+	defer helper.ln.Close()
+	defer helper.tlsPlainLn.Close()
+	testProxy := helper.testProxy
+
+	var mu sync.Mutex
+	var events []string
+	takeEvents := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := events
+		events = nil
+		return taken
+	}
+	newAddon := func(name string) *lifecycleAddon {
+		return &lifecycleAddon{name: name, events: &events, mu: &mu}
+	}
+	first, second, late := newAddon("first"), newAddon("second"), newAddon("late")
+	testProxy.AddAddon(first)
+	testProxy.AddAddon(second)
+	removed := newAddon("removed")
+	testProxy.AddAddon(removed)
+	c.Assert(testProxy.RemoveAddon(removed), qt.IsTrue)
+	c.Assert(takeEvents(), qt.HasLen, 0)
+
+	started := make(chan error, 1)
+	go func() { started <- testProxy.Start() }()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+	c.Assert(takeEvents(), qt.DeepEquals, []string{"running first", "running second"})
+	first.mu.Lock()
+	c.Assert(first.proxy, qt.Equals, testProxy)
+	first.mu.Unlock()
+
+	// addons added to and removed from the running proxy start and stop at once
+	testProxy.AddAddon(late)
+	c.Assert(takeEvents(), qt.DeepEquals, []string{"running late"})
+	c.Assert(testProxy.RemoveAddon(second), qt.IsTrue)
+	c.Assert(takeEvents(), qt.DeepEquals, []string{"done second"})
+
+	c.Assert(testProxy.Close(), qt.IsNil)
+	c.Assert(<-started, qt.ErrorIs, http.ErrServerClosed)
+	c.Assert(takeEvents(), qt.DeepEquals, []string{"done late", "done first"})
+
+	// stopping again does nothing
+	c.Assert(testProxy.Close(), qt.IsNil)
+	c.Assert(takeEvents(), qt.HasLen, 0)
+}
@@ -0,0 +1,91 @@
+// Justification for whitebox testing:
+// systemd passes its sockets from file descriptor 3 on, which a test process cannot
+// hand over to itself. systemdListeners takes the first file descriptor so the tests
+// pass the descriptor of a listener of their own, and serve it through the unexported
+// entries of Proxy.
+
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/cert"
+)
+
+// systemdTestFD returns a listening socket as systemd would pass it, and its address.
+// systemdListeners takes over the descriptor, release closes the file owning it.
+func systemdTestFD(c *qt.C) (fd int, addr string, release func()) {
+	if runtime.GOOS == "windows" {
+		c.Skip("listeners cannot be inherited as files on windows")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	c.Assert(err, qt.IsNil)
+	// closed by systemdListeners already, closing f keeps its finalizer from closing
+	// the descriptor once it is reused
+	return int(f.Fd()), ln.Addr().String(), func() { _ = f.Close() }
+}
+
+func TestSystemdListeners(t *testing.T) {
+	c := qt.New(t)
+	fd, addr, release := systemdTestFD(c)
+	c.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	c.Setenv("LISTEN_FDS", "1")
+	c.Setenv("LISTEN_FDNAMES", "proxy")
+
+	listeners, err := systemdListeners(fd)
+	release()
+	c.Assert(err, qt.IsNil)
+	c.Assert(listeners, qt.HasLen, 1)
+	defer listeners[0].Close()
+	c.Assert(listeners[0].Addr().String(), qt.Equals, addr)
+	// child processes do not inherit the sockets
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_, ok := os.LookupEnv(key)
+		c.Assert(ok, qt.IsFalse, qt.Commentf(key))
+	}
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	p, err := NewProxy(Config{Addr: "127.0.0.1:0"}, ca)
+	c.Assert(err, qt.IsNil)
+	p.entries[0].ln = listeners[0]
+	errCh := make(chan error, 1)
+	go func() { errCh <- p.Start() }()
+	time.Sleep(10 * time.Millisecond) // wait for the proxy startup
+
+	// requests to the proxy itself are answered by the proxy
+	resp, err := http.Get("http://" + addr + "/")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+
+	c.Assert(p.Close(), qt.IsNil)
+	c.Assert(<-errCh, qt.ErrorIs, http.ErrServerClosed)
+}
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	c := qt.New(t)
+	// the variables are meant for another process
+	c.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	c.Setenv("LISTEN_FDS", "1")
+	listeners, err := systemdListeners(systemdListenFdsStart)
+	c.Assert(err, qt.IsNil)
+	c.Assert(listeners, qt.IsNil)
+	c.Assert(os.Getenv("LISTEN_FDS"), qt.Equals, "1")
+
+	c.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	c.Setenv("LISTEN_FDS", "x")
+	_, err = systemdListeners(systemdListenFdsStart)
+	c.Assert(err, qt.ErrorMatches, `invalid LISTEN_FDS "x"`)
+}
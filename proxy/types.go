@@ -1,6 +1,10 @@
 package proxy
 
 import (
+	"net/http"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/internal/resolver"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/conn"
 	"github.com/denisvmedia/go-mitmproxy/proxy/internal/types"
 )
@@ -18,12 +22,30 @@ type (
 	// Response represents an HTTP response in the proxy flow.
 	Response = types.Response
 
+	// Metadata holds values addons attach to a flow to cooperate.
+	Metadata = types.Metadata
+
+	// Timings are the points in time a flow went through.
+	Timings = types.Timings
+
+	// Resolution is the DNS lookup the proxy did to connect upstream for a flow.
+	Resolution = types.Resolution
+
+	// WebSocketMessage is a complete WebSocket data message.
+	WebSocketMessage = types.WebSocketMessage
+
 	// ClientConn represents a client connection.
 	ClientConn = conn.ClientConn
 
 	// ServerConn represents a server connection.
 	ServerConn = conn.ServerConn
 
+	// CertificateInfo summarizes a certificate presented on a connection.
+	CertificateInfo = conn.CertificateInfo
+
+	// TLSInfo summarizes what the TLS handshake of a connection negotiated.
+	TLSInfo = conn.TLSInfo
+
 	// ConnContext represents the connection context.
 	ConnContext = conn.Context
 
@@ -41,9 +63,92 @@ type (
 
 	// DefaultClientFactory is the default implementation of ClientFactory.
 	DefaultClientFactory = types.DefaultClientFactory
+
+	// HTTP3ClientFactory is implemented by client factories that create the HTTP/3 client.
+	HTTP3ClientFactory = types.HTTP3ClientFactory
+
+	// ConnClientFactory is implemented by client factories that create the clients of
+	// upstream TLS connections that are not a *tls.Conn.
+	ConnClientFactory = types.ConnClientFactory
+
+	// ClientPoolStats are statistics of the connections of a client.
+	ClientPoolStats = types.ClientPoolStats
+
+	// ClientCertificate is a client certificate presented to matching upstream servers.
+	ClientCertificate = types.ClientCertificate
+
+	// ClientCertificates selects the client certificate for an upstream server.
+	ClientCertificates = types.ClientCertificates
+
+	// Resolver looks up the IP addresses of the hosts the proxy connects to.
+	Resolver = resolver.Resolver
+
+	// DNSResolver is a Resolver querying DNS servers of choice.
+	DNSResolver = resolver.DNS
+
+	// DoHResolver is a Resolver querying a DNS-over-HTTPS server.
+	DoHResolver = resolver.DoH
+
+	// SpooledBody is a large body kept in a temporary file, see Config.SpoolLargeBodies.
+	SpooledBody = types.SpooledBody
+
+	// ProxyAuth authenticates the CONNECT requests to HTTP upstream proxies.
+	ProxyAuth = helper.ProxyAuth
+
+	// ProxyAuthHandshake is an authentication begun by ProxyAuth, which answers the
+	// challenges of the proxy.
+	ProxyAuthHandshake = helper.ProxyAuthHandshake
 )
 
+// WebSocket message types.
+const (
+	WebSocketText   = types.WebSocketText
+	WebSocketBinary = types.WebSocketBinary
+)
+
+// ErrFlowKilled is the error of the flows killed with Flow.Kill.
+var ErrFlowKilled = types.ErrFlowKilled
+
 // NewDefaultClientFactory creates a new DefaultClientFactory.
 func NewDefaultClientFactory() *DefaultClientFactory {
 	return types.NewDefaultClientFactory()
 }
+
+// LoadClientCertificate loads a client certificate for hosts from a PEM certificate
+// file and a PEM key file.
+func LoadClientCertificate(hosts []string, certFile, keyFile string) (ClientCertificate, error) {
+	return types.LoadClientCertificate(hosts, certFile, keyFile)
+}
+
+// NewDNSResolver creates a Resolver sending its queries to the DNS servers, whose
+// addresses default to port 53. The next server is asked when one fails to answer.
+func NewDNSResolver(servers ...string) *DNSResolver {
+	return resolver.NewDNS(servers...)
+}
+
+// NewDoHResolver creates a Resolver querying the DNS-over-HTTPS server at url, e.g.
+// "https://1.1.1.1/dns-query", with client, or http.DefaultClient when nil.
+func NewDoHResolver(url string, client *http.Client) *DoHResolver {
+	return resolver.NewDoH(url, client)
+}
+
+// NewFlow creates a new Flow, e.g. for flows loaded from a file.
+func NewFlow() *Flow {
+	return types.NewFlow()
+}
+
+// NewResponse creates a response with the given status code, header and body, filling
+// in the Content-Length and Content-Type headers.
+func NewResponse(status int, header http.Header, body []byte) *Response {
+	return types.NewResponse(status, header, body)
+}
+
+// NewJSONResponse creates a 200 response with v marshaled as JSON.
+func NewJSONResponse(v any) (*Response, error) {
+	return types.NewJSONResponse(v)
+}
+
+// NewTextResponse creates a 200 response with the plain text s.
+func NewTextResponse(s string) *Response {
+	return types.NewTextResponse(s)
+}
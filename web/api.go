@@ -0,0 +1,328 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// The REST API serves the finished flows as JSON, for tools that do not speak the
+// websocket protocol of the web interface:
+//
+//	GET    /api/flows                      list the flows, oldest first
+//	DELETE /api/flows                      delete all flows
+//	GET    /api/flows/{id}                 get a flow with its headers
+//	DELETE /api/flows/{id}                 delete a flow
+//	GET    /api/flows/{id}/request/body    get the decoded request body
+//	GET    /api/flows/{id}/response/body   get the decoded response body
+//	POST   /api/flows/{id}/replay          replay a flow and get the new one
+//	GET    /api/har                        download the flows as a HAR file
+//
+// The list and the HAR file are filtered with the query parameters method, host (a pattern like the
+// ones of ignore_hosts), url (a substring of the URL) and status (a status code, or a
+// class like 4xx), and paged with limit (the number of most recent flows) and offset
+// (the number of more recent flows to skip). Errors are returned as {"error": "..."}.
+func (web *WebAddon) handleAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/flows", web.apiListFlows)
+	mux.HandleFunc("DELETE /api/flows", web.apiDeleteFlows)
+	mux.HandleFunc("GET /api/flows/{id}", web.apiGetFlow)
+	mux.HandleFunc("DELETE /api/flows/{id}", web.apiDeleteFlow)
+	mux.HandleFunc("GET /api/flows/{id}/request/body", web.apiGetRequestBody)
+	mux.HandleFunc("GET /api/flows/{id}/response/body", web.apiGetResponseBody)
+	mux.HandleFunc("POST /api/flows/{id}/replay", web.apiReplayFlow)
+	mux.HandleFunc("GET /api/har", web.apiExportHAR)
+}
+
+// apiFlow is a flow in the list.
+type apiFlow struct {
+	ID           string `json:"id"`
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	StatusCode   int    `json:"statusCode,omitempty"`
+	ContentType  string `json:"contentType,omitempty"`
+	RequestSize  int    `json:"requestSize"`
+	ResponseSize int    `json:"responseSize"`
+	IsReplay     bool   `json:"isReplay,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+func newAPIFlow(f *proxy.Flow) apiFlow {
+	af := apiFlow{
+		ID:          f.ID.String(),
+		Method:      f.Request.Method,
+		URL:         f.Request.URL.String(),
+		RequestSize: len(f.Request.Body),
+		IsReplay:    f.IsReplay,
+	}
+	if f.Response != nil {
+		af.StatusCode = f.Response.StatusCode
+		af.ContentType = f.Response.Header.Get("Content-Type")
+		af.ResponseSize = len(f.Response.Body)
+	}
+	if f.Error != nil {
+		af.Error = f.Error.Error()
+	}
+	return af
+}
+
+// apiFilter selects flows of the list.
+type apiFilter struct {
+	method string
+	hosts  []string
+	url    string
+	status int // a code, or a class from 1 to 5
+	limit  int
+	offset int
+}
+
+func parseAPIFilter(query map[string][]string) (*apiFilter, error) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	filter := &apiFilter{
+		method: get("method"),
+		url:    get("url"),
+	}
+	if host := get("host"); host != "" {
+		filter.hosts = []string{host}
+	}
+	if status := get("status"); status != "" {
+		code, err := parseStatus(status)
+		if err != nil {
+			return nil, err
+		}
+		filter.status = code
+	}
+	for key, n := range map[string]*int{"limit": &filter.limit, "offset": &filter.offset} {
+		value := get(key)
+		if value == "" {
+			continue
+		}
+		var err error
+		*n, err = strconv.Atoi(value)
+		if err != nil || *n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", key, value)
+		}
+	}
+	return filter, nil
+}
+
+func (filter *apiFilter) match(f *proxy.Flow) bool {
+	if filter.method != "" && !strings.EqualFold(f.Request.Method, filter.method) {
+		return false
+	}
+	if filter.hosts != nil && !helper.MatchHost(f.Request.URL.Host, filter.hosts) {
+		return false
+	}
+	if filter.url != "" && !strings.Contains(f.Request.URL.String(), filter.url) {
+		return false
+	}
+	if filter.status != 0 && (f.Response == nil || !matchStatus(f.Response.StatusCode, filter.status)) {
+		return false
+	}
+	return true
+}
+
+// parseStatus parses a status code, or a class like 4xx into its digit.
+func parseStatus(status string) (int, error) {
+	class, isClass := strings.CutSuffix(strings.ToLower(status), "xx")
+	code, err := strconv.Atoi(class)
+	if err != nil || (isClass && (code < 1 || code > 5)) || (!isClass && (code < 100 || code > 999)) {
+		return 0, fmt.Errorf("invalid status %q", status)
+	}
+	return code, nil
+}
+
+// matchStatus reports whether code matches a status of parseStatus, zero matching any.
+func matchStatus(code, status int) bool {
+	if status < 10 {
+		if status == 0 {
+			return true
+		}
+		code /= 100
+	}
+	return code == status
+}
+
+func (web *WebAddon) apiListFlows(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAPIFilter(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	flows, err := web.filterFlows(filter)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list := make([]apiFlow, 0, len(flows))
+	for _, f := range flows {
+		list = append(list, newAPIFlow(f))
+	}
+	writeAPIJSON(w, http.StatusOK, list)
+}
+
+// filterFlows returns the stored flows selected by filter, oldest first.
+func (web *WebAddon) filterFlows(filter *apiFilter) ([]*proxy.Flow, error) {
+	flows := make([]*proxy.Flow, 0)
+	skip := filter.offset
+	err := web.flows.Range(func(f *proxy.Flow) bool {
+		if !filter.match(f) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		flows = append(flows, f)
+		return filter.limit == 0 || len(flows) < filter.limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(flows)
+	return flows, nil
+}
+
+func (web *WebAddon) apiDeleteFlows(w http.ResponseWriter, _ *http.Request) {
+	if err := web.flows.Clear(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiFlowOf returns the flow of the id in the path, or writes an error.
+func (web *WebAddon) apiFlowOf(w http.ResponseWriter, r *http.Request) (*proxy.Flow, bool) {
+	id, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid flow id %q", r.PathValue("id")))
+		return nil, false
+	}
+	f, err := web.flows.Get(id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if f == nil {
+		writeAPIError(w, http.StatusNotFound, errors.New("flow not found"))
+		return nil, false
+	}
+	return f, true
+}
+
+func (web *WebAddon) apiGetFlow(w http.ResponseWriter, r *http.Request) {
+	f, ok := web.apiFlowOf(w, r)
+	if !ok {
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, f)
+}
+
+func (web *WebAddon) apiDeleteFlow(w http.ResponseWriter, r *http.Request) {
+	f, ok := web.apiFlowOf(w, r)
+	if !ok {
+		return
+	}
+	if _, err := web.flows.Delete(f.ID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (web *WebAddon) apiGetRequestBody(w http.ResponseWriter, r *http.Request) {
+	f, ok := web.apiFlowOf(w, r)
+	if !ok {
+		return
+	}
+	body, err := f.Request.DecodedBody()
+	if err != nil {
+		body = f.Request.Body
+	}
+	writeAPIBody(w, f.Request.Header.Get("Content-Type"), body)
+}
+
+func (web *WebAddon) apiGetResponseBody(w http.ResponseWriter, r *http.Request) {
+	f, ok := web.apiFlowOf(w, r)
+	if !ok {
+		return
+	}
+	if f.Response == nil {
+		writeAPIError(w, http.StatusNotFound, errors.New("flow without response"))
+		return
+	}
+	body, err := f.Response.DecodedBody()
+	if err != nil {
+		body = f.Response.Body
+	}
+	writeAPIBody(w, f.Response.Header.Get("Content-Type"), body)
+}
+
+func (web *WebAddon) apiReplayFlow(w http.ResponseWriter, r *http.Request) {
+	f, ok := web.apiFlowOf(w, r)
+	if !ok {
+		return
+	}
+	p := web.running.Load()
+	if p == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, errors.New("proxy not running"))
+		return
+	}
+	replayed, err := p.ReplayFlow(r.Context(), f)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err)
+		return
+	}
+	// finished flows are stored in the background, maybe only after the reply
+	if err := web.flows.Add(truncateFlow(replayed, web.maxBodySize)); err != nil {
+		slog.Warn("web store flow failed", "error", err)
+	}
+	writeAPIJSON(w, http.StatusOK, replayed)
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Debug("web api write failed", "error", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Debug("web api write failed", "error", err)
+	}
+}
+
+func writeAPIBody(w http.ResponseWriter, contentType string, body []byte) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		slog.Debug("web api write failed", "error", err)
+	}
+}
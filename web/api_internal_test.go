@@ -0,0 +1,237 @@
+// This file contains tests for the REST API of the web addon.
+//
+// Justification:
+// - the API is served by the unexported server of WebAddon, whose listening address
+//   is only known inside the package
+// - flows are added to the unexported flow store as the proxy would once they finish
+// - replays asked for over the websocket go through the unexported replay
+//
+// The handlers are exercised through the server's mux with httptest.
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/cert"
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+func newAPITestFlow(c *qt.C, method, rawURL string, status int, body string) *proxy.Flow {
+	u, err := url.Parse(rawURL)
+	c.Assert(err, qt.IsNil)
+	f := proxy.NewFlow()
+	f.Request = &proxy.Request{
+		Method: method,
+		URL:    u,
+		Proto:  "HTTP/1.1",
+		Header: http.Header{"Content-Type": {"text/plain"}},
+		Body:   []byte("request " + body),
+	}
+	if status != 0 {
+		f.Response = &proxy.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       []byte(body),
+		}
+	}
+	return f
+}
+
+func apiRequest(c *qt.C, web *WebAddon, method, target string) *httptest.ResponseRecorder {
+	c.Helper()
+	rec := httptest.NewRecorder()
+	web.server.Handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func apiListIDs(c *qt.C, web *WebAddon, query string) []string {
+	c.Helper()
+	rec := apiRequest(c, web, "GET", "/api/flows"+query)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	var flows []apiFlow
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &flows), qt.IsNil)
+	ids := make([]string, len(flows))
+	for i, f := range flows {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+func TestAPIListAndFilterFlows(t *testing.T) {
+	c := qt.New(t)
+	web := NewWebAddon("127.0.0.1:0")
+	get := newAPITestFlow(c, "GET", "https://api.example.com/items?page=1", 200, `{"a":1}`)
+	post := newAPITestFlow(c, "POST", "https://api.example.com/items", 201, `{}`)
+	missing := newAPITestFlow(c, "GET", "http://cdn.example.org/missing.png", 404, "")
+	failed := newAPITestFlow(c, "GET", "http://down.example.org/", 0, "")
+	failed.Error = errors.New("connection refused")
+	for _, f := range []*proxy.Flow{get, post, missing, failed} {
+		c.Assert(web.flows.Add(f), qt.IsNil)
+	}
+
+	rec := apiRequest(c, web, "GET", "/api/flows")
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "application/json")
+	var flows []apiFlow
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &flows), qt.IsNil)
+	c.Assert(flows, qt.DeepEquals, []apiFlow{
+		{ID: get.ID.String(), Method: "GET", URL: "https://api.example.com/items?page=1", StatusCode: 200, ContentType: "application/json", RequestSize: 15, ResponseSize: 7},
+		{ID: post.ID.String(), Method: "POST", URL: "https://api.example.com/items", StatusCode: 201, ContentType: "application/json", RequestSize: 10, ResponseSize: 2},
+		{ID: missing.ID.String(), Method: "GET", URL: "http://cdn.example.org/missing.png", StatusCode: 404, ContentType: "application/json", RequestSize: 8},
+		{ID: failed.ID.String(), Method: "GET", URL: "http://down.example.org/", RequestSize: 8, Error: "connection refused"},
+	})
+
+	tests := []struct {
+		query string
+		want  []*proxy.Flow
+	}{
+		{query: "?method=post", want: []*proxy.Flow{post}},
+		{query: "?host=*.example.org", want: []*proxy.Flow{missing, failed}},
+		{query: "?url=page=1", want: []*proxy.Flow{get}},
+		{query: "?status=404", want: []*proxy.Flow{missing}},
+		{query: "?status=2xx", want: []*proxy.Flow{get, post}},
+		{query: "?method=GET&limit=2", want: []*proxy.Flow{missing, failed}},
+		{query: "?limit=2&offset=1", want: []*proxy.Flow{post, missing}},
+		{query: "?offset=4", want: []*proxy.Flow{}},
+		{query: "?status=600x", want: nil},
+	}
+	for _, tt := range tests {
+		c.Run(tt.query, func(c *qt.C) {
+			if tt.want == nil {
+				rec := apiRequest(c, web, "GET", "/api/flows"+tt.query)
+				c.Assert(rec.Code, qt.Equals, http.StatusBadRequest)
+				c.Assert(rec.Body.String(), qt.Equals, `{"error":"invalid status \"600x\""}`)
+				return
+			}
+			want := make([]string, len(tt.want))
+			for i, f := range tt.want {
+				want[i] = f.ID.String()
+			}
+			c.Assert(apiListIDs(c, web, tt.query), qt.DeepEquals, want)
+		})
+	}
+}
+
+func TestAPIGetAndDeleteFlows(t *testing.T) {
+	c := qt.New(t)
+	web := NewWebAddon("127.0.0.1:0")
+	f := newAPITestFlow(c, "POST", "https://api.example.com/items", 200, `{"ok":true}`)
+	other := newAPITestFlow(c, "GET", "https://api.example.com/", 0, "")
+	c.Assert(web.flows.Add(f), qt.IsNil)
+	c.Assert(web.flows.Add(other), qt.IsNil)
+	path := "/api/flows/" + f.ID.String()
+
+	rec := apiRequest(c, web, "GET", path)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	var detail map[string]any
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &detail), qt.IsNil)
+	c.Assert(detail["id"], qt.Equals, f.ID.String())
+	c.Assert(detail["request"].(map[string]any)["method"], qt.Equals, "POST")
+	c.Assert(detail["response"].(map[string]any)["statusCode"], qt.Equals, 200.0)
+
+	rec = apiRequest(c, web, "GET", path+"/request/body")
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "text/plain")
+	c.Assert(rec.Body.String(), qt.Equals, `request {"ok":true}`)
+	rec = apiRequest(c, web, "GET", path+"/response/body")
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(rec.Body.String(), qt.Equals, `{"ok":true}`)
+	rec = apiRequest(c, web, "GET", "/api/flows/"+other.ID.String()+"/response/body")
+	c.Assert(rec.Code, qt.Equals, http.StatusNotFound)
+
+	c.Assert(apiRequest(c, web, "GET", "/api/flows/nope").Code, qt.Equals, http.StatusBadRequest)
+	c.Assert(apiRequest(c, web, "DELETE", path).Code, qt.Equals, http.StatusNoContent)
+	c.Assert(apiRequest(c, web, "GET", path).Code, qt.Equals, http.StatusNotFound)
+	c.Assert(apiListIDs(c, web, ""), qt.DeepEquals, []string{other.ID.String()})
+
+	c.Assert(apiRequest(c, web, "DELETE", "/api/flows").Code, qt.Equals, http.StatusNoContent)
+	c.Assert(apiListIDs(c, web, ""), qt.DeepEquals, []string{})
+}
+
+func TestAPIReplayFlow(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte("replayed " + string(body)))
+	}))
+	defer upstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	p, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:0"}, ca)
+	c.Assert(err, qt.IsNil)
+	web := NewWebAddon("127.0.0.1:0")
+	p.AddAddon(web)
+	f := newAPITestFlow(c, "POST", upstream.URL+"/", 200, "body")
+	c.Assert(web.flows.Add(f), qt.IsNil)
+	path := "/api/flows/" + f.ID.String() + "/replay"
+
+	rec := apiRequest(c, web, "POST", path)
+	c.Assert(rec.Code, qt.Equals, http.StatusServiceUnavailable)
+
+	web.Running(p)
+	rec = apiRequest(c, web, "POST", path)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	var replayed map[string]any
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &replayed), qt.IsNil)
+	c.Assert(replayed["isReplay"], qt.Equals, true)
+	c.Assert(replayed["response"].(map[string]any)["statusCode"], qt.Equals, 200.0)
+
+	// the replay went through the addons and is stored too
+	id := replayed["id"].(string)
+	c.Assert(apiListIDs(c, web, ""), qt.DeepEquals, []string{f.ID.String(), id})
+	rec = apiRequest(c, web, "GET", "/api/flows/"+id+"/response/body")
+	c.Assert(rec.Body.String(), qt.Equals, "replayed request body")
+
+	f.Request.URL, _ = url.Parse("http://127.0.0.1:1/")
+	rec = apiRequest(c, web, "POST", path)
+	c.Assert(rec.Code, qt.Equals, http.StatusBadGateway)
+	c.Assert(strings.Contains(rec.Body.String(), "replay failed"), qt.IsTrue)
+}
+
+func TestWebsocketReplayFlow(t *testing.T) {
+	c := qt.New(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("replayed " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	p, err := proxy.NewProxy(proxy.Config{Addr: "127.0.0.1:0"}, ca)
+	c.Assert(err, qt.IsNil)
+	web := NewWebAddon("127.0.0.1:0")
+	p.AddAddon(web)
+	web.Running(p)
+	f := newAPITestFlow(c, "GET", upstream.URL+"/again", 200, "body")
+	c.Assert(web.flows.Add(f), qt.IsNil)
+
+	web.replay(f.ID)
+
+	// the replayed flow reaches the addon, which stores it once it is finished
+	var replayed *proxy.Flow
+	deadline := time.Now().Add(5 * time.Second)
+	for replayed == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		c.Assert(web.flows.Range(func(stored *proxy.Flow) bool {
+			if stored.IsReplay {
+				replayed = stored
+			}
+			return false
+		}), qt.IsNil)
+	}
+	c.Assert(replayed, qt.IsNotNil)
+	c.Assert(replayed.ID, qt.Not(qt.Equals), f.ID)
+	c.Assert(string(replayed.Response.Body), qt.Equals, "replayed /again")
+}
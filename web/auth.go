@@ -0,0 +1,78 @@
+package web
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// tokenCookie keeps the token of a browser that opened the web interface with
+// ?token=, so its websocket and API requests are authenticated too.
+const tokenCookie = "mitmproxy_web_token"
+
+// authHandler requires the basic authentication or the token of the options before
+// serving requests with next.
+type authHandler struct {
+	username string
+	password string
+	token    string
+	secure   bool // the interface is served over TLS, so the cookie is only sent over it
+	next     http.Handler
+}
+
+func (opts *Options) authRequired() bool {
+	return opts.Username != "" || opts.Token != ""
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" {
+		if token := r.URL.Query().Get("token"); token != "" && secureEqual(token, h.token) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   h.secure,
+				SameSite: http.SameSiteStrictMode,
+			})
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(bearer, h.token) {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(tokenCookie); err == nil && secureEqual(cookie.Value, h.token) {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+	}
+	if h.username != "" {
+		if username, password, ok := r.BasicAuth(); ok && secureEqual(username, h.username) && secureEqual(password, h.password) {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="go-mitmproxy", charset="UTF-8"`)
+	}
+	slog.Debug("web interface request unauthorized", "remote", r.RemoteAddr, "path", r.URL.Path)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// isLoopbackAddr reports whether a listen addr only accepts local connections.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
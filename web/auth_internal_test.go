@@ -0,0 +1,99 @@
+// This file contains tests for the authentication of the web interface.
+//
+// Justification:
+// - requests are served by the unexported server of WebAddon, whose listening address
+//   is only known inside the package
+// - isLoopbackAddr is unexported
+//
+// The handlers are exercised through the server's handler with httptest.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAuthentication(t *testing.T) {
+	c := qt.New(t)
+	web := NewWebAddonWithOptions(Options{Addr: "127.0.0.1:0", Username: "user", Password: "secret", Token: "t0ken"})
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		web.server.Handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+		want   int
+	}{
+		{name: "none", modify: func(*http.Request) {}, want: http.StatusUnauthorized},
+		{name: "basic", modify: func(r *http.Request) { r.SetBasicAuth("user", "secret") }, want: http.StatusOK},
+		{name: "wrong password", modify: func(r *http.Request) { r.SetBasicAuth("user", "nope") }, want: http.StatusUnauthorized},
+		{name: "bearer", modify: func(r *http.Request) { r.Header.Set("Authorization", "Bearer t0ken") }, want: http.StatusOK},
+		{name: "wrong bearer", modify: func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, want: http.StatusUnauthorized},
+		{name: "cookie", modify: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: tokenCookie, Value: "t0ken"}) }, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			r := httptest.NewRequest("GET", "/api/flows", nil)
+			tt.modify(r)
+			rec := serve(r)
+			c.Assert(rec.Code, qt.Equals, tt.want)
+			if tt.want == http.StatusUnauthorized {
+				c.Assert(rec.Header().Get("WWW-Authenticate"), qt.Equals, `Basic realm="go-mitmproxy", charset="UTF-8"`)
+			}
+		})
+	}
+
+	// the token in the URL is kept in a cookie for the following requests
+	rec := serve(httptest.NewRequest("GET", "/api/flows?token=t0ken", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	cookies := rec.Result().Cookies()
+	c.Assert(cookies, qt.HasLen, 1)
+	c.Assert(cookies[0].Name, qt.Equals, tokenCookie)
+	c.Assert(cookies[0].Value, qt.Equals, "t0ken")
+	c.Assert(cookies[0].HttpOnly, qt.IsTrue)
+	c.Assert(cookies[0].Secure, qt.IsFalse)
+	c.Assert(serve(httptest.NewRequest("GET", "/api/flows?token=nope", nil)).Code, qt.Equals, http.StatusUnauthorized)
+}
+
+func TestTokenCookieSecureOverTLS(t *testing.T) {
+	c := qt.New(t)
+	h := &authHandler{token: "t0ken", secure: true, next: http.NotFoundHandler()}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?token=t0ken", nil))
+	cookies := rec.Result().Cookies()
+	c.Assert(cookies, qt.HasLen, 1)
+	c.Assert(cookies[0].Secure, qt.IsTrue)
+}
+
+func TestWithoutAuthentication(t *testing.T) {
+	c := qt.New(t)
+	web := NewWebAddonWithOptions(Options{Addr: "127.0.0.1:0"})
+	rec := httptest.NewRecorder()
+	web.server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/flows", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:9081": true,
+		"[::1]:9081":     true,
+		"localhost:9081": true,
+		":9081":          false,
+		"0.0.0.0:9081":   false,
+		"10.0.0.1:9081":  false,
+		"example.com:80": false,
+		"9081":           false,
+	}
+	for addr, want := range tests {
+		t.Run(addr, func(t *testing.T) {
+			qt.Assert(t, isLoopbackAddr(addr), qt.Equals, want)
+		})
+	}
+}
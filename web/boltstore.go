@@ -0,0 +1,279 @@
+package web
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// maxBoltStoredFlows bounds how many flows a bbolt store keeps by default.
+const maxBoltStoredFlows = 100000
+
+var (
+	boltFlowsBucket = []byte("flows") // sequence -> storedFlow as JSON
+	boltIDsBucket   = []byte("ids")   // flow ID -> sequence
+	boltMetaBucket  = []byte("meta")
+	boltCountKey    = []byte("count")
+)
+
+// boltFlowStore keeps the flows in a bbolt database, so they survive restarts.
+type boltFlowStore struct {
+	db    *bolt.DB
+	limit uint64
+}
+
+// NewBoltFlowStore creates a FlowStore keeping the last limit flows in the bbolt
+// database at filename, which is created if needed. Zero means 100000 flows. The
+// database is locked until the store is closed.
+func NewBoltFlowStore(filename string, limit int) (FlowStore, error) {
+	if limit <= 0 {
+		limit = maxBoltStoredFlows
+	}
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltFlowsBucket, boltIDsBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltFlowStore{db: db, limit: uint64(limit)}, nil
+}
+
+func (s *boltFlowStore) Add(f *proxy.Flow) error {
+	value, err := json.Marshal(newStoredFlow(f))
+	if err != nil {
+		return err
+	}
+	id := f.ID.Bytes()
+	// Batch commits flows finishing together at once
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		flows, ids, meta := tx.Bucket(boltFlowsBucket), tx.Bucket(boltIDsBucket), tx.Bucket(boltMetaBucket)
+		if ids.Get(id) != nil {
+			return nil
+		}
+		seq, err := flows.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(nil, seq)
+		if err := flows.Put(key, value); err != nil {
+			return err
+		}
+		if err := ids.Put(id, key); err != nil {
+			return err
+		}
+		count := boltCount(meta) + 1
+		// drop the oldest flows, the keys are ordered by sequence
+		c := flows.Cursor()
+		for k, v := c.First(); k != nil && count > s.limit; k, v = c.First() {
+			var oldest storedFlow
+			if err := json.Unmarshal(v, &oldest); err == nil {
+				if oldestID, err := uuid.FromString(oldest.ID); err == nil {
+					if err := ids.Delete(oldestID.Bytes()); err != nil {
+						return err
+					}
+				}
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			count--
+		}
+		return meta.Put(boltCountKey, binary.BigEndian.AppendUint64(nil, count))
+	})
+}
+
+func boltCount(meta *bolt.Bucket) uint64 {
+	if v := meta.Get(boltCountKey); len(v) == 8 {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (s *boltFlowStore) Get(id uuid.UUID) (*proxy.Flow, error) {
+	var f *proxy.Flow
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(boltIDsBucket).Get(id.Bytes())
+		if key == nil {
+			return nil
+		}
+		var err error
+		f, err = decodeStoredFlow(tx.Bucket(boltFlowsBucket).Get(key))
+		return err
+	})
+	return f, err
+}
+
+func (s *boltFlowStore) Range(fn func(f *proxy.Flow) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltFlowsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			f, err := decodeStoredFlow(v)
+			if err != nil {
+				return err
+			}
+			if !fn(f) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltFlowStore) Delete(id uuid.UUID) (bool, error) {
+	deleted := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(boltIDsBucket)
+		key := ids.Get(id.Bytes())
+		if key == nil {
+			return nil
+		}
+		deleted = true
+		if err := tx.Bucket(boltFlowsBucket).Delete(key); err != nil {
+			return err
+		}
+		if err := ids.Delete(id.Bytes()); err != nil {
+			return err
+		}
+		meta := tx.Bucket(boltMetaBucket)
+		return meta.Put(boltCountKey, binary.BigEndian.AppendUint64(nil, boltCount(meta)-1))
+	})
+	return deleted, err
+}
+
+func (s *boltFlowStore) Clear() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltFlowsBucket, boltIDsBucket, boltMetaBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltFlowStore) Close() error {
+	return s.db.Close()
+}
+
+// storedFlow is a flow as it is stored, with its bodies but without its connection.
+type storedFlow struct {
+	ID       string          `json:"id"`
+	Request  storedRequest   `json:"request"`
+	Response *storedResponse `json:"response,omitempty"`
+	IsReplay bool            `json:"isReplay,omitempty"`
+	TraceID  string          `json:"traceId,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Timings  *proxy.Timings  `json:"timings,omitempty"`
+}
+
+type storedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Proto   string      `json:"proto"`
+	Header  http.Header `json:"header"`
+	Trailer http.Header `json:"trailer,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+type storedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Trailer    http.Header `json:"trailer,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+func newStoredFlow(f *proxy.Flow) *storedFlow {
+	sf := &storedFlow{
+		ID: f.ID.String(),
+		Request: storedRequest{
+			Method:  f.Request.Method,
+			URL:     f.Request.URL.String(),
+			Proto:   f.Request.Proto,
+			Header:  f.Request.Header,
+			Trailer: f.Request.Trailer,
+			Body:    f.Request.Body,
+		},
+		IsReplay: f.IsReplay,
+		TraceID:  f.TraceID,
+	}
+	if f.Response != nil {
+		sf.Response = &storedResponse{
+			StatusCode: f.Response.StatusCode,
+			Header:     f.Response.Header,
+			Trailer:    f.Response.Trailer,
+			Body:       f.Response.Body,
+		}
+	}
+	if f.Error != nil {
+		sf.Error = f.Error.Error()
+	}
+	if timings := f.Timings(); timings != (proxy.Timings{}) {
+		sf.Timings = &timings
+	}
+	return sf
+}
+
+// decodeStoredFlow decodes a stored flow into a finished flow.
+func decodeStoredFlow(data []byte) (*proxy.Flow, error) {
+	var sf storedFlow
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, err
+	}
+	id, err := uuid.FromString(sf.ID)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(sf.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	f := proxy.NewFlow()
+	f.ID = id
+	f.Request = &proxy.Request{
+		Method:  sf.Request.Method,
+		URL:     u,
+		Proto:   sf.Request.Proto,
+		Header:  sf.Request.Header,
+		Trailer: sf.Request.Trailer,
+		Body:    sf.Request.Body,
+	}
+	if sf.Response != nil {
+		f.Response = &proxy.Response{
+			StatusCode: sf.Response.StatusCode,
+			Header:     sf.Response.Header,
+			Trailer:    sf.Response.Trailer,
+			Body:       sf.Response.Body,
+		}
+	}
+	f.IsReplay = sf.IsReplay
+	f.TraceID = sf.TraceID
+	if sf.Error != "" {
+		f.Error = errors.New(sf.Error)
+	}
+	if sf.Timings != nil {
+		f.UpdateTimings(func(t *proxy.Timings) { *t = *sf.Timings })
+	}
+	f.Finish()
+	return f, nil
+}
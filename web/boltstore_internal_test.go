@@ -0,0 +1,63 @@
+// This file contains tests for the bbolt flow store.
+//
+// Justification:
+// - the store is checked through the unexported flow store of WebAddon and the REST
+//   API, as the web interface uses it
+//
+// The tests reuse the flow helpers of api_internal_test.go.
+
+package web
+
+import (
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+func TestBoltFlowStoreSurvivesRestart(t *testing.T) {
+	c := qt.New(t)
+	filename := filepath.Join(c.TempDir(), "flows.db")
+
+	store, err := NewBoltFlowStore(filename, 3)
+	c.Assert(err, qt.IsNil)
+	web := NewWebAddonWithStore("127.0.0.1:0", store)
+	var flows []*proxy.Flow
+	for i, status := range []int{200, 201, 404, 0} {
+		f := newAPITestFlow(c, "GET", "https://api.example.com/"+string(rune('a'+i)), status, "body")
+		flows = append(flows, f)
+		c.Assert(web.flows.Add(f), qt.IsNil)
+		c.Assert(web.flows.Add(f), qt.IsNil) // a stored flow is not added twice
+	}
+	// the oldest flow was dropped for the limit
+	want := []string{flows[1].ID.String(), flows[2].ID.String(), flows[3].ID.String()}
+	c.Assert(apiListIDs(c, web, ""), qt.DeepEquals, want)
+	c.Assert(web.flows.Close(), qt.IsNil)
+
+	store, err = NewBoltFlowStore(filename, 3)
+	c.Assert(err, qt.IsNil)
+	web = NewWebAddonWithStore("127.0.0.1:0", store)
+	defer web.Done()
+	c.Assert(apiListIDs(c, web, ""), qt.DeepEquals, want)
+	c.Assert(apiListIDs(c, web, "?limit=1&offset=1"), qt.DeepEquals, want[1:2])
+
+	f, err := web.flows.Get(flows[2].ID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(f.Request.URL.String(), qt.Equals, "https://api.example.com/c")
+	c.Assert(f.Request.Header.Get("Content-Type"), qt.Equals, "text/plain")
+	c.Assert(string(f.Response.Body), qt.Equals, "body")
+	c.Assert(f.Response.StatusCode, qt.Equals, 404)
+	f, err = web.flows.Get(flows[0].ID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(f, qt.IsNil)
+
+	deleted, err := web.flows.Delete(flows[1].ID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(deleted, qt.IsTrue)
+	c.Assert(web.flows.Add(flows[0]), qt.IsNil)
+	c.Assert(apiListIDs(c, web, ""), qt.DeepEquals, []string{flows[2].ID.String(), flows[3].ID.String(), flows[0].ID.String()})
+	c.Assert(web.flows.Clear(), qt.IsNil)
+	c.Assert(apiListIDs(c, web, ""), qt.DeepEquals, []string{})
+}
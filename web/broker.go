@@ -0,0 +1,286 @@
+package web
+
+import (
+	"log/slog"
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// broker shares the state of the web interface between its websocket connections, so
+// all browser tabs see the same flows: the breakpoint rules set by one connection
+// apply to all of them, and a flow stopped at a breakpoint is shown to every
+// connection and resumed by the first edit of any of them.
+type broker struct {
+	conns   []*concurrentConn
+	connsMu sync.RWMutex
+
+	breakPointRules []*breakPointRule
+	rulesMsg        *messageMeta // the rules as last set, sent to new connections
+	rulesMu         sync.RWMutex
+
+	openConns   map[uuid.UUID]*proxy.Flow      // connection ID -> a flow of it, the connections sent
+	intercepted map[uuid.UUID]*interceptedFlow // flow ID -> the flow waiting for an edit
+	mu          sync.Mutex
+}
+
+// interceptedFlow is a flow stopped at a breakpoint until an edit arrives.
+type interceptedFlow struct {
+	flow *proxy.Flow
+	msg  *messageFlow // the body message waiting for the edit
+	edit chan *messageEdit
+}
+
+func newBroker() *broker {
+	return &broker{
+		conns:       make([]*concurrentConn, 0),
+		openConns:   make(map[uuid.UUID]*proxy.Flow),
+		intercepted: make(map[uuid.UUID]*interceptedFlow),
+	}
+}
+
+// addConn sends the shared state to a new connection, then the messages of the flows.
+func (b *broker) addConn(c *concurrentConn) {
+	b.rulesMu.RLock()
+	rulesMsg := b.rulesMsg
+	b.rulesMu.RUnlock()
+	if rulesMsg != nil {
+		c.writeBytes(rulesMsg.toBytes())
+	}
+
+	b.mu.Lock()
+	for _, f := range b.openConns {
+		msg, err := newMessageFlow(messageTypeConn, f)
+		if err != nil {
+			continue
+		}
+		c.writeMessage(msg)
+	}
+	for _, w := range b.intercepted {
+		for t := messageTypeRequest; t < w.msg.mType; t++ {
+			msg, err := newMessageFlow(t, w.flow)
+			if err != nil {
+				continue
+			}
+			c.writeMessage(msg)
+		}
+		c.writeInterceptMessage(w.msg, w.flow)
+	}
+	// under mu, so no connection or intercepted flow is missed
+	b.connsMu.Lock()
+	b.conns = append(b.conns, c)
+	b.connsMu.Unlock()
+	b.mu.Unlock()
+}
+
+func (b *broker) removeConn(conn *concurrentConn) {
+	b.connsMu.Lock()
+	defer b.connsMu.Unlock()
+
+	index := -1
+	for i, c := range b.conns {
+		if conn == c {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return
+	}
+	b.conns = append(b.conns[:index], b.conns[index+1:]...)
+}
+
+func (b *broker) connCount() int {
+	b.connsMu.RLock()
+	defer b.connsMu.RUnlock()
+	return len(b.conns)
+}
+
+func (b *broker) forEachConn(do func(c *concurrentConn)) {
+	b.connsMu.RLock()
+	conns := b.conns
+	b.connsMu.RUnlock()
+	for _, c := range conns {
+		do(c)
+	}
+}
+
+// setBreakPointRules replaces the rules of all connections with the ones set by from,
+// and sends them to the other connections.
+func (b *broker) setBreakPointRules(msg *messageMeta, from *concurrentConn) {
+	b.rulesMu.Lock()
+	b.breakPointRules = msg.breakPointRules
+	b.rulesMsg = msg
+	b.rulesMu.Unlock()
+
+	data := msg.toBytes()
+	b.forEachConn(func(c *concurrentConn) {
+		if c != from {
+			c.writeBytes(data)
+		}
+	})
+}
+
+// Check whether to intercept.
+func (b *broker) isIntercpt(f *proxy.Flow, mType messageType) bool {
+	if mType != messageTypeRequestBody && mType != messageTypeResponseBody {
+		return false
+	}
+
+	b.rulesMu.RLock()
+	rules := b.breakPointRules
+	b.rulesMu.RUnlock()
+	if len(rules) == 0 {
+		return false
+	}
+
+	var action int
+	if mType == messageTypeRequestBody {
+		action = 1
+	} else {
+		action = 2
+	}
+
+	for _, rule := range rules {
+		if !rule.hasCondition() {
+			continue
+		}
+		if action&rule.Action == 0 {
+			continue
+		}
+		if rule.match(f, mType == messageTypeResponseBody) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trySendConnMessage sends the connection of f once.
+func (b *broker) trySendConnMessage(f *proxy.Flow) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := f.ConnContext.ID()
+	if _, ok := b.openConns[id]; ok {
+		return
+	}
+	b.openConns[id] = f
+	msg, err := newMessageFlow(messageTypeConn, f)
+	if err != nil {
+		slog.Error("web addon gen msg failed", "error", err)
+		return
+	}
+	b.forEachConn(func(c *concurrentConn) {
+		c.writeMessage(msg)
+	})
+}
+
+func (b *broker) whenConnClose(connCtx *proxy.ConnContext) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.openConns, connCtx.ID())
+	msg := newMessageConnClose(connCtx)
+	b.forEachConn(func(c *concurrentConn) {
+		c.writeMessage(msg)
+	})
+}
+
+func (b *broker) sendFlow(f *proxy.Flow, msgFn func() (*messageFlow, error)) {
+	if b.connCount() == 0 {
+		return
+	}
+
+	msg, err := msgFn()
+	if err != nil {
+		slog.Error("web addon gen msg failed", "error", err)
+		return
+	}
+	b.forEachConn(func(c *concurrentConn) {
+		c.writeFlowMessage(msg, f)
+	})
+}
+
+// sendFlowMayWait sends a message of f, and waits for its edit when f is stopped at a
+// breakpoint.
+func (b *broker) sendFlowMayWait(f *proxy.Flow, msgFn func() (*messageFlow, error)) {
+	if b.connCount() == 0 {
+		return
+	}
+
+	msg, err := msgFn()
+	if err != nil {
+		slog.Error("web addon gen msg failed", "error", err)
+		return
+	}
+	if !b.isIntercpt(f, msg.mType) {
+		b.forEachConn(func(c *concurrentConn) {
+			c.writeFlowMessage(msg, f)
+		})
+		return
+	}
+
+	msg.waitIntercept = 1
+	w := &interceptedFlow{flow: f, msg: msg, edit: make(chan *messageEdit, 1)}
+	b.mu.Lock()
+	b.intercepted[f.ID] = w
+	b.forEachConn(func(c *concurrentConn) {
+		c.writeInterceptMessage(msg, f)
+	})
+	b.mu.Unlock()
+
+	applyEdit(f, <-w.edit)
+
+	// show the edit to all connections, which stop waiting for it
+	for _, mType := range []messageType{msg.mType - 1, msg.mType} {
+		resumed, err := newMessageFlow(mType, f)
+		if err != nil {
+			continue
+		}
+		b.forEachConn(func(c *concurrentConn) {
+			c.writeFlowMessage(resumed, f)
+		})
+	}
+}
+
+// resume resumes the flow stopped at a breakpoint with an edit. Only the first edit
+// of the flow counts when several connections send one.
+func (b *broker) resume(msg *messageEdit) {
+	b.mu.Lock()
+	w, ok := b.intercepted[msg.id]
+	delete(b.intercepted, msg.id)
+	b.mu.Unlock()
+	if !ok {
+		slog.Warn("edit of a flow not stopped at a breakpoint, skip", "id", msg.id)
+		return
+	}
+	w.edit <- msg
+}
+
+// applyEdit changes or drops f as edited in the web interface.
+func applyEdit(f *proxy.Flow, msg *messageEdit) {
+	// drop
+	if msg.mType == messageTypeDropRequest || msg.mType == messageTypeDropResponse {
+		f.Response = &proxy.Response{
+			StatusCode: 502,
+		}
+		return
+	}
+
+	// change
+	switch msg.mType {
+	case messageTypeChangeRequest:
+		f.Request.Method = msg.request.Method
+		f.Request.URL = msg.request.URL
+		f.Request.Header = msg.request.Header
+		f.Request.Body = msg.request.Body
+	case messageTypeChangeResponse:
+		f.Response.StatusCode = msg.response.StatusCode
+		f.Response.Header = msg.response.Header
+		f.Response.Body = msg.response.Body
+	}
+}
@@ -0,0 +1,106 @@
+// This file contains tests for the state the web addon shares between its websocket
+// connections.
+//
+// Justification:
+// - the broker and the websocket messages are unexported
+//
+// The connections are real websockets to the handler of the addon, served by httptest.
+
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/websocket"
+)
+
+func dialBrokerTest(c *qt.C, web *WebAddon, server *httptest.Server) *websocket.Conn {
+	c.Helper()
+	conns := web.broker.connCount()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/echo", nil)
+	c.Assert(err, qt.IsNil)
+	c.Cleanup(func() { client.Close() })
+	deadline := time.Now().Add(5 * time.Second)
+	for web.broker.connCount() == conns && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(web.broker.connCount(), qt.Equals, conns+1)
+	return client
+}
+
+func readBrokerTest(c *qt.C, client *websocket.Conn) []byte {
+	c.Helper()
+	c.Assert(client.SetReadDeadline(time.Now().Add(5*time.Second)), qt.IsNil)
+	_, data, err := client.ReadMessage()
+	c.Assert(err, qt.IsNil)
+	return data
+}
+
+func TestBrokerSharesBreakPoints(t *testing.T) {
+	c := qt.New(t)
+	web := NewWebAddon("127.0.0.1:0")
+	server := httptest.NewServer(web.server.Handler)
+	defer server.Close()
+	first := dialBrokerTest(c, web, server)
+	second := dialBrokerTest(c, web, server)
+
+	rules := []byte(`[{"method":"POST","url":"/intercepted","action":1}]`)
+	c.Assert(first.WriteMessage(websocket.BinaryMessage, append([]byte{messageVersion, byte(messageTypeChangeBreakPointRules)}, rules...)), qt.IsNil)
+	// the other connections get the rules
+	msg, ok := parseMessage(readBrokerTest(c, second)).(*messageMeta)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(msg.breakPointRules, qt.HasLen, 1)
+	c.Assert(msg.breakPointRules[0].URL, qt.Equals, "/intercepted")
+
+	f := newAPITestFlow(c, "POST", "http://example.com/intercepted", 0, "")
+	done := make(chan struct{})
+	go func() {
+		web.Request(f)
+		close(done)
+	}()
+	// both connections see the flow waiting
+	for _, client := range []*websocket.Conn{first, second} {
+		for _, mType := range []messageType{messageTypeRequest, messageTypeRequestBody} {
+			data := readBrokerTest(c, client)
+			c.Assert(messageType(data[1]), qt.Equals, mType)
+			c.Assert(string(data[2:38]), qt.Equals, f.ID.String())
+			c.Assert(data[38], qt.Equals, byte(mType-messageTypeRequest))
+		}
+	}
+
+	// a connection opened meanwhile gets the rules and the waiting flow
+	third := dialBrokerTest(c, web, server)
+	_, ok = parseMessage(readBrokerTest(c, third)).(*messageMeta)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(readBrokerTest(c, third)[1], qt.Equals, byte(messageTypeRequest))
+	data := readBrokerTest(c, third)
+	c.Assert(data[1], qt.Equals, byte(messageTypeRequestBody))
+	c.Assert(data[38], qt.Equals, byte(1))
+
+	// the second connection drops the request, for all of them
+	drop := &messageEdit{mType: messageTypeDropRequest, id: f.ID}
+	c.Assert(second.WriteMessage(websocket.BinaryMessage, drop.toBytes()), qt.IsNil)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("the flow is still waiting")
+	}
+	c.Assert(f.Response.StatusCode, qt.Equals, 502)
+	for _, client := range []*websocket.Conn{first, second, third} {
+		for _, mType := range []messageType{messageTypeRequest, messageTypeRequestBody} {
+			data := readBrokerTest(c, client)
+			c.Assert(messageType(data[1]), qt.Equals, mType)
+			c.Assert(data[38], qt.Equals, byte(0))
+		}
+	}
+
+	// a late edit of the same flow is ignored
+	web.broker.resume(drop)
+	web.broker.mu.Lock()
+	c.Assert(web.broker.intercepted, qt.HasLen, 0)
+	web.broker.mu.Unlock()
+}
@@ -12,15 +12,17 @@ import ViewFlow from './containers/ViewFlow'
 import Resizer from './components/Resizer'
 
 import { Flow, FlowManager } from './utils/flow'
-import { parseMessage, SendMessageType, buildMessageMeta, MessageType } from './utils/message'
+import { parseMessage, parseMessageBreakPointRules, SendMessageType, buildMessageMeta, buildMessageFilter, MessageType } from './utils/message'
 import { isInViewPort } from './utils/utils'
 import { ConnectionManager, IConnection } from './utils/connection'
+import type { IBreakPointRule } from './utils/config'
 
 interface IState {
   flows: Flow[]
   flow: Flow | null
   wsStatus: 'open' | 'close' | 'connecting'
   filterInvalid: boolean
+  breakPointRules: IBreakPointRule[] // shared by all the connections
 }
 
 const wsReconnIntervals = [1, 1, 2, 2, 4, 4, 8, 8, 16, 16, 32, 32]
@@ -49,6 +51,7 @@ class App extends React.Component<IProps, IState> {
       flow: null,
       wsStatus: 'close',
       filterInvalid: false,
+      breakPointRules: [],
     }
 
     this.ws = null
@@ -75,12 +78,16 @@ class App extends React.Component<IProps, IState> {
     this.setState({ wsStatus: 'connecting' })
 
     let host
+    let scheme = 'ws'
     if (process.env.NODE_ENV === 'development') {
       host = 'localhost:9081'
     } else {
-      host = new URL(document.URL).host
+      const url = new URL(document.URL)
+      host = url.host
+      // the web interface may be served over HTTPS
+      if (url.protocol === 'https:') scheme = 'wss'
     }
-    this.ws = new WebSocket(`ws://${host}/echo`)
+    this.ws = new WebSocket(`${scheme}://${host}/echo`)
     this.ws.binaryType = 'arraybuffer'
 
     this.ws.onopen = () => {
@@ -112,6 +119,12 @@ class App extends React.Component<IProps, IState> {
     }
 
     this.ws.onmessage = evt => {
+      const rules = parseMessageBreakPointRules(evt.data)
+      if (rules) {
+        this.setState({ breakPointRules: rules })
+        return
+      }
+
       const msg = parseMessage(evt.data)
       if (!msg) {
         console.error('parse error:', evt.data)
@@ -239,9 +252,26 @@ class App extends React.Component<IProps, IState> {
             </div>
 
             <div style={{ marginRight: '10px' }}>
-              <BreakPoint onSave={rules => {
+              <Form.Control
+                size="sm" placeholder="Server filter, e.g. ~d example.com & ~c 500"
+                style={{ width: '300px' }}
+                title="Only flows matching the mitmproxy style expression are sent by the proxy, press Enter to apply"
+                onKeyDown={(e: React.KeyboardEvent<HTMLInputElement>) => {
+                  if (e.key !== 'Enter') return
+                  this.wsSend(buildMessageFilter(e.currentTarget.value))
+                }}
+              />
+            </div>
+
+            <div style={{ marginRight: '10px' }}>
+              <Button size="sm" variant="primary" href="/api/har" download="flows.har">Export HAR</Button>
+            </div>
+
+            <div style={{ marginRight: '10px' }}>
+              <BreakPoint rules={this.state.breakPointRules} onSave={rules => {
                 const msg = buildMessageMeta(SendMessageType.CHANGE_BREAK_POINT_RULES, rules)
                 this.wsSend(msg)
+                this.setState({ breakPointRules: rules })
               }} />
             </div>
           </div>
@@ -4,38 +4,60 @@ import Modal from 'react-bootstrap/Modal'
 import Form from 'react-bootstrap/Form'
 import Row from 'react-bootstrap/Row'
 import Col from 'react-bootstrap/Col'
-import { BreakPointRuleAction, BreakPointRuleMethod, IBreakPointRule, configBreakPointRule, useConfig } from '../utils/config'
+import { BreakPointRuleAction, BreakPointRuleMethod, IBreakPointHeader, IBreakPointRule, configBreakPointRule, useConfig } from '../utils/config'
 
 interface IProps {
+  rules: IBreakPointRule[] // the rules of the proxy, set by any connection
   onSave: (rules: IBreakPointRule[]) => void
 }
 
-function BreakPoint({ onSave }: IProps) {
+function BreakPoint({ rules: proxyRules, onSave }: IProps) {
   const [show, setShow] = useState(false)
   const [rule, setRule] = useConfig(configBreakPointRule)
-  const [haveRules, setHaveRules] = useState(false)
 
-  const variant = haveRules ? 'success' : 'primary'
+  const variant = proxyRules.length ? 'success' : 'primary'
 
   const handleClose = () => setShow(false)
   const handleShow = () => setShow(true)
   const handleSave = () => {
     const rules: IBreakPointRule[] = []
-    if (rule.url) {
+    const headers: IBreakPointHeader[] = []
+    if (rule.header) {
+      const index = rule.header.indexOf(':')
+      const name = (index < 0 ? rule.header : rule.header.slice(0, index)).trim()
+      const value = index < 0 ? '' : rule.header.slice(index + 1).trim()
+      if (name) headers.push({ name, value })
+    }
+    if (rule.url || rule.urlRegex || rule.host || headers.length || rule.status) {
       rules.push({
         method: rule.method === 'ALL' ? '' : rule.method,
         url: rule.url,
+        urlRegex: rule.urlRegex,
+        host: rule.host,
+        headers,
+        status: rule.status,
         action: rule.action,
       })
     }
     onSave(rules)
     handleClose()
-    setHaveRules(rules.length ? true : false)
   }
 
+  // show the rules set by the other connections
   useEffect(() => {
-    handleSave()
-  }, [])
+    const proxyRule = proxyRules[0]
+    if (!proxyRule) return
+    const header = proxyRule.headers?.length ? `${proxyRule.headers[0].name}: ${proxyRule.headers[0].value}` : ''
+    setRule({
+      method: proxyRule.method || 'ALL',
+      url: proxyRule.url,
+      urlRegex: proxyRule.urlRegex,
+      host: proxyRule.host,
+      header,
+      status: proxyRule.status,
+      action: proxyRule.action,
+    })
+  }, [proxyRules])
 
   return (
     <div>
@@ -65,6 +87,26 @@ function BreakPoint({ onSave }: IProps) {
             <Col sm={10}><Form.Control value={rule.url} onChange={e => { setRule({ ...rule, url: e.target.value }) }} /></Col>
           </Form.Group>
 
+          <Form.Group as={Row}>
+            <Form.Label column sm={2}>URL Regex</Form.Label>
+            <Col sm={10}><Form.Control value={rule.urlRegex || ''} placeholder="/api/v\d+/" onChange={e => { setRule({ ...rule, urlRegex: e.target.value }) }} /></Col>
+          </Form.Group>
+
+          <Form.Group as={Row}>
+            <Form.Label column sm={2}>Host</Form.Label>
+            <Col sm={10}><Form.Control value={rule.host || ''} placeholder="*.example.com" onChange={e => { setRule({ ...rule, host: e.target.value }) }} /></Col>
+          </Form.Group>
+
+          <Form.Group as={Row}>
+            <Form.Label column sm={2}>Header</Form.Label>
+            <Col sm={10}><Form.Control value={rule.header || ''} placeholder="Content-Type: json" onChange={e => { setRule({ ...rule, header: e.target.value }) }} /></Col>
+          </Form.Group>
+
+          <Form.Group as={Row}>
+            <Form.Label column sm={2}>Status</Form.Label>
+            <Col sm={10}><Form.Control value={rule.status || ''} placeholder="5xx, responses only" onChange={e => { setRule({ ...rule, status: e.target.value }) }} /></Col>
+          </Form.Group>
+
           <Form.Group as={Row}>
             <Form.Label column sm={2}>Action</Form.Label>
             <Col sm={10}>
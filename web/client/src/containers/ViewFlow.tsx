@@ -5,12 +5,26 @@ import fetchToCurl from 'fetch-to-curl'
 import copy from 'copy-to-clipboard'
 import JSONPretty from 'react-json-pretty'
 import { flattenHeader, isTextBody } from '../utils/utils'
+import { buildMessageRequestReplay } from '../utils/message'
 import type { Flow, IResponse } from '../utils/flow'
+import type { ITLSInfo } from '../utils/connection'
 import EditFlow from './EditFlow'
 import { useSize } from 'ahooks'
 import { ResizerItem } from '../components/ResizerItem'
 import { configViewFlowRequestBodyTab, configViewFlowResponseBodyLineBreak, configViewFlowTab, useConfig } from '../utils/config'
 
+// tlsInfo shows what the TLS handshake of a connection negotiated, which tells why
+// its flows are HTTP/1.1 or HTTP/2.
+const tlsInfo = (info: ITLSInfo) => (
+  <>
+    <p>TLS: {info.version}, {info.cipherSuite}</p>
+    <p>ALPN: {info.alpn || 'none (http/1.1)'}</p>
+    {
+      !info.sni ? null : <p>SNI: {info.sni}</p>
+    }
+  </>
+)
+
 interface Iprops {
   flow: Flow | null
   onClose: () => void
@@ -52,6 +66,16 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
     )
   }
 
+  const replay = () => {
+    if (!flow) return null
+    return (
+      <Button size="sm" variant="primary" style={{ marginLeft: '5px' }} onClick={() => {
+        // the replayed flow arrives like the flows of clients
+        onMessage(buildMessageRequestReplay(flow))
+      }}>Replay</Button>
+    )
+  }
+
   const preview = () => {
     if (!flow) return null
     const response = flow.response
@@ -126,6 +150,17 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
             <p>Id: {flow.id}</p>
           </div>
         </div>
+        {
+          !flow.timings().length ? null :
+            <div className="header-block">
+              <p>Timings</p>
+              <div className="header-block-content">
+                {
+                  flow.timings().map(({ name, value }) => <p key={name}>{name}: {value}</p>)
+                }
+              </div>
+            </div>
+        }
         {
           !conn ? null :
             <>
@@ -137,14 +172,44 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
                       <div className="header-block-content">
                         <p>Address: {conn.serverConn.address}</p>
                         <p>Resolved Address: {conn.serverConn.peername}</p>
+                        {
+                          !conn.serverConn.tlsInfo ? null : tlsInfo(conn.serverConn.tlsInfo)
+                        }
                       </div>
                     </div>
+                    {
+                      !conn.serverConn.certificateChain ? null :
+                        <div className="header-block">
+                          <p>Server Certificates</p>
+                          {
+                            conn.serverConn.certificateChain.map(cert => (
+                              <div key={cert.fingerprint} className="header-block-content">
+                                <p>Subject: {cert.subject}</p>
+                                <p>Issuer: {cert.issuer}</p>
+                                {
+                                  !cert.dnsNames && !cert.ipAddresses ? null :
+                                    <p>Names: {[...(cert.dnsNames || []), ...(cert.ipAddresses || [])].join(', ')}</p>
+                                }
+                                <p>Valid: {cert.notBefore} - {cert.notAfter}</p>
+                                <p>SHA-256: {cert.fingerprint}</p>
+                              </div>
+                            ))
+                          }
+                        </div>
+                    }
                   </>
               }
               <div className="header-block">
                 <p>Client Connection</p>
                 <div className="header-block-content">
                   <p>Address: {conn.clientConn.address}</p>
+                  {
+                    !conn.clientConn.tlsInfo ? null : tlsInfo(conn.clientConn.tlsInfo)
+                  }
+                  {
+                    !conn.clientConn.offeredAlpn ? null :
+                      <p>Offered ALPN: {conn.clientConn.offeredAlpn.join(', ')}</p>
+                  }
                 </div>
               </div>
               <div className="header-block">
@@ -225,7 +290,7 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
           }}
         />
 
-        <div>{copyAsCurl()}</div>
+        <div>{copyAsCurl()}{replay()}</div>
 
         <div>
           <span className={flowTab === 'Detail' ? 'selected' : undefined} onClick={() => { setFlowTab('Detail') }}>Detail</span>
@@ -46,16 +46,28 @@ export const configViewFlowRequestBodyTab = (() => {
 
 export type BreakPointRuleMethod = 'ALL' | 'GET' | 'POST' | 'PUT' | 'DELETE' | ''
 export type BreakPointRuleAction = 1 | 2 | 3
+export interface IBreakPointHeader {
+  name: string
+  value: string // regex, empty matches any value
+}
 export interface IBreakPointRule {
   method: BreakPointRuleMethod
   url: string
+  urlRegex?: string
+  host?: string
+  headers?: IBreakPointHeader[]
+  status?: string // status code or class like 5xx, response only
   action: BreakPointRuleAction
 }
+// the rule as edited, with the header matcher as "Name: regex"
+export interface IBreakPointRuleConfig extends Omit<IBreakPointRule, 'headers'> {
+  header?: string
+}
 export const configBreakPointRule = (() => {
   const key = 'go-mitm.configBreakPointRule'
   return {
     get: () => {
-      let rule: IBreakPointRule | undefined
+      let rule: IBreakPointRuleConfig | undefined
       const str = localStorage.getItem(key)
       if (str) {
         try {
@@ -67,6 +79,6 @@ export const configBreakPointRule = (() => {
       if (!rule) rule = { method: 'ALL', url: '', action: 1 }
       return rule
     },
-    set: (value: IBreakPointRule) => localStorage.setItem(key, JSON.stringify(value)),
+    set: (value: IBreakPointRuleConfig) => localStorage.setItem(key, JSON.stringify(value)),
   }
 })()
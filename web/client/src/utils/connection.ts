@@ -1,13 +1,34 @@
+export interface ICertificateInfo {
+  subject: string
+  issuer: string
+  dnsNames?: string[]
+  ipAddresses?: string[]
+  notBefore: string
+  notAfter: string
+  fingerprint: string
+}
+
+export interface ITLSInfo {
+  version: string
+  cipherSuite: string
+  alpn?: string
+  sni?: string
+}
+
 export interface IConnection {
   clientConn: {
     id: string
     tls: boolean
     address: string
+    tlsInfo?: ITLSInfo
+    offeredAlpn?: string[]
   }
   serverConn?: {
     id: string
     address: string
     peername: string
+    certificateChain?: ICertificateInfo[]
+    tlsInfo?: ITLSInfo
   }
   intercept: boolean
   opening?: boolean
@@ -18,10 +18,21 @@ export interface IFlowRequest {
   request: IRequest
 }
 
+// The points in time a flow went through, as RFC 3339 strings, missing for those it did not go through
+export interface ITimings {
+  requestStart?: string
+  dialStart?: string
+  connected?: string
+  tlsEstablished?: string
+  firstResponseByte?: string
+  end?: string
+}
+
 export interface IResponse {
   statusCode: number
   header: Header
   body?: ArrayBuffer
+  timings?: ITimings
 }
 
 export interface IPreviewBody {
@@ -152,6 +163,11 @@ export class Flow {
     }
     this.endTime = Date.now()
     this.costTime = String(this.endTime - this.startTime) + ' ms'
+    // stored flows are finished, the proxy knows their duration
+    const timings = this.response?.timings
+    if (timings?.requestStart && timings.end) {
+      this.costTime = String(Date.parse(timings.end) - Date.parse(timings.requestStart)) + ' ms'
+    }
 
     if (!this.headerContentLengthExist && this.response && this.response.body) {
       this._size = this.response.body.byteLength
@@ -283,6 +299,26 @@ export class Flow {
     return this._hexviewResponseBody
   }
 
+  // The durations known from the timings the proxy recorded, e.g. Connect only when the flow dialed the server
+  public timings(): Array<{ name: string; value: string }> {
+    const t = this.response?.timings
+    if (!t) return []
+
+    const between = (start?: string, end?: string) => {
+      if (!start || !end) return null
+      return Date.parse(end) - Date.parse(start)
+    }
+    const items: Array<{ name: string; value: string }> = []
+    const add = (name: string, ms: number | null) => {
+      if (ms !== null) items.push({ name, value: String(ms) + ' ms' })
+    }
+    add('Connect', between(t.dialStart, t.connected))
+    add('TLS Handshake', between(t.connected, t.tlsEstablished))
+    add('Time to First Byte', between(t.requestStart, t.firstResponseByte))
+    add('Total', between(t.requestStart, t.end))
+    return items
+  }
+
   public getConn(): IConnection | undefined {
     if (this.conn) return this.conn
     this.conn = this.connMgr.get(this.connId)
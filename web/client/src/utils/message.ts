@@ -1,5 +1,6 @@
 import type { IConnection } from './connection'
 import type { Flow, IFlowRequest, IRequest, IResponse } from './flow'
+import type { IBreakPointRule } from './config'
 import { delHeader, hasHeader, setHeader } from './utils'
 
 const MESSAGE_VERSION = 2
@@ -76,6 +77,8 @@ export enum SendMessageType {
   DROP_REQUEST = 13,
   DROP_RESPONSE = 14,
   CHANGE_BREAK_POINT_RULES = 21,
+  CHANGE_FILTER = 22,
+  REQUEST_REPLAY = 33,
 }
 
 // type: 11/12/13/14
@@ -128,6 +131,18 @@ export const buildMessageEdit = (messageType: SendMessageType, flow: Flow) => {
 // type: 21
 // messageMeta
 // version 1 byte + type 1 byte + content left bytes
+// sent by the proxy too, with the rules shared by all the connections
+export const parseMessageBreakPointRules = (data: ArrayBuffer): IBreakPointRule[] | null => {
+  if (data.byteLength < 2) return null
+  const meta = new Int8Array(data.slice(0, 2))
+  if (meta[0] !== MESSAGE_VERSION || meta[1] !== SendMessageType.CHANGE_BREAK_POINT_RULES) return null
+  try {
+    return JSON.parse(new TextDecoder().decode(data.slice(2))) || []
+  } catch (err) {
+    return null
+  }
+}
+
 export const buildMessageMeta = (messageType: SendMessageType, rules: any) => {
   if (messageType !== SendMessageType.CHANGE_BREAK_POINT_RULES) {
     throw new Error('invalid message type')
@@ -141,3 +156,26 @@ export const buildMessageMeta = (messageType: SendMessageType, rules: any) => {
 
   return view
 }
+
+// type: 22
+// messageFilter
+// version 1 byte + type 1 byte + filter expression left bytes
+export const buildMessageFilter = (expr: string) => {
+  const exprBytes = new TextEncoder().encode(expr)
+  const view = new Uint8Array(2 + exprBytes.byteLength)
+  view[0] = MESSAGE_VERSION
+  view[1] = SendMessageType.CHANGE_FILTER
+  view.set(exprBytes, 2)
+  return view
+}
+
+// type: 33
+// messageRequestReplay
+// version 1 byte + type 1 byte + id 36 byte
+export const buildMessageRequestReplay = (flow: Flow) => {
+  const view = new Uint8Array(38)
+  view[0] = MESSAGE_VERSION
+  view[1] = SendMessageType.REQUEST_REPLAY
+  view.set(new TextEncoder().encode(flow.id), 2)
+  return view
+}
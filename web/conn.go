@@ -1,96 +1,221 @@
 package web
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 
+	"github.com/golang/groupcache/lru"
 	"github.com/gorilla/websocket"
+	uuid "github.com/satori/go.uuid"
 
+	"github.com/denisvmedia/go-mitmproxy/internal/helper"
 	"github.com/denisvmedia/go-mitmproxy/proxy"
 )
 
+// breakPointRule intercepts the flows matching all of its conditions. A rule without
+// any condition besides Method intercepts nothing.
 type breakPointRule struct {
-	Method string `json:"method"`
-	URL    string `json:"url"`
-	Action int    `json:"action"` // 1 - change request 2 - change response 3 - both
+	Method   string `json:"method"`
+	URL      string `json:"url"`                // substring of the URL
+	URLRegex string `json:"urlRegex,omitempty"` // regular expression matching the URL
+	Host     string `json:"host,omitempty"`     // host pattern like the ones of ignore_hosts, e.g. *.example.com
+
+	Headers         []*breakPointHeader `json:"headers,omitempty"`         // request header matchers
+	ResponseHeaders []*breakPointHeader `json:"responseHeaders,omitempty"` // response header matchers, response breakpoints only
+	Status          string              `json:"status,omitempty"`          // a status code or a class like 5xx, response breakpoints only
+
+	Action int `json:"action"` // 1 - change request 2 - change response 3 - both
+
+	urlRegex *regexp.Regexp
+	status   int
+}
+
+// breakPointHeader matches the flows with a header value matching Value.
+type breakPointHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"` // regular expression, empty matches any value
+
+	value *regexp.Regexp
+}
+
+// compile checks the rule and prepares its regular expressions.
+func (rule *breakPointRule) compile() error {
+	if rule.URLRegex != "" {
+		re, err := regexp.Compile(rule.URLRegex)
+		if err != nil {
+			return fmt.Errorf("invalid url regex: %w", err)
+		}
+		rule.urlRegex = re
+	}
+	for _, header := range slices.Concat(rule.Headers, rule.ResponseHeaders) {
+		if header.Name == "" {
+			return errors.New("header without name")
+		}
+		re, err := regexp.Compile(header.Value)
+		if err != nil {
+			return fmt.Errorf("invalid regex of header %s: %w", header.Name, err)
+		}
+		header.value = re
+	}
+	if rule.Status != "" {
+		status, err := parseStatus(rule.Status)
+		if err != nil {
+			return err
+		}
+		rule.status = status
+	}
+	return nil
+}
+
+func (rule *breakPointRule) hasCondition() bool {
+	return rule.URL != "" || rule.URLRegex != "" || rule.Host != "" || len(rule.Headers) > 0 ||
+		len(rule.ResponseHeaders) > 0 || rule.Status != ""
+}
+
+// match reports whether the rule matches the request of f, and its response when
+// response is set. Conditions on the response never match requests.
+func (rule *breakPointRule) match(f *proxy.Flow, response bool) bool {
+	if rule.Method != "" && rule.Method != f.Request.Method {
+		return false
+	}
+	url := f.Request.URL.String()
+	if rule.URL != "" && !strings.Contains(url, rule.URL) {
+		return false
+	}
+	if rule.urlRegex != nil && !rule.urlRegex.MatchString(url) {
+		return false
+	}
+	if rule.Host != "" && !helper.MatchHost(f.Request.URL.Host, []string{rule.Host}) {
+		return false
+	}
+	if !matchHeaders(rule.Headers, f.Request.Header) {
+		return false
+	}
+	if rule.status == 0 && len(rule.ResponseHeaders) == 0 {
+		return true
+	}
+	if !response || f.Response == nil {
+		return false
+	}
+	return matchStatus(f.Response.StatusCode, rule.status) && matchHeaders(rule.ResponseHeaders, f.Response.Header)
+}
+
+func matchHeaders(headers []*breakPointHeader, header http.Header) bool {
+	for _, h := range headers {
+		values := header.Values(h.Name)
+		if len(values) == 0 || (h.value != nil && !slices.ContainsFunc(values, h.value.MatchString)) {
+			return false
+		}
+	}
+	return true
 }
 
 type concurrentConn struct {
 	conn *websocket.Conn
 	mu   sync.Mutex
 
-	sendConnMessageMap map[string]bool
-
-	waitChans   map[string]chan any
-	waitChansMu sync.Mutex
-
-	breakPointRules []*breakPointRule
+	// broker shares the breakpoints and the edits with the other connections.
+	broker *broker
+
+	// filter selects the flows sent to the connection, nil sends all of them. Flows
+	// are sent from the first message they match on, once sent they stay sent.
+	filter     flowFilter
+	shownFlows *lru.Cache // flow ID -> struct{}, the flows sent despite filter
+	filterMu   sync.Mutex
+
+	// curlMessage answers requests for the curl command of a flow, nil if it is unknown.
+	curlMessage func(id uuid.UUID) *messageFlow
+	// replay replays the request of a flow in the background.
+	replay func(id uuid.UUID)
+	// maxBodySize truncates the bodies sent, zero for none.
+	maxBodySize int
 }
 
 func newConn(c *websocket.Conn) *concurrentConn {
 	return &concurrentConn{
-		conn:               c,
-		sendConnMessageMap: make(map[string]bool),
-		waitChans:          make(map[string]chan any),
+		conn: c,
 	}
 }
 
-func (c *concurrentConn) trySendConnMessage(f *proxy.Flow) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// setFilter changes the filter of the flows sent to the connection.
+func (c *concurrentConn) setFilter(filter flowFilter) {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	c.filter = filter
+	c.shownFlows = lru.New(maxRecentFlows)
+}
 
-	key := f.ConnContext.ID().String()
-	if send := c.sendConnMessageMap[key]; send {
-		return
+// showFlow reports whether the messages of f are sent to the connection. The messages
+// of a flow matching the filter only from mType on are sent first. force shows flows
+// not matching the filter.
+func (c *concurrentConn) showFlow(f *proxy.Flow, mType messageType, force bool) bool {
+	c.filterMu.Lock()
+	if c.filter == nil {
+		c.filterMu.Unlock()
+		return true
 	}
-	c.sendConnMessageMap[key] = true
-	msg, err := newMessageFlow(messageTypeConn, f)
-	if err != nil {
-		slog.Error("web addon gen msg failed", "error", err)
-		return
+	if _, ok := c.shownFlows.Get(f.ID); ok {
+		c.filterMu.Unlock()
+		return true
 	}
-	if err := c.conn.WriteMessage(websocket.BinaryMessage, msg.toBytes()); err != nil {
-		slog.Error("write websocket message failed", "error", err)
-		return
+	if !force && !c.filter(f) {
+		c.filterMu.Unlock()
+		return false
 	}
-}
-
-func (c *concurrentConn) whenConnClose(connCtx *proxy.ConnContext) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	delete(c.sendConnMessageMap, connCtx.ID().String())
+	c.shownFlows.Add(f.ID, struct{}{})
+	c.filterMu.Unlock()
 
-	msg := newMessageConnClose(connCtx)
-	if err := c.conn.WriteMessage(websocket.BinaryMessage, msg.toBytes()); err != nil {
-		slog.Error("write websocket message failed", "error", err)
-		return
+	for t := messageTypeRequest; t < mType; t++ {
+		msg, err := newMessageFlow(t, f)
+		if err != nil {
+			continue
+		}
+		c.writeMessage(msg)
 	}
+	return true
 }
 
-func (c *concurrentConn) writeMessageMayWait(msg *messageFlow, f *proxy.Flow) {
-	if c.isIntercpt(f, msg.mType) {
-		msg.waitIntercept = 1
+// writeFlowMessage writes a message of f if the filter shows f.
+func (c *concurrentConn) writeFlowMessage(msg *messageFlow, f *proxy.Flow) {
+	if c.showFlow(f, msg.mType, false) {
+		c.writeMessage(msg)
 	}
+}
 
-	c.mu.Lock()
-	err := c.conn.WriteMessage(websocket.BinaryMessage, msg.toBytes())
-	c.mu.Unlock()
-	if err != nil {
-		slog.Error("write websocket message failed", "error", err)
-		return
+// writeInterceptMessage writes a message of a flow stopped at a breakpoint, which is
+// shown to be resumed whatever the filter.
+func (c *concurrentConn) writeInterceptMessage(msg *messageFlow, f *proxy.Flow) {
+	if c.showFlow(f, msg.mType, true) {
+		c.writeBytes(c.messageBytes(msg))
 	}
+}
 
-	if msg.waitIntercept == 1 {
-		c.waitIntercept(f)
+// messageBytes encodes msg, with the body truncated to maxBodySize unless the flow
+// waits for an edit, which would keep the truncated body.
+func (c *concurrentConn) messageBytes(msg *messageFlow) []byte {
+	if c.maxBodySize > 0 && msg.waitIntercept == 0 && len(msg.content) > c.maxBodySize &&
+		(msg.mType == messageTypeRequestBody || msg.mType == messageTypeResponseBody) {
+		truncated := *msg
+		truncated.content = msg.content[:c.maxBodySize]
+		return truncated.toBytes()
 	}
+	return msg.toBytes()
 }
 
 func (c *concurrentConn) writeMessage(msg *messageFlow) {
 	msg.waitIntercept = 0
+	c.writeBytes(c.messageBytes(msg))
+}
+
+func (c *concurrentConn) writeBytes(data []byte) {
 	c.mu.Lock()
-	err := c.conn.WriteMessage(websocket.BinaryMessage, msg.toBytes())
+	err := c.conn.WriteMessage(websocket.BinaryMessage, data)
 	c.mu.Unlock()
 	if err != nil {
 		slog.Error("write websocket message failed", "error", err)
@@ -98,6 +223,18 @@ func (c *concurrentConn) writeMessage(msg *messageFlow) {
 	}
 }
 
+func (c *concurrentConn) writeCurl(id uuid.UUID) {
+	if c.curlMessage == nil {
+		return
+	}
+	msg := c.curlMessage(id)
+	if msg == nil {
+		slog.Warn("curl requested for unknown flow", "flowId", id)
+		return
+	}
+	c.writeMessage(msg)
+}
+
 func (c *concurrentConn) readloop() {
 	for {
 		mt, data, err := c.conn.ReadMessage()
@@ -118,96 +255,19 @@ func (c *concurrentConn) readloop() {
 		}
 
 		if msgEdit, ok := msg.(*messageEdit); ok {
-			ch := c.initWaitChan(msgEdit.id.String())
-			go func(m *messageEdit, ch chan<- any) {
-				ch <- m
-			}(msgEdit, ch)
+			c.broker.resume(msgEdit)
 		} else if msgMeta, ok := msg.(*messageMeta); ok {
-			c.breakPointRules = msgMeta.breakPointRules
+			c.broker.setBreakPointRules(msgMeta, c)
+		} else if msgFilter, ok := msg.(*messageFilter); ok {
+			c.setFilter(msgFilter.filter)
+		} else if msgCurl, ok := msg.(*messageRequestCurl); ok {
+			c.writeCurl(msgCurl.id)
+		} else if msgReplay, ok := msg.(*messageRequestReplay); ok {
+			if c.replay != nil {
+				c.replay(msgReplay.id)
+			}
 		} else {
 			slog.Warn("invalid message, skip")
 		}
 	}
 }
-
-func (c *concurrentConn) initWaitChan(key string) chan any {
-	c.waitChansMu.Lock()
-	defer c.waitChansMu.Unlock()
-
-	if ch, ok := c.waitChans[key]; ok {
-		return ch
-	}
-	ch := make(chan any)
-	c.waitChans[key] = ch
-	return ch
-}
-
-// Check whether to intercept.
-func (c *concurrentConn) isIntercpt(f *proxy.Flow, mType messageType) bool {
-	if mType != messageTypeRequestBody && mType != messageTypeResponseBody {
-		return false
-	}
-
-	if len(c.breakPointRules) == 0 {
-		return false
-	}
-
-	var action int
-	if mType == messageTypeRequestBody {
-		action = 1
-	} else {
-		action = 2
-	}
-
-	for _, rule := range c.breakPointRules {
-		if rule.URL == "" {
-			continue
-		}
-		if action&rule.Action == 0 {
-			continue
-		}
-		if rule.Method != "" && rule.Method != f.Request.Method {
-			continue
-		}
-		if strings.Contains(f.Request.URL.String(), rule.URL) {
-			return true
-		}
-	}
-
-	return false
-}
-
-// Intercept.
-func (c *concurrentConn) waitIntercept(f *proxy.Flow) {
-	ch := c.initWaitChan(f.ID.String())
-	msgRaw := <-ch
-	msg, ok := msgRaw.(*messageEdit)
-	if !ok {
-		slog.Error("received message is not a *messageEdit")
-		f.Response = &proxy.Response{
-			StatusCode: 500,
-		}
-		return
-	}
-
-	// drop
-	if msg.mType == messageTypeDropRequest || msg.mType == messageTypeDropResponse {
-		f.Response = &proxy.Response{
-			StatusCode: 502,
-		}
-		return
-	}
-
-	// change
-	switch msg.mType {
-	case messageTypeChangeRequest:
-		f.Request.Method = msg.request.Method
-		f.Request.URL = msg.request.URL
-		f.Request.Header = msg.request.Header
-		f.Request.Body = msg.request.Body
-	case messageTypeChangeResponse:
-		f.Response.StatusCode = msg.response.StatusCode
-		f.Response.Header = msg.response.Header
-		f.Response.Body = msg.response.Body
-	}
-}
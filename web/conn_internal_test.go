@@ -1,8 +1,9 @@
 // This file contains tests for internal web connection breakpoint logic.
 //
 // Justification:
-// - concurrentConn.isIntercpt: determines if a request should be intercepted based on breakpoint rules
-// - breakPointRule matching: validates URL and method matching for interception
+// - broker.isIntercpt: determines if a request should be intercepted based on breakpoint rules
+// - breakPointRule matching: validates URL, regex, host, header, status and method
+//   matching for interception
 //
 // These functions implement the interception decision logic which is core to the
 // web debugging interface but requires access to unexported types.
@@ -10,6 +11,7 @@
 package web
 
 import (
+	"net/http"
 	"net/url"
 	"testing"
 
@@ -18,10 +20,10 @@ import (
 	"github.com/denisvmedia/go-mitmproxy/proxy"
 )
 
-func TestConcurrentConnIsInterceptWithNoRules(t *testing.T) {
+func TestBrokerIsInterceptWithNoRules(t *testing.T) {
 	c := qt.New(t)
 
-	conn := &concurrentConn{
+	b := &broker{
 		breakPointRules: nil,
 	}
 
@@ -33,15 +35,15 @@ func TestConcurrentConnIsInterceptWithNoRules(t *testing.T) {
 		},
 	}
 
-	result := conn.isIntercpt(flow, messageTypeRequestBody)
+	result := b.isIntercpt(flow, messageTypeRequestBody)
 
 	c.Assert(result, qt.IsFalse)
 }
 
-func TestConcurrentConnIsInterceptWithMatchingRule(t *testing.T) {
+func TestBrokerIsInterceptWithMatchingRule(t *testing.T) {
 	c := qt.New(t)
 
-	conn := &concurrentConn{
+	b := &broker{
 		breakPointRules: []*breakPointRule{
 			{
 				Method: "GET",
@@ -59,15 +61,15 @@ func TestConcurrentConnIsInterceptWithMatchingRule(t *testing.T) {
 		},
 	}
 
-	result := conn.isIntercpt(flow, messageTypeRequestBody)
+	result := b.isIntercpt(flow, messageTypeRequestBody)
 
 	c.Assert(result, qt.IsTrue)
 }
 
-func TestConcurrentConnIsInterceptWithNonMatchingMethod(t *testing.T) {
+func TestBrokerIsInterceptWithNonMatchingMethod(t *testing.T) {
 	c := qt.New(t)
 
-	conn := &concurrentConn{
+	b := &broker{
 		breakPointRules: []*breakPointRule{
 			{
 				Method: "POST",
@@ -85,15 +87,15 @@ func TestConcurrentConnIsInterceptWithNonMatchingMethod(t *testing.T) {
 		},
 	}
 
-	result := conn.isIntercpt(flow, messageTypeRequestBody)
+	result := b.isIntercpt(flow, messageTypeRequestBody)
 
 	c.Assert(result, qt.IsFalse)
 }
 
-func TestConcurrentConnIsInterceptWithResponseBodyType(t *testing.T) {
+func TestBrokerIsInterceptWithResponseBodyType(t *testing.T) {
 	c := qt.New(t)
 
-	conn := &concurrentConn{
+	b := &broker{
 		breakPointRules: []*breakPointRule{
 			{
 				Method: "GET",
@@ -111,15 +113,15 @@ func TestConcurrentConnIsInterceptWithResponseBodyType(t *testing.T) {
 		},
 	}
 
-	result := conn.isIntercpt(flow, messageTypeResponseBody)
+	result := b.isIntercpt(flow, messageTypeResponseBody)
 
 	c.Assert(result, qt.IsTrue)
 }
 
-func TestConcurrentConnIsInterceptWithBothAction(t *testing.T) {
+func TestBrokerIsInterceptWithBothAction(t *testing.T) {
 	c := qt.New(t)
 
-	conn := &concurrentConn{
+	b := &broker{
 		breakPointRules: []*breakPointRule{
 			{
 				Method: "GET",
@@ -137,17 +139,17 @@ func TestConcurrentConnIsInterceptWithBothAction(t *testing.T) {
 		},
 	}
 
-	requestResult := conn.isIntercpt(flow, messageTypeRequestBody)
-	responseResult := conn.isIntercpt(flow, messageTypeResponseBody)
+	requestResult := b.isIntercpt(flow, messageTypeRequestBody)
+	responseResult := b.isIntercpt(flow, messageTypeResponseBody)
 
 	c.Assert(requestResult, qt.IsTrue)
 	c.Assert(responseResult, qt.IsTrue)
 }
 
-func TestConcurrentConnIsInterceptIgnoresNonBodyMessageTypes(t *testing.T) {
+func TestBrokerIsInterceptIgnoresNonBodyMessageTypes(t *testing.T) {
 	c := qt.New(t)
 
-	conn := &concurrentConn{
+	b := &broker{
 		breakPointRules: []*breakPointRule{
 			{
 				Method: "GET",
@@ -165,7 +167,69 @@ func TestConcurrentConnIsInterceptIgnoresNonBodyMessageTypes(t *testing.T) {
 		},
 	}
 
-	result := conn.isIntercpt(flow, messageTypeRequest)
+	result := b.isIntercpt(flow, messageTypeRequest)
 
 	c.Assert(result, qt.IsFalse)
 }
+
+func TestBreakPointRuleConditions(t *testing.T) {
+	newFlow := func() *proxy.Flow {
+		return &proxy.Flow{
+			Request: &proxy.Request{
+				Method: "POST",
+				URL:    &url.URL{Scheme: "https", Host: "api.example.com", Path: "/v1/items/42"},
+				Header: http.Header{"Content-Type": {"application/json"}, "X-Debug": {"1"}},
+			},
+			Response: &proxy.Response{
+				StatusCode: 503,
+				Header:     http.Header{"Retry-After": {"30"}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		rule     breakPointRule
+		request  bool
+		response bool
+	}{
+		{name: "url regex", rule: breakPointRule{URLRegex: `/items/\d+$`}, request: true, response: true},
+		{name: "url regex mismatch", rule: breakPointRule{URLRegex: `/items/[a-z]+$`}},
+		{name: "host", rule: breakPointRule{Host: "*.example.com"}, request: true, response: true},
+		{name: "host mismatch", rule: breakPointRule{Host: "example.org"}},
+		{name: "host and method", rule: breakPointRule{Host: "api.example.com", Method: "GET"}},
+		{name: "header present", rule: breakPointRule{Headers: []*breakPointHeader{{Name: "x-debug"}}}, request: true, response: true},
+		{name: "header value", rule: breakPointRule{Headers: []*breakPointHeader{{Name: "Content-Type", Value: "json"}}}, request: true, response: true},
+		{name: "header value mismatch", rule: breakPointRule{Headers: []*breakPointHeader{{Name: "Content-Type", Value: "^text/"}}}},
+		{name: "header missing", rule: breakPointRule{Headers: []*breakPointHeader{{Name: "Authorization"}}}},
+		{name: "status", rule: breakPointRule{Status: "503"}, response: true},
+		{name: "status class", rule: breakPointRule{Status: "5xx", URLRegex: "example"}, response: true},
+		{name: "status mismatch", rule: breakPointRule{Status: "2xx"}},
+		{name: "response header", rule: breakPointRule{ResponseHeaders: []*breakPointHeader{{Name: "Retry-After", Value: `^\d+$`}}}, response: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			rule := tt.rule
+			rule.Action = 3
+			c.Assert(rule.compile(), qt.IsNil)
+			b := &broker{breakPointRules: []*breakPointRule{&rule}}
+			c.Assert(b.isIntercpt(newFlow(), messageTypeRequestBody), qt.Equals, tt.request)
+			c.Assert(b.isIntercpt(newFlow(), messageTypeResponseBody), qt.Equals, tt.response)
+		})
+	}
+}
+
+func TestBreakPointRuleCompileErrors(t *testing.T) {
+	tests := map[string]breakPointRule{
+		"invalid url regex \"(\"": {URLRegex: "("},
+		"header without name":     {Headers: []*breakPointHeader{{Value: "x"}}},
+		"invalid header regex":    {ResponseHeaders: []*breakPointHeader{{Name: "X", Value: "["}}},
+		"invalid status \"6xx\"":  {Status: "6xx"},
+	}
+	for name, rule := range tests {
+		t.Run(name, func(t *testing.T) {
+			qt.Assert(t, rule.compile(), qt.IsNotNil)
+		})
+	}
+}
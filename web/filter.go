@@ -0,0 +1,292 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// flowFilter reports whether a flow matches a filter expression. Conditions on the
+// response do not match flows without one yet.
+type flowFilter func(f *proxy.Flow) bool
+
+// The filter expressions follow the ones of mitmproxy: a bare string or ~u matches the
+// URL, and conditions combine with ! (not), & (and, implied between conditions), |
+// (or) and parentheses. Regular expressions are case-insensitive.
+//
+// filterOperators maps the operators to their argument, "" for none.
+var filterOperators = map[string]string{
+	"~all":    "",      // all flows
+	"~q":      "",      // requests without a response
+	"~s":      "",      // flows with a response
+	"~e":      "",      // flows with an error
+	"~replay": "",      // replayed flows
+	"~d":      "regex", // host
+	"~m":      "regex", // method
+	"~u":      "regex", // URL
+	"~c":      "int",   // response status code
+	"~h":      "regex", // request or response header, as name: value
+	"~hq":     "regex", // request header
+	"~hs":     "regex", // response header
+	"~b":      "regex", // request or response body
+	"~bq":     "regex", // request body
+	"~bs":     "regex", // response body
+	"~t":      "regex", // request or response content type
+	"~tq":     "regex", // request content type
+	"~ts":     "regex", // response content type
+}
+
+// parseFilter parses a filter expression. An empty expression matches all flows.
+func parseFilter(expr string) (flowFilter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return func(*proxy.Flow) bool { return true }, nil
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+	return filter, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool // quoted strings are never operators
+}
+
+func (tok filterToken) is(op string) bool {
+	return !tok.quoted && tok.text == op
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case strings.IndexByte("()!&|", ch) >= 0:
+			tokens = append(tokens, filterToken{text: string(ch)})
+			i++
+		case ch == '"' || ch == '\'':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(expr) && expr[j] != ch; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) && expr[j+1] == ch {
+					j++
+				}
+				text.WriteByte(expr[j])
+			}
+			if j == len(expr) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, filterToken{text: text.String(), quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && strings.IndexByte(" \t\n\r()!&|\"'", expr[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, filterToken{text: expr[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *filterParser) parseOr() (flowFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || !tok.is("|") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(f *proxy.Flow) bool { return l(f) || right(f) }
+	}
+}
+
+func (p *filterParser) parseAnd() (flowFilter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.is("|") || tok.is(")") {
+			return left, nil
+		}
+		// conditions next to each other are and-ed too
+		if tok.is("&") {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(f *proxy.Flow) bool { return l(f) && right(f) }
+	}
+}
+
+func (p *filterParser) parseNot() (flowFilter, error) {
+	tok, ok := p.peek()
+	if ok && tok.is("!") {
+		p.pos++
+		filter, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *proxy.Flow) bool { return !filter(f) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (flowFilter, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of expression")
+	}
+	p.pos++
+	switch {
+	case tok.is("("):
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing, ok := p.peek(); !ok || !closing.is(")") {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return filter, nil
+	case tok.is(")") || tok.is("&") || tok.is("|"):
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	case tok.quoted || !strings.HasPrefix(tok.text, "~"):
+		return newFilterCondition("~u", tok.text)
+	}
+
+	argKind, ok := filterOperators[tok.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %s", tok.text)
+	}
+	var arg string
+	if argKind != "" {
+		argTok, ok := p.peek()
+		if !ok || (!argTok.quoted && strings.IndexByte("()!&|", argTok.text[0]) >= 0) {
+			return nil, fmt.Errorf("%s needs an argument", tok.text)
+		}
+		p.pos++
+		arg = argTok.text
+	}
+	return newFilterCondition(tok.text, arg)
+}
+
+func newFilterCondition(op, arg string) (flowFilter, error) {
+	switch op {
+	case "~all":
+		return func(*proxy.Flow) bool { return true }, nil
+	case "~q":
+		return func(f *proxy.Flow) bool { return f.Response == nil }, nil
+	case "~s":
+		return func(f *proxy.Flow) bool { return f.Response != nil }, nil
+	case "~e":
+		return func(f *proxy.Flow) bool { return f.Error != nil }, nil
+	case "~replay":
+		return func(f *proxy.Flow) bool { return f.IsReplay }, nil
+	case "~c":
+		code, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", arg)
+		}
+		return func(f *proxy.Flow) bool { return f.Response != nil && f.Response.StatusCode == code }, nil
+	}
+
+	re, err := regexp.Compile("(?i)" + arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of %s: %w", op, err)
+	}
+	switch op {
+	case "~d":
+		return func(f *proxy.Flow) bool { return re.MatchString(f.Request.URL.Hostname()) }, nil
+	case "~m":
+		return func(f *proxy.Flow) bool { return re.MatchString(f.Request.Method) }, nil
+	case "~u":
+		return func(f *proxy.Flow) bool { return re.MatchString(f.Request.URL.String()) }, nil
+	case "~h", "~hq", "~hs":
+		return matchBoth(op, func(f *proxy.Flow) bool { return matchHeaderLines(re, f.Request.Header) },
+			func(f *proxy.Flow) bool { return matchHeaderLines(re, f.Response.Header) }), nil
+	case "~b", "~bq", "~bs":
+		return matchBoth(op, func(f *proxy.Flow) bool {
+			body, err := f.Request.DecodedBody()
+			if err != nil {
+				body = f.Request.Body
+			}
+			return re.Match(body)
+		}, func(f *proxy.Flow) bool {
+			body, err := f.Response.DecodedBody()
+			if err != nil {
+				body = f.Response.Body
+			}
+			return re.Match(body)
+		}), nil
+	case "~t", "~tq", "~ts":
+		return matchBoth(op, func(f *proxy.Flow) bool { return re.MatchString(f.Request.Header.Get("Content-Type")) },
+			func(f *proxy.Flow) bool { return re.MatchString(f.Response.Header.Get("Content-Type")) }), nil
+	}
+	return nil, fmt.Errorf("unknown filter %s", op)
+}
+
+// matchBoth returns the filter of an operator matching the request with a q suffix,
+// the response with an s suffix, and either one without suffix.
+func matchBoth(op string, request, response flowFilter) flowFilter {
+	withResponse := func(f *proxy.Flow) bool { return f.Response != nil && response(f) }
+	switch op[len(op)-1] {
+	case 'q':
+		return request
+	case 's':
+		return withResponse
+	}
+	return func(f *proxy.Flow) bool { return request(f) || withResponse(f) }
+}
+
+func matchHeaderLines(re *regexp.Regexp, header http.Header) bool {
+	for name, values := range header {
+		if slices.ContainsFunc(values, func(value string) bool { return re.MatchString(name + ": " + value) }) {
+			return true
+		}
+	}
+	return false
+}
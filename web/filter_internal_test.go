@@ -0,0 +1,178 @@
+// This file contains tests for the filter expressions of the web addon.
+//
+// Justification:
+// - parseFilter and the flowFilter it returns are unexported
+// - the flows sent to a connection are filtered by the unexported concurrentConn
+//
+// The connection is tested over a real websocket served by httptest.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/websocket"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+func newFilterTestFlow() *proxy.Flow {
+	f := proxy.NewFlow()
+	f.Request = &proxy.Request{
+		Method: "POST",
+		URL:    &url.URL{Scheme: "https", Host: "api.example.com:8443", Path: "/v1/login", RawQuery: "next=home"},
+		Header: http.Header{"Content-Type": {"application/json"}, "X-Debug": {"on"}},
+		Body:   []byte(`{"user":"alice"}`),
+	}
+	f.Response = &proxy.Response{
+		StatusCode: 401,
+		Header:     http.Header{"Content-Type": {"text/html"}, "Www-Authenticate": {"Bearer"}},
+		Body:       []byte("<p>denied</p>"),
+	}
+	return f
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "", want: true},
+		{expr: "login", want: true},
+		{expr: "LOGIN", want: true},
+		{expr: "logout", want: false},
+		{expr: "~all", want: true},
+		{expr: "~d ^api\\.example\\.com$", want: true},
+		{expr: "~d example.org", want: false},
+		{expr: "~m post", want: true},
+		{expr: "~u next=home", want: true},
+		{expr: "~c 401", want: true},
+		{expr: "~c 200", want: false},
+		{expr: "~h 'x-debug: on'", want: true},
+		{expr: "~hq www-authenticate", want: false},
+		{expr: "~hs www-authenticate", want: true},
+		{expr: "~b alice", want: true},
+		{expr: "~bq denied", want: false},
+		{expr: "~bs denied", want: true},
+		{expr: "~t json", want: true},
+		{expr: "~tq html", want: false},
+		{expr: "~ts html", want: true},
+		{expr: "~q", want: false},
+		{expr: "~s", want: true},
+		{expr: "~e", want: false},
+		{expr: "~replay", want: false},
+		{expr: "!~e", want: true},
+		{expr: "~m post ~c 401", want: true},
+		{expr: "~m post & ~c 200", want: false},
+		{expr: "~c 200 | ~c 401", want: true},
+		{expr: "!(~c 200 | ~c 401)", want: false},
+		{expr: "~m get | ~d api & ~c 401", want: true},
+		{expr: "(~m get | ~d api) & !~s", want: false},
+		{expr: `"~c"`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c := qt.New(t)
+			filter, err := parseFilter(tt.expr)
+			c.Assert(err, qt.IsNil)
+			c.Assert(filter(newFilterTestFlow()), qt.Equals, tt.want)
+		})
+	}
+}
+
+func TestParseFilterWithoutResponse(t *testing.T) {
+	c := qt.New(t)
+	f := newFilterTestFlow()
+	f.Response = nil
+	f.Error = errors.New("connection refused")
+	for expr, want := range map[string]bool{"~q": true, "~e": true, "~c 401": false, "~hs .": false, "!~bs x": true, "~b alice": true} {
+		filter, err := parseFilter(expr)
+		c.Assert(err, qt.IsNil)
+		c.Assert(filter(f), qt.Equals, want, qt.Commentf(expr))
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := map[string]string{
+		"~x":          "unknown filter ~x",
+		"~c":          "~c needs an argument",
+		"~c (":        "~c needs an argument",
+		"~c abc":      `invalid status code "abc"`,
+		"~u '('":      "invalid regex of ~u: error parsing regexp: missing closing ): `(?i)(`",
+		"(~s":         "missing )",
+		"~s)":         `unexpected ")"`,
+		"~s |":        "unexpected end of expression",
+		"| ~s":        `unexpected "|"`,
+		"'unfinished": "unterminated string at 0",
+	}
+	for expr, want := range tests {
+		t.Run(expr, func(t *testing.T) {
+			_, err := parseFilter(expr)
+			qt.Assert(t, err, qt.ErrorMatches, regexp.QuoteMeta(want))
+		})
+	}
+}
+
+func TestConnFilterSendsMatchingFlows(t *testing.T) {
+	c := qt.New(t)
+	conns := make(chan *concurrentConn, 1)
+	upgrader := &websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- newConn(ws)
+	}))
+	defer server.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/", nil)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+	conn := <-conns
+
+	msg, ok := parseMessage((&messageFilter{expr: "~c 401"}).toBytes()).(*messageFilter)
+	c.Assert(ok, qt.IsTrue)
+	conn.setFilter(msg.filter)
+
+	send := func(f *proxy.Flow, mType messageType) {
+		msg, err := newMessageFlow(mType, f)
+		c.Assert(err, qt.IsNil)
+		conn.writeFlowMessage(msg, f)
+	}
+	other := newFilterTestFlow()
+	other.Response.StatusCode = 200
+	matching := newFilterTestFlow()
+	response := matching.Response
+	matching.Response = nil
+	// the request does not match yet, the response does
+	send(matching, messageTypeRequest)
+	send(other, messageTypeRequest)
+	matching.Response = response
+	send(other, messageTypeResponse)
+	send(matching, messageTypeResponse)
+	send(matching, messageTypeResponseBody)
+
+	var got []messageType
+	for len(got) < 4 {
+		c.Assert(client.SetReadDeadline(time.Now().Add(5*time.Second)), qt.IsNil)
+		_, data, err := client.ReadMessage()
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data[2:38]), qt.Equals, matching.ID.String())
+		got = append(got, messageType(data[1]))
+	}
+	c.Assert(got, qt.DeepEquals, []messageType{messageTypeRequest, messageTypeRequestBody, messageTypeResponse, messageTypeResponseBody})
+
+	// an empty expression sends all flows again
+	msg, ok = parseMessage((&messageFilter{}).toBytes()).(*messageFilter)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(msg.filter, qt.IsNil)
+	c.Assert(parseMessage((&messageFilter{expr: "~c"}).toBytes()), qt.IsNil)
+}
@@ -0,0 +1,255 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+	"github.com/denisvmedia/go-mitmproxy/version"
+)
+
+// The HAR 1.2 format, see http://www.softwareishard.com/blog/har-12-spec/.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// harTimings are the phases of an entry in milliseconds, -1 for those that do not
+// apply, e.g. connect and ssl for the flows sent on an open connection.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// newHARFile converts finished flows into a HAR file, with the bodies decoded.
+func newHARFile(flows []*proxy.Flow, exported time.Time) *harFile {
+	har := &harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "go-mitmproxy", Version: version.Version},
+		Entries: make([]harEntry, 0, len(flows)),
+	}}
+	for _, f := range flows {
+		har.Log.Entries = append(har.Log.Entries, newHAREntry(f, exported))
+	}
+	return har
+}
+
+func newHAREntry(f *proxy.Flow, exported time.Time) harEntry {
+	req := f.Request
+	// flows without timings, e.g. loaded from a file, started when they were exported
+	started := f.Timings().RequestStart
+	if started.IsZero() {
+		started = exported
+	}
+	entry := harEntry{
+		StartedDateTime: started.UTC().Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: harHTTPVersion(req.Proto),
+			Cookies:     make([]harNameValue, 0),
+			Headers:     harHeaders(req.Header),
+			QueryString: make([]harNameValue, 0),
+			HeadersSize: -1,
+			BodySize:    len(req.Body),
+		},
+		// requests without a response are recorded with status 0
+		Response: harResponse{
+			Cookies:     make([]harNameValue, 0),
+			Headers:     make([]harNameValue, 0),
+			HTTPVersion: harHTTPVersion(req.Proto),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: value})
+		}
+	}
+	slices.SortStableFunc(entry.Request.QueryString, func(a, b harNameValue) int { return strings.Compare(a.Name, b.Name) })
+	for _, cookie := range (&http.Request{Header: req.Header}).Cookies() {
+		entry.Request.Cookies = append(entry.Request.Cookies, harNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	if len(req.Body) > 0 {
+		body, err := req.DecodedBody()
+		if err != nil {
+			body = req.Body
+		}
+		entry.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: string(body)}
+	}
+
+	if res := f.Response; res != nil {
+		entry.Response.Status = res.StatusCode
+		entry.Response.StatusText = http.StatusText(res.StatusCode)
+		entry.Response.Headers = harHeaders(res.Header)
+		entry.Response.RedirectURL = res.Header.Get("Location")
+		entry.Response.BodySize = len(res.Body)
+		for _, cookie := range (&http.Response{Header: res.Header}).Cookies() {
+			entry.Response.Cookies = append(entry.Response.Cookies, harNameValue{Name: cookie.Name, Value: cookie.Value})
+		}
+		body, err := res.DecodedBody()
+		if err != nil {
+			body = res.Body
+		}
+		entry.Response.Content = harContent{Size: len(body), MimeType: res.Header.Get("Content-Type")}
+		if utf8.Valid(body) {
+			entry.Response.Content.Text = string(body)
+		} else {
+			entry.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+			entry.Response.Content.Encoding = "base64"
+		}
+	}
+	if f.Error != nil {
+		entry.Comment = f.Error.Error()
+	}
+	entry.Time, entry.Timings = newHARTimings(f.Timings())
+	return entry
+}
+
+// newHARTimings splits the duration of a flow into the HAR phases. The time before the
+// proxy dials the server, to read the request and run the addons, is blocked, and the
+// time before the first response byte, or the end of flows without a response from
+// the server, is wait.
+func newHARTimings(t proxy.Timings) (float64, harTimings) {
+	ms := func(start, end time.Time) float64 {
+		if start.IsZero() || end.IsZero() || end.Before(start) {
+			return 0
+		}
+		return float64(end.Sub(start).Microseconds()) / 1000
+	}
+	timings := harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	ready := t.RequestStart
+	if !t.DialStart.IsZero() && !t.Connected.IsZero() {
+		timings.Blocked = ms(t.RequestStart, t.DialStart)
+		ready = t.Connected
+		if !t.TLSEstablished.IsZero() {
+			timings.SSL = ms(t.Connected, t.TLSEstablished)
+			ready = t.TLSEstablished
+		}
+		timings.Connect = ms(t.DialStart, ready)
+	}
+	if t.FirstResponseByte.IsZero() {
+		timings.Wait = ms(ready, t.End)
+	} else {
+		timings.Wait = ms(ready, t.FirstResponseByte)
+		timings.Receive = ms(t.FirstResponseByte, t.End)
+	}
+	return ms(t.RequestStart, t.End), timings
+}
+
+func harHTTPVersion(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+// harHeaders lists the headers sorted by name, to keep the file stable.
+func harHeaders(header http.Header) []harNameValue {
+	fields := make([]harNameValue, 0, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			fields = append(fields, harNameValue{Name: name, Value: value})
+		}
+	}
+	slices.SortStableFunc(fields, func(a, b harNameValue) int { return strings.Compare(a.Name, b.Name) })
+	return fields
+}
+
+// apiExportHAR downloads the flows selected like the ones of GET /api/flows as a HAR
+// file.
+func (web *WebAddon) apiExportHAR(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAPIFilter(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	flows, err := web.filterFlows(filter)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	data, err := json.MarshalIndent(newHARFile(flows, time.Now()), "", "  ")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="flows.har"`)
+	if _, err := w.Write(data); err != nil {
+		slog.Debug("web api write failed", "error", err)
+	}
+}
@@ -0,0 +1,108 @@
+// This file contains tests for the HAR export of the web addon.
+//
+// Justification:
+// - the export is served by the unexported server of WebAddon, whose listening
+//   address is only known inside the package
+// - flows are added to the unexported flow store as the proxy would once they finish
+//
+// The handler is exercised through the server's mux with httptest.
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+func TestAPIExportHAR(t *testing.T) {
+	c := qt.New(t)
+	web := NewWebAddon("127.0.0.1:0")
+	get := newAPITestFlow(c, "GET", "https://api.example.com/items?page=2&a=b", 200, `{"a":1}`)
+	get.Request.Header.Set("Cookie", "session=abc")
+	get.Response.Header.Set("Set-Cookie", "seen=1; Path=/")
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	get.UpdateTimings(func(t *proxy.Timings) {
+		t.RequestStart = started
+		t.FirstResponseByte = started.Add(20 * time.Millisecond)
+		t.End = started.Add(25 * time.Millisecond)
+	})
+	binary := newAPITestFlow(c, "POST", "https://api.example.com/upload", 201, "\xff\xfe")
+	failed := newAPITestFlow(c, "GET", "http://down.example.org/", 0, "")
+	failed.Error = errors.New("connection refused")
+	for _, f := range []*proxy.Flow{get, binary, failed} {
+		c.Assert(web.flows.Add(f), qt.IsNil)
+	}
+
+	rec := apiRequest(c, web, "GET", "/api/har")
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Disposition"), qt.Equals, `attachment; filename="flows.har"`)
+	var har harFile
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &har), qt.IsNil)
+	c.Assert(har.Log.Version, qt.Equals, "1.2")
+	c.Assert(har.Log.Creator.Name, qt.Equals, "go-mitmproxy")
+	c.Assert(har.Log.Entries, qt.HasLen, 3)
+
+	entry := har.Log.Entries[0]
+	c.Assert(entry.Request.Method, qt.Equals, "GET")
+	c.Assert(entry.Request.URL, qt.Equals, "https://api.example.com/items?page=2&a=b")
+	c.Assert(entry.Request.QueryString, qt.DeepEquals, []harNameValue{{Name: "a", Value: "b"}, {Name: "page", Value: "2"}})
+	c.Assert(entry.Request.Cookies, qt.DeepEquals, []harNameValue{{Name: "session", Value: "abc"}})
+	c.Assert(entry.Request.PostData.Text, qt.Equals, "request {\"a\":1}")
+	c.Assert(entry.Response.Status, qt.Equals, 200)
+	c.Assert(entry.Response.StatusText, qt.Equals, "OK")
+	c.Assert(entry.Response.Cookies, qt.DeepEquals, []harNameValue{{Name: "seen", Value: "1"}})
+	c.Assert(entry.Response.Content, qt.DeepEquals, harContent{Size: 7, MimeType: "application/json", Text: `{"a":1}`})
+	c.Assert(entry.StartedDateTime, qt.Equals, "2024-05-01T12:00:00Z")
+	c.Assert(entry.Time, qt.Equals, 25.0)
+	c.Assert(entry.Timings.Wait, qt.Equals, 20.0)
+
+	c.Assert(har.Log.Entries[1].Response.Content, qt.DeepEquals, harContent{Size: 2, MimeType: "application/json", Text: "//4=", Encoding: "base64"})
+	c.Assert(har.Log.Entries[2].Response.Status, qt.Equals, 0)
+	c.Assert(har.Log.Entries[2].Comment, qt.Equals, "connection refused")
+
+	// the flows are filtered like the ones of the list
+	rec = apiRequest(c, web, "GET", "/api/har?host=*.example.org")
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &har), qt.IsNil)
+	c.Assert(har.Log.Entries, qt.HasLen, 1)
+	c.Assert(har.Log.Entries[0].Request.URL, qt.Equals, "http://down.example.org/")
+	c.Assert(apiRequest(c, web, "GET", "/api/har?status=x").Code, qt.Equals, http.StatusBadRequest)
+}
+
+func TestNewHARTimings(t *testing.T) {
+	c := qt.New(t)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// a flow that dialed the server
+	total, timings := newHARTimings(proxy.Timings{
+		RequestStart:      start,
+		DialStart:         at(2),
+		Connected:         at(12),
+		TLSEstablished:    at(30),
+		FirstResponseByte: at(80),
+		End:               at(100),
+	})
+	c.Assert(total, qt.Equals, 100.0)
+	c.Assert(timings, qt.Equals, harTimings{Blocked: 2, DNS: -1, Connect: 28, SSL: 18, Wait: 50, Receive: 20})
+
+	// a flow sent on an open connection
+	total, timings = newHARTimings(proxy.Timings{
+		RequestStart:      start,
+		FirstResponseByte: at(40),
+		End:               at(45),
+	})
+	c.Assert(total, qt.Equals, 45.0)
+	c.Assert(timings, qt.Equals, harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: 40, Receive: 5})
+
+	// a flow answered by an addon
+	total, timings = newHARTimings(proxy.Timings{RequestStart: start, End: at(3)})
+	c.Assert(total, qt.Equals, 3.0)
+	c.Assert(timings, qt.Equals, harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: 3})
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"slices"
+	"strings"
 
 	uuid "github.com/satori/go.uuid"
 
@@ -14,7 +16,7 @@ import (
 
 // message:
 
-// type: 0/1/2/3/4/5
+// type: 0/1/2/3/4/5/32
 // messageFlow
 // version 1 byte + type 1 byte + id 36 byte + waitIntercept 1 byte + content left bytes
 
@@ -23,9 +25,21 @@ import (
 // version 1 byte + type 1 byte + id 36 byte + header len 4 byte + header content bytes + body len 4 byte + [body content bytes]
 
 // type: 21
-// messageMeta
+// messageMeta, also sent to the other connections when one changes the rules
 // version 1 byte + type 1 byte + content left bytes
 
+// type: 22
+// messageFilter
+// version 1 byte + type 1 byte + filter expression left bytes
+
+// type: 31
+// messageRequestCurl
+// version 1 byte + type 1 byte + id 36 byte
+
+// type: 33
+// messageRequestReplay
+// version 1 byte + type 1 byte + id 36 byte
+
 const messageVersion = 2
 
 type messageType byte
@@ -44,6 +58,12 @@ const (
 	messageTypeDropResponse   messageType = 14
 
 	messageTypeChangeBreakPointRules messageType = 21
+	messageTypeChangeFilter          messageType = 22
+
+	messageTypeRequestCurl messageType = 31
+	messageTypeCurl        messageType = 32
+
+	messageTypeRequestReplay messageType = 33
 )
 
 var allMessageTypes = []messageType{
@@ -58,6 +78,10 @@ var allMessageTypes = []messageType{
 	messageTypeDropRequest,
 	messageTypeDropResponse,
 	messageTypeChangeBreakPointRules,
+	messageTypeChangeFilter,
+	messageTypeRequestCurl,
+	messageTypeCurl,
+	messageTypeRequestReplay,
 }
 
 func validMessageType(t byte) bool {
@@ -92,7 +116,10 @@ func newMessageFlow(mType messageType, f *proxy.Flow) (*messageFlow, error) {
 	case messageTypeRequest:
 		m := make(map[string]any)
 		m["request"] = f.Request
-		m["connId"] = f.ConnContext.ID().String()
+		m["connId"] = ""
+		if f.ConnContext != nil { // stored flows have no connection
+			m["connId"] = f.ConnContext.ID().String()
+		}
 		content, err = json.Marshal(m)
 	case messageTypeRequestBody:
 		content, err = f.Request.DecodedBody()
@@ -101,7 +128,12 @@ func newMessageFlow(mType messageType, f *proxy.Flow) (*messageFlow, error) {
 			err = errors.New("no response")
 			break
 		}
-		content, err = json.Marshal(f.Response)
+		// the timings so far, the flow is not finished yet when its response is sent
+		timings := f.Timings()
+		content, err = json.Marshal(struct {
+			*proxy.Response
+			Timings *proxy.Timings `json:"timings,omitempty"`
+		}{f.Response, &timings})
 	case messageTypeResponseBody:
 		if f.Response == nil {
 			err = errors.New("no response")
@@ -133,6 +165,14 @@ func newMessageConnClose(connCtx *proxy.ConnContext) *messageFlow {
 	}
 }
 
+func newMessageCurl(f *proxy.Flow, proxyURL string) *messageFlow {
+	return &messageFlow{
+		mType:   messageTypeCurl,
+		id:      f.ID,
+		content: []byte(f.Request.ToCurl(proxyURL)),
+	}
+}
+
 func (m *messageFlow) toBytes() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
 	buf.WriteByte(byte(messageVersion))
@@ -265,6 +305,13 @@ func parseMessageMeta(data []byte) *messageMeta {
 	if err != nil {
 		return nil
 	}
+	rules = slices.DeleteFunc(rules, func(rule *breakPointRule) bool {
+		if err := rule.compile(); err != nil {
+			slog.Warn("invalid breakpoint rule, skip", "error", err)
+			return true
+		}
+		return false
+	})
 
 	return &messageMeta{
 		mType:           messageType(data[1]),
@@ -286,6 +333,90 @@ func (m *messageMeta) toBytes() []byte {
 	return buf.Bytes()
 }
 
+// messageFilter changes the filter selecting the flows sent to the connection, an
+// empty expression sends all flows.
+type messageFilter struct {
+	expr   string
+	filter flowFilter // nil for the empty expression
+}
+
+func parseMessageFilter(data []byte) *messageFilter {
+	expr := string(data[2:])
+	msg := &messageFilter{expr: expr}
+	if strings.TrimSpace(expr) == "" {
+		return msg
+	}
+	filter, err := parseFilter(expr)
+	if err != nil {
+		slog.Warn("invalid flow filter", "filter", expr, "error", err)
+		return nil
+	}
+	msg.filter = filter
+	return msg
+}
+
+func (m *messageFilter) toBytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	buf.WriteByte(byte(messageVersion))
+	buf.WriteByte(byte(messageTypeChangeFilter))
+	buf.WriteString(m.expr)
+	return buf.Bytes()
+}
+
+type messageRequestCurl struct {
+	id uuid.UUID
+}
+
+func parseMessageRequestCurl(data []byte) *messageRequestCurl {
+	id, ok := parseMessageFlowID(data)
+	if !ok {
+		return nil
+	}
+	return &messageRequestCurl{id: id}
+}
+
+// parseMessageFlowID parses the id of a message made of the flow ID only.
+func parseMessageFlowID(data []byte) (uuid.UUID, bool) {
+	if len(data) != 38 {
+		return uuid.Nil, false
+	}
+	id, err := uuid.FromString(string(data[2:38]))
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func (m *messageRequestCurl) toBytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	buf.WriteByte(byte(messageVersion))
+	buf.WriteByte(byte(messageTypeRequestCurl))
+	buf.WriteString(m.id.String()) // len: 36
+	return buf.Bytes()
+}
+
+// messageRequestReplay asks to replay the request of a flow, which is then sent like
+// the flows of clients.
+type messageRequestReplay struct {
+	id uuid.UUID
+}
+
+func parseMessageRequestReplay(data []byte) *messageRequestReplay {
+	id, ok := parseMessageFlowID(data)
+	if !ok {
+		return nil
+	}
+	return &messageRequestReplay{id: id}
+}
+
+func (m *messageRequestReplay) toBytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	buf.WriteByte(byte(messageVersion))
+	buf.WriteByte(byte(messageTypeRequestReplay))
+	buf.WriteString(m.id.String()) // len: 36
+	return buf.Bytes()
+}
+
 func parseMessage(data []byte) message {
 	if len(data) < 2 {
 		return nil
@@ -306,6 +437,12 @@ func parseMessage(data []byte) message {
 		return parseMessageEdit(data)
 	case messageTypeChangeBreakPointRules:
 		return parseMessageMeta(data)
+	case messageTypeChangeFilter:
+		return parseMessageFilter(data)
+	case messageTypeRequestCurl:
+		return parseMessageRequestCurl(data)
+	case messageTypeRequestReplay:
+		return parseMessageRequestReplay(data)
 	default:
 		slog.Warn("invalid message type", "type", mType)
 		return nil
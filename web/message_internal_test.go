@@ -2,7 +2,8 @@
 //
 // Justification:
 // - validMessageType: validates binary protocol message types
-// - parseMessageEdit, parseMessageMeta: parse binary websocket messages
+// - parseMessageEdit, parseMessageMeta, parseMessageRequestCurl, parseMessageRequestReplay:
+//   parse binary websocket messages
 // - messageFlow.toBytes, messageEdit.toBytes: serialize messages to wire format
 //
 // These are core protocol parsing functions that define the websocket communication
@@ -13,6 +14,8 @@ package web
 
 import (
 	"encoding/binary"
+	"net/http"
+	"net/url"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -131,6 +134,23 @@ func TestParseMetaMessageExtractsBreakpointRules(t *testing.T) {
 	c.Assert(msg.breakPointRules[0].Action, qt.Equals, 3)
 }
 
+func TestParseMetaMessageCompilesBreakpointRules(t *testing.T) {
+	c := qt.New(t)
+
+	rulesJSON := []byte(`[{"urlRegex":"^https://","headers":[{"name":"X-Debug","value":"1"}],"status":"5xx","action":2},{"urlRegex":"(","action":1}]`)
+	data := append([]byte{messageVersion, byte(messageTypeChangeBreakPointRules)}, rulesJSON...)
+
+	msg := parseMessageMeta(data)
+
+	// the rule with an invalid regex is dropped
+	c.Assert(msg, qt.IsNotNil)
+	c.Assert(msg.breakPointRules, qt.HasLen, 1)
+	rule := msg.breakPointRules[0]
+	c.Assert(rule.urlRegex.String(), qt.Equals, "^https://")
+	c.Assert(rule.Headers[0].value.String(), qt.Equals, "1")
+	c.Assert(rule.status, qt.Equals, 5)
+}
+
 func TestNewMessageConnCloseEncodesFlowCount(t *testing.T) {
 	c := qt.New(t)
 
@@ -148,3 +168,43 @@ func TestNewMessageConnCloseEncodesFlowCount(t *testing.T) {
 	flowCount := binary.BigEndian.Uint32(msg.content)
 	c.Assert(flowCount, qt.Equals, uint32(42))
 }
+
+func TestParseMessageRequestCurl(t *testing.T) {
+	c := qt.New(t)
+
+	id := uuid.NewV4()
+	msg, ok := parseMessage((&messageRequestCurl{id: id}).toBytes()).(*messageRequestCurl)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(msg.id, qt.Equals, id)
+
+	// trailing content is not part of the format
+	data := append((&messageRequestCurl{id: id}).toBytes(), 'x')
+	c.Assert(parseMessageRequestCurl(data), qt.IsNil)
+}
+
+func TestParseMessageRequestReplay(t *testing.T) {
+	c := qt.New(t)
+
+	id := uuid.NewV4()
+	msg, ok := parseMessage((&messageRequestReplay{id: id}).toBytes()).(*messageRequestReplay)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(msg.id, qt.Equals, id)
+
+	data := (&messageRequestReplay{id: id}).toBytes()
+	c.Assert(parseMessageRequestReplay(data[:37]), qt.IsNil)
+}
+
+func TestNewMessageCurlCarriesCommand(t *testing.T) {
+	c := qt.New(t)
+
+	u, err := url.Parse("https://example.com/")
+	c.Assert(err, qt.IsNil)
+	f := proxy.NewFlow()
+	f.Request = &proxy.Request{Method: http.MethodGet, URL: u, Header: make(http.Header)}
+
+	msg := newMessageCurl(f, "http://127.0.0.1:9080")
+
+	c.Assert(msg.mType, qt.Equals, messageTypeCurl)
+	c.Assert(msg.id, qt.Equals, f.ID)
+	c.Assert(string(msg.content), qt.Equals, `curl --proxy 'http://127.0.0.1:9080' --insecure 'https://example.com/'`)
+}
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+// maxStoredFlows bounds how many finished flows the memory store keeps by default.
+const maxStoredFlows = 1000
+
+// FlowStore keeps the finished flows shown by the web interface and its REST API, in
+// the order they are added. Its methods are called concurrently.
+type FlowStore interface {
+	// Add stores a finished flow. Adding a stored flow again does nothing.
+	Add(f *proxy.Flow) error
+	// Get returns the flow with id, nil if there is none.
+	Get(id uuid.UUID) (*proxy.Flow, error)
+	// Range calls fn with the flows from the newest to the oldest until fn returns
+	// false. fn must not call the store.
+	Range(fn func(f *proxy.Flow) bool) error
+	// Delete deletes the flow with id and reports whether there was one.
+	Delete(id uuid.UUID) (bool, error)
+	// Clear deletes all flows.
+	Clear() error
+	// Close releases the store.
+	Close() error
+}
+
+// memoryFlowStore keeps the most recent flows in memory. Once it is full, the least
+// recently added or read flows are evicted first.
+type memoryFlowStore struct {
+	mu        sync.Mutex
+	limit     int
+	maxMemory int64      // bound of size, zero for none
+	size      int64      // of the bodies of the flows
+	order     *list.List // of *memoryFlow, oldest first
+	recent    *list.List // of *memoryFlow, least recently used first
+	byID      map[uuid.UUID]*memoryFlow
+}
+
+type memoryFlow struct {
+	flow   *proxy.Flow
+	size   int64
+	order  *list.Element
+	recent *list.Element
+}
+
+// NewMemoryFlowStore creates a FlowStore keeping the last limit flows in memory, the
+// store of NewWebAddon. Zero means 1000 flows.
+func NewMemoryFlowStore(limit int) FlowStore {
+	return NewMemoryFlowStoreWithLimits(limit, 0)
+}
+
+// NewMemoryFlowStoreWithLimits creates a FlowStore keeping up to limit flows whose
+// bodies take up to maxMemory bytes in memory, evicting the least recently added or
+// read flows first. Zero means 1000 flows, and no bound of the memory.
+func NewMemoryFlowStoreWithLimits(limit int, maxMemory int64) FlowStore {
+	if limit <= 0 {
+		limit = maxStoredFlows
+	}
+	return &memoryFlowStore{
+		limit:     limit,
+		maxMemory: maxMemory,
+		order:     list.New(),
+		recent:    list.New(),
+		byID:      make(map[uuid.UUID]*memoryFlow),
+	}
+}
+
+func flowBodySize(f *proxy.Flow) int64 {
+	size := int64(len(f.Request.Body))
+	if f.Response != nil {
+		size += int64(len(f.Response.Body))
+	}
+	return size
+}
+
+func (s *memoryFlowStore) Add(f *proxy.Flow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[f.ID]; ok {
+		return nil
+	}
+	mf := &memoryFlow{flow: f, size: flowBodySize(f)}
+	mf.order = s.order.PushBack(mf)
+	mf.recent = s.recent.PushBack(mf)
+	s.byID[f.ID] = mf
+	s.size += mf.size
+	// the flow just added is kept even if it is larger than maxMemory alone
+	for s.recent.Len() > 1 && (len(s.byID) > s.limit || (s.maxMemory > 0 && s.size > s.maxMemory)) {
+		s.remove(s.recent.Front().Value.(*memoryFlow))
+	}
+	return nil
+}
+
+func (s *memoryFlowStore) remove(mf *memoryFlow) {
+	s.order.Remove(mf.order)
+	s.recent.Remove(mf.recent)
+	delete(s.byID, mf.flow.ID)
+	s.size -= mf.size
+}
+
+func (s *memoryFlowStore) Get(id uuid.UUID) (*proxy.Flow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mf, ok := s.byID[id]
+	if !ok {
+		return nil, nil
+	}
+	s.recent.MoveToBack(mf.recent)
+	return mf.flow, nil
+}
+
+func (s *memoryFlowStore) Range(fn func(f *proxy.Flow) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for e := s.order.Back(); e != nil; e = e.Prev() {
+		if !fn(e.Value.(*memoryFlow).flow) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryFlowStore) Delete(id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mf, ok := s.byID[id]
+	if !ok {
+		return false, nil
+	}
+	s.remove(mf)
+	return true, nil
+}
+
+func (s *memoryFlowStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.order.Init()
+	s.recent.Init()
+	clear(s.byID)
+	s.size = 0
+	return nil
+}
+
+func (s *memoryFlowStore) Close() error {
+	return nil
+}
+
+// truncateFlow returns f with its bodies truncated to maxBodySize bytes, a copy if one
+// is longer. Encoded bodies are decoded first, as truncating them would break them.
+func truncateFlow(f *proxy.Flow, maxBodySize int) *proxy.Flow {
+	if maxBodySize <= 0 || (len(f.Request.Body) <= maxBodySize && (f.Response == nil || len(f.Response.Body) <= maxBodySize)) {
+		return f
+	}
+	truncated := proxy.NewFlow()
+	truncated.ID = f.ID
+	truncated.ConnContext = f.ConnContext
+	truncated.IsReplay = f.IsReplay
+	truncated.TraceID = f.TraceID
+	truncated.Error = f.Error
+	for k, v := range f.Metadata.All() {
+		truncated.Metadata.Set(k, v)
+	}
+	timings := f.Timings()
+	truncated.UpdateTimings(func(t *proxy.Timings) { *t = timings })
+	request := *f.Request
+	request.Header, request.Body = truncateBody(f.Request.Header, f.Request.Body, f.Request.DecodedBody, maxBodySize)
+	truncated.Request = &request
+	if f.Response != nil {
+		response := *f.Response
+		response.Header, response.Body = truncateBody(f.Response.Header, f.Response.Body, f.Response.DecodedBody, maxBodySize)
+		truncated.Response = &response
+	}
+	truncated.Finish()
+	return truncated
+}
+
+func truncateBody(header http.Header, body []byte, decode func() ([]byte, error), maxBodySize int) (http.Header, []byte) {
+	if len(body) <= maxBodySize {
+		return header, body
+	}
+	if header.Get("Content-Encoding") != "" {
+		if decoded, err := decode(); err == nil {
+			header = header.Clone()
+			header.Del("Content-Encoding")
+			body = decoded
+		}
+	}
+	if len(body) <= maxBodySize {
+		return header, body
+	}
+	// copy, so the whole body is not kept in memory
+	return header, bytes.Clone(body[:maxBodySize])
+}
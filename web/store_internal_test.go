@@ -0,0 +1,129 @@
+// This file contains tests for the retention limits of the web addon.
+//
+// Justification:
+// - truncateFlow and the truncation of the messages of concurrentConn are unexported
+// - the eviction order of the memory store is only observable through its flows
+//
+// Flows are built directly, as the proxy would have finished them.
+
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/proxy"
+)
+
+func storedIDs(c *qt.C, store FlowStore) []string {
+	c.Helper()
+	var ids []string
+	c.Assert(store.Range(func(f *proxy.Flow) bool {
+		ids = append(ids, f.ID.String())
+		return true
+	}), qt.IsNil)
+	return ids
+}
+
+func TestMemoryFlowStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	c := qt.New(t)
+	store := NewMemoryFlowStoreWithLimits(3, 0)
+	a := newAPITestFlow(c, "GET", "http://example.com/a", 200, "")
+	b := newAPITestFlow(c, "GET", "http://example.com/b", 200, "")
+	d := newAPITestFlow(c, "GET", "http://example.com/d", 200, "")
+	e := newAPITestFlow(c, "GET", "http://example.com/e", 200, "")
+	for _, f := range []*proxy.Flow{a, b, d} {
+		c.Assert(store.Add(f), qt.IsNil)
+	}
+	// reading a keeps it, b is evicted instead
+	f, err := store.Get(a.ID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(f, qt.Equals, a)
+	c.Assert(store.Add(e), qt.IsNil)
+	// the flows stay in the order they were added
+	c.Assert(storedIDs(c, store), qt.DeepEquals, []string{e.ID.String(), d.ID.String(), a.ID.String()})
+}
+
+func TestMemoryFlowStoreMaxMemory(t *testing.T) {
+	c := qt.New(t)
+	store := NewMemoryFlowStoreWithLimits(0, 150)
+	// the flows take 48, 68 and 408 bytes: the request body is "request " and the response body
+	small := newAPITestFlow(c, "GET", "http://example.com/small", 200, strings.Repeat("s", 20))
+	medium := newAPITestFlow(c, "GET", "http://example.com/medium", 200, strings.Repeat("m", 30))
+	large := newAPITestFlow(c, "GET", "http://example.com/large", 200, strings.Repeat("l", 200))
+	c.Assert(store.Add(small), qt.IsNil)
+	c.Assert(store.Add(medium), qt.IsNil)
+	c.Assert(storedIDs(c, store), qt.DeepEquals, []string{medium.ID.String(), small.ID.String()})
+
+	// the oldest flows make room, a flow larger than the bound is kept alone
+	c.Assert(store.Add(large), qt.IsNil)
+	c.Assert(storedIDs(c, store), qt.DeepEquals, []string{large.ID.String()})
+	c.Assert(store.Add(small), qt.IsNil)
+	c.Assert(storedIDs(c, store), qt.DeepEquals, []string{small.ID.String()})
+
+	deleted, err := store.Delete(small.ID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(deleted, qt.IsTrue)
+	c.Assert(store.Add(medium), qt.IsNil)
+	c.Assert(store.Add(small), qt.IsNil)
+	c.Assert(storedIDs(c, store), qt.DeepEquals, []string{small.ID.String(), medium.ID.String()})
+}
+
+func TestTruncateFlow(t *testing.T) {
+	c := qt.New(t)
+	f := newAPITestFlow(c, "POST", "http://example.com/", 200, "")
+	c.Assert(truncateFlow(f, 0), qt.Equals, f)
+	c.Assert(truncateFlow(f, 100), qt.Equals, f)
+
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	_, err := w.Write([]byte(strings.Repeat("0123456789", 100)))
+	c.Assert(err, qt.IsNil)
+	c.Assert(w.Close(), qt.IsNil)
+	f.Request.Body = []byte(strings.Repeat("x", 50))
+	f.Response.Header.Set("Content-Encoding", "gzip")
+	f.Response.Body = gzipped.Bytes()
+	original := f.Response.Body
+
+	truncated := truncateFlow(f, 25)
+	c.Assert(truncated, qt.Not(qt.Equals), f)
+	c.Assert(truncated.ID, qt.Equals, f.ID)
+	c.Assert(string(truncated.Request.Body), qt.Equals, strings.Repeat("x", 25))
+	c.Assert(string(truncated.Response.Body), qt.Equals, "0123456789012345678901234")
+	c.Assert(truncated.Response.Header.Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(truncated.Response.StatusCode, qt.Equals, 200)
+	select {
+	case <-truncated.Done():
+	default:
+		c.Fatal("the truncated flow is not finished")
+	}
+
+	// the flow itself is left alone
+	c.Assert(len(f.Request.Body), qt.Equals, 50)
+	c.Assert(f.Response.Body, qt.DeepEquals, original)
+	c.Assert(f.Response.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+}
+
+func TestConnTruncatesBodies(t *testing.T) {
+	c := qt.New(t)
+	conn := &concurrentConn{maxBodySize: 4}
+	f := newAPITestFlow(c, "GET", "http://example.com/", http.StatusOK, "truncated")
+
+	msg, err := newMessageFlow(messageTypeResponseBody, f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.messageBytes(msg)[39:], qt.DeepEquals, []byte("trun"))
+	c.Assert(string(msg.content), qt.Equals, "truncated")
+
+	// bodies waiting for an edit are sent whole
+	msg.waitIntercept = 1
+	c.Assert(conn.messageBytes(msg)[39:], qt.DeepEquals, []byte("truncated"))
+
+	msg, err = newMessageFlow(messageTypeResponse, f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.messageBytes(msg), qt.DeepEquals, msg.toBytes())
+}
@@ -0,0 +1,38 @@
+package web
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// tlsConfig returns the TLS config serving the web interface with the certificate of
+// the options, nil if it is served in plaintext.
+func (opts *Options) tlsConfig() *tls.Config {
+	if opts.TLSCertificate != nil {
+		return &tls.Config{
+			Certificates: []tls.Certificate{*opts.TLSCertificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+	if opts.CA == nil {
+		return nil
+	}
+	ca := opts.CA
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// clients connecting to an IP address send no server name
+			name := hello.ServerName
+			if name == "" && hello.Conn != nil {
+				if host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String()); err == nil {
+					name = host
+				}
+			}
+			if name == "" {
+				return nil, errors.New("no server name")
+			}
+			return ca.GetCert(name)
+		},
+	}
+}
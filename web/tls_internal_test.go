@@ -0,0 +1,68 @@
+// This file contains tests for serving the web interface over HTTPS.
+//
+// Justification:
+// - the TLS config is built by the unexported tlsConfig, and the listening address of
+//   the server of WebAddon is only known inside the package
+//
+// The config is served with httptest and checked with a client trusting the CA.
+
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/denisvmedia/go-mitmproxy/cert"
+)
+
+func TestTLSConfigWithCA(t *testing.T) {
+	c := qt.New(t)
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	opts := Options{CA: ca}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	// StartTLS would add a certificate of its own
+	server.Listener = tls.NewListener(server.Listener, opts.tlsConfig())
+	server.Start()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.GetRootCA())
+	for _, serverName := range []string{"", "mitm.example.com"} {
+		c.Run("server name "+serverName, func(c *qt.C) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: serverName},
+			}}
+			res, err := client.Get("https://" + server.Listener.Addr().String())
+			c.Assert(err, qt.IsNil)
+			defer res.Body.Close()
+			c.Assert(res.StatusCode, qt.Equals, http.StatusOK)
+			want := serverName
+			if want == "" {
+				want = "127.0.0.1"
+			}
+			c.Assert(res.TLS.PeerCertificates[0].Subject.CommonName, qt.Equals, want)
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	c := qt.New(t)
+	c.Assert((&Options{}).tlsConfig(), qt.IsNil)
+
+	ca, err := cert.NewSelfSignCAMemory()
+	c.Assert(err, qt.IsNil)
+	certificate, err := ca.GetCert("localhost")
+	c.Assert(err, qt.IsNil)
+	config := (&Options{TLSCertificate: certificate, CA: ca}).tlsConfig()
+	c.Assert(config.Certificates, qt.HasLen, 1)
+	c.Assert(config.GetCertificate, qt.IsNil)
+}
@@ -1,36 +1,110 @@
 package web
 
 import (
+	"context"
+	"crypto/tls"
 	"embed"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 
+	"github.com/golang/groupcache/lru"
 	"github.com/gorilla/websocket"
+	uuid "github.com/satori/go.uuid"
 
+	"github.com/denisvmedia/go-mitmproxy/cert"
 	"github.com/denisvmedia/go-mitmproxy/proxy"
 )
 
 //go:embed client/build
 var assets embed.FS
 
+// maxRecentFlows bounds how many unfinished flows the web interface can request curl
+// commands for.
+const maxRecentFlows = 1000
+
 type WebAddon struct {
 	proxy.BaseAddon
 
 	server   *http.Server
 	upgrader *websocket.Upgrader
 
-	conns   []*concurrentConn
-	connsMu sync.RWMutex
+	broker *broker // the connections of the web interface
 
 	flowMessageState map[*proxy.Flow]messageType
+	recentFlows      *lru.Cache // flow ID -> *proxy.Flow, until it is stored
 	flowMu           sync.Mutex
+
+	flows   FlowStore                   // finished flows
+	running atomic.Pointer[proxy.Proxy] // the proxy while it runs, for replays
+
+	curlProxyURL string
+	maxBodySize  int
 }
 
+// Options configures a WebAddon.
+type Options struct {
+	Addr string // listen addr, e.g. 127.0.0.1:9081 to only accept local connections
+
+	// Store keeps the finished flows, in memory within MaxFlows and MaxMemory if nil.
+	// It is closed when the proxy is done.
+	Store FlowStore
+	// MaxFlows bounds how many flows the memory store keeps, 1000 if zero.
+	MaxFlows int
+	// MaxMemory bounds the size of the bodies the memory store keeps, in bytes. The
+	// least recently added or read flows are evicted first. Zero means no bound.
+	MaxMemory int64
+	// MaxBodySize truncates the bodies stored and sent to the web interface to its
+	// size, in bytes, except the bodies stopped at a breakpoint. Zero means no
+	// truncation.
+	MaxBodySize int
+
+	// Username and Password require HTTP basic authentication.
+	Username string
+	Password string
+	// Token requires a bearer token, passed in the Authorization header, as the token
+	// query parameter or in the cookie set once the browser passed it.
+	Token string
+
+	// TLSCertificate serves the web interface over HTTPS, and its websocket over wss,
+	// e.g. with a certificate loaded by tls.LoadX509KeyPair.
+	TLSCertificate *tls.Certificate
+	// CA serves the web interface over HTTPS with certificates for the requested host
+	// signed by CA, e.g. the CA of the proxy, when TLSCertificate is nil.
+	CA cert.CA
+}
+
+// NewWebAddon creates a WebAddon serving the web interface at addr, which keeps the
+// last 1000 flows in memory.
 func NewWebAddon(addr string) *WebAddon {
+	return NewWebAddonWithOptions(Options{Addr: addr})
+}
+
+// NewWebAddonWithStore creates a WebAddon keeping the finished flows in store, e.g. one
+// created with NewBoltFlowStore so they survive restarts. The store is closed when the
+// proxy is done.
+func NewWebAddonWithStore(addr string, store FlowStore) *WebAddon {
+	return NewWebAddonWithOptions(Options{Addr: addr, Store: store})
+}
+
+// NewWebAddonWithOptions creates a WebAddon configured by opts. Without Username or
+// Token everybody reaching Addr sees the traffic, which is only logged as a warning
+// when Addr is not a loopback address.
+func NewWebAddonWithOptions(opts Options) *WebAddon {
+	addr := opts.Addr
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryFlowStoreWithLimits(opts.MaxFlows, opts.MaxMemory)
+	}
 	web := &WebAddon{
+		broker:           newBroker(),
 		flowMessageState: make(map[*proxy.Flow]messageType),
+		recentFlows:      lru.New(maxRecentFlows),
+		flows:            store,
+		maxBodySize:      opts.MaxBodySize,
 	}
 
 	web.upgrader = &websocket.Upgrader{
@@ -38,9 +112,16 @@ func NewWebAddon(addr string) *WebAddon {
 			return true
 		},
 	}
+	if opts.authRequired() {
+		// keep other sites from using the credentials of the browser
+		web.upgrader.CheckOrigin = nil
+	} else if !isLoopbackAddr(addr) {
+		slog.Warn("web interface exposes the traffic without authentication", "addr", addr)
+	}
 
 	serverMux := new(http.ServeMux)
 	serverMux.HandleFunc("/echo", web.echo)
+	web.handleAPI(serverMux)
 
 	fsys, err := fs.Sub(assets, "client/build")
 	if err != nil {
@@ -48,12 +129,22 @@ func NewWebAddon(addr string) *WebAddon {
 	}
 	serverMux.Handle("/", http.FileServer(http.FS(fsys)))
 
-	web.server = &http.Server{Addr: addr, Handler: serverMux}
-	web.conns = make([]*concurrentConn, 0)
+	tlsConfig := opts.tlsConfig()
+	var handler http.Handler = serverMux
+	if opts.authRequired() {
+		handler = &authHandler{username: opts.Username, password: opts.Password, token: opts.Token, secure: tlsConfig != nil, next: serverMux}
+	}
+	web.server = &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
 
 	go func() {
-		slog.Info("web interface listening", "addr", addr)
-		if err := web.server.ListenAndServe(); err != nil {
+		slog.Info("web interface listening", "addr", addr, "tls", web.server.TLSConfig != nil)
+		var err error
+		if web.server.TLSConfig != nil {
+			err = web.server.ListenAndServeTLS("", "")
+		} else {
+			err = web.server.ListenAndServe()
+		}
+		if err != nil {
 			slog.Error("web interface stopped", "error", err)
 		}
 	}()
@@ -69,94 +160,137 @@ func (web *WebAddon) echo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	conn := newConn(c)
-	web.addConn(conn)
+	conn.broker = web.broker
+	conn.curlMessage = web.curlMessage
+	conn.replay = web.replay
+	conn.maxBodySize = web.maxBodySize
+	web.sendStoredFlows(conn)
+	web.broker.addConn(conn)
 	defer func() {
-		web.removeConn(conn)
+		web.broker.removeConn(conn)
 		c.Close()
 	}()
 
 	conn.readloop()
 }
 
-func (web *WebAddon) addConn(c *concurrentConn) {
-	web.connsMu.Lock()
-	web.conns = append(web.conns, c)
-	web.connsMu.Unlock()
+// Running implements proxy.LifecycleAddon, the REST API replays flows with p.
+func (web *WebAddon) Running(p *proxy.Proxy) {
+	web.running.Store(p)
 }
 
-func (web *WebAddon) removeConn(conn *concurrentConn) {
-	web.connsMu.Lock()
-	defer web.connsMu.Unlock()
-
-	index := -1
-	for i, c := range web.conns {
-		if conn == c {
-			index = i
-			break
-		}
+// Done implements proxy.LifecycleAddon, it closes the flow store.
+func (web *WebAddon) Done() {
+	web.running.Store(nil)
+	if err := web.flows.Close(); err != nil {
+		slog.Warn("web store close failed", "error", err)
 	}
+}
 
-	if index == -1 {
-		return
-	}
-	web.conns = append(web.conns[:index], web.conns[index+1:]...)
+// SetCurlProxy makes the curl commands copied from the web interface send their
+// requests through the proxy at proxyURL, e.g. http://127.0.0.1:9080.
+func (web *WebAddon) SetCurlProxy(proxyURL string) {
+	web.curlProxyURL = proxyURL
 }
 
-func (web *WebAddon) forEachConn(do func(c *concurrentConn)) {
-	web.connsMu.RLock()
-	conns := web.conns
-	web.connsMu.RUnlock()
-	for _, c := range conns {
-		do(c)
+func (web *WebAddon) curlMessage(id uuid.UUID) *messageFlow {
+	f := web.flow(id)
+	if f == nil {
+		return nil
 	}
+	return newMessageCurl(f, web.curlProxyURL)
 }
 
-func (web *WebAddon) sendFlowMayWait(f *proxy.Flow, msgFn func() (*messageFlow, error)) {
-	web.connsMu.RLock()
-	conns := web.conns
-	web.connsMu.RUnlock()
+// flow returns a recent or stored flow, nil if it is unknown.
+func (web *WebAddon) flow(id uuid.UUID) *proxy.Flow {
+	web.flowMu.Lock()
+	v, ok := web.recentFlows.Get(id)
+	web.flowMu.Unlock()
+	if ok {
+		return v.(*proxy.Flow)
+	}
+	// flows sent from the store on connecting are not recent
+	f, err := web.flows.Get(id)
+	if err != nil {
+		slog.Warn("web store read failed", "error", err)
+		return nil
+	}
+	return f
+}
 
-	if len(conns) == 0 {
+// replay replays the request of a flow through the running proxy. The new flow goes
+// through the addons, so it reaches the connections like the flows of clients.
+func (web *WebAddon) replay(id uuid.UUID) {
+	f := web.flow(id)
+	if f == nil {
+		slog.Warn("web replay of unknown flow", "id", id)
 		return
 	}
+	p := web.running.Load()
+	if p == nil {
+		slog.Warn("web replay while the proxy is not running", "id", id)
+		return
+	}
+	go func() {
+		if _, err := p.ReplayFlow(context.Background(), f); err != nil {
+			slog.Warn("web replay failed", "id", id, "error", err)
+		}
+	}()
+}
 
-	msg, err := msgFn()
+// sendStoredFlows sends the most recent stored flows to a new connection, so the web
+// interface resumes the capture after reconnecting or restarting.
+func (web *WebAddon) sendStoredFlows(c *concurrentConn) {
+	flows := make([]*proxy.Flow, 0)
+	err := web.flows.Range(func(f *proxy.Flow) bool {
+		flows = append(flows, f)
+		return len(flows) < maxRecentFlows
+	})
 	if err != nil {
-		slog.Error("web addon gen msg failed", "error", err)
-		return
+		slog.Warn("web store read failed", "error", err)
 	}
-	for _, c := range conns {
-		c.writeMessageMayWait(msg, f)
+	for _, f := range slices.Backward(flows) {
+		for _, mType := range []messageType{messageTypeRequest, messageTypeRequestBody, messageTypeResponse, messageTypeResponseBody} {
+			msg, err := newMessageFlow(mType, f)
+			if err != nil {
+				continue
+			}
+			c.writeMessage(msg)
+		}
 	}
 }
 
 func (web *WebAddon) Requestheaders(f *proxy.Flow) {
 	web.flowMu.Lock()
 	web.flowMessageState[f] = messageType(0)
+	web.recentFlows.Add(f.ID, f)
 	web.flowMu.Unlock()
 
 	go func() {
 		<-f.Done()
 		web.sendMessageUntil(f, messageTypeResponseBody)
 
+		if err := web.flows.Add(truncateFlow(f, web.maxBodySize)); err != nil {
+			slog.Warn("web store flow failed", "error", err)
+		}
+		// the store has the flow now, possibly truncated
 		web.flowMu.Lock()
 		delete(web.flowMessageState, f)
+		web.recentFlows.Remove(f.ID)
 		web.flowMu.Unlock()
 	}()
 
 	if f.ConnContext.ClientConn.TLS {
-		web.forEachConn(func(c *concurrentConn) {
-			c.trySendConnMessage(f)
-		})
+		web.broker.trySendConnMessage(f)
 	}
 }
 
 func (web *WebAddon) Request(f *proxy.Flow) {
-	if web.isIntercpt(f, messageTypeRequestBody) {
-		web.sendFlowMayWait(f, func() (*messageFlow, error) {
+	if web.broker.isIntercpt(f, messageTypeRequestBody) {
+		web.broker.sendFlowMayWait(f, func() (*messageFlow, error) {
 			return newMessageFlow(messageTypeRequest, f)
 		})
-		web.sendFlowMayWait(f, func() (*messageFlow, error) {
+		web.broker.sendFlowMayWait(f, func() (*messageFlow, error) {
 			return newMessageFlow(messageTypeRequestBody, f)
 		})
 	}
@@ -164,65 +298,25 @@ func (web *WebAddon) Request(f *proxy.Flow) {
 
 func (web *WebAddon) Responseheaders(f *proxy.Flow) {
 	if !f.ConnContext.ClientConn.TLS {
-		web.forEachConn(func(c *concurrentConn) {
-			c.trySendConnMessage(f)
-		})
+		web.broker.trySendConnMessage(f)
 	}
 
 	web.sendMessageUntil(f, messageTypeRequestBody)
 }
 
 func (web *WebAddon) Response(f *proxy.Flow) {
-	if web.isIntercpt(f, messageTypeResponseBody) {
-		web.sendFlowMayWait(f, func() (*messageFlow, error) {
+	if web.broker.isIntercpt(f, messageTypeResponseBody) {
+		web.broker.sendFlowMayWait(f, func() (*messageFlow, error) {
 			return newMessageFlow(messageTypeResponse, f)
 		})
-		web.sendFlowMayWait(f, func() (*messageFlow, error) {
+		web.broker.sendFlowMayWait(f, func() (*messageFlow, error) {
 			return newMessageFlow(messageTypeResponseBody, f)
 		})
 	}
 }
 
 func (web *WebAddon) ServerDisconnected(connCtx *proxy.ConnContext) {
-	web.forEachConn(func(c *concurrentConn) {
-		c.whenConnClose(connCtx)
-	})
-}
-
-func (web *WebAddon) isIntercpt(f *proxy.Flow, mType messageType) bool {
-	web.connsMu.RLock()
-	conns := web.conns
-	web.connsMu.RUnlock()
-
-	if len(conns) == 0 {
-		return false
-	}
-
-	for _, c := range conns {
-		if c.isIntercpt(f, mType) {
-			return true
-		}
-	}
-	return false
-}
-
-func (web *WebAddon) sendFlow(msgFn func() (*messageFlow, error)) {
-	web.connsMu.RLock()
-	conns := web.conns
-	web.connsMu.RUnlock()
-
-	if len(conns) == 0 {
-		return
-	}
-
-	msg, err := msgFn()
-	if err != nil {
-		slog.Error("web addon gen msg failed", "error", err)
-		return
-	}
-	for _, c := range conns {
-		c.writeMessage(msg)
-	}
+	web.broker.whenConnClose(connCtx)
 }
 
 func (web *WebAddon) sendMessageUntil(f *proxy.Flow, mType messageType) {
@@ -235,7 +329,7 @@ func (web *WebAddon) sendMessageUntil(f *proxy.Flow, mType messageType) {
 	web.flowMu.Unlock()
 
 	for ; state <= mType; state++ {
-		web.sendFlow(func() (*messageFlow, error) {
+		web.broker.sendFlow(f, func() (*messageFlow, error) {
 			return newMessageFlow(state, f)
 		})
 	}